@@ -0,0 +1,34 @@
+package socks5
+
+import (
+	"net"
+)
+
+// AcceptFilter filters the accepted connections purely from the addresses,
+// the network of the addresses and the local and remote endpoints,
+// before any goroutine or buffer is allocated for them.
+type AcceptFilter interface {
+	// Accept reports whether to serve the connection, the tag is attached
+	// to the Request and the Session of the connection.
+	Accept(local, remote net.Addr) (tag string, ok bool)
+}
+
+// AcceptFilterFunc is an adapter to allow the use of ordinary functions as AcceptFilter
+type AcceptFilterFunc func(local, remote net.Addr) (tag string, ok bool)
+
+// Accept implement interface AcceptFilter
+func (f AcceptFilterFunc) Accept(local, remote net.Addr) (string, bool) {
+	return f(local, remote)
+}
+
+// acceptConn applies the accept filter, the connection rejected is closed.
+func (sf *Server) acceptConn(conn net.Conn) (string, bool) {
+	if sf.acceptFilter == nil {
+		return "", true
+	}
+	tag, ok := sf.acceptFilter.Accept(conn.LocalAddr(), conn.RemoteAddr())
+	if !ok {
+		conn.Close()
+	}
+	return tag, ok
+}
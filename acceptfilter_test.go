@@ -0,0 +1,46 @@
+package socks5
+
+import (
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer_AcceptFilter(t *testing.T) {
+	target := echoTarget(t)
+	var accepted int32
+	closed := make(chan Session, 1)
+	srv := NewServer(
+		WithAcceptFilter(AcceptFilterFunc(func(local, remote net.Addr) (string, bool) {
+			require.Equal(t, "tcp", remote.Network())
+			return "office", atomic.AddInt32(&accepted, 1) > 1
+		})),
+		WithSessionCloseHandle(func(s Session, err error) { closed <- s }),
+	)
+	proxy, _ := startServer(t, srv)
+	defer srv.Close()
+
+	// the first connection is rejected
+	conn, err := net.Dial("tcp", proxy.String())
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(time.Second)) // nolint: errcheck
+	_, err = conn.Read(make([]byte, 1))
+	require.Equal(t, io.EOF, err)
+	require.Empty(t, srv.Sessions())
+
+	// the second connection is tagged
+	conn = relaySession(t, proxy, target)
+	require.Equal(t, "office", srv.Sessions()[0].Tag)
+	conn.Close()
+	select {
+	case s := <-closed:
+		require.Equal(t, "office", s.Tag)
+	case <-time.After(time.Second):
+		t.Fatal("session close not notified")
+	}
+}
@@ -0,0 +1,51 @@
+package socks5
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// listenFdsStart is the first file descriptor passed by the systemd socket activation
+const listenFdsStart = 3
+
+// ActivationListeners returns the listeners passed by the systemd socket activation in the order
+// of the sockets of the unit, nil if the process is not activated, serve them by ServeListeners.
+// The environment of the activation is unset, so the child processes do not inherit it.
+func ActivationListeners() ([]net.Listener, error) {
+	defer func() {
+		os.Unsetenv("LISTEN_PID")     // nolint: errcheck
+		os.Unsetenv("LISTEN_FDS")     // nolint: errcheck
+		os.Unsetenv("LISTEN_FDNAMES") // nolint: errcheck
+	}()
+	if pid, err := strconv.Atoi(os.Getenv("LISTEN_PID")); err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n <= 0 {
+		return nil, nil
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	ls := make([]net.Listener, 0, n)
+	for i := 0; i < n; i++ {
+		fd := listenFdsStart + i
+		name := "LISTEN_FD_" + strconv.Itoa(fd)
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+		// the listener dups the file descriptor, which is closed then
+		f := os.NewFile(uintptr(fd), name)
+		l, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			for _, l := range ls {
+				l.Close()
+			}
+			return nil, fmt.Errorf("socket activation %s, %v", name, err)
+		}
+		ls = append(ls, l)
+	}
+	return ls, nil
+}
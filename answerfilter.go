@@ -0,0 +1,142 @@
+package socks5
+
+import (
+	"context"
+	"errors"
+	"net"
+
+	"github.com/thinkgos/go-socks5/statute"
+)
+
+// AnswerFilter inspects and filters the addresses resolved from the FQDN destination before
+// the rules and the dial, the request is carried by the context, see RequestFromContext.
+// The addresses returned are kept, the error fails the request with the reply code of ReplyOf,
+// such as ErrRuleDenied is replied with RepRuleFailure.
+type AnswerFilter func(ctx context.Context, name string, ips []net.IP) ([]net.IP, error)
+
+// errAnswerFiltered all the addresses of the answer are filtered
+var errAnswerFiltered = errors.New("all the addresses filtered")
+
+// DropIPv6 is the AnswerFilter which drops the IPv6 addresses
+func DropIPv6() AnswerFilter {
+	return func(_ context.Context, _ string, ips []net.IP) ([]net.IP, error) {
+		return filterFamily(ips, "tcp4"), nil
+	}
+}
+
+// bogonNetworks are the networks not routable on the internet, the private, loopback,
+// link local, multicast, reserved and documentation networks
+var bogonNetworks = func() []*net.IPNet {
+	var networks []*net.IPNet
+	for _, s := range []string{
+		"0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "127.0.0.0/8", "169.254.0.0/16", "172.16.0.0/12",
+		"192.0.0.0/24", "192.0.2.0/24", "192.168.0.0/16", "198.18.0.0/15", "198.51.100.0/24",
+		"203.0.113.0/24", "224.0.0.0/4", "240.0.0.0/4",
+		"::/128", "::1/128", "fc00::/7", "fe80::/10", "ff00::/8", "2001:db8::/32",
+	} {
+		_, network, _ := net.ParseCIDR(s)
+		networks = append(networks, network)
+	}
+	return networks
+}()
+
+// DropBogons is the AnswerFilter which drops the addresses not routable on the internet,
+// such as the private and loopback addresses, against the DNS rebinding to the internal services.
+func DropBogons() AnswerFilter {
+	return func(_ context.Context, _ string, ips []net.IP) ([]net.IP, error) {
+		out := make([]net.IP, 0, len(ips))
+		for _, ip := range ips {
+			if !containsIP(bogonNetworks, unmapIP(ip)) {
+				out = append(out, ip)
+			}
+		}
+		return out, nil
+	}
+}
+
+// AnswerWithinCIDR is the AnswerFilter which keeps the addresses within the CIDR only,
+// such as "10.0.0.0/8" or "::1/128", a bare ip is taken as the single address network.
+func AnswerWithinCIDR(cidrs ...string) (AnswerFilter, error) {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, s := range cidrs {
+		network, err := parseCIDR(s)
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, network)
+	}
+	return func(_ context.Context, _ string, ips []net.IP) ([]net.IP, error) {
+		out := make([]net.IP, 0, len(ips))
+		for _, ip := range ips {
+			if containsIP(networks, unmapIP(ip)) {
+				out = append(out, ip)
+			}
+		}
+		return out, nil
+	}, nil
+}
+
+// containsIP reports whether any of the networks contains the ip
+func containsIP(networks []*net.IPNet, ip net.IP) bool {
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// filterAnswer applies the answer filters in order, it fails if no address is left
+func (sf *Server) filterAnswer(ctx context.Context, name string, ips []net.IP) ([]net.IP, error) {
+	var err error
+	for _, f := range sf.answerFilters {
+		if ips, err = f(ctx, name, ips); err != nil {
+			return nil, err
+		}
+	}
+	if len(ips) == 0 {
+		return nil, &net.DNSError{Err: errAnswerFiltered.Error(), Name: name, IsNotFound: true}
+	}
+	return ips, nil
+}
+
+// resolve resolves the FQDN by the NameResolver and filters the answer, the other addresses
+// of the AddrResolver are looked up if the address resolved is filtered or the Happy Eyeballs
+// is enabled. It returns the addresses, the first is the address picked, and the reply code
+// of the failure.
+func (sf *Server) resolve(ctx context.Context, name string) (context.Context, []net.IP, uint8, error) {
+	resolver := sf.currentResolver()
+	ctx, ip, err := resolver.Resolve(ctx, name)
+	if err != nil {
+		return ctx, nil, statute.RepHostUnreachable, err
+	}
+	picked, err := sf.filterAnswer(ctx, name, []net.IP{ip})
+	r, ok := resolver.(AddrResolver)
+	if !ok || (err == nil && sf.happyEyeballs <= 0) {
+		if err != nil {
+			return ctx, nil, ReplyOf(err), err
+		}
+		return ctx, picked, statute.RepSuccess, nil
+	}
+	all, _, lerr := r.LookupAddrs(ctx, name)
+	if lerr != nil {
+		if err != nil {
+			return ctx, nil, ReplyOf(err), err
+		}
+		return ctx, picked, statute.RepSuccess, nil
+	}
+	ips, ferr := sf.filterAnswer(ctx, name, all)
+	if err != nil {
+		if ferr != nil {
+			return ctx, nil, ReplyOf(ferr), ferr
+		}
+		return ctx, ips, statute.RepSuccess, nil
+	}
+	// the address picked goes first
+	for _, v := range ips {
+		if !v.Equal(ip) {
+			picked = append(picked, v)
+		}
+	}
+	return ctx, picked, statute.RepSuccess, nil
+}
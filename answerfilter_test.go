@@ -0,0 +1,67 @@
+package socks5
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/thinkgos/go-socks5/statute"
+)
+
+func TestAnswerFilter(t *testing.T) {
+	ctx := context.Background()
+	public4, private4 := net.IPv4(8, 8, 8, 8), net.IPv4(192, 168, 1, 1)
+	public6, loopback6 := net.ParseIP("2606:4700::1111"), net.IPv6loopback
+	ips := []net.IP{public4, private4, public6, loopback6}
+
+	got, err := DropIPv6()(ctx, "x", ips)
+	require.NoError(t, err)
+	assert.Equal(t, []net.IP{public4, private4}, got)
+
+	got, err = DropBogons()(ctx, "x", ips)
+	require.NoError(t, err)
+	assert.Equal(t, []net.IP{public4, public6}, got)
+
+	within, err := AnswerWithinCIDR("192.168.0.0/16", "::1")
+	require.NoError(t, err)
+	got, err = within(ctx, "x", ips)
+	require.NoError(t, err)
+	assert.Equal(t, []net.IP{private4, loopback6}, got)
+	_, err = AnswerWithinCIDR("10.0.0.0/33")
+	require.Error(t, err)
+}
+
+func TestServer_AnswerFilter(t *testing.T) {
+	target := echoTarget(t)
+	resolver := PolicyResolver{
+		Resolver: addrResolverFunc(func(context.Context, string) ([]net.IP, time.Duration, error) {
+			return []net.IP{net.ParseIP("2001:db8::1"), target.IP}, 0, nil
+		}),
+		Policy: PreferIPv6,
+	}
+
+	// the IPv6 address preferred is dropped, the IPv4 one is dialed and checked by the rules
+	dests := make(chan string, 1)
+	proxy := serveSocks(t, WithResolver(resolver), WithAnswerFilter(DropIPv6()),
+		WithRule(ruleFunc(func(ctx context.Context, req *Request) (context.Context, bool) {
+			dests <- req.DestAddr.IP.String()
+			return ctx, true
+		})))
+	assert.Equal(t, statute.RepSuccess, connectDomain(t, proxy, "internal.example", target.Port))
+	assert.Equal(t, target.IP.String(), <-dests)
+
+	// all dropped
+	proxy = serveSocks(t, WithResolver(resolver), WithAnswerFilter(DropIPv6(), DropBogons()))
+	assert.Equal(t, statute.RepHostUnreachable, connectDomain(t, proxy, "internal.example", target.Port))
+
+	// the filter denies
+	proxy = serveSocks(t, WithResolver(resolver), WithAnswerFilter(
+		func(_ context.Context, name string, _ []net.IP) ([]net.IP, error) {
+			return nil, ErrRuleDenied
+		}))
+	assert.Equal(t, statute.RepRuleFailure, connectDomain(t, proxy, "internal.example", target.Port))
+}
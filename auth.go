@@ -1,7 +1,11 @@
 package socks5
 
 import (
+	"context"
+	"crypto/tls"
+	"fmt"
 	"io"
+	"unicode/utf8"
 
 	"github.com/thinkgos/go-socks5/statute"
 )
@@ -15,6 +19,10 @@ type AuthContext struct {
 	// Keys depend on the used auth method.
 	// For UserPass auth contains username/password
 	Payload map[string]string
+	// Destinations the user is allowed to, nil means no restriction, empty means none
+	Destinations []DestinationTemplate
+	// encapsulate the subsequent request and data after authenticated, such as GSSAPI
+	encapsulate func(r io.Reader, w io.Writer) (io.Reader, io.Writer)
 }
 
 // Authenticator provide auth
@@ -23,6 +31,21 @@ type Authenticator interface {
 	GetCode() uint8
 }
 
+// TLSAuthenticator is optionally implemented by the Authenticator, to see the TLS state
+// of the connection accepted by a TLS listener, such as the verified client certificate chains.
+type TLSAuthenticator interface {
+	Authenticator
+	AuthenticateTLS(reader io.Reader, writer io.Writer, userAddr string,
+		state *tls.ConnectionState) (*AuthContext, error)
+}
+
+// ContextAuthenticator is optionally implemented by the Authenticator, to receive the context
+// of the connection, which is canceled once the server is closed.
+type ContextAuthenticator interface {
+	Authenticator
+	AuthenticateContext(ctx context.Context, reader io.Reader, writer io.Writer, userAddr string) (*AuthContext, error)
+}
+
 // NoAuthAuthenticator is used to handle the "No Authentication" mode
 type NoAuthAuthenticator struct{}
 
@@ -32,7 +55,7 @@ func (a NoAuthAuthenticator) GetCode() uint8 { return statute.MethodNoAuth }
 // Authenticate implement interface Authenticator
 func (a NoAuthAuthenticator) Authenticate(_ io.Reader, writer io.Writer, _ string) (*AuthContext, error) {
 	_, err := writer.Write([]byte{statute.VersionSocks5, statute.MethodNoAuth})
-	return &AuthContext{statute.MethodNoAuth, make(map[string]string)}, err
+	return &AuthContext{Method: statute.MethodNoAuth, Payload: make(map[string]string)}, err
 }
 
 // UserPassAuthenticator is used to handle username/password based
@@ -46,6 +69,20 @@ func (a UserPassAuthenticator) GetCode() uint8 { return statute.MethodUserPassAu
 
 // Authenticate implement interface Authenticator
 func (a UserPassAuthenticator) Authenticate(reader io.Reader, writer io.Writer, userAddr string) (*AuthContext, error) {
+	return a.AuthenticateContext(context.Background(), reader, writer, userAddr)
+}
+
+// AuthenticateContext implement interface ContextAuthenticator
+func (a UserPassAuthenticator) AuthenticateContext(ctx context.Context, reader io.Reader, writer io.Writer,
+	userAddr string) (*AuthContext, error) {
+	return a.authenticate(ctx, reader, writer, userAddr, func(nup statute.UserPassRequest) (string, string, error) {
+		return string(nup.User), string(nup.Pass), nil
+	})
+}
+
+// authenticate authenticates the username and password checked by check
+func (a UserPassAuthenticator) authenticate(ctx context.Context, reader io.Reader, writer io.Writer, userAddr string,
+	check func(nup statute.UserPassRequest) (user, pass string, err error)) (*AuthContext, error) {
 	// reply the client to use user/pass auth
 	if _, err := writer.Write([]byte{statute.VersionSocks5, statute.MethodUserPassAuth}); err != nil {
 		return nil, err
@@ -56,23 +93,103 @@ func (a UserPassAuthenticator) Authenticate(reader io.Reader, writer io.Writer,
 		return nil, err
 	}
 
+	user, pass, err := check(nup)
+	if err != nil {
+		if _, err := writer.Write([]byte{statute.UserPassAuthVersion, statute.AuthFailure}); err != nil {
+			return nil, err
+		}
+		return nil, &AuthError{User: string(nup.User), Err: err}
+	}
+
 	// Verify the password
-	if !a.Credentials.Valid(string(nup.User), string(nup.Pass), userAddr) {
+	if !validCredentials(ctx, a.Credentials, user, pass, userAddr) {
 		if _, err := writer.Write([]byte{statute.UserPassAuthVersion, statute.AuthFailure}); err != nil {
 			return nil, err
 		}
-		return nil, statute.ErrUserAuthFailed
+		return nil, &AuthError{User: user, Err: statute.ErrUserAuthFailed}
 	}
 
 	if _, err := writer.Write([]byte{statute.UserPassAuthVersion, statute.AuthSuccess}); err != nil {
 		return nil, err
 	}
 	// Done
-	return &AuthContext{
-		statute.MethodUserPassAuth,
-		map[string]string{
-			"username": string(nup.User),
-			"password": string(nup.Pass),
+	ac := &AuthContext{
+		Method: statute.MethodUserPassAuth,
+		Payload: map[string]string{
+			"username": user,
+			"password": pass,
 		},
-	}, nil
+	}
+	if dt, ok := a.Credentials.(DestinationTemplates); ok {
+		if templates, ok := dt.Destinations(user); ok {
+			if templates == nil {
+				// a listed user without templates is allowed nowhere, not everywhere
+				templates = []DestinationTemplate{}
+			}
+			ac.Destinations = templates
+		}
+	}
+	return ac, nil
+}
+
+// AuthError is the authentication failure of the user
+type AuthError struct {
+	User string
+	Err  error
+}
+
+// Error implement interface error
+func (sf *AuthError) Error() string { return sf.Err.Error() }
+
+// Unwrap returns the underlying error
+func (sf *AuthError) Unwrap() error { return sf.Err }
+
+// CheckedUserPassAuthenticator is the UserPassAuthenticator which checks the limits of
+// the username and password, and normalizes them before lookups.
+type CheckedUserPassAuthenticator struct {
+	UserPassAuthenticator
+	// MaxUserLen limits the username length, 0 means no limit other than the protocol's 255 bytes.
+	MaxUserLen int
+	// MaxPassLen limits the password length, 0 means no limit other than the protocol's 255 bytes.
+	MaxPassLen int
+	// ValidUTF8 requires the username and password to be valid UTF-8.
+	ValidUTF8 bool
+	// Normalize optional normalize the username and password before lookups,
+	// such as SASLprep or PRECIS OpaqueString.
+	Normalize func(s string) (string, error)
+}
+
+// Authenticate implement interface Authenticator
+func (a CheckedUserPassAuthenticator) Authenticate(reader io.Reader, writer io.Writer,
+	userAddr string) (*AuthContext, error) {
+	return a.AuthenticateContext(context.Background(), reader, writer, userAddr)
+}
+
+// AuthenticateContext implement interface ContextAuthenticator
+func (a CheckedUserPassAuthenticator) AuthenticateContext(ctx context.Context, reader io.Reader, writer io.Writer,
+	userAddr string) (*AuthContext, error) {
+	return a.authenticate(ctx, reader, writer, userAddr, a.checkUserPass)
+}
+
+// checkUserPass check the limits of username and password, and normalize them
+func (a CheckedUserPassAuthenticator) checkUserPass(nup statute.UserPassRequest) (user, pass string, err error) {
+	if a.MaxUserLen > 0 && len(nup.User) > a.MaxUserLen {
+		return "", "", fmt.Errorf("%w, username too long", statute.ErrUserAuthFailed)
+	}
+	if a.MaxPassLen > 0 && len(nup.Pass) > a.MaxPassLen {
+		return "", "", fmt.Errorf("%w, password too long", statute.ErrUserAuthFailed)
+	}
+	if a.ValidUTF8 && (!utf8.Valid(nup.User) || !utf8.Valid(nup.Pass)) {
+		return "", "", fmt.Errorf("%w, invalid UTF-8 username or password", statute.ErrUserAuthFailed)
+	}
+	user, pass = string(nup.User), string(nup.Pass)
+	if a.Normalize != nil {
+		if user, err = a.Normalize(user); err != nil {
+			return "", "", fmt.Errorf("%w, normalize username, %v", statute.ErrUserAuthFailed, err)
+		}
+		if pass, err = a.Normalize(pass); err != nil {
+			return "", "", fmt.Errorf("%w, normalize password, %v", statute.ErrUserAuthFailed, err)
+		}
+	}
+	return user, pass, nil
 }
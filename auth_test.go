@@ -2,7 +2,9 @@ package socks5
 
 import (
 	"bytes"
+	"context"
 	"errors"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -46,6 +48,29 @@ func TestPasswordAuth_Valid(t *testing.T) {
 	assert.Equal(t, []byte{statute.VersionSocks5, statute.MethodUserPassAuth, 1, statute.AuthSuccess}, rsp.Bytes())
 }
 
+type ctxKey struct{}
+
+type contextCredentials struct {
+	StaticCredentials
+	got interface{}
+}
+
+func (c *contextCredentials) ValidContext(ctx context.Context, user, password, userAddr string) bool {
+	c.got = ctx.Value(ctxKey{})
+	return c.Valid(user, password, userAddr)
+}
+
+func TestPasswordAuth_Context(t *testing.T) {
+	req := bytes.NewBuffer([]byte{1, 3, 'f', 'o', 'o', 3, 'b', 'a', 'r'})
+	creds := &contextCredentials{StaticCredentials: StaticCredentials{"foo": "bar"}}
+	cator := UserPassAuthenticator{Credentials: creds}
+
+	ctx := context.WithValue(context.Background(), ctxKey{}, "conn")
+	_, err := cator.AuthenticateContext(ctx, req, new(bytes.Buffer), "")
+	require.NoError(t, err)
+	require.Equal(t, "conn", creds.got)
+}
+
 func TestPasswordAuth_Invalid(t *testing.T) {
 	req := bytes.NewBuffer([]byte{1, 3, 'f', 'o', 'o', 3, 'b', 'a', 'z'})
 	rsp := new(bytes.Buffer)
@@ -61,3 +86,34 @@ func TestPasswordAuth_Invalid(t *testing.T) {
 
 	assert.Equal(t, []byte{statute.VersionSocks5, statute.MethodUserPassAuth, 1, statute.AuthFailure}, rsp.Bytes())
 }
+
+func TestPasswordAuth_Limits(t *testing.T) {
+	cator := CheckedUserPassAuthenticator{
+		UserPassAuthenticator: UserPassAuthenticator{StaticCredentials{"foo": "bar"}},
+		MaxUserLen:            2,
+	}
+	rsp := new(bytes.Buffer)
+	ctx, err := cator.Authenticate(bytes.NewBuffer([]byte{1, 3, 'f', 'o', 'o', 3, 'b', 'a', 'r'}), rsp, "")
+	require.True(t, errors.Is(err, statute.ErrUserAuthFailed))
+	require.Nil(t, ctx)
+	assert.Equal(t, []byte{statute.VersionSocks5, statute.MethodUserPassAuth, 1, statute.AuthFailure}, rsp.Bytes())
+
+	cator = CheckedUserPassAuthenticator{
+		UserPassAuthenticator: UserPassAuthenticator{StaticCredentials{"foo": "bar"}},
+		ValidUTF8:             true,
+	}
+	rsp.Reset()
+	_, err = cator.Authenticate(bytes.NewBuffer([]byte{1, 3, 'f', 'o', 0xff, 3, 'b', 'a', 'r'}), rsp, "")
+	require.True(t, errors.Is(err, statute.ErrUserAuthFailed))
+}
+
+func TestPasswordAuth_Normalize(t *testing.T) {
+	cator := CheckedUserPassAuthenticator{
+		UserPassAuthenticator: UserPassAuthenticator{StaticCredentials{"foo": "bar"}},
+		Normalize:             func(s string) (string, error) { return strings.ToLower(s), nil },
+	}
+	rsp := new(bytes.Buffer)
+	ctx, err := cator.Authenticate(bytes.NewBuffer([]byte{1, 3, 'F', 'O', 'O', 3, 'B', 'a', 'r'}), rsp, "")
+	require.NoError(t, err)
+	assert.Equal(t, "foo", ctx.Payload["username"])
+}
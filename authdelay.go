@@ -0,0 +1,47 @@
+package socks5
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"math/rand"
+	"time"
+)
+
+// delayedAuth holds the replies of the authenticator until it returns, so the failure reply
+// is sent after the delay of WithAuthFailureDelay. The replies before the authenticator reads
+// the client, such as the method selection, are flushed at once.
+type delayedAuth struct {
+	replies *replyBuffer
+	buf     *bufio.Writer
+}
+
+func newDelayedAuth(w io.Writer) *delayedAuth {
+	buf := bufio.NewWriter(w)
+	return &delayedAuth{&replyBuffer{ReplyWriter: buf}, buf}
+}
+
+// reader returns the reader of the authenticator, flushing the replies before reading
+func (sf *delayedAuth) reader(r io.Reader) io.Reader {
+	return &flushReader{r, sf.replies}
+}
+
+// release flushes the replies held, the failure reply is delayed, nothing is delayed if
+// no reply is pending, such as the client gone.
+func (sf *delayedAuth) release(ctx context.Context, srv *Server, failed bool) error {
+	if failed && sf.buf.Buffered() > 0 {
+		if err := sleepContext(ctx, srv.clock, srv.authFailureDelayOnce()); err != nil {
+			return err
+		}
+	}
+	return sf.replies.flush()
+}
+
+// authFailureDelayOnce returns the delay of a failure, the delay plus a random jitter up to the jitter
+func (sf *Server) authFailureDelayOnce() time.Duration {
+	d := sf.authFailureDelay
+	if sf.authFailureJitter > 0 {
+		d += time.Duration(rand.Int63n(int64(sf.authFailureJitter)))
+	}
+	return d
+}
@@ -0,0 +1,64 @@
+package socks5
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/thinkgos/go-socks5/statute"
+)
+
+// stampWriter records the time of each write
+type stampWriter struct {
+	bytes.Buffer
+	stamps []time.Time
+}
+
+func (sf *stampWriter) Write(p []byte) (int, error) {
+	sf.stamps = append(sf.stamps, time.Now())
+	return sf.Buffer.Write(p)
+}
+
+func TestServer_AuthFailureDelay(t *testing.T) {
+	const delay = 50 * time.Millisecond
+	srv := NewServer(
+		WithAuthMethods([]Authenticator{UserPassAuthenticator{Credentials: StaticCredentials{"foo": "bar"}}}),
+		WithAuthFailureDelay(delay, 10*time.Millisecond),
+	)
+
+	t.Run("failure delayed", func(t *testing.T) {
+		w := new(stampWriter)
+		start := time.Now()
+		_, err := srv.authenticate(w, bytes.NewBuffer([]byte{1, 3, 'f', 'o', 'o', 3, 'b', 'a', 'z'}),
+			"127.0.0.1:5000", []byte{statute.MethodUserPassAuth})
+		require.Error(t, err)
+		require.Equal(t, []byte{statute.VersionSocks5, statute.MethodUserPassAuth,
+			statute.UserPassAuthVersion, statute.AuthFailure}, w.Bytes())
+		// the method reply is flushed before reading the credentials, the failure after the delay
+		require.Len(t, w.stamps, 2)
+		require.Less(t, int64(w.stamps[0].Sub(start)), int64(delay))
+		require.GreaterOrEqual(t, int64(w.stamps[1].Sub(start)), int64(delay))
+	})
+
+	t.Run("success not delayed", func(t *testing.T) {
+		w := new(stampWriter)
+		start := time.Now()
+		_, err := srv.authenticate(w, bytes.NewBuffer([]byte{1, 3, 'f', 'o', 'o', 3, 'b', 'a', 'r'}),
+			"127.0.0.1:5000", []byte{statute.MethodUserPassAuth})
+		require.NoError(t, err)
+		require.Equal(t, []byte{statute.VersionSocks5, statute.MethodUserPassAuth,
+			statute.UserPassAuthVersion, statute.AuthSuccess}, w.Bytes())
+		require.Less(t, int64(time.Since(start)), int64(delay))
+	})
+
+	t.Run("client gone not delayed", func(t *testing.T) {
+		w := new(stampWriter)
+		start := time.Now()
+		_, err := srv.authenticate(w, bytes.NewBuffer([]byte{1, 3, 'f'}),
+			"127.0.0.1:5000", []byte{statute.MethodUserPassAuth})
+		require.Error(t, err)
+		require.Less(t, int64(time.Since(start)), int64(delay))
+	})
+}
@@ -0,0 +1,47 @@
+package socks5
+
+import (
+	"errors"
+	"time"
+)
+
+// AuthEvent is the structured event of an authentication attempt, such as for SIEM ingestion
+type AuthEvent struct {
+	// Method of the authentication
+	Method uint8
+	// Username provided, empty if the method has no username
+	Username string
+	// Source address of the client
+	Source string
+	// Success of the authentication
+	Success bool
+	// Err of the failure
+	Err error
+	// Time the attempt started
+	Time time.Time
+	// Latency of the authentication
+	Latency time.Duration
+}
+
+// emitAuthEvent emits the event of the authentication attempt to the auth event handle
+func (sf *Server) emitAuthEvent(method uint8, source string, start time.Time, ac *AuthContext, err error) {
+	if sf.authEventHandle == nil {
+		return
+	}
+	ev := AuthEvent{
+		Method:  method,
+		Source:  source,
+		Success: err == nil,
+		Err:     err,
+		Time:    start,
+		Latency: sf.since(start),
+	}
+	if ac != nil {
+		ev.Username = ac.Payload["username"]
+	}
+	var ae *AuthError
+	if errors.As(err, &ae) {
+		ev.Username = ae.User
+	}
+	sf.authEventHandle(ev)
+}
@@ -0,0 +1,39 @@
+package socks5
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/thinkgos/go-socks5/statute"
+)
+
+func TestServer_AuthEvent(t *testing.T) {
+	var events []AuthEvent
+	srv := NewServer(
+		WithAuthMethods([]Authenticator{UserPassAuthenticator{Credentials: StaticCredentials{"foo": "bar"}}}),
+		WithAuthEventHandle(func(ev AuthEvent) { events = append(events, ev) }),
+	)
+
+	_, err := srv.authenticate(new(bytes.Buffer),
+		bytes.NewBuffer([]byte{1, 3, 'f', 'o', 'o', 3, 'b', 'a', 'r'}), "127.0.0.1:5000",
+		[]byte{statute.MethodUserPassAuth})
+	require.NoError(t, err)
+	_, err = srv.authenticate(new(bytes.Buffer),
+		bytes.NewBuffer([]byte{1, 3, 'f', 'o', 'o', 3, 'b', 'a', 'z'}), "127.0.0.1:5001",
+		[]byte{statute.MethodUserPassAuth})
+	require.Error(t, err)
+
+	require.Len(t, events, 2)
+	require.Equal(t, statute.MethodUserPassAuth, events[0].Method)
+	require.Equal(t, "foo", events[0].Username)
+	require.Equal(t, "127.0.0.1:5000", events[0].Source)
+	require.True(t, events[0].Success)
+	require.False(t, events[0].Time.IsZero())
+
+	require.Equal(t, "foo", events[1].Username)
+	require.False(t, events[1].Success)
+	require.True(t, errors.Is(events[1].Err, statute.ErrUserAuthFailed))
+}
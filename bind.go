@@ -0,0 +1,162 @@
+package socks5
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/thinkgos/go-socks5/statute"
+)
+
+// BindConfig is the config of the BIND command
+type BindConfig struct {
+	// AcceptTimeout limits the time waiting for the incoming connection,
+	// RepTTLExpired is replied on expiry. 0 means no timeout.
+	AcceptTimeout time.Duration
+	// KeepAlive is the period of the tcp keepalives sent on the incoming connection,
+	// which keeps the NAT mapping alive. 0 disables the keepalives.
+	KeepAlive time.Duration
+}
+
+type bindConfigKey struct{}
+
+// WithBindContext returns a copy of the context carrying the BIND config,
+// a RuleSet use it to configure the BIND per rule.
+func WithBindContext(ctx context.Context, cfg BindConfig) context.Context {
+	return context.WithValue(ctx, bindConfigKey{}, cfg)
+}
+
+// bindConfig returns the BIND config of the rule carried by the context, or the server's
+func (sf *Server) bindConfig(ctx context.Context) BindConfig {
+	if cfg, ok := ctx.Value(bindConfigKey{}).(BindConfig); ok {
+		return cfg
+	}
+	return sf.bind
+}
+
+// acceptBind waits for the incoming connection of the BIND with the config, only from the peers
+// allowed if not nil, the connections from the others are closed and the wait goes on until
+// the timeout. It returns the SOCKS reply code on failure.
+func acceptBind(ln *net.TCPListener, cfg BindConfig, allow func(addr net.Addr) bool) (*net.TCPConn, uint8, error) {
+	if cfg.AcceptTimeout > 0 {
+		ln.SetDeadline(time.Now().Add(cfg.AcceptTimeout)) // nolint: errcheck
+	}
+	for {
+		conn, err := ln.AcceptTCP()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				return nil, statute.RepTTLExpired, err
+			}
+			return nil, statute.RepServerFailure, err
+		}
+		if allow != nil && !allow(conn.RemoteAddr()) {
+			conn.Close()
+			continue
+		}
+		if cfg.KeepAlive > 0 {
+			conn.SetKeepAlive(true)                // nolint: errcheck
+			conn.SetKeepAlivePeriod(cfg.KeepAlive) // nolint: errcheck
+		}
+		return conn, statute.RepSuccess, nil
+	}
+}
+
+// watchBindClient closes the listener once the client connection is closed or fails while the BIND
+// waits for the incoming connection, nothing else reads the client meanwhile. The data the client
+// sent early is handed back to the request reader when stopped.
+func (sf *Server) watchBindClient(request *Request, ln io.Closer) (stop func()) {
+	if request.conn == nil {
+		return func() {}
+	}
+	var b [1]byte
+	var n int
+	var stopped int32
+	done := make(chan struct{})
+	sf.goFunc(func() {
+		defer close(done)
+		var err error
+		n, err = request.Reader.Read(b[:])
+		if err != nil && atomic.LoadInt32(&stopped) == 0 {
+			ln.Close()
+		}
+	})
+	return func() {
+		atomic.StoreInt32(&stopped, 1)
+		request.conn.SetReadDeadline(aLongTimeAgo) // nolint: errcheck
+		<-done
+		request.conn.SetReadDeadline(time.Time{}) // nolint: errcheck
+		if n > 0 {
+			request.Reader = io.MultiReader(bytes.NewReader(b[:n]), request.Reader)
+		}
+	}
+}
+
+// bindPeers returns the ips the BIND accepts the incoming connection from, the DST.ADDR of the
+// request as RFC 1928 evaluates the BIND by, nil accepts any if the DST.ADDR is unspecified.
+func bindPeers(request *Request) []net.IP {
+	ip := request.DestAddr.IP
+	if ip == nil || ip.IsUnspecified() {
+		return nil
+	}
+	if len(request.ResolvedIPs) > 0 {
+		return request.ResolvedIPs
+	}
+	return []net.IP{ip}
+}
+
+// bindPeerAllowed reports whether the incoming connection from the address is of the peers
+func bindPeerAllowed(peers []net.IP, addr net.Addr) bool {
+	if len(peers) == 0 {
+		return true
+	}
+	ip := addrIP(addr)
+	for _, peer := range peers {
+		if peer.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// listenBind listen the tcp of the bind from the bind address pool, in turn,
+// defaults to the local ip of the client connection or the bind ip.
+func (sf *Server) listenBind(request *Request) (*net.TCPListener, error) {
+	if len(sf.bindAddrPool) == 0 {
+		ip := sf.bindIP
+		if ip == nil {
+			ip = addrIP(request.LocalAddr)
+		}
+		return net.ListenTCP("tcp", &net.TCPAddr{IP: ip})
+	}
+
+	var err error
+	start := int(atomic.AddUint32(&sf.bindAddrNext, 1))
+	for i := 0; i < len(sf.bindAddrPool); i++ {
+		var addr *net.TCPAddr
+		addr, err = net.ResolveTCPAddr("tcp", sf.bindAddrPool[(start+i)%len(sf.bindAddrPool)])
+		if err != nil {
+			continue
+		}
+		var ln *net.TCPListener
+		if ln, err = net.ListenTCP("tcp", addr); err == nil {
+			return ln, nil
+		}
+	}
+	return nil, err
+}
+
+// bindReplyAddr returns the address advertised in the first reply,
+// the local ip of the client connection instead if listening on the unspecified ip.
+func (sf *Server) bindReplyAddr(request *Request, addr net.Addr) net.Addr {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok || !tcpAddr.IP.IsUnspecified() {
+		return addr
+	}
+	if ip := addrIP(request.LocalAddr); ip != nil {
+		return &net.TCPAddr{IP: unmapIP(ip), Port: tcpAddr.Port}
+	}
+	return addr
+}
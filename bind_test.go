@@ -0,0 +1,221 @@
+package socks5
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/thinkgos/go-socks5/statute"
+)
+
+func TestServer_bindConfig(t *testing.T) {
+	srv := NewServer(WithBindConfig(BindConfig{AcceptTimeout: time.Second}))
+	require.Equal(t, time.Second, srv.bindConfig(context.Background()).AcceptTimeout)
+
+	ctx := WithBindContext(context.Background(), BindConfig{KeepAlive: time.Minute})
+	cfg := srv.bindConfig(ctx)
+	require.Equal(t, time.Duration(0), cfg.AcceptTimeout)
+	require.Equal(t, time.Minute, cfg.KeepAlive)
+}
+
+func TestAcceptBind(t *testing.T) {
+	ln, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	defer ln.Close()
+
+	_, rep, err := acceptBind(ln, BindConfig{AcceptTimeout: 20 * time.Millisecond}, nil)
+	require.Error(t, err)
+	require.Equal(t, statute.RepTTLExpired, rep)
+
+	go func() {
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		if err == nil {
+			conn.Close()
+		}
+	}()
+	conn, rep, err := acceptBind(ln, BindConfig{AcceptTimeout: time.Second, KeepAlive: time.Second}, nil)
+	require.NoError(t, err)
+	require.Equal(t, statute.RepSuccess, rep)
+	conn.Close()
+}
+
+func TestAcceptBind_Peer(t *testing.T) {
+	ln, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	defer ln.Close()
+
+	accepted := make(chan *net.TCPConn, 1)
+	go func() {
+		conn, rep, err := acceptBind(ln, BindConfig{AcceptTimeout: 2 * time.Second}, func(addr net.Addr) bool {
+			return bindPeerAllowed([]net.IP{net.IPv4(127, 0, 0, 2)}, addr)
+		})
+		if err == nil && rep == statute.RepSuccess {
+			accepted <- conn
+		}
+		close(accepted)
+	}()
+
+	// the wrong peer is closed, the wait goes on
+	wrong, err := net.Dial("tcp", ln.Addr().String())
+	require.NoError(t, err)
+	defer wrong.Close()
+	wrong.SetReadDeadline(time.Now().Add(time.Second)) // nolint: errcheck
+	_, err = wrong.Read(make([]byte, 1))
+	require.Equal(t, io.EOF, err)
+
+	d := net.Dialer{LocalAddr: &net.TCPAddr{IP: net.IPv4(127, 0, 0, 2)}}
+	peer, err := d.Dial("tcp", ln.Addr().String())
+	require.NoError(t, err)
+	defer peer.Close()
+	conn := <-accepted
+	require.NotNil(t, conn)
+	defer conn.Close()
+	require.Equal(t, peer.LocalAddr().String(), conn.RemoteAddr().String())
+}
+
+func TestBindPeers(t *testing.T) {
+	req := &Request{DestAddr: &statute.AddrSpec{IP: net.IPv4zero}}
+	require.Nil(t, bindPeers(req))
+	req.DestAddr = &statute.AddrSpec{IP: net.IPv4(10, 0, 0, 1)}
+	require.Equal(t, []net.IP{net.IPv4(10, 0, 0, 1)}, bindPeers(req))
+	req.ResolvedIPs = []net.IP{net.IPv4(10, 0, 0, 1), net.IPv4(10, 0, 0, 2)}
+	require.Len(t, bindPeers(req), 2)
+}
+
+func bindRequest(t *testing.T, srv *Server) (net.Conn, statute.Reply) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+	go srv.Serve(l) // nolint: errcheck
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(2 * time.Second)) // nolint: errcheck
+
+	_, err = conn.Write([]byte{
+		statute.VersionSocks5, 1, statute.MethodNoAuth,
+		statute.VersionSocks5, statute.CommandBind, 0, statute.ATYPIPv4, 127, 0, 0, 1, 0, 0,
+	})
+	require.NoError(t, err)
+	_, err = io.ReadFull(conn, make([]byte, 2))
+	require.NoError(t, err)
+	reply, err := statute.ParseReply(conn)
+	require.NoError(t, err)
+	return conn, reply
+}
+
+func TestServer_Bind(t *testing.T) {
+	conn, first := bindRequest(t, NewServer())
+	require.Equal(t, statute.RepSuccess, first.Response)
+	require.True(t, first.BndAddr.IP.Equal(net.IPv4(127, 0, 0, 1)))
+	require.NotZero(t, first.BndAddr.Port)
+
+	peer, err := net.Dial("tcp", first.BndAddr.String())
+	require.NoError(t, err)
+	defer peer.Close()
+
+	second, err := statute.ParseReply(conn)
+	require.NoError(t, err)
+	require.Equal(t, statute.RepSuccess, second.Response)
+	require.Equal(t, peer.LocalAddr().(*net.TCPAddr).Port, second.BndAddr.Port)
+
+	_, err = peer.Write([]byte("ping"))
+	require.NoError(t, err)
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(conn, buf)
+	require.NoError(t, err)
+	require.Equal(t, []byte("ping"), buf)
+
+	_, err = conn.Write([]byte("pong"))
+	require.NoError(t, err)
+	_, err = io.ReadFull(peer, buf)
+	require.NoError(t, err)
+	require.Equal(t, []byte("pong"), buf)
+}
+
+func TestServer_Bind_Timeout(t *testing.T) {
+	conn, first := bindRequest(t, NewServer(WithBindConfig(BindConfig{AcceptTimeout: 20 * time.Millisecond})))
+	require.Equal(t, statute.RepSuccess, first.Response)
+
+	second, err := statute.ParseReply(conn)
+	require.NoError(t, err)
+	require.Equal(t, statute.RepTTLExpired, second.Response)
+}
+
+func TestServer_Bind_ClientGone(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	go NewServer().Serve(l) // nolint: errcheck
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	conn.SetDeadline(time.Now().Add(2 * time.Second)) // nolint: errcheck
+	// only the peer 192.0.2.1 is accepted, the dials of the test are refused
+	_, err = conn.Write([]byte{
+		statute.VersionSocks5, 1, statute.MethodNoAuth,
+		statute.VersionSocks5, statute.CommandBind, 0, statute.ATYPIPv4, 192, 0, 2, 1, 0, 0,
+	})
+	require.NoError(t, err)
+	_, err = io.ReadFull(conn, make([]byte, 2))
+	require.NoError(t, err)
+	first, err := statute.ParseReply(conn)
+	require.NoError(t, err)
+	require.Equal(t, statute.RepSuccess, first.Response)
+	conn.Close()
+
+	require.Eventually(t, func() bool {
+		peer, err := net.Dial("tcp", first.BndAddr.String())
+		if err != nil {
+			return true
+		}
+		peer.Close()
+		return false
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestServer_Bind_EarlyData(t *testing.T) {
+	conn, first := bindRequest(t, NewServer())
+	require.Equal(t, statute.RepSuccess, first.Response)
+
+	_, err := conn.Write([]byte("ping"))
+	require.NoError(t, err)
+	time.Sleep(20 * time.Millisecond)
+	peer, err := net.Dial("tcp", first.BndAddr.String())
+	require.NoError(t, err)
+	defer peer.Close()
+
+	second, err := statute.ParseReply(conn)
+	require.NoError(t, err)
+	require.Equal(t, statute.RepSuccess, second.Response)
+
+	peer.SetDeadline(time.Now().Add(2 * time.Second)) // nolint: errcheck
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(peer, buf)
+	require.NoError(t, err)
+	require.Equal(t, []byte("ping"), buf)
+}
+
+func TestServer_Bind_Filter(t *testing.T) {
+	_, first := bindRequest(t, NewServer(WithBindFilter(func(context.Context, *Request) bool { return false })))
+	require.Equal(t, statute.RepRuleFailure, first.Response)
+}
+
+func TestServer_listenBind_Pool(t *testing.T) {
+	busy, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer busy.Close()
+
+	srv := NewServer(WithBindAddrPool([]string{busy.Addr().String(), "127.0.0.1:0"}))
+	for i := 0; i < 2; i++ {
+		ln, err := srv.listenBind(&Request{})
+		require.NoError(t, err)
+		require.NotEqual(t, busy.Addr().String(), ln.Addr().String())
+		ln.Close()
+	}
+}
@@ -0,0 +1,254 @@
+package socks5
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned by the dial of the CircuitBreaker if the circuits of the upstreams are open
+var ErrCircuitOpen = errors.New("circuit open")
+
+// breaker defaults
+const (
+	breakerWindow         = time.Minute
+	breakerLatencyWeight  = 0.2
+	breakerDefaultTimeout = 30 * time.Second
+)
+
+// BreakerState is the state of the circuit of an upstream
+type BreakerState uint8
+
+// breaker state defined
+const (
+	// BreakerClosed the upstream is used
+	BreakerClosed BreakerState = iota
+	// BreakerOpen the upstream is skipped
+	BreakerOpen
+	// BreakerHalfOpen a single dial probes the upstream
+	BreakerHalfOpen
+)
+
+// String implement interface fmt.Stringer
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// Upstream is a named way to dial out, such as through an upstream proxy
+type Upstream struct {
+	Name string
+	Dial DialFunc
+}
+
+// UpstreamStats is the statistics of an upstream
+type UpstreamStats struct {
+	Name  string
+	State BreakerState
+	// Successes and Failures are the total dials
+	Successes uint64
+	Failures  uint64
+	// Latency is the moving average of the successful dials
+	Latency time.Duration
+	// Opened is when the circuit opened last
+	Opened time.Time
+}
+
+// upstreamBreaker is the circuit of an upstream
+type upstreamBreaker struct {
+	UpstreamStats
+	// windowStart, requests and failures are the counters of the window
+	windowStart time.Time
+	requests    int
+	failures    int
+	probing     bool
+}
+
+// CircuitBreaker tracks the success rate and the latency of the dials per upstream,
+// and opens the circuit of the upstream once its failure ratio within the window
+// reaches the threshold, so the dials skip the dead upstream instead of waiting
+// for its timeout. After the open timeout a single dial probes the upstream,
+// which closes the circuit if succeeded, otherwise it is open again.
+// Use Dial for an upstream, or Failover for the upstreams by priority,
+// as the dial function of the server.
+type CircuitBreaker struct {
+	// Clock of the circuits, set before use, nil means the system clock.
+	Clock Clock
+	// IsFailure reports whether the dial error counts as a failure of the upstream,
+	// such as excluding the failure replies of the target through an upstream proxy.
+	// nil means all errors except the canceled context.
+	IsFailure func(err error) bool
+	// StateHandle optional called on the state change of the circuit of the upstream
+	StateHandle func(upstream string, from, to BreakerState)
+
+	failureRatio float64
+	minRequests  int
+	openTimeout  time.Duration
+	mu           sync.Mutex
+	upstreams    map[string]*upstreamBreaker
+}
+
+// NewCircuitBreaker new a circuit breaker opens the circuit once the ratio of the failed dials
+// reaches failureRatio among at least minRequests dials within a minute, and probes the upstream
+// after openTimeout, defaults to 30 seconds.
+func NewCircuitBreaker(failureRatio float64, minRequests int, openTimeout time.Duration) *CircuitBreaker {
+	if minRequests <= 0 {
+		minRequests = 1
+	}
+	if openTimeout <= 0 {
+		openTimeout = breakerDefaultTimeout
+	}
+	return &CircuitBreaker{
+		failureRatio: failureRatio,
+		minRequests:  minRequests,
+		openTimeout:  openTimeout,
+		upstreams:    make(map[string]*upstreamBreaker),
+	}
+}
+
+// Dial returns the dial function through the circuit of the upstream
+func (sf *CircuitBreaker) Dial(upstream Upstream) DialFunc {
+	return sf.Failover(upstream)
+}
+
+// Failover returns the dial function tries the upstreams in order, skipping the ones whose circuit
+// is open, and the next one is tried if the dial failed by the upstream.
+func (sf *CircuitBreaker) Failover(upstreams ...Upstream) DialFunc {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		err := ErrCircuitOpen
+		for _, u := range upstreams {
+			if !sf.allow(u.Name) {
+				continue
+			}
+			start := sf.clock().Now()
+			var conn net.Conn
+			conn, err = u.Dial(ctx, network, addr)
+			failed := err != nil && sf.isFailure(err)
+			sf.done(u.Name, start, err == nil, failed)
+			if err == nil || !failed {
+				return conn, err
+			}
+			err = fmt.Errorf("upstream %s, %v", u.Name, err)
+		}
+		return nil, err
+	}
+}
+
+// Stats returns the statistics of the upstreams dialed, sorted by name
+func (sf *CircuitBreaker) Stats() []UpstreamStats {
+	now := sf.clock().Now()
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	stats := make([]UpstreamStats, 0, len(sf.upstreams))
+	for _, b := range sf.upstreams {
+		s := b.UpstreamStats
+		if s.State == BreakerOpen && now.Sub(s.Opened) >= sf.openTimeout {
+			s.State = BreakerHalfOpen
+		}
+		stats = append(stats, s)
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Name < stats[j].Name })
+	return stats
+}
+
+func (sf *CircuitBreaker) clock() Clock { return clockOrSystem(sf.Clock) }
+
+func (sf *CircuitBreaker) isFailure(err error) bool {
+	if sf.IsFailure != nil {
+		return sf.IsFailure(err)
+	}
+	return !errors.Is(err, context.Canceled)
+}
+
+// allow reports whether the upstream can be dialed now, the half-open circuit allows a single probe.
+func (sf *CircuitBreaker) allow(name string) bool {
+	now := sf.clock().Now()
+	sf.mu.Lock()
+	b, ok := sf.upstreams[name]
+	if !ok {
+		b = &upstreamBreaker{UpstreamStats: UpstreamStats{Name: name}, windowStart: now}
+		sf.upstreams[name] = b
+	}
+	from := b.State
+	switch b.State {
+	case BreakerOpen:
+		if now.Sub(b.Opened) < sf.openTimeout {
+			sf.mu.Unlock()
+			return false
+		}
+		b.State = BreakerHalfOpen
+		fallthrough
+	case BreakerHalfOpen:
+		if b.probing {
+			sf.mu.Unlock()
+			return false
+		}
+		b.probing = true
+	}
+	to := b.State
+	sf.mu.Unlock()
+	sf.stateChanged(name, from, to)
+	return true
+}
+
+// done records the result of the dial started at start
+func (sf *CircuitBreaker) done(name string, start time.Time, success, failed bool) {
+	now := sf.clock().Now()
+	sf.mu.Lock()
+	b := sf.upstreams[name]
+	from := b.State
+	if now.Sub(b.windowStart) >= breakerWindow {
+		b.windowStart, b.requests, b.failures = now, 0, 0
+	}
+	if success {
+		b.Successes++
+		latency := now.Sub(start)
+		if b.Latency == 0 {
+			b.Latency = latency
+		} else {
+			b.Latency += time.Duration(breakerLatencyWeight * float64(latency-b.Latency))
+		}
+	}
+	if failed {
+		b.Failures++
+	}
+	if b.State == BreakerHalfOpen {
+		// the probe ended neither way, such as cancelled, leaves the circuit half-open for another probe
+		b.probing = false
+		if failed {
+			b.State, b.Opened = BreakerOpen, now
+		} else if success {
+			b.State = BreakerClosed
+			b.windowStart, b.requests, b.failures = now, 0, 0
+		}
+	} else if b.State == BreakerClosed {
+		b.requests++
+		if failed {
+			b.failures++
+		}
+		if failed && b.requests >= sf.minRequests &&
+			float64(b.failures) >= sf.failureRatio*float64(b.requests) {
+			b.State, b.Opened = BreakerOpen, now
+		}
+	}
+	to := b.State
+	sf.mu.Unlock()
+	sf.stateChanged(name, from, to)
+}
+
+func (sf *CircuitBreaker) stateChanged(name string, from, to BreakerState) {
+	if from != to && sf.StateHandle != nil {
+		sf.StateHandle(name, from, to)
+	}
+}
@@ -0,0 +1,118 @@
+package socks5
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeUpstream dials a pipe, or fails with err if set
+type fakeUpstream struct {
+	err   error
+	dials int
+}
+
+func (sf *fakeUpstream) upstream(name string) Upstream {
+	return Upstream{Name: name, Dial: func(context.Context, string, string) (net.Conn, error) {
+		sf.dials++
+		if sf.err != nil {
+			return nil, sf.err
+		}
+		c1, c2 := net.Pipe()
+		c2.Close()
+		return c1, nil
+	}}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	clock := newManualClock()
+	cb := NewCircuitBreaker(0.5, 4, 10*time.Second)
+	cb.Clock = clock
+	var changes []string
+	cb.StateHandle = func(upstream string, from, to BreakerState) {
+		changes = append(changes, upstream+" "+from.String()+"->"+to.String())
+	}
+	up := &fakeUpstream{err: errors.New("connection refused")}
+	dial := cb.Dial(up.upstream("a"))
+
+	// below the min requests the circuit stays closed
+	for i := 0; i < 3; i++ {
+		_, err := dial(context.Background(), "tcp", "example.com:80")
+		require.Error(t, err)
+	}
+	require.Equal(t, BreakerClosed, cb.Stats()[0].State)
+	_, err := dial(context.Background(), "tcp", "example.com:80")
+	require.Error(t, err)
+	require.Equal(t, BreakerOpen, cb.Stats()[0].State)
+
+	// open, skipped without dialing
+	_, err = dial(context.Background(), "tcp", "example.com:80")
+	require.True(t, errors.Is(err, ErrCircuitOpen))
+	require.Equal(t, 4, up.dials)
+
+	// half-open probe failed, open again
+	clock.Advance(10 * time.Second)
+	require.Equal(t, BreakerHalfOpen, cb.Stats()[0].State)
+	_, err = dial(context.Background(), "tcp", "example.com:80")
+	require.False(t, errors.Is(err, ErrCircuitOpen))
+	require.Equal(t, 5, up.dials)
+	_, err = dial(context.Background(), "tcp", "example.com:80")
+	require.True(t, errors.Is(err, ErrCircuitOpen))
+
+	// half-open probe cancelled, half-open still for another probe
+	clock.Advance(10 * time.Second)
+	up.err = context.Canceled
+	_, err = dial(context.Background(), "tcp", "example.com:80")
+	require.True(t, errors.Is(err, context.Canceled))
+	require.Equal(t, BreakerHalfOpen, cb.Stats()[0].State)
+
+	// half-open probe succeeded, closed
+	up.err = nil
+	conn, err := dial(context.Background(), "tcp", "example.com:80")
+	require.NoError(t, err)
+	conn.Close()
+
+	s := cb.Stats()[0]
+	require.Equal(t, BreakerClosed, s.State)
+	require.Equal(t, uint64(1), s.Successes)
+	require.Equal(t, uint64(5), s.Failures)
+	require.Equal(t, []string{
+		"a closed->open",
+		"a open->half-open",
+		"a half-open->open",
+		"a open->half-open",
+		"a half-open->closed",
+	}, changes)
+}
+
+func TestCircuitBreaker_Failover(t *testing.T) {
+	cb := NewCircuitBreaker(0.5, 1, time.Minute)
+	cb.IsFailure = func(err error) bool { return err.Error() != "target refused" }
+	primary := &fakeUpstream{err: errors.New("i/o timeout")}
+	backup := &fakeUpstream{}
+	dial := cb.Failover(primary.upstream("primary"), backup.upstream("backup"))
+
+	for i := 0; i < 3; i++ {
+		conn, err := dial(context.Background(), "tcp", "example.com:80")
+		require.NoError(t, err)
+		conn.Close()
+	}
+	// the dead primary is skipped once its circuit opened
+	require.Equal(t, 1, primary.dials)
+	require.Equal(t, 3, backup.dials)
+
+	// the failure of the target is not the failure of the upstream, no failover
+	backup.err = errors.New("target refused")
+	_, err := dial(context.Background(), "tcp", "example.com:80")
+	require.EqualError(t, err, "target refused")
+	stats := cb.Stats()
+	require.Equal(t, "backup", stats[0].Name)
+	require.Equal(t, BreakerClosed, stats[0].State)
+	require.Equal(t, uint64(0), stats[0].Failures)
+	require.Equal(t, "primary", stats[1].Name)
+	require.Equal(t, BreakerOpen, stats[1].State)
+}
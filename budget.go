@@ -0,0 +1,93 @@
+package socks5
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// BudgetLimit limits the new connections to Max per Window, such as 600 a minute
+type BudgetLimit struct {
+	Window time.Duration
+	Max    int64
+}
+
+// ConnectionBudget limits how many new sessions a user may open per time window, distinct from
+// the concurrency and the bandwidth limits. The counters of the fixed windows, aligned to the
+// clock of the server, are kept by the Store, so the instances sharing the Store share the budget.
+// The request allowed by the rules is counted by the windows in order until one is exceeded.
+type ConnectionBudget struct {
+	// Store of the counters, defaults to a MemoryStore
+	Store Store
+	// Limits of the users all must pass
+	Limits []BudgetLimit
+	// UserLimits overrides the Limits of the users by the username
+	UserLimits map[string][]BudgetLimit
+	// Key of the budget of the request, defaults to the username,
+	// the client ip if not authenticated by username.
+	Key func(req *Request) string
+	// FailOpen allows the request if the Store failed, otherwise it is refused
+	FailOpen bool
+}
+
+// keyOf returns the budget key of the request
+func (sf *ConnectionBudget) keyOf(req *Request) string {
+	if sf.Key != nil {
+		return sf.Key(req)
+	}
+	if user := usernameOf(req); user != "" {
+		return "user:" + user
+	}
+	return "ip:" + unmapIP(addrIP(req.RemoteAddr)).String()
+}
+
+// allow counts the request and reports whether it is within the budget, the decision is
+// returned if refused.
+func (sf *ConnectionBudget) allow(ctx context.Context, now time.Time, req *Request) (RuleDecision, bool) {
+	key := sf.keyOf(req)
+	limits := sf.Limits
+	if user := usernameOf(req); user != "" {
+		if l, ok := sf.UserLimits[user]; ok {
+			limits = l
+		}
+	}
+	for _, l := range limits {
+		start := now.Truncate(l.Window)
+		counter := "budget:" + key + ":" + strconv.FormatInt(int64(l.Window), 10) + ":" +
+			strconv.FormatInt(start.UnixNano(), 10)
+		n, err := sf.Store.Incr(ctx, counter, start.Add(l.Window).Sub(now))
+		if err != nil {
+			if sf.FailOpen {
+				continue
+			}
+			return RuleDecision{
+				Rule:   "connection-budget",
+				Reason: fmt.Sprintf("connection budget unavailable, %v", err),
+			}, false
+		}
+		if n > l.Max {
+			return RuleDecision{
+				Rule:   "connection-budget",
+				Reason: fmt.Sprintf("connection budget %d per %v of %s exceeded", l.Max, l.Window, key),
+			}, false
+		}
+	}
+	return RuleDecision{}, true
+}
+
+// valid reports whether all the windows are positive and the max are not negative
+func (sf *ConnectionBudget) valid() bool {
+	all := [][]BudgetLimit{sf.Limits}
+	for _, limits := range sf.UserLimits {
+		all = append(all, limits)
+	}
+	for _, limits := range all {
+		for _, l := range limits {
+			if l.Window <= 0 || l.Max < 0 {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,108 @@
+package socks5
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/thinkgos/go-socks5/statute"
+)
+
+func TestMemoryStore(t *testing.T) {
+	clock := newManualClock()
+	store := NewMemoryStore()
+	store.Clock = clock
+	ctx := context.Background()
+
+	for i := int64(1); i <= 3; i++ {
+		n, err := store.Incr(ctx, "a", time.Minute)
+		require.NoError(t, err)
+		require.Equal(t, i, n)
+	}
+	n, _ := store.Incr(ctx, "b", time.Minute)
+	require.Equal(t, int64(1), n)
+
+	clock.Advance(time.Minute)
+	n, _ = store.Incr(ctx, "a", time.Minute)
+	require.Equal(t, int64(1), n)
+}
+
+func TestMemoryStore_ZeroValue(t *testing.T) {
+	var store MemoryStore
+	n, err := store.Incr(context.Background(), "a", time.Minute)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), n)
+}
+
+type errStore struct{}
+
+func (errStore) Incr(context.Context, string, time.Duration) (int64, error) {
+	return 0, errors.New("store down")
+}
+
+func budgetRequest(t *testing.T, s *Server, user string) error {
+	req, err := ParseRequest(bytes.NewBuffer([]byte{
+		statute.VersionSocks5, statute.CommandConnect, 0,
+		statute.ATYPIPv4, 127, 0, 0, 1, 0, 80,
+	}))
+	require.NoError(t, err)
+	req.RemoteAddr = (&MockConn{}).RemoteAddr()
+	if user != "" {
+		req.AuthContext = &AuthContext{Payload: map[string]string{"username": user}}
+	}
+	return s.handleRequest(context.Background(), new(MockConn), req)
+}
+
+func TestServer_ConnectionBudget(t *testing.T) {
+	clock := newManualClock()
+	connected := func(context.Context, io.Writer, *Request) error { return nil }
+	s := NewServer(
+		WithClock(clock),
+		WithConnectionBudget(ConnectionBudget{
+			Limits:     []BudgetLimit{{Window: time.Minute, Max: 2}, {Window: time.Hour, Max: 3}},
+			UserLimits: map[string][]BudgetLimit{"vip": {{Window: time.Minute, Max: 10}}},
+		}),
+		WithConnectHandle(connected),
+	)
+
+	require.NoError(t, budgetRequest(t, s, "foo"))
+	require.NoError(t, budgetRequest(t, s, "foo"))
+	err := budgetRequest(t, s, "foo")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "connection budget 2 per 1m0s of user:foo exceeded")
+	// the budgets are per user, the client ip if not authenticated
+	require.NoError(t, budgetRequest(t, s, "bar"))
+	require.NoError(t, budgetRequest(t, s, ""))
+	for i := 0; i < 5; i++ {
+		require.NoError(t, budgetRequest(t, s, "vip"))
+	}
+
+	// the minute window resets, the request refused by the minute window is not counted by the hour
+	clock.Advance(time.Minute)
+	require.NoError(t, budgetRequest(t, s, "foo"))
+	err = budgetRequest(t, s, "foo")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "connection budget 3 per 1h0m0s of user:foo exceeded")
+	clock.Advance(time.Hour)
+	require.NoError(t, budgetRequest(t, s, "foo"))
+}
+
+func TestServer_ConnectionBudgetStoreFailure(t *testing.T) {
+	connected := func(context.Context, io.Writer, *Request) error { return nil }
+	limits := []BudgetLimit{{Window: time.Minute, Max: 1}}
+
+	s := NewServer(WithConnectionBudget(ConnectionBudget{Store: errStore{}, Limits: limits}),
+		WithConnectHandle(connected))
+	err := budgetRequest(t, s, "foo")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "connection budget unavailable, store down")
+
+	s = NewServer(WithConnectionBudget(ConnectionBudget{Store: errStore{}, Limits: limits, FailOpen: true}),
+		WithConnectHandle(connected))
+	require.NoError(t, budgetRequest(t, s, "foo"))
+}
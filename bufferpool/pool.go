@@ -11,6 +11,12 @@ type BufPool interface {
 	Put([]byte)
 }
 
+// Sizer is optionally implemented by the BufPool, to report the capacity of the buffers
+// before getting one.
+type Sizer interface {
+	Size() int
+}
+
 type pool struct {
 	size int
 	pool *sync.Pool
@@ -31,6 +37,11 @@ func (sf *pool) Get() []byte {
 	return sf.pool.Get().([]byte)
 }
 
+// Size implement interface Sizer
+func (sf *pool) Size() int {
+	return sf.size
+}
+
 // Put implement interface BufPool
 func (sf *pool) Put(b []byte) {
 	if cap(b) != sf.size {
@@ -18,6 +18,7 @@ func TestPool(t *testing.T) {
 	p.Put(b)
 	p.Put(make([]byte, 2048))
 	require.Panics(t, func() { p.Put([]byte{}) })
+	require.Equal(t, 2048, p.(Sizer).Size())
 }
 
 func BenchmarkSyncPool(b *testing.B) {
@@ -49,6 +49,9 @@ func (sf *Connect) SetNoDelay(noDelay bool) error {
 
 // ReadFrom implements the io.ReaderFrom ReadFrom method.
 func (sf *Connect) ReadFrom(r io.Reader) (int64, error) {
+	if uc := sf.Conn.(*underConnect); uc.fw != nil {
+		return io.Copy(struct{ io.Writer }{uc}, r)
+	}
 	return sf.getUnderConnect().ReadFrom(r)
 }
 
@@ -61,6 +64,12 @@ func (sf *Connect) CloseRead() error {
 // CloseWrite shuts down the writing side of the TCP connection.
 // Most callers should just use Close.
 func (sf *Connect) CloseWrite() error {
+	if uc := sf.Conn.(*underConnect); uc.fw != nil {
+		// end the compressed stream
+		if err := uc.fw.Close(); err != nil {
+			return err
+		}
+	}
 	return sf.getUnderConnect().CloseWrite()
 }
 
@@ -0,0 +1,92 @@
+package ccsocks5
+
+import (
+	"io"
+	"time"
+
+	"github.com/thinkgos/go-socks5/statute"
+)
+
+// Authenticator is the client side of an auth method,
+// mirroring the server's socks5.Authenticator.
+type Authenticator interface {
+	// GetCode returns the auth method code
+	GetCode() uint8
+	// Authenticate the method sub-negotiation after the server selected the method
+	Authenticate(reader io.Reader, writer io.Writer) error
+}
+
+// NoAuth is used for the "No Authentication" mode
+type NoAuth struct{}
+
+// GetCode implement interface Authenticator
+func (NoAuth) GetCode() uint8 { return statute.MethodNoAuth }
+
+// Authenticate implement interface Authenticator
+func (NoAuth) Authenticate(io.Reader, io.Writer) error { return nil }
+
+// UserPassAuth is used for the username/password authentication
+type UserPassAuth struct {
+	User     string
+	Password string
+}
+
+// GetCode implement interface Authenticator
+func (UserPassAuth) GetCode() uint8 { return statute.MethodUserPassAuth }
+
+// Authenticate implement interface Authenticator
+func (a UserPassAuth) Authenticate(reader io.Reader, writer io.Writer) error {
+	_, err := writer.Write(statute.NewUserPassRequest(statute.UserPassAuthVersion,
+		[]byte(a.User), []byte(a.Password)).Bytes())
+	if err != nil {
+		return err
+	}
+	rsp, err := statute.ParseUserPassReply(reader)
+	if err != nil {
+		return err
+	}
+	if rsp.Ver != statute.UserPassAuthVersion {
+		return statute.ErrNotSupportMethod
+	}
+	if rsp.Status != statute.RepSuccess {
+		return statute.ErrUserAuthFailed
+	}
+	return nil
+}
+
+// ForwardedIdentityAuth is used by a front proxy to forward the identity of the original client
+// to the next hop, signed by the secret shared with the next hop for the nonce it challenges by,
+// see socks5.ForwardedIdentityAuthenticator. The connection to the next hop must be over TLS.
+type ForwardedIdentityAuth struct {
+	Secret []byte
+	// User of the original client, empty if not authenticated by a user
+	User string
+	// Client is the address of the original client, ip:port
+	Client string
+}
+
+// GetCode implement interface Authenticator
+func (ForwardedIdentityAuth) GetCode() uint8 { return statute.MethodForwardedIdentity }
+
+// Authenticate implement interface Authenticator
+func (a ForwardedIdentityAuth) Authenticate(reader io.Reader, writer io.Writer) error {
+	fc, err := statute.ParseForwardedChallenge(reader)
+	if err != nil {
+		return err
+	}
+	fi := statute.NewForwardedIdentity(a.Secret, fc.Nonce, a.User, a.Client, time.Now().Unix())
+	if _, err := writer.Write(fi.Bytes()); err != nil {
+		return err
+	}
+	rsp, err := statute.ParseUserPassReply(reader)
+	if err != nil {
+		return err
+	}
+	if rsp.Ver != statute.ForwardedIdentityVersion {
+		return statute.ErrNotSupportMethod
+	}
+	if rsp.Status != statute.AuthSuccess {
+		return statute.ErrUserAuthFailed
+	}
+	return nil
+}
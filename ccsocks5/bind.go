@@ -0,0 +1,53 @@
+package ccsocks5
+
+import (
+	"net"
+
+	"github.com/thinkgos/go-socks5/statute"
+)
+
+// Bind implement socks5 bind command
+type Bind struct {
+	*Client
+	bndAddress net.Addr
+}
+
+// Bind asks the proxy to listen for the incoming connection from the address,
+// BindAddr returns the listening address which should be told to the remote peer,
+// then Accept waits for the remote peer to connect.
+func (sf *Client) Bind(network, addr string) (*Bind, error) {
+	conn := *sf // clone a client
+
+	bndAddress, err := conn.handshake(network, statute.CommandBind, addr)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	ba, err := net.ResolveTCPAddr(network, bndAddress)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &Bind{&conn, ba}, nil
+}
+
+// BindAddr returns the address the proxy listens on for the remote peer.
+func (sf *Bind) BindAddr() net.Addr {
+	return sf.bndAddress
+}
+
+// Accept waits for the remote peer to connect, the returned conn relays with it.
+func (sf *Bind) Accept() (net.Conn, error) {
+	peerAddress, err := sf.reply()
+	if err != nil {
+		sf.Close()
+		return nil, err
+	}
+	ra, err := net.ResolveTCPAddr("tcp", peerAddress)
+	if err != nil {
+		sf.Close()
+		return nil, err
+	}
+	sf.Conn = &underConnect{TCPConn: sf.proxyConn.(*net.TCPConn), remoteAddress: ra}
+	return &Connect{sf.Client}, nil
+}
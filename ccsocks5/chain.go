@@ -0,0 +1,121 @@
+package ccsocks5
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+
+	"golang.org/x/net/proxy"
+
+	"github.com/thinkgos/go-socks5/statute"
+)
+
+// maxConnectResponse limits the header of the HTTP CONNECT response
+const maxConnectResponse = 8 * 1024
+
+// HopType the protocol of a hop of the chain
+type HopType uint8
+
+// hop types
+const (
+	// HopSocks5 a SOCKS5 proxy, asked by the CONNECT command
+	HopSocks5 HopType = iota
+	// HopHTTP an HTTP proxy, asked by the CONNECT method
+	HopHTTP
+)
+
+// String implement interface fmt.Stringer
+func (t HopType) String() string {
+	switch t {
+	case HopSocks5:
+		return "socks5"
+	case HopHTTP:
+		return "http"
+	}
+	return "HopType(" + strconv.Itoa(int(t)) + ")"
+}
+
+// Hop is a proxy of the chain the client tunnels through to reach its proxy
+type Hop struct {
+	Type HopType
+	Addr string
+	// Auth of the hop, the username and password of SOCKS5 or the basic auth of HTTP, nil if none
+	Auth *proxy.Auth
+}
+
+// dialChain connects to the first hop and asks each hop to connect to the next,
+// the last hop to the proxy, returns the connection to the first hop tunneled to the proxy.
+func (sf *Client) dialChain(network string) (net.Conn, error) {
+	conn, err := net.Dial(network, sf.chain[0].Addr)
+	if err != nil {
+		return nil, err
+	}
+	for i, hop := range sf.chain {
+		next := sf.proxyAddr
+		if i+1 < len(sf.chain) {
+			next = sf.chain[i+1].Addr
+		}
+		if err := hop.connect(conn, next); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("chain hop %d %s %s, %v", i, hop.Type, hop.Addr, err)
+		}
+	}
+	return conn, nil
+}
+
+// connect asks the hop to connect to the address, the conn is tunneled to the address if succeed
+func (sf Hop) connect(conn net.Conn, addr string) error {
+	switch sf.Type {
+	case HopSocks5:
+		c := Client{proxyConn: conn, auth: sf.Auth}
+		if err := c.negotiate(); err != nil {
+			return err
+		}
+		_, err := c.request(statute.CommandConnect, addr)
+		return err
+	case HopHTTP:
+		return sf.httpConnect(conn, addr)
+	}
+	return errors.New("not support hop type")
+}
+
+// httpConnect sends the CONNECT request and reads the response, the response is read byte by byte,
+// so nothing of the tunnel is consumed.
+func (sf Hop) httpConnect(conn net.Conn, addr string) error {
+	var req bytes.Buffer
+	req.WriteString("CONNECT " + addr + " HTTP/1.1\r\nHost: " + addr + "\r\n")
+	if sf.Auth != nil {
+		token := base64.StdEncoding.EncodeToString([]byte(sf.Auth.User + ":" + sf.Auth.Password))
+		req.WriteString("Proxy-Authorization: Basic " + token + "\r\n")
+	}
+	req.WriteString("\r\n")
+	if _, err := conn.Write(req.Bytes()); err != nil {
+		return err
+	}
+
+	head := make([]byte, 0, 128)
+	b := make([]byte, 1)
+	for !bytes.HasSuffix(head, []byte("\r\n\r\n")) {
+		if len(head) >= maxConnectResponse {
+			return errors.New("connect response too large")
+		}
+		if _, err := io.ReadFull(conn, b); err != nil {
+			return err
+		}
+		head = append(head, b[0])
+	}
+	rsp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(head)), nil)
+	if err != nil {
+		return err
+	}
+	if rsp.StatusCode < 200 || rsp.StatusCode > 299 {
+		return fmt.Errorf("connect response %s", rsp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package ccsocks5_test
+
+import (
+	"bufio"
+	"encoding/base64"
+	"io"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/proxy"
+
+	"github.com/thinkgos/go-socks5"
+	"github.com/thinkgos/go-socks5/ccsocks5"
+)
+
+// connectProxy returns the address of a HTTP CONNECT proxy, refusing without the basic auth
+func connectProxy(t *testing.T, user, password string) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				req, err := http.ReadRequest(bufio.NewReader(conn))
+				if err != nil || req.Method != http.MethodConnect {
+					return
+				}
+				token := base64.StdEncoding.EncodeToString([]byte(user + ":" + password))
+				if req.Header.Get("Proxy-Authorization") != "Basic "+token {
+					conn.Write([]byte("HTTP/1.1 407 Proxy Authentication Required\r\n\r\n")) // nolint: errcheck
+					return
+				}
+				target, err := net.Dial("tcp", req.Host)
+				if err != nil {
+					conn.Write([]byte("HTTP/1.1 502 Bad Gateway\r\n\r\n")) // nolint: errcheck
+					return
+				}
+				defer target.Close()
+				conn.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n")) // nolint: errcheck
+				go io.Copy(target, conn)                                          // nolint: errcheck
+				io.Copy(conn, target)                                             // nolint: errcheck
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+func socksProxy(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+	go socks5.NewServer().Serve(l) // nolint: errcheck
+	return l.Addr().String()
+}
+
+func TestChain(t *testing.T) {
+	proxyAddr, target, cs := warmProxy(t)
+	hop1 := socksProxy(t)
+	hop2 := connectProxy(t, "user", "pass")
+
+	c := ccsocks5.NewClient(proxyAddr,
+		ccsocks5.WithAuth(&proxy.Auth{User: "foo", Password: "bar"}),
+		ccsocks5.WithChain(
+			ccsocks5.Hop{Type: ccsocks5.HopSocks5, Addr: hop1},
+			ccsocks5.Hop{Type: ccsocks5.HopHTTP, Addr: hop2, Auth: &proxy.Auth{User: "user", Password: "pass"}},
+		))
+	ping(t, c, target)
+	ping(t, c, target)
+	require.Equal(t, int32(2), atomic.LoadInt32(&cs.count))
+}
+
+func TestChain_HopFailed(t *testing.T) {
+	proxyAddr, target, _ := warmProxy(t)
+	hop := connectProxy(t, "user", "pass")
+
+	c := ccsocks5.NewClient(proxyAddr,
+		ccsocks5.WithAuth(&proxy.Auth{User: "foo", Password: "bar"}),
+		ccsocks5.WithChain(ccsocks5.Hop{
+			Type: ccsocks5.HopHTTP, Addr: hop, Auth: &proxy.Auth{User: "user", Password: "wrong"},
+		}))
+	_, err := c.Dial("tcp", target)
+	require.EqualError(t, err, "chain hop 0 http "+hop+", connect response 407 Proxy Authentication Required")
+}
+
+func TestChain_UDP(t *testing.T) {
+	c := ccsocks5.NewClient("127.0.0.1:1080",
+		ccsocks5.WithChain(ccsocks5.Hop{Type: ccsocks5.HopSocks5, Addr: "127.0.0.1:1081"}))
+	_, err := c.Dial("udp", "127.0.0.1:53")
+	require.Error(t, err)
+}
+
+func TestHopType_String(t *testing.T) {
+	require.Equal(t, "socks5", ccsocks5.HopSocks5.String())
+	require.Equal(t, "http", ccsocks5.HopHTTP.String())
+	require.Equal(t, "HopType(9)", ccsocks5.HopType(9).String())
+}
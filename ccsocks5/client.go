@@ -15,11 +15,42 @@ import (
 type Client struct {
 	proxyAddr string
 	auth      *proxy.Auth
+	// auth methods offered to the server, in order of preference
+	authMethods []Authenticator
 	// On command UDP, let server control the tcp and udp connection relationship
 	proxyConn net.Conn
 	// real server connection udp/tcp
 	net.Conn
 	bufferPool bufferpool.BufPool
+	// parse the reply detail extension after a failure reply
+	replyDetail bool
+	// warm connections to the proxy, shared by the clones
+	warm        *warmPool
+	warmSize    int
+	warmMaxIdle time.Duration
+	// compress requests the compression of the CONNECT payload at the level,
+	// compressed is set if the server accepted it.
+	compress      bool
+	compressLevel int
+	compressed    bool
+	// proxies tunneled through in order to reach the proxy, none if dialed directly
+	chain []Hop
+}
+
+// ReplyError is returned when the server reply a failure.
+type ReplyError struct {
+	// Rep reply status see statute's statute file
+	Rep uint8
+	// Detail the reply detail extension provided by server, if any
+	Detail string
+}
+
+// Error implement interface error
+func (e *ReplyError) Error() string {
+	if e.Detail != "" {
+		return statute.RepText(e.Rep) + ", " + e.Detail
+	}
+	return statute.RepText(e.Rep)
 }
 
 // NewClient This is just create a client.
@@ -32,9 +63,20 @@ func NewClient(proxyAddr string, opts ...Option) *Client {
 	for _, opt := range opts {
 		opt(c)
 	}
+	if c.warmSize > 0 {
+		c.warm = newWarmPool(c, c.warmSize, c.warmMaxIdle)
+		c.warm.mu.Lock()
+		c.warm.refill()
+		c.warm.mu.Unlock()
+	}
 	return c
 }
 
+// CloseIdleConnections closes the warm connections to the proxy and stops keeping them.
+func (sf *Client) CloseIdleConnections() {
+	sf.warm.close()
+}
+
 // Close closes the connection.
 func (sf *Client) Close() (err error) {
 	if sf.proxyConn != nil {
@@ -65,36 +107,35 @@ func (sf *Client) DialTCP(network, addr string) (net.Conn, error) {
 	if err != nil {
 		return nil, err
 	}
-	conn.proxyConn, err = net.Dial(network, sf.proxyAddr)
-	if err != nil {
-		return nil, err
-	}
-
-	if _, err := conn.handshake(statute.CommandConnect, addr); err != nil {
+	if _, err := conn.handshake(network, statute.CommandConnect, addr); err != nil {
 		conn.Close()
 		return nil, err
 	}
-	conn.Conn = &underConnect{
-		conn.proxyConn.(*net.TCPConn),
-		remoteAddress,
+	uc := &underConnect{TCPConn: conn.proxyConn.(*net.TCPConn), remoteAddress: remoteAddress}
+	if conn.compressed {
+		if err := uc.compress(conn.compressLevel); err != nil {
+			conn.Close()
+			return nil, err
+		}
 	}
+	conn.Conn = uc
 	return &Connect{&conn}, nil
 }
 
 // DialUDP connects to the address on the named network through proxy , with socks5 handshake.
 func (sf *Client) DialUDP(network string, laddr *net.UDPAddr, raddr string) (net.Conn, error) {
+	if len(sf.chain) > 0 {
+		return nil, errors.New("not support udp through the chain")
+	}
 	conn := *sf // clone a client
 
 	remoteAddress, err := net.ResolveUDPAddr(network, raddr)
 	if err != nil {
 		return nil, err
 	}
-	conn.proxyConn, err = net.Dial("tcp", sf.proxyAddr)
-	if err != nil {
-		return nil, err
-	}
-	bndAddress, err := conn.handshake(statute.CommandAssociate, raddr)
+	bndAddress, err := conn.handshake("tcp", statute.CommandAssociate, raddr)
 	if err != nil {
+		conn.Close()
 		return nil, err
 	}
 
@@ -126,47 +167,84 @@ func (sf *Client) DialUDP(network string, laddr *net.UDPAddr, raddr string) (net
 	return &Associate{&conn}, nil
 }
 
-func (sf *Client) handshake(command byte, addr string) (string, error) {
-	methods := statute.MethodNoAuth
-	if sf.auth != nil {
-		methods = statute.MethodUserPassAuth
+// handshake connects to the proxy and sends the request, returns the bind address of the reply.
+// A warm connection is taken if any, and a new connection is tried once if the warm one is broken.
+func (sf *Client) handshake(network string, command byte, addr string) (string, error) {
+	if conn := sf.warm.get(); conn != nil {
+		sf.proxyConn = conn
+		bnd, err := sf.request(command, addr)
+		var re *ReplyError
+		if err == nil || errors.As(err, &re) {
+			return bnd, err
+		}
+		conn.Close()
+		sf.proxyConn = nil
 	}
-
-	_, err := sf.proxyConn.Write(statute.NewMethodRequest(statute.VersionSocks5, []byte{methods}).Bytes())
-	if err != nil {
-		return "", err
-	}
-	reply, err := statute.ParseMethodReply(sf.proxyConn)
+	conn, err := sf.dialProxy(network)
 	if err != nil {
 		return "", err
 	}
+	sf.proxyConn = conn
+	return sf.request(command, addr)
+}
 
-	if reply.Ver != statute.VersionSocks5 {
-		return "", statute.ErrNotSupportVersion
+// dialProxy connects to the proxy through the chain if any, negotiates the auth method and authenticates
+func (sf *Client) dialProxy(network string) (net.Conn, error) {
+	c := *sf
+	var conn net.Conn
+	var err error
+	if len(sf.chain) > 0 {
+		conn, err = sf.dialChain(network)
+	} else {
+		conn, err = net.Dial(network, sf.proxyAddr)
+	}
+	if err != nil {
+		return nil, err
 	}
-	if reply.Method != methods {
-		return "", statute.ErrNotSupportMethod
+	c.proxyConn = conn
+	if err := c.negotiate(); err != nil {
+		conn.Close()
+		return nil, err
 	}
+	return conn, nil
+}
 
-	if methods == statute.MethodUserPassAuth {
-		_, err = sf.proxyConn.Write(statute.NewUserPassRequest(statute.UserPassAuthVersion,
-			[]byte(sf.auth.User), []byte(sf.auth.Password)).Bytes())
-		if err != nil {
-			return "", err
+// negotiate the auth method and authenticate
+func (sf *Client) negotiate() error {
+	cators := sf.authMethods
+	if len(cators) == 0 {
+		if sf.auth != nil {
+			cators = []Authenticator{UserPassAuth{User: sf.auth.User, Password: sf.auth.Password}}
+		} else {
+			cators = []Authenticator{NoAuth{}}
 		}
+	}
+	methods := make([]byte, 0, len(cators))
+	for _, cator := range cators {
+		methods = append(methods, cator.GetCode())
+	}
 
-		rsp, err := statute.ParseUserPassReply(sf.proxyConn)
-		if err != nil {
-			return "", err
-		}
-		if rsp.Ver != statute.UserPassAuthVersion {
-			return "", statute.ErrNotSupportMethod
-		}
-		if rsp.Status != statute.RepSuccess {
-			return "", statute.ErrUserAuthFailed
+	_, err := sf.proxyConn.Write(statute.NewMethodRequest(statute.VersionSocks5, methods).Bytes())
+	if err != nil {
+		return err
+	}
+	reply, err := statute.ParseMethodReply(sf.proxyConn)
+	if err != nil {
+		return err
+	}
+	if reply.Ver != statute.VersionSocks5 {
+		return statute.ErrNotSupportVersion
+	}
+	for _, cator := range cators {
+		if cator.GetCode() == reply.Method {
+			return cator.Authenticate(sf.proxyConn, sf.proxyConn)
 		}
 	}
+	return statute.ErrNotSupportMethod
+}
 
+// request send the request and returns the bind address of the reply
+func (sf *Client) request(command byte, addr string) (string, error) {
 	a, err := statute.ParseAddrSpec(addr)
 	if err != nil {
 		return "", err
@@ -176,17 +254,31 @@ func (sf *Client) handshake(command byte, addr string) (string, error) {
 		Command: command,
 		DstAddr: a,
 	}
+	if sf.compress && command == statute.CommandConnect {
+		reqHead.Reserved = statute.FlagCompress
+	}
 	if _, err := sf.proxyConn.Write(reqHead.Bytes()); err != nil {
 		return "", err
 	}
+	return sf.reply()
+}
 
+// reply parse the reply and returns the bind address
+func (sf *Client) reply() (string, error) {
 	rspHead, err := statute.ParseReply(sf.proxyConn)
 	if err != nil {
 		return "", err
 	}
 	if rspHead.Response != statute.RepSuccess {
-		return "", errors.New("host unreachable")
+		rErr := &ReplyError{Rep: rspHead.Response}
+		if sf.replyDetail {
+			if rd, err := statute.ParseReplyDetail(sf.proxyConn); err == nil {
+				rErr.Detail = rd.Detail
+			}
+		}
+		return "", rErr
 	}
+	sf.compressed = sf.compress && rspHead.Reserved&statute.FlagCompress != 0
 	return rspHead.BndAddr.String(), nil
 }
 
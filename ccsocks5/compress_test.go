@@ -0,0 +1,79 @@
+package ccsocks5_test
+
+import (
+	"bytes"
+	"compress/flate"
+	"io/ioutil"
+	"net"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/thinkgos/go-socks5"
+	"github.com/thinkgos/go-socks5/ccsocks5"
+)
+
+// countListener counts the bytes read from the accepted connections
+type countListener struct {
+	net.Listener
+	n *int64
+}
+
+func (sf countListener) Accept() (net.Conn, error) {
+	conn, err := sf.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &countConn{conn, sf.n}, nil
+}
+
+type countConn struct {
+	net.Conn
+	n *int64
+}
+
+func (sf *countConn) Read(p []byte) (int, error) {
+	n, err := sf.Conn.Read(p)
+	atomic.AddInt64(sf.n, int64(n))
+	return n, err
+}
+
+func (sf *countConn) CloseWrite() error {
+	return sf.Conn.(*net.TCPConn).CloseWrite()
+}
+
+func TestClient_Compression(t *testing.T) {
+	_, target, _ := warmProxy(t)
+	payload := bytes.Repeat([]byte("compressible "), 8192)
+
+	for _, tt := range []struct {
+		name       string
+		opts       []socks5.Option
+		compressed bool
+	}{
+		{"accepted", []socks5.Option{socks5.WithCompression(flate.BestSpeed)}, true},
+		{"not enabled", nil, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var received int64
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			require.NoError(t, err)
+			defer l.Close()
+			go socks5.NewServer(tt.opts...).Serve(countListener{l, &received}) // nolint: errcheck
+
+			c := ccsocks5.NewClient(l.Addr().String(), ccsocks5.WithCompression(flate.BestSpeed))
+			conn, err := c.Dial("tcp", target)
+			require.NoError(t, err)
+			defer conn.Close()
+			go func() {
+				conn.(*ccsocks5.Connect).ReadFrom(bytes.NewReader(payload)) // nolint: errcheck
+				conn.(*ccsocks5.Connect).CloseWrite()                       // nolint: errcheck
+			}()
+			echoed, err := ioutil.ReadAll(conn)
+			require.NoError(t, err)
+			require.Equal(t, payload, echoed)
+			require.Equal(t, tt.compressed, atomic.LoadInt64(&received) < int64(len(payload)/10))
+		})
+	}
+}
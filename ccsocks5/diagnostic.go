@@ -0,0 +1,40 @@
+package ccsocks5
+
+import (
+	"github.com/thinkgos/go-socks5/statute"
+)
+
+// Diagnosis is the result of the diagnostic probe
+type Diagnosis struct {
+	// Version of the server
+	Version string
+	// Method is the auth method negotiated
+	Method byte
+	// User authenticated, empty if not authenticated by a user
+	User string
+	// ClientAddr is the address of the client observed by the server
+	ClientAddr string
+}
+
+// Diagnose probes the server by the vendor diagnostic command, which reports the version of
+// the server, the negotiated auth method and the client address the server observed.
+// The server must enable it, see socks5.WithDiagnostic.
+func (sf *Client) Diagnose() (*Diagnosis, error) {
+	conn := *sf // clone a client
+	defer conn.Close()
+
+	bnd, err := conn.handshake("tcp", statute.CommandDiagnostic, "0.0.0.0:0")
+	if err != nil {
+		return nil, err
+	}
+	d, err := statute.ParseDiagnostic(conn.proxyConn)
+	if err != nil {
+		return nil, err
+	}
+	return &Diagnosis{
+		Version:    d.Version,
+		Method:     d.Method,
+		User:       d.User,
+		ClientAddr: bnd,
+	}, nil
+}
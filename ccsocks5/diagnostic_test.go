@@ -0,0 +1,45 @@
+package ccsocks5_test
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/proxy"
+
+	"github.com/thinkgos/go-socks5"
+	"github.com/thinkgos/go-socks5/ccsocks5"
+	"github.com/thinkgos/go-socks5/statute"
+)
+
+func TestClient_Diagnose(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+	go socks5.NewServer( // nolint: errcheck
+		socks5.WithCredential(socks5.StaticCredentials{"foo": "bar"}),
+		socks5.WithRule(socks5.NewPermitNone()),
+		socks5.WithDiagnostic("v1.2.3"),
+	).Serve(l)
+
+	c := ccsocks5.NewClient(l.Addr().String(), ccsocks5.WithAuth(&proxy.Auth{User: "foo", Password: "bar"}))
+	d, err := c.Diagnose()
+	require.NoError(t, err)
+	require.Equal(t, "v1.2.3", d.Version)
+	require.Equal(t, statute.MethodUserPassAuth, d.Method)
+	require.Equal(t, "foo", d.User)
+	host, _, err := net.SplitHostPort(d.ClientAddr)
+	require.NoError(t, err)
+	require.Equal(t, "127.0.0.1", host)
+
+	// not enabled
+	proxyAddr, _, _ := warmProxy(t)
+	_, err = ccsocks5.NewClient(proxyAddr, ccsocks5.WithAuth(&proxy.Auth{User: "foo", Password: "bar"})).Diagnose()
+	require.Equal(t, &ccsocks5.ReplyError{Rep: statute.RepCommandNotSupported}, err)
+}
+
+func TestReplyError(t *testing.T) {
+	require.EqualError(t, &ccsocks5.ReplyError{Rep: statute.RepConnectionRefused}, "connection refused")
+	require.EqualError(t, &ccsocks5.ReplyError{Rep: statute.RepRuleFailure, Detail: statute.DetailRuleDenied},
+		"connection not allowed by ruleset, rule_denied")
+}
@@ -0,0 +1,24 @@
+package ccsocks5
+
+import "net"
+
+// WarmIdle returns the number of the idle connections of the warm pool of the client
+func WarmIdle(c *Client) int {
+	c.warm.mu.Lock()
+	defer c.warm.mu.Unlock()
+	return len(c.warm.conns)
+}
+
+// WarmConns returns the idle connections of the warm pool of the client
+func WarmConns(c *Client) []net.Conn {
+	c.warm.mu.Lock()
+	defer c.warm.mu.Unlock()
+	conns := make([]net.Conn, 0, len(c.warm.conns))
+	for _, wc := range c.warm.conns {
+		conns = append(conns, wc.conn)
+	}
+	return conns
+}
+
+// WarmGet takes an idle connection of the warm pool of the client, nil if none
+func WarmGet(c *Client) net.Conn { return c.warm.get() }
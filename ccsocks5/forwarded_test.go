@@ -0,0 +1,74 @@
+package ccsocks5_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/proxy"
+
+	"github.com/thinkgos/go-socks5"
+	"github.com/thinkgos/go-socks5/ccsocks5"
+)
+
+type recordRule struct {
+	req chan *socks5.Request
+}
+
+func (sf recordRule) Allow(ctx context.Context, req *socks5.Request) (context.Context, bool) {
+	sf.req <- req
+	return ctx, true
+}
+
+func TestForwardedIdentityAuth(t *testing.T) {
+	secret := []byte("shared secret")
+	_, target, _ := warmProxy(t)
+
+	// the second hop trusts the identity forwarded by the front proxy
+	rule := recordRule{make(chan *socks5.Request, 1)}
+	next, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { next.Close() })
+	go socks5.NewServer( // nolint: errcheck
+		socks5.WithAuthMethods([]socks5.Authenticator{socks5.ForwardedIdentityAuthenticator{Secret: secret}}),
+		socks5.WithRule(rule),
+	).Serve(next)
+
+	front, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { front.Close() })
+	go socks5.NewServer( // nolint: errcheck
+		socks5.WithCredential(socks5.StaticCredentials{"foo": "bar"}),
+		socks5.WithDialer(socks5.DialFunc(func(ctx context.Context, network, addr string) (net.Conn, error) {
+			req, _ := socks5.RequestFromContext(ctx)
+			return ccsocks5.NewClient(next.Addr().String(), ccsocks5.WithAuthMethods(ccsocks5.ForwardedIdentityAuth{
+				Secret: secret, User: req.AuthContext.Payload["username"], Client: req.RemoteAddr.String(),
+			})).Dial(network, addr)
+		})),
+	).Serve(front)
+
+	c := ccsocks5.NewClient(front.Addr().String(), ccsocks5.WithAuth(&proxy.Auth{User: "foo", Password: "bar"}))
+	conn, err := c.Dial("tcp", target)
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("ping"))
+	require.NoError(t, err)
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(conn, buf)
+	require.NoError(t, err)
+	require.Equal(t, "ping", string(buf))
+
+	req := <-rule.req
+	require.Equal(t, conn.LocalAddr().String(), req.RemoteAddr.String())
+	require.Equal(t, "foo", req.AuthContext.Payload["username"])
+	require.NotNil(t, req.ForwardedBy)
+
+	// the wrong secret is refused
+	c = ccsocks5.NewClient(next.Addr().String(), ccsocks5.WithAuthMethods(ccsocks5.ForwardedIdentityAuth{
+		Secret: []byte("wrong"), User: "foo", Client: "10.0.0.1:1000",
+	}))
+	_, err = c.Dial("tcp", target)
+	require.Error(t, err)
+}
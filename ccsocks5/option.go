@@ -1,6 +1,8 @@
 package ccsocks5
 
 import (
+	"time"
+
 	"golang.org/x/net/proxy"
 
 	"github.com/thinkgos/go-socks5/bufferpool"
@@ -17,6 +19,14 @@ func WithAuth(auth *proxy.Auth) Option {
 	}
 }
 
+// WithAuthMethods with the auth methods offered to the server, in order of preference,
+// it takes precedence over WithAuth.
+func WithAuthMethods(methods ...Authenticator) Option {
+	return func(c *Client) {
+		c.authMethods = methods
+	}
+}
+
 // WithBufferPool with buffer pool
 // default: 32k
 func WithBufferPool(p bufferpool.BufPool) Option {
@@ -24,3 +34,42 @@ func WithBufferPool(p bufferpool.BufPool) Option {
 		c.bufferPool = p
 	}
 }
+
+// WithWarmPool keeps size connections to the proxy negotiated and authenticated in advance,
+// so the first request after idle does not pay the cost of the handshake. The warm
+// connections idle longer than maxIdle are dropped, set it below the proxy's timeout
+// of the request, 0 means no limit. Call Client.CloseIdleConnections to stop.
+func WithWarmPool(size int, maxIdle time.Duration) Option {
+	return func(c *Client) {
+		c.warmSize = size
+		c.warmMaxIdle = maxIdle
+	}
+}
+
+// WithReplyDetail parse the reply detail extension after a failure reply,
+// the server must enable it too, see socks5.WithReplyDetail.
+func WithReplyDetail() Option {
+	return func(c *Client) {
+		c.replyDetail = true
+	}
+}
+
+// WithCompression requests the DEFLATE compression of the CONNECT payload at the level of
+// compress/flate, such as flate.BestSpeed, the payload is compressed only if the server
+// accepts it, see socks5.WithCompression. The data must not be sent before the reply.
+func WithCompression(level int) Option {
+	return func(c *Client) {
+		c.compress = true
+		c.compressLevel = level
+	}
+}
+
+// WithChain tunnels through the proxies in order to reach the proxy of the client, the client
+// connects to the first hop, asks each hop to CONNECT to the next one and the last hop to the
+// proxy, so a multi-hop topology is built by the nested handshakes. The UDP is not supported
+// through the chain, as the datagrams are not tunneled.
+func WithChain(hops ...Hop) Option {
+	return func(c *Client) {
+		c.chain = hops
+	}
+}
@@ -1,6 +1,8 @@
 package ccsocks5
 
 import (
+	"compress/flate"
+	"io"
 	"net"
 
 	"github.com/thinkgos/go-socks5/bufferpool"
@@ -11,6 +13,38 @@ import (
 type underConnect struct {
 	*net.TCPConn
 	remoteAddress net.Addr // real remote address, not the proxy address
+	// the compressed payload, nil if not negotiated
+	fr io.ReadCloser
+	fw *flate.Writer
+}
+
+// compress the payload by the DEFLATE at the level
+func (sf *underConnect) compress(level int) (err error) {
+	if sf.fw, err = flate.NewWriter(sf.TCPConn, level); err != nil {
+		return err
+	}
+	sf.fr = flate.NewReader(sf.TCPConn)
+	return nil
+}
+
+// Read implements the Conn Read method.
+func (sf *underConnect) Read(b []byte) (int, error) {
+	if sf.fr != nil {
+		return sf.fr.Read(b)
+	}
+	return sf.TCPConn.Read(b)
+}
+
+// Write implements the Conn Write method, the compressed data are flushed on each write.
+func (sf *underConnect) Write(b []byte) (int, error) {
+	if sf.fw == nil {
+		return sf.TCPConn.Write(b)
+	}
+	n, err := sf.fw.Write(b)
+	if err == nil {
+		err = sf.fw.Flush()
+	}
+	return n, err
 }
 
 // RemoteAddr returns the remote network address.
@@ -0,0 +1,102 @@
+package ccsocks5
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// warmConn is a connection to the proxy negotiated and authenticated, waiting for the request
+type warmConn struct {
+	conn  net.Conn
+	since time.Time
+}
+
+// warmPool keeps a small pool of warm connections to the proxy, so the request
+// after idle does not pay the cost of the connection and the authentication.
+type warmPool struct {
+	client  Client // the template to negotiate
+	size    int
+	maxIdle time.Duration
+	mu      sync.Mutex
+	conns   []warmConn
+	filling bool
+	closed  bool
+}
+
+func newWarmPool(c *Client, size int, maxIdle time.Duration) *warmPool {
+	p := &warmPool{client: *c, size: size, maxIdle: maxIdle}
+	p.client.warm = nil
+	return p
+}
+
+// get takes a warm connection, nil if none, and refills the pool in background.
+// The connections idle longer than the max idle are closed,
+// as the proxy may have timed out them.
+func (sf *warmPool) get() net.Conn {
+	if sf == nil {
+		return nil
+	}
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	var conn net.Conn
+	for len(sf.conns) > 0 && conn == nil {
+		wc := sf.conns[0]
+		sf.conns = sf.conns[1:]
+		if sf.maxIdle > 0 && time.Since(wc.since) > sf.maxIdle {
+			wc.conn.Close()
+			continue
+		}
+		conn = wc.conn
+	}
+	sf.refill()
+	return conn
+}
+
+// refill starts filling the pool in background if not yet, must be called with the lock held.
+func (sf *warmPool) refill() {
+	if sf.filling || sf.closed || len(sf.conns) >= sf.size {
+		return
+	}
+	sf.filling = true
+	go sf.fill()
+}
+
+func (sf *warmPool) fill() {
+	for {
+		sf.mu.Lock()
+		if sf.closed || len(sf.conns) >= sf.size {
+			sf.filling = false
+			sf.mu.Unlock()
+			return
+		}
+		sf.mu.Unlock()
+
+		conn, err := sf.client.dialProxy("tcp")
+		sf.mu.Lock()
+		if err != nil || sf.closed {
+			sf.filling = false
+			sf.mu.Unlock()
+			if conn != nil {
+				conn.Close()
+			}
+			return
+		}
+		sf.conns = append(sf.conns, warmConn{conn, time.Now()})
+		sf.mu.Unlock()
+	}
+}
+
+// close closes the warm connections and stops filling
+func (sf *warmPool) close() {
+	if sf == nil {
+		return
+	}
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	sf.closed = true
+	for _, wc := range sf.conns {
+		wc.conn.Close()
+	}
+	sf.conns = nil
+}
@@ -0,0 +1,103 @@
+package ccsocks5_test
+
+import (
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/proxy"
+
+	"github.com/thinkgos/go-socks5"
+	"github.com/thinkgos/go-socks5/ccsocks5"
+)
+
+type countCredentials struct {
+	count int32
+}
+
+func (sf *countCredentials) Valid(user, password, _ string) bool {
+	atomic.AddInt32(&sf.count, 1)
+	return user == "foo" && password == "bar"
+}
+
+// warmProxy returns the address of the proxy authenticating by cs, and the address of an echo target
+func warmProxy(t *testing.T) (string, string, *countCredentials) {
+	cs := &countCredentials{}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+	go socks5.NewServer(socks5.WithCredential(cs)).Serve(l) // nolint: errcheck
+
+	target, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { target.Close() })
+	go func() {
+		for {
+			conn, err := target.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn) // nolint: errcheck
+			}()
+		}
+	}()
+	return l.Addr().String(), target.Addr().String(), cs
+}
+
+func ping(t *testing.T, c *ccsocks5.Client, target string) {
+	conn, err := c.Dial("tcp", target)
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("ping"))
+	require.NoError(t, err)
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(conn, buf)
+	require.NoError(t, err)
+	require.Equal(t, "ping", string(buf))
+}
+
+func TestWarmPool(t *testing.T) {
+	proxyAddr, target, cs := warmProxy(t)
+
+	c := ccsocks5.NewClient(proxyAddr, ccsocks5.WithAuth(&proxy.Auth{User: "foo", Password: "bar"}),
+		ccsocks5.WithWarmPool(2, 0))
+	defer c.CloseIdleConnections()
+	require.Eventually(t, func() bool { return ccsocks5.WarmIdle(c) == 2 }, time.Second, 10*time.Millisecond)
+	require.Equal(t, int32(2), atomic.LoadInt32(&cs.count))
+
+	ping(t, c, target)
+	// the request took a warm connection, which is refilled in background
+	require.Eventually(t, func() bool { return ccsocks5.WarmIdle(c) == 2 }, time.Second, 10*time.Millisecond)
+	require.Equal(t, int32(3), atomic.LoadInt32(&cs.count))
+
+	// a broken warm connection falls back to a new one
+	for _, conn := range ccsocks5.WarmConns(c) {
+		conn.Close()
+	}
+	ping(t, c, target)
+
+	c.CloseIdleConnections()
+	require.Equal(t, 0, ccsocks5.WarmIdle(c))
+	ping(t, c, target)
+}
+
+func TestWarmPool_MaxIdle(t *testing.T) {
+	proxyAddr, target, _ := warmProxy(t)
+
+	c := ccsocks5.NewClient(proxyAddr, ccsocks5.WithAuth(&proxy.Auth{User: "foo", Password: "bar"}),
+		ccsocks5.WithWarmPool(1, 50*time.Millisecond))
+	defer c.CloseIdleConnections()
+	require.Eventually(t, func() bool { return ccsocks5.WarmIdle(c) == 1 }, time.Second, 10*time.Millisecond)
+	stale := ccsocks5.WarmConns(c)[0]
+	time.Sleep(100 * time.Millisecond)
+
+	require.Nil(t, ccsocks5.WarmGet(c))
+	_, err := stale.Write([]byte{0})
+	require.Error(t, err)
+	ping(t, c, target)
+}
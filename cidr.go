@@ -0,0 +1,122 @@
+package socks5
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync/atomic"
+)
+
+// CIDRRule allows or denies the client addresses within the network
+type CIDRRule struct {
+	Network *net.IPNet
+	Allow   bool
+}
+
+// ParseCIDRRules parses the allow and deny lists of CIDR, such as "10.0.0.0/8" or "::1/128",
+// a bare ip is taken as the single address network.
+func ParseCIDRRules(allow, deny []string) ([]CIDRRule, error) {
+	rules := make([]CIDRRule, 0, len(allow)+len(deny))
+	for _, list := range []struct {
+		cidrs []string
+		allow bool
+	}{{allow, true}, {deny, false}} {
+		for _, s := range list.cidrs {
+			network, err := parseCIDR(s)
+			if err != nil {
+				return nil, err
+			}
+			rules = append(rules, CIDRRule{network, list.allow})
+		}
+	}
+	return rules, nil
+}
+
+func parseCIDR(s string) (*net.IPNet, error) {
+	if ip := net.ParseIP(s); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, nil
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+	}
+	_, network, err := net.ParseCIDR(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cidr %s, %v", s, err)
+	}
+	return network, nil
+}
+
+// cidrRules is the compiled rules, replaced as a whole on update
+type cidrRules struct {
+	allow []*net.IPNet
+	deny  []*net.IPNet
+}
+
+// CIDRFilter is the connection-level access control of the client address by CIDR rules.
+// A client within any deny network is rejected, otherwise it must be within an allow
+// network if there is any allow rule. It is an AcceptFilter rejecting the clients before
+// the handshake, and also a RuleSet for the requests.
+type CIDRFilter struct {
+	rules atomic.Value // *cidrRules
+}
+
+// NewCIDRFilter new CIDR filter with the rules
+func NewCIDRFilter(rules []CIDRRule) *CIDRFilter {
+	sf := &CIDRFilter{}
+	sf.Update(rules)
+	return sf
+}
+
+// Update replaces the rules at runtime, the connections already accepted are not affected.
+func (sf *CIDRFilter) Update(rules []CIDRRule) {
+	cr := &cidrRules{}
+	for _, r := range rules {
+		if r.Network == nil {
+			continue
+		}
+		if r.Allow {
+			cr.allow = append(cr.allow, r.Network)
+		} else {
+			cr.deny = append(cr.deny, r.Network)
+		}
+	}
+	sf.rules.Store(cr)
+}
+
+// Permit reports whether the client ip is allowed, and the network decided it, nil if no network matched
+func (sf *CIDRFilter) Permit(ip net.IP) (bool, *net.IPNet) {
+	if ip == nil {
+		return false, nil
+	}
+	cr := sf.rules.Load().(*cidrRules)
+	for _, network := range cr.deny {
+		if network.Contains(ip) {
+			return false, network
+		}
+	}
+	if len(cr.allow) == 0 {
+		return true, nil
+	}
+	for _, network := range cr.allow {
+		if network.Contains(ip) {
+			return true, network
+		}
+	}
+	return false, nil
+}
+
+// Accept implement interface AcceptFilter
+func (sf *CIDRFilter) Accept(_, remote net.Addr) (string, bool) {
+	ok, _ := sf.Permit(addrIP(remote))
+	return "", ok
+}
+
+// Allow implement interface RuleSet
+func (sf *CIDRFilter) Allow(ctx context.Context, req *Request) (context.Context, bool) {
+	ok, network := sf.Permit(addrIP(req.RemoteAddr))
+	d := RuleDecision{Rule: "cidr", Allow: ok, Reason: "no cidr matched"}
+	if network != nil {
+		d.Reason = "matched " + network.String()
+	}
+	return WithRuleDecision(ctx, d), ok
+}
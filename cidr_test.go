@@ -0,0 +1,52 @@
+package socks5
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCIDRFilter(t *testing.T) {
+	rules, err := ParseCIDRRules([]string{"10.0.0.0/8", "::1"}, []string{"10.1.0.0/16"})
+	require.NoError(t, err)
+	f := NewCIDRFilter(rules)
+
+	accept := func(ip string) bool {
+		_, ok := f.Accept(nil, &net.TCPAddr{IP: net.ParseIP(ip), Port: 1080})
+		return ok
+	}
+	require.True(t, accept("10.2.3.4"))
+	require.True(t, accept("::ffff:10.2.3.4"))
+	require.True(t, accept("::1"))
+	require.False(t, accept("10.1.2.3"))
+	require.False(t, accept("192.168.1.1"))
+
+	ctx, ok := f.Allow(context.Background(), &Request{RemoteAddr: &net.TCPAddr{IP: net.ParseIP("10.1.2.3")}})
+	require.False(t, ok)
+	d, _ := RuleDecisionFromContext(ctx)
+	require.Equal(t, "matched 10.1.0.0/16", d.Reason)
+
+	// runtime update, no allow rule means any client not denied
+	rules, err = ParseCIDRRules(nil, []string{"10.0.0.0/8"})
+	require.NoError(t, err)
+	f.Update(rules)
+	require.True(t, accept("192.168.1.1"))
+	require.False(t, accept("10.2.3.4"))
+
+	_, err = ParseCIDRRules([]string{"10.0.0.0/33"}, nil)
+	require.Error(t, err)
+}
+
+func TestServer_CIDRFilter(t *testing.T) {
+	rules, err := ParseCIDRRules(nil, []string{"127.0.0.0/8"})
+	require.NoError(t, err)
+	proxy := serveSocks(t, WithAcceptFilter(NewCIDRFilter(rules)))
+
+	conn, err := net.Dial("tcp", proxy.String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Read(make([]byte, 1))
+	require.Error(t, err)
+}
@@ -0,0 +1,14 @@
+package socks5
+
+import (
+	"github.com/thinkgos/go-socks5/ccsocks5"
+)
+
+// Client is the SOCKS5 client of CONNECT, BIND and UDP ASSOCIATE with the pluggable auth methods,
+// see the ccsocks5 package for the options.
+type Client = ccsocks5.Client
+
+// NewClient returns the client of the proxy, the same as ccsocks5.NewClient
+func NewClient(proxyAddr string, opts ...ccsocks5.Option) *Client {
+	return ccsocks5.NewClient(proxyAddr, opts...)
+}
@@ -0,0 +1,23 @@
+package socks5
+
+import (
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewClient(t *testing.T) {
+	target := echoTarget(t)
+	proxy := serveSocks(t)
+
+	conn, err := NewClient(proxy.String()).Dial("tcp", target.String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("ping"))
+	require.NoError(t, err)
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(conn, buf)
+	require.NoError(t, err)
+	require.Equal(t, []byte("ping"), buf)
+}
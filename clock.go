@@ -0,0 +1,75 @@
+package socks5
+
+import (
+	"context"
+	"time"
+)
+
+// Clock is the source of time of the timeouts, rate limits, quotas and idle tracking,
+// such as a fake clock to test the time-dependent behavior deterministically,
+// or a virtual clock in simulations. The socket deadlines always use the system time.
+type Clock interface {
+	Now() time.Time
+	// NewTimer creates a Timer fires once after at least the duration d
+	NewTimer(d time.Duration) Timer
+	// NewTicker creates a Ticker fires every duration d
+	NewTicker(d time.Duration) Ticker
+}
+
+// Timer is the timer created by the Clock
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+}
+
+// Ticker is the ticker created by the Clock
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// SystemClock is the Clock of the system time
+type SystemClock struct{}
+
+// Now implement interface Clock
+func (SystemClock) Now() time.Time { return time.Now() }
+
+// NewTimer implement interface Clock
+func (SystemClock) NewTimer(d time.Duration) Timer { return systemTimer{time.NewTimer(d)} }
+
+// NewTicker implement interface Clock
+func (SystemClock) NewTicker(d time.Duration) Ticker { return systemTicker{time.NewTicker(d)} }
+
+type systemTimer struct{ *time.Timer }
+
+func (sf systemTimer) C() <-chan time.Time { return sf.Timer.C }
+
+type systemTicker struct{ *time.Ticker }
+
+func (sf systemTicker) C() <-chan time.Time { return sf.Ticker.C }
+
+// clockOrSystem returns the clock, the system clock if nil
+func clockOrSystem(c Clock) Clock {
+	if c == nil {
+		return SystemClock{}
+	}
+	return c
+}
+
+// sleepContext waits the duration d on the clock, or until the context is done.
+func sleepContext(ctx context.Context, c Clock, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	timer := c.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C():
+		return nil
+	}
+}
+
+// since returns the time elapsed since t on the clock of the server
+func (sf *Server) since(t time.Time) time.Duration { return sf.clock.Now().Sub(t) }
@@ -0,0 +1,146 @@
+package socks5
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// manualClock is a fake clock advanced by the test
+type manualClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*manualTimer
+}
+
+type manualTimer struct {
+	clock  *manualClock
+	c      chan time.Time
+	when   time.Time
+	period time.Duration
+}
+
+func newManualClock() *manualClock {
+	return &manualClock{now: time.Unix(1600000000, 0)}
+}
+
+func (c *manualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *manualClock) NewTimer(d time.Duration) Timer { return c.add(d, 0) }
+
+func (c *manualClock) NewTicker(d time.Duration) Ticker { return manualTicker{c.add(d, d)} }
+
+func (c *manualClock) add(d, period time.Duration) *manualTimer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &manualTimer{clock: c, c: make(chan time.Time, 1), when: c.now.Add(d), period: period}
+	c.timers = append(c.timers, t)
+	return t
+}
+
+// Advance moves the clock forward and fires the timers due
+func (c *manualClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	timers := c.timers[:0]
+	for _, t := range c.timers {
+		if t.when.After(c.now) {
+			timers = append(timers, t)
+			continue
+		}
+		select {
+		case t.c <- c.now:
+		default:
+		}
+		if t.period > 0 {
+			t.when = c.now.Add(t.period)
+			timers = append(timers, t)
+		}
+	}
+	c.timers = timers
+}
+
+func (t *manualTimer) C() <-chan time.Time { return t.c }
+
+func (t *manualTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	for i, v := range t.clock.timers {
+		if v == t {
+			t.clock.timers = append(t.clock.timers[:i], t.clock.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+type manualTicker struct{ *manualTimer }
+
+func (t manualTicker) Stop() { t.manualTimer.Stop() }
+
+func TestSleepContext(t *testing.T) {
+	clock := newManualClock()
+	done := make(chan error, 1)
+	go func() { done <- sleepContext(context.Background(), clock, time.Second) }()
+	require.Eventually(t, func() bool {
+		clock.mu.Lock()
+		defer clock.mu.Unlock()
+		return len(clock.timers) == 1
+	}, time.Second, time.Millisecond)
+	clock.Advance(time.Second)
+	require.NoError(t, <-done)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	require.Equal(t, context.Canceled, sleepContext(ctx, clock, time.Second))
+}
+
+func TestTokenBucketLimiter_Clock(t *testing.T) {
+	clock := newManualClock()
+	l := NewTokenBucketLimiter(1000, 0)
+	l.Clock = clock
+
+	require.True(t, l.AllowN("user:foo", 1000))
+	require.False(t, l.AllowN("user:foo", 1))
+	clock.Advance(500 * time.Millisecond)
+	require.True(t, l.AllowN("user:foo", 500))
+	require.False(t, l.AllowN("user:foo", 1))
+}
+
+func TestTokenBucketLimiter_NoLimit(t *testing.T) {
+	clock := newManualClock()
+	l := NewTokenBucketLimiter(0, 0)
+	l.Clock = clock
+
+	require.True(t, l.AllowN("user:foo", 1<<20))
+	clock.Advance(tokenBucketSweepInterval + time.Second)
+	require.True(t, l.AllowN("user:bar", 1<<20))
+	require.NoError(t, l.WaitN(context.Background(), "user:baz", 1<<20))
+	require.Empty(t, l.buckets)
+}
+
+func TestMilestones_Clock(t *testing.T) {
+	clock := newManualClock()
+	var fired []Milestone
+	srv := NewServer(WithClock(clock), WithMilestones(time.Hour, []uint64{100}, func(m Milestone) {
+		fired = append(fired, m)
+	}))
+	count := srv.milestones.counter("foo")
+	count(100)
+	require.Len(t, fired, 1)
+	require.Equal(t, clock.Now(), fired[0].Time)
+
+	// a new window starts after an hour
+	clock.Advance(time.Hour)
+	count(100)
+	require.Len(t, fired, 2)
+	require.Equal(t, clock.Now(), fired[1].WindowStart)
+}
@@ -0,0 +1,121 @@
+package socks5
+
+import (
+	"errors"
+	"net"
+	"sync/atomic"
+)
+
+// CloseReason is the machine-readable reason of the session end
+type CloseReason uint32
+
+// close reason defined
+const (
+	// CloseReasonNone the session is still active
+	CloseReasonNone CloseReason = iota
+	// CloseReasonClientEOF the client closed the connection first
+	CloseReasonClientEOF
+	// CloseReasonTargetEOF the target closed the connection first
+	CloseReasonTargetEOF
+	// CloseReasonIdleTimeout the session timed out or stalled
+	CloseReasonIdleTimeout
+	// CloseReasonQuotaExceeded the session exceeded the limits, such as per ip sessions or memory
+	CloseReasonQuotaExceeded
+	// CloseReasonAdminKill the session was closed by Close, Shutdown, CloseSession or the context of ServeContext
+	CloseReasonAdminKill
+	// CloseReasonError the session ended with an error, such as failed negotiation or dial
+	CloseReasonError
+	// CloseReasonMigrated the session was handed to the new process, see Server.Migrate
+	CloseReasonMigrated
+)
+
+// String implement interface fmt.Stringer
+func (r CloseReason) String() string {
+	switch r {
+	case CloseReasonNone:
+		return "none"
+	case CloseReasonClientEOF:
+		return "client_eof"
+	case CloseReasonTargetEOF:
+		return "target_eof"
+	case CloseReasonIdleTimeout:
+		return "idle_timeout"
+	case CloseReasonQuotaExceeded:
+		return "quota_exceeded"
+	case CloseReasonAdminKill:
+		return "admin_kill"
+	case CloseReasonError:
+		return "error"
+	case CloseReasonMigrated:
+		return "migrated"
+	}
+	return "unknown"
+}
+
+// SessionCloseError is the error of the session end with the close reason,
+// returned by ServeConn.
+type SessionCloseError struct {
+	Reason CloseReason
+	Err    error
+}
+
+// Error implement interface error
+func (sf *SessionCloseError) Error() string {
+	return sf.Err.Error() + " [close reason: " + sf.Reason.String() + "]"
+}
+
+// Unwrap returns the underlying error
+func (sf *SessionCloseError) Unwrap() error { return sf.Err }
+
+// setCloseReason set the close reason if not set yet, the first reason wins.
+func (sf *session) setCloseReason(r CloseReason) {
+	if sf != nil {
+		atomic.CompareAndSwapUint32(&sf.closeReason, uint32(CloseReasonNone), uint32(r))
+	}
+}
+
+// relayCloseReason is the close reason of the relay direction which ended first
+func relayCloseReason(up bool, err error) CloseReason {
+	var ne net.Error
+	switch {
+	case err == nil && up:
+		return CloseReasonClientEOF
+	case err == nil:
+		return CloseReasonTargetEOF
+	case errors.As(err, &ne) && ne.Timeout():
+		return CloseReasonIdleTimeout
+	}
+	return CloseReasonError
+}
+
+// endSession settles the close reason of the session, notifies the metrics and
+// the session close handle, and returns the error with the close reason.
+func (sf *Server) endSession(sess *session, err error) error {
+	var noise *clientNoiseError
+	switch {
+	case err == nil, errors.As(err, &noise):
+		sess.setCloseReason(CloseReasonClientEOF)
+	case errors.Is(err, ErrServerClosed):
+		sess.setCloseReason(CloseReasonAdminKill)
+	default:
+		sess.setCloseReason(CloseReasonError)
+	}
+	reason := CloseReason(atomic.LoadUint32(&sess.closeReason))
+	if m, ok := sf.metrics.(SessionMetrics); ok {
+		sess.mu.Lock()
+		cmd := sess.command
+		sess.mu.Unlock()
+		m.IncSessionClose(reason)
+		m.ObserveSessionDuration(cmd, sf.since(sess.started))
+	}
+	sf.accessLog(sess, err)
+	sf.logSessionEnd(sess, err)
+	sf.meterEnd(sess)
+	if sf.sessionCloseHandle != nil {
+		sf.sessionCloseHandle(sess.snapshot(), err)
+	}
+	if err == nil {
+		return nil
+	}
+	return &SessionCloseError{reason, err}
+}
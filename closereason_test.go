@@ -0,0 +1,64 @@
+package socks5
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRelayCloseReason(t *testing.T) {
+	require.Equal(t, CloseReasonClientEOF, relayCloseReason(true, nil))
+	require.Equal(t, CloseReasonTargetEOF, relayCloseReason(false, nil))
+	require.Equal(t, CloseReasonIdleTimeout, relayCloseReason(false, &net.OpError{Op: "read", Err: timeoutError{}}))
+	require.Equal(t, CloseReasonError, relayCloseReason(true, errors.New("reset")))
+	require.Equal(t, "quota_exceeded", CloseReasonQuotaExceeded.String())
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestServer_SessionCloseReason(t *testing.T) {
+	target := echoTarget(t)
+	closed := make(chan Session, 4)
+	metrics := newMockMetrics()
+	srv := NewServer(
+		WithMetrics(metrics),
+		WithPerIPLimit(0, 1),
+		WithSessionCloseHandle(func(s Session, err error) { closed <- s }),
+	)
+	proxy, _ := startServer(t, srv)
+	reason := func() CloseReason {
+		select {
+		case s := <-closed:
+			return s.CloseReason
+		case <-time.After(time.Second):
+			t.Fatal("session close not notified")
+		}
+		return CloseReasonNone
+	}
+
+	conn := relaySession(t, proxy, target)
+	conn.Close()
+	require.Equal(t, CloseReasonClientEOF, reason())
+
+	conn = relaySession(t, proxy, target)
+	defer conn.Close()
+	time.Sleep(10 * time.Millisecond)
+	// the second session exceeds the per ip limit
+	second, err := net.Dial("tcp", proxy.String())
+	require.NoError(t, err)
+	defer second.Close()
+	_, err = second.Write([]byte{5, 1, 0, 5, 1, 0, 1, 127, 0, 0, 1, 0, 80})
+	require.NoError(t, err)
+	require.Equal(t, CloseReasonQuotaExceeded, reason())
+
+	require.NoError(t, srv.Close())
+	require.Equal(t, CloseReasonAdminKill, reason())
+	require.Equal(t, 1, metrics.closes[CloseReasonAdminKill])
+}
@@ -0,0 +1,61 @@
+package socks5
+
+import (
+	"compress/flate"
+	"io"
+	"net"
+
+	"github.com/thinkgos/go-socks5/statute"
+)
+
+// compressWriter compresses the data by the DEFLATE, each write is flushed
+// so the interactive stream is not delayed.
+type compressWriter struct {
+	w  io.Writer
+	fw *flate.Writer
+}
+
+// Write implement interface io.Writer
+func (sf *compressWriter) Write(p []byte) (int, error) {
+	n, err := sf.fw.Write(p)
+	if err == nil {
+		err = sf.fw.Flush()
+	}
+	return n, err
+}
+
+// CloseWrite implement interface closeWriter, it ends the compressed stream
+func (sf *compressWriter) CloseWrite() error {
+	if err := sf.fw.Close(); err != nil {
+		return err
+	}
+	if c, ok := sf.w.(closeWriter); ok {
+		return c.CloseWrite()
+	}
+	return nil
+}
+
+// acceptCompression reports whether the payload of the CONNECT is compressed,
+// it is requested by the client with the statute.FlagCompress of the request.
+func (sf *Server) acceptCompression(request *Request) bool {
+	return sf.compression &&
+		sf.compressionLevel >= flate.HuffmanOnly && sf.compressionLevel <= flate.BestCompression &&
+		request.Request.Command == statute.CommandConnect &&
+		request.Request.Reserved&statute.FlagCompress != 0
+}
+
+// sendCompressReply sends the success reply of the CONNECT with the statute.FlagCompress,
+// and returns the client reader and writer of the compressed payload.
+func (sf *Server) sendCompressReply(writer io.Writer, request *Request, bindAddr net.Addr) (
+	io.Reader, io.Writer, error) {
+	fw, err := flate.NewWriter(writer, sf.compressionLevel)
+	if err != nil {
+		return nil, nil, err
+	}
+	reply := AppendReply(nil, statute.RepSuccess, bindAddr)
+	reply[2] = statute.FlagCompress
+	if _, err := writer.Write(reply); err != nil {
+		return nil, nil, err
+	}
+	return flate.NewReader(request.Reader), &compressWriter{writer, fw}, nil
+}
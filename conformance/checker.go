@@ -0,0 +1,226 @@
+package conformance
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/thinkgos/go-socks5/internal/echotarget"
+	"github.com/thinkgos/go-socks5/statute"
+)
+
+// checker holds the echo targets served for the checks
+type checker struct {
+	cfg   Config
+	echo  net.Listener
+	echo6 net.Listener // nil if the checker could not listen on Host6
+	udp   net.PacketConn
+}
+
+func newChecker(cfg Config) (*checker, error) {
+	if cfg.Host == "" {
+		cfg.Host = defaultHost
+	}
+	if cfg.Host6 == "" {
+		cfg.Host6 = defaultHost6
+	}
+	if cfg.FQDN == "" {
+		cfg.FQDN = defaultFQDN
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaultTimeout
+	}
+	c := &checker{cfg: cfg}
+	var err error
+	if c.echo, err = net.Listen("tcp", net.JoinHostPort(cfg.Host, "0")); err != nil {
+		return nil, fmt.Errorf("conformance: listen echo target, %v", err)
+	}
+	go echotarget.ServeTCP(c.echo)
+	if c.echo6, err = net.Listen("tcp6", net.JoinHostPort(cfg.Host6, "0")); err == nil {
+		go echotarget.ServeTCP(c.echo6)
+	}
+	if c.udp, err = net.ListenPacket("udp", net.JoinHostPort(cfg.Host, "0")); err != nil {
+		c.close()
+		return nil, fmt.Errorf("conformance: listen udp echo target, %v", err)
+	}
+	go echotarget.ServeUDP(c.udp)
+	return c, nil
+}
+
+func (sf *checker) close() {
+	sf.echo.Close()
+	if sf.echo6 != nil {
+		sf.echo6.Close()
+	}
+	if sf.udp != nil {
+		sf.udp.Close()
+	}
+}
+
+// dial connects the server with the deadline of the check
+func (sf *checker) dial(ctx context.Context) (net.Conn, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", sf.cfg.ProxyAddr)
+	if err != nil {
+		return nil, fmt.Errorf("dial proxy, %v", err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline) // nolint: errcheck
+	}
+	return conn, nil
+}
+
+// method returns the method the checker authenticates with
+func (sf *checker) method() byte {
+	if sf.cfg.Username != "" {
+		return statute.MethodUserPassAuth
+	}
+	return statute.MethodNoAuth
+}
+
+// greet offers the methods, it returns the method selected by the server
+func greet(conn net.Conn, methods ...byte) (byte, error) {
+	if _, err := conn.Write(statute.NewMethodRequest(statute.VersionSocks5, methods).Bytes()); err != nil {
+		return 0, err
+	}
+	rep, err := statute.ParseMethodReply(conn)
+	if err != nil {
+		return 0, fmt.Errorf("read method reply, %v", err)
+	}
+	if rep.Ver != statute.VersionSocks5 {
+		return 0, fmt.Errorf("method reply version %d, want %d", rep.Ver, statute.VersionSocks5)
+	}
+	return rep.Method, nil
+}
+
+// authenticate does the username/password authentication, it returns the status of the server
+func authenticate(conn net.Conn, user, pass string) (byte, error) {
+	req := statute.NewUserPassRequest(statute.UserPassAuthVersion, []byte(user), []byte(pass))
+	if _, err := conn.Write(req.Bytes()); err != nil {
+		return 0, err
+	}
+	rep, err := statute.ParseUserPassReply(conn)
+	if err != nil {
+		return 0, fmt.Errorf("read auth reply, %v", err)
+	}
+	if rep.Ver != statute.UserPassAuthVersion {
+		return 0, fmt.Errorf("auth reply version %d, want %d", rep.Ver, statute.UserPassAuthVersion)
+	}
+	return rep.Status, nil
+}
+
+// negotiate dials the server and negotiates the method of the checker
+func (sf *checker) negotiate(ctx context.Context) (net.Conn, error) {
+	conn, err := sf.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	method, err := greet(conn, sf.method())
+	if err == nil && method != sf.method() {
+		err = fmt.Errorf("method %#x selected, want %#x", method, sf.method())
+	}
+	if err == nil && method == statute.MethodUserPassAuth {
+		var status byte
+		status, err = authenticate(conn, sf.cfg.Username, sf.cfg.Password)
+		if err == nil && status != statute.AuthSuccess {
+			err = fmt.Errorf("authentication failed, status %#x", status)
+		}
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// request negotiates and sends the request, it returns the reply of the server
+func (sf *checker) request(ctx context.Context, cmd byte, dst statute.AddrSpec) (net.Conn, statute.Reply, error) {
+	conn, err := sf.negotiate(ctx)
+	if err != nil {
+		return nil, statute.Reply{}, err
+	}
+	req := statute.Request{Version: statute.VersionSocks5, Command: cmd, DstAddr: dst}
+	if _, err = conn.Write(req.Bytes()); err != nil {
+		conn.Close()
+		return nil, statute.Reply{}, err
+	}
+	rep, err := statute.ParseReply(conn)
+	if err != nil {
+		conn.Close()
+		return nil, rep, fmt.Errorf("read reply, %v", err)
+	}
+	return conn, rep, nil
+}
+
+// replyError is the failure reply
+func replyError(rep statute.Reply) error {
+	return fmt.Errorf("reply %d, want %d", rep.Response, statute.RepSuccess)
+}
+
+// connectEcho connects the echo target by the proxy and echoes a message
+func (sf *checker) connectEcho(ctx context.Context, dst statute.AddrSpec) error {
+	conn, rep, err := sf.request(ctx, statute.CommandConnect, dst)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if rep.Response != statute.RepSuccess {
+		return replyError(rep)
+	}
+	return echo(conn)
+}
+
+// echo writes a message and reads the same back
+func echo(rw io.ReadWriter) error {
+	msg := []byte("socks5 conformance")
+	if _, err := rw.Write(msg); err != nil {
+		return fmt.Errorf("write data, %v", err)
+	}
+	buf := make([]byte, len(msg))
+	if _, err := io.ReadFull(rw, buf); err != nil {
+		return fmt.Errorf("read data, %v", err)
+	}
+	if string(buf) != string(msg) {
+		return fmt.Errorf("data %q relayed, want %q", buf, msg)
+	}
+	return nil
+}
+
+// expectClosed reports whether the server closes the connection without a success reply
+func expectClosed(conn net.Conn) error {
+	buf := make([]byte, 512)
+	for {
+		n, err := conn.Read(buf)
+		if n > 1 && buf[0] == statute.VersionSocks5 && buf[1] == statute.RepSuccess {
+			return errors.New("success replied")
+		}
+		if err != nil {
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				return errors.New("connection kept open")
+			}
+			return nil
+		}
+	}
+}
+
+// proxyAddr returns the address replied by the server, the unspecified ip is
+// replaced by the ip of the server.
+func (sf *checker) proxyAddr(bnd statute.AddrSpec) string {
+	if bnd.IP == nil || bnd.IP.IsUnspecified() {
+		host, _, _ := net.SplitHostPort(sf.cfg.ProxyAddr)
+		return net.JoinHostPort(host, strconv.Itoa(bnd.Port))
+	}
+	return bnd.String()
+}
+
+// addrSpec returns the AddrSpec of the tcp address
+// deadline returns the deadline of the check
+func deadline(ctx context.Context) time.Time {
+	d, _ := ctx.Deadline()
+	return d
+}
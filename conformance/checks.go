@@ -0,0 +1,298 @@
+package conformance
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+
+	"github.com/thinkgos/go-socks5/internal/echotarget"
+	"github.com/thinkgos/go-socks5/statute"
+)
+
+// methodPrivate is a method of the private range, which the server does not know
+const methodPrivate = byte(0x80)
+
+func checkMethodSelected(ctx context.Context, c *checker) error {
+	conn, err := c.negotiate(ctx)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+func checkPreferredMethod(ctx context.Context, c *checker) error {
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	method, err := greet(conn, methodPrivate, c.method())
+	if err != nil {
+		return err
+	}
+	if method != c.method() {
+		return fmt.Errorf("method %#x selected, want %#x", method, c.method())
+	}
+	return nil
+}
+
+func checkNoAcceptable(ctx context.Context, c *checker) error {
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	method, err := greet(conn, methodPrivate)
+	if err != nil {
+		return err
+	}
+	if method != statute.MethodNoAcceptable {
+		return fmt.Errorf("method %#x selected, want %#x", method, statute.MethodNoAcceptable)
+	}
+	return nil
+}
+
+func checkValidCredentials(ctx context.Context, c *checker) error {
+	if c.cfg.Username == "" {
+		return errSkip("no credentials configured")
+	}
+	// negotiate authenticates with the credentials
+	return checkMethodSelected(ctx, c)
+}
+
+func checkInvalidCredentials(ctx context.Context, c *checker) error {
+	if c.cfg.Username == "" {
+		return errSkip("no credentials configured")
+	}
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if _, err = greet(conn, statute.MethodUserPassAuth); err != nil {
+		return err
+	}
+	status, err := authenticate(conn, c.cfg.Username, c.cfg.Password+"-invalid")
+	if err != nil {
+		return err
+	}
+	if status == statute.AuthSuccess {
+		return errors.New("invalid credentials accepted")
+	}
+	// the server must close the connection on the failure
+	return expectClosed(conn)
+}
+
+func checkConnectIPv4(ctx context.Context, c *checker) error {
+	return c.connectEcho(ctx, echotarget.AddrSpec(c.echo.Addr()))
+}
+
+func checkConnectIPv6(ctx context.Context, c *checker) error {
+	if c.echo6 == nil {
+		return errSkip("could not listen on " + c.cfg.Host6)
+	}
+	return c.connectEcho(ctx, echotarget.AddrSpec(c.echo6.Addr()))
+}
+
+func checkConnectDomain(ctx context.Context, c *checker) error {
+	return c.connectEcho(ctx, statute.AddrSpec{
+		FQDN:     c.cfg.FQDN,
+		Port:     echotarget.AddrSpec(c.echo.Addr()).Port,
+		AddrType: statute.ATYPDomain,
+	})
+}
+
+func checkConnectRefused(ctx context.Context, c *checker) error {
+	l, err := net.Listen("tcp", net.JoinHostPort(c.cfg.Host, "0"))
+	if err != nil {
+		return errSkip(err.Error())
+	}
+	closed := echotarget.AddrSpec(l.Addr())
+	l.Close()
+
+	conn, rep, err := c.request(ctx, statute.CommandConnect, closed)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if rep.Response != statute.RepConnectionRefused {
+		return fmt.Errorf("reply %d, want %d", rep.Response, statute.RepConnectionRefused)
+	}
+	return nil
+}
+
+func checkBind(ctx context.Context, c *checker) error {
+	// the peer is expected to connect from the host of the checker
+	conn, rep, err := c.request(ctx, statute.CommandBind, echotarget.AddrSpec(&net.TCPAddr{IP: net.ParseIP(c.cfg.Host)}))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if rep.Response != statute.RepSuccess {
+		return replyError(rep)
+	}
+	var d net.Dialer
+	peer, err := d.DialContext(ctx, "tcp", c.proxyAddr(rep.BndAddr))
+	if err != nil {
+		return fmt.Errorf("peer dial %s, %v", c.proxyAddr(rep.BndAddr), err)
+	}
+	defer peer.Close()
+	peer.SetDeadline(deadline(ctx)) // nolint: errcheck
+	go io.Copy(peer, peer)          // nolint: errcheck
+
+	// the second reply once the peer connected
+	if rep, err = statute.ParseReply(conn); err != nil {
+		return fmt.Errorf("read second reply, %v", err)
+	}
+	if rep.Response != statute.RepSuccess {
+		return fmt.Errorf("second %v", replyError(rep))
+	}
+	return echo(conn)
+}
+
+func checkAssociate(ctx context.Context, c *checker) error {
+	pc, err := net.ListenPacket("udp", net.JoinHostPort(c.cfg.Host, "0"))
+	if err != nil {
+		return errSkip(err.Error())
+	}
+	defer pc.Close()
+	pc.SetDeadline(deadline(ctx)) // nolint: errcheck
+
+	conn, rep, err := c.request(ctx, statute.CommandAssociate, statute.AddrSpec{
+		IP: net.IPv4zero, AddrType: statute.ATYPIPv4,
+	})
+	if err != nil {
+		return err
+	}
+	// the association lives as long as the tcp connection
+	defer conn.Close()
+	if rep.Response != statute.RepSuccess {
+		return replyError(rep)
+	}
+	relay, err := net.ResolveUDPAddr("udp", c.proxyAddr(rep.BndAddr))
+	if err != nil {
+		return fmt.Errorf("relay address, %v", err)
+	}
+	msg := []byte("socks5 conformance")
+	pk, err := statute.NewDatagram(c.udp.LocalAddr().String(), msg)
+	if err != nil {
+		return err
+	}
+	if _, err = pc.WriteTo(pk.Bytes(), relay); err != nil {
+		return fmt.Errorf("write datagram, %v", err)
+	}
+	buf := make([]byte, 64*1024)
+	n, _, err := pc.ReadFrom(buf)
+	if err != nil {
+		return fmt.Errorf("read datagram, %v", err)
+	}
+	if pk, err = statute.ParseDatagram(buf[:n]); err != nil {
+		return fmt.Errorf("parse datagram, %v", err)
+	}
+	if string(pk.Data) != string(msg) {
+		return fmt.Errorf("data %q relayed, want %q", pk.Data, msg)
+	}
+	return nil
+}
+
+func checkWrongVersion(ctx context.Context, c *checker) error {
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if _, err = conn.Write([]byte{0x06, 1, c.method()}); err != nil {
+		return err
+	}
+	return expectClosed(conn)
+}
+
+func checkZeroMethods(ctx context.Context, c *checker) error {
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if _, err = conn.Write([]byte{statute.VersionSocks5, 0}); err != nil {
+		return err
+	}
+	// either no acceptable methods replied or the connection closed
+	rep, err := statute.ParseMethodReply(conn)
+	if err != nil {
+		var ne net.Error
+		if errors.As(err, &ne) && ne.Timeout() {
+			return errors.New("no reply and connection kept open")
+		}
+		return nil
+	}
+	if rep.Method != statute.MethodNoAcceptable {
+		return fmt.Errorf("method %#x selected, want %#x", rep.Method, statute.MethodNoAcceptable)
+	}
+	return nil
+}
+
+func checkTruncatedGreeting(ctx context.Context, c *checker) error {
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if _, err = conn.Write([]byte{statute.VersionSocks5, 3, c.method()}); err != nil {
+		return err
+	}
+	if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+		cw.CloseWrite() // nolint: errcheck
+	}
+	return expectClosed(conn)
+}
+
+func checkUnsupportedCommand(ctx context.Context, c *checker) error {
+	conn, rep, err := c.request(ctx, 0x09, echotarget.AddrSpec(c.echo.Addr()))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if rep.Response != statute.RepCommandNotSupported {
+		return fmt.Errorf("reply %d, want %d", rep.Response, statute.RepCommandNotSupported)
+	}
+	return nil
+}
+
+func checkUnsupportedAddrType(ctx context.Context, c *checker) error {
+	conn, err := c.negotiate(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	req := []byte{statute.VersionSocks5, statute.CommandConnect, 0, 0x05, 127, 0, 0, 1, 0, 80}
+	if _, err = conn.Write(req); err != nil {
+		return err
+	}
+	rep, err := statute.ParseReply(conn)
+	if err != nil {
+		return fmt.Errorf("read reply, %v", err)
+	}
+	if rep.Response != statute.RepAddrTypeNotSupported {
+		return fmt.Errorf("reply %d, want %d", rep.Response, statute.RepAddrTypeNotSupported)
+	}
+	return nil
+}
+
+func checkWrongRequestVersion(ctx context.Context, c *checker) error {
+	conn, err := c.negotiate(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	req := statute.Request{
+		Version: statute.VersionSocks4,
+		Command: statute.CommandConnect,
+		DstAddr: echotarget.AddrSpec(c.echo.Addr()),
+	}
+	if _, err = conn.Write(req.Bytes()); err != nil {
+		return err
+	}
+	return expectClosed(conn)
+}
@@ -0,0 +1,169 @@
+// Package conformance checks a SOCKS5 server against RFC 1928 and RFC 1929, it exercises
+// the server with the valid and the malformed negotiations, all the address types and all
+// the commands, and reports a pass/fail matrix. It is usable against any SOCKS5 server,
+// the checker serves the echo targets and the BIND peer itself.
+package conformance
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaults of the config
+const (
+	defaultHost    = "127.0.0.1"
+	defaultHost6   = "::1"
+	defaultFQDN    = "localhost"
+	defaultTimeout = 5 * time.Second
+)
+
+// Config is the config of the checker
+type Config struct {
+	// ProxyAddr is the address of the server under test
+	ProxyAddr string
+	// Username and Password authenticate by RFC 1929, no authentication if Username is empty.
+	Username string
+	Password string
+	// Host is the ip of the checker reachable from the server, the echo targets and
+	// the BIND peer are served on it, defaults to 127.0.0.1.
+	Host string
+	// Host6 is the IPv6 of the checker reachable from the server, defaults to ::1,
+	// the IPv6 address type is skipped if the checker could not listen on it.
+	Host6 string
+	// FQDN is the domain resolved to Host by the server, defaults to localhost.
+	FQDN string
+	// Timeout is the timeout of each check, defaults to 5 seconds.
+	Timeout time.Duration
+}
+
+// Status is the status of a check
+type Status uint8
+
+// status defined
+const (
+	Pass Status = iota
+	Fail
+	Skip
+)
+
+// String implement interface fmt.Stringer
+func (s Status) String() string {
+	switch s {
+	case Pass:
+		return "PASS"
+	case Fail:
+		return "FAIL"
+	case Skip:
+		return "SKIP"
+	default:
+		return "Status(" + strconv.Itoa(int(s)) + ")"
+	}
+}
+
+// Result is the result of a check
+type Result struct {
+	// Group of the check, such as "negotiation", "address" or "command"
+	Group string
+	// Name of the check
+	Name   string
+	Status Status
+	// Detail why the check failed or skipped
+	Detail string
+}
+
+// Report is the pass/fail matrix of the checks
+type Report struct {
+	Results []Result
+}
+
+// Failed returns the failed checks
+func (sf *Report) Failed() []Result {
+	var failed []Result
+	for _, r := range sf.Results {
+		if r.Status == Fail {
+			failed = append(failed, r)
+		}
+	}
+	return failed
+}
+
+// String implement interface fmt.Stringer, one check per line
+func (sf *Report) String() string {
+	b := new(strings.Builder)
+	for _, r := range sf.Results {
+		fmt.Fprintf(b, "%-12s %-28s %s", r.Group, r.Name, r.Status)
+		if r.Detail != "" {
+			b.WriteString("  " + r.Detail)
+		}
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// errSkip skips the check
+type errSkip string
+
+func (e errSkip) Error() string { return string(e) }
+
+// check is a conformance check
+type check struct {
+	group string
+	name  string
+	run   func(ctx context.Context, c *checker) error
+}
+
+var checks = []check{
+	{"negotiation", "method selected", checkMethodSelected},
+	{"negotiation", "preferred method", checkPreferredMethod},
+	{"negotiation", "no acceptable methods", checkNoAcceptable},
+	{"auth", "valid credentials", checkValidCredentials},
+	{"auth", "invalid credentials", checkInvalidCredentials},
+	{"address", "ipv4", checkConnectIPv4},
+	{"address", "ipv6", checkConnectIPv6},
+	{"address", "domain", checkConnectDomain},
+	{"command", "connect", checkConnectIPv4},
+	{"command", "connect refused", checkConnectRefused},
+	{"command", "bind", checkBind},
+	{"command", "associate", checkAssociate},
+	{"malformed", "wrong version", checkWrongVersion},
+	{"malformed", "zero methods", checkZeroMethods},
+	{"malformed", "truncated greeting", checkTruncatedGreeting},
+	{"malformed", "unsupported command", checkUnsupportedCommand},
+	{"malformed", "unsupported address type", checkUnsupportedAddrType},
+	{"malformed", "wrong request version", checkWrongRequestVersion},
+}
+
+// Run runs all the checks against the server, the checks are run in sequence.
+func Run(ctx context.Context, cfg Config) (*Report, error) {
+	if cfg.ProxyAddr == "" {
+		return nil, errors.New("conformance: proxy address required")
+	}
+	c, err := newChecker(cfg)
+	if err != nil {
+		return nil, err
+	}
+	defer c.close()
+
+	report := &Report{}
+	for _, ck := range checks {
+		r := Result{Group: ck.group, Name: ck.name}
+		cctx, cancel := context.WithTimeout(ctx, c.cfg.Timeout)
+		err := ck.run(cctx, c)
+		cancel()
+		var skip errSkip
+		switch {
+		case err == nil:
+			r.Status = Pass
+		case errors.As(err, &skip):
+			r.Status, r.Detail = Skip, skip.Error()
+		default:
+			r.Status, r.Detail = Fail, err.Error()
+		}
+		report.Results = append(report.Results, r)
+	}
+	return report, nil
+}
@@ -0,0 +1,47 @@
+package conformance
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/thinkgos/go-socks5"
+)
+
+func serve(t *testing.T, opts ...socks5.Option) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+	go socks5.NewServer(opts...).Serve(l) // nolint: errcheck
+	return l.Addr().String()
+}
+
+func TestRun(t *testing.T) {
+	report, err := Run(context.Background(), Config{ProxyAddr: serve(t)})
+	require.NoError(t, err)
+	require.Empty(t, report.Failed(), report.String())
+	require.Len(t, report.Results, len(checks))
+	for _, r := range report.Results {
+		if r.Group == "auth" {
+			require.Equal(t, Skip, r.Status)
+		}
+	}
+
+	addr := serve(t, socks5.WithCredential(socks5.StaticCredentials{"user": "pass"}))
+	report, err = Run(context.Background(), Config{ProxyAddr: addr, Username: "user", Password: "pass"})
+	require.NoError(t, err)
+	require.Empty(t, report.Failed(), report.String())
+
+	// the failures are reported in the matrix
+	report, err = Run(context.Background(), Config{ProxyAddr: serve(t, socks5.WithRule(socks5.NewPermitConnAndAss()))})
+	require.NoError(t, err)
+	failed := report.Failed()
+	require.Len(t, failed, 1, report.String())
+	require.Equal(t, "bind", failed[0].Name)
+	require.Contains(t, report.String(), "bind                         FAIL  reply 2, want 0")
+
+	_, err = Run(context.Background(), Config{})
+	require.Error(t, err)
+}
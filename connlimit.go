@@ -0,0 +1,113 @@
+package socks5
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/thinkgos/go-socks5/statute"
+)
+
+// OverloadPolicy is what the server does with the connections over the limit of WithMaxConnections
+type OverloadPolicy uint8
+
+// overload policy defined
+const (
+	// OverloadDelay stops accepting until a connection ends, the clients queue
+	// in the backlog of the listener.
+	OverloadDelay OverloadPolicy = iota
+	// OverloadRefuse accepts and refuses the connection with a reply, the no acceptable
+	// methods of SOCKS5 or the rejected of SOCKS4, then closes it. At most 64 connections are
+	// refused with the reply at the same time, the others are closed without.
+	OverloadRefuse
+)
+
+// String implement interface fmt.Stringer
+func (p OverloadPolicy) String() string {
+	switch p {
+	case OverloadDelay:
+		return "delay"
+	case OverloadRefuse:
+		return "refuse"
+	}
+	return "unknown"
+}
+
+// overloadPollInterval is the interval the delayed accept checks the shutdown
+const overloadPollInterval = 100 * time.Millisecond
+
+// refuseTimeout limits the time to read the greeting of the connection refused
+const refuseTimeout = time.Second
+
+// maxRefusing limits the connections being refused with a reply at the same time,
+// the connections over it are closed without the reply.
+const maxRefusing = 64
+
+// acquireConn acquires a slot of the connection limit before accept, it blocks by OverloadDelay,
+// it returns the release function, false if the slot is not acquired by OverloadRefuse.
+// The error is returned if the server or the context is done while waiting.
+func (sf *Server) acquireConn(ctx context.Context) (func(), bool, error) {
+	if sf.connSlots == nil {
+		return func() {}, true, nil
+	}
+	release := func() { <-sf.connSlots }
+	select {
+	case sf.connSlots <- struct{}{}:
+		return release, true, nil
+	default:
+	}
+	if sf.overloadPolicy == OverloadRefuse {
+		return nil, false, nil
+	}
+	ticker := time.NewTicker(overloadPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case sf.connSlots <- struct{}{}:
+			return release, true, nil
+		case <-ctx.Done():
+			return nil, false, ctx.Err()
+		case <-ticker.C:
+			if sf.shuttingDown() {
+				return nil, false, ErrServerClosed
+			}
+		}
+	}
+}
+
+// refuse refuses the connection over the limit in a goroutine, the connection is closed
+// immediately if maxRefusing connections are being refused.
+func (sf *Server) refuse(conn net.Conn) {
+	if atomic.AddInt64(&sf.refusing, 1) > maxRefusing {
+		atomic.AddInt64(&sf.refusing, -1)
+		sf.incError(PhaseNegotiation, NoReply)
+		conn.Close()
+		return
+	}
+	sf.goFunc(func() {
+		defer atomic.AddInt64(&sf.refusing, -1)
+		sf.refuseConn(conn)
+	})
+}
+
+// refuseConn refuses the connection over the limit with the reply of the version, then closes it
+func (sf *Server) refuseConn(conn net.Conn) {
+	defer conn.Close()
+	sf.incError(PhaseNegotiation, NoReply)
+	conn.SetDeadline(time.Now().Add(refuseTimeout)) // nolint: errcheck
+	bufConn := bufio.NewReader(conn)
+	version, err := sniffVersion(bufConn)
+	if err != nil {
+		return
+	}
+	if version == statute.VersionSocks4 {
+		sendSocks4Reply(conn, statute.Socks4Rejected) // nolint: errcheck
+		return
+	}
+	if _, err := statute.ParseMethodRequest(bufConn); err != nil {
+		return
+	}
+	conn.Write([]byte{statute.VersionSocks5, statute.MethodNoAcceptable}) // nolint: errcheck
+}
@@ -0,0 +1,89 @@
+package socks5
+
+import (
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/thinkgos/go-socks5/statute"
+)
+
+// greet sends the no auth greeting and returns the method chosen
+func greet(t *testing.T, proxy net.Addr, timeout time.Duration) (net.Conn, byte, error) {
+	conn, err := net.Dial("tcp", proxy.String())
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	_, err = conn.Write([]byte{statute.VersionSocks5, 1, statute.MethodNoAuth})
+	require.NoError(t, err)
+	conn.SetReadDeadline(time.Now().Add(timeout)) // nolint: errcheck
+	rep, err := statute.ParseMethodReply(conn)
+	conn.SetReadDeadline(time.Time{}) // nolint: errcheck
+	return conn, rep.Method, err
+}
+
+func TestServer_MaxConnectionsRefuse(t *testing.T) {
+	target := echoTarget(t)
+	proxy := serveSocks(t, WithMaxConnections(1, OverloadRefuse))
+
+	first := relaySession(t, proxy, target)
+	_, method, err := greet(t, proxy, time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, statute.MethodNoAcceptable, method)
+
+	first.Close()
+	require.Eventually(t, func() bool {
+		_, method, err := greet(t, proxy, time.Second)
+		return err == nil && method == statute.MethodNoAuth
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestServer_MaxConnectionsDelay(t *testing.T) {
+	target := echoTarget(t)
+	srv := NewServer(WithMaxConnections(1, OverloadDelay))
+	proxy, served := startServer(t, srv)
+
+	first := relaySession(t, proxy, target)
+	// queued in the backlog until the first ends
+	conn, _, err := greet(t, proxy, 100*time.Millisecond)
+	require.Error(t, err)
+	first.Close()
+	conn.SetReadDeadline(time.Now().Add(time.Second)) // nolint: errcheck
+	rep, err := statute.ParseMethodReply(conn)
+	require.NoError(t, err)
+	assert.Equal(t, statute.MethodNoAuth, rep.Method)
+
+	// the accept waiting is interrupted by the close
+	require.NoError(t, srv.Close())
+	select {
+	case err := <-served:
+		assert.Equal(t, ErrServerClosed, err)
+	case <-time.After(time.Second):
+		t.Fatal("serve not returned")
+	}
+}
+
+func TestServer_MaxConnectionsRefuse_Bounded(t *testing.T) {
+	target := echoTarget(t)
+	srv := NewServer(WithMaxConnections(1, OverloadRefuse))
+	proxy, _ := startServer(t, srv)
+	t.Cleanup(func() { srv.Close() })
+
+	relaySession(t, proxy, target)
+	atomic.StoreInt64(&srv.refusing, maxRefusing)
+	// closed without the reply
+	_, _, err := greet(t, proxy, time.Second)
+	require.Error(t, err)
+	var ne net.Error
+	assert.False(t, errors.As(err, &ne) && ne.Timeout())
+	assert.Equal(t, int64(maxRefusing), atomic.LoadInt64(&srv.refusing))
+
+	atomic.StoreInt64(&srv.refusing, 0)
+	_, method, err := greet(t, proxy, time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, statute.MethodNoAcceptable, method)
+}
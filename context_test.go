@@ -0,0 +1,65 @@
+package socks5
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type serveContextKey struct{}
+
+func TestServer_ServeContext(t *testing.T) {
+	target := echoTarget(t)
+	propagated := make(chan bool, 1)
+	srv := NewServer(WithRule(ruleFunc(func(ctx context.Context, _ *Request) (context.Context, bool) {
+		propagated <- ctx.Value(serveContextKey{}) != nil
+		return ctx, true
+	})))
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), serveContextKey{}, true))
+	defer cancel()
+	served := make(chan error, 1)
+	go func() { served <- srv.ServeContext(ctx, l) }()
+
+	relaying := relaySession(t, l.Addr(), target)
+	defer relaying.Close()
+	require.True(t, <-propagated)
+
+	cancel()
+	select {
+	case err := <-served:
+		require.Equal(t, context.Canceled, err)
+	case <-time.After(time.Second):
+		t.Fatal("serve not returned after the context cancelled")
+	}
+	relaying.SetReadDeadline(time.Now().Add(time.Second)) // nolint: errcheck
+	_, err = relaying.Read(make([]byte, 1))
+	require.Equal(t, io.EOF, err)
+}
+
+func TestServer_ConnContextCanceled(t *testing.T) {
+	target := echoTarget(t)
+	captured := make(chan context.Context, 1)
+	proxy := serveSocks(t, WithRule(ruleFunc(func(ctx context.Context, _ *Request) (context.Context, bool) {
+		captured <- ctx
+		return ctx, true
+	})))
+
+	conn := relaySession(t, proxy, target)
+	ctx := <-captured
+	require.NoError(t, ctx.Err())
+
+	// the work started for the connection observes the cancellation once it ends
+	conn.Close()
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("context not cancelled after the connection ended")
+	}
+}
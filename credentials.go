@@ -1,11 +1,33 @@
 package socks5
 
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"sync"
+)
+
 // CredentialStore is used to support user/pass authentication optional network addr
 // if you want to limit user network addr,you can refuse it.
 type CredentialStore interface {
 	Valid(user, password, userAddr string) bool
 }
 
+// ContextCredentialStore is optionally implemented by the CredentialStore, to receive the
+// context of the connection, such as to abandon a remote lookup once the server is shutting down.
+type ContextCredentialStore interface {
+	CredentialStore
+	ValidContext(ctx context.Context, user, password, userAddr string) bool
+}
+
+// validCredentials verifies the password with the context if the credential store supports it
+func validCredentials(ctx context.Context, cs CredentialStore, user, password, userAddr string) bool {
+	if c, ok := cs.(ContextCredentialStore); ok {
+		return c.ValidContext(ctx, user, password, userAddr)
+	}
+	return cs.Valid(user, password, userAddr)
+}
+
 // StaticCredentials enables using a map directly as a credential store
 type StaticCredentials map[string]string
 
@@ -14,3 +36,47 @@ func (s StaticCredentials) Valid(user, password, _ string) bool {
 	pass, ok := s[user]
 	return ok && password == pass
 }
+
+// MemoryCredentials is an in-memory credential store safe for concurrent use,
+// the SHA-256 digests of the passwords are compared in constant time.
+// The zero value is ready to use.
+type MemoryCredentials struct {
+	mu    sync.RWMutex
+	users map[string][sha256.Size]byte
+}
+
+// NewMemoryCredentials new in-memory credential store with the user to password map
+func NewMemoryCredentials(users map[string]string) *MemoryCredentials {
+	sf := &MemoryCredentials{users: make(map[string][sha256.Size]byte, len(users))}
+	for user, pass := range users {
+		sf.users[user] = sha256.Sum256([]byte(pass))
+	}
+	return sf
+}
+
+// Set adds or replaces the password of the user
+func (sf *MemoryCredentials) Set(user, password string) {
+	sf.mu.Lock()
+	if sf.users == nil {
+		sf.users = make(map[string][sha256.Size]byte)
+	}
+	sf.users[user] = sha256.Sum256([]byte(password))
+	sf.mu.Unlock()
+}
+
+// Delete removes the user
+func (sf *MemoryCredentials) Delete(user string) {
+	sf.mu.Lock()
+	delete(sf.users, user)
+	sf.mu.Unlock()
+}
+
+// Valid implement interface CredentialStore
+func (sf *MemoryCredentials) Valid(user, password, _ string) bool {
+	sf.mu.RLock()
+	pass, ok := sf.users[user]
+	sf.mu.RUnlock()
+	// compare anyway so an unknown user takes as long as a wrong password
+	digest := sha256.Sum256([]byte(password))
+	return subtle.ConstantTimeCompare(digest[:], pass[:]) == 1 && ok
+}
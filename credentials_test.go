@@ -16,3 +16,23 @@ func TestStaticCredentials(t *testing.T) {
 	assert.True(t, creds.Valid("baz", "", ""))
 	assert.False(t, creds.Valid("foo", "", ""))
 }
+
+func TestMemoryCredentials(t *testing.T) {
+	creds := NewMemoryCredentials(map[string]string{"foo": "bar"})
+
+	assert.True(t, creds.Valid("foo", "bar", ""))
+	assert.False(t, creds.Valid("foo", "baz", ""))
+	assert.False(t, creds.Valid("baz", "bar", ""))
+
+	creds.Set("baz", "qux")
+	assert.True(t, creds.Valid("baz", "qux", ""))
+	creds.Delete("foo")
+	assert.False(t, creds.Valid("foo", "bar", ""))
+
+	// the zero value is ready to use
+	var zero MemoryCredentials
+	assert.False(t, zero.Valid("foo", "", ""))
+	zero.Delete("foo")
+	zero.Set("foo", "bar")
+	assert.True(t, zero.Valid("foo", "bar", ""))
+}
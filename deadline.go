@@ -0,0 +1,115 @@
+package socks5
+
+import (
+	"io"
+	"net"
+	"time"
+)
+
+// symmetricDeadline mirrors the deadline to both relay legs, the deadline
+// is extended on every read of either leg, so a stalled peer on either side
+// releases both sockets promptly.
+type symmetricDeadline struct {
+	timeout time.Duration
+	conns   []net.Conn
+	sess    *session
+}
+
+func (sf *symmetricDeadline) extend() {
+	t := time.Now().Add(sf.timeout)
+	for _, c := range sf.conns {
+		c.SetDeadline(t) // nolint: errcheck
+	}
+	sf.sess.setDeadline(t)
+}
+
+// reader wraps r extending the deadline before read
+func (sf *symmetricDeadline) reader(r io.Reader) io.Reader {
+	return &deadlineReader{r, sf}
+}
+
+type deadlineReader struct {
+	io.Reader
+	d *symmetricDeadline
+}
+
+// Read implement interface io.Reader
+func (sf *deadlineReader) Read(p []byte) (int, error) {
+	sf.d.extend()
+	return sf.Reader.Read(p)
+}
+
+// timeoutReader sets the read deadline of conn before every read
+type timeoutReader struct {
+	io.Reader
+	conn    net.Conn
+	timeout time.Duration
+}
+
+// Read implement interface io.Reader
+func (sf *timeoutReader) Read(p []byte) (int, error) {
+	sf.conn.SetReadDeadline(time.Now().Add(sf.timeout)) // nolint: errcheck
+	return sf.Reader.Read(p)
+}
+
+// timeoutWriter sets the write deadline of conn before every write
+type timeoutWriter struct {
+	io.Writer
+	conn    net.Conn
+	timeout time.Duration
+}
+
+// Write implement interface io.Writer
+func (sf *timeoutWriter) Write(p []byte) (int, error) {
+	sf.conn.SetWriteDeadline(time.Now().Add(sf.timeout)) // nolint: errcheck
+	return sf.Writer.Write(p)
+}
+
+// CloseWrite implement interface closeWriter
+func (sf *timeoutWriter) CloseWrite() error {
+	if c, ok := sf.Writer.(closeWriter); ok {
+		return c.CloseWrite()
+	}
+	return nil
+}
+
+// relayLegs returns the reader and writer of the client and target leg,
+// with the symmetric deadline and the read/write timeouts if enabled.
+func (sf *Server) relayLegs(request *Request, writer io.Writer, target net.Conn) (
+	clientR io.Reader, clientW io.Writer, targetR io.Reader, targetW io.Writer) {
+	clientR, clientW, targetR, targetW = request.Reader, writer, target, target
+	client := readWriteTimeout{sf.clientReadTimeout, sf.clientWriteTimeout}
+	tt := readWriteTimeout{sf.targetReadTimeout, sf.targetWriteTimeout}
+	if lc := request.listener; lc != nil {
+		if lc.clientTimeout != nil {
+			client = *lc.clientTimeout
+		}
+		if lc.targetTimeout != nil {
+			tt = *lc.targetTimeout
+		}
+	}
+	if request.conn != nil {
+		if client.read > 0 {
+			clientR = &timeoutReader{clientR, request.conn, client.read}
+		}
+		if client.write > 0 {
+			clientW = &timeoutWriter{clientW, request.conn, client.write}
+		}
+	}
+	if tt.read > 0 {
+		targetR = &timeoutReader{targetR, target, tt.read}
+	}
+	if tt.write > 0 {
+		targetW = &timeoutWriter{targetW, target, tt.write}
+	}
+	if sf.symmetricTimeout > 0 && request.conn != nil {
+		d := &symmetricDeadline{
+			timeout: sf.symmetricTimeout,
+			conns:   []net.Conn{request.conn, target},
+			sess:    request.sess,
+		}
+		d.extend()
+		clientR, targetR = d.reader(clientR), d.reader(targetR)
+	}
+	return clientR, clientW, targetR, targetW
+}
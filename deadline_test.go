@@ -0,0 +1,64 @@
+package socks5
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/proxy"
+)
+
+func TestServer_SymmetricDeadline(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		// stalled peer
+		time.Sleep(time.Second)
+	}()
+
+	srv := NewServer(WithSymmetricDeadline(100 * time.Millisecond))
+	sl, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go srv.Serve(sl) // nolint: errcheck
+
+	dial, err := proxy.SOCKS5("tcp", sl.Addr().String(), nil, proxy.Direct)
+	require.NoError(t, err)
+	conn, err := dial.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	require.Eventually(t, func() bool {
+		sessions := srv.Sessions()
+		return len(sessions) == 1 && !sessions[0].Deadline.IsZero()
+	}, time.Second, 10*time.Millisecond)
+
+	// both legs are released by the deadline
+	conn.SetDeadline(time.Now().Add(500 * time.Millisecond)) // nolint: errcheck
+	_, err = conn.Read(make([]byte, 1))
+	require.Error(t, err)
+	ne, ok := err.(net.Error)
+	require.False(t, ok && ne.Timeout())
+}
+
+func TestServer_relayLegs(t *testing.T) {
+	client, _ := net.Pipe()
+	target, _ := net.Pipe()
+	srv := NewServer(
+		WithClientTimeout(time.Second, 0),
+		WithTargetTimeout(0, time.Second),
+	)
+	request := &Request{Reader: client, conn: client}
+
+	clientR, clientW, targetR, targetW := srv.relayLegs(request, client, target)
+	require.IsType(t, &timeoutReader{}, clientR)
+	require.Equal(t, client, clientW)
+	require.Equal(t, target, targetR)
+	require.IsType(t, &timeoutWriter{}, targetW)
+}
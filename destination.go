@@ -0,0 +1,124 @@
+package socks5
+
+import (
+	"net"
+	"strings"
+
+	"github.com/thinkgos/go-socks5/statute"
+)
+
+// DestinationTemplate is a template of the allowed destinations
+type DestinationTemplate struct {
+	// Host pattern, "*" or empty matches any host, "*.example.com" matches the subdomains,
+	// a CIDR matches the IPs in the network, otherwise matches the exact FQDN or IP.
+	Host string
+	// PortMin and PortMax is the port range, 0 PortMin matches any port,
+	// 0 PortMax means PortMin only.
+	PortMin int
+	PortMax int
+}
+
+// DestinationTemplates is optionally implemented by the CredentialStore, to return
+// the per-user destination templates which the server enforces automatically.
+type DestinationTemplates interface {
+	// Destinations returns the allowed destinations of the user,
+	// false means no restriction.
+	Destinations(user string) ([]DestinationTemplate, bool)
+}
+
+// TemplateCredentials is a CredentialStore with the per-user destination templates
+type TemplateCredentials struct {
+	CredentialStore
+	// Templates of the users, the user not in the map has no restriction
+	Templates map[string][]DestinationTemplate
+}
+
+// Destinations implement interface DestinationTemplates
+func (sf TemplateCredentials) Destinations(user string) ([]DestinationTemplate, bool) {
+	templates, ok := sf.Templates[user]
+	return templates, ok
+}
+
+// Match reports whether the destination matches the template,
+// fqdn is the requested host name, empty if requested by IP.
+func (sf DestinationTemplate) Match(fqdn string, dest *statute.AddrSpec) bool {
+	if sf.PortMin != 0 {
+		max := sf.PortMax
+		if max == 0 {
+			max = sf.PortMin
+		}
+		if dest.Port < sf.PortMin || dest.Port > max {
+			return false
+		}
+	}
+	return sf.matchHost(fqdn, dest.IP)
+}
+
+// matchHost reports whether the host matches the template, regardless of the port
+func (sf DestinationTemplate) matchHost(fqdn string, ip net.IP) bool {
+	switch host := sf.Host; {
+	case host == "" || host == "*":
+		return true
+	case strings.HasPrefix(host, "*."):
+		return fqdn != "" && strings.HasSuffix(strings.ToLower(fqdn), strings.ToLower(host[1:]))
+	case strings.Contains(host, "/"):
+		_, ipNet, err := net.ParseCIDR(host)
+		return err == nil && ip != nil && ipNet.Contains(ip)
+	default:
+		if hostIP := net.ParseIP(host); hostIP != nil {
+			return ip != nil && hostIP.Equal(ip)
+		}
+		return strings.EqualFold(host, fqdn)
+	}
+}
+
+// allowDestination reports whether the destination of the request is allowed by
+// the destination templates of the authenticated user. The declared address of the ASSOCIATE
+// and BIND is not the destination, their datagrams are checked by allowDatagram and the
+// incoming connection by allowBindPeer instead.
+func allowDestination(req *Request) bool {
+	if req.Command == statute.CommandAssociate || req.Command == statute.CommandBind {
+		return true
+	}
+	var fqdn string
+	if req.RawDestAddr != nil {
+		fqdn = req.RawDestAddr.FQDN
+	}
+	return matchDestinations(req.AuthContext, fqdn, req.DestAddr)
+}
+
+// allowDatagram reports whether the destination of the datagram of the ASSOCIATE is allowed by
+// the destination templates of the authenticated user, the FQDN is matched unresolved.
+func allowDatagram(req *Request, dest *statute.AddrSpec) bool {
+	return matchDestinations(req.AuthContext, dest.FQDN, dest)
+}
+
+// allowBindPeer reports whether the incoming connection of the BIND is from a host allowed by
+// the destination templates of the authenticated user, the ports of the templates are not
+// matched as the peer connects from an ephemeral port.
+func allowBindPeer(req *Request, addr net.Addr) bool {
+	if req.AuthContext == nil || req.AuthContext.Destinations == nil {
+		return true
+	}
+	ip := addrIP(addr)
+	for _, t := range req.AuthContext.Destinations {
+		if t.matchHost("", ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchDestinations reports whether the destination matches any of the destination templates,
+// true if the user has no templates.
+func matchDestinations(ac *AuthContext, fqdn string, dest *statute.AddrSpec) bool {
+	if ac == nil || ac.Destinations == nil {
+		return true
+	}
+	for _, t := range ac.Destinations {
+		if t.Match(fqdn, dest) {
+			return true
+		}
+	}
+	return false
+}
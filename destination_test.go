@@ -0,0 +1,126 @@
+package socks5
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/thinkgos/go-socks5/statute"
+)
+
+func TestDestinationTemplate_Match(t *testing.T) {
+	ip := &statute.AddrSpec{IP: net.IPv4(10, 1, 2, 3), Port: 443}
+	tests := []struct {
+		tpl  DestinationTemplate
+		fqdn string
+		want bool
+	}{
+		{DestinationTemplate{}, "", true},
+		{DestinationTemplate{Host: "*", PortMin: 80}, "", false},
+		{DestinationTemplate{Host: "*", PortMin: 400, PortMax: 500}, "", true},
+		{DestinationTemplate{Host: "10.0.0.0/8"}, "", true},
+		{DestinationTemplate{Host: "192.168.0.0/16"}, "", false},
+		{DestinationTemplate{Host: "10.1.2.3", PortMin: 443}, "", true},
+		{DestinationTemplate{Host: "*.Example.com"}, "api.example.COM", true},
+		{DestinationTemplate{Host: "*.example.com"}, "example.com", false},
+		{DestinationTemplate{Host: "example.com"}, "example.com", true},
+		{DestinationTemplate{Host: "example.com"}, "", false},
+	}
+	for _, tt := range tests {
+		require.Equal(t, tt.want, tt.tpl.Match(tt.fqdn, ip), "%+v %s", tt.tpl, tt.fqdn)
+	}
+}
+
+func TestAuth_DestinationTemplates(t *testing.T) {
+	cator := UserPassAuthenticator{
+		Credentials: TemplateCredentials{
+			CredentialStore: StaticCredentials{"foo": "bar"},
+			Templates: map[string][]DestinationTemplate{
+				"foo": {{Host: "127.0.0.1", PortMin: 8000, PortMax: 8080}},
+			},
+		},
+	}
+	ac, err := cator.Authenticate(bytes.NewBuffer([]byte{1, 3, 'f', 'o', 'o', 3, 'b', 'a', 'r'}), new(bytes.Buffer), "")
+	require.NoError(t, err)
+	require.Len(t, ac.Destinations, 1)
+
+	s := NewServer()
+	req, err := ParseRequest(bytes.NewBuffer([]byte{
+		statute.VersionSocks5, statute.CommandConnect, 0,
+		statute.ATYPIPv4, 127, 0, 0, 1, 0, 80,
+	}))
+	require.NoError(t, err)
+	req.AuthContext = ac
+
+	err = s.handleRequest(context.Background(), new(MockConn), req)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "blocked by rules")
+	require.Equal(t, "destination-template", req.Decision.Rule)
+}
+
+func TestAuth_DestinationTemplates_NoTemplates(t *testing.T) {
+	cator := UserPassAuthenticator{
+		Credentials: TemplateCredentials{
+			CredentialStore: StaticCredentials{"foo": "bar", "baz": "qux"},
+			Templates:       map[string][]DestinationTemplate{"foo": nil},
+		},
+	}
+	ac, err := cator.Authenticate(bytes.NewBuffer([]byte{1, 3, 'f', 'o', 'o', 3, 'b', 'a', 'r'}), new(bytes.Buffer), "")
+	require.NoError(t, err)
+	require.NotNil(t, ac.Destinations)
+	require.Empty(t, ac.Destinations)
+	require.False(t, matchDestinations(ac, "", &statute.AddrSpec{IP: net.IPv4(127, 0, 0, 1), Port: 80}))
+
+	// the user not listed has no restriction
+	ac, err = cator.Authenticate(bytes.NewBuffer([]byte{1, 3, 'b', 'a', 'z', 3, 'q', 'u', 'x'}), new(bytes.Buffer), "")
+	require.NoError(t, err)
+	require.Nil(t, ac.Destinations)
+	require.True(t, matchDestinations(ac, "", &statute.AddrSpec{IP: net.IPv4(127, 0, 0, 1), Port: 80}))
+}
+
+func TestServer_DestinationTemplates_Associate(t *testing.T) {
+	allowed, denied := udpEchoTarget(t), udpEchoTarget(t)
+	templates := func(next Handler) Handler {
+		return func(ctx context.Context, writer io.Writer, request *Request) error {
+			request.AuthContext.Destinations = []DestinationTemplate{
+				{Host: "127.0.0.1", PortMin: allowed.LocalAddr().(*net.UDPAddr).Port},
+			}
+			return next(ctx, writer, request)
+		}
+	}
+	proxy := serveSocks(t, WithMiddleware(templates))
+
+	// the declared address is not checked by the templates
+	conn, relay := associate(t, proxy, 0)
+	defer conn.Close()
+	client, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	defer client.Close()
+
+	rsp, err := udpPing(client, relay, allowed.LocalAddr(), "ping")
+	require.NoError(t, err)
+	require.Equal(t, "ping", rsp)
+	_, err = udpPing(client, relay, denied.LocalAddr(), "ping")
+	require.Error(t, err)
+}
+
+func TestAllowBindPeer(t *testing.T) {
+	req := &Request{AuthContext: &AuthContext{Destinations: []DestinationTemplate{
+		{Host: "10.0.0.0/8", PortMin: 80},
+	}}}
+	require.True(t, allowBindPeer(req, &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 50000}))
+	require.False(t, allowBindPeer(req, &net.TCPAddr{IP: net.IPv4(192, 168, 0, 1), Port: 80}))
+	require.True(t, allowBindPeer(&Request{}, &net.TCPAddr{IP: net.IPv4(192, 168, 0, 1), Port: 80}))
+
+	req.Command = statute.CommandAssociate
+	req.DestAddr = &statute.AddrSpec{AddrType: statute.ATYPIPv4, IP: net.IPv4zero}
+	require.True(t, allowDestination(req))
+	dest := &statute.AddrSpec{AddrType: statute.ATYPIPv4, IP: net.IPv4(10, 0, 0, 1), Port: 53}
+	require.False(t, allowDatagram(req, dest))
+	dest.Port = 80
+	require.True(t, allowDatagram(req, dest))
+}
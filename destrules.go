@@ -0,0 +1,107 @@
+package socks5
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/thinkgos/go-socks5/statute"
+)
+
+// DestinationAction is the action of the DestinationRule
+type DestinationAction uint8
+
+// destination action defined
+const (
+	DestinationAllow DestinationAction = iota
+	DestinationDeny
+	DestinationRedirect
+)
+
+// String implement interface fmt.Stringer
+func (a DestinationAction) String() string {
+	switch a {
+	case DestinationAllow:
+		return "allow"
+	case DestinationDeny:
+		return "deny"
+	case DestinationRedirect:
+		return "redirect"
+	}
+	return "unknown"
+}
+
+// DestinationRule applies the action to the destination matched the template,
+// such as {Host: "*.internal.corp", Action: DestinationDeny},
+// {Host: "10.0.0.0/8", PortMin: 8000, PortMax: 8999} or {Host: "*", PortMin: 25, Action: DestinationDeny}.
+type DestinationRule struct {
+	DestinationTemplate
+	// Name of the rule, recorded into the decision
+	Name string
+	// Action to the matched destination
+	Action DestinationAction
+	// RedirectTo is the "host:port" to connect instead for the redirect action
+	RedirectTo string
+}
+
+// DestinationRules is an implementation of the RuleSet which decides by the first
+// matched rule on the requested host name, the resolved ip and the port.
+// The redirect action allows the request and replaces its DestAddr.
+type DestinationRules struct {
+	Rules []DestinationRule
+	// DefaultAllow is the decision if no rule matched
+	DefaultAllow bool
+}
+
+// Validate checks the redirect targets of the rules
+func (sf *DestinationRules) Validate() error {
+	for i, r := range sf.Rules {
+		if r.Action != DestinationRedirect {
+			continue
+		}
+		if _, err := statute.ParseAddrSpec(r.RedirectTo); err != nil {
+			return fmt.Errorf("destination rule %s, invalid redirect %q, %v", r.name(i), r.RedirectTo, err)
+		}
+	}
+	return nil
+}
+
+// Allow implement interface RuleSet
+func (sf *DestinationRules) Allow(ctx context.Context, req *Request) (context.Context, bool) {
+	var fqdn string
+	if req.RawDestAddr != nil {
+		fqdn = req.RawDestAddr.FQDN
+	}
+	for i, r := range sf.Rules {
+		if !r.Match(fqdn, req.DestAddr) {
+			continue
+		}
+		name := r.name(i)
+		d := RuleDecision{
+			Rule:   name,
+			Allow:  r.Action != DestinationDeny,
+			Reason: fmt.Sprintf("matched destination rule %s, %s", name, r.Action),
+		}
+		if r.Action == DestinationRedirect {
+			dest, err := statute.ParseAddrSpec(r.RedirectTo)
+			if err != nil {
+				d.Allow = false
+				d.Reason = fmt.Sprintf("matched destination rule %s, invalid redirect, %v", name, err)
+			} else {
+				req.DestAddr = &dest
+				d.Reason += " to " + r.RedirectTo
+			}
+		}
+		return WithRuleDecision(ctx, d), d.Allow
+	}
+	return WithRuleDecision(ctx, RuleDecision{
+		Allow:  sf.DefaultAllow,
+		Reason: "no destination rule matched, default action",
+	}), sf.DefaultAllow
+}
+
+func (r DestinationRule) name(i int) string {
+	if r.Name != "" {
+		return r.Name
+	}
+	return fmt.Sprintf("#%d", i)
+}
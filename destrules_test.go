@@ -0,0 +1,76 @@
+package socks5
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/thinkgos/go-socks5/statute"
+)
+
+func TestDestinationRules(t *testing.T) {
+	rules := &DestinationRules{
+		Rules: []DestinationRule{
+			{DestinationTemplate: DestinationTemplate{Host: "*.internal.corp"}, Action: DestinationDeny},
+			{
+				DestinationTemplate: DestinationTemplate{Host: "10.0.0.0/8", PortMin: 8000, PortMax: 8999},
+				Name:                "intranet",
+			},
+			{
+				DestinationTemplate: DestinationTemplate{Host: "*", PortMin: 25},
+				Action:              DestinationRedirect,
+				RedirectTo:          "127.0.0.1:2525",
+			},
+		},
+	}
+	require.NoError(t, rules.Validate())
+
+	request := func(fqdn string, ip string, port int) *Request {
+		dest := &statute.AddrSpec{FQDN: fqdn, IP: net.ParseIP(ip), Port: port}
+		return &Request{RawDestAddr: dest, DestAddr: dest}
+	}
+
+	_, ok := rules.Allow(context.Background(), request("db.internal.corp", "10.1.1.1", 8080))
+	require.False(t, ok)
+
+	ctx, ok := rules.Allow(context.Background(), request("", "10.1.1.1", 8080))
+	require.True(t, ok)
+	d, _ := RuleDecisionFromContext(ctx)
+	require.Equal(t, "intranet", d.Rule)
+
+	_, ok = rules.Allow(context.Background(), request("", "10.1.1.1", 9000))
+	require.False(t, ok)
+
+	req := request("mail.example.com", "1.2.3.4", 25)
+	_, ok = rules.Allow(context.Background(), req)
+	require.True(t, ok)
+	require.Equal(t, "127.0.0.1:2525", req.DestAddr.String())
+	require.Equal(t, "mail.example.com", req.RawDestAddr.FQDN)
+
+	rules.Rules[2].RedirectTo = "bad"
+	require.Error(t, rules.Validate())
+}
+
+func TestServer_DestinationRedirectResolved(t *testing.T) {
+	target := echoTarget(t)
+	rules := &DestinationRules{
+		Rules: []DestinationRule{
+			{
+				DestinationTemplate: DestinationTemplate{Host: "*"},
+				Action:              DestinationRedirect,
+				RedirectTo:          net.JoinHostPort("echo.redirect.internal", strconv.Itoa(target.Port)),
+			},
+		},
+	}
+	resolver := resolverFunc(func(ctx context.Context, name string) (context.Context, net.IP, error) {
+		require.Equal(t, "echo.redirect.internal", name)
+		return ctx, target.IP, nil
+	})
+	proxy := serveSocks(t, WithRule(rules), WithResolver(resolver))
+
+	conn := relaySession(t, proxy, &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1})
+	conn.Close()
+}
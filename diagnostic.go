@@ -0,0 +1,25 @@
+package socks5
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/thinkgos/go-socks5/statute"
+)
+
+// handleDiagnostic replies the diagnostic probe, the observed client address as the bind address,
+// followed by the version of the server, the negotiated auth method and the user.
+func (sf *Server) handleDiagnostic(writer io.Writer, request *Request) error {
+	method := statute.MethodNoAuth
+	if request.AuthContext != nil {
+		method = request.AuthContext.Method
+	}
+	if err := SendReply(writer, statute.RepSuccess, request.RemoteAddr); err != nil {
+		return fmt.Errorf("failed to send reply, %v", err)
+	}
+	d := statute.NewDiagnostic(method, sf.diagnosticVersion, usernameOf(request))
+	if _, err := writer.Write(d.Bytes()); err != nil {
+		return fmt.Errorf("failed to send diagnostic, %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,170 @@
+package socks5
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+// Dialer dials out the targets of the requests, net.Dialer implements it.
+type Dialer interface {
+	DialContext(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+// DialFunc is the function to dial out, implement interface Dialer
+type DialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// DialContext implement interface Dialer
+func (f DialFunc) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	return f(ctx, network, addr)
+}
+
+// proxy hop type defined
+const (
+	ProxySOCKS5 = "socks5"
+	ProxyHTTP   = "http"
+)
+
+// ProxyHop is an upstream proxy of the ChainDialer
+type ProxyHop struct {
+	// Type is ProxySOCKS5 or ProxyHTTP, the http proxy must support the CONNECT method
+	Type string
+	// Addr is the "host:port" of the proxy
+	Addr string
+	// User and Password authenticate to the proxy, no authentication if User is empty
+	User     string
+	Password string
+}
+
+// ChainDialer dials the tcp targets through the upstream proxies in order, the first hop
+// is dialed by the Forward dialer, and each next hop is connected through the previous
+// ones, the target is connected by the last hop, such as client -> server -> hop1 -> hop2 -> target.
+type ChainDialer struct {
+	Hops []ProxyHop
+	// Forward dials the first hop, defaults to net.Dialer.
+	Forward Dialer
+	// UDPDirect dials the udp targets of ASSOCIATE by the Forward dialer,
+	// otherwise they fail, as the udp is not relayed through the hops.
+	UDPDirect bool
+}
+
+// NewChainDialer new a chain dialer through the hops
+func NewChainDialer(hops ...ProxyHop) (*ChainDialer, error) {
+	for i, hop := range hops {
+		if hop.Type != ProxySOCKS5 && hop.Type != ProxyHTTP {
+			return nil, fmt.Errorf("proxy hop #%d %s, unsupported type %q", i, hop.Addr, hop.Type)
+		}
+		if _, _, err := net.SplitHostPort(hop.Addr); err != nil {
+			return nil, fmt.Errorf("proxy hop #%d, invalid address %q, %v", i, hop.Addr, err)
+		}
+	}
+	return &ChainDialer{Hops: hops}, nil
+}
+
+// DialContext implement interface Dialer
+func (sf *ChainDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	var d Dialer = sf.Forward
+	if d == nil {
+		d = new(net.Dialer)
+	}
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+	default:
+		if sf.UDPDirect {
+			return d.DialContext(ctx, network, addr)
+		}
+		return nil, fmt.Errorf("network %s not supported through the proxy chain", network)
+	}
+	for _, hop := range sf.Hops {
+		switch hop.Type {
+		case ProxySOCKS5:
+			var auth *proxy.Auth
+			if hop.User != "" {
+				auth = &proxy.Auth{User: hop.User, Password: hop.Password}
+			}
+			pd, err := proxy.SOCKS5("tcp", hop.Addr, auth, forwardDialer{d})
+			if err != nil {
+				return nil, err
+			}
+			d = pd.(Dialer)
+		case ProxyHTTP:
+			d = &httpConnectDialer{hop, d}
+		default:
+			return nil, fmt.Errorf("proxy hop %s, unsupported type %q", hop.Addr, hop.Type)
+		}
+	}
+	return d.DialContext(ctx, network, addr)
+}
+
+// forwardDialer adapts the Dialer to the forward dialer of golang.org/x/net/proxy
+type forwardDialer struct {
+	Dialer
+}
+
+// Dial implement interface proxy.Dialer
+func (sf forwardDialer) Dial(network, addr string) (net.Conn, error) {
+	return sf.DialContext(context.Background(), network, addr)
+}
+
+// httpConnectDialer connects the targets by the CONNECT method of the http proxy
+type httpConnectDialer struct {
+	hop     ProxyHop
+	forward Dialer
+}
+
+// DialContext implement interface Dialer
+func (sf *httpConnectDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	conn, err := sf.forward.DialContext(ctx, network, sf.hop.Addr)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)          // nolint: errcheck
+		defer conn.SetDeadline(time.Time{}) // nolint: errcheck
+	}
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if sf.hop.User != "" {
+		cred := base64.StdEncoding.EncodeToString([]byte(sf.hop.User + ":" + sf.hop.Password))
+		req.Header.Set("Proxy-Authorization", "Basic "+cred)
+	}
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("http proxy %s, %v", sf.hop.Addr, err)
+	}
+	br := bufio.NewReader(conn)
+	rsp, err := http.ReadResponse(br, req)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("http proxy %s, %v", sf.hop.Addr, err)
+	}
+	if rsp.StatusCode != http.StatusOK {
+		rsp.Body.Close()
+		conn.Close()
+		return nil, fmt.Errorf("http proxy %s, connect %s, %s", sf.hop.Addr, addr, rsp.Status)
+	}
+	if br.Buffered() > 0 {
+		return &bufferedConn{conn, br}, nil
+	}
+	return conn, nil
+}
+
+// bufferedConn is the conn with the data already buffered by the reader
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+// Read implement interface io.Reader
+func (sf *bufferedConn) Read(p []byte) (int, error) { return sf.r.Read(p) }
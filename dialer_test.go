@@ -0,0 +1,91 @@
+package socks5
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/proxy"
+)
+
+// httpConnectProxy serves the CONNECT method requiring the Proxy-Authorization, counts the tunnels
+func httpConnectProxy(t *testing.T, auth string, tunnels *int32) net.Addr {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				req, err := http.ReadRequest(bufio.NewReader(conn))
+				if err != nil {
+					return
+				}
+				if req.Method != http.MethodConnect || req.Header.Get("Proxy-Authorization") != auth {
+					io.WriteString(conn, "HTTP/1.1 407 Proxy Authentication Required\r\n\r\n") // nolint: errcheck
+					return
+				}
+				target, err := net.Dial("tcp", req.Host)
+				if err != nil {
+					io.WriteString(conn, "HTTP/1.1 502 Bad Gateway\r\n\r\n") // nolint: errcheck
+					return
+				}
+				defer target.Close()
+				atomic.AddInt32(tunnels, 1)
+				io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n") // nolint: errcheck
+				go io.Copy(target, conn)                                            // nolint: errcheck
+				io.Copy(conn, target)                                               // nolint: errcheck
+			}()
+		}
+	}()
+	return l.Addr()
+}
+
+func TestChainDialer(t *testing.T) {
+	target := echoTarget(t)
+	var tunnels int32
+	httpHop := httpConnectProxy(t, "Basic Zm9vOmJhcg==", &tunnels)
+	socksHop := serveSocks(t, WithCredential(StaticCredentials{"user": "pass"}))
+
+	chain, err := NewChainDialer(
+		ProxyHop{Type: ProxySOCKS5, Addr: socksHop.String(), User: "user", Password: "pass"},
+		ProxyHop{Type: ProxyHTTP, Addr: httpHop.String(), User: "foo", Password: "bar"},
+	)
+	require.NoError(t, err)
+	proxyAddr := serveSocks(t, WithDialer(chain))
+
+	d, err := proxy.SOCKS5("tcp", proxyAddr.String(), nil, proxy.Direct)
+	require.NoError(t, err)
+	conn, err := d.Dial("tcp", target.String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("ping"))
+	require.NoError(t, err)
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(conn, buf)
+	require.NoError(t, err)
+	require.Equal(t, "ping", string(buf))
+	require.Equal(t, int32(1), atomic.LoadInt32(&tunnels))
+
+	// the udp is not relayed through the hops
+	_, err = chain.DialContext(context.Background(), "udp", target.String())
+	require.Error(t, err)
+
+	// the failure of a hop
+	chain.Hops[1].Password = "wrong"
+	_, err = chain.DialContext(context.Background(), "tcp", target.String())
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "407")
+
+	_, err = NewChainDialer(ProxyHop{Type: "socks4", Addr: "127.0.0.1:1080"})
+	require.Error(t, err)
+}
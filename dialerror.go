@@ -0,0 +1,80 @@
+package socks5
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"syscall"
+
+	"github.com/thinkgos/go-socks5/statute"
+)
+
+// ErrRuleDenied is returned by the dialers refusing the destination by a policy,
+// such as an egress firewall, it is replied with RepRuleFailure.
+var ErrRuleDenied = errors.New("connection not allowed by ruleset")
+
+// ReplyCoder is implemented by the errors of the dialers which decide the reply code of the failure
+type ReplyCoder interface {
+	ReplyCode() uint8
+}
+
+// ReplyError is the error of the dial with the reply code, so the custom dialers decide
+// the reply of the failure, such as relaying the reply of the upstream proxy.
+type ReplyError struct {
+	Rep uint8
+	Err error
+}
+
+// Error implement interface error
+func (sf *ReplyError) Error() string { return sf.Err.Error() }
+
+// Unwrap returns the underlying error
+func (sf *ReplyError) Unwrap() error { return sf.Err }
+
+// ReplyCode implement interface ReplyCoder
+func (sf *ReplyError) ReplyCode() uint8 { return sf.Rep }
+
+// ReplyOf maps the dial error to the reply code, the code of the ReplyCoder in the chain of
+// the error takes precedence, then the connection refused is RepConnectionRefused, the network
+// unreachable is RepNetworkUnreachable, the timeout is RepTTLExpired, ErrRuleDenied is
+// RepRuleFailure, and the others are RepHostUnreachable.
+func ReplyOf(err error) uint8 {
+	if err == nil {
+		return statute.RepSuccess
+	}
+	var rc ReplyCoder
+	if errors.As(err, &rc) && rc.ReplyCode() != statute.RepSuccess {
+		return rc.ReplyCode()
+	}
+	if errors.Is(err, ErrRuleDenied) {
+		return statute.RepRuleFailure
+	}
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		return statute.RepConnectionRefused
+	}
+	if errors.Is(err, syscall.ENETUNREACH) {
+		return statute.RepNetworkUnreachable
+	}
+	if errors.Is(err, syscall.EHOSTUNREACH) {
+		return statute.RepHostUnreachable
+	}
+	var ne net.Error
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, syscall.ETIMEDOUT) ||
+		(errors.As(err, &ne) && ne.Timeout()) {
+		return statute.RepTTLExpired
+	}
+	// the errors not wrapping the errno, such as the replies of the upstream socks5 proxies
+	msg := err.Error()
+	switch {
+	case strings.Contains(msg, "refused"):
+		return statute.RepConnectionRefused
+	case strings.Contains(msg, "network is unreachable"), strings.Contains(msg, "network unreachable"):
+		return statute.RepNetworkUnreachable
+	case strings.Contains(msg, "TTL expired"):
+		return statute.RepTTLExpired
+	case strings.Contains(msg, "not allowed by ruleset"):
+		return statute.RepRuleFailure
+	}
+	return statute.RepHostUnreachable
+}
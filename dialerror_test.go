@@ -0,0 +1,86 @@
+package socks5
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/thinkgos/go-socks5/statute"
+)
+
+func TestReplyOf(t *testing.T) {
+	opErr := func(err error) error {
+		return &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", err)}
+	}
+	for _, c := range []struct {
+		err  error
+		want uint8
+	}{
+		{nil, statute.RepSuccess},
+		{opErr(syscall.ECONNREFUSED), statute.RepConnectionRefused},
+		{opErr(syscall.ENETUNREACH), statute.RepNetworkUnreachable},
+		{opErr(syscall.EHOSTUNREACH), statute.RepHostUnreachable},
+		{opErr(syscall.ETIMEDOUT), statute.RepTTLExpired},
+		{&net.OpError{Op: "dial", Net: "tcp", Err: timeoutError{}}, statute.RepTTLExpired},
+		{fmt.Errorf("dial, %w", context.DeadlineExceeded), statute.RepTTLExpired},
+		{fmt.Errorf("egress, %w", ErrRuleDenied), statute.RepRuleFailure},
+		{&net.DNSError{Err: "no such host", Name: "x"}, statute.RepHostUnreachable},
+		{errors.New("socks connect tcp 1.2.3.4:1->5.6.7.8:80: unknown error network unreachable"),
+			statute.RepNetworkUnreachable},
+		{errors.New("socks connect: unknown error TTL expired"), statute.RepTTLExpired},
+		{fmt.Errorf("hop, %w", &ReplyError{statute.RepServerFailure, opErr(syscall.ECONNREFUSED)}),
+			statute.RepServerFailure},
+		{&ReplyError{statute.RepSuccess, opErr(syscall.ECONNREFUSED)}, statute.RepConnectionRefused},
+		{errors.New("boom"), statute.RepHostUnreachable},
+	} {
+		assert.Equal(t, c.want, ReplyOf(c.err), "%v", c.err)
+	}
+}
+
+func TestServer_DialErrorReply(t *testing.T) {
+	connect := func(proxy net.Addr, dst string) uint8 {
+		conn, err := net.Dial("tcp", proxy.String())
+		require.NoError(t, err)
+		defer conn.Close()
+		addr, err := statute.ParseAddrSpec(dst)
+		require.NoError(t, err)
+		req := bytes.NewBuffer([]byte{statute.VersionSocks5, 1, statute.MethodNoAuth})
+		req.Write(statute.Request{
+			Version: statute.VersionSocks5,
+			Command: statute.CommandConnect,
+			DstAddr: addr,
+		}.Bytes())
+		_, err = conn.Write(req.Bytes())
+		require.NoError(t, err)
+		_, err = statute.ParseMethodReply(conn)
+		require.NoError(t, err)
+		rep, err := statute.ParseReply(conn)
+		require.NoError(t, err)
+		return rep.Response
+	}
+
+	// the port not listened
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	closed := l.Addr().String()
+	l.Close()
+	assert.Equal(t, statute.RepConnectionRefused, connect(serveSocks(t), closed))
+
+	// the custom dialer decides the reply
+	proxy := serveSocks(t, WithDial(func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if addr == "10.0.0.1:80" {
+			return nil, fmt.Errorf("egress to %s, %w", addr, ErrRuleDenied)
+		}
+		return nil, &ReplyError{Rep: statute.RepNetworkUnreachable, Err: errors.New("no route")}
+	}))
+	assert.Equal(t, statute.RepRuleFailure, connect(proxy, "10.0.0.1:80"))
+	assert.Equal(t, statute.RepNetworkUnreachable, connect(proxy, "10.0.0.2:80"))
+}
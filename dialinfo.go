@@ -0,0 +1,84 @@
+package socks5
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"time"
+)
+
+// DialInfo is the metadata of the connection dialed to the target on the connect path,
+// such as to audit the egress ip usage on the multi-homed hosts.
+type DialInfo struct {
+	// Network dialed
+	Network string
+	// LocalAddr chosen for the connection to the target, the egress address
+	LocalAddr net.Addr
+	// RemoteAddr of the target connected
+	RemoteAddr net.Addr
+	// ResolvedIP of the FQDN destination, nil if the destination is an IP
+	ResolvedIP net.IP
+	// FQDN of the destination requested, empty if the destination is an IP
+	FQDN string
+	// Duration of the dial
+	Duration time.Duration
+}
+
+// AccessLogger is used to write the access log, such as *log.Logger
+type AccessLogger interface {
+	Printf(format string, args ...interface{})
+}
+
+// setDial records the dial info of the request and notifies the dial handle
+func (sf *Server) setDial(ctx context.Context, request *Request, network string, target net.Conn, d time.Duration) {
+	info := &DialInfo{
+		Network:    network,
+		LocalAddr:  target.LocalAddr(),
+		RemoteAddr: target.RemoteAddr(),
+		ResolvedIP: request.ResolvedIP,
+		FQDN:       request.ResolvedFQDN,
+		Duration:   d,
+	}
+	request.Dial = info
+	request.sess.setDial(info)
+	if sf.dialHandle != nil {
+		sf.dialHandle(ctx, request, *info)
+	}
+}
+
+func (sf *session) setDial(info *DialInfo) {
+	if sf != nil {
+		sf.mu.Lock()
+		sf.dial = info
+		sf.mu.Unlock()
+	}
+}
+
+// accessLog writes the access log line of the session end
+func (sf *Server) accessLog(sess *session, err error) {
+	if sf.accessLogger == nil {
+		return
+	}
+	s := sess.snapshot()
+	if sf.accessLogEncoder != nil {
+		rec := NewSessionRecord(s, sf.clock.Now(), err)
+		var line bytes.Buffer
+		if err := sf.accessLogEncoder.EncodeSession(&line, &rec); err != nil {
+			sf.logger.Errorf("access log: %v", err)
+			return
+		}
+		sf.accessLogger.Printf("%s", bytes.TrimSuffix(line.Bytes(), []byte{'\n'}))
+		return
+	}
+	egress, remote, resolved, dial := "-", "-", "-", time.Duration(0)
+	if s.Dial != nil {
+		egress, remote, dial = s.Dial.LocalAddr.String(), s.Dial.RemoteAddr.String(), s.Dial.Duration
+		if s.Dial.ResolvedIP != nil {
+			resolved = s.Dial.ResolvedIP.String()
+		}
+	}
+	sf.accessLogger.Printf("client=%v user=%q cmd=%d dest=%q egress=%s remote=%s resolved=%s dial=%v "+
+		"up=%d down=%d duration=%v reason=%s rule=%q rule_reason=%q err=%v",
+		s.ClientAddr, s.User, s.Command, s.DestAddr, egress, remote, resolved, dial,
+		s.BytesUp, s.BytesDown, sf.since(s.Started), s.CloseReason, s.Rule, s.RuleReason, err)
+}
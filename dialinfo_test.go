@@ -0,0 +1,60 @@
+package socks5
+
+import (
+	"context"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type syncBuffer struct {
+	bufferLogger
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.sb.Write(p)
+}
+
+func TestServer_DialInfo(t *testing.T) {
+	target := echoTarget(t)
+	dialed := make(chan DialInfo, 1)
+	closed := make(chan Session, 1)
+	accessLog := &syncBuffer{}
+	srv := NewServer(
+		WithDialHandle(func(_ context.Context, request *Request, info DialInfo) {
+			require.Equal(t, &info, request.Dial)
+			dialed <- info
+		}),
+		WithAccessLog(log.New(accessLog, "", 0)),
+		WithSessionCloseHandle(func(s Session, err error) { closed <- s }),
+		WithRule(&NamedRules{Rules: []NamedRule{{Name: "lan", Allow: true}}}),
+	)
+	proxy, _ := startServer(t, srv)
+	defer srv.Close()
+
+	conn := relaySession(t, proxy, target)
+	info := <-dialed
+	require.Equal(t, "tcp", info.Network)
+	require.Equal(t, target.String(), info.RemoteAddr.String())
+	require.Nil(t, info.ResolvedIP)
+	require.True(t, info.Duration > 0)
+
+	conn.Close()
+	select {
+	case s := <-closed:
+		require.Equal(t, info.LocalAddr, s.Dial.LocalAddr)
+		require.Equal(t, "lan", s.Rule)
+		require.Equal(t, "matched rule lan", s.RuleReason)
+	case <-time.After(time.Second):
+		t.Fatal("session close not notified")
+	}
+	line := accessLog.String()
+	require.Contains(t, line, "egress="+info.LocalAddr.String())
+	require.Contains(t, line, "remote="+target.String())
+	require.Contains(t, line, "reason=client_eof")
+	require.Contains(t, line, `rule="lan" rule_reason="matched rule lan"`)
+}
@@ -0,0 +1,62 @@
+package socks5
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/thinkgos/go-socks5/statute"
+)
+
+// errForwardTarget the forward target selected is invalid, the request is refused
+var errForwardTarget = errors.New("invalid forward target")
+
+// forwardTarget is a target of the static forwarding mode parsed by WithForward,
+// err is the parse error of the invalid one, which is reported by Server.Validate.
+type forwardTarget struct {
+	raw  string
+	addr statute.AddrSpec
+	err  error
+}
+
+func newForwardTarget(target string) *forwardTarget {
+	addr, err := statute.ParseAddrSpec(target)
+	if err == nil && addr.FQDN == "" && addr.IP == nil {
+		err = errors.New("missing host")
+	}
+	return &forwardTarget{target, addr, err}
+}
+
+// forwardAddr returns the target of the static forwarding mode,
+// false if the mode is disabled or no target is selected for the request,
+// an error if the target selected is invalid, which never falls back to the requested destination.
+func (sf *Server) forwardAddr(req *Request) (*statute.AddrSpec, bool, error) {
+	if req.Command != statute.CommandConnect {
+		return nil, false, nil
+	}
+	target := sf.forwardTarget
+	if req.AuthContext != nil {
+		if t, ok := sf.forwardUserTargets[req.AuthContext.Payload["username"]]; ok {
+			target = t
+		}
+	}
+	if target == nil {
+		return nil, false, nil
+	}
+	if target.err != nil {
+		return nil, true, fmt.Errorf("%w %s, %v", errForwardTarget, target.raw, target.err)
+	}
+	addr := target.addr
+	return &addr, true, nil
+}
+
+// refuseForward refuses the request of which the forward target selected is invalid
+func (sf *Server) refuseForward(write io.Writer, req *Request, err error) error {
+	sf.incError(PhaseRequest, statute.RepServerFailure)
+	sf.logger.Errorf("%v", err)
+	if err := sf.sendFailure(write, req.RemoteAddr, statute.RepServerFailure,
+		statute.DetailServerFailure); err != nil {
+		return fmt.Errorf("failed to send reply, %v", err)
+	}
+	return err
+}
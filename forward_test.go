@@ -0,0 +1,60 @@
+package socks5
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/thinkgos/go-socks5/statute"
+)
+
+func TestServer_forwardAddr(t *testing.T) {
+	srv := NewServer(WithForward("127.0.0.1:8080", map[string]string{"foo": "localhost:9090"}))
+
+	req := &Request{Request: statute.Request{Command: statute.CommandConnect}}
+	addr, ok, err := srv.forwardAddr(req)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "127.0.0.1:8080", addr.String())
+
+	req.AuthContext = &AuthContext{Method: statute.MethodUserPassAuth, Payload: map[string]string{"username": "foo"}}
+	addr, ok, err = srv.forwardAddr(req)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "localhost:9090", addr.String())
+
+	req.Command = statute.CommandAssociate
+	_, ok, _ = srv.forwardAddr(req)
+	require.False(t, ok)
+
+	_, ok, _ = NewServer().forwardAddr(&Request{Request: statute.Request{Command: statute.CommandConnect}})
+	require.False(t, ok)
+}
+
+func TestServer_forwardAddr_Invalid(t *testing.T) {
+	srv := NewServer(WithForward("127.0.0.1:8080", map[string]string{"foo": "no-port", "bar": ""}))
+	for _, user := range []string{"foo", "bar"} {
+		req := &Request{
+			Request:     statute.Request{Command: statute.CommandConnect},
+			AuthContext: &AuthContext{Payload: map[string]string{"username": user}},
+		}
+		_, ok, err := srv.forwardAddr(req)
+		require.True(t, ok)
+		require.True(t, errors.Is(err, errForwardTarget))
+	}
+
+	// refused rather than connected to the requested destination
+	req, err := ParseRequest(bytes.NewBuffer([]byte{
+		statute.VersionSocks5, statute.CommandConnect, 0,
+		statute.ATYPIPv4, 127, 0, 0, 1, 0, 80,
+	}))
+	require.NoError(t, err)
+	req.AuthContext = &AuthContext{Payload: map[string]string{"username": "foo"}}
+	resp := new(MockConn)
+	err = srv.handleRequest(context.Background(), resp, req)
+	require.True(t, errors.Is(err, errForwardTarget))
+	require.Equal(t, statute.RepServerFailure, resp.buf.Bytes()[1])
+}
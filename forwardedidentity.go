@@ -0,0 +1,134 @@
+package socks5
+
+import (
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/thinkgos/go-socks5/statute"
+)
+
+// defaultForwardedMaxSkew the default max skew of the forwarded identity timestamp
+const defaultForwardedMaxSkew = 30 * time.Second
+
+// forwarded identity errors
+var (
+	errForwardedSignature = errors.New("forwarded identity signature mismatch")
+	errForwardedExpired   = errors.New("forwarded identity timestamp out of the skew")
+	errForwardedClient    = errors.New("forwarded identity invalid client address")
+)
+
+// ForwardedIdentityAuthenticator authenticates the front proxy which forwards the identity of the
+// original client by the vendor method statute.MethodForwardedIdentity, the identity is signed
+// by the secret shared by the proxies for the random nonce the server challenges by, so a handshake
+// seen is not replayed. The request is then handled as from the original client, the rules and the
+// accounting see the client address and the username forwarded, Request.ForwardedBy is the front proxy.
+// The signature authenticates only the handshake, neither the identity is encrypted nor the session
+// after it is protected, the method requires TLS between the proxies, see ListenAndServeTLS.
+// The front proxy built on this package forwards the identity by ccsocks5.ForwardedIdentityAuth,
+// such as:
+//
+//	socks5.WithDialer(socks5.DialFunc(func(ctx context.Context, network, addr string) (net.Conn, error) {
+//		req, _ := socks5.RequestFromContext(ctx)
+//		var user string
+//		if req.AuthContext != nil {
+//			user = req.AuthContext.Payload["username"]
+//		}
+//		return ccsocks5.NewClient(nextHop, ccsocks5.WithAuthMethods(ccsocks5.ForwardedIdentityAuth{
+//			Secret: secret, User: user, Client: req.RemoteAddr.String(),
+//		})).Dial(network, addr)
+//	}))
+type ForwardedIdentityAuthenticator struct {
+	Secret []byte
+	// MaxSkew of the timestamp from the local clock, defaults to 30s
+	MaxSkew time.Duration
+	// Clock to check the timestamp against, nil means the system clock
+	Clock Clock
+}
+
+// GetCode implement interface Authenticator
+func (a ForwardedIdentityAuthenticator) GetCode() uint8 { return statute.MethodForwardedIdentity }
+
+// Authenticate implement interface Authenticator
+func (a ForwardedIdentityAuthenticator) Authenticate(reader io.Reader, writer io.Writer,
+	userAddr string) (*AuthContext, error) {
+	fc, err := statute.NewForwardedChallenge()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := writer.Write(append([]byte{statute.VersionSocks5, statute.MethodForwardedIdentity},
+		fc.Bytes()...)); err != nil {
+		return nil, err
+	}
+	fi, err := statute.ParseForwardedIdentity(reader)
+	if err != nil {
+		return nil, err
+	}
+	if err = a.check(fi, fc.Nonce); err != nil {
+		if _, err := writer.Write([]byte{statute.ForwardedIdentityVersion, statute.AuthFailure}); err != nil {
+			return nil, err
+		}
+		return nil, &AuthError{User: fi.User, Err: err}
+	}
+	if _, err := writer.Write([]byte{statute.ForwardedIdentityVersion, statute.AuthSuccess}); err != nil {
+		return nil, err
+	}
+	return &AuthContext{
+		Method: statute.MethodForwardedIdentity,
+		Payload: map[string]string{
+			"username":      fi.User,
+			"forwarded_for": fi.Client,
+			"forwarded_by":  userAddr,
+		},
+	}, nil
+}
+
+// check verifies the signature for the nonce, the timestamp and the client address of the forwarded identity
+func (a ForwardedIdentityAuthenticator) check(fi statute.ForwardedIdentity, nonce []byte) error {
+	if len(a.Secret) == 0 || !fi.Verify(a.Secret, nonce) {
+		return errForwardedSignature
+	}
+	maxSkew := a.MaxSkew
+	if maxSkew <= 0 {
+		maxSkew = defaultForwardedMaxSkew
+	}
+	if skew := clockOrSystem(a.Clock).Now().Sub(time.Unix(fi.Timestamp, 0)); skew > maxSkew || skew < -maxSkew {
+		return errForwardedExpired
+	}
+	if parseClientAddr(fi.Client) == nil {
+		return errForwardedClient
+	}
+	return nil
+}
+
+// parseClientAddr parses the host:port of the ip, nil if invalid
+func parseClientAddr(s string) net.Addr {
+	host, port, err := net.SplitHostPort(s)
+	if err != nil {
+		return nil
+	}
+	ip := net.ParseIP(host)
+	p, err := strconv.Atoi(port)
+	if ip == nil || err != nil || p < 0 || p > 0xffff {
+		return nil
+	}
+	return &net.TCPAddr{IP: unmapIP(ip), Port: p}
+}
+
+// forwardedClient returns the original client of the forwarded identity, nil if not forwarded
+func forwardedClient(ac *AuthContext) net.Addr {
+	if ac == nil || ac.Method != statute.MethodForwardedIdentity {
+		return nil
+	}
+	return parseClientAddr(ac.Payload["forwarded_for"])
+}
+
+// peerAddr returns the address of the tcp peer, which is the front proxy if the identity forwarded
+func (sf *Request) peerAddr() net.Addr {
+	if sf.ForwardedBy != nil {
+		return sf.ForwardedBy
+	}
+	return sf.RemoteAddr
+}
@@ -0,0 +1,105 @@
+package socks5
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/thinkgos/go-socks5/statute"
+)
+
+// forwardIdentity runs the handshake of the authenticator, the identity is signed by sign
+// for the nonce challenged, it returns the replies sent and the identity signed.
+func forwardIdentity(t *testing.T, cator ForwardedIdentityAuthenticator,
+	sign func(nonce []byte) statute.ForwardedIdentity) (*AuthContext, []byte, statute.ForwardedIdentity, error) {
+	client, server := net.Pipe()
+	defer client.Close()
+	type result struct {
+		ac  *AuthContext
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		ac, err := cator.Authenticate(server, server, "192.168.1.1:2000")
+		server.Close()
+		done <- result{ac, err}
+	}()
+	method := make([]byte, 2)
+	_, err := io.ReadFull(client, method)
+	require.NoError(t, err)
+	fc, err := statute.ParseForwardedChallenge(client)
+	require.NoError(t, err)
+	fi := sign(fc.Nonce)
+	_, err = client.Write(fi.Bytes())
+	require.NoError(t, err)
+	rsp, err := ioutil.ReadAll(client)
+	require.NoError(t, err)
+	r := <-done
+	return r.ac, append(method, rsp...), fi, r.err
+}
+
+func TestForwardedIdentityAuthenticator(t *testing.T) {
+	secret := []byte("secret")
+	clock := newManualClock()
+	now := clock.Now()
+	cator := ForwardedIdentityAuthenticator{Secret: secret, Clock: clock}
+
+	ac, rsp, seen, err := forwardIdentity(t, cator, func(nonce []byte) statute.ForwardedIdentity {
+		return statute.NewForwardedIdentity(secret, nonce, "foo", "10.0.0.1:1000", now.Unix()-10)
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []byte{statute.VersionSocks5, statute.MethodForwardedIdentity,
+		statute.ForwardedIdentityVersion, statute.AuthSuccess}, rsp)
+	assert.Equal(t, "foo", ac.Payload["username"])
+	assert.Equal(t, "192.168.1.1:2000", ac.Payload["forwarded_by"])
+	assert.Equal(t, &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1).To4(), Port: 1000}, forwardedClient(ac))
+
+	client, ts := "10.0.0.1:1000", now.Unix()
+	for name, tc := range map[string]struct {
+		sign func(nonce []byte) statute.ForwardedIdentity
+		err  error
+	}{
+		"wrong secret": {func(nonce []byte) statute.ForwardedIdentity {
+			return statute.NewForwardedIdentity([]byte("x"), nonce, "foo", client, ts)
+		}, errForwardedSignature},
+		"replayed": {func([]byte) statute.ForwardedIdentity { return seen }, errForwardedSignature},
+		"expired": {func(nonce []byte) statute.ForwardedIdentity {
+			return statute.NewForwardedIdentity(secret, nonce, "foo", client, ts-31)
+		}, errForwardedExpired},
+		"future": {func(nonce []byte) statute.ForwardedIdentity {
+			return statute.NewForwardedIdentity(secret, nonce, "foo", client, ts+31)
+		}, errForwardedExpired},
+		"invalid client": {func(nonce []byte) statute.ForwardedIdentity {
+			return statute.NewForwardedIdentity(secret, nonce, "foo", "host:1000", ts)
+		}, errForwardedClient},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, rsp, _, err := forwardIdentity(t, cator, tc.sign)
+			require.True(t, errors.Is(err, tc.err))
+			assert.Equal(t, []byte{statute.VersionSocks5, statute.MethodForwardedIdentity,
+				statute.ForwardedIdentityVersion, statute.AuthFailure}, rsp)
+		})
+	}
+}
+
+func TestSession_ForwardedIdentity(t *testing.T) {
+	front := &net.TCPAddr{IP: net.IPv4(192, 168, 1, 1).To4(), Port: 2000}
+	sess := &session{clientAddr: front}
+	sess.setRequest(&Request{
+		Request:     statute.Request{Command: statute.CommandConnect},
+		RawDestAddr: &statute.AddrSpec{IP: net.IPv4(1, 2, 3, 4), Port: 80},
+		AuthContext: &AuthContext{
+			Method:  statute.MethodForwardedIdentity,
+			Payload: map[string]string{"username": "foo", "forwarded_for": "10.0.0.1:1000"},
+		},
+	})
+	s := sess.snapshot()
+	assert.Equal(t, "10.0.0.1:1000", s.ClientAddr.String())
+	assert.Equal(t, front, s.ForwardedBy)
+	assert.Equal(t, "foo", s.User)
+}
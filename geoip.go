@@ -0,0 +1,130 @@
+package socks5
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strings"
+)
+
+// GeoIP looks up the country of the address
+type GeoIP interface {
+	// Country returns the ISO 3166-1 alpha-2 code of the ip, such as "US", empty if unknown
+	Country(ip net.IP) string
+}
+
+// GeoIPFunc is an adapter to allow the use of ordinary functions as GeoIP,
+// such as over a MaxMind database reader.
+type GeoIPFunc func(ip net.IP) string
+
+// Country implement interface GeoIP
+func (f GeoIPFunc) Country(ip net.IP) string { return f(ip) }
+
+// countryNetwork is a network of the country
+type countryNetwork struct {
+	network *net.IPNet
+	start   net.IP // 16 bytes
+	country string
+}
+
+// CountryTable is a GeoIP of the networks to the countries, the networks must not overlap,
+// such as the blocks of the GeoLite2 Country CSV.
+type CountryTable struct {
+	networks []countryNetwork
+}
+
+// NewCountryTable new a country table of the CIDR to the country code, such as "1.0.0.0/24": "AU"
+func NewCountryTable(networks map[string]string) (*CountryTable, error) {
+	sf := &CountryTable{networks: make([]countryNetwork, 0, len(networks))}
+	for cidr, country := range networks {
+		network, err := parseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		sf.networks = append(sf.networks, countryNetwork{network, network.IP.To16(), strings.ToUpper(country)})
+	}
+	sort.Slice(sf.networks, func(i, j int) bool {
+		return bytes.Compare(sf.networks[i].start, sf.networks[j].start) < 0
+	})
+	return sf, nil
+}
+
+// LoadCountryCSV loads the country table of the lines "network,country", such as "1.0.0.0/24,AU",
+// the empty lines and the comments starting with '#' are skipped.
+func LoadCountryCSV(r io.Reader) (*CountryTable, error) {
+	networks := make(map[string]string)
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, ",")
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("invalid country line %d, %q", n, line)
+		}
+		networks[strings.TrimSpace(fields[0])] = strings.TrimSpace(fields[1])
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return NewCountryTable(networks)
+}
+
+// Country implement interface GeoIP
+func (sf *CountryTable) Country(ip net.IP) string {
+	ip16 := ip.To16()
+	if ip16 == nil {
+		return ""
+	}
+	// the last network starting before the ip
+	i := sort.Search(len(sf.networks), func(i int) bool {
+		return bytes.Compare(sf.networks[i].start, ip16) > 0
+	})
+	if i > 0 && sf.networks[i-1].network.Contains(ip) {
+		return sf.networks[i-1].country
+	}
+	return ""
+}
+
+// CountryAny is the key of the CountryPolicy of the countries not listed, including the unknown
+const CountryAny = "*"
+
+// CountryPolicy is the policy of the clients by the country of the source address
+type CountryPolicy struct {
+	// Deny rejects the clients before the handshake
+	Deny bool
+	// AuthMethods required from the clients, such as the username/password from the other
+	// countries, nil means the methods of the server or the listener.
+	AuthMethods []Authenticator
+	// ByteRate limits the bytes per second of a session, both directions,
+	// 0 means the limit of WithConnRateLimit.
+	ByteRate int
+}
+
+// clientCountry looks up the country of the client and the policy of it, false if no policy
+func (sf *Server) clientCountry(remote net.Addr) (string, *CountryPolicy, bool) {
+	if sf.geoIP == nil {
+		return "", nil, false
+	}
+	country := sf.geoIP.Country(unmapIP(addrIP(remote)))
+	p, ok := sf.countryPolicies[country]
+	if !ok {
+		p, ok = sf.countryPolicies[CountryAny]
+	}
+	if !ok {
+		return country, nil, false
+	}
+	return country, p, true
+}
+
+// sessionByteRate is the bytes per second limit of the session of the request
+func (sf *Server) sessionByteRate(request *Request) int {
+	if request.countryPolicy != nil && request.countryPolicy.ByteRate > 0 {
+		return request.countryPolicy.ByteRate
+	}
+	return sf.connByteRate
+}
@@ -0,0 +1,96 @@
+package socks5
+
+import (
+	"context"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/thinkgos/go-socks5/statute"
+)
+
+func TestCountryTable(t *testing.T) {
+	table, err := LoadCountryCSV(strings.NewReader(`# network,country
+1.0.0.0/24,au
+1.0.1.0/24,CN
+
+2001:db8::/32,JP
+10.0.0.1,ZZ
+`))
+	require.NoError(t, err)
+	assert.Equal(t, "AU", table.Country(net.IPv4(1, 0, 0, 9)))
+	assert.Equal(t, "CN", table.Country(net.IPv4(1, 0, 1, 255)))
+	assert.Equal(t, "", table.Country(net.IPv4(1, 0, 2, 0)))
+	assert.Equal(t, "JP", table.Country(net.ParseIP("2001:db8::1")))
+	assert.Equal(t, "ZZ", table.Country(net.IPv4(10, 0, 0, 1)))
+	assert.Equal(t, "", table.Country(net.IPv4(10, 0, 0, 2)))
+	assert.Equal(t, "", table.Country(nil))
+
+	_, err = LoadCountryCSV(strings.NewReader("1.0.0.0/24"))
+	require.Error(t, err)
+	_, err = NewCountryTable(map[string]string{"1.0.0.0/33": "AU"})
+	require.Error(t, err)
+}
+
+func TestServer_ClientCountry(t *testing.T) {
+	target := echoTarget(t)
+	loopback := GeoIPFunc(func(ip net.IP) string {
+		if ip.IsLoopback() {
+			return "ZZ"
+		}
+		return ""
+	})
+	// negotiate offers no auth only, returns the method chosen
+	negotiate := func(proxy net.Addr) (net.Conn, uint8, error) {
+		conn, err := net.Dial("tcp", proxy.String())
+		require.NoError(t, err)
+		t.Cleanup(func() { conn.Close() })
+		conn.SetDeadline(time.Now().Add(time.Second)) // nolint: errcheck
+		_, err = conn.Write([]byte{statute.VersionSocks5, 1, statute.MethodNoAuth})
+		require.NoError(t, err)
+		rep, err := statute.ParseMethodReply(conn)
+		return conn, rep.Method, err
+	}
+
+	// denied before the handshake
+	proxy := serveSocks(t, WithClientCountry(loopback, map[string]CountryPolicy{"zz": {Deny: true}}))
+	_, _, err := negotiate(proxy)
+	assert.Equal(t, io.EOF, err)
+	proxy = serveSocks(t, WithClientCountry(GeoIPFunc(func(net.IP) string { return "" }),
+		map[string]CountryPolicy{CountryAny: {Deny: true}}))
+	_, _, err = negotiate(proxy)
+	assert.Equal(t, io.EOF, err)
+
+	// the username/password required
+	userPass := UserPassAuthenticator{Credentials: StaticCredentials{"foo": "bar"}}
+	proxy = serveSocks(t, WithClientCountry(loopback, map[string]CountryPolicy{
+		"ZZ": {AuthMethods: []Authenticator{userPass}},
+	}))
+	_, method, err := negotiate(proxy)
+	require.NoError(t, err)
+	assert.Equal(t, statute.MethodNoAcceptable, method)
+
+	// the other countries served as usual, the country passed to the rule set
+	countries := make(chan string, 1)
+	proxy = serveSocks(t,
+		WithClientCountry(loopback, map[string]CountryPolicy{"CN": {Deny: true}}),
+		WithRule(ruleFunc(func(ctx context.Context, req *Request) (context.Context, bool) {
+			countries <- req.ClientCountry
+			return ctx, true
+		})),
+	)
+	relaySession(t, proxy, target).Close()
+	assert.Equal(t, "ZZ", <-countries)
+}
+
+func TestServer_SessionByteRate(t *testing.T) {
+	srv := NewServer(WithConnRateLimit(100))
+	assert.Equal(t, 100, srv.sessionByteRate(&Request{}))
+	assert.Equal(t, 10, srv.sessionByteRate(&Request{countryPolicy: &CountryPolicy{ByteRate: 10}}))
+	assert.Equal(t, 100, srv.sessionByteRate(&Request{countryPolicy: &CountryPolicy{Deny: false}}))
+}
@@ -4,5 +4,6 @@ go 1.14
 
 require (
 	github.com/stretchr/testify v1.6.1
-    golang.org/x/net v0.0.0-20200324143707-d3edc9973b7e
+	golang.org/x/crypto v0.0.0-20200622213623-75b288015ac9
+	golang.org/x/net v0.0.0-20200324143707-d3edc9973b7e
 )
@@ -0,0 +1,209 @@
+package socks5
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/thinkgos/go-socks5/statute"
+)
+
+// GSSAPIContext is the security context of a GSSAPI backend, such as Kerberos or SPNEGO
+type GSSAPIContext interface {
+	// AcceptSecContext processes the token of the client, returns the output token sent
+	// back to the client and whether the context is established.
+	AcceptSecContext(token []byte) (output []byte, established bool, err error)
+	// Wrap protects the message, with the confidentiality if confidential.
+	Wrap(msg []byte, confidential bool) ([]byte, error)
+	// Unwrap verifies and decrypts the protected token.
+	Unwrap(token []byte) ([]byte, error)
+	// SourceName returns the authenticated principal of the client.
+	SourceName() string
+}
+
+// GSSAPIBackend creates the security context for each connection
+type GSSAPIBackend interface {
+	NewContext(userAddr string) (GSSAPIContext, error)
+}
+
+// ContextGSSAPIBackend is optionally implemented by the GSSAPIBackend, to receive the
+// context of the connection, such as to bound the round trip to the KDC.
+type ContextGSSAPIBackend interface {
+	GSSAPIBackend
+	NewContextWith(ctx context.Context, userAddr string) (GSSAPIContext, error)
+}
+
+// GSSAPIAuthenticator is used to handle the GSSAPI authentication, see RFC 1961.
+// After authenticated, the subsequent request and data are encapsulated per the
+// protection level negotiated with the client.
+type GSSAPIAuthenticator struct {
+	Backend GSSAPIBackend
+}
+
+// GetCode implement interface Authenticator
+func (a GSSAPIAuthenticator) GetCode() uint8 { return statute.MethodGSSAPI }
+
+// Authenticate implement interface Authenticator
+func (a GSSAPIAuthenticator) Authenticate(reader io.Reader, writer io.Writer, userAddr string) (*AuthContext, error) {
+	return a.AuthenticateContext(context.Background(), reader, writer, userAddr)
+}
+
+// AuthenticateContext implement interface ContextAuthenticator
+func (a GSSAPIAuthenticator) AuthenticateContext(ctx context.Context, reader io.Reader, writer io.Writer,
+	userAddr string) (*AuthContext, error) {
+	// reply the client to use gssapi auth
+	if _, err := writer.Write([]byte{statute.VersionSocks5, statute.MethodGSSAPI}); err != nil {
+		return nil, err
+	}
+	var gc GSSAPIContext
+	var err error
+	if b, ok := a.Backend.(ContextGSSAPIBackend); ok {
+		gc, err = b.NewContextWith(ctx, userAddr)
+	} else {
+		gc, err = a.Backend.NewContext(userAddr)
+	}
+	if err != nil {
+		return nil, gssapiAbort(writer, err)
+	}
+
+	// security context establishment
+	for established := false; !established; {
+		msg, err := statute.ParseGSSAPIMessage(reader)
+		if err != nil {
+			return nil, err
+		}
+		if msg.MTyp != statute.GSSAPITypeAuth {
+			return nil, gssapiAbort(writer, fmt.Errorf("unexpected gssapi message type %d", msg.MTyp))
+		}
+		var output []byte
+		output, established, err = gc.AcceptSecContext(msg.Token)
+		if err != nil {
+			return nil, gssapiAbort(writer, err)
+		}
+		if len(output) > 0 {
+			if err := writeGSSAPIMessage(writer, statute.GSSAPITypeAuth, output); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// protection level negotiation
+	msg, err := statute.ParseGSSAPIMessage(reader)
+	if err != nil {
+		return nil, err
+	}
+	if msg.MTyp != statute.GSSAPITypeProtection {
+		return nil, gssapiAbort(writer, fmt.Errorf("unexpected gssapi message type %d", msg.MTyp))
+	}
+	level, err := gc.Unwrap(msg.Token)
+	if err != nil || len(level) != 1 ||
+		level[0] < statute.GSSAPIProtectionIntegrity || level[0] > statute.GSSAPIProtectionSelective {
+		return nil, gssapiAbort(writer, errors.New("invalid gssapi protection level"))
+	}
+	// selective protection is served with the confidentiality
+	confidential := level[0] != statute.GSSAPIProtectionIntegrity
+	token, err := gc.Wrap(level, confidential)
+	if err != nil {
+		return nil, gssapiAbort(writer, err)
+	}
+	if err := writeGSSAPIMessage(writer, statute.GSSAPITypeProtection, token); err != nil {
+		return nil, err
+	}
+
+	return &AuthContext{
+		Method: statute.MethodGSSAPI,
+		Payload: map[string]string{
+			"username":   gc.SourceName(),
+			"protection": strconv.Itoa(int(level[0])),
+		},
+		encapsulate: func(r io.Reader, w io.Writer) (io.Reader, io.Writer) {
+			return &gssapiReader{r: r, gc: gc}, &gssapiWriter{w: w, gc: gc, confidential: confidential}
+		},
+	}, nil
+}
+
+// gssapiAbort sends the abort message and returns the authentication failure
+func gssapiAbort(w io.Writer, err error) error {
+	abort := statute.GSSAPIMessage{Ver: statute.GSSAPIVersion, MTyp: statute.GSSAPITypeAbort}
+	w.Write(abort.Bytes()) // nolint: errcheck
+	return fmt.Errorf("%w, gssapi, %v", statute.ErrUserAuthFailed, err)
+}
+
+func writeGSSAPIMessage(w io.Writer, mtyp byte, token []byte) error {
+	msg, err := statute.NewGSSAPIMessage(mtyp, token)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(msg.Bytes())
+	return err
+}
+
+// gssapiReader reads the encapsulated messages
+type gssapiReader struct {
+	r       io.Reader
+	gc      GSSAPIContext
+	pending []byte
+}
+
+// Read implement interface io.Reader
+func (sf *gssapiReader) Read(p []byte) (int, error) {
+	for len(sf.pending) == 0 {
+		msg, err := statute.ParseGSSAPIMessage(sf.r)
+		if err != nil {
+			return 0, err
+		}
+		if msg.MTyp != statute.GSSAPITypeEncapsulation {
+			return 0, fmt.Errorf("unexpected gssapi message type %d", msg.MTyp)
+		}
+		if sf.pending, err = sf.gc.Unwrap(msg.Token); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, sf.pending)
+	sf.pending = sf.pending[n:]
+	return n, nil
+}
+
+// Buffered implement interface bufferedReader, the data decapsulated but not read yet
+func (sf *gssapiReader) Buffered() int { return len(sf.pending) }
+
+// gssapiMaxChunk is the max data encapsulated in a message, leaves room for the wrap overhead
+const gssapiMaxChunk = 32 * 1024
+
+// gssapiWriter writes the encapsulated messages
+type gssapiWriter struct {
+	w            io.Writer
+	gc           GSSAPIContext
+	confidential bool
+}
+
+// Write implement interface io.Writer
+func (sf *gssapiWriter) Write(p []byte) (int, error) {
+	n := 0
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > gssapiMaxChunk {
+			chunk = chunk[:gssapiMaxChunk]
+		}
+		token, err := sf.gc.Wrap(chunk, sf.confidential)
+		if err != nil {
+			return n, err
+		}
+		if err := writeGSSAPIMessage(sf.w, statute.GSSAPITypeEncapsulation, token); err != nil {
+			return n, err
+		}
+		n += len(chunk)
+		p = p[len(chunk):]
+	}
+	return n, nil
+}
+
+// CloseWrite implement interface closeWriter
+func (sf *gssapiWriter) CloseWrite() error {
+	if c, ok := sf.w.(closeWriter); ok {
+		return c.CloseWrite()
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package socks5
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/thinkgos/go-socks5/statute"
+)
+
+// mockGSSAPI established after two tokens, wraps the message with a 'w' prefix
+type mockGSSAPI struct{ step int }
+
+func (m *mockGSSAPI) NewContext(string) (GSSAPIContext, error) { return m, nil }
+
+func (m *mockGSSAPI) AcceptSecContext(token []byte) ([]byte, bool, error) {
+	m.step++
+	switch {
+	case m.step == 1 && string(token) == "hello":
+		return []byte("challenge"), false, nil
+	case m.step == 2 && string(token) == "response":
+		return nil, true, nil
+	}
+	return nil, false, errors.New("bad token")
+}
+
+func (m *mockGSSAPI) Wrap(msg []byte, _ bool) ([]byte, error) { return append([]byte{'w'}, msg...), nil }
+
+func (m *mockGSSAPI) Unwrap(token []byte) ([]byte, error) {
+	if len(token) == 0 || token[0] != 'w' {
+		return nil, errors.New("bad wrap")
+	}
+	return token[1:], nil
+}
+
+func (m *mockGSSAPI) SourceName() string { return "alice@EXAMPLE.COM" }
+
+func gssapiMessage(t *testing.T, mtyp byte, token []byte) []byte {
+	msg, err := statute.NewGSSAPIMessage(mtyp, token)
+	require.NoError(t, err)
+	return msg.Bytes()
+}
+
+func TestGSSAPIAuthenticator(t *testing.T) {
+	req := new(bytes.Buffer)
+	req.Write(gssapiMessage(t, statute.GSSAPITypeAuth, []byte("hello")))
+	req.Write(gssapiMessage(t, statute.GSSAPITypeAuth, []byte("response")))
+	req.Write(gssapiMessage(t, statute.GSSAPITypeProtection, []byte{'w', statute.GSSAPIProtectionIntegrity}))
+	req.Write(gssapiMessage(t, statute.GSSAPITypeEncapsulation, []byte("wping")))
+	rsp := new(bytes.Buffer)
+
+	cator := GSSAPIAuthenticator{Backend: &mockGSSAPI{}}
+	ac, err := cator.Authenticate(req, rsp, "127.0.0.1:5000")
+	require.NoError(t, err)
+	require.Equal(t, statute.MethodGSSAPI, ac.Method)
+	require.Equal(t, "alice@EXAMPLE.COM", ac.Payload["username"])
+	require.Equal(t, "1", ac.Payload["protection"])
+
+	expect := []byte{statute.VersionSocks5, statute.MethodGSSAPI}
+	expect = append(expect, gssapiMessage(t, statute.GSSAPITypeAuth, []byte("challenge"))...)
+	expect = append(expect, gssapiMessage(t, statute.GSSAPITypeProtection, []byte{'w', 1})...)
+	require.Equal(t, expect, rsp.Bytes())
+
+	// subsequent data is encapsulated
+	rsp.Reset()
+	r, w := ac.encapsulate(req, rsp)
+	data, err := ioutil.ReadAll(r)
+	require.NoError(t, err)
+	require.Equal(t, []byte("ping"), data)
+	_, err = w.Write([]byte("pong"))
+	require.NoError(t, err)
+	require.Equal(t, gssapiMessage(t, statute.GSSAPITypeEncapsulation, []byte("wpong")), rsp.Bytes())
+}
+
+func TestGSSAPIAuthenticator_Abort(t *testing.T) {
+	req := bytes.NewBuffer(gssapiMessage(t, statute.GSSAPITypeAuth, []byte("bad")))
+	rsp := new(bytes.Buffer)
+
+	cator := GSSAPIAuthenticator{Backend: &mockGSSAPI{}}
+	_, err := cator.Authenticate(req, rsp, "")
+	require.True(t, errors.Is(err, statute.ErrUserAuthFailed))
+	require.Equal(t, []byte{
+		statute.VersionSocks5, statute.MethodGSSAPI,
+		statute.GSSAPIVersion, statute.GSSAPITypeAbort,
+	}, rsp.Bytes())
+}
+
+type mockContextGSSAPI struct {
+	mockGSSAPI
+	ctx context.Context
+}
+
+func (m *mockContextGSSAPI) NewContextWith(ctx context.Context, _ string) (GSSAPIContext, error) {
+	m.ctx = ctx
+	return &m.mockGSSAPI, nil
+}
+
+func TestGSSAPIAuthenticator_Context(t *testing.T) {
+	req := bytes.NewBuffer(gssapiMessage(t, statute.GSSAPITypeAuth, []byte("bad")))
+	backend := &mockContextGSSAPI{}
+	cator := GSSAPIAuthenticator{Backend: backend}
+
+	ctx := context.WithValue(context.Background(), ctxKey{}, "conn")
+	_, err := cator.AuthenticateContext(ctx, req, new(bytes.Buffer), "")
+	require.Error(t, err)
+	require.Equal(t, "conn", backend.ctx.Value(ctxKey{}))
+}
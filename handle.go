@@ -1,12 +1,15 @@
 package socks5
 
 import (
+	"bufio"
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	"strings"
-	"sync"
+	"time"
 
 	"github.com/thinkgos/go-socks5/statute"
 )
@@ -16,6 +19,14 @@ type AddressRewriter interface {
 	Rewrite(ctx context.Context, request *Request) (context.Context, *statute.AddrSpec)
 }
 
+// DestinationOverride is used to replace the destination entirely,
+// it is invoked after the RuleSet with the full request context, such as the AuthContext and
+// the Decision, for sandboxing, canary routing, the DNS based service discovery or the per
+// tenant split. Return nil keeps the destination.
+type DestinationOverride interface {
+	Override(ctx context.Context, request *Request) (context.Context, *statute.AddrSpec)
+}
+
 // A Request represents request received by a server
 type Request struct {
 	statute.Request
@@ -23,14 +34,76 @@ type Request struct {
 	AuthContext *AuthContext
 	// LocalAddr of the the network server listen
 	LocalAddr net.Addr
-	// RemoteAddr of the the network that sent the request
+	// RemoteAddr of the the network that sent the request,
+	// the original client if the identity forwarded by a front proxy
 	RemoteAddr net.Addr
+	// ForwardedBy is the front proxy which forwarded the identity of the client, nil if not forwarded,
+	// see ForwardedIdentityAuthenticator.
+	ForwardedBy net.Addr
 	// DestAddr of the actual destination (might be affected by rewrite)
 	DestAddr *statute.AddrSpec
 	// Reader connect of request
 	Reader io.Reader
 	// RawDestAddr of the desired destination
 	RawDestAddr *statute.AddrSpec
+	// Tenant of the virtual server or listener which accepted the request
+	Tenant string
+	// Tag of the connection attached by the AcceptFilter
+	Tag string
+	// sess is the session of the request
+	sess *session
+	// listener is the config of the listener which accepted the request
+	listener *listenerConfig
+	// RawHeader of the request as received, before any conversion
+	RawHeader []byte
+	// ResolvedIP of the FQDN destination, nil if the destination is an IP
+	ResolvedIP net.IP
+	// ResolvedFQDN is the FQDN resolved to the ResolvedIP, empty if the destination is an IP
+	ResolvedFQDN string
+	// ResolvedIPs of the FQDN destination filtered by the answer filters, the first is the
+	// ResolvedIP, the others are the alternates of the Happy Eyeballs, only the alternates
+	// the rules allow are kept once the rules passed. The dial never resolves again.
+	ResolvedIPs []net.IP
+	// ClientCountry of the client source address by the GeoIP of WithClientCountry, empty if unknown
+	ClientCountry string
+	// countryPolicy of the client, nil if none
+	countryPolicy *CountryPolicy
+	// Accepted time of the client connection
+	Accepted time.Time
+	// Received time of the request header
+	Received time.Time
+	// TLS state of the connection accepted by a TLS listener, nil otherwise
+	TLS *tls.ConnectionState
+	// Decision of the rule set, nil if the rule set does not record it
+	Decision *RuleDecision
+	// Dial info of the connect path, nil if not dialed
+	Dial *DialInfo
+	// conn is the client connection
+	conn net.Conn
+	// replies is the writer of the replies set by WithReplyWriter, nil if not set
+	replies *replyBuffer
+	// mem is the memory budget of the session, nil means no limit
+	mem *memoryBudget
+}
+
+type requestContextKey struct{}
+
+// RequestFromContext returns the Request carried by the context passed to
+// the resolver, rule, rewriter, override and dial hooks.
+func RequestFromContext(ctx context.Context) (*Request, bool) {
+	req, ok := ctx.Value(requestContextKey{}).(*Request)
+	return req, ok
+}
+
+// AuthContextFromContext returns the AuthContext of the request carried by the context
+// passed to the resolver, rule, rewriter, override and dial hooks, such as for the per user
+// DNS policies, false if the request is not authenticated.
+func AuthContextFromContext(ctx context.Context) (*AuthContext, bool) {
+	req, ok := RequestFromContext(ctx)
+	if !ok || req.AuthContext == nil {
+		return nil, false
+	}
+	return req.AuthContext, true
 }
 
 // ParseRequest creates a new Request from the tcp connection
@@ -39,46 +112,118 @@ func ParseRequest(bufConn io.Reader) (*Request, error) {
 	if err != nil {
 		return nil, err
 	}
+	raw := hd.Bytes()
+	// IPv4-mapped IPv6 address is converted to IPv4 address
+	hd.DstAddr.Unmap()
+	now := time.Now()
 	return &Request{
 		Request:     hd,
 		RawDestAddr: &hd.DstAddr,
 		Reader:      bufConn,
+		RawHeader:   raw,
+		Accepted:    now,
+		Received:    now,
 	}, nil
 }
 
 // handleRequest is used for request processing after authentication
-func (sf *Server) handleRequest(write io.Writer, req *Request) error {
+func (sf *Server) handleRequest(ctx context.Context, write io.Writer, req *Request) error {
 	var err error
 
-	ctx := context.Background()
-	// Resolve the address if we have a FQDN
+	ctx = context.WithValue(ctx, requestContextKey{}, req)
+	// In static forwarding mode, the requested destination is ignored
 	dest := req.RawDestAddr
+	forward, isForward, err := sf.forwardAddr(req)
+	if err != nil {
+		return sf.refuseForward(write, req, err)
+	}
+	if isForward {
+		dest = forward
+	}
+
+	// Resolve the address if we have a FQDN
 	if dest.FQDN != "" {
-		ctx, dest.IP, err = sf.resolver.Resolve(ctx, dest.FQDN)
-		if err != nil {
-			if err := SendReply(write, statute.RepHostUnreachable, nil); err != nil {
-				return fmt.Errorf("failed to send reply, %v", err)
-			}
-			return fmt.Errorf("failed to resolve destination[%v], %v", dest.FQDN, err)
+		var ips []net.IP
+		if ctx, ips, err = sf.resolveDest(ctx, write, req, dest); err != nil {
+			return err
 		}
+		req.ResolvedIP, req.ResolvedFQDN, req.ResolvedIPs = dest.IP, dest.FQDN, ips
 	}
 
 	// Apply any address rewrites
-	req.DestAddr = req.RawDestAddr
-	if sf.rewriter != nil {
+	req.DestAddr = dest
+	if sf.rewriter != nil && !isForward {
 		ctx, req.DestAddr = sf.rewriter.Rewrite(ctx, req)
+		// the FQDN rewritten is resolved before the rules too, so the address checked is dialed
+		if req.DestAddr.FQDN != "" && req.DestAddr.IP == nil {
+			rewritten := *req.DestAddr
+			if ctx, _, err = sf.resolveDest(ctx, write, req, &rewritten); err != nil {
+				return err
+			}
+			req.DestAddr = &rewritten
+		}
 	}
 
 	// Check if this is allowed
 	var ok bool
-	ctx, ok = sf.rules.Allow(ctx, req)
+	ctx, ok = sf.ruleSet(req).Allow(ctx, req)
+	if d, has := RuleDecisionFromContext(ctx); has {
+		req.Decision = &d
+	}
+	if ok && !allowDestination(req) {
+		ok = false
+		req.Decision = &RuleDecision{
+			Rule:   "destination-template",
+			Reason: "destination not in the user's templates",
+		}
+	}
+	if ok && sf.budget != nil {
+		var d RuleDecision
+		if d, ok = sf.budget.allow(ctx, sf.clock.Now(), req); !ok {
+			req.Decision = &d
+		}
+	}
+	req.sess.setDecision(req.Decision)
 	if !ok {
-		if err := SendReply(write, statute.RepRuleFailure, nil); err != nil {
+		sf.incError(PhaseRule, statute.RepRuleFailure)
+		sf.usage.addDenial(req)
+		if err := sf.sendFailure(write, req.RemoteAddr, statute.RepRuleFailure,
+			statute.DetailRuleDenied); err != nil {
 			return fmt.Errorf("failed to send reply, %v", err)
 		}
+		if req.Decision != nil {
+			return fmt.Errorf("bind to %v blocked by rules, %v", req.RawDestAddr, req.Decision)
+		}
 		return fmt.Errorf("bind to %v blocked by rules", req.RawDestAddr)
 	}
 
+	// the FQDN the rules redirected to is resolved before the dial, as the one rewritten
+	if req.DestAddr.FQDN != "" && req.DestAddr.IP == nil {
+		redirected := *req.DestAddr
+		if ctx, _, err = sf.resolveDest(ctx, write, req, &redirected); err != nil {
+			return err
+		}
+		req.DestAddr = &redirected
+	}
+
+	req.ResolvedIPs = sf.pinAddrs(ctx, req)
+
+	// Apply the destination override, the FQDN overridden is resolved before the dial
+	if sf.override != nil {
+		var dest *statute.AddrSpec
+		if ctx, dest = sf.override.Override(ctx, req); dest != nil && dest != req.DestAddr {
+			overridden := *dest
+			var ips []net.IP
+			if overridden.FQDN != "" && overridden.IP == nil {
+				if ctx, ips, err = sf.resolveDest(ctx, write, req, &overridden); err != nil {
+					return err
+				}
+			}
+			// the alternates of the destination replaced are dropped
+			req.DestAddr, req.ResolvedIPs = &overridden, ips
+		}
+	}
+
 	// Switch on the command
 	switch req.Command {
 	case statute.CommandConnect:
@@ -97,7 +242,9 @@ func (sf *Server) handleRequest(write io.Writer, req *Request) error {
 		}
 		return sf.handleAssociate(ctx, write, req)
 	default:
-		if err := SendReply(write, statute.RepCommandNotSupported, nil); err != nil {
+		sf.incError(PhaseNegotiation, statute.RepCommandNotSupported)
+		if err := sf.sendFailure(write, req.RemoteAddr, statute.RepCommandNotSupported,
+			statute.DetailCommandNotSupported); err != nil {
 			return fmt.Errorf("failed to send reply, %v", err)
 		}
 		return fmt.Errorf("unsupported command[%v]", req.Command)
@@ -107,220 +254,536 @@ func (sf *Server) handleRequest(write io.Writer, req *Request) error {
 // handleConnect is used to handle a connect command
 func (sf *Server) handleConnect(ctx context.Context, writer io.Writer, request *Request) error {
 	// Attempt to connect
-	dial := sf.dial
-	if dial == nil {
-		dial = func(ctx context.Context, net_, addr string) (net.Conn, error) {
-			return net.Dial(net_, addr)
-		}
-	}
-	target, err := dial(ctx, "tcp", request.DestAddr.String())
+	request.sess.setState(SessionConnecting)
+	start := sf.clock.Now()
+	dialCtx, span := sf.startSpan(ctx, SpanDial, Attribute{AttrDest, request.DestAddr.String()})
+	target, err := sf.dialOut(dialCtx, request, "tcp", request.DestAddr.String())
+	dialDuration := sf.since(start)
+	sf.observeDuration(PhaseDial, request.Command, start)
 	if err != nil {
-		msg := err.Error()
-		resp := statute.RepHostUnreachable
-		if strings.Contains(msg, "refused") {
-			resp = statute.RepConnectionRefused
-		} else if strings.Contains(msg, "network is unreachable") {
-			resp = statute.RepNetworkUnreachable
-		}
-		if err := SendReply(writer, resp, nil); err != nil {
+		resp := ReplyOf(err)
+		span.SetAttributes(Attribute{AttrReply, int64(resp)})
+		endSpan(span, err)
+		sf.incError(PhaseDial, resp)
+		if err := sf.sendFailure(writer, request.RemoteAddr, resp, statute.DetailDialFailed); err != nil {
 			return fmt.Errorf("failed to send reply, %v", err)
 		}
 		return fmt.Errorf("connect to %v failed, %v", request.RawDestAddr, err)
 	}
 	defer target.Close()
+	span.SetAttributes(Attribute{AttrRemote, target.RemoteAddr().String()})
+	span.End()
+	sf.setDial(ctx, request, "tcp", target, dialDuration)
+	if mirror := sf.startShadow(ctx, request); mirror != nil {
+		defer mirror.close()
+		target = &shadowConn{target, mirror}
+	}
+
+	if err := sf.flushEarlyData(request, target); err != nil {
+		sf.incError(PhaseDial, statute.RepHostUnreachable)
+		if err := sf.sendFailure(writer, request.RemoteAddr, statute.RepHostUnreachable,
+			statute.DetailDialFailed); err != nil {
+			return fmt.Errorf("failed to send reply, %v", err)
+		}
+		return fmt.Errorf("write early data to %v failed, %v", request.RawDestAddr, err)
+	}
 
 	// Send success
-	if err := SendReply(writer, statute.RepSuccess, target.LocalAddr()); err != nil {
+	if sf.acceptCompression(request) {
+		if request.Reader, writer, err = sf.sendCompressReply(writer, request, target.LocalAddr()); err != nil {
+			return fmt.Errorf("failed to send reply, %v", err)
+		}
+	} else if err := SendReply(writer, statute.RepSuccess, target.LocalAddr()); err != nil {
 		return fmt.Errorf("failed to send reply, %v", err)
 	}
 
 	// Start proxying
-	errCh := make(chan error, 2)
-	sf.goFunc(func() { errCh <- sf.Proxy(target, request.Reader) })
-	sf.goFunc(func() { errCh <- sf.Proxy(writer, target) })
-	// Wait
-	for i := 0; i < 2; i++ {
-		e := <-errCh
-		if e != nil {
-			// return from this function closes target (and conn).
-			return e
-		}
-	}
-	return nil
+	return sf.relay(ctx, writer, request, target)
 }
 
-// handleBind is used to handle a connect command
-func (sf *Server) handleBind(_ context.Context, writer io.Writer, _ *Request) error {
-	// TODO: Support bind
-	if err := SendReply(writer, statute.RepCommandNotSupported, nil); err != nil {
-		return fmt.Errorf("failed to send reply: %v", err)
+// relay is used to relay the data between the client and the target
+func (sf *Server) relay(ctx context.Context, writer io.Writer, request *Request, target net.Conn) (err error) {
+	if err := request.replies.startRelay(); err != nil {
+		return fmt.Errorf("failed to send reply, %v", err)
+	}
+	request.sess.setState(SessionRelaying)
+	request.sess.setBuffered(0)
+	ctx, span := sf.startSpan(ctx, SpanRelay)
+	var results [2]halfResult
+	defer func() {
+		var up, down int64
+		for _, r := range results {
+			if r.up {
+				up = r.n
+			} else {
+				down = r.n
+			}
+		}
+		span.SetAttributes(Attribute{AttrBytesUp, up}, Attribute{AttrBytesDown, down})
+		endSpan(span, err)
+	}()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	defer request.sess.setMigratable(request, target)()
+	clientR, clientW, targetR, targetW := sf.relayLegs(request, writer, target)
+	clientW, targetW = sf.rateLimit(ctx, request, clientW, targetW)
+	clientW, targetW, stopWatch := sf.watchStall(request, clientW, targetW, target)
+	defer stopWatch()
+	// the data not written by the interrupted writes of the migrating session is handed off
+	upPending := &pendingWriter{Writer: targetW, sess: request.sess}
+	downPending := &pendingWriter{Writer: clientW, sess: request.sess}
+	up := func() (int64, error) { return sf.proxy(request.mem, request.sess.upWriter(upPending), clientR) }
+	down := func() (int64, error) { return sf.proxy(request.mem, request.sess.downWriter(downPending), targetR) }
+	if client, tc, ok := sf.spliceLegs(request, writer, target, clientR, clientW, targetR, targetW); ok {
+		br := request.Reader.(*bufio.Reader)
+		up = func() (int64, error) { return sf.splice(request.sess.upWriter(tc), tc, client, br) }
+		down = func() (int64, error) { return sf.splice(request.sess.downWriter(client), client, tc, nil) }
+	}
+	results = sf.pipe(request.sess, request.conn, target, up, down)
+	// the relay is interrupted to hand the session to the new process
+	if m := request.sess.migrationOf(); m != nil {
+		return sf.handOff(m, request, target, upPending.pending, downPending.pending)
+	}
+	// the direction ended first settles the close reason
+	request.sess.setCloseReason(relayCloseReason(results[0].up, results[0].err))
+	for _, r := range results {
+		if r.err != nil {
+			sf.incError(PhaseRelay, NoReply)
+			// the error of the direction ended first, the other is interrupted by it
+			return r.err
+		}
 	}
 	return nil
 }
 
-// handleAssociate is used to handle a connect command
-func (sf *Server) handleAssociate(ctx context.Context, writer io.Writer, request *Request) error {
-	// Attempt to connect
-	dial := sf.dial
-	if dial == nil {
-		dial = func(ctx context.Context, net_, addr string) (net.Conn, error) {
-			return net.Dial(net_, addr)
+// handleBind is used to handle a bind command, the first reply carries the listening
+// address, the second reply carries the address of the remote peer after it connects.
+func (sf *Server) handleBind(ctx context.Context, writer io.Writer, request *Request) error {
+	if sf.bindFilter != nil && !sf.bindFilter(ctx, request) {
+		sf.incError(PhaseRule, statute.RepRuleFailure)
+		if err := sf.sendFailure(writer, request.RemoteAddr, statute.RepRuleFailure,
+			statute.DetailRuleDenied); err != nil {
+			return fmt.Errorf("failed to send reply, %v", err)
 		}
+		return fmt.Errorf("bind from %v blocked by bind filter", request.RemoteAddr)
 	}
 
-	target, err := dial(ctx, "udp", request.DestAddr.String())
+	ln, err := sf.listenBind(request)
 	if err != nil {
-		msg := err.Error()
-		resp := statute.RepHostUnreachable
-		if strings.Contains(msg, "refused") {
-			resp = statute.RepConnectionRefused
-		} else if strings.Contains(msg, "network is unreachable") {
-			resp = statute.RepNetworkUnreachable
-		}
-		if err := SendReply(writer, resp, nil); err != nil {
+		sf.incError(PhaseDial, statute.RepServerFailure)
+		if err := sf.sendFailure(writer, request.RemoteAddr, statute.RepServerFailure,
+			statute.DetailServerFailure); err != nil {
 			return fmt.Errorf("failed to send reply, %v", err)
 		}
-		return fmt.Errorf("connect to %v failed, %v", request.RawDestAddr, err)
+		return fmt.Errorf("listen bind failed, %v", err)
 	}
-	defer target.Close()
+	defer ln.Close()
 
-	targetUDP, ok := target.(*net.UDPConn)
-	if !ok {
-		if err := SendReply(writer, statute.RepServerFailure, nil); err != nil {
+	// first reply, the listening address
+	if err := SendReply(writer, statute.RepSuccess, sf.bindReplyAddr(request, ln.Addr())); err != nil {
+		return fmt.Errorf("failed to send reply, %v", err)
+	}
+
+	if err := request.replies.flush(); err != nil {
+		return fmt.Errorf("failed to send reply, %v", err)
+	}
+	request.sess.setState(SessionConnecting)
+	stop := closeOnDone(ctx, ln)
+	stopWatch := sf.watchBindClient(request, ln)
+	peers := bindPeers(request)
+	target, rep, err := acceptBind(ln, sf.bindConfig(ctx), func(addr net.Addr) bool {
+		return bindPeerAllowed(peers, addr) && allowBindPeer(request, addr)
+	})
+	stopWatch()
+	stop()
+	if err != nil {
+		sf.incError(PhaseDial, rep)
+		if err := sf.sendFailure(writer, request.RemoteAddr, rep, statute.DetailDialFailed); err != nil {
 			return fmt.Errorf("failed to send reply, %v", err)
 		}
-		return fmt.Errorf("dial udp invalid")
+		return fmt.Errorf("accept bind failed, %v", err)
+	}
+	defer target.Close()
+	ln.Close()
+
+	// second reply, the address of the remote peer
+	if err := SendReply(writer, statute.RepSuccess, target.RemoteAddr()); err != nil {
+		return fmt.Errorf("failed to send reply, %v", err)
 	}
+	return sf.relay(ctx, writer, request, target)
+}
 
-	bindLn, err := net.ListenUDP("udp", nil)
+// handleAssociate is used to handle a associate command
+func (sf *Server) handleAssociate(ctx context.Context, writer io.Writer, request *Request) error {
+	bindLn, err := sf.listenAssociate(request)
 	if err != nil {
-		if err := SendReply(writer, statute.RepServerFailure, nil); err != nil {
+		sf.incError(PhaseDial, statute.RepServerFailure)
+		if err := sf.sendFailure(writer, request.RemoteAddr, statute.RepServerFailure,
+			statute.DetailServerFailure); err != nil {
 			return fmt.Errorf("failed to send reply, %v", err)
 		}
 		return fmt.Errorf("listen udp failed, %v", err)
 	}
 	defer bindLn.Close()
 
-	sf.logger.Errorf("target addr %v, listen addr: %s", targetUDP.RemoteAddr(), bindLn.LocalAddr())
 	// send BND.ADDR and BND.PORT, client used
 	if err = SendReply(writer, statute.RepSuccess, bindLn.LocalAddr()); err != nil {
 		return fmt.Errorf("failed to send reply, %v", err)
 	}
 
-	sf.goFunc(func() {
-		// read from client and write to remote server
-		conns := sync.Map{}
-		bufPool := sf.bufferPool.Get()
-		defer func() {
-			targetUDP.Close()
-			bindLn.Close()
-			sf.bufferPool.Put(bufPool)
-		}()
-		for {
-			n, srcAddr, err := bindLn.ReadFrom(bufPool[:cap(bufPool)])
-			if err != nil {
-				if strings.Contains(err.Error(), "use of closed network connection") {
-					sf.logger.Errorf("read data from bind listen address %s failed, %v", bindLn.LocalAddr(), err)
-					return
-				}
-				continue
+	request.sess.setState(SessionRelaying)
+	table := newNatTable(sf)
+	table.sess = request.sess
+	table.mem = request.mem
+	table.connRate = newTokenBucket(sf.clock, sf.sessionByteRate(request), maxUDPPayload)
+	table.rateKey = rateLimitKey(request)
+	sf.udpTables.Store(table, struct{}{})
+	done := make(chan struct{})
+	defer func() {
+		close(done)
+		sf.udpTables.Delete(table)
+		table.close()
+	}()
+	if sf.udpFlowIdleTimeout > 0 {
+		sf.goFunc(func() { table.expireIdle(sf.udpFlowIdleTimeout, done) })
+	}
+	sf.goFunc(func() { sf.relayAssociate(ctx, bindLn, table, request) })
+
+	// the association terminates when the tcp connection closed
+	buf := sf.bufferPool.Get()
+	defer sf.bufferPool.Put(buf)
+	for {
+		if _, err := request.Reader.Read(buf[:cap(buf)]); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
 			}
+			return err
+		}
+	}
+}
 
-			pk, err := statute.ParseDatagram(bufPool[:n])
-			if err != nil {
-				continue
+// sameAddr reports whether the address is the same host and port
+func sameAddr(a, b statute.AddrSpec) bool {
+	if a.Port != b.Port {
+		return false
+	}
+	if a.FQDN != "" && b.FQDN != "" {
+		return strings.EqualFold(a.FQDN, b.FQDN)
+	}
+	return a.IP != nil && a.IP.Equal(b.IP)
+}
+
+// associateDest reports whether the datagram destination is the one given in the ASSOCIATE
+// request, the ip destination matches any address the FQDN given resolved to.
+func associateDest(request *Request, dst statute.AddrSpec) bool {
+	if sameAddr(dst, *request.DestAddr) {
+		return true
+	}
+	if dst.FQDN != "" || dst.Port != request.DestAddr.Port {
+		return false
+	}
+	for _, ip := range request.ResolvedIPs {
+		if ip.Equal(dst.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// listenAssociate listen the udp relay of the association,
+// on the address family of the client connection if prefer IPv6.
+func (sf *Server) listenAssociate(request *Request) (net.PacketConn, error) {
+	network, laddr := "udp", &net.UDPAddr{}
+	if ip := addrIP(request.LocalAddr); sf.associatePreferIPv6 && ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			network, laddr.IP = "udp4", ip4
+		} else {
+			network, laddr.IP = "udp6", ip
+		}
+	}
+	if client := addrIP(request.peerAddr()); sf.udpSocketReuse && client != nil {
+		return sf.udpRelays.listen(sf, network, laddr, client, request.DestAddr.Port)
+	}
+	return net.ListenUDP(network, laddr)
+}
+
+// associateSource returns the check of the datagram source per the client address declared
+// in the ASSOCIATE request, or the tcp peer's ip if declared unspecified, nil if not enabled.
+// The port is checked if declared, the NAT relaxed check accepts the tcp peer's ip with any port.
+func (sf *Server) associateSource(request *Request) func(src *net.UDPAddr) bool {
+	if !sf.associateSourceCheck {
+		return nil
+	}
+	peerIP := unmapIP(addrIP(request.peerAddr()))
+	declared := request.DestAddr
+	ip := declared.IP
+	if declared.FQDN != "" || ip == nil || ip.IsUnspecified() {
+		ip = peerIP
+	}
+	return func(src *net.UDPAddr) bool {
+		srcIP := unmapIP(src.IP)
+		if sf.associateSourceNAT {
+			return srcIP.Equal(ip) || srcIP.Equal(peerIP)
+		}
+		return srcIP.Equal(ip) && (declared.Port == 0 || declared.Port == src.Port)
+	}
+}
+
+// relayAssociate read datagram from client and write to the target of the flow
+func (sf *Server) relayAssociate(ctx context.Context, bindLn net.PacketConn, table *natTable, request *Request) {
+	bufPool := sf.datagramPool.Get()
+	defer func() {
+		bindLn.Close()
+		table.close()
+		sf.datagramPool.Put(bufPool)
+	}()
+	var peerIP net.IP
+	if sf.associatePeerOnly {
+		peerIP = addrIP(request.peerAddr())
+	}
+	validSource := sf.associateSource(request)
+	frags := newFragQueue(sf, table.mem)
+	defer frags.reset()
+	packetLimit := newTokenBucket(sf.clock, sf.udpPacketRate, 0)
+	byteLimit := newTokenBucket(sf.clock, sf.udpByteRate, maxUDPPayload)
+	for {
+		n, srcAddr, err := bindLn.ReadFrom(bufPool[:cap(bufPool)])
+		if err != nil {
+			if strings.Contains(err.Error(), "use of closed network connection") {
+				return
 			}
+			continue
+		}
+		if src, ok := srcAddr.(*net.UDPAddr); !ok || (validSource != nil && !validSource(src)) {
+			continue
+		}
+		if !table.learnClient(srcAddr, peerIP) {
+			continue
+		}
+		if !table.allowDatagram(packetLimit, byteLimit, n) {
+			continue
+		}
 
-			if _, ok := conns.LoadOrStore(srcAddr.String(), struct{}{}); !ok {
-				sf.goFunc(func() {
-					// read from remote server and write to client
-					bufPool := sf.bufferPool.Get()
-					defer func() {
-						targetUDP.Close()
-						bindLn.Close()
-						sf.bufferPool.Put(bufPool)
-					}()
-
-					for {
-						buf := bufPool[:cap(bufPool)]
-						n, remote, err := targetUDP.ReadFrom(buf)
-						if err != nil {
-							sf.logger.Errorf("read data from remote %s failed, %v", targetUDP.RemoteAddr(), err)
-							return
-						}
-
-						pkb, err := statute.NewDatagram(remote.String(), buf[:n])
-						if err != nil {
-							continue
-						}
-						tmpBufPool := sf.bufferPool.Get()
-						proBuf := tmpBufPool
-						proBuf = append(proBuf, pkb.Header()...)
-						proBuf = append(proBuf, pkb.Data...)
-						if _, err := bindLn.WriteTo(proBuf, srcAddr); err != nil {
-							sf.bufferPool.Put(tmpBufPool)
-							sf.logger.Errorf("write data to client %s failed, %v", bindLn.LocalAddr(), err)
-							return
-						}
-						sf.bufferPool.Put(tmpBufPool)
-					}
-				})
+		pk, err := statute.ParseDatagram(bufPool[:n])
+		if err != nil {
+			continue
+		}
+		if pk.Frag != 0 {
+			var complete bool
+			if pk, complete = frags.push(pk); !complete {
+				continue
+			}
+		}
+		// clients which do not fill the datagram destination use the associate destination
+		dst := pk.DstAddr
+		dst.Unmap()
+		if dst.FQDN == "" && (dst.IP.IsUnspecified() || dst.Port == 0) {
+			// the declared address is the client's own if the source is checked
+			if validSource != nil {
+				continue
 			}
+			dst = *request.DestAddr
+		}
+		if sf.associateStrict && !associateDest(request, dst) {
+			continue
+		}
 
-			// 把消息写给remote sever
-			if _, err := targetUDP.Write(pk.Data); err != nil {
-				sf.logger.Errorf("write data to remote %s failed, %v", targetUDP.RemoteAddr(), err)
-				return
+		key := srcAddr.String() + "-" + dst.String()
+		flow, ok := table.get(key)
+		if !ok {
+			if !allowDatagram(request, &dst) {
+				continue
+			}
+			// the buffer of the flow, the datagram is dropped if the memory limit exceeded
+			if !table.mem.acquire(int64(cap(bufPool))) {
+				continue
 			}
+			target, err := sf.dialOut(ctx, request, "udp", dst.String())
+			if err != nil {
+				table.mem.release(int64(cap(bufPool)))
+				sf.logger.Errorf("dial udp target %s failed, %v", dst.String(), err)
+				continue
+			}
+			flow = newUDPFlow(sf.clock, key, srcAddr, target)
+			if !table.add(flow) {
+				table.mem.release(int64(cap(bufPool)))
+				target.Close()
+				continue
+			}
+			sf.goFunc(func() { sf.relayAssociateTarget(bindLn, table, flow) })
 		}
-	})
 
-	buf := sf.bufferPool.Get()
-	defer sf.bufferPool.Put(buf)
+		if _, err := flow.target.Write(pk.Data); err != nil {
+			sf.logger.Errorf("write data to remote %s failed, %v", flow.target.RemoteAddr(), err)
+			table.remove(flow, UDPFlowError)
+			continue
+		}
+		flow.countUp(len(pk.Data))
+		table.sess.countUp(len(pk.Data))
+		sf.incUDPDatagram(true)
+	}
+}
 
+// relayAssociateTarget read data from the target of the flow and write datagram to client
+func (sf *Server) relayAssociateTarget(bindLn net.PacketConn, table *natTable, flow *udpFlow) {
+	bufPool := sf.datagramPool.Get()
+	defer func() {
+		table.remove(flow, UDPFlowError)
+		table.mem.release(int64(cap(bufPool)))
+		sf.datagramPool.Put(bufPool)
+	}()
+
+	// reserve space for the datagram header, the buffer of the pool too small for it is replaced
+	b := bufPool[:cap(bufPool)]
+	if len(b) < minDatagramBufferSize {
+		b = make([]byte, minDatagramBufferSize)
+	}
+	buf := b[:len(b)-maxDatagramHeaderLen]
 	for {
-		_, err := request.Reader.Read(buf[:cap(buf)])
+		n, err := flow.target.Read(buf)
 		if err != nil {
-			if strings.Contains(err.Error(), "use of closed network connection") {
-				return err
+			return
+		}
+		table.get(flow.key)
+		if !table.allowRate(n) {
+			continue
+		}
+		flow.countDown(n)
+		table.sess.countDown(n)
+
+		pkb, err := statute.NewDatagram(flow.target.RemoteAddr().String(), buf[:n])
+		if err != nil {
+			continue
+		}
+		packets, oversize := encapsulate(pkb, sf.udpMaxDatagram, sf.udpOversizePolicy)
+		if oversize {
+			sf.incUDPOversize(sf.udpOversizePolicy)
+		}
+		for _, b := range packets {
+			if _, err := bindLn.WriteTo(b, flow.client); err != nil {
+				sf.logger.Errorf("write data to client %s failed, %v", flow.client, err)
+				return
 			}
+			sf.incUDPDatagram(false)
 		}
 	}
 }
 
+// maxDatagramHeaderLen is the max length of datagram header, with a 255 bytes FQDN
+const maxDatagramHeaderLen = 4 + 1 + 255 + 2
+
+// maxUDPPayload is the max payload of the udp datagram, the byte buckets of the datagrams burst
+// at least it, so the largest datagram passes the low byte rates.
+const maxUDPPayload = 65535
+
+// minDatagramBufferSize is the smallest datagram buffer, the header and a 512 bytes payload
+const minDatagramBufferSize = maxDatagramHeaderLen + 512
+
+// dialOut is used to dial out with the optional dialer of the listener or server
+func (sf *Server) dialOut(ctx context.Context, request *Request, network, addr string) (net.Conn, error) {
+	var d Dialer = new(net.Dialer)
+	if request.listener != nil && request.listener.dialer != nil {
+		d = request.listener.dialer
+	} else if sf.dialer != nil {
+		d = sf.dialer
+	}
+	if sf.dialTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, sf.dialTimeout)
+		defer cancel()
+	}
+	if priority, ok := sf.socketPriority(ctx, request); ok {
+		d = sf.prioritized(d, priority)
+	}
+	if sf.happyEyeballs > 0 && network == "tcp" && len(request.ResolvedIPs) > 1 {
+		d = HappyEyeballsDialer{Resolver: pinnedResolver{request}, FallbackDelay: sf.happyEyeballs, Forward: d}
+	}
+	return d.DialContext(ctx, network, addr)
+}
+
 // SendReply is used to send a reply message
 // rep: reply status see statute's statute file
 func SendReply(w io.Writer, rep uint8, bindAddr net.Addr) error {
-	rsp := statute.Reply{
-		Version:  statute.VersionSocks5,
-		Response: rep,
-		BndAddr: statute.AddrSpec{
-			AddrType: statute.ATYPIPv4,
-			IP:       net.IPv4zero,
-			Port:     0,
-		},
-	}
-
-	if rsp.Response == statute.RepSuccess {
-		if tcpAddr, ok := bindAddr.(*net.TCPAddr); ok && tcpAddr != nil {
-			rsp.BndAddr.IP = tcpAddr.IP
-			rsp.BndAddr.Port = tcpAddr.Port
-		} else if udpAddr, ok := bindAddr.(*net.UDPAddr); ok && udpAddr != nil {
-			rsp.BndAddr.IP = udpAddr.IP
-			rsp.BndAddr.Port = udpAddr.Port
-		} else {
-			rsp.Response = statute.RepAddrTypeNotSupported
+	if rep != statute.RepSuccess {
+		_, err := w.Write(failureReplies[rep])
+		return err
+	}
+	buf := replyPool.Get().(*[replyMaxLen]byte)
+	_, err := w.Write(AppendReply(buf[:0], rep, bindAddr))
+	replyPool.Put(buf)
+	return err
+}
+
+// sendFailure is used to send a failure reply, followed by the reply detail
+// extension if the client is inside the trusted networks.
+func (sf *Server) sendFailure(w io.Writer, remote net.Addr, rep uint8, detail string) error {
+	if err := SendReply(w, rep, nil); err != nil {
+		return err
+	}
+	if !sf.replyDetailTrusted(remote) {
+		return nil
+	}
+	_, err := w.Write(statute.NewReplyDetail(detail).Bytes())
+	return err
+}
+
+func (sf *Server) replyDetailTrusted(remote net.Addr) bool {
+	ip := addrIP(remote)
+	if ip == nil {
+		return false
+	}
+	for _, n := range sf.replyDetailNets {
+		if n.Contains(ip) {
+			return true
 		}
+	}
+	return false
+}
 
-		if rsp.BndAddr.IP.To4() != nil {
-			rsp.BndAddr.AddrType = statute.ATYPIPv4
-		} else if rsp.BndAddr.IP.To16() != nil {
-			rsp.BndAddr.AddrType = statute.ATYPIPv6
+// addrIP returns the ip of the address, nil if not a ip address.
+// IPv4-mapped IPv6 address is converted to IPv4 address.
+func addrIP(addr net.Addr) net.IP {
+	var ip net.IP
+	switch v := addr.(type) {
+	case *net.TCPAddr:
+		ip = v.IP
+	case *net.UDPAddr:
+		ip = v.IP
+	case *net.IPAddr:
+		ip = v.IP
+	case nil:
+		return nil
+	default:
+		host, _, err := net.SplitHostPort(addr.String())
+		if err != nil {
+			return nil
 		}
+		ip = net.ParseIP(host)
 	}
-	// Send the message
-	_, err := w.Write(rsp.Bytes())
-	return err
+	return unmapIP(ip)
+}
+
+// unmapIP converts the IPv4-mapped IPv6 address to IPv4 address
+func unmapIP(ip net.IP) net.IP {
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4
+	}
+	return ip
+}
+
+// unmapAddr converts the IPv4-mapped IPv6 address of tcp or udp address to IPv4 address
+func unmapAddr(addr net.Addr) net.Addr {
+	switch v := addr.(type) {
+	case *net.TCPAddr:
+		if len(v.IP) == net.IPv6len && v.IP.To4() != nil {
+			return &net.TCPAddr{IP: v.IP.To4(), Port: v.Port}
+		}
+	case *net.UDPAddr:
+		if len(v.IP) == net.IPv6len && v.IP.To4() != nil {
+			return &net.UDPAddr{IP: v.IP.To4(), Port: v.Port}
+		}
+	}
+	return addr
 }
 
 type closeWriter interface {
@@ -330,11 +793,6 @@ type closeWriter interface {
 // Proxy is used to suffle data from src to destination, and sends errors
 // down a dedicated channel
 func (sf *Server) Proxy(dst io.Writer, src io.Reader) error {
-	buf := sf.bufferPool.Get()
-	defer sf.bufferPool.Put(buf)
-	_, err := io.CopyBuffer(dst, src, buf[:cap(buf)])
-	if tcpConn, ok := dst.(closeWriter); ok {
-		tcpConn.CloseWrite() // nolint: errcheck
-	}
+	_, err := sf.proxy(nil, dst, src)
 	return err
 }
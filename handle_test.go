@@ -2,11 +2,14 @@ package socks5
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"io"
 	"log"
 	"net"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 
@@ -51,6 +54,7 @@ func TestRequest_Connect(t *testing.T) {
 		resolver:   DNSResolver{},
 		logger:     NewLogger(log.New(os.Stdout, "socks5: ", log.LstdFlags)),
 		bufferPool: bufferpool.NewPool(32 * 1024),
+		clock:      SystemClock{},
 	}
 
 	// Create the connect request
@@ -66,7 +70,7 @@ func TestRequest_Connect(t *testing.T) {
 	req, err := ParseRequest(buf)
 	require.NoError(t, err)
 
-	err = proxySrv.handleRequest(rsp, req)
+	err = proxySrv.handleRequest(context.Background(), rsp, req)
 	require.NoError(t, err)
 
 	// Verify response
@@ -108,6 +112,7 @@ func TestRequest_Connect_RuleFail(t *testing.T) {
 		resolver:   DNSResolver{},
 		logger:     NewLogger(log.New(os.Stdout, "socks5: ", log.LstdFlags)),
 		bufferPool: bufferpool.NewPool(32 * 1024),
+		clock:      SystemClock{},
 	}
 
 	// Create the connect request
@@ -124,7 +129,7 @@ func TestRequest_Connect_RuleFail(t *testing.T) {
 	req, err := ParseRequest(buf)
 	require.NoError(t, err)
 
-	err = s.handleRequest(rsp, req)
+	err = s.handleRequest(context.Background(), rsp, req)
 	require.Contains(t, err.Error(), "blocked by rules")
 
 	// Verify response
@@ -135,3 +140,287 @@ func TestRequest_Connect_RuleFail(t *testing.T) {
 	}
 	require.Equal(t, expected, out)
 }
+
+func TestParseRequest_Unmap(t *testing.T) {
+	ip := net.ParseIP("::ffff:127.0.0.1")
+	buf := bytes.NewBuffer([]byte{statute.VersionSocks5, statute.CommandConnect, 0, statute.ATYPIPv6})
+	buf.Write(ip)
+	buf.Write([]byte{0, 80})
+
+	req, err := ParseRequest(buf)
+	require.NoError(t, err)
+	require.Equal(t, statute.ATYPIPv4, req.DstAddr.AddrType)
+	require.Equal(t, "127.0.0.1:80", req.RawDestAddr.String())
+	require.Equal(t, net.IPv4len, len(addrIP(&net.TCPAddr{IP: ip, Port: 80})))
+}
+
+type overrideFunc func(ctx context.Context, request *Request) (context.Context, *statute.AddrSpec)
+
+func (f overrideFunc) Override(ctx context.Context, request *Request) (context.Context, *statute.AddrSpec) {
+	return f(ctx, request)
+}
+
+func TestRequest_Connect_Override(t *testing.T) {
+	// Create a local listener
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	go func() {
+		conn, err := l.Accept()
+		require.NoError(t, err)
+		defer conn.Close()
+
+		buf := make([]byte, 4)
+		_, err = io.ReadAtLeast(conn, buf, 4)
+		require.NoError(t, err)
+		require.Equal(t, []byte("ping"), buf)
+
+		conn.Write([]byte("pong")) // nolint: errcheck
+	}()
+	lAddr := l.Addr().(*net.TCPAddr)
+
+	// Make proxy server, the rules deny the port 1 only
+	proxySrv := NewServer(
+		WithRule(ruleFunc(func(ctx context.Context, req *Request) (context.Context, bool) {
+			return ctx, req.DestAddr.Port == 1
+		})),
+		WithOverride(overrideFunc(func(ctx context.Context, req *Request) (context.Context, *statute.AddrSpec) {
+			return ctx, &statute.AddrSpec{IP: lAddr.IP, Port: lAddr.Port, AddrType: statute.ATYPIPv4}
+		})),
+	)
+
+	// Create the connect request
+	buf := bytes.NewBuffer([]byte{
+		statute.VersionSocks5, statute.CommandConnect, 0,
+		statute.ATYPIPv4, 127, 0, 0, 1, 0, 1,
+	})
+	buf.Write([]byte("ping"))
+
+	rsp := new(MockConn)
+	req, err := ParseRequest(buf)
+	require.NoError(t, err)
+
+	err = proxySrv.handleRequest(context.Background(), rsp, req)
+	require.NoError(t, err)
+	require.Equal(t, []byte("pong"), rsp.buf.Bytes()[10:])
+}
+
+func TestRequest_Associate_PreferIPv6(t *testing.T) {
+	l, err := net.Listen("tcp6", "[::1]:0")
+	if err != nil {
+		t.Skip("ipv6 not available")
+	}
+	l.Close()
+
+	proxySrv := NewServer(WithAssociateIPv6(true))
+	for _, tt := range []struct {
+		local net.IP
+		atyp  byte
+	}{
+		{net.IPv6loopback, statute.ATYPIPv6},
+		{net.IPv4(127, 0, 0, 1).To4(), statute.ATYPIPv4},
+	} {
+		req, err := ParseRequest(bytes.NewBuffer([]byte{
+			statute.VersionSocks5, statute.CommandAssociate, 0,
+			statute.ATYPIPv4, 0, 0, 0, 0, 0, 0,
+		}))
+		require.NoError(t, err)
+		req.LocalAddr = &net.TCPAddr{IP: tt.local, Port: 1080}
+		req.RemoteAddr = &net.TCPAddr{IP: tt.local, Port: 65432}
+		req.Reader = bytes.NewReader(nil)
+		rsp := new(MockConn)
+		require.NoError(t, proxySrv.handleRequest(context.Background(), rsp, req))
+
+		reply, err := statute.ParseReply(&rsp.buf)
+		require.NoError(t, err)
+		require.Equal(t, statute.RepSuccess, reply.Response)
+		require.Equal(t, tt.atyp, reply.BndAddr.AddrType)
+		require.True(t, tt.local.Equal(reply.BndAddr.IP))
+	}
+}
+
+func TestRequest_Structured(t *testing.T) {
+	var dialed *Request
+	proxySrv := NewServer(WithDial(func(ctx context.Context, network, addr string) (net.Conn, error) {
+		dialed, _ = RequestFromContext(ctx)
+		return nil, errors.New("dial refused")
+	}))
+
+	header := []byte{
+		statute.VersionSocks5, statute.CommandConnect, 0,
+		statute.ATYPDomain, 9, 'l', 'o', 'c', 'a', 'l', 'h', 'o', 's', 't', 0, 80,
+	}
+	req, err := ParseRequest(bytes.NewBuffer(header))
+	require.NoError(t, err)
+	require.Equal(t, header, req.RawHeader)
+	require.False(t, req.Received.IsZero())
+
+	err = proxySrv.handleRequest(context.Background(), new(MockConn), req)
+	require.Error(t, err)
+	require.Equal(t, req, dialed)
+	require.NotNil(t, req.ResolvedIP)
+	require.True(t, req.ResolvedIP.Equal(req.DestAddr.IP))
+}
+
+type replyWriter chan []byte
+
+func (w replyWriter) Write(b []byte) (int, error) {
+	w <- append([]byte(nil), b...)
+	return len(b), nil
+}
+
+func TestRequest_Associate_Strict(t *testing.T) {
+	listen := func() (*net.UDPConn, chan []byte) {
+		l, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		require.NoError(t, err)
+		got := make(chan []byte, 4)
+		go func() {
+			buf := make([]byte, 64)
+			for {
+				n, err := l.Read(buf)
+				if err != nil {
+					return
+				}
+				got <- append([]byte(nil), buf[:n]...)
+			}
+		}()
+		return l, got
+	}
+	allowed, allowedGot := listen()
+	defer allowed.Close()
+	other, otherGot := listen()
+	defer other.Close()
+	aAddr := allowed.LocalAddr().(*net.UDPAddr)
+
+	req, err := ParseRequest(bytes.NewBuffer([]byte{
+		statute.VersionSocks5, statute.CommandAssociate, 0,
+		statute.ATYPIPv4, 127, 0, 0, 1, byte(aAddr.Port >> 8), byte(aAddr.Port),
+	}))
+	require.NoError(t, err)
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	req.Reader = pr
+	req.RemoteAddr = &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 65432}
+
+	rsp := make(replyWriter, 1)
+	go NewServer(WithAssociateStrict(true)).handleRequest(context.Background(), rsp, req) // nolint: errcheck
+	reply, err := statute.ParseReply(bytes.NewReader(<-rsp))
+	require.NoError(t, err)
+	require.Equal(t, statute.RepSuccess, reply.Response)
+
+	client, err := net.DialUDP("udp", nil, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: reply.BndAddr.Port})
+	require.NoError(t, err)
+	defer client.Close()
+
+	for _, target := range []string{other.LocalAddr().String(), aAddr.String()} {
+		pk, err := statute.NewDatagram(target, []byte("ping"))
+		require.NoError(t, err)
+		_, err = client.Write(pk.Bytes())
+		require.NoError(t, err)
+	}
+	select {
+	case b := <-allowedGot:
+		require.Equal(t, []byte("ping"), b)
+	case <-time.After(time.Second):
+		t.Fatal("datagram to the associate destination not relayed")
+	}
+	select {
+	case <-otherGot:
+		t.Fatal("datagram to other destination relayed")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestRequest_Associate_StrictFQDN(t *testing.T) {
+	// the target listens on the alternate address of the FQDN
+	target, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 2)})
+	if err != nil {
+		t.Skipf("listen on 127.0.0.2, %v", err)
+	}
+	defer target.Close()
+	tAddr := target.LocalAddr().(*net.UDPAddr)
+
+	req, err := ParseRequest(bytes.NewBuffer(append([]byte{
+		statute.VersionSocks5, statute.CommandAssociate, 0,
+		statute.ATYPDomain, 9, 'e', 'c', 'h', 'o', '.', 't', 'e', 's', 't',
+	}, byte(tAddr.Port>>8), byte(tAddr.Port))))
+	require.NoError(t, err)
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	req.Reader = pr
+	req.RemoteAddr = &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 65432}
+
+	resolver := &CachingResolver{Resolver: addrResolverFunc(func(context.Context, string) ([]net.IP, time.Duration, error) {
+		return []net.IP{net.IPv4(127, 0, 0, 1), net.IPv4(127, 0, 0, 2)}, time.Minute, nil
+	})}
+	srv := NewServer(WithAssociateStrict(true), WithResolver(resolver), WithHappyEyeballs(0))
+	rsp := make(replyWriter, 1)
+	go srv.handleRequest(context.Background(), rsp, req) // nolint: errcheck
+	reply, err := statute.ParseReply(bytes.NewReader(<-rsp))
+	require.NoError(t, err)
+	require.Equal(t, statute.RepSuccess, reply.Response)
+
+	client, err := net.DialUDP("udp", nil, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: reply.BndAddr.Port})
+	require.NoError(t, err)
+	defer client.Close()
+
+	// the datagram to any ip the declared FQDN resolved to is relayed
+	pk, err := statute.NewDatagram(tAddr.String(), []byte("ping"))
+	require.NoError(t, err)
+	_, err = client.Write(pk.Bytes())
+	require.NoError(t, err)
+	target.SetReadDeadline(time.Now().Add(time.Second)) // nolint: errcheck
+	buf := make([]byte, 64)
+	n, err := target.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, []byte("ping"), buf[:n])
+}
+
+func TestRequest_Associate_SourceCheck(t *testing.T) {
+	target := udpEchoTarget(t)
+	listen := func() *net.UDPConn {
+		c, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		require.NoError(t, err)
+		t.Cleanup(func() { c.Close() })
+		return c
+	}
+	client, intruder := listen(), listen()
+
+	proxy := serveSocks(t, WithAssociateSourceCheck(true, false))
+	conn, relay := associate(t, proxy, client.LocalAddr().(*net.UDPAddr).Port)
+	defer conn.Close()
+
+	// the datagram from the undeclared port is dropped
+	_, err := udpPing(intruder, relay, target.LocalAddr(), "inject")
+	require.Error(t, err)
+	rsp, err := udpPing(client, relay, target.LocalAddr(), "ping")
+	require.NoError(t, err)
+	require.Equal(t, "ping", rsp)
+
+	// relaxed for NAT, the tcp peer's ip with any port
+	proxy = serveSocks(t, WithAssociateSourceCheck(true, true))
+	conn, relay = associate(t, proxy, 1)
+	defer conn.Close()
+	rsp, err = udpPing(intruder, relay, target.LocalAddr(), "ping")
+	require.NoError(t, err)
+	require.Equal(t, "ping", rsp)
+}
+
+func TestRequest_Associate_SmallDatagramBuffer(t *testing.T) {
+	target := udpEchoTarget(t)
+	srv := NewServer(WithDatagramBufferPool(bufferpool.NewPool(64)))
+	proxy, _ := startServer(t, srv)
+	defer srv.Close()
+
+	client, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	defer client.Close()
+	conn, relay := associate(t, proxy, client.LocalAddr().(*net.UDPAddr).Port)
+	defer conn.Close()
+
+	// the reply of the target is relayed, not panics slicing the buffer smaller than the header
+	echo, err := udpPing(client, relay, target.LocalAddr(), "ping")
+	require.NoError(t, err)
+	require.Equal(t, "ping", echo)
+	require.Equal(t, minDatagramBufferSize, cap(NewServer(WithBufferSize(0, 16)).datagramPool.Get()))
+}
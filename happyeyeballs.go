@@ -0,0 +1,185 @@
+package socks5
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+// defaultFallbackDelay is the delay before the next connection attempt, as recommended by RFC 8305
+const defaultFallbackDelay = 250 * time.Millisecond
+
+// HappyEyeballsDialer dials the tcp targets by the Happy Eyeballs (RFC 8305), when the destination
+// resolves to both the IPv6 and IPv4 addresses, the connection attempts alternating the address
+// families are raced, each started after the FallbackDelay or the failure of the previous one,
+// the first connection established wins and the others are cancelled.
+// The address resolved by the server from the FQDN of the request is tried first, the other
+// addresses of the FQDN are looked up by the Resolver, so a CachingResolver is recommended,
+// WithHappyEyeballs dials the addresses pinned before the rules only, never looked up again.
+type HappyEyeballsDialer struct {
+	// Resolver resolves all the addresses of the FQDN, defaults to DNSResolver
+	Resolver AddrResolver
+	// FallbackDelay before the next attempt starts, defaults to 250ms
+	FallbackDelay time.Duration
+	// Forward dials each address, defaults to net.Dialer
+	Forward Dialer
+}
+
+// DialContext implement interface Dialer
+func (sf HappyEyeballsDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	var d Dialer = new(net.Dialer)
+	if sf.Forward != nil {
+		d = sf.Forward
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || (network != "tcp" && network != "tcp4" && network != "tcp6") {
+		return d.DialContext(ctx, network, addr)
+	}
+	name, first := host, net.ParseIP(host)
+	if first != nil {
+		// only the address resolved from the FQDN, not the IP requested or rewritten
+		req, ok := RequestFromContext(ctx)
+		if !ok || req.ResolvedFQDN == "" || !first.Equal(req.ResolvedIP) {
+			return d.DialContext(ctx, network, addr)
+		}
+		name = req.ResolvedFQDN
+	}
+
+	var r AddrResolver = DNSResolver{}
+	if sf.Resolver != nil {
+		r = sf.Resolver
+	}
+	ips, _, err := r.LookupAddrs(ctx, name)
+	ips = interleaveAddrs(filterFamily(ips, network), first)
+	if len(ips) == 0 {
+		if first != nil {
+			return d.DialContext(ctx, network, addr)
+		}
+		if err == nil {
+			err = &net.DNSError{Err: errNoAddress.Error(), Name: name, IsNotFound: true}
+		}
+		return nil, err
+	}
+	addrs := make([]string, 0, len(ips))
+	for _, ip := range ips {
+		addrs = append(addrs, net.JoinHostPort(ip.String(), port))
+	}
+	delay := sf.FallbackDelay
+	if delay <= 0 {
+		delay = defaultFallbackDelay
+	}
+	return raceDial(ctx, d, network, addrs, delay)
+}
+
+// filterFamily keeps the addresses of the family of the network, "tcp4" or "tcp6"
+func filterFamily(ips []net.IP, network string) []net.IP {
+	if network != "tcp4" && network != "tcp6" {
+		return ips
+	}
+	out := make([]net.IP, 0, len(ips))
+	for _, ip := range ips {
+		if (ip.To4() != nil) == (network == "tcp4") {
+			out = append(out, ip)
+		}
+	}
+	return out
+}
+
+// interleaveAddrs orders the addresses alternating the families, starting with the first address
+// if it is one of them, otherwise the IPv6 as RFC 8305 recommended
+func interleaveAddrs(ips []net.IP, first net.IP) []net.IP {
+	var ip4, ip6 []net.IP
+	found := false
+	for _, ip := range ips {
+		if first != nil && ip.Equal(first) {
+			found = true
+			continue
+		}
+		if ip.To4() != nil {
+			ip4 = append(ip4, ip)
+		} else {
+			ip6 = append(ip6, ip)
+		}
+	}
+	primary, secondary := ip6, ip4
+	out := make([]net.IP, 0, len(ips))
+	if found {
+		out = append(out, first)
+		// the other family goes next
+		if first.To4() == nil {
+			primary, secondary = ip4, ip6
+		}
+	}
+	for i := 0; i < len(primary) || i < len(secondary); i++ {
+		if i < len(primary) {
+			out = append(out, primary[i])
+		}
+		if i < len(secondary) {
+			out = append(out, secondary[i])
+		}
+	}
+	return out
+}
+
+// raceDial dials the addresses in order, the next attempt starts after the delay or the failure
+// of the previous one, returns the first connection established, or the first error if all failed.
+func raceDial(ctx context.Context, d Dialer, network string, addrs []string, delay time.Duration) (net.Conn, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+	results := make(chan result, len(addrs))
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	next, pending := 0, 0
+	start := func() {
+		addr := addrs[next]
+		next++
+		pending++
+		go func() {
+			conn, err := d.DialContext(ctx, network, addr)
+			results <- result{conn, err}
+		}()
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(delay)
+	}
+
+	var firstErr error
+	start()
+	for pending > 0 {
+		select {
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				// close the connections established late
+				go func(n int) {
+					for ; n > 0; n-- {
+						if late := <-results; late.conn != nil {
+							late.conn.Close()
+						}
+					}
+				}(pending)
+				return r.conn, nil
+			}
+			if firstErr == nil {
+				firstErr = r.err
+			}
+			if next < len(addrs) {
+				start()
+			}
+		case <-timer.C:
+			if next < len(addrs) {
+				start()
+			}
+		}
+	}
+	return nil, firstErr
+}
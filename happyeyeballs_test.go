@@ -0,0 +1,131 @@
+package socks5
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/thinkgos/go-socks5/statute"
+)
+
+func TestInterleaveAddrs(t *testing.T) {
+	a4, b4 := net.IPv4(10, 0, 0, 1), net.IPv4(10, 0, 0, 2)
+	a6, b6 := net.ParseIP("2001:db8::1"), net.ParseIP("2001:db8::2")
+	ips := []net.IP{a4, b4, a6, b6}
+	assert.Equal(t, []net.IP{a6, a4, b6, b4}, interleaveAddrs(ips, nil))
+	assert.Equal(t, []net.IP{b4, a6, a4, b6}, interleaveAddrs(ips, b4))
+	assert.Equal(t, []net.IP{b6, a4, a6, b4}, interleaveAddrs(ips, b6))
+	assert.Equal(t, []net.IP{a4, b4}, filterFamily(ips, "tcp4"))
+}
+
+func TestHappyEyeballsDialer(t *testing.T) {
+	var mu sync.Mutex
+	var dialed []string
+	cancelled := make(chan string, 4)
+	forward := DialFunc(func(ctx context.Context, network, addr string) (net.Conn, error) {
+		mu.Lock()
+		dialed = append(dialed, addr)
+		mu.Unlock()
+		switch addr {
+		case "[2001:db8::1]:80": // black holed
+			<-ctx.Done()
+			cancelled <- addr
+			return nil, ctx.Err()
+		case "[2001:db8::2]:80":
+			return nil, errors.New("connection refused")
+		}
+		c1, c2 := net.Pipe()
+		go c2.Close()
+		return c1, nil
+	})
+	ips := []net.IP{net.IPv4(10, 0, 0, 1), net.ParseIP("2001:db8::1")}
+	d := HappyEyeballsDialer{
+		Resolver: addrResolverFunc(func(context.Context, string) ([]net.IP, time.Duration, error) {
+			return ips, 0, nil
+		}),
+		FallbackDelay: 50 * time.Millisecond,
+		Forward:       forward,
+	}
+
+	start := time.Now()
+	conn, err := d.DialContext(context.Background(), "tcp", "dual.example:80")
+	require.NoError(t, err)
+	conn.Close()
+	assert.GreaterOrEqual(t, int64(time.Since(start)), int64(50*time.Millisecond))
+	assert.Equal(t, "[2001:db8::1]:80", <-cancelled)
+	assert.Equal(t, []string{"[2001:db8::1]:80", "10.0.0.1:80"}, dialed)
+
+	// the failure starts the next attempt at once
+	dialed = nil
+	ips = []net.IP{net.ParseIP("2001:db8::2"), net.IPv4(10, 0, 0, 1)}
+	d.FallbackDelay = time.Hour
+	conn, err = d.DialContext(context.Background(), "tcp", "dual.example:80")
+	require.NoError(t, err)
+	conn.Close()
+	assert.Equal(t, []string{"[2001:db8::2]:80", "10.0.0.1:80"}, dialed)
+
+	// all failed
+	ips = []net.IP{net.ParseIP("2001:db8::2")}
+	_, err = d.DialContext(context.Background(), "tcp", "dual.example:80")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "refused")
+
+	// the IP not resolved from a FQDN is dialed directly
+	dialed = nil
+	conn, err = d.DialContext(context.Background(), "tcp", "10.0.0.9:80")
+	require.NoError(t, err)
+	conn.Close()
+	assert.Equal(t, []string{"10.0.0.9:80"}, dialed)
+}
+
+func TestServer_HappyEyeballs(t *testing.T) {
+	target := echoTarget(t)
+	// the IPv6 address preferred is not listened
+	resolver := PolicyResolver{
+		Resolver: addrResolverFunc(func(context.Context, string) ([]net.IP, time.Duration, error) {
+			return []net.IP{net.IPv6loopback, target.IP}, 0, nil
+		}),
+		Policy: PreferIPv6,
+	}
+
+	proxy := serveSocks(t, WithResolver(resolver))
+	assert.NotEqual(t, statute.RepSuccess, connectDomain(t, proxy, "dual.example", target.Port))
+	proxy = serveSocks(t, WithResolver(resolver), WithHappyEyeballs(50*time.Millisecond))
+	assert.Equal(t, statute.RepSuccess, connectDomain(t, proxy, "dual.example", target.Port))
+}
+
+// connectDomain connects the FQDN through the proxy with the pipelined "ping",
+// the echo is checked if succeeded, it returns the reply code.
+func connectDomain(t *testing.T, proxy net.Addr, fqdn string, port int) uint8 {
+	conn, err := net.Dial("tcp", proxy.String())
+	require.NoError(t, err)
+	defer conn.Close()
+	req := bytes.NewBuffer([]byte{statute.VersionSocks5, 1, statute.MethodNoAuth})
+	req.Write(statute.Request{
+		Version: statute.VersionSocks5,
+		Command: statute.CommandConnect,
+		DstAddr: statute.AddrSpec{AddrType: statute.ATYPDomain, FQDN: fqdn, Port: port},
+	}.Bytes())
+	req.WriteString("ping")
+	_, err = conn.Write(req.Bytes())
+	require.NoError(t, err)
+	_, err = statute.ParseMethodReply(conn)
+	require.NoError(t, err)
+	rep, err := statute.ParseReply(conn)
+	require.NoError(t, err)
+	if rep.Response == statute.RepSuccess {
+		buf := make([]byte, 4)
+		_, err = io.ReadFull(conn, buf)
+		require.NoError(t, err)
+		assert.Equal(t, "ping", string(buf))
+	}
+	return rep.Response
+}
@@ -0,0 +1,127 @@
+package socks5
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// htpasswdCheckInterval is the default minimum interval between checks of the file for changes
+const htpasswdCheckInterval = time.Second
+
+// htpasswdDummyHash is compared for an unknown user so it takes as long as a wrong password
+var htpasswdDummyHash = []byte("$2a$10$HHYHyzdi1RFp6rPibwkZsePq0tv22tx4wAu.wYaQX9GJcua..AcvO")
+
+// HtpasswdCredentials is a credential store backed by an htpasswd-style file,
+// each line is "user:hash" with a bcrypt hash ($2a$, $2b$ or $2y$), blank lines and
+// lines starting with # are ignored. The file is reloaded once it is modified.
+type HtpasswdCredentials struct {
+	path string
+	// CheckInterval is the minimum interval between checks of the file for changes.
+	CheckInterval time.Duration
+	// ErrorHandle optional handle the reload failure, the previous users are kept.
+	ErrorHandle func(err error)
+
+	mu        sync.RWMutex
+	users     map[string][]byte
+	modTime   time.Time
+	size      int64
+	lastCheck time.Time
+}
+
+// NewHtpasswdCredentials new credential store with the htpasswd file
+func NewHtpasswdCredentials(path string) (*HtpasswdCredentials, error) {
+	sf := &HtpasswdCredentials{path: path, CheckInterval: htpasswdCheckInterval}
+	if err := sf.Reload(); err != nil {
+		return nil, err
+	}
+	return sf, nil
+}
+
+// Reload reads the file and replaces the users
+func (sf *HtpasswdCredentials) Reload() error {
+	fi, err := os.Stat(sf.path)
+	if err != nil {
+		return fmt.Errorf("htpasswd: stat %s, %v", sf.path, err)
+	}
+	b, err := ioutil.ReadFile(sf.path)
+	if err != nil {
+		return fmt.Errorf("htpasswd: read %s, %v", sf.path, err)
+	}
+	users, err := parseHtpasswd(b)
+	if err != nil {
+		return fmt.Errorf("htpasswd: %s, %v", sf.path, err)
+	}
+	sf.mu.Lock()
+	sf.users, sf.modTime, sf.size, sf.lastCheck = users, fi.ModTime(), fi.Size(), time.Now()
+	sf.mu.Unlock()
+	return nil
+}
+
+// Valid implement interface CredentialStore
+func (sf *HtpasswdCredentials) Valid(user, password, _ string) bool {
+	sf.checkReload()
+	sf.mu.RLock()
+	hash, ok := sf.users[user]
+	sf.mu.RUnlock()
+	if !ok {
+		bcrypt.CompareHashAndPassword(htpasswdDummyHash, []byte(password)) // nolint: errcheck
+		return false
+	}
+	return bcrypt.CompareHashAndPassword(hash, []byte(password)) == nil
+}
+
+// checkReload reloads the file if it is modified, checked at most once per interval
+func (sf *HtpasswdCredentials) checkReload() {
+	now := time.Now()
+	sf.mu.Lock()
+	if now.Sub(sf.lastCheck) < sf.CheckInterval {
+		sf.mu.Unlock()
+		return
+	}
+	sf.lastCheck = now
+	modTime, size := sf.modTime, sf.size
+	sf.mu.Unlock()
+
+	fi, err := os.Stat(sf.path)
+	if err == nil && fi.ModTime().Equal(modTime) && fi.Size() == size {
+		return
+	}
+	if err == nil {
+		err = sf.Reload()
+	} else {
+		err = fmt.Errorf("htpasswd: stat %s, %v", sf.path, err)
+	}
+	if err != nil && sf.ErrorHandle != nil {
+		sf.ErrorHandle(err)
+	}
+}
+
+// parseHtpasswd parses the htpasswd file content to the user to bcrypt hash map
+func parseHtpasswd(b []byte) (map[string][]byte, error) {
+	users := make(map[string][]byte)
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		idx := strings.IndexByte(text, ':')
+		if idx <= 0 {
+			return nil, fmt.Errorf("line %d, missing user", line)
+		}
+		user, hash := text[:idx], text[idx+1:]
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return nil, fmt.Errorf("line %d, user %s, not a bcrypt hash, %v", line, user, err)
+		}
+		users[user] = []byte(hash)
+	}
+	return users, scanner.Err()
+}
@@ -0,0 +1,58 @@
+package socks5
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// bcrypt hash of "bar" with the min cost
+const htpasswdBar = "$2a$04$/RLzQtKToypZXvONk2pcDe/TO3mbd/G/WYDHfUgNwVWd5HqMPCSgm"
+
+func writeHtpasswd(t *testing.T, path, content string, modTime time.Time) {
+	require.NoError(t, ioutil.WriteFile(path, []byte(content), 0600))
+	require.NoError(t, os.Chtimes(path, modTime, modTime))
+}
+
+func TestHtpasswdCredentials(t *testing.T) {
+	dir, err := ioutil.TempDir("", "htpasswd")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "htpasswd")
+
+	now := time.Now()
+	writeHtpasswd(t, path, "# users\n\nfoo:"+htpasswdBar+"\n", now.Add(-time.Minute))
+	creds, err := NewHtpasswdCredentials(path)
+	require.NoError(t, err)
+	creds.CheckInterval = 0
+
+	require.True(t, creds.Valid("foo", "bar", ""))
+	require.False(t, creds.Valid("foo", "baz", ""))
+	require.False(t, creds.Valid("baz", "bar", ""))
+
+	// hot reload
+	writeHtpasswd(t, path, "baz:"+htpasswdBar+"\n", now)
+	require.True(t, creds.Valid("baz", "bar", ""))
+	require.False(t, creds.Valid("foo", "bar", ""))
+
+	// a broken file keeps the previous users
+	var reloadErr error
+	creds.ErrorHandle = func(err error) { reloadErr = err }
+	writeHtpasswd(t, path, "baz:plaintext\n", now.Add(time.Minute))
+	require.True(t, creds.Valid("baz", "bar", ""))
+	require.Error(t, reloadErr)
+}
+
+func TestParseHtpasswd(t *testing.T) {
+	_, err := parseHtpasswd([]byte("foo:bar\n"))
+	require.Error(t, err)
+	_, err = parseHtpasswd([]byte(":" + htpasswdBar + "\n"))
+	require.Error(t, err)
+	users, err := parseHtpasswd([]byte(" foo:" + htpasswdBar + " \n#bar:x\n"))
+	require.NoError(t, err)
+	require.Len(t, users, 1)
+}
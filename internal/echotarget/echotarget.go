@@ -0,0 +1,45 @@
+// Package echotarget is the loopback echo targets shared by the self test, the conformance checker
+// and the load generator.
+package echotarget
+
+import (
+	"io"
+	"net"
+
+	"github.com/thinkgos/go-socks5/statute"
+)
+
+// ServeTCP echoes the connections accepted from the listener until it is closed
+func ServeTCP(l net.Listener) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			io.Copy(conn, conn) // nolint: errcheck
+		}()
+	}
+}
+
+// ServeUDP echoes the datagrams read from the packet conn until it is closed
+func ServeUDP(pc net.PacketConn) {
+	buf := make([]byte, 64*1024)
+	for {
+		n, addr, err := pc.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		pc.WriteTo(buf[:n], addr) // nolint: errcheck
+	}
+}
+
+// AddrSpec returns the address spec of the tcp address, such as of the echo target
+func AddrSpec(addr net.Addr) statute.AddrSpec {
+	a := addr.(*net.TCPAddr)
+	if ip4 := a.IP.To4(); ip4 != nil {
+		return statute.AddrSpec{IP: ip4, Port: a.Port, AddrType: statute.ATYPIPv4}
+	}
+	return statute.AddrSpec{IP: a.IP, Port: a.Port, AddrType: statute.ATYPIPv6}
+}
@@ -0,0 +1,216 @@
+package socks5
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"time"
+)
+
+// ListenerOption is the option of a listener served by the server,
+// it overrides the server's option for the connections accepted by the listener.
+type ListenerOption func(c *listenerConfig)
+
+// listenerConfig is the per listener config
+type listenerConfig struct {
+	rules       RuleSet
+	authMethods map[uint8]Authenticator
+	tenant      string
+	dialer      Dialer
+	tls         *tls.Config
+	clientAuth  *tls.ClientAuthType
+	clientCAs   *x509.CertPool
+	transparent TransparentMode
+	// middlewares wrap the server's handler, handler is the chain built
+	middlewares []Middleware
+	handler     Handler
+	// the timeouts overriding the server's, nil if not overridden
+	handshakeTimeout *time.Duration
+	idleTimeout      *time.Duration
+	clientTimeout    *readWriteTimeout
+	targetTimeout    *readWriteTimeout
+	// addr of the listener served
+	addr net.Addr
+}
+
+// readWriteTimeout is the read and write timeout of a leg of the relay
+type readWriteTimeout struct {
+	read, write time.Duration
+}
+
+// WithListenerRule overrides the RuleSet of the server for the listener,
+// such as an internal listener more permissive than the public one.
+func WithListenerRule(rule RuleSet) ListenerOption {
+	return func(c *listenerConfig) {
+		c.rules = rule
+	}
+}
+
+// WithListenerAuthMethods overrides the authentication methods of the server for the listener.
+func WithListenerAuthMethods(authMethods []Authenticator) ListenerOption {
+	return func(c *listenerConfig) {
+		if len(authMethods) != 0 {
+			c.authMethods = make(map[uint8]Authenticator, len(authMethods))
+			for _, v := range authMethods {
+				c.authMethods[v.GetCode()] = v
+			}
+		}
+	}
+}
+
+// WithListenerTenant set the accounting tenant of the listener, see Request.Tenant.
+func WithListenerTenant(tenant string) ListenerOption {
+	return func(c *listenerConfig) {
+		c.tenant = tenant
+	}
+}
+
+// WithListenerDialer overrides the dialer of the server for the listener,
+// such as dialing out through the tenant's upstream.
+func WithListenerDialer(d Dialer) ListenerOption {
+	return func(c *listenerConfig) {
+		c.dialer = d
+	}
+}
+
+// WithListenerTLS serves TLS on the listener, the server does the TLS handshake
+// of the accepted connections before the SOCKS negotiation.
+func WithListenerTLS(cfg *tls.Config) ListenerOption {
+	return func(c *listenerConfig) {
+		c.tls = cfg
+	}
+}
+
+// WithListenerClientAuth sets the TLS client auth policy of the listener, such as
+// tls.RequireAndVerifyClientCert or tls.VerifyClientCertIfGiven, with the CA pool
+// which verifies the client certificates. It applies to the tls config of WithListenerTLS, or of
+// the server's WithTLSConfig, Serve returns an error if neither is set.
+// The verified chains are exposed by Request.TLS and TLSAuthenticator.
+func WithListenerClientAuth(mode tls.ClientAuthType, ca *x509.CertPool) ListenerOption {
+	return func(c *listenerConfig) {
+		c.clientAuth = &mode
+		c.clientCAs = ca
+	}
+}
+
+// WithListenerTransparent serves the listener as a transparent TCP proxy, the connections
+// redirected by the iptables REDIRECT or TPROXY are relayed to the original destination without
+// the SOCKS handshake, by the same rules, dialer and relay of the CONNECT, no reply is written.
+// The client is not authenticated, the TLS and the PROXY protocol are not served on the listener.
+// The connections to the listener itself are closed, the port of the listener bound to the
+// unspecified address must not be intercepted by TPROXY.
+func WithListenerTransparent(mode TransparentMode) ListenerOption {
+	return func(c *listenerConfig) {
+		c.transparent = mode
+	}
+}
+
+// WithListenerMiddleware wraps the handling of the request of the listener by the middlewares,
+// they run before the server's of WithMiddleware, the first one is the outermost.
+func WithListenerMiddleware(middlewares ...Middleware) ListenerOption {
+	return func(c *listenerConfig) {
+		c.middlewares = append(c.middlewares, middlewares...)
+	}
+}
+
+// WithListenerHandshakeTimeout overrides the WithHandshakeTimeout of the server for the listener,
+// 0 means no limit. The PROXY protocol header is read by the timeout of the listener accepted it,
+// not by the virtual server selected after.
+func WithListenerHandshakeTimeout(timeout time.Duration) ListenerOption {
+	return func(c *listenerConfig) {
+		c.handshakeTimeout = &timeout
+	}
+}
+
+// WithListenerIdleTimeout overrides the WithIdleTimeout of the server for the listener, 0 means never.
+func WithListenerIdleTimeout(timeout time.Duration) ListenerOption {
+	return func(c *listenerConfig) {
+		c.idleTimeout = &timeout
+	}
+}
+
+// WithListenerClientTimeout overrides the WithClientTimeout of the server for the listener.
+func WithListenerClientTimeout(read, write time.Duration) ListenerOption {
+	return func(c *listenerConfig) {
+		c.clientTimeout = &readWriteTimeout{read, write}
+	}
+}
+
+// WithListenerTargetTimeout overrides the WithTargetTimeout of the server for the listener.
+func WithListenerTargetTimeout(read, write time.Duration) ListenerOption {
+	return func(c *listenerConfig) {
+		c.targetTimeout = &readWriteTimeout{read, write}
+	}
+}
+
+func newListenerConfig(opts ...ListenerOption) *listenerConfig {
+	if len(opts) == 0 {
+		return nil
+	}
+	c := &listenerConfig{}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// errClientAuthWithoutTLS the client auth of the listener is set without any tls config
+var errClientAuthWithoutTLS = errors.New("socks5: listener client auth without TLS")
+
+// buildTLS applies the client auth of the listener to its tls config, or to a copy of the
+// server's if the listener has none, it returns an error if TLS is not served at all.
+func (sf *Server) buildTLS(c *listenerConfig) error {
+	if c == nil || c.clientAuth == nil {
+		return nil
+	}
+	cfg := sf.tlsConfigOf(c)
+	if cfg == nil {
+		return errClientAuthWithoutTLS
+	}
+	c.tls = cfg.Clone()
+	c.tls.ClientAuth = *c.clientAuth
+	if c.clientCAs != nil {
+		c.tls.ClientCAs = c.clientCAs
+	}
+	return nil
+}
+
+// buildHandler chains the middlewares of the listener to the server's handler
+func (sf *Server) buildHandler(c *listenerConfig) {
+	if c != nil && len(c.middlewares) != 0 {
+		c.handler = chainHandler(sf.handler, c.middlewares)
+	}
+}
+
+// handlerOf returns the handler of the listener or the server's
+func (sf *Server) handlerOf(c *listenerConfig) Handler {
+	if c != nil && c.handler != nil {
+		return c.handler
+	}
+	return sf.handler
+}
+
+// ruleSet returns the RuleSet of the request
+func (sf *Server) ruleSet(req *Request) RuleSet {
+	if req.listener != nil && req.listener.rules != nil {
+		return req.listener.rules
+	}
+	return sf.currentRules()
+}
+
+// virtualServer returns the config of the virtual server matched the local address,
+// the exact ip:port is preferred to the ip.
+func (sf *Server) virtualServer(localAddr net.Addr) *listenerConfig {
+	if len(sf.virtualServers) == 0 || localAddr == nil {
+		return nil
+	}
+	addr := unmapAddr(localAddr).String()
+	if c, ok := sf.virtualServers[addr]; ok {
+		return c
+	}
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return sf.virtualServers[host]
+	}
+	return nil
+}
@@ -0,0 +1,178 @@
+package socks5
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/proxy"
+)
+
+func TestServer_ListenerRule(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	srv := NewServer(WithRule(NewPermitNone()))
+	public, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go srv.Serve(public) // nolint: errcheck
+	internal, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go srv.Serve(internal, WithListenerRule(NewPermitAll())) // nolint: errcheck
+
+	dial, err := proxy.SOCKS5("tcp", public.Addr().String(), nil, proxy.Direct)
+	require.NoError(t, err)
+	_, err = dial.Dial("tcp", l.Addr().String())
+	require.Error(t, err)
+
+	dial, err = proxy.SOCKS5("tcp", internal.Addr().String(), nil, proxy.Direct)
+	require.NoError(t, err)
+	conn, err := dial.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	conn.Close()
+}
+
+func TestServer_VirtualServer(t *testing.T) {
+	srv := NewServer(
+		WithVirtualServer("127.0.0.1:1080", WithListenerTenant("foo")),
+		WithVirtualServer("::ffff:127.0.0.2", WithListenerTenant("bar")),
+	)
+	c := srv.virtualServer(&net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 1080})
+	require.NotNil(t, c)
+	require.Equal(t, "foo", c.tenant)
+
+	c = srv.virtualServer(&net.TCPAddr{IP: net.ParseIP("127.0.0.2"), Port: 1081})
+	require.NotNil(t, c)
+	require.Equal(t, "bar", c.tenant)
+
+	require.Nil(t, srv.virtualServer(&net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 1081}))
+}
+
+func TestServer_ServeListeners(t *testing.T) {
+	target := echoTarget(t)
+	dir, err := ioutil.TempDir("", "socks5")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	tcpL, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	unixL, err := net.Listen("unix", filepath.Join(dir, "socks5.sock"))
+	require.NoError(t, err)
+
+	srv := NewServer()
+	done := make(chan error, 1)
+	go func() { done <- srv.ServeListeners(context.Background(), tcpL, unixL) }()
+
+	// the sessions of all the listeners are relayed
+	for _, l := range []net.Listener{tcpL, unixL} {
+		relaySession(t, l.Addr(), target).Close()
+	}
+
+	// the shared shutdown stops all the listeners
+	require.NoError(t, srv.Shutdown(context.Background()))
+	select {
+	case err := <-done:
+		require.Equal(t, ErrServerClosed, err)
+	case <-time.After(time.Second):
+		t.Fatal("ServeListeners not returned")
+	}
+}
+
+func TestServer_ServeListenersFailure(t *testing.T) {
+	l1, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	l2, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- NewServer().ServeListeners(ctx, l1, l2) }()
+
+	cancel()
+	require.Equal(t, context.Canceled, <-done)
+	_, err = net.Dial("tcp", l2.Addr().String())
+	require.Error(t, err)
+}
+
+func TestActivationListeners(t *testing.T) {
+	// not activated, the environment of the other process
+	os.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()+1)) // nolint: errcheck
+	os.Setenv("LISTEN_FDS", "1")                         // nolint: errcheck
+	ls, err := ActivationListeners()
+	require.NoError(t, err)
+	require.Nil(t, ls)
+	_, ok := os.LookupEnv("LISTEN_FDS")
+	require.False(t, ok)
+}
+
+func TestServer_ListenerMiddleware(t *testing.T) {
+	target := echoTarget(t)
+	order := make(chan string, 4)
+	trace := func(name string) Middleware {
+		return func(next Handler) Handler {
+			return func(ctx context.Context, writer io.Writer, request *Request) error {
+				order <- name
+				return next(ctx, writer, request)
+			}
+		}
+	}
+	srv := NewServer(WithMiddleware(trace("server")))
+	public, _ := startServer(t, srv)
+	internal, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go srv.Serve(internal, WithListenerMiddleware(trace("listener"))) // nolint: errcheck
+	t.Cleanup(func() { srv.Close() })
+
+	relaySession(t, internal.Addr(), target).Close()
+	require.Equal(t, "listener", <-order)
+	require.Equal(t, "server", <-order)
+
+	relaySession(t, public, target).Close()
+	require.Equal(t, "server", <-order)
+	require.Len(t, order, 0)
+}
+
+func TestServer_ListenerTimeout(t *testing.T) {
+	srv := NewServer(WithHandshakeTimeout(time.Minute), WithIdleTimeout(time.Minute),
+		WithClientTimeout(time.Minute, time.Minute))
+	lc := newListenerConfig(WithListenerHandshakeTimeout(50*time.Millisecond), WithListenerIdleTimeout(0),
+		WithListenerClientTimeout(0, time.Second))
+	require.Equal(t, 50*time.Millisecond, srv.handshakeTimeoutOf(lc))
+	require.Equal(t, time.Duration(0), srv.idleTimeoutOf(lc))
+	require.Equal(t, time.Minute, srv.handshakeTimeoutOf(nil))
+	require.Equal(t, time.Minute, srv.idleTimeoutOf(newListenerConfig(WithListenerTenant("foo"))))
+
+	client, _ := tcpPair(t)
+	target, _ := tcpPair(t)
+	clientR, clientW, targetR, _ := srv.relayLegs(&Request{Reader: client, conn: client, listener: lc}, client, target)
+	require.Equal(t, client, clientR)
+	require.Equal(t, time.Second, clientW.(*timeoutWriter).timeout)
+	require.Equal(t, target, targetR)
+
+	// the silent client is closed by the handshake timeout of the listener
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go srv.Serve(l, WithListenerHandshakeTimeout(50*time.Millisecond)) // nolint: errcheck
+	t.Cleanup(func() { srv.Close() })
+	conn, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(time.Second)) // nolint: errcheck
+	_, err = conn.Read(make([]byte, 1))
+	require.Equal(t, io.EOF, err)
+}
@@ -0,0 +1,157 @@
+package socks5
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/thinkgos/go-socks5/statute"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pool *x509.CertPool
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tpl, tpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return &testCA{cert, key, pool}
+}
+
+func (ca *testCA) issue(t *testing.T, cn string, usage x509.ExtKeyUsage) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tpl, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(t, err)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+type certAuthenticator struct{ NoAuthAuthenticator }
+
+func (certAuthenticator) AuthenticateTLS(reader io.Reader, writer io.Writer, userAddr string,
+	state *tls.ConnectionState) (*AuthContext, error) {
+	ac, err := NoAuthAuthenticator{}.Authenticate(reader, writer, userAddr)
+	if err == nil && len(state.VerifiedChains) > 0 {
+		ac.Payload["username"] = state.VerifiedChains[0][0].Subject.CommonName
+	}
+	return ac, err
+}
+
+func TestServer_ListenerClientAuth(t *testing.T) {
+	ca := newTestCA(t)
+	type seen struct {
+		user   string
+		chains int
+	}
+	seenCh := make(chan seen, 1)
+	srv := NewServer(WithRule(ruleFunc(func(ctx context.Context, req *Request) (context.Context, bool) {
+		seenCh <- seen{req.AuthContext.Payload["username"], len(req.TLS.VerifiedChains)}
+		return ctx, false
+	})))
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	serverCert := ca.issue(t, "server", x509.ExtKeyUsageServerAuth)
+	go srv.Serve(l, // nolint: errcheck
+		WithListenerTLS(&tls.Config{Certificates: []tls.Certificate{serverCert}}),
+		WithListenerClientAuth(tls.RequireAndVerifyClientCert, ca.pool),
+		WithListenerAuthMethods([]Authenticator{certAuthenticator{}}),
+	)
+
+	dial := func(certs []tls.Certificate) error {
+		conn, err := tls.Dial("tcp", l.Addr().String(), &tls.Config{RootCAs: ca.pool, Certificates: certs})
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		if _, err = conn.Write([]byte{
+			statute.VersionSocks5, 1, statute.MethodNoAuth,
+			statute.VersionSocks5, statute.CommandConnect, 0, statute.ATYPIPv4, 127, 0, 0, 1, 0, 80,
+		}); err != nil {
+			return err
+		}
+		if _, err = io.ReadFull(conn, make([]byte, 2)); err != nil {
+			return err
+		}
+		_, err = statute.ParseReply(conn)
+		return err
+	}
+
+	require.NoError(t, dial([]tls.Certificate{ca.issue(t, "alice", x509.ExtKeyUsageClientAuth)}))
+	require.Equal(t, seen{"alice", 1}, <-seenCh)
+
+	require.Error(t, dial(nil))
+}
+
+func TestServer_ListenerClientAuth_ServerTLS(t *testing.T) {
+	ca := newTestCA(t)
+	serverCert := ca.issue(t, "server", x509.ExtKeyUsageServerAuth)
+	srv := NewServer(WithTLSConfig(&tls.Config{Certificates: []tls.Certificate{serverCert}}))
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	go srv.Serve(l, WithListenerClientAuth(tls.RequireAndVerifyClientCert, ca.pool)) // nolint: errcheck
+
+	// the client auth applies to the tls config of the server
+	greet := func(certs []tls.Certificate) error {
+		conn, err := tls.Dial("tcp", l.Addr().String(), &tls.Config{RootCAs: ca.pool, Certificates: certs})
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		if _, err = conn.Write([]byte{statute.VersionSocks5, 1, statute.MethodNoAuth}); err != nil {
+			return err
+		}
+		_, err = statute.ParseMethodReply(conn)
+		return err
+	}
+	require.NoError(t, greet([]tls.Certificate{ca.issue(t, "alice", x509.ExtKeyUsageClientAuth)}))
+	require.Error(t, greet(nil))
+	require.Nil(t, srv.tlsConfig.ClientCAs)
+
+	// no TLS at all
+	l, err = net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	require.Equal(t, errClientAuthWithoutTLS, NewServer().Serve(l, WithListenerClientAuth(tls.RequireAnyClientCert, nil)))
+	err = NewServer(WithVirtualServer("127.0.0.1", WithListenerClientAuth(tls.RequireAnyClientCert, nil))).Validate()
+	require.EqualError(t, err, "socks5: invalid configuration, WithVirtualServer: client auth of 127.0.0.1 without TLS")
+}
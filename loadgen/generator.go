@@ -0,0 +1,326 @@
+package loadgen
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/thinkgos/go-socks5/internal/echotarget"
+	"github.com/thinkgos/go-socks5/statute"
+)
+
+// generator holds the echo targets served for the sessions
+type generator struct {
+	cfg     Config
+	echo    net.Listener
+	udp     net.PacketConn
+	started int64 // sessions started, atomic
+}
+
+// stats of the sessions run by a worker
+type stats struct {
+	sessions     int
+	failures     int
+	firstError   error
+	firstErrorAt time.Time
+	bytes        uint64
+	datagrams    int
+	lost         int
+	connect      []time.Duration
+	roundTrip    []time.Duration
+}
+
+func newGenerator(cfg Config) (*generator, error) {
+	g := &generator{cfg: cfg}
+	var err error
+	if g.echo, err = net.Listen("tcp", net.JoinHostPort(cfg.Host, "0")); err != nil {
+		return nil, fmt.Errorf("loadgen: listen echo target, %v", err)
+	}
+	go echotarget.ServeTCP(g.echo)
+	if cfg.Mode == Associate {
+		if g.udp, err = net.ListenPacket("udp", net.JoinHostPort(cfg.Host, "0")); err != nil {
+			g.close()
+			return nil, fmt.Errorf("loadgen: listen udp echo target, %v", err)
+		}
+		go echotarget.ServeUDP(g.udp)
+	}
+	return g, nil
+}
+
+func (sf *generator) close() {
+	sf.echo.Close()
+	if sf.udp != nil {
+		sf.udp.Close()
+	}
+}
+
+// next reports whether another session should be started
+func (sf *generator) next(ctx context.Context, start time.Time) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	if sf.cfg.Duration > 0 {
+		return time.Since(start) < sf.cfg.Duration
+	}
+	return atomic.AddInt64(&sf.started, 1) <= int64(sf.cfg.Sessions)
+}
+
+// work runs the sessions one by one until no more should be started
+func (sf *generator) work(ctx context.Context, st *stats, start time.Time, seed int64) {
+	rnd := rand.New(rand.NewSource(seed))
+	buf := make([]byte, sf.cfg.Payload.Size)
+	rbuf := make([]byte, 64*1024)
+	if len(buf) > len(rbuf) {
+		rbuf = make([]byte, len(buf))
+	}
+	for sf.next(ctx, start) {
+		var err error
+		if sf.cfg.Mode == Associate {
+			err = sf.associate(ctx, st, rnd, buf, rbuf)
+		} else {
+			err = sf.connect(ctx, st, rnd, buf, rbuf)
+		}
+		// the sessions interrupted by the ctx are not counted
+		if err != nil && ctx.Err() != nil {
+			return
+		}
+		st.sessions++
+		if err != nil {
+			st.failures++
+			if st.firstError == nil {
+				st.firstError, st.firstErrorAt = err, time.Now()
+			}
+		}
+	}
+}
+
+// fill fills the payload of the index by the pattern
+func (sf *generator) fill(buf []byte, rnd *rand.Rand, index int) {
+	switch sf.cfg.Payload.Fill {
+	case FillRandom:
+		rnd.Read(buf) // nolint: errcheck
+	case FillSequence:
+		for i := range buf {
+			buf[i] = byte(index + i)
+		}
+	default:
+		for i := range buf {
+			buf[i] = 0
+		}
+	}
+}
+
+// pause waits the interval between the payloads
+func (sf *generator) pause(ctx context.Context, index int) error {
+	if index == 0 || sf.cfg.Payload.Interval <= 0 {
+		return ctx.Err()
+	}
+	t := time.NewTimer(sf.cfg.Payload.Interval)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// connect runs a CONNECT session echoing the payloads by the tcp echo target
+func (sf *generator) connect(ctx context.Context, st *stats, rnd *rand.Rand, buf, rbuf []byte) error {
+	begin := time.Now()
+	conn, err := sf.request(statute.CommandConnect, echotarget.AddrSpec(sf.echo.Addr()))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	st.connect = append(st.connect, time.Since(begin))
+
+	rbuf = rbuf[:len(buf)]
+	for i := 0; i < sf.cfg.Payload.Count; i++ {
+		if err := sf.pause(ctx, i); err != nil {
+			return err
+		}
+		sf.fill(buf, rnd, i)
+		conn.SetDeadline(time.Now().Add(sf.cfg.Timeout)) // nolint: errcheck
+		begin = time.Now()
+		if _, err := conn.Write(buf); err != nil {
+			return fmt.Errorf("write payload, %v", err)
+		}
+		if _, err := io.ReadFull(conn, rbuf); err != nil {
+			return fmt.Errorf("read payload, %v", err)
+		}
+		st.roundTrip = append(st.roundTrip, time.Since(begin))
+		if !bytes.Equal(buf, rbuf) {
+			return fmt.Errorf("payload %d corrupted", i)
+		}
+		st.bytes += uint64(len(buf))
+	}
+	return nil
+}
+
+// associate runs an ASSOCIATE session echoing the payloads by the udp echo target,
+// the index of the payload is stamped at the head to match the echo.
+func (sf *generator) associate(ctx context.Context, st *stats, rnd *rand.Rand, buf, rbuf []byte) error {
+	pc, err := net.ListenPacket("udp", net.JoinHostPort(sf.cfg.Host, "0"))
+	if err != nil {
+		return fmt.Errorf("listen udp, %v", err)
+	}
+	defer pc.Close()
+
+	begin := time.Now()
+	conn, err := sf.request(statute.CommandAssociate, statute.AddrSpec{IP: net.IPv4zero, AddrType: statute.ATYPIPv4})
+	if err != nil {
+		return err
+	}
+	// the association lives as long as the tcp connection
+	defer conn.Close()
+	st.connect = append(st.connect, time.Since(begin))
+	relay, err := net.ResolveUDPAddr("udp", sf.relayAddr(conn))
+	if err != nil {
+		return fmt.Errorf("relay address, %v", err)
+	}
+
+	target := sf.udp.LocalAddr().String()
+	for i := 0; i < sf.cfg.Payload.Count; i++ {
+		if err := sf.pause(ctx, i); err != nil {
+			return err
+		}
+		sf.fill(buf, rnd, i)
+		if len(buf) >= 4 {
+			binary.BigEndian.PutUint32(buf, uint32(i))
+		}
+		pk, err := statute.NewDatagram(target, buf)
+		if err != nil {
+			return err
+		}
+		begin = time.Now()
+		if _, err := pc.WriteTo(pk.Bytes(), relay); err != nil {
+			return fmt.Errorf("write datagram, %v", err)
+		}
+		st.datagrams++
+		echoed, err := readEcho(pc, buf, rbuf, time.Now().Add(sf.cfg.Timeout))
+		if err != nil {
+			return err
+		}
+		if !echoed {
+			st.lost++
+			continue
+		}
+		st.roundTrip = append(st.roundTrip, time.Since(begin))
+		st.bytes += uint64(len(buf))
+	}
+	return nil
+}
+
+// readEcho reads the datagrams until the echo of the payload, the late echoes of the
+// payloads lost before are skipped, it reports false if not echoed before the deadline.
+func readEcho(pc net.PacketConn, payload, rbuf []byte, deadline time.Time) (bool, error) {
+	pc.SetReadDeadline(deadline) // nolint: errcheck
+	for {
+		n, _, err := pc.ReadFrom(rbuf)
+		if err != nil {
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				return false, nil
+			}
+			return false, fmt.Errorf("read datagram, %v", err)
+		}
+		pk, err := statute.ParseDatagram(rbuf[:n])
+		if err != nil {
+			return false, fmt.Errorf("parse datagram, %v", err)
+		}
+		if len(payload) >= 4 && len(pk.Data) >= 4 && !bytes.Equal(pk.Data[:4], payload[:4]) {
+			continue
+		}
+		if !bytes.Equal(pk.Data, payload) {
+			return false, errors.New("datagram corrupted")
+		}
+		return true, nil
+	}
+}
+
+// request dials the server, negotiates and sends the request, the connection is returned
+// after the success reply with the bind address of the reply.
+func (sf *generator) request(cmd byte, dst statute.AddrSpec) (*proxyConn, error) {
+	conn, err := net.DialTimeout("tcp", sf.cfg.ProxyAddr, sf.cfg.Timeout)
+	if err != nil {
+		return nil, fmt.Errorf("dial proxy, %v", err)
+	}
+	conn.SetDeadline(time.Now().Add(sf.cfg.Timeout)) // nolint: errcheck
+	rep, err := sf.handshake(conn, cmd, dst)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{}) // nolint: errcheck
+	return &proxyConn{conn, rep.BndAddr}, nil
+}
+
+// handshake negotiates the method, authenticates and sends the request
+func (sf *generator) handshake(conn net.Conn, cmd byte, dst statute.AddrSpec) (statute.Reply, error) {
+	method := statute.MethodNoAuth
+	if sf.cfg.Username != "" {
+		method = statute.MethodUserPassAuth
+	}
+	if _, err := conn.Write(statute.NewMethodRequest(statute.VersionSocks5, []byte{method}).Bytes()); err != nil {
+		return statute.Reply{}, err
+	}
+	mr, err := statute.ParseMethodReply(conn)
+	if err != nil {
+		return statute.Reply{}, fmt.Errorf("read method reply, %v", err)
+	}
+	if mr.Method != method {
+		return statute.Reply{}, fmt.Errorf("method %#x selected, want %#x", mr.Method, method)
+	}
+	if method == statute.MethodUserPassAuth {
+		req := statute.NewUserPassRequest(statute.UserPassAuthVersion, []byte(sf.cfg.Username), []byte(sf.cfg.Password))
+		if _, err := conn.Write(req.Bytes()); err != nil {
+			return statute.Reply{}, err
+		}
+		ar, err := statute.ParseUserPassReply(conn)
+		if err != nil {
+			return statute.Reply{}, fmt.Errorf("read auth reply, %v", err)
+		}
+		if ar.Status != statute.AuthSuccess {
+			return statute.Reply{}, fmt.Errorf("authentication failed, status %#x", ar.Status)
+		}
+	}
+	req := statute.Request{Version: statute.VersionSocks5, Command: cmd, DstAddr: dst}
+	if _, err := conn.Write(req.Bytes()); err != nil {
+		return statute.Reply{}, err
+	}
+	rep, err := statute.ParseReply(conn)
+	if err != nil {
+		return rep, fmt.Errorf("read reply, %v", err)
+	}
+	if rep.Response != statute.RepSuccess {
+		return rep, fmt.Errorf("reply %d, want %d", rep.Response, statute.RepSuccess)
+	}
+	return rep, nil
+}
+
+// proxyConn is the connection to the server with the bind address replied
+type proxyConn struct {
+	net.Conn
+	bnd statute.AddrSpec
+}
+
+// relayAddr returns the udp relay address replied by the server, the unspecified ip is
+// replaced by the ip of the server.
+func (sf *generator) relayAddr(conn *proxyConn) string {
+	if conn.bnd.IP == nil || conn.bnd.IP.IsUnspecified() {
+		host, _, _ := net.SplitHostPort(sf.cfg.ProxyAddr)
+		return net.JoinHostPort(host, strconv.Itoa(conn.bnd.Port))
+	}
+	return conn.bnd.String()
+}
+
+// addrSpec returns the AddrSpec of the tcp address
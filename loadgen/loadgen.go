@@ -0,0 +1,294 @@
+// Package loadgen drives the concurrent CONNECT or ASSOCIATE sessions against a SOCKS5 server
+// with the configurable payload patterns, and reports the throughput and the latency, so the
+// performance regressions of the relay and the udp paths are measurable by the embedders.
+// The generator serves the tcp and udp echo targets itself, each payload is echoed back
+// through the server and verified.
+package loadgen
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/thinkgos/go-socks5"
+)
+
+// defaults of the config
+const (
+	defaultHost        = "127.0.0.1"
+	defaultConcurrency = 8
+	defaultSize        = 1024
+	defaultCount       = 16
+	defaultTimeout     = 5 * time.Second
+)
+
+// maxDatagramPayload the max payload of a udp datagram with the IPv4 socks5 udp header
+const maxDatagramPayload = 65507 - 10
+
+// Mode is the command of the sessions
+type Mode uint8
+
+// mode defined
+const (
+	Connect Mode = iota
+	Associate
+)
+
+// String implement interface fmt.Stringer
+func (m Mode) String() string {
+	switch m {
+	case Connect:
+		return "CONNECT"
+	case Associate:
+		return "ASSOCIATE"
+	default:
+		return "Mode(" + strconv.Itoa(int(m)) + ")"
+	}
+}
+
+// Fill is the pattern of the payload
+type Fill uint8
+
+// fill defined
+const (
+	// FillZero all the bytes are zero
+	FillZero Fill = iota
+	// FillRandom the pseudo random bytes, differ per payload
+	FillRandom
+	// FillSequence the incrementing bytes starting at the index of the payload
+	FillSequence
+)
+
+// String implement interface fmt.Stringer
+func (f Fill) String() string {
+	switch f {
+	case FillZero:
+		return "zero"
+	case FillRandom:
+		return "random"
+	case FillSequence:
+		return "sequence"
+	default:
+		return "Fill(" + strconv.Itoa(int(f)) + ")"
+	}
+}
+
+// Payload is the payload pattern of a session
+type Payload struct {
+	// Size of each payload in bytes, defaults to 1024, the payload of ASSOCIATE
+	// must fit in a udp datagram.
+	Size int
+	// Count of the payloads echoed per session, defaults to 16
+	Count int
+	// Fill pattern of the payload
+	Fill Fill
+	// Interval between the payloads of a session, zero sends back to back
+	Interval time.Duration
+}
+
+// Config is the config of the generator
+type Config struct {
+	// ProxyAddr is the address of the server under test
+	ProxyAddr string
+	// Username and Password authenticate by RFC 1929, no authentication if Username is empty.
+	Username string
+	Password string
+	// Mode is the command of the sessions
+	Mode Mode
+	// Concurrency is the number of the sessions run at the same time, defaults to 8
+	Concurrency int
+	// Sessions is the total number of the sessions, defaults to Concurrency
+	Sessions int
+	// Duration keeps starting the sessions until elapsed for a soak, Sessions is ignored if set.
+	Duration time.Duration
+	// Payload of each session
+	Payload Payload
+	// Host is the ip of the generator reachable from the server, the echo targets are
+	// served on it, defaults to 127.0.0.1.
+	Host string
+	// Timeout of each handshake and each payload echoed, defaults to 5 seconds,
+	// the datagram not echoed in time is counted lost.
+	Timeout time.Duration
+}
+
+// Latency is the distribution of the samples
+type Latency struct {
+	Count int
+	Min   time.Duration
+	Mean  time.Duration
+	P50   time.Duration
+	P90   time.Duration
+	P99   time.Duration
+	Max   time.Duration
+}
+
+// newLatency returns the distribution of the samples, the samples are sorted in place
+func newLatency(samples []time.Duration) Latency {
+	if len(samples) == 0 {
+		return Latency{}
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+	var sum time.Duration
+	for _, s := range samples {
+		sum += s
+	}
+	percentile := func(p int) time.Duration {
+		return samples[(len(samples)-1)*p/100]
+	}
+	return Latency{
+		Count: len(samples),
+		Min:   samples[0],
+		Mean:  sum / time.Duration(len(samples)),
+		P50:   percentile(50),
+		P90:   percentile(90),
+		P99:   percentile(99),
+		Max:   samples[len(samples)-1],
+	}
+}
+
+// String implement interface fmt.Stringer
+func (sf Latency) String() string {
+	return fmt.Sprintf("min %v mean %v p50 %v p90 %v p99 %v max %v",
+		sf.Min, sf.Mean, sf.P50, sf.P90, sf.P99, sf.Max)
+}
+
+// Report is the result of the run
+type Report struct {
+	Mode Mode
+	// Sessions is the number of the sessions completed, the failed included
+	Sessions int
+	// Failures is the number of the sessions failed
+	Failures int
+	// FirstError is the error of the first session failed
+	FirstError error
+	// Bytes is the payload bytes echoed back
+	Bytes uint64
+	// Datagrams sent and Lost not echoed in time, ASSOCIATE only
+	Datagrams int
+	Lost      int
+	// Duration of the run
+	Duration time.Duration
+	// Connect is the latency from the dial to the success reply
+	Connect Latency
+	// RoundTrip is the latency of echoing a payload
+	RoundTrip Latency
+}
+
+// Throughput returns the payload bytes echoed back per second
+func (sf *Report) Throughput() float64 {
+	if sf.Duration <= 0 {
+		return 0
+	}
+	return float64(sf.Bytes) / sf.Duration.Seconds()
+}
+
+// String implement interface fmt.Stringer
+func (sf *Report) String() string {
+	b := new(strings.Builder)
+	fmt.Fprintf(b, "%s sessions %d failures %d duration %v\n", sf.Mode, sf.Sessions, sf.Failures, sf.Duration)
+	fmt.Fprintf(b, "throughput %.2f MB/s, %d bytes echoed\n", sf.Throughput()/(1<<20), sf.Bytes)
+	fmt.Fprintf(b, "connect    %v\n", sf.Connect)
+	fmt.Fprintf(b, "round trip %v\n", sf.RoundTrip)
+	if sf.Mode == Associate {
+		fmt.Fprintf(b, "datagrams %d lost %d\n", sf.Datagrams, sf.Lost)
+	}
+	if sf.FirstError != nil {
+		fmt.Fprintf(b, "first error: %v\n", sf.FirstError)
+	}
+	return b.String()
+}
+
+// Run runs the sessions against the server, and reports when all the sessions end.
+// The sessions not started yet are abandoned once the ctx is done, the report covers
+// the sessions completed.
+func Run(ctx context.Context, cfg Config) (*Report, error) {
+	if cfg.ProxyAddr == "" {
+		return nil, errors.New("loadgen: proxy address required")
+	}
+	if cfg.Concurrency <= 0 {
+		cfg.Concurrency = defaultConcurrency
+	}
+	if cfg.Sessions <= 0 {
+		cfg.Sessions = cfg.Concurrency
+	}
+	if cfg.Payload.Size <= 0 {
+		cfg.Payload.Size = defaultSize
+	}
+	if cfg.Payload.Count <= 0 {
+		cfg.Payload.Count = defaultCount
+	}
+	if cfg.Host == "" {
+		cfg.Host = defaultHost
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaultTimeout
+	}
+	if cfg.Mode == Associate && cfg.Payload.Size > maxDatagramPayload {
+		return nil, fmt.Errorf("loadgen: payload size %d exceeds the datagram, max %d",
+			cfg.Payload.Size, maxDatagramPayload)
+	}
+	if cfg.Mode != Connect && cfg.Mode != Associate {
+		return nil, fmt.Errorf("loadgen: unsupported mode %s", cfg.Mode)
+	}
+
+	g, err := newGenerator(cfg)
+	if err != nil {
+		return nil, err
+	}
+	defer g.close()
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	results := make([]*stats, cfg.Concurrency)
+	for i := range results {
+		results[i] = &stats{}
+		wg.Add(1)
+		go func(st *stats, seed int64) {
+			defer wg.Done()
+			g.work(ctx, st, start, seed)
+		}(results[i], start.UnixNano()+int64(i))
+	}
+	wg.Wait()
+
+	report := &Report{Mode: cfg.Mode, Duration: time.Since(start)}
+	var connect, roundTrip []time.Duration
+	var firstErrorAt time.Time
+	for _, st := range results {
+		report.Sessions += st.sessions
+		report.Failures += st.failures
+		report.Bytes += st.bytes
+		report.Datagrams += st.datagrams
+		report.Lost += st.lost
+		if st.firstError != nil && (report.FirstError == nil || st.firstErrorAt.Before(firstErrorAt)) {
+			report.FirstError, firstErrorAt = st.firstError, st.firstErrorAt
+		}
+		connect = append(connect, st.connect...)
+		roundTrip = append(roundTrip, st.roundTrip...)
+	}
+	report.Connect = newLatency(connect)
+	report.RoundTrip = newLatency(roundTrip)
+	return report, nil
+}
+
+// RunServer serves the server on a loopback listener of the Host, and runs the sessions
+// against it, the listener is closed once the run ends.
+func RunServer(ctx context.Context, srv *socks5.Server, cfg Config) (*Report, error) {
+	host := cfg.Host
+	if host == "" {
+		host = defaultHost
+	}
+	l, err := net.Listen("tcp", net.JoinHostPort(host, "0"))
+	if err != nil {
+		return nil, fmt.Errorf("loadgen: listen server, %v", err)
+	}
+	defer l.Close()
+	go srv.Serve(l) // nolint: errcheck
+	cfg.ProxyAddr = l.Addr().String()
+	return Run(ctx, cfg)
+}
@@ -0,0 +1,106 @@
+package loadgen
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/thinkgos/go-socks5"
+)
+
+func TestRunConnect(t *testing.T) {
+	for _, fill := range []Fill{FillZero, FillRandom, FillSequence} {
+		report, err := RunServer(context.Background(), socks5.NewServer(), Config{
+			Concurrency: 4,
+			Sessions:    10,
+			Payload:     Payload{Size: 4096, Count: 8, Fill: fill},
+		})
+		require.NoError(t, err)
+		require.Zero(t, report.Failures, report.String())
+		require.Equal(t, 10, report.Sessions)
+		require.Equal(t, uint64(10*8*4096), report.Bytes)
+		require.Equal(t, 10, report.Connect.Count)
+		require.Equal(t, 80, report.RoundTrip.Count)
+		require.True(t, report.RoundTrip.Min <= report.RoundTrip.P50 && report.RoundTrip.P50 <= report.RoundTrip.Max)
+		require.True(t, report.Throughput() > 0)
+	}
+}
+
+func TestRunAssociate(t *testing.T) {
+	srv := socks5.NewServer(socks5.WithCredential(socks5.StaticCredentials{"user": "pass"}))
+	report, err := RunServer(context.Background(), srv, Config{
+		Username:    "user",
+		Password:    "pass",
+		Mode:        Associate,
+		Concurrency: 3,
+		Sessions:    6,
+		Payload:     Payload{Size: 512, Count: 5, Fill: FillSequence, Interval: time.Millisecond},
+	})
+	require.NoError(t, err)
+	require.Zero(t, report.Failures, report.String())
+	require.Equal(t, 6, report.Sessions)
+	require.Equal(t, 30, report.Datagrams)
+	require.Equal(t, uint64(30-report.Lost)*512, report.Bytes)
+	require.Contains(t, report.String(), "ASSOCIATE sessions 6 failures 0")
+}
+
+func TestRunDuration(t *testing.T) {
+	report, err := RunServer(context.Background(), socks5.NewServer(), Config{
+		Concurrency: 2,
+		Duration:    200 * time.Millisecond,
+		Payload:     Payload{Size: 64, Count: 2, Interval: 10 * time.Millisecond},
+	})
+	require.NoError(t, err)
+	require.Zero(t, report.Failures, report.String())
+	require.True(t, report.Sessions > 2)
+	require.True(t, report.Duration >= 200*time.Millisecond)
+}
+
+func TestRunFailures(t *testing.T) {
+	// the wrong password fails every session
+	srv := socks5.NewServer(socks5.WithCredential(socks5.StaticCredentials{"user": "pass"}))
+	report, err := RunServer(context.Background(), srv, Config{Username: "user", Password: "bad", Sessions: 3})
+	require.NoError(t, err)
+	require.Equal(t, 3, report.Failures)
+	require.EqualError(t, report.FirstError, "authentication failed, status 0x1")
+
+	_, err = Run(context.Background(), Config{})
+	require.Error(t, err)
+	_, err = Run(context.Background(), Config{
+		ProxyAddr: "127.0.0.1:1",
+		Mode:      Associate,
+		Payload:   Payload{Size: 1 << 16},
+	})
+	require.Error(t, err)
+
+	// the sessions not started are abandoned once canceled
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	report, err = Run(ctx, Config{ProxyAddr: l.Addr().String(), Sessions: 100})
+	require.NoError(t, err)
+	require.Zero(t, report.Sessions)
+}
+
+func TestNewLatency(t *testing.T) {
+	var samples []time.Duration
+	for i := 100; i > 0; i-- {
+		samples = append(samples, time.Duration(i)*time.Millisecond)
+	}
+	l := newLatency(samples)
+	require.Equal(t, Latency{
+		Count: 100,
+		Min:   time.Millisecond,
+		Mean:  50500 * time.Microsecond,
+		P50:   50 * time.Millisecond,
+		P90:   90 * time.Millisecond,
+		P99:   99 * time.Millisecond,
+		Max:   100 * time.Millisecond,
+	}, l)
+	require.Equal(t, Latency{}, newLatency(nil))
+}
@@ -1,7 +1,9 @@
 package socks5
 
 import (
+	"fmt"
 	"log"
+	"strings"
 )
 
 // Logger is used to provide debug logger
@@ -9,6 +11,43 @@ type Logger interface {
 	Errorf(format string, arg ...interface{})
 }
 
+// StructuredLogger is the leveled and structured logger, the keysAndValues are the
+// alternating keys and values of the fields, such as "session", 1, "up", 512.
+// *slog.Logger implements it, NewZapLogger adapts the zap SugaredLogger,
+// and LoggerFunc with Fields adapts the logrus.
+type StructuredLogger interface {
+	Debug(msg string, keysAndValues ...interface{})
+	Info(msg string, keysAndValues ...interface{})
+	Warn(msg string, keysAndValues ...interface{})
+	Error(msg string, keysAndValues ...interface{})
+}
+
+// Level is the level of the structured log
+type Level uint8
+
+// level defined
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+// String implement interface fmt.Stringer
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "debug"
+	case LevelInfo:
+		return "info"
+	case LevelWarn:
+		return "warn"
+	case LevelError:
+		return "error"
+	}
+	return "unknown"
+}
+
 // Std std logger
 type Std struct {
 	*log.Logger
@@ -23,3 +62,164 @@ func NewLogger(l *log.Logger) *Std {
 func (sf Std) Errorf(format string, args ...interface{}) {
 	sf.Logger.Printf("[E]: "+format, args...)
 }
+
+// Debug implement interface StructuredLogger
+func (sf Std) Debug(msg string, keysAndValues ...interface{}) { sf.output("[D]: ", msg, keysAndValues) }
+
+// Info implement interface StructuredLogger
+func (sf Std) Info(msg string, keysAndValues ...interface{}) { sf.output("[I]: ", msg, keysAndValues) }
+
+// Warn implement interface StructuredLogger
+func (sf Std) Warn(msg string, keysAndValues ...interface{}) { sf.output("[W]: ", msg, keysAndValues) }
+
+// Error implement interface StructuredLogger
+func (sf Std) Error(msg string, keysAndValues ...interface{}) { sf.output("[E]: ", msg, keysAndValues) }
+
+// output writes the message with the fields as key=value
+func (sf Std) output(prefix, msg string, keysAndValues []interface{}) {
+	b := new(strings.Builder)
+	b.WriteString(prefix + msg)
+	for i := 0; i < len(keysAndValues); i += 2 {
+		if i+1 < len(keysAndValues) {
+			fmt.Fprintf(b, " %v=%v", keysAndValues[i], keysAndValues[i+1])
+		} else {
+			fmt.Fprintf(b, " !BADKEY=%v", keysAndValues[i])
+		}
+	}
+	sf.Logger.Print(b.String())
+}
+
+// LoggerFunc is the function to log at the level, implement interface StructuredLogger,
+// such as adapting the logrus:
+//
+//	socks5.LoggerFunc(func(level socks5.Level, msg string, keysAndValues ...interface{}) {
+//		logrus.WithFields(socks5.Fields(keysAndValues...)).Log(logrus.Level(5-level), msg)
+//	})
+type LoggerFunc func(level Level, msg string, keysAndValues ...interface{})
+
+// Debug implement interface StructuredLogger
+func (f LoggerFunc) Debug(msg string, keysAndValues ...interface{}) {
+	f(LevelDebug, msg, keysAndValues...)
+}
+
+// Info implement interface StructuredLogger
+func (f LoggerFunc) Info(msg string, keysAndValues ...interface{}) {
+	f(LevelInfo, msg, keysAndValues...)
+}
+
+// Warn implement interface StructuredLogger
+func (f LoggerFunc) Warn(msg string, keysAndValues ...interface{}) {
+	f(LevelWarn, msg, keysAndValues...)
+}
+
+// Error implement interface StructuredLogger
+func (f LoggerFunc) Error(msg string, keysAndValues ...interface{}) {
+	f(LevelError, msg, keysAndValues...)
+}
+
+// Fields returns the map of the alternating keys and values, which is assignable
+// to logrus.Fields, the key without value is kept as "!BADKEY".
+func Fields(keysAndValues ...interface{}) map[string]interface{} {
+	m := make(map[string]interface{}, (len(keysAndValues)+1)/2)
+	for i := 0; i < len(keysAndValues); i += 2 {
+		if i+1 < len(keysAndValues) {
+			m[fmt.Sprint(keysAndValues[i])] = keysAndValues[i+1]
+		} else {
+			m["!BADKEY"] = keysAndValues[i]
+		}
+	}
+	return m
+}
+
+// ZapSugaredLogger is the methods of the zap SugaredLogger the adapter uses
+type ZapSugaredLogger interface {
+	Debugw(msg string, keysAndValues ...interface{})
+	Infow(msg string, keysAndValues ...interface{})
+	Warnw(msg string, keysAndValues ...interface{})
+	Errorw(msg string, keysAndValues ...interface{})
+}
+
+// NewZapLogger adapts the zap SugaredLogger to the StructuredLogger, such as
+// NewZapLogger(zapLogger.Sugar()).
+func NewZapLogger(l ZapSugaredLogger) StructuredLogger {
+	return zapLogger{l}
+}
+
+// zapLogger is the adapter of the zap SugaredLogger
+type zapLogger struct {
+	l ZapSugaredLogger
+}
+
+// Debug implement interface StructuredLogger
+func (sf zapLogger) Debug(msg string, keysAndValues ...interface{}) {
+	sf.l.Debugw(msg, keysAndValues...)
+}
+
+// Info implement interface StructuredLogger
+func (sf zapLogger) Info(msg string, keysAndValues ...interface{}) {
+	sf.l.Infow(msg, keysAndValues...)
+}
+
+// Warn implement interface StructuredLogger
+func (sf zapLogger) Warn(msg string, keysAndValues ...interface{}) {
+	sf.l.Warnw(msg, keysAndValues...)
+}
+
+// Error implement interface StructuredLogger
+func (sf zapLogger) Error(msg string, keysAndValues ...interface{}) {
+	sf.l.Errorw(msg, keysAndValues...)
+}
+
+// errorfLogger writes the messages of the Logger as the errors of the StructuredLogger
+type errorfLogger struct {
+	l StructuredLogger
+}
+
+// Errorf implement interface Logger
+func (sf errorfLogger) Errorf(format string, args ...interface{}) {
+	sf.l.Error(fmt.Sprintf(format, args...))
+}
+
+// logSessionStart writes the debug log of the session accepted
+func (sf *Server) logSessionStart(sess *session) {
+	if sf.structured != nil {
+		sf.structured.Debug("session accepted", "session", sess.id, "client", sess.clientAddr, "local", sess.localAddr)
+	}
+}
+
+// logSessionEnd writes the log of the session end with the request and the bytes relayed,
+// the session closed by an error is logged as a warning.
+func (sf *Server) logSessionEnd(sess *session, err error) {
+	if sf.structured == nil {
+		return
+	}
+	s := sess.snapshot()
+	kv := []interface{}{
+		"session", s.ID,
+		"client", s.ClientAddr,
+		"user", s.User,
+		"command", s.Command,
+		"dest", s.DestAddr,
+		"up", s.BytesUp,
+		"down", s.BytesDown,
+		"duration", sf.since(s.Started),
+		"reason", s.CloseReason,
+	}
+	if s.Dial != nil {
+		kv = append(kv, "egress", s.Dial.LocalAddr, "remote", s.Dial.RemoteAddr)
+	}
+	if s.Tenant != "" {
+		kv = append(kv, "tenant", s.Tenant)
+	}
+	if s.ForwardedBy != nil {
+		kv = append(kv, "forwarded_by", s.ForwardedBy)
+	}
+	if s.Rule != "" || s.RuleReason != "" {
+		kv = append(kv, "rule", s.Rule, "rule_reason", s.RuleReason)
+	}
+	if s.CloseReason == CloseReasonError {
+		sf.structured.Warn("session closed", append(kv, "error", err)...)
+		return
+	}
+	sf.structured.Info("session closed", kv...)
+}
@@ -0,0 +1,23 @@
+//go:build go1.21
+// +build go1.21
+
+package socks5
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSlogLogger(t *testing.T) {
+	buf := new(bytes.Buffer)
+	var l StructuredLogger = slog.New(slog.NewJSONHandler(buf, nil))
+	l.Info("session closed", "session", 1, "up", 512)
+	var m map[string]interface{}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &m))
+	require.Equal(t, "session closed", m["msg"])
+	require.Equal(t, float64(512), m["up"])
+}
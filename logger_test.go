@@ -0,0 +1,93 @@
+package socks5
+
+import (
+	"bytes"
+	"log"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type logEntry struct {
+	level  Level
+	msg    string
+	fields map[string]interface{}
+}
+
+// recordLogger records the structured logs
+type recordLogger struct {
+	mu      sync.Mutex
+	entries []logEntry
+}
+
+func (sf *recordLogger) log(level Level, msg string, keysAndValues ...interface{}) {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	sf.entries = append(sf.entries, logEntry{level, msg, Fields(keysAndValues...)})
+}
+
+func (sf *recordLogger) find(msg string) (logEntry, bool) {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	for _, e := range sf.entries {
+		if e.msg == msg {
+			return e, true
+		}
+	}
+	return logEntry{}, false
+}
+
+func TestServer_StructuredLogger(t *testing.T) {
+	target := echoTarget(t)
+	rec := &recordLogger{}
+	proxy := serveSocks(t, WithStructuredLogger(LoggerFunc(rec.log)))
+	conn := relaySession(t, proxy, target)
+	conn.Close()
+
+	var closed logEntry
+	require.Eventually(t, func() bool {
+		var ok bool
+		closed, ok = rec.find("session closed")
+		return ok
+	}, time.Second, 10*time.Millisecond)
+	require.Equal(t, LevelInfo, closed.level)
+	require.Equal(t, uint64(4), closed.fields["up"])
+	require.Equal(t, uint64(4), closed.fields["down"])
+	require.Equal(t, target.String(), closed.fields["dest"])
+	require.Equal(t, CloseReasonClientEOF, closed.fields["reason"])
+
+	accepted, ok := rec.find("session accepted")
+	require.True(t, ok)
+	require.Equal(t, LevelDebug, accepted.level)
+	require.Equal(t, closed.fields["session"], accepted.fields["session"])
+}
+
+func TestStd_Structured(t *testing.T) {
+	buf := new(bytes.Buffer)
+	l := NewLogger(log.New(buf, "", 0))
+	l.Info("session closed", "session", 1, "up", 512, "odd")
+	l.Errorf("accept error: %v", "EOF")
+	require.Equal(t, "[I]: session closed session=1 up=512 !BADKEY=odd\n[E]: accept error: EOF\n", buf.String())
+}
+
+type zapSugared struct {
+	recordLogger
+}
+
+func (sf *zapSugared) Debugw(msg string, kv ...interface{}) { sf.log(LevelDebug, msg, kv...) }
+func (sf *zapSugared) Infow(msg string, kv ...interface{})  { sf.log(LevelInfo, msg, kv...) }
+func (sf *zapSugared) Warnw(msg string, kv ...interface{})  { sf.log(LevelWarn, msg, kv...) }
+func (sf *zapSugared) Errorw(msg string, kv ...interface{}) { sf.log(LevelError, msg, kv...) }
+
+func TestNewZapLogger(t *testing.T) {
+	z := &zapSugared{}
+	l := NewZapLogger(z)
+	l.Warn("slow client", "session", 2)
+	errorfLogger{l}.Errorf("server: %v", "closed")
+	require.Equal(t, []logEntry{
+		{LevelWarn, "slow client", map[string]interface{}{"session": 2}},
+		{LevelError, "server: closed", map[string]interface{}{}},
+	}, z.entries)
+}
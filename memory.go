@@ -0,0 +1,118 @@
+package socks5
+
+import (
+	"fmt"
+	"io"
+	"sync/atomic"
+
+	"github.com/thinkgos/go-socks5/bufferpool"
+	"github.com/thinkgos/go-socks5/statute"
+)
+
+// relayBufferFloor is the relay buffer size of each direction reserved when the session is admitted,
+// the relay degrades to it when the memory limit does not allow a buffer from the buffer pool.
+const relayBufferFloor = 4 * 1024
+
+// memoryBudget bounds the bytes of the internal buffers, a session budget charges the global
+// budget as its parent. A nil memoryBudget has no limit.
+type memoryBudget struct {
+	limit  int64
+	used   int64 // updated atomically
+	parent *memoryBudget
+}
+
+func newMemoryBudget(limit int64, parent *memoryBudget) *memoryBudget {
+	if limit <= 0 && parent == nil {
+		return nil
+	}
+	return &memoryBudget{limit: limit, parent: parent}
+}
+
+// acquire n bytes from the budget and its parent, false if any limit exceeded.
+func (sf *memoryBudget) acquire(n int64) bool {
+	if sf == nil || n <= 0 {
+		return true
+	}
+	if used := atomic.AddInt64(&sf.used, n); sf.limit > 0 && used > sf.limit {
+		atomic.AddInt64(&sf.used, -n)
+		return false
+	}
+	if !sf.parent.acquire(n) {
+		atomic.AddInt64(&sf.used, -n)
+		return false
+	}
+	return true
+}
+
+// release n bytes to the budget and its parent.
+func (sf *memoryBudget) release(n int64) {
+	if sf == nil || n <= 0 {
+		return
+	}
+	atomic.AddInt64(&sf.used, -n)
+	sf.parent.release(n)
+}
+
+// close release all the bytes still used by the session budget to its parent.
+func (sf *memoryBudget) close() {
+	if sf == nil {
+		return
+	}
+	sf.parent.release(atomic.SwapInt64(&sf.used, 0))
+}
+
+// admitMemory reserves the buffered bytes and the relay buffer floor of the session,
+// replies the failure if the session or global memory limit exceeded.
+func (sf *Server) admitMemory(writer io.Writer, request *Request, buffered int) error {
+	if request.mem.acquire(int64(buffered) + 2*relayBufferFloor) {
+		return nil
+	}
+	request.sess.setCloseReason(CloseReasonQuotaExceeded)
+	sf.incError(PhaseRequest, statute.RepServerFailure)
+	if err := sf.sendFailure(writer, request.RemoteAddr, statute.RepServerFailure,
+		statute.DetailMemoryLimit); err != nil {
+		return fmt.Errorf("failed to send reply, %v", err)
+	}
+	return fmt.Errorf("memory limit exceeded, %d bytes buffered", buffered)
+}
+
+// proxy is the same as Proxy, but the buffer from the buffer pool is charged to the memory budget,
+// degrades to a buffer of relayBufferFloor, which is reserved when admitted, if the limit exceeded.
+// It returns the bytes copied.
+func (sf *Server) proxy(mem *memoryBudget, dst io.Writer, src io.Reader) (int64, error) {
+	b, release := sf.relayBuffer(mem)
+	defer release()
+	n, err := io.CopyBuffer(dst, src, b)
+	if tcpConn, ok := dst.(closeWriter); ok {
+		tcpConn.CloseWrite() // nolint: errcheck
+	}
+	return n, err
+}
+
+// relayBuffer returns a buffer from the buffer pool if the memory budget allows its bytes over
+// relayBufferFloor, otherwise a buffer of relayBufferFloor, release gives it back. The buffer of
+// the pool is only taken once admitted if the pool reports its size by bufferpool.Sizer.
+func (sf *Server) relayBuffer(mem *memoryBudget) ([]byte, func()) {
+	var buf []byte
+	size := relayBufferFloor
+	if s, ok := sf.bufferPool.(bufferpool.Sizer); ok {
+		size = s.Size()
+	} else {
+		buf = sf.bufferPool.Get()
+		size = cap(buf)
+	}
+	extra := int64(size - relayBufferFloor)
+	if !mem.acquire(extra) {
+		if buf != nil {
+			sf.bufferPool.Put(buf)
+		}
+		return make([]byte, relayBufferFloor), func() {}
+	}
+	if buf == nil {
+		buf = sf.bufferPool.Get()
+	}
+	return buf[:cap(buf)], func() {
+		sf.bufferPool.Put(buf)
+		mem.release(extra)
+	}
+}
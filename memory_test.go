@@ -0,0 +1,134 @@
+package socks5
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/thinkgos/go-socks5/bufferpool"
+	"github.com/thinkgos/go-socks5/statute"
+)
+
+func TestMemoryBudget(t *testing.T) {
+	var none *memoryBudget
+	require.True(t, none.acquire(1<<30))
+	none.release(1 << 30)
+	none.close()
+	require.Nil(t, newMemoryBudget(0, nil))
+
+	global := newMemoryBudget(100, nil)
+	a := newMemoryBudget(60, global)
+	b := newMemoryBudget(0, global)
+	require.True(t, a.acquire(60))
+	require.False(t, a.acquire(1))
+	require.True(t, b.acquire(40))
+	require.False(t, b.acquire(1))
+	require.Equal(t, int64(100), global.used)
+
+	a.release(10)
+	require.True(t, b.acquire(10))
+	require.False(t, a.acquire(10))
+
+	a.close()
+	require.Equal(t, int64(50), global.used)
+	b.close()
+	require.Equal(t, int64(0), global.used)
+}
+
+func TestServer_Proxy_MemoryDegrade(t *testing.T) {
+	srv := &Server{bufferPool: bufferpool.NewPool(32 * 1024)}
+	data := bytes.Repeat([]byte("a"), 100*1024)
+
+	mem := newMemoryBudget(2*relayBufferFloor, nil)
+	require.True(t, mem.acquire(2*relayBufferFloor))
+	out := new(bytes.Buffer)
+	n, err := srv.proxy(mem, out, bytes.NewReader(data))
+	require.NoError(t, err)
+	require.Equal(t, int64(len(data)), n)
+	require.Equal(t, data, out.Bytes())
+	require.Equal(t, int64(2*relayBufferFloor), mem.used)
+
+	mem = newMemoryBudget(64*1024, nil)
+	out.Reset()
+	_, err = srv.proxy(mem, out, bytes.NewReader(data))
+	require.NoError(t, err)
+	require.Equal(t, data, out.Bytes())
+	require.Equal(t, int64(0), mem.used)
+}
+
+// countPool counts the buffers taken from the pool
+type countPool struct {
+	bufferpool.BufPool
+	size int
+	gets int
+}
+
+func (sf *countPool) Get() []byte { sf.gets++; return sf.BufPool.Get() }
+func (sf *countPool) Size() int   { return sf.size }
+
+func TestServer_Proxy_MemoryExhausted(t *testing.T) {
+	pool := &countPool{BufPool: bufferpool.NewPool(32 * 1024), size: 32 * 1024}
+	srv := &Server{bufferPool: pool}
+	data := bytes.Repeat([]byte("a"), 100*1024)
+
+	mem := newMemoryBudget(2*relayBufferFloor, nil)
+	require.True(t, mem.acquire(2*relayBufferFloor))
+	out := new(bytes.Buffer)
+	_, err := srv.proxy(mem, out, bytes.NewReader(data))
+	require.NoError(t, err)
+	require.Equal(t, data, out.Bytes())
+	require.Zero(t, pool.gets)
+
+	_, err = srv.proxy(nil, out, bytes.NewReader(data))
+	require.NoError(t, err)
+	require.Equal(t, 1, pool.gets)
+}
+
+func TestSOCKS5_MemoryLimit(t *testing.T) {
+	target := echoTarget(t)
+	proxy := serveSocks(t, WithMemoryLimit(0, 3*relayBufferFloor))
+
+	connect := func() (net.Conn, uint8) {
+		conn, err := net.Dial("tcp", proxy.String())
+		require.NoError(t, err)
+		t.Cleanup(func() { conn.Close() })
+		conn.SetDeadline(time.Now().Add(time.Second)) // nolint: errcheck
+		req := bytes.NewBuffer([]byte{statute.VersionSocks5, 1, statute.MethodNoAuth})
+		req.Write(statute.Request{
+			Version: statute.VersionSocks5,
+			Command: statute.CommandConnect,
+			DstAddr: statute.AddrSpec{AddrType: statute.ATYPIPv4, IP: target.IP, Port: target.Port},
+		}.Bytes())
+		_, err = conn.Write(req.Bytes())
+		require.NoError(t, err)
+		_, err = statute.ParseMethodReply(conn)
+		require.NoError(t, err)
+		rep, err := statute.ParseReply(conn)
+		require.NoError(t, err)
+		return conn, rep.Response
+	}
+
+	// the first session relays with the degraded buffers
+	conn, rep := connect()
+	require.Equal(t, statute.RepSuccess, rep)
+	_, err := conn.Write([]byte("ping"))
+	require.NoError(t, err)
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(conn, buf)
+	require.NoError(t, err)
+	require.Equal(t, []byte("ping"), buf)
+
+	// the second session exceeds the global limit
+	_, rep = connect()
+	require.Equal(t, statute.RepServerFailure, rep)
+
+	// the memory is released after the first session closed
+	conn.Close()
+	time.Sleep(50 * time.Millisecond)
+	_, rep = connect()
+	require.Equal(t, statute.RepSuccess, rep)
+}
@@ -0,0 +1,167 @@
+package socks5
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Phase is the processing phase of a connection
+type Phase uint8
+
+// phase defined
+const (
+	PhaseNegotiation Phase = iota
+	PhaseAuth
+	PhaseRule
+	PhaseResolve
+	PhaseDial
+	PhaseRelay
+	PhaseRequest
+)
+
+// String implement interface fmt.Stringer
+func (p Phase) String() string {
+	switch p {
+	case PhaseNegotiation:
+		return "negotiation"
+	case PhaseAuth:
+		return "auth"
+	case PhaseRule:
+		return "rule"
+	case PhaseResolve:
+		return "resolve"
+	case PhaseDial:
+		return "dial"
+	case PhaseRelay:
+		return "relay"
+	case PhaseRequest:
+		return "request"
+	}
+	return "unknown"
+}
+
+// NoReply means no SOCKS reply was returned to the client for the failure,
+// such as a failure during method negotiation, authentication or the relay.
+const NoReply = uint8(0xff)
+
+// Metrics is used to export server metrics, the other observations are reported only if the
+// Metrics implements the optional interfaces, such as DurationMetrics and TrafficMetrics.
+type Metrics interface {
+	// IncError counts a failure in the phase,
+	// rep is the SOCKS reply code returned to the client or NoReply.
+	IncError(phase Phase, rep uint8)
+}
+
+// DurationMetrics is implemented by the Metrics which observe the phase durations
+type DurationMetrics interface {
+	// ObserveDuration records the time spent in the phase for the command,
+	// only negotiation, auth, request, resolve and dial phases are observed.
+	ObserveDuration(phase Phase, cmd byte, d time.Duration)
+}
+
+// NATMetrics is implemented by the Metrics which count the NAT table evictions
+type NATMetrics interface {
+	// IncNATEviction counts an udp flow evicted from the NAT table
+	// because of the per association or global limit.
+	IncNATEviction()
+}
+
+// RequestHeaderMetrics is implemented by the Metrics which observe the request header delivery
+type RequestHeaderMetrics interface {
+	// ObserveRequestHeader records the size of the request header and the number of
+	// reads from the connection it took to deliver, a client trickling bytes takes many reads.
+	ObserveRequestHeader(size, reads int)
+}
+
+// UDPMetrics is implemented by the Metrics which count the oversize and fragmented datagrams
+type UDPMetrics interface {
+	// IncUDPOversize counts a relayed datagram exceeding the max size,
+	// handled with the policy.
+	IncUDPOversize(policy UDPOversizePolicy)
+	// AddDroppedFragments counts the fragments of the datagrams from the client dropped,
+	// because the fragmentation is not enabled, or the datagram could not be reassembled.
+	AddDroppedFragments(n int)
+}
+
+// ClientNoiseMetrics is implemented by the Metrics which count the client noise
+type ClientNoiseMetrics interface {
+	// IncClientNoise counts the client which aborted, reset or closed the connection
+	// during accept or negotiation in the phase, such as scanners, not counted as errors.
+	IncClientNoise(phase Phase)
+}
+
+// SessionMetrics is implemented by the Metrics which observe the connections and sessions
+type SessionMetrics interface {
+	// AddActiveConns adjusts the number of connections being served by delta.
+	AddActiveConns(delta int)
+	// IncAuthFailure counts a failed authentication with the method.
+	IncAuthFailure(method uint8)
+	// IncSessionClose counts the session end with the close reason.
+	IncSessionClose(reason CloseReason)
+	// ObserveSessionDuration records the lifetime of a session of the command once it ends,
+	// cmd is 0 if the session ended before the request.
+	ObserveSessionDuration(cmd byte, d time.Duration)
+}
+
+// TrafficMetrics is implemented by the Metrics which count the traffic relayed
+type TrafficMetrics interface {
+	// AddRelayedBytes counts the n bytes relayed by a session of the command as they are relayed,
+	// upstream is from client to target.
+	AddRelayedBytes(cmd byte, upstream bool, n int)
+	// IncUDPDatagram counts a datagram forwarded by an udp association,
+	// upstream is from client to target.
+	IncUDPDatagram(upstream bool)
+}
+
+// NoopMetrics is a Metrics which discards everything
+type NoopMetrics struct{}
+
+// IncError implement interface Metrics
+func (NoopMetrics) IncError(Phase, uint8) {}
+
+func (sf *Server) incError(phase Phase, rep uint8) {
+	if sf.metrics != nil {
+		sf.metrics.IncError(phase, rep)
+	}
+}
+
+func (sf *Server) observeDuration(phase Phase, cmd byte, start time.Time) {
+	if m, ok := sf.metrics.(DurationMetrics); ok {
+		m.ObserveDuration(phase, cmd, sf.since(start))
+	}
+}
+
+// addActiveConns adjusts the connections being served and notifies the metrics
+func (sf *Server) addActiveConns(delta int) {
+	atomic.AddInt64(&sf.activeConns, int64(delta))
+	if m, ok := sf.metrics.(SessionMetrics); ok {
+		m.AddActiveConns(delta)
+	}
+}
+
+func (sf *Server) incUDPDatagram(upstream bool) {
+	if m, ok := sf.metrics.(TrafficMetrics); ok {
+		m.IncUDPDatagram(upstream)
+	}
+}
+
+// transferHook returns the hook notified of the bytes relayed by a session of the command, which
+// counts them for the milestones and the metrics as they are relayed, nil if nothing counts them.
+func (sf *Server) transferHook(cmd byte, milestone func(n int)) func(upstream bool, n int) {
+	m, ok := sf.metrics.(TrafficMetrics)
+	if !ok {
+		if milestone == nil {
+			return nil
+		}
+		return func(_ bool, n int) { milestone(n) }
+	}
+	return func(upstream bool, n int) {
+		if n <= 0 {
+			return
+		}
+		m.AddRelayedBytes(cmd, upstream, n)
+		if milestone != nil {
+			milestone(n)
+		}
+	}
+}
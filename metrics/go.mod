@@ -0,0 +1,11 @@
+module github.com/thinkgos/go-socks5/metrics
+
+go 1.14
+
+require (
+	github.com/prometheus/client_golang v1.7.1
+	github.com/stretchr/testify v1.6.1
+	github.com/thinkgos/go-socks5 v0.0.0
+)
+
+replace github.com/thinkgos/go-socks5 => ../
@@ -0,0 +1,266 @@
+// Package metrics implement interface socks5.Metrics and the optional interfaces with prometheus collectors.
+//
+//	m, err := metrics.New("socks5", prometheus.DefaultRegisterer)
+//	if err != nil {
+//		// handle the registration error
+//	}
+//	server := socks5.NewServer(socks5.WithMetrics(m))
+package metrics
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/thinkgos/go-socks5"
+	"github.com/thinkgos/go-socks5/statute"
+)
+
+// Metrics implement interface socks5.Metrics and the optional interfaces
+type Metrics struct {
+	activeConns    prometheus.Gauge
+	errors         *prometheus.CounterVec
+	durations      *prometheus.HistogramVec
+	dnsDurations   prometheus.Histogram
+	natEvictions   prometheus.Counter
+	requestHeaders prometheus.Histogram
+	udpOversize    *prometheus.CounterVec
+	droppedFrags   prometheus.Counter
+	clientNoise    *prometheus.CounterVec
+	sessionCloses  *prometheus.CounterVec
+	authFailures   *prometheus.CounterVec
+	relayedBytes   *prometheus.CounterVec
+	sessionTimes   *prometheus.HistogramVec
+	udpDatagrams   *prometheus.CounterVec
+	upBytes        [4]prometheus.Counter // by command
+	downBytes      [4]prometheus.Counter // by command
+	upDatagrams    prometheus.Counter
+	downDatagrams  prometheus.Counter
+}
+
+var (
+	_ socks5.Metrics              = (*Metrics)(nil)
+	_ socks5.DurationMetrics      = (*Metrics)(nil)
+	_ socks5.NATMetrics           = (*Metrics)(nil)
+	_ socks5.RequestHeaderMetrics = (*Metrics)(nil)
+	_ socks5.UDPMetrics           = (*Metrics)(nil)
+	_ socks5.ClientNoiseMetrics   = (*Metrics)(nil)
+	_ socks5.SessionMetrics       = (*Metrics)(nil)
+	_ socks5.TrafficMetrics       = (*Metrics)(nil)
+)
+
+// New creates the metrics with the namespace and registers them on reg,
+// the prometheus.DefaultRegisterer is used if reg is nil.
+func New(namespace string, reg prometheus.Registerer) (*Metrics, error) {
+	if reg == nil {
+		reg = prometheus.DefaultRegisterer
+	}
+	sf := &Metrics{
+		activeConns: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "active_connections",
+			Help:      "Number of connections being served.",
+		}),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "errors_total",
+			Help:      "Number of failures by phase and the reply code returned to the client.",
+		}, []string{"phase", "rep"}),
+		durations: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "phase_duration_seconds",
+			Help:      "Time spent in the negotiation, auth, request and dial phases.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"phase", "command"}),
+		dnsDurations: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "dns_resolution_seconds",
+			Help:      "Latency of the destination name resolution.",
+			Buckets:   prometheus.DefBuckets,
+		}),
+		natEvictions: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "nat_evictions_total",
+			Help:      "Number of udp flows evicted from the NAT table.",
+		}),
+		requestHeaders: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "request_header_reads",
+			Help:      "Number of reads taken to deliver the request header.",
+			Buckets:   []float64{1, 2, 4, 8, 16, 32},
+		}),
+		udpOversize: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "udp_oversize_total",
+			Help:      "Number of relayed datagrams exceeding the max size by policy.",
+		}, []string{"policy"}),
+		droppedFrags: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "udp_fragments_dropped_total",
+			Help:      "Number of fragments of the client datagrams dropped.",
+		}),
+		clientNoise: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "client_noise_total",
+			Help:      "Number of clients which aborted, reset or closed the connection early by phase.",
+		}, []string{"phase"}),
+		sessionCloses: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "sessions_closed_total",
+			Help:      "Number of sessions ended by close reason.",
+		}, []string{"reason"}),
+		authFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "auth_failures_total",
+			Help:      "Number of failed authentications by method.",
+		}, []string{"method"}),
+		relayedBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "relayed_bytes_total",
+			Help:      "Number of bytes relayed by command and direction.",
+		}, []string{"command", "direction"}),
+		sessionTimes: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "session_duration_seconds",
+			Help:      "Lifetime of the ended sessions by command.",
+			Buckets:   []float64{0.1, 0.5, 1, 5, 10, 30, 60, 300, 900, 3600},
+		}, []string{"command"}),
+		udpDatagrams: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "udp_datagrams_total",
+			Help:      "Number of datagrams forwarded by udp associations by direction.",
+		}, []string{"direction"}),
+	}
+	// the command and direction children are resolved once, they are hit on every session and datagram
+	for cmd := range sf.upBytes {
+		sf.upBytes[cmd] = sf.relayedBytes.WithLabelValues(commandLabel(byte(cmd)), "up")
+		sf.downBytes[cmd] = sf.relayedBytes.WithLabelValues(commandLabel(byte(cmd)), "down")
+	}
+	sf.upDatagrams = sf.udpDatagrams.WithLabelValues("up")
+	sf.downDatagrams = sf.udpDatagrams.WithLabelValues("down")
+
+	for _, c := range sf.collectors() {
+		if err := reg.Register(c); err != nil {
+			return nil, err
+		}
+	}
+	return sf, nil
+}
+
+func (sf *Metrics) collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		sf.activeConns, sf.errors, sf.durations, sf.dnsDurations,
+		sf.natEvictions, sf.requestHeaders, sf.udpOversize, sf.droppedFrags, sf.clientNoise,
+		sf.sessionCloses, sf.authFailures, sf.relayedBytes, sf.sessionTimes, sf.udpDatagrams,
+	}
+}
+
+// IncError implement interface socks5.Metrics
+func (sf *Metrics) IncError(phase socks5.Phase, rep uint8) {
+	label := "none"
+	if rep != socks5.NoReply {
+		label = strconv.Itoa(int(rep))
+	}
+	sf.errors.WithLabelValues(phase.String(), label).Inc()
+}
+
+// ObserveDuration implement interface socks5.DurationMetrics
+func (sf *Metrics) ObserveDuration(phase socks5.Phase, cmd byte, d time.Duration) {
+	if phase == socks5.PhaseResolve {
+		sf.dnsDurations.Observe(d.Seconds())
+		return
+	}
+	sf.durations.WithLabelValues(phase.String(), commandLabel(cmd)).Observe(d.Seconds())
+}
+
+// IncNATEviction implement interface socks5.NATMetrics
+func (sf *Metrics) IncNATEviction() { sf.natEvictions.Inc() }
+
+// ObserveRequestHeader implement interface socks5.RequestHeaderMetrics
+func (sf *Metrics) ObserveRequestHeader(_, reads int) { sf.requestHeaders.Observe(float64(reads)) }
+
+// IncUDPOversize implement interface socks5.UDPMetrics
+func (sf *Metrics) IncUDPOversize(policy socks5.UDPOversizePolicy) {
+	sf.udpOversize.WithLabelValues(policy.String()).Inc()
+}
+
+// AddDroppedFragments implement interface socks5.UDPMetrics
+func (sf *Metrics) AddDroppedFragments(n int) { sf.droppedFrags.Add(float64(n)) }
+
+// IncClientNoise implement interface socks5.ClientNoiseMetrics
+func (sf *Metrics) IncClientNoise(phase socks5.Phase) {
+	sf.clientNoise.WithLabelValues(phase.String()).Inc()
+}
+
+// IncSessionClose implement interface socks5.SessionMetrics
+func (sf *Metrics) IncSessionClose(reason socks5.CloseReason) {
+	sf.sessionCloses.WithLabelValues(reason.String()).Inc()
+}
+
+// AddActiveConns implement interface socks5.SessionMetrics
+func (sf *Metrics) AddActiveConns(delta int) { sf.activeConns.Add(float64(delta)) }
+
+// IncAuthFailure implement interface socks5.SessionMetrics
+func (sf *Metrics) IncAuthFailure(method uint8) {
+	sf.authFailures.WithLabelValues(methodLabel(method)).Inc()
+}
+
+// AddRelayedBytes implement interface socks5.TrafficMetrics
+func (sf *Metrics) AddRelayedBytes(cmd byte, upstream bool, n int) {
+	if int(cmd) < len(sf.upBytes) {
+		if upstream {
+			sf.upBytes[cmd].Add(float64(n))
+		} else {
+			sf.downBytes[cmd].Add(float64(n))
+		}
+		return
+	}
+	direction := "down"
+	if upstream {
+		direction = "up"
+	}
+	sf.relayedBytes.WithLabelValues(commandLabel(cmd), direction).Add(float64(n))
+}
+
+// ObserveSessionDuration implement interface socks5.SessionMetrics
+func (sf *Metrics) ObserveSessionDuration(cmd byte, d time.Duration) {
+	sf.sessionTimes.WithLabelValues(commandLabel(cmd)).Observe(d.Seconds())
+}
+
+// IncUDPDatagram implement interface socks5.TrafficMetrics
+func (sf *Metrics) IncUDPDatagram(upstream bool) {
+	if upstream {
+		sf.upDatagrams.Inc()
+	} else {
+		sf.downDatagrams.Inc()
+	}
+}
+
+func commandLabel(cmd byte) string {
+	switch cmd {
+	case statute.CommandConnect:
+		return "connect"
+	case statute.CommandBind:
+		return "bind"
+	case statute.CommandAssociate:
+		return "associate"
+	case 0:
+		return "none"
+	}
+	return strconv.Itoa(int(cmd))
+}
+
+func methodLabel(method uint8) string {
+	switch method {
+	case statute.MethodNoAuth:
+		return "no_auth"
+	case statute.MethodGSSAPI:
+		return "gssapi"
+	case statute.MethodUserPassAuth:
+		return "user_pass"
+	case statute.MethodForwardedIdentity:
+		return "forwarded_identity"
+	}
+	return strconv.Itoa(int(method))
+}
@@ -0,0 +1,49 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+
+	"github.com/thinkgos/go-socks5"
+	"github.com/thinkgos/go-socks5/statute"
+)
+
+func TestMetrics(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	m, err := New("socks5", reg)
+	require.NoError(t, err)
+
+	m.AddActiveConns(2)
+	m.AddActiveConns(-1)
+	m.IncError(socks5.PhaseDial, statute.RepConnectionRefused)
+	m.IncError(socks5.PhaseAuth, socks5.NoReply)
+	m.IncAuthFailure(statute.MethodUserPassAuth)
+	m.AddRelayedBytes(statute.CommandConnect, true, 10)
+	m.AddRelayedBytes(statute.CommandConnect, false, 20)
+	m.AddRelayedBytes(statute.CommandAssociate, false, 2)
+	m.ObserveSessionDuration(statute.CommandAssociate, time.Second)
+	m.ObserveSessionDuration(0, time.Millisecond)
+	m.IncUDPDatagram(true)
+	m.AddDroppedFragments(3)
+	m.ObserveDuration(socks5.PhaseResolve, statute.CommandConnect, time.Millisecond)
+
+	require.Equal(t, float64(1), testutil.ToFloat64(m.activeConns))
+	require.Equal(t, float64(1), testutil.ToFloat64(m.errors.WithLabelValues("dial", "5")))
+	require.Equal(t, float64(1), testutil.ToFloat64(m.errors.WithLabelValues("auth", "none")))
+	require.Equal(t, float64(1), testutil.ToFloat64(m.authFailures.WithLabelValues("user_pass")))
+	require.Equal(t, float64(10), testutil.ToFloat64(m.relayedBytes.WithLabelValues("connect", "up")))
+	require.Equal(t, float64(20), testutil.ToFloat64(m.relayedBytes.WithLabelValues("connect", "down")))
+	require.Equal(t, float64(2), testutil.ToFloat64(m.relayedBytes.WithLabelValues("associate", "down")))
+	require.Equal(t, 2, testutil.CollectAndCount(m.sessionTimes))
+	require.Equal(t, float64(1), testutil.ToFloat64(m.upDatagrams))
+	require.Equal(t, float64(3), testutil.ToFloat64(m.droppedFrags))
+	require.Equal(t, 1, testutil.CollectAndCount(m.dnsDurations))
+
+	// registering twice on the same registerer fails
+	_, err = New("socks5", reg)
+	require.Error(t, err)
+}
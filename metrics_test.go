@@ -0,0 +1,234 @@
+package socks5
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/thinkgos/go-socks5/statute"
+)
+
+type mockMetrics struct {
+	errors    map[Phase]map[uint8]int
+	durations map[Phase]map[byte]int
+	evictions int
+	headers   int
+	reads     int
+	oversize  map[UDPOversizePolicy]int
+	fragments int
+	noise     map[Phase]int
+	closes    map[CloseReason]int
+	// updated from the serving goroutines
+	active        int64
+	authFailures  map[uint8]int
+	bytesUp       uint64
+	bytesDown     uint64
+	relayedCmd    uint32
+	datagramsUp   int64
+	datagramsDown int64
+	// sessions ended by command
+	sessions [4]int64
+}
+
+var (
+	_ DurationMetrics      = (*mockMetrics)(nil)
+	_ NATMetrics           = (*mockMetrics)(nil)
+	_ RequestHeaderMetrics = (*mockMetrics)(nil)
+	_ UDPMetrics           = (*mockMetrics)(nil)
+	_ ClientNoiseMetrics   = (*mockMetrics)(nil)
+	_ SessionMetrics       = (*mockMetrics)(nil)
+	_ TrafficMetrics       = (*mockMetrics)(nil)
+)
+
+func newMockMetrics() *mockMetrics {
+	return &mockMetrics{
+		errors:    make(map[Phase]map[uint8]int),
+		durations: make(map[Phase]map[byte]int),
+		oversize:  make(map[UDPOversizePolicy]int),
+		noise:     make(map[Phase]int),
+		closes:    make(map[CloseReason]int),
+
+		authFailures: make(map[uint8]int),
+	}
+}
+
+func (m *mockMetrics) IncError(phase Phase, rep uint8) {
+	if m.errors[phase] == nil {
+		m.errors[phase] = make(map[uint8]int)
+	}
+	m.errors[phase][rep]++
+}
+
+func (m *mockMetrics) ObserveDuration(phase Phase, cmd byte, _ time.Duration) {
+	if m.durations[phase] == nil {
+		m.durations[phase] = make(map[byte]int)
+	}
+	m.durations[phase][cmd]++
+}
+
+func (m *mockMetrics) IncNATEviction() { m.evictions++ }
+
+func (m *mockMetrics) IncClientNoise(phase Phase) { m.noise[phase]++ }
+
+func (m *mockMetrics) IncSessionClose(reason CloseReason) { m.closes[reason]++ }
+
+func (m *mockMetrics) IncUDPOversize(policy UDPOversizePolicy) { m.oversize[policy]++ }
+
+func (m *mockMetrics) AddDroppedFragments(n int) { m.fragments += n }
+
+func (m *mockMetrics) AddActiveConns(delta int) { atomic.AddInt64(&m.active, int64(delta)) }
+
+func (m *mockMetrics) IncAuthFailure(method uint8) { m.authFailures[method]++ }
+
+func (m *mockMetrics) AddRelayedBytes(cmd byte, upstream bool, n int) {
+	atomic.StoreUint32(&m.relayedCmd, uint32(cmd))
+	if upstream {
+		atomic.AddUint64(&m.bytesUp, uint64(n))
+	} else {
+		atomic.AddUint64(&m.bytesDown, uint64(n))
+	}
+}
+
+func (m *mockMetrics) ObserveSessionDuration(cmd byte, _ time.Duration) {
+	atomic.AddInt64(&m.sessions[cmd], 1)
+}
+
+func (m *mockMetrics) IncUDPDatagram(upstream bool) {
+	if upstream {
+		atomic.AddInt64(&m.datagramsUp, 1)
+	} else {
+		atomic.AddInt64(&m.datagramsDown, 1)
+	}
+}
+
+func (m *mockMetrics) ObserveRequestHeader(_, reads int) {
+	m.headers++
+	m.reads += reads
+}
+
+func TestPhase_String(t *testing.T) {
+	require.Equal(t, "negotiation", PhaseNegotiation.String())
+	require.Equal(t, "relay", PhaseRelay.String())
+	require.Equal(t, "unknown", Phase(0xff).String())
+}
+
+func TestMetrics_IncError(t *testing.T) {
+	m := newMockMetrics()
+	s := NewServer(WithRule(NewPermitNone()), WithMetrics(m))
+
+	req, err := ParseRequest(bytes.NewBuffer([]byte{
+		statute.VersionSocks5, statute.CommandConnect, 0,
+		statute.ATYPIPv4, 127, 0, 0, 1, 0, 80,
+	}))
+	require.NoError(t, err)
+
+	err = s.handleRequest(context.Background(), new(MockConn), req)
+	require.Error(t, err)
+	require.Equal(t, 1, m.errors[PhaseRule][statute.RepRuleFailure])
+}
+
+func TestMetrics_IncError_Relay(t *testing.T) {
+	target := echoTarget(t)
+	closed := make(chan struct{}, 1)
+	m := newMockMetrics()
+	srv := NewServer(
+		WithMetrics(m),
+		WithClientTimeout(50*time.Millisecond, 0),
+		WithSessionCloseHandle(func(Session, error) { closed <- struct{}{} }),
+	)
+	proxy, _ := startServer(t, srv)
+	defer srv.Close()
+
+	// the client going silent fails the relay by the read timeout
+	conn := relaySession(t, proxy, target)
+	defer conn.Close()
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("session close not notified")
+	}
+	require.Equal(t, 1, m.errors[PhaseRelay][NoReply])
+	require.Zero(t, m.errors[PhaseRelay][statute.RepSuccess])
+}
+
+func TestMetrics_ObserveDuration(t *testing.T) {
+	m := newMockMetrics()
+	s := NewServer(WithMetrics(m))
+
+	req, err := ParseRequest(bytes.NewBuffer([]byte{
+		statute.VersionSocks5, statute.CommandConnect, 0,
+		statute.ATYPDomain, 9, 'l', 'o', 'c', 'a', 'l', 'h', 'o', 's', 't', 0, 1,
+	}))
+	require.NoError(t, err)
+
+	err = s.handleRequest(context.Background(), new(MockConn), req)
+	require.Error(t, err)
+	require.Equal(t, 1, m.durations[PhaseResolve][statute.CommandConnect])
+	require.Equal(t, 1, m.durations[PhaseDial][statute.CommandConnect])
+	require.Equal(t, 1, m.errors[PhaseDial][statute.RepConnectionRefused])
+}
+
+func TestMetrics_Session(t *testing.T) {
+	target := echoTarget(t)
+	closed := make(chan struct{}, 1)
+	m := newMockMetrics()
+	srv := NewServer(
+		WithMetrics(m),
+		WithSessionCloseHandle(func(Session, error) { closed <- struct{}{} }),
+	)
+	proxy, _ := startServer(t, srv)
+	defer srv.Close()
+
+	conn := relaySession(t, proxy, target)
+	require.Equal(t, int64(1), atomic.LoadInt64(&m.active))
+	// the bytes are counted as they are relayed, before the session ends
+	require.Eventually(t, func() bool {
+		return atomic.LoadUint64(&m.bytesUp) == 4 && atomic.LoadUint64(&m.bytesDown) == 4
+	}, time.Second, 10*time.Millisecond)
+	require.Equal(t, uint32(statute.CommandConnect), atomic.LoadUint32(&m.relayedCmd))
+	conn.Close()
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("session close not notified")
+	}
+	require.Equal(t, int64(1), atomic.LoadInt64(&m.sessions[statute.CommandConnect]))
+	require.Eventually(t, func() bool { return atomic.LoadInt64(&m.active) == 0 }, time.Second, 10*time.Millisecond)
+
+	// the session ended before the request has no command
+	conn, err := net.Dial("tcp", proxy.String())
+	require.NoError(t, err)
+	conn.Close()
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("session close not notified")
+	}
+	require.Equal(t, int64(1), atomic.LoadInt64(&m.sessions[0]))
+}
+
+func TestMetrics_Optional(t *testing.T) {
+	target := echoTarget(t)
+	srv := NewServer(WithMetrics(NoopMetrics{}))
+	proxy, _ := startServer(t, srv)
+	defer srv.Close()
+
+	// the observations not implemented by the Metrics are skipped
+	conn := relaySession(t, proxy, target)
+	conn.Close()
+}
+
+func TestMetrics_IncAuthFailure(t *testing.T) {
+	m := newMockMetrics()
+	s := NewServer(WithMetrics(m), WithCredential(StaticCredentials{"foo": "bar"}))
+
+	req := bytes.NewBuffer([]byte{1, 3, 'f', 'o', 'o', 3, 'b', 'a', 'z'})
+	_, err := s.authenticate(new(bytes.Buffer), req, "", []byte{statute.MethodUserPassAuth})
+	require.Error(t, err)
+	require.Equal(t, 1, m.authFailures[statute.MethodUserPassAuth])
+}
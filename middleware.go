@@ -0,0 +1,23 @@
+package socks5
+
+import (
+	"context"
+	"io"
+)
+
+// Handler handles the request of a session, it writes the reply and relays the data,
+// the same as WithConnectHandle, WithBindHandle and WithAssociateHandle.
+type Handler func(ctx context.Context, writer io.Writer, request *Request) error
+
+// Middleware wraps the Handler, such as logging, enriching the AuthContext, rewriting the
+// destination address or metrics around the request handling, similar to net/http middleware.
+// The middleware may handle the request itself without calling the next Handler.
+type Middleware func(next Handler) Handler
+
+// chainHandler wraps the handler by the middlewares, the first one is the outermost
+func chainHandler(h Handler, middlewares []Middleware) Handler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		h = middlewares[i](h)
+	}
+	return h
+}
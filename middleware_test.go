@@ -0,0 +1,60 @@
+package socks5
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/thinkgos/go-socks5/statute"
+)
+
+func TestServer_Middleware(t *testing.T) {
+	target := echoTarget(t)
+	order := make(chan string, 4)
+	trace := func(name string) Middleware {
+		return func(next Handler) Handler {
+			return func(ctx context.Context, writer io.Writer, request *Request) error {
+				order <- name
+				return next(ctx, writer, request)
+			}
+		}
+	}
+	// the destination rewritten is dialed
+	rewrite := func(next Handler) Handler {
+		return func(ctx context.Context, writer io.Writer, request *Request) error {
+			request.RawDestAddr = &statute.AddrSpec{AddrType: statute.ATYPIPv4, IP: target.IP, Port: target.Port}
+			return next(ctx, writer, request)
+		}
+	}
+	proxy := serveSocks(t, WithMiddleware(trace("a"), trace("b")), WithMiddleware(rewrite))
+
+	conn := relaySession(t, proxy, &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1})
+	defer conn.Close()
+	require.Equal(t, "a", <-order)
+	require.Equal(t, "b", <-order)
+}
+
+func TestServer_MiddlewareDeny(t *testing.T) {
+	deny := func(next Handler) Handler {
+		return func(ctx context.Context, writer io.Writer, request *Request) error {
+			if err := SendReply(writer, statute.RepRuleFailure, nil); err != nil {
+				return err
+			}
+			return errors.New("denied by the middleware")
+		}
+	}
+	srv := NewServer(WithMiddleware(deny))
+	rsp := new(MockConn)
+	req := &Request{
+		Request:     statute.Request{Version: statute.VersionSocks5, Command: statute.CommandConnect},
+		RawDestAddr: &statute.AddrSpec{AddrType: statute.ATYPIPv4, IP: net.IPv4(127, 0, 0, 1), Port: 1},
+	}
+	require.EqualError(t, srv.handler(context.Background(), rsp, req), "denied by the middleware")
+	rep, err := statute.ParseReply(&rsp.buf)
+	require.NoError(t, err)
+	require.Equal(t, statute.RepRuleFailure, rep.Response)
+}
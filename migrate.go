@@ -0,0 +1,349 @@
+package socks5
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/thinkgos/go-socks5/statute"
+)
+
+// errMigrationUnsupported is returned on the platform which could not pass the file descriptors
+var errMigrationUnsupported = errors.New("session migration not supported on this platform")
+
+// migrationPollInterval is the interval the relay of the migrating sessions is interrupted
+const migrationPollInterval = 10 * time.Millisecond
+
+// maxMigrationMessage the max size of the migrated session descriptor
+const maxMigrationMessage = 64 * 1024
+
+// migratedSession is the descriptor of the session handed to the new process,
+// sent with the client and the target file descriptors.
+type migratedSession struct {
+	Command    byte
+	RawDest    string
+	Dest       string
+	Tenant     string
+	Tag        string
+	AuthMethod uint8
+	Payload    map[string]string
+	Started    time.Time
+	BytesUp    uint64
+	BytesDown  uint64
+	// Buffered is the data received from the client but not forwarded to the target yet
+	Buffered []byte
+	// BufferedDown is the data received from the target but not forwarded to the client yet
+	BufferedDown []byte
+}
+
+// migration hands the sessions to the new process over the unix connection
+type migration struct {
+	uc *net.UnixConn
+	mu sync.Mutex // serializes the messages
+	wg sync.WaitGroup
+	n  int32
+}
+
+// Migrate hands the relaying CONNECT and BIND sessions to the new process of a graceful restart,
+// the file descriptors of both legs are passed over the unix connection, which must be a
+// "unixpacket" connection to the process calling Adopt, so the long-lived tunnels survive the
+// binary upgrade. The reads of the relay are interrupted, the writes in flight finish, and the
+// data received but not relayed yet in both directions is handed too, the session ends here with
+// CloseReasonMigrated. Only the sessions relayed on the plain tcp connections are migrated, not on
+// TLS, the PROXY protocol, the compression, the traffic trace or a wrapped target such as the shadow.
+// It returns the number of the sessions migrated, the sessions not migrated keep relaying, such as to
+// be closed by Shutdown later.
+func (sf *Server) Migrate(ctx context.Context, uc *net.UnixConn) (int, error) {
+	if !canPassFiles {
+		return 0, errMigrationUnsupported
+	}
+	m := &migration{uc: uc}
+	var pending []*session
+	sf.sessions.Range(func(_, value interface{}) bool {
+		sess := value.(*session)
+		sess.mu.Lock()
+		if sess.migratable != nil && sess.migration == nil {
+			sess.migration = m
+			m.wg.Add(1)
+			pending = append(pending, sess)
+		}
+		sess.mu.Unlock()
+		return true
+	})
+
+	done := make(chan struct{})
+	go func() {
+		m.wg.Wait()
+		close(done)
+	}()
+	// interrupt the relay repeatedly, the timeout of the relay may extend the deadline
+	ticker := time.NewTicker(migrationPollInterval)
+	defer ticker.Stop()
+	for {
+		for _, sess := range pending {
+			sess.mu.Lock()
+			if t := sess.migratable; t != nil {
+				sess.conn.SetReadDeadline(aLongTimeAgo) // nolint: errcheck
+				t.SetReadDeadline(aLongTimeAgo)         // nolint: errcheck
+			}
+			sess.mu.Unlock()
+		}
+		select {
+		case <-done:
+			return int(atomic.LoadInt32(&m.n)), nil
+		case <-ctx.Done():
+			return int(atomic.LoadInt32(&m.n)), ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// aLongTimeAgo is a deadline in the past which interrupts the blocked reads immediately
+var aLongTimeAgo = time.Unix(1, 0)
+
+// setMigratable marks the session migratable if both legs are the plain tcp connections,
+// the returned func unmarks it, which must be called once the relay ends.
+func (sf *session) setMigratable(request *Request, target net.Conn) func() {
+	if sf == nil {
+		return func() {}
+	}
+	_, ok1 := request.conn.(*net.TCPConn)
+	_, ok2 := target.(*net.TCPConn)
+	_, ok3 := request.Reader.(*bufio.Reader)
+	if !ok1 || !ok2 || !ok3 || (request.AuthContext != nil && request.AuthContext.encapsulate != nil) {
+		return func() {}
+	}
+	sf.mu.Lock()
+	sf.migratable = target
+	sf.mu.Unlock()
+	return func() {
+		sf.mu.Lock()
+		sf.migratable = nil
+		if sf.migration != nil && !sf.handedOff {
+			sf.handedOff = true
+			sf.migration.wg.Done()
+		}
+		sf.mu.Unlock()
+	}
+}
+
+// migrationOf returns the migration of the session, nil if not migrating
+func (sf *session) migrationOf() *migration {
+	if sf == nil {
+		return nil
+	}
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	return sf.migration
+}
+
+// pendingWriter keeps the data not written by the failed writes of the migrating session,
+// which is handed to the new process with the session.
+type pendingWriter struct {
+	io.Writer
+	sess    *session
+	pending []byte
+}
+
+// Write implement interface io.Writer
+func (sf *pendingWriter) Write(b []byte) (int, error) {
+	n, err := sf.Writer.Write(b)
+	if err != nil && n < len(b) && sf.sess.migrationOf() != nil {
+		sf.pending = append(sf.pending, b[n:]...)
+	}
+	return n, err
+}
+
+// CloseWrite implement interface closeWriter
+func (sf *pendingWriter) CloseWrite() error {
+	if c, ok := sf.Writer.(closeWriter); ok {
+		return c.CloseWrite()
+	}
+	return nil
+}
+
+// handOff sends the session of the stopped relay to the new process, up and down are the data
+// read but not written to the target and to the client.
+func (sf *Server) handOff(m *migration, request *Request, target net.Conn, up, down []byte) error {
+	sess := request.sess
+	defer func() {
+		sess.mu.Lock()
+		if !sess.handedOff {
+			sess.handedOff = true
+			m.wg.Done()
+		}
+		sess.mu.Unlock()
+	}()
+
+	br := request.Reader.(*bufio.Reader)
+	buffered, _ := br.Peek(br.Buffered())
+	ms := migratedSession{
+		Command:   request.Command,
+		RawDest:   request.RawDestAddr.String(),
+		Dest:      request.DestAddr.String(),
+		Tenant:    request.Tenant,
+		Tag:       request.Tag,
+		Started:   sess.started,
+		BytesUp:   atomic.LoadUint64(&sess.bytesUp),
+		BytesDown: atomic.LoadUint64(&sess.bytesDown),
+		// the data read from the reader precedes the data still buffered by it
+		Buffered:     append(up, buffered...),
+		BufferedDown: down,
+	}
+	if ac := request.AuthContext; ac != nil {
+		ms.AuthMethod, ms.Payload = ac.Method, ac.Payload
+	}
+	b, err := json.Marshal(ms)
+	if err != nil {
+		return err
+	}
+	if len(b) > maxMigrationMessage {
+		return fmt.Errorf("migrated session descriptor too large, %d bytes", len(b))
+	}
+	cf, err := request.conn.(*net.TCPConn).File()
+	if err != nil {
+		return err
+	}
+	defer cf.Close()
+	tf, err := target.(*net.TCPConn).File()
+	if err != nil {
+		return err
+	}
+	defer tf.Close()
+
+	m.mu.Lock()
+	err = writeFiles(m.uc, b, cf, tf)
+	m.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("hand off session, %v", err)
+	}
+	atomic.AddInt32(&m.n, 1)
+	sess.setCloseReason(CloseReasonMigrated)
+	return nil
+}
+
+// Adopt receives the sessions handed by Migrate of the old process over the unix connection,
+// and resumes the relay of them, until the old process closes the connection.
+// The adopted sessions are served as the sessions accepted, closed by Shutdown or Close.
+func (sf *Server) Adopt(uc *net.UnixConn) error {
+	if !canPassFiles {
+		return errMigrationUnsupported
+	}
+	buf := make([]byte, maxMigrationMessage)
+	for {
+		n, files, err := readFiles(uc, buf)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		if n == 0 && len(files) == 0 {
+			return nil
+		}
+		if err := sf.adopt(buf[:n], files); err != nil {
+			sf.logger.Errorf("adopt session failed, %v", err)
+		}
+	}
+}
+
+// adopt resumes the relay of the migrated session
+func (sf *Server) adopt(b []byte, files []*os.File) error {
+	conns := make([]net.Conn, 0, len(files))
+	for _, f := range files {
+		conn, err := net.FileConn(f)
+		f.Close()
+		if err == nil {
+			conns = append(conns, conn)
+		}
+	}
+	closeAll := func() {
+		for _, c := range conns {
+			c.Close()
+		}
+	}
+	if len(conns) != 2 {
+		closeAll()
+		return fmt.Errorf("%d connections received, want 2", len(conns))
+	}
+	var ms migratedSession
+	if err := json.Unmarshal(b, &ms); err != nil {
+		closeAll()
+		return fmt.Errorf("invalid migrated session, %v", err)
+	}
+	rawDest, err1 := statute.ParseAddrSpec(ms.RawDest)
+	dest, err2 := statute.ParseAddrSpec(ms.Dest)
+	if err1 != nil || err2 != nil {
+		closeAll()
+		return fmt.Errorf("invalid migrated session destination %s", ms.RawDest)
+	}
+	sf.addActiveConns(1)
+	sf.goFunc(func() {
+		defer sf.addActiveConns(-1)
+		sf.serveAdopted(conns[0], conns[1], &ms, &rawDest, &dest) // nolint: errcheck
+	})
+	return nil
+}
+
+// serveAdopted relays the adopted session
+func (sf *Server) serveAdopted(conn, target net.Conn, ms *migratedSession,
+	rawDest, dest *statute.AddrSpec) (err error) {
+	defer conn.Close()
+	defer target.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sess := newSession(conn, ms.Started)
+	sess.tag = ms.Tag
+	sess.bytesUp, sess.bytesDown = ms.BytesUp, ms.BytesDown
+	sf.sessions.Store(sess.id, sess)
+	defer sf.sessions.Delete(sess.id)
+	defer func() { err = sf.endSession(sess, err) }()
+	defer closeOnDone(ctx, closerFunc(func() error {
+		sess.setCloseReason(CloseReasonAdminKill)
+		return conn.Close()
+	}))()
+	if sf.shuttingDown() {
+		return ErrServerClosed
+	}
+
+	request := &Request{
+		Request:     statute.Request{Version: statute.VersionSocks5, Command: ms.Command, DstAddr: *rawDest},
+		RawDestAddr: rawDest,
+		DestAddr:    dest,
+		Tenant:      ms.Tenant,
+		Tag:         ms.Tag,
+		Reader:      bufio.NewReader(io.MultiReader(bytes.NewReader(ms.Buffered), conn)),
+		Accepted:    ms.Started,
+		Received:    ms.Started,
+		LocalAddr:   unmapAddr(conn.LocalAddr()),
+		RemoteAddr:  unmapAddr(conn.RemoteAddr()),
+		sess:        sess,
+		conn:        conn,
+	}
+	if ms.Payload != nil {
+		request.AuthContext = &AuthContext{Method: ms.AuthMethod, Payload: ms.Payload}
+	}
+	if client := forwardedClient(request.AuthContext); client != nil {
+		request.ForwardedBy, request.RemoteAddr = request.RemoteAddr, client
+	}
+	request.mem = newMemoryBudget(sf.sessionMemory, sf.memory)
+	defer request.mem.close()
+	sess.setRequest(request)
+	defer sf.watchIdle(sess, conn, sf.idleTimeout)()
+	if len(ms.BufferedDown) > 0 {
+		if _, err := sess.downWriter(conn).Write(ms.BufferedDown); err != nil {
+			return fmt.Errorf("write migrated data to client failed, %v", err)
+		}
+	}
+	return sf.relay(ctx, conn, request, target)
+}
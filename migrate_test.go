@@ -0,0 +1,150 @@
+//go:build linux
+// +build linux
+
+package socks5
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// listenMigrate listens on a unixpacket socket in a fresh temporary directory.
+func listenMigrate(t *testing.T) (*net.UnixListener, func()) {
+	dir, err := ioutil.TempDir("", "migrate")
+	require.NoError(t, err)
+	l, err := net.ListenUnix("unixpacket", &net.UnixAddr{Name: filepath.Join(dir, "migrate.sock")})
+	if err != nil {
+		os.RemoveAll(dir) // nolint: errcheck
+		require.NoError(t, err)
+	}
+	return l, func() {
+		l.Close()
+		os.RemoveAll(dir) // nolint: errcheck
+	}
+}
+
+func TestServer_Migrate(t *testing.T) {
+	target := echoTarget(t)
+	closed := make(chan Session, 1)
+	oldSrv := NewServer(WithSessionCloseHandle(func(s Session, _ error) { closed <- s }))
+	proxyAddr, _ := startServer(t, oldSrv)
+	defer oldSrv.Close()
+	conn := relaySession(t, proxyAddr, target)
+	defer conn.Close()
+
+	newSrv := NewServer()
+	defer newSrv.Close()
+	l, cleanup := listenMigrate(t)
+	defer cleanup()
+	adopted := make(chan error, 1)
+	go func() {
+		uc, err := l.AcceptUnix()
+		if err != nil {
+			adopted <- err
+			return
+		}
+		defer uc.Close()
+		adopted <- newSrv.Adopt(uc)
+	}()
+
+	uc, err := net.DialUnix("unixpacket", nil, l.Addr().(*net.UnixAddr))
+	require.NoError(t, err)
+	require.Eventually(t, func() bool { return len(oldSrv.Sessions()) == 1 }, time.Second, 10*time.Millisecond)
+	n, err := oldSrv.Migrate(context.Background(), uc)
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+	uc.Close()
+	require.NoError(t, <-adopted)
+
+	s := <-closed
+	require.Equal(t, CloseReasonMigrated, s.CloseReason)
+	require.Empty(t, oldSrv.Sessions())
+	require.Eventually(t, func() bool { return len(newSrv.Sessions()) == 1 }, time.Second, 10*time.Millisecond)
+	sessions := newSrv.Sessions()
+	require.Equal(t, target.String(), sessions[0].DestAddr)
+	require.Equal(t, uint64(4), sessions[0].BytesUp)
+
+	// the tunnel survives, relayed by the new server
+	_, err = conn.Write([]byte("pong"))
+	require.NoError(t, err)
+	buf := make([]byte, 4)
+	conn.SetReadDeadline(time.Now().Add(time.Second)) // nolint: errcheck
+	_, err = io.ReadFull(conn, buf)
+	require.NoError(t, err)
+	require.Equal(t, "pong", string(buf))
+	require.Eventually(t, func() bool {
+		s := newSrv.Sessions()
+		return len(s) == 1 && s[0].BytesDown == 8
+	}, time.Second, 10*time.Millisecond)
+
+	// the client close ends the adopted session
+	conn.Close()
+	require.Eventually(t, func() bool { return len(newSrv.Sessions()) == 0 }, time.Second, 10*time.Millisecond)
+}
+
+func TestServer_Migrate_BulkTransfer(t *testing.T) {
+	target := echoTarget(t)
+	oldSrv := NewServer()
+	proxyAddr, _ := startServer(t, oldSrv)
+	defer oldSrv.Close()
+	conn := relaySession(t, proxyAddr, target)
+	defer conn.Close()
+
+	newSrv := NewServer()
+	defer newSrv.Close()
+	l, cleanup := listenMigrate(t)
+	defer cleanup()
+	go func() {
+		uc, err := l.AcceptUnix()
+		if err != nil {
+			return
+		}
+		defer uc.Close()
+		newSrv.Adopt(uc) // nolint: errcheck
+	}()
+
+	const size = 8 << 20
+	payload := make([]byte, size)
+	for i := range payload {
+		payload[i] = byte(i % 251)
+	}
+	go func() {
+		for b := payload; len(b) > 0; b = b[32*1024:] {
+			if _, err := conn.Write(b[:32*1024]); err != nil {
+				return
+			}
+		}
+	}()
+	echoed := make(chan []byte, 1)
+	read := make(chan struct{})
+	go func() {
+		conn.SetReadDeadline(time.Now().Add(10 * time.Second)) // nolint: errcheck
+		b := make([]byte, size)
+		n, _ := io.ReadFull(conn, b[:size/4])
+		close(read)
+		m, _ := io.ReadFull(conn, b[n:])
+		echoed <- b[:n+m]
+	}()
+
+	// migrate in the middle of the transfer
+	<-read
+	uc, err := net.DialUnix("unixpacket", nil, l.Addr().(*net.UnixAddr))
+	require.NoError(t, err)
+	defer uc.Close()
+	n, err := oldSrv.Migrate(context.Background(), uc)
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+
+	b := <-echoed
+	require.Equal(t, size, len(b))
+	require.True(t, bytes.Equal(payload, b), "byte stream corrupted")
+}
@@ -0,0 +1,51 @@
+//go:build !windows
+// +build !windows
+
+package socks5
+
+import (
+	"io"
+	"net"
+	"os"
+	"syscall"
+)
+
+// canPassFiles reports whether the file descriptors could be passed over the unix connection
+const canPassFiles = true
+
+// writeFiles sends the message with the files as SCM_RIGHTS
+func writeFiles(uc *net.UnixConn, b []byte, files ...*os.File) error {
+	fds := make([]int, 0, len(files))
+	for _, f := range files {
+		fds = append(fds, int(f.Fd()))
+	}
+	_, _, err := uc.WriteMsgUnix(b, syscall.UnixRights(fds...), nil)
+	return err
+}
+
+// readFiles receives a message with the files passed as SCM_RIGHTS
+func readFiles(uc *net.UnixConn, b []byte) (int, []*os.File, error) {
+	oob := make([]byte, syscall.CmsgSpace(4*4))
+	n, oobn, _, _, err := uc.ReadMsgUnix(b, oob)
+	if err != nil {
+		return 0, nil, err
+	}
+	if n == 0 && oobn == 0 {
+		return 0, nil, io.EOF
+	}
+	msgs, err := syscall.ParseSocketControlMessage(oob[:oobn])
+	if err != nil {
+		return 0, nil, err
+	}
+	var files []*os.File
+	for i := range msgs {
+		fds, err := syscall.ParseUnixRights(&msgs[i])
+		if err != nil {
+			continue
+		}
+		for _, fd := range fds {
+			files = append(files, os.NewFile(uintptr(fd), "migrated"))
+		}
+	}
+	return n, files, nil
+}
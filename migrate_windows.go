@@ -0,0 +1,22 @@
+//go:build windows
+// +build windows
+
+package socks5
+
+import (
+	"net"
+	"os"
+)
+
+// canPassFiles reports whether the file descriptors could be passed over the unix connection
+const canPassFiles = false
+
+// writeFiles is not supported on windows
+func writeFiles(*net.UnixConn, []byte, ...*os.File) error {
+	return errMigrationUnsupported
+}
+
+// readFiles is not supported on windows
+func readFiles(*net.UnixConn, []byte) (int, []*os.File, error) {
+	return 0, nil, errMigrationUnsupported
+}
@@ -0,0 +1,113 @@
+package socks5
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Milestone is fired when a user crosses a cumulative transfer threshold within the window
+type Milestone struct {
+	// User name crossed the threshold
+	User string
+	// Threshold of the cumulative bytes crossed
+	Threshold uint64
+	// Bytes relayed by the user in the window, both directions
+	Bytes uint64
+	// WindowStart is the start time of the window
+	WindowStart time.Time
+	// Time the threshold crossed
+	Time time.Time
+}
+
+// userTransfer is the cumulative transfer of a user in the window
+type userTransfer struct {
+	start time.Time
+	bytes uint64
+	// next is the index of the next threshold to cross
+	next int
+}
+
+// milestoneTracker tracks the cumulative transfer per user and fires the milestones
+type milestoneTracker struct {
+	clock      Clock
+	window     time.Duration
+	thresholds []uint64
+	handle     func(Milestone)
+	mu         sync.Mutex
+	users      map[string]*userTransfer
+	swept      time.Time
+}
+
+func newMilestoneTracker(window time.Duration, thresholds []uint64, h func(Milestone)) *milestoneTracker {
+	if len(thresholds) == 0 || h == nil {
+		return nil
+	}
+	ts := append([]uint64(nil), thresholds...)
+	sort.Slice(ts, func(i, j int) bool { return ts[i] < ts[j] })
+	return &milestoneTracker{
+		clock:      SystemClock{},
+		window:     window,
+		thresholds: ts,
+		handle:     h,
+		users:      make(map[string]*userTransfer),
+	}
+}
+
+// counter returns the function to count the bytes relayed by the user,
+// nil if not tracked, such as the user not authenticated by username.
+func (sf *milestoneTracker) counter(user string) func(n int) {
+	if sf == nil || user == "" {
+		return nil
+	}
+	return func(n int) { sf.add(user, n) }
+}
+
+func (sf *milestoneTracker) add(user string, n int) {
+	if n <= 0 {
+		return
+	}
+	now := sf.clock.Now()
+	var fired []Milestone
+
+	sf.mu.Lock()
+	u, ok := sf.users[user]
+	if !ok || (sf.window > 0 && now.Sub(u.start) >= sf.window) {
+		u = &userTransfer{start: now}
+		sf.users[user] = u
+		sf.sweep(now)
+	}
+	u.bytes += uint64(n)
+	for u.next < len(sf.thresholds) && u.bytes >= sf.thresholds[u.next] {
+		fired = append(fired, Milestone{
+			User:        user,
+			Threshold:   sf.thresholds[u.next],
+			Bytes:       u.bytes,
+			WindowStart: u.start,
+			Time:        now,
+		})
+		u.next++
+	}
+	sf.mu.Unlock()
+
+	for _, m := range fired {
+		sf.handle(m)
+	}
+}
+
+// sweep removes the users whose window expired, at most once per window,
+// so the users map is bounded by the users active within the last two windows.
+func (sf *milestoneTracker) sweep(now time.Time) {
+	if sf.swept.IsZero() {
+		sf.swept = now
+	}
+	if sf.window <= 0 || now.Sub(sf.swept) < sf.window {
+		return
+	}
+	sf.swept = now
+	for user, u := range sf.users {
+		if now.Sub(u.start) >= sf.window {
+			delete(sf.users, user)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package socks5
+
+import (
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMilestoneTracker(t *testing.T) {
+	var fired []Milestone
+	tracker := newMilestoneTracker(0, []uint64{100, 10}, func(m Milestone) { fired = append(fired, m) })
+	require.Nil(t, tracker.counter(""))
+
+	count := tracker.counter("foo")
+	count(5)
+	require.Empty(t, fired)
+	count(6)
+	require.Len(t, fired, 1)
+	require.Equal(t, "foo", fired[0].User)
+	require.Equal(t, uint64(10), fired[0].Threshold)
+	require.Equal(t, uint64(11), fired[0].Bytes)
+
+	// crosses once only
+	count(1)
+	require.Len(t, fired, 1)
+	tracker.counter("bar")(200)
+	require.Len(t, fired, 3)
+	require.Equal(t, uint64(100), fired[2].Threshold)
+
+	var nilTracker *milestoneTracker
+	require.Nil(t, nilTracker.counter("foo"))
+	require.Nil(t, newMilestoneTracker(time.Hour, nil, func(Milestone) {}))
+}
+
+func TestMilestoneTracker_Window(t *testing.T) {
+	var fired []Milestone
+	tracker := newMilestoneTracker(20*time.Millisecond, []uint64{10}, func(m Milestone) { fired = append(fired, m) })
+
+	count := tracker.counter("foo")
+	count(10)
+	require.Len(t, fired, 1)
+	count(10)
+	require.Len(t, fired, 1)
+
+	// the window reset
+	time.Sleep(30 * time.Millisecond)
+	tracker.counter("bar")(1)
+	require.Len(t, tracker.users, 1)
+	count(10)
+	require.Len(t, fired, 2)
+	require.True(t, fired[1].WindowStart.After(fired[0].WindowStart))
+}
+
+func TestSession_Transfer(t *testing.T) {
+	var transferred int
+	sess := &session{onTransfer: func(_ bool, n int) { transferred += n }}
+	_, err := sess.upWriter(ioutil.Discard).Write([]byte("ping"))
+	require.NoError(t, err)
+	_, err = sess.downWriter(ioutil.Discard).Write([]byte("pong"))
+	require.NoError(t, err)
+	sess.countUp(2)
+	require.Equal(t, 10, transferred)
+}
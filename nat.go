@@ -0,0 +1,301 @@
+package socks5
+
+import (
+	"container/list"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// UDPFlow is a snapshot of an udp flow in the NAT table of an association
+type UDPFlow struct {
+	// Client address of the flow
+	Client net.Addr
+	// Target address of the flow
+	Target net.Addr
+	// Created time of the flow
+	Created time.Time
+	// LastActive time of the flow
+	LastActive time.Time
+	// Datagrams and bytes from client to target
+	PacketsUp uint64
+	BytesUp   uint64
+	// Datagrams and bytes from target to client
+	PacketsDown uint64
+	BytesDown   uint64
+}
+
+// UDPFlowEvictReason is the reason why an udp flow is removed from the NAT table
+type UDPFlowEvictReason uint8
+
+// udp flow evict reason defined
+const (
+	// UDPFlowIdle the flow has no datagram in either direction for the idle timeout
+	UDPFlowIdle UDPFlowEvictReason = iota
+	// UDPFlowLimit the least recently used flow is evicted by the per association or global limit
+	UDPFlowLimit
+	// UDPFlowError the flow failed to read from or write to the target
+	UDPFlowError
+	// UDPFlowClosed the association ended
+	UDPFlowClosed
+)
+
+// String implement interface fmt.Stringer
+func (r UDPFlowEvictReason) String() string {
+	switch r {
+	case UDPFlowIdle:
+		return "idle"
+	case UDPFlowLimit:
+		return "limit"
+	case UDPFlowError:
+		return "error"
+	case UDPFlowClosed:
+		return "closed"
+	}
+	return "unknown"
+}
+
+// udpFlow is the udp flow from a client address to a target
+type udpFlow struct {
+	clock   Clock
+	key     string
+	client  net.Addr
+	target  net.Conn
+	created time.Time
+	// counters, updated atomically
+	lastActive  int64 // unix nano
+	packetsUp   uint64
+	bytesUp     uint64
+	packetsDown uint64
+	bytesDown   uint64
+}
+
+func newUDPFlow(clock Clock, key string, client net.Addr, target net.Conn) *udpFlow {
+	now := clock.Now()
+	return &udpFlow{
+		clock:      clock,
+		key:        key,
+		client:     client,
+		target:     target,
+		created:    now,
+		lastActive: now.UnixNano(),
+	}
+}
+
+// countUp counts a datagram from client to target
+func (sf *udpFlow) countUp(n int) {
+	atomic.StoreInt64(&sf.lastActive, sf.clock.Now().UnixNano())
+	atomic.AddUint64(&sf.packetsUp, 1)
+	atomic.AddUint64(&sf.bytesUp, uint64(n))
+}
+
+// countDown counts a datagram from target to client
+func (sf *udpFlow) countDown(n int) {
+	atomic.StoreInt64(&sf.lastActive, sf.clock.Now().UnixNano())
+	atomic.AddUint64(&sf.packetsDown, 1)
+	atomic.AddUint64(&sf.bytesDown, uint64(n))
+}
+
+func (sf *udpFlow) snapshot() UDPFlow {
+	return UDPFlow{
+		Client:      sf.client,
+		Target:      sf.target.RemoteAddr(),
+		Created:     sf.created,
+		LastActive:  time.Unix(0, atomic.LoadInt64(&sf.lastActive)),
+		PacketsUp:   atomic.LoadUint64(&sf.packetsUp),
+		BytesUp:     atomic.LoadUint64(&sf.bytesUp),
+		PacketsDown: atomic.LoadUint64(&sf.packetsDown),
+		BytesDown:   atomic.LoadUint64(&sf.bytesDown),
+	}
+}
+
+// natTable is the flow table of an udp association,
+// bounded with least-recently-used eviction.
+type natTable struct {
+	srv   *Server
+	sess  *session
+	mem   *memoryBudget
+	mu    sync.Mutex
+	ll    *list.List
+	flows map[string]*list.Element
+	// client is the udp endpoint of the client learned from the first datagram
+	client *net.UDPAddr
+	// connRate and rateKey limit the bandwidth of the association, see WithRateLimiter
+	connRate *tokenBucket
+	rateKey  string
+	// evicted is the flows removed but not yet notified to the evict handle, guarded by mu
+	evicted []evictedFlow
+}
+
+type evictedFlow struct {
+	flow   *udpFlow
+	reason UDPFlowEvictReason
+}
+
+func newNatTable(srv *Server) *natTable {
+	return &natTable{
+		srv:   srv,
+		ll:    list.New(),
+		flows: make(map[string]*list.Element),
+	}
+}
+
+// learnClient learns the udp endpoint of the client from the first datagram, which
+// must come from the peer ip if not nil, and reports whether the datagram is from the client.
+func (sf *natTable) learnClient(src net.Addr, peer net.IP) bool {
+	addr, ok := src.(*net.UDPAddr)
+	if !ok {
+		return false
+	}
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	if sf.client == nil {
+		if peer != nil && !peer.Equal(addr.IP) {
+			return false
+		}
+		sf.client = addr
+		return true
+	}
+	return sf.client.Port == addr.Port && sf.client.IP.Equal(addr.IP)
+}
+
+// get returns the flow of the key and marks it most recently used
+func (sf *natTable) get(key string) (*udpFlow, bool) {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	e, ok := sf.flows[key]
+	if !ok {
+		return nil, false
+	}
+	sf.ll.MoveToFront(e)
+	return e.Value.(*udpFlow), true
+}
+
+// add the flow, the least recently used flows are evicted when the per association
+// or global limit is hit. It returns false if the global limit is hit and
+// the table has nothing to evict.
+func (sf *natTable) add(f *udpFlow) bool {
+	defer sf.notify()
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	if max := sf.srv.udpMaxFlows; max > 0 {
+		for sf.ll.Len() >= max {
+			sf.evictOldest()
+		}
+	}
+	if max := int64(sf.srv.udpMaxGlobalFlows); max > 0 {
+		for atomic.LoadInt64(&sf.srv.udpGlobalFlows) >= max {
+			if sf.ll.Len() == 0 {
+				return false
+			}
+			sf.evictOldest()
+		}
+	}
+	sf.flows[f.key] = sf.ll.PushFront(f)
+	atomic.AddInt64(&sf.srv.udpGlobalFlows, 1)
+	return true
+}
+
+// remove the flow if it is still in the table, and close the flow
+func (sf *natTable) remove(f *udpFlow, reason UDPFlowEvictReason) {
+	sf.mu.Lock()
+	if e, ok := sf.flows[f.key]; ok && e.Value.(*udpFlow) == f {
+		sf.removeElement(e, reason)
+	}
+	sf.mu.Unlock()
+	f.target.Close()
+	sf.notify()
+}
+
+// close removes and closes all flows
+func (sf *natTable) close() {
+	sf.mu.Lock()
+	for e := sf.ll.Front(); e != nil; e = sf.ll.Front() {
+		sf.removeElement(e, UDPFlowClosed)
+		e.Value.(*udpFlow).target.Close()
+	}
+	sf.mu.Unlock()
+	sf.notify()
+}
+
+// expireIdle removes the flows idle for the timeout periodically, until done is closed
+func (sf *natTable) expireIdle(timeout time.Duration, done <-chan struct{}) {
+	interval := timeout / 2
+	if interval < time.Second {
+		interval = time.Second
+	}
+	ticker := sf.srv.clock.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C():
+		}
+		deadline := sf.srv.clock.Now().Add(-timeout).UnixNano()
+		sf.mu.Lock()
+		for e := sf.ll.Back(); e != nil; {
+			prev := e.Prev()
+			if f := e.Value.(*udpFlow); atomic.LoadInt64(&f.lastActive) <= deadline {
+				sf.removeElement(e, UDPFlowIdle)
+				f.target.Close()
+			}
+			e = prev
+		}
+		sf.mu.Unlock()
+		sf.notify()
+	}
+}
+
+// notify calls the evict handle with the flows removed, must be called without the lock held
+func (sf *natTable) notify() {
+	sf.mu.Lock()
+	evicted := sf.evicted
+	sf.evicted = nil
+	sf.mu.Unlock()
+	for _, ev := range evicted {
+		sf.srv.udpFlowEvictHandle(ev.flow.snapshot(), ev.reason)
+	}
+}
+
+// snapshot returns the snapshot of the flows, most recently used first
+func (sf *natTable) snapshot() []UDPFlow {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	flows := make([]UDPFlow, 0, sf.ll.Len())
+	for e := sf.ll.Front(); e != nil; e = e.Next() {
+		flows = append(flows, e.Value.(*udpFlow).snapshot())
+	}
+	return flows
+}
+
+// UDPFlows returns the snapshot of the udp flows of all associations,
+// like conntrack, it is useful to debug associate issues.
+func (sf *Server) UDPFlows() []UDPFlow {
+	var flows []UDPFlow
+	sf.udpTables.Range(func(key, _ interface{}) bool {
+		flows = append(flows, key.(*natTable).snapshot()...)
+		return true
+	})
+	return flows
+}
+
+func (sf *natTable) evictOldest() {
+	e := sf.ll.Back()
+	sf.removeElement(e, UDPFlowLimit)
+	e.Value.(*udpFlow).target.Close()
+	if m, ok := sf.srv.metrics.(NATMetrics); ok {
+		m.IncNATEviction()
+	}
+}
+
+func (sf *natTable) removeElement(e *list.Element, reason UDPFlowEvictReason) {
+	f := e.Value.(*udpFlow)
+	sf.ll.Remove(e)
+	delete(sf.flows, f.key)
+	atomic.AddInt64(&sf.srv.udpGlobalFlows, -1)
+	if sf.srv.udpFlowEvictHandle != nil {
+		sf.evicted = append(sf.evicted, evictedFlow{f, reason})
+	}
+}
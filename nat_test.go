@@ -0,0 +1,144 @@
+package socks5
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newTestFlow(key string) *udpFlow {
+	c1, c2 := net.Pipe()
+	c2.Close()
+	return newUDPFlow(SystemClock{}, key, nil, c1)
+}
+
+func TestNatTable_LRU(t *testing.T) {
+	m := newMockMetrics()
+	srv := NewServer(WithUDPNATLimit(2, 0), WithMetrics(m))
+	table := newNatTable(srv)
+
+	require.True(t, table.add(newTestFlow("a")))
+	require.True(t, table.add(newTestFlow("b")))
+	_, ok := table.get("a")
+	require.True(t, ok)
+	require.True(t, table.add(newTestFlow("c")))
+
+	_, ok = table.get("b")
+	require.False(t, ok)
+	_, ok = table.get("a")
+	require.True(t, ok)
+	require.Equal(t, 1, m.evictions)
+	require.Equal(t, int64(2), srv.udpGlobalFlows)
+
+	table.close()
+	require.Equal(t, int64(0), srv.udpGlobalFlows)
+}
+
+func TestNatTable_GlobalLimit(t *testing.T) {
+	srv := NewServer(WithUDPNATLimit(0, 1))
+	table1 := newNatTable(srv)
+	table2 := newNatTable(srv)
+
+	require.True(t, table1.add(newTestFlow("a")))
+	require.False(t, table2.add(newTestFlow("b")))
+	require.True(t, table1.add(newTestFlow("c")))
+	_, ok := table1.get("a")
+	require.False(t, ok)
+
+	f := newTestFlow("d")
+	table1.remove(f, UDPFlowError)
+	require.Equal(t, int64(1), srv.udpGlobalFlows)
+}
+
+func TestServer_UDPFlows(t *testing.T) {
+	srv := NewServer()
+	table := newNatTable(srv)
+	srv.udpTables.Store(table, struct{}{})
+
+	f := newTestFlow("a")
+	require.True(t, table.add(f))
+	f.countUp(4)
+	f.countDown(8)
+	f.countDown(8)
+
+	flows := srv.UDPFlows()
+	require.Len(t, flows, 1)
+	require.Equal(t, uint64(1), flows[0].PacketsUp)
+	require.Equal(t, uint64(4), flows[0].BytesUp)
+	require.Equal(t, uint64(2), flows[0].PacketsDown)
+	require.Equal(t, uint64(16), flows[0].BytesDown)
+	require.False(t, flows[0].LastActive.Before(flows[0].Created))
+}
+
+func TestNatTable_LearnClient(t *testing.T) {
+	client := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 5000}
+	other := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 5001}
+
+	table := newNatTable(NewServer())
+	require.True(t, table.learnClient(client, nil))
+	require.True(t, table.learnClient(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 5000}, nil))
+	require.False(t, table.learnClient(other, nil))
+
+	table = newNatTable(NewServer())
+	require.False(t, table.learnClient(&net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 5000}, client.IP))
+	require.True(t, table.learnClient(other, client.IP))
+	require.False(t, table.learnClient(client, client.IP))
+}
+
+func TestNatTable_IdleTimeout(t *testing.T) {
+	clock := newManualClock()
+	var mu sync.Mutex
+	evicted := make(map[string]UDPFlowEvictReason)
+	srv := NewServer(WithClock(clock), WithUDPNATLimit(2, 0), WithUDPFlowTimeout(10*time.Second),
+		WithUDPFlowEvictHandle(func(flow UDPFlow, reason UDPFlowEvictReason) {
+			mu.Lock()
+			evicted[fmt.Sprint(flow.PacketsUp)] = reason
+			mu.Unlock()
+		}))
+	table := newNatTable(srv)
+	done := make(chan struct{})
+	defer close(done)
+	go table.expireIdle(srv.udpFlowIdleTimeout, done)
+
+	newFlow := func(key string, packets int) *udpFlow {
+		c1, c2 := net.Pipe()
+		c2.Close()
+		f := newUDPFlow(clock, key, nil, c1)
+		for i := 0; i < packets; i++ {
+			f.countUp(1)
+		}
+		require.True(t, table.add(f))
+		return f
+	}
+	newFlow("a", 1)
+	active := newFlow("b", 2)
+	newFlow("c", 3) // evicts a by the limit
+	require.Eventually(t, func() bool {
+		clock.mu.Lock()
+		defer clock.mu.Unlock()
+		return len(clock.timers) == 1
+	}, time.Second, 10*time.Millisecond)
+
+	clock.Advance(6 * time.Second)
+	active.countDown(1)
+	clock.Advance(6 * time.Second)
+	require.Eventually(t, func() bool {
+		_, ok := table.get("c")
+		return !ok
+	}, time.Second, 10*time.Millisecond)
+	_, ok := table.get("b")
+	require.True(t, ok)
+
+	table.close()
+	mu.Lock()
+	defer mu.Unlock()
+	require.Equal(t, map[string]UDPFlowEvictReason{
+		"1": UDPFlowLimit,
+		"2": UDPFlowClosed,
+		"3": UDPFlowIdle,
+	}, evicted)
+}
@@ -0,0 +1,55 @@
+package socks5
+
+import (
+	"errors"
+	"io"
+	"syscall"
+)
+
+// winsock errors of the aborted and reset connection
+const (
+	wsaECONNABORTED = syscall.Errno(10053)
+	wsaECONNRESET   = syscall.Errno(10054)
+)
+
+// clientNoiseError is the error caused by the client which left early, such as the
+// high-churn scanners, counted as the client noise rather than logged.
+type clientNoiseError struct {
+	err error
+}
+
+func (sf *clientNoiseError) Error() string { return sf.err.Error() }
+
+func (sf *clientNoiseError) Unwrap() error { return sf.err }
+
+// isClientNoise reports whether the error is caused by the client which aborted,
+// reset or closed the connection, such as ECONNABORTED and WSAECONNRESET. The errors
+// of the client read path wrap with %w, the others never count as the client noise.
+func isClientNoise(err error) bool {
+	if err == nil {
+		return false
+	}
+	var errno syscall.Errno
+	if errors.As(err, &errno) {
+		switch errno {
+		case syscall.ECONNABORTED, syscall.ECONNRESET, syscall.EPIPE, wsaECONNABORTED, wsaECONNRESET:
+			return true
+		}
+	}
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	return false
+}
+
+// clientNoise counts the client noise of the phase, notifies the client noise handle,
+// and returns the error marked as the client noise.
+func (sf *Server) clientNoise(phase Phase, err error) error {
+	if m, ok := sf.metrics.(ClientNoiseMetrics); ok {
+		m.IncClientNoise(phase)
+	}
+	if sf.clientNoiseHandle != nil {
+		sf.clientNoiseHandle(phase, err)
+	}
+	return &clientNoiseError{err}
+}
@@ -0,0 +1,96 @@
+package socks5
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/thinkgos/go-socks5/statute"
+)
+
+func TestIsClientNoise(t *testing.T) {
+	opErr := func(errno syscall.Errno) error {
+		return &net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("read", errno)}
+	}
+	for _, err := range []error{
+		opErr(syscall.ECONNRESET),
+		opErr(syscall.ECONNABORTED),
+		opErr(wsaECONNRESET),
+		&net.OpError{Op: "accept", Net: "tcp", Err: os.NewSyscallError("accept", syscall.ECONNABORTED)},
+		io.EOF,
+		fmt.Errorf("failed to get command version, %w", io.ErrUnexpectedEOF),
+		fmt.Errorf("failed to get method, %w", opErr(syscall.ECONNRESET)),
+	} {
+		assert.True(t, isClientNoise(err), err.Error())
+	}
+	for _, err := range []error{
+		nil,
+		errors.New("closed"),
+		statute.ErrNoSupportedAuth,
+		opErr(syscall.EMFILE),
+		fmt.Errorf("connect to target, %v", io.EOF),
+		errors.New("remote error: tls: unexpected EOF"),
+	} {
+		assert.False(t, isClientNoise(err), fmt.Sprint(err))
+	}
+}
+
+func TestServer_ClientNoise(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	metrics := newMockMetrics()
+	logger := &bufferLogger{}
+	noise := make(chan Phase, 1)
+	srv := NewServer(
+		WithMetrics(metrics),
+		WithLogger(logger),
+		WithClientNoiseHandle(func(phase Phase, err error) {
+			noise <- phase
+		}),
+	)
+	go srv.Serve(l) // nolint: errcheck
+	defer l.Close()
+
+	// the scanner leaves before the method request completes
+	conn, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	_, err = conn.Write([]byte{statute.VersionSocks5})
+	require.NoError(t, err)
+	conn.Close()
+
+	select {
+	case phase := <-noise:
+		require.Equal(t, PhaseNegotiation, phase)
+	case <-time.After(time.Second):
+		t.Fatal("client noise not notified")
+	}
+	time.Sleep(10 * time.Millisecond)
+	require.Empty(t, logger.String())
+	require.Equal(t, 0, len(metrics.errors[PhaseNegotiation]))
+}
+
+func TestServer_AcceptClientNoise(t *testing.T) {
+	metrics := newMockMetrics()
+	var delays []time.Duration
+	srv := NewServer(
+		WithMetrics(metrics),
+		WithAcceptBackoff(time.Millisecond, 3*time.Millisecond),
+		WithAcceptErrorHandle(func(err error, delay time.Duration) {
+			delays = append(delays, delay)
+		}),
+	)
+	aborted := &net.OpError{Op: "accept", Net: "tcp", Err: os.NewSyscallError("accept", syscall.ECONNABORTED)}
+	err := srv.Serve(&errListener{errs: []error{aborted, aborted}})
+	require.EqualError(t, err, "closed")
+	require.Empty(t, delays)
+	require.Equal(t, 2, metrics.noise[PhaseNegotiation])
+}
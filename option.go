@@ -2,23 +2,66 @@ package socks5
 
 import (
 	"context"
+	"crypto/tls"
 	"io"
 	"net"
+	"strings"
+	"time"
 
 	"github.com/thinkgos/go-socks5/bufferpool"
+	"github.com/thinkgos/go-socks5/statute"
 )
 
 // Option user's option
 type Option func(s *Server)
 
-// WithBufferPool can be provided to implement custom buffer pool
+// WithBufferPool can be provided to implement custom buffer pool of the tcp relay
 // By default, buffer pool use size is 32k
+// The pool implements bufferpool.Sizer so no buffer is taken when the memory limit exceeded.
 func WithBufferPool(bufferPool bufferpool.BufPool) Option {
 	return func(s *Server) {
 		s.bufferPool = bufferPool
 	}
 }
 
+// WithDatagramBufferPool can be provided to implement custom buffer pool of the udp datagrams
+// relayed by ASSOCIATE, the datagram larger than the buffer is truncated, the buffer smaller than
+// the max datagram header plus 512 bytes is not used for the datagrams from the targets.
+// By default, buffer pool use size is 32k
+func WithDatagramBufferPool(bufferPool bufferpool.BufPool) Option {
+	return func(s *Server) {
+		s.datagramPool = bufferPool
+	}
+}
+
+// WithBufferSize sets the buffer size of the tcp relay and of the udp datagrams,
+// 0 keeps the pool of the size, such as the custom pool. The datagram buffer is at least
+// the max datagram header plus 512 bytes, the smaller size is raised to it.
+func WithBufferSize(relay, datagram int) Option {
+	return func(s *Server) {
+		s.relayBufferSize, s.datagramBufferSize = relay, datagram
+		if relay > 0 {
+			s.bufferPool = bufferpool.NewPool(relay)
+		}
+		if datagram > 0 {
+			if datagram < minDatagramBufferSize {
+				datagram = minDatagramBufferSize
+			}
+			s.datagramPool = bufferpool.NewPool(datagram)
+		}
+	}
+}
+
+// WithZeroCopy relays the CONNECT and BIND sessions by the splice(2) on linux, the data is not
+// copied to the userspace, when both legs are the plain tcp connections and nothing observes
+// the data relayed, no TLS, compression, rate limit, relay timeouts, stall watchdog, idle timeout
+// nor shadow. The bytes relayed are counted per 64k spliced. No effect on the other platforms.
+func WithZeroCopy() Option {
+	return func(s *Server) {
+		s.zeroCopy = true
+	}
+}
+
 // WithAuthMethods can be provided to implement custom authentication
 // By default, "auth-less" mode is enabled.
 // For password-based auth use UserPassAuthenticator.
@@ -41,7 +84,7 @@ func WithCredential(cs CredentialStore) Option {
 }
 
 // WithResolver can be provided to do custom name resolution.
-// Defaults to DNSResolver if not provided.
+// Defaults to DNSResolver if not provided, Server.SetResolver replaces it at runtime.
 func WithResolver(res NameResolver) Option {
 	return func(s *Server) {
 		s.resolver = res
@@ -49,7 +92,7 @@ func WithResolver(res NameResolver) Option {
 }
 
 // WithRule is provided to enable custom logic around permitting
-// various commands. If not provided, NewPermitAll is used.
+// various commands. If not provided, NewPermitAll is used, Server.SetRules replaces it at runtime.
 func WithRule(rule RuleSet) Option {
 	return func(s *Server) {
 		s.rules = rule
@@ -65,6 +108,34 @@ func WithRewriter(rew AddressRewriter) Option {
 	}
 }
 
+// WithForward enable the static forwarding mode, the server ignores the requested
+// destination of CONNECT and always connects to the target, acting as an authenticated
+// TCP forwarder with SOCKS framing. perUser optional selects the target by the username,
+// falls back to target if the user is not found. The rewriter is not invoked in this mode.
+// The targets are parsed once, the invalid one is reported by Validate, and the request which
+// selects it is refused rather than connected to the requested destination.
+func WithForward(target string, perUser map[string]string) Option {
+	return func(s *Server) {
+		s.forwardTarget = nil
+		if target != "" {
+			s.forwardTarget = newForwardTarget(target)
+		}
+		s.forwardUserTargets = make(map[string]*forwardTarget, len(perUser))
+		for user, t := range perUser {
+			s.forwardUserTargets[user] = newForwardTarget(t)
+		}
+	}
+}
+
+// WithOverride can be used to replace the destination entirely.
+// This is invoked after the RuleSet is invoked, unlike the rewriter, the destination overridden
+// is not checked by the rules again, the FQDN overridden is resolved by the NameResolver.
+func WithOverride(o DestinationOverride) Option {
+	return func(s *Server) {
+		s.override = o
+	}
+}
+
 // WithBindIP is used for bind or udp associate
 func WithBindIP(ip net.IP) Option {
 	return func(s *Server) {
@@ -75,6 +146,62 @@ func WithBindIP(ip net.IP) Option {
 	}
 }
 
+// WithUDPNATLimit limits the udp flows of an association and of all associations,
+// the least recently used flows are evicted when the limit is hit. 0 means no limit.
+func WithUDPNATLimit(perAssociation, global int) Option {
+	return func(s *Server) {
+		s.udpMaxFlows = perAssociation
+		s.udpMaxGlobalFlows = global
+	}
+}
+
+// WithUDPFlowTimeout removes the udp flows of the NAT table which have no datagram in either
+// direction for the idle timeout, so the association keeps its flows of the long-lived peers,
+// such as gaming or VoIP, while the silent ones are released. 0 means never, the default.
+func WithUDPFlowTimeout(idle time.Duration) Option {
+	return func(s *Server) {
+		s.udpFlowIdleTimeout = idle
+	}
+}
+
+// WithUDPFlowEvictHandle sets the handle called with the final snapshot and the reason
+// when an udp flow is removed from the NAT table, such as for the accounting of the flows.
+func WithUDPFlowEvictHandle(h func(flow UDPFlow, reason UDPFlowEvictReason)) Option {
+	return func(s *Server) {
+		s.udpFlowEvictHandle = h
+	}
+}
+
+// WithUDPRateLimit limits the datagrams per second and the bytes per second which the
+// client of each association sends, independently of the tcp limits. The datagrams over
+// the limit are dropped, the bytes burst at least the max datagram of 64k. 0 means no limit.
+func WithUDPRateLimit(packetsPerSecond, bytesPerSecond int) Option {
+	return func(s *Server) {
+		s.udpPacketRate = packetsPerSecond
+		s.udpByteRate = bytesPerSecond
+	}
+}
+
+// WithUDPMaxDatagram limits the size of the datagram relayed to the client, such as
+// the downstream MTU. The oversized datagram is dropped, truncated or fragmented per policy,
+// and counted by the Metrics. 0 means no limit.
+func WithUDPMaxDatagram(size int, policy UDPOversizePolicy) Option {
+	return func(s *Server) {
+		s.udpMaxDatagram = size
+		s.udpOversizePolicy = policy
+	}
+}
+
+// WithUDPFragment sets the policy of the fragmented datagrams from the client, whose FRAG is not 0.
+// The fragments are dropped by default. The reassembly drops the incomplete datagram once its
+// reassembly timer expired, 0 means 5 seconds. The fragments dropped are counted by the Metrics.
+func WithUDPFragment(policy UDPFragmentPolicy, reassemblyTimeout time.Duration) Option {
+	return func(s *Server) {
+		s.udpFragmentPolicy = policy
+		s.udpReassemblyTimeout = reassemblyTimeout
+	}
+}
+
 // WithLogger can be used to provide a custom log target.
 // Defaults to ioutil.Discard.
 func WithLogger(l Logger) Option {
@@ -83,10 +210,50 @@ func WithLogger(l Logger) Option {
 	}
 }
 
+// WithStructuredLogger writes the leveled and structured logs, such as the session end with
+// the session id, the request and the bytes relayed, the messages of the Logger are written
+// as the errors. *slog.Logger implements it, NewZapLogger and LoggerFunc adapt zap and logrus.
+func WithStructuredLogger(l StructuredLogger) Option {
+	return func(s *Server) {
+		s.structured = l
+		s.logger = errorfLogger{l}
+	}
+}
+
 // WithDial Optional function for dialing out
+//
+// Deprecated: use WithDialer with DialFunc instead.
 func WithDial(dial func(ctx context.Context, network, addr string) (net.Conn, error)) Option {
+	return WithDialer(DialFunc(dial))
+}
+
+// WithDialer sets the dialer to dial out the targets, such as a ChainDialer through
+// the upstream proxies. Defaults to net.Dialer.
+func WithDialer(d Dialer) Option {
+	return func(s *Server) {
+		s.dialer = d
+	}
+}
+
+// WithAnswerFilter inspects and filters the addresses resolved from the FQDN destinations
+// before the rules and the dial, the filters apply in order, such as DropIPv6, DropBogons
+// or AnswerWithinCIDR, so the address checked by the rules is the address dialed.
+func WithAnswerFilter(filters ...AnswerFilter) Option {
+	return func(s *Server) {
+		s.answerFilters = append(s.answerFilters, filters...)
+	}
+}
+
+// WithHappyEyeballs races the connections to both the IPv6 and IPv4 addresses of the FQDN
+// destination of CONNECT by the Happy Eyeballs (RFC 8305), each attempt started after the
+// fallbackDelay, <= 0 means 250ms. The NameResolver must implement AddrResolver, such as
+// DNSResolver or CachingResolver, otherwise the single address resolved is dialed.
+func WithHappyEyeballs(fallbackDelay time.Duration) Option {
 	return func(s *Server) {
-		s.dial = dial
+		if fallbackDelay <= 0 {
+			fallbackDelay = defaultFallbackDelay
+		}
+		s.happyEyeballs = fallbackDelay
 	}
 }
 
@@ -97,6 +264,565 @@ func WithGPool(pool GPool) Option {
 	}
 }
 
+// WithMetrics can be used to export server metrics, the optional interfaces such as
+// TrafficMetrics the Metrics implements are observed too.
+// Defaults to NoopMetrics.
+func WithMetrics(m Metrics) Option {
+	return func(s *Server) {
+		s.metrics = m
+	}
+}
+
+// WithReplyDetail enable the reply detail extension toward the trusted networks,
+// a short machine-readable error detail is appended after a failure reply.
+// Only enable it for clients which can parse it, such as ccsocks5 with WithReplyDetail.
+func WithReplyDetail(trusted []*net.IPNet) Option {
+	return func(s *Server) {
+		s.replyDetailNets = append([]*net.IPNet{}, trusted...)
+	}
+}
+
+// WithReplyWriter writes the replies by the ReplyWriter created for each connection around w,
+// the writer of the connection, so the replies are framed and flushed by the embedder,
+// such as terminating SOCKS inside the websocket messages. The writer passed to the handles set
+// by WithConnectHandle, WithBindHandle and WithAssociateHandle implements the Flush too.
+func WithReplyWriter(f func(w io.Writer) ReplyWriter) Option {
+	return func(s *Server) {
+		s.replyWriter = f
+	}
+}
+
+// WithAuthEventHandle is notified of every authentication attempt with the method, username,
+// source address, result and latency, separate from the general logging.
+func WithAuthEventHandle(h func(AuthEvent)) Option {
+	return func(s *Server) {
+		s.authEventHandle = h
+	}
+}
+
+// WithAuthFailureDelay delays the auth failure replies by the delay plus a random jitter up to
+// the jitter, to slow down the online password guessing without banning the source.
+// The connection is held during the delay, which is bounded by WithHandshakeTimeout.
+func WithAuthFailureDelay(delay, jitter time.Duration) Option {
+	return func(s *Server) {
+		s.authFailureDelay = delay
+		s.authFailureJitter = jitter
+	}
+}
+
+// WithMemoryLimit bounds the internal buffering, such as the pipelined data and the relay buffers,
+// per session and globally, 0 means no limit. The session is rejected if the buffered data and
+// the minimal relay buffers exceed the limit, the relay degrades to the minimal buffers and
+// the UDP associate drops the datagrams to new targets when the limit is hit.
+func WithMemoryLimit(perSession, global int64) Option {
+	return func(s *Server) {
+		s.sessionMemory = perSession
+		s.memory = newMemoryBudget(global, nil)
+	}
+}
+
+// WithProtocols set the socks protocols served on the same listener, sniffed by the version byte,
+// only SOCKS5 by default. SOCKS4 has no authentication, it is served only when the no auth method
+// is enabled, with the USERID provided as the payload "userid" of the auth context.
+func WithProtocols(protocols ...Protocol) Option {
+	return func(s *Server) {
+		s.protocols = 0
+		for _, p := range protocols {
+			s.protocols |= p
+		}
+	}
+}
+
+// WithRateLimiter limits the bandwidth of the TCP relay and the UDP forwarding,
+// keyed by the auth username, or the client ip if not authenticated by username,
+// such as NewTokenBucketLimiter. The TCP relay waits and the datagram is dropped if limited.
+func WithRateLimiter(l RateLimiter) Option {
+	return func(s *Server) {
+		s.rateLimiter = l
+	}
+}
+
+// WithClientCountry looks up the country of the client source address by the GeoIP, such as
+// a CountryTable, and applies the policy of the country, keyed by the country code or CountryAny,
+// so the access, the auth methods required or the rate limit vary by where the clients originate.
+// The country is set to Request.ClientCountry, so the RuleSet can vary by it too.
+func WithClientCountry(geo GeoIP, policies map[string]CountryPolicy) Option {
+	return func(s *Server) {
+		s.geoIP = geo
+		s.countryPolicies = make(map[string]*CountryPolicy, len(policies))
+		for country, p := range policies {
+			p := p
+			s.countryPolicies[strings.ToUpper(country)] = &p
+		}
+	}
+}
+
+// WithConnRateLimit limits the bytes per second of each connection, both directions,
+// the UDP association counts as the connection. 0 means no limit.
+func WithConnRateLimit(bytesPerSecond int) Option {
+	return func(s *Server) {
+		s.connByteRate = bytesPerSecond
+	}
+}
+
+// WithAcceptFilter installs the filter to reject or tag the accepted connections
+// purely from the addresses, before any goroutine or buffer is allocated for them,
+// as the cheapest defense layer. The connection rejected is closed silently.
+func WithAcceptFilter(f AcceptFilter) Option {
+	return func(s *Server) {
+		s.acceptFilter = f
+	}
+}
+
+// WithDialHandle is notified of the chosen local and remote addresses, the resolved ip
+// and the dial duration on the connect path, see also Request.Dial.
+func WithDialHandle(h func(ctx context.Context, request *Request, info DialInfo)) Option {
+	return func(s *Server) {
+		s.dialHandle = h
+	}
+}
+
+// WithTrafficMeter is notified of the start and the end of the sessions with the byte counters,
+// the duration, the target address and the auth context, such as to bill the users.
+func WithTrafficMeter(m TrafficMeter) Option {
+	return func(s *Server) {
+		s.trafficMeter = m
+	}
+}
+
+// WithAccessLog writes a line of every session end, with the client, user, destination,
+// the egress address and the dial info, the bytes relayed and the close reason.
+func WithAccessLog(l AccessLogger) Option {
+	return func(s *Server) {
+		s.accessLogger = l
+	}
+}
+
+// WithAccessLogEncoder encodes the access log line of WithAccessLog by the encoder, the
+// SessionRecord schema shared with the other exporters, such as JSONEncoder.
+func WithAccessLogEncoder(enc RecordEncoder) Option {
+	return func(s *Server) {
+		s.accessLogEncoder = enc
+	}
+}
+
+// WithMilestones fires the handle when a user crosses the cumulative transfer thresholds
+// of both directions within the window, such as 1GB and 10GB a month, 0 window means no reset.
+// Only users authenticated by username are tracked, the handle is called synchronously
+// in the relay and should not block.
+func WithMilestones(window time.Duration, thresholds []uint64, h func(Milestone)) Option {
+	return func(s *Server) {
+		s.milestones = newMilestoneTracker(window, thresholds, h)
+	}
+}
+
+// WithSessionCloseHandle is notified of every session end, with the Session.CloseReason
+// and the error the session ended with, nil if the session ended normally.
+func WithSessionCloseHandle(h func(s Session, err error)) Option {
+	return func(s *Server) {
+		s.sessionCloseHandle = h
+	}
+}
+
+// WithClientNoiseHandle is notified of the client which aborted, reset or closed the connection
+// during accept or negotiation, such as ECONNABORTED and WSAECONNRESET of the high-churn scanners.
+// They are counted by the Metrics as the client noise rather than logged as errors.
+func WithClientNoiseHandle(h func(phase Phase, err error)) Option {
+	return func(s *Server) {
+		s.clientNoiseHandle = h
+	}
+}
+
+// WithAcceptBackoff set the delay range to back off on temporary accept errors,
+// such as EMFILE and ENFILE, the delay doubles from min up to max.
+// max 0 disables the backoff and Serve returns on any accept error.
+// Defaults to 5ms and 1s.
+func WithAcceptBackoff(min, max time.Duration) Option {
+	return func(s *Server) {
+		if min <= 0 {
+			min = 5 * time.Millisecond
+		}
+		s.acceptBackoffMin = min
+		s.acceptBackoffMax = max
+	}
+}
+
+// WithAcceptErrorHandle is used to be notified of the temporary accept errors
+// and the delay before the next accept.
+func WithAcceptErrorHandle(h func(err error, delay time.Duration)) Option {
+	return func(s *Server) {
+		s.acceptErrorHandle = h
+	}
+}
+
+// WithVirtualServer selects an entire config profile by the local address the client
+// connected to, so one process can serve many tenants. addr is ip:port or ip for any port.
+// It takes precedence over the listener's options.
+func WithVirtualServer(addr string, opts ...ListenerOption) Option {
+	return func(s *Server) {
+		if s.virtualServers == nil {
+			s.virtualServers = make(map[string]*listenerConfig)
+		}
+		if ip := net.ParseIP(addr); ip != nil {
+			addr = unmapIP(ip).String()
+		} else if host, port, err := net.SplitHostPort(addr); err == nil {
+			if ip := net.ParseIP(host); ip != nil {
+				addr = net.JoinHostPort(unmapIP(ip).String(), port)
+			}
+		}
+		c := newListenerConfig(opts...)
+		if c == nil {
+			c = &listenerConfig{}
+		}
+		s.virtualServers[addr] = c
+	}
+}
+
+// WithUsageReport generates the aggregated usage summaries per user and destination
+// on the interval, and delivers them to the report callback, such as for billing pipelines.
+// The report of the last interval is delivered on Shutdown or Close.
+func WithUsageReport(interval time.Duration, report func(UsageReport)) Option {
+	return func(s *Server) {
+		if interval > 0 && report != nil {
+			s.usage = newUsageCollector(interval, report)
+		}
+	}
+}
+
+// WithSymmetricDeadline mirrors the deadline to both legs of the CONNECT relay,
+// the deadline is extended by timeout on every read of either leg, so a stalled
+// peer on either side releases both sockets promptly.
+func WithSymmetricDeadline(timeout time.Duration) Option {
+	return func(s *Server) {
+		s.symmetricTimeout = timeout
+	}
+}
+
+// WithClientTimeout set the read and write timeout of the client leg of the CONNECT relay,
+// the deadline is set before every read or write. 0 means no timeout.
+func WithClientTimeout(read, write time.Duration) Option {
+	return func(s *Server) {
+		s.clientReadTimeout = read
+		s.clientWriteTimeout = write
+	}
+}
+
+// WithTargetTimeout set the read and write timeout of the target leg of the CONNECT relay,
+// the deadline is set before every read or write. 0 means no timeout.
+func WithTargetTimeout(read, write time.Duration) Option {
+	return func(s *Server) {
+		s.targetReadTimeout = read
+		s.targetWriteTimeout = write
+	}
+}
+
+// WithPipelinedData controls the bytes the client sends immediately after the CONNECT request,
+// before the reply. If allow, they are buffered and forwarded after a successful dial, up to
+// maxBuffered bytes, otherwise the request is rejected. Defaults to allow with no limit.
+// If denied or limited, the CONNECT waits up to 5ms for the data pipelined before the dial.
+func WithPipelinedData(allow bool, maxBuffered int) Option {
+	return func(s *Server) {
+		s.pipelinedDeny = !allow
+		s.pipelinedMax = maxBuffered
+	}
+}
+
+// WithEarlyData enables the fast-open CONNECT, the data the client pipelines after
+// the request header is written to the target as soon as connected, before the reply.
+// The data not buffered yet when connected, or not decapsulated yet by GSSAPI, is relayed
+// after the reply as usual.
+func WithEarlyData(enable bool) Option {
+	return func(s *Server) {
+		s.earlyData = enable
+	}
+}
+
+// WithMaxConnections caps the connections served concurrently, so the server does not exhaust
+// the file descriptors under load, the connections over the limit are delayed or refused by
+// the policy. It applies to all the listeners served, see WithPerIPLimit for the limits per
+// client ip. 0 means no limit.
+func WithMaxConnections(n int, policy OverloadPolicy) Option {
+	return func(s *Server) {
+		s.connSlots = nil
+		if n > 0 {
+			s.connSlots = make(chan struct{}, n)
+		}
+		s.overloadPolicy = policy
+	}
+}
+
+// WithPerIPLimit caps the concurrent un-finished handshakes and active sessions per source ip,
+// separately from the global connection limit of WithMaxConnections, such as generous session limits but tight
+// handshake limits for NATed office networks. 0 means no limit.
+func WithPerIPLimit(handshakes, sessions int) Option {
+	return func(s *Server) {
+		s.perIP = newPerIPLimiter(handshakes, sessions)
+	}
+}
+
+// WithRequestHeaderLimit limits the slow clients which trickle the request header bytes,
+// such as scanners and slowloris. The client must deliver the request header within the timeout
+// and the maxReads reads from the connection, otherwise it is counted as a PhaseRequest error
+// and closed. 0 means no limit.
+func WithRequestHeaderLimit(timeout time.Duration, maxReads int) Option {
+	return func(s *Server) {
+		s.requestHeaderTimeout = timeout
+		s.requestHeaderMaxReads = maxReads
+	}
+}
+
+// WithHandshakeTimeout limits the time of the method negotiation, the auth and the request parsing,
+// including the TLS handshake, so the clients connecting and going silent are closed. 0 means no limit.
+func WithHandshakeTimeout(timeout time.Duration) Option {
+	return func(s *Server) {
+		s.handshakeTimeout = timeout
+	}
+}
+
+// WithDialTimeout limits the dial of the targets, the timeout is replied with RepTTLExpired.
+// 0 means no limit except the dialer's.
+func WithDialTimeout(timeout time.Duration) Option {
+	return func(s *Server) {
+		s.dialTimeout = timeout
+	}
+}
+
+// WithIdleTimeout closes the sessions of any command relaying no byte in either direction for
+// the timeout, with CloseReasonIdleTimeout, checked every quarter of the timeout, at least 10ms.
+// 0 means never.
+func WithIdleTimeout(timeout time.Duration) Option {
+	return func(s *Server) {
+		s.idleTimeout = timeout
+	}
+}
+
+// WithBindConfig sets the default config of the BIND command, such as the accept timeout
+// and the tcp keepalives, a RuleSet can override it per rule with WithBindContext.
+func WithBindConfig(cfg BindConfig) Option {
+	return func(s *Server) {
+		s.bind = cfg
+	}
+}
+
+// WithBindAddrPool sets the addresses the BIND listens on, host:port with 0 port for any port,
+// tried in turn until one is available. Defaults to the bind ip or the local ip of the client connection.
+func WithBindAddrPool(addrs []string) Option {
+	return func(s *Server) {
+		s.bindAddrPool = append([]string(nil), addrs...)
+	}
+}
+
+// WithBindFilter restricts which clients may use BIND, the request is refused if false returned.
+func WithBindFilter(f func(ctx context.Context, request *Request) bool) Option {
+	return func(s *Server) {
+		s.bindFilter = f
+	}
+}
+
+// WithAssociateIPv6 binds the ASSOCIATE udp relay on the local address of the client
+// connection, a client connected over IPv6 gets an ATYPIPv6 reply and an IPv4 client gets
+// an ATYPIPv4 reply. Defaults to bind the relay on the unspecified address.
+func WithAssociateIPv6(prefer bool) Option {
+	return func(s *Server) {
+		s.associatePreferIPv6 = prefer
+	}
+}
+
+// WithAssociateStrict only relays the datagrams to the destination given in the
+// ASSOCIATE request and drops the others, for associate-per-destination semantics.
+// The FQDN given matches the datagrams to any of the addresses it resolved to.
+func WithAssociateStrict(strict bool) Option {
+	return func(s *Server) {
+		s.associateStrict = strict
+	}
+}
+
+// WithAssociatePeerOnly restricts learning the client udp endpoint of the association
+// to the datagram from the tcp peer's ip. The association learns the client endpoint from
+// the first datagram, as most clients declare 0.0.0.0:0 in the ASSOCIATE request, and
+// drops the datagrams from any other endpoint.
+func WithAssociatePeerOnly(restrict bool) Option {
+	return func(s *Server) {
+		s.associatePeerOnly = restrict
+	}
+}
+
+// WithClock sets the source of time of the timeouts, rate limits, quotas and idle tracking,
+// such as a fake clock to test the time-dependent behavior deterministically.
+// The socket deadlines always use the system time, default SystemClock.
+func WithClock(c Clock) Option {
+	return func(s *Server) {
+		if c != nil {
+			s.clock = c
+		}
+	}
+}
+
+// WithUDPSocketReuse shares one udp relay socket among the associations from the same client ip,
+// demuxing the datagrams by the client port, to reduce the socket churn of the clients which open
+// many short-lived associations, such as DNS over SOCKS. The association binds the client port
+// declared in the ASSOCIATE request, the association not declaring it, or declaring the port of
+// another, gets its own socket. The datagrams from any other ip than the tcp peer's are dropped.
+func WithUDPSocketReuse(reuse bool) Option {
+	return func(s *Server) {
+		s.udpSocketReuse = reuse
+	}
+}
+
+// WithAssociateSourceCheck only relays the datagrams from the client address declared
+// in the ASSOCIATE request per RFC 1928, or from the tcp peer's ip if the declared address
+// is unspecified, and from the declared port if not 0, so a host which learns the relay port
+// can not inject datagrams. The DST.ADDR of the request is taken as the client address,
+// rather than the default destination. relaxNAT accepts the tcp peer's ip with any port too,
+// for the clients behind NAT which declare their private address.
+func WithAssociateSourceCheck(enable, relaxNAT bool) Option {
+	return func(s *Server) {
+		s.associateSourceCheck = enable
+		s.associateSourceNAT = relaxNAT
+	}
+}
+
+// WithStallWatchdog detects the CONNECT relay where one direction has been blocked
+// on write longer than the threshold, such as the slow-reader attack. The handle is
+// notified with the session and the stalled direction, up is true if the target
+// reads slowly, the session is closed unless the handle returns false.
+func WithStallWatchdog(threshold time.Duration, h func(s Session, up bool) bool) Option {
+	return func(s *Server) {
+		s.stallThreshold = threshold
+		s.stallHandle = h
+	}
+}
+
+// WithTrace enables the wire-level debug tracing, it hex-dumps the negotiation messages
+// and the first n bytes of each relay direction to the logger, the credentials are redacted.
+// It is useful to diagnose interop problems with odd clients, never enable it in production.
+func WithTrace(n int) Option {
+	return func(s *Server) {
+		s.traceLimit = n
+	}
+}
+
+// WithSocketPriority sets the SO_PRIORITY of the outbound socket per request, such as per user,
+// so the kernel qdiscs could classify the proxied traffic, false to leave the default priority.
+// The priority set by the RuleSet with ContextWithSocketPriority takes precedence. Linux only.
+func WithSocketPriority(f func(ctx context.Context, request *Request) (priority int, ok bool)) Option {
+	return func(s *Server) {
+		s.socketPriorityFunc = f
+	}
+}
+
+// WithDisableConnect rejects the CONNECT command with RepCommandNotSupported,
+// before the resolution and the rules.
+func WithDisableConnect() Option {
+	return func(s *Server) {
+		s.disabledCommands |= 1 << statute.CommandConnect
+	}
+}
+
+// WithDisableBind rejects the BIND command with RepCommandNotSupported,
+// before the resolution and the rules.
+func WithDisableBind() Option {
+	return func(s *Server) {
+		s.disabledCommands |= 1 << statute.CommandBind
+	}
+}
+
+// WithDisableAssociate rejects the ASSOCIATE command with RepCommandNotSupported,
+// before the resolution and the rules.
+func WithDisableAssociate() Option {
+	return func(s *Server) {
+		s.disabledCommands |= 1 << statute.CommandAssociate
+	}
+}
+
+// WithDiagnostic answers the vendor command statute.CommandDiagnostic, a quick in-band probe
+// for the operators, which replies the observed client address as the bind address, followed by
+// the version, the negotiated auth method and the user, see ccsocks5.Client.Diagnose.
+// The probe does not connect anywhere, so it is answered without the rules.
+func WithDiagnostic(version string) Option {
+	return func(s *Server) {
+		s.diagnostic = true
+		s.diagnosticVersion = version
+	}
+}
+
+// WithTracerProvider traces the phases of the requests, the session, the negotiation,
+// the resolution, the dial and the relay, with the attributes such as the destination,
+// the command and the reply code, see TracerProvider for the OpenTelemetry adapter.
+// nil disables the tracing.
+func WithTracerProvider(tp TracerProvider) Option {
+	return func(s *Server) {
+		if tp == nil {
+			s.tracer = nil
+			return
+		}
+		s.tracer = tp.Tracer(tracerName)
+	}
+}
+
+// WithCompression compresses the CONNECT payload by the DEFLATE at the level of compress/flate,
+// such as flate.BestSpeed, when the client requests it, such as ccsocks5 with WithCompression,
+// it improves the throughput of the chained proxy link over the low-bandwidth uplink.
+// The other clients are not affected. Each compressed session costs the memory of a flate
+// writer and reader, and the bytes counted are the uncompressed payload.
+func WithCompression(level int) Option {
+	return func(s *Server) {
+		s.compression = true
+		s.compressionLevel = level
+	}
+}
+
+// WithProxyProtocol parses the PROXY protocol v1 and v2 header of the inbound connections
+// before the SOCKS handshake, such as the server behind a TCP load balancer, see ProxyProtocolConfig.
+func WithProxyProtocol(cfg ProxyProtocolConfig) Option {
+	return func(s *Server) {
+		s.proxyProtocol = newProxyProtocol(cfg)
+	}
+}
+
+// WithTLSConfig serves SOCKS over TLS (socks5s) on all the connections, so the control channel
+// is encrypted, the listener's WithListenerTLS takes precedence. The client certificate
+// authentication is configured by the ClientAuth and ClientCAs, see ClientCertAuthenticator.
+func WithTLSConfig(cfg *tls.Config) Option {
+	return func(s *Server) {
+		s.tlsConfig = cfg
+	}
+}
+
+// WithShadow mirrors the selected CONNECT traffic to a shadow backend, see ShadowConfig.
+func WithShadow(cfg ShadowConfig) Option {
+	return func(s *Server) {
+		s.shadow = &cfg
+	}
+}
+
+// WithConnectionBudget limits how many new sessions a user may open per time window, checked
+// once the request allowed by the rules, the request over the budget is refused as blocked by
+// the rules. The Store defaults to a MemoryStore on the clock of the server.
+func WithConnectionBudget(b ConnectionBudget) Option {
+	return func(s *Server) {
+		s.budget = &b
+	}
+}
+
+// WithSelfTest enables SelfTest, the server hosts the tcp and udp echo targets on loopback
+// for the self test, authenticating by the username and password if the username is not empty.
+func WithSelfTest(username, password string) Option {
+	return func(s *Server) {
+		s.selfTest = &selfTest{username: username, password: password}
+	}
+}
+
+// WithMiddleware wraps the handling of the request by the middlewares, the first one is the
+// outermost, the middlewares of the repeated options are appended. The request is handled after
+// the authentication, the destination address rewritten by a middleware before calling the next
+// Handler, that is the RawDestAddr, is resolved and checked by the rules.
+func WithMiddleware(middlewares ...Middleware) Option {
+	return func(s *Server) {
+		s.middlewares = append(s.middlewares, middlewares...)
+	}
+}
+
 // WithConnectHandle is used to handle a user's connect command
 func WithConnectHandle(h func(ctx context.Context, writer io.Writer, request *Request) error) Option {
 	return func(s *Server) {
@@ -0,0 +1,63 @@
+package socks5
+
+import (
+	"sync"
+)
+
+// perIPLimiter caps the concurrent handshakes and active sessions per source ip
+type perIPLimiter struct {
+	maxHandshakes int
+	maxSessions   int
+	mu            sync.Mutex
+	handshakes    map[string]int
+	sessions      map[string]int
+}
+
+func newPerIPLimiter(handshakes, sessions int) *perIPLimiter {
+	return &perIPLimiter{
+		maxHandshakes: handshakes,
+		maxSessions:   sessions,
+		handshakes:    make(map[string]int),
+		sessions:      make(map[string]int),
+	}
+}
+
+// acquireHandshake acquires an un-finished handshake of the ip,
+// it returns the release function, which is safe to call more than once.
+func (sf *perIPLimiter) acquireHandshake(ip string) (func(), bool) {
+	if sf == nil {
+		return func() {}, true
+	}
+	return sf.acquire(sf.handshakes, sf.maxHandshakes, ip)
+}
+
+// acquireSession acquires an active session of the ip,
+// it returns the release function, which is safe to call more than once.
+func (sf *perIPLimiter) acquireSession(ip string) (func(), bool) {
+	if sf == nil {
+		return func() {}, true
+	}
+	return sf.acquire(sf.sessions, sf.maxSessions, ip)
+}
+
+func (sf *perIPLimiter) acquire(counts map[string]int, max int, ip string) (func(), bool) {
+	if max <= 0 {
+		return func() {}, true
+	}
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	if counts[ip] >= max {
+		return nil, false
+	}
+	counts[ip]++
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			sf.mu.Lock()
+			defer sf.mu.Unlock()
+			if counts[ip]--; counts[ip] <= 0 {
+				delete(counts, ip)
+			}
+		})
+	}, true
+}
@@ -0,0 +1,54 @@
+package socks5
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPerIPLimiter(t *testing.T) {
+	var nilLimiter *perIPLimiter
+	_, ok := nilLimiter.acquireHandshake("127.0.0.1")
+	require.True(t, ok)
+
+	l := newPerIPLimiter(1, 2)
+	release, ok := l.acquireHandshake("127.0.0.1")
+	require.True(t, ok)
+	_, ok = l.acquireHandshake("127.0.0.1")
+	require.False(t, ok)
+	_, ok = l.acquireHandshake("127.0.0.2")
+	require.True(t, ok)
+	release()
+	release()
+	_, ok = l.acquireHandshake("127.0.0.1")
+	require.True(t, ok)
+
+	_, ok = l.acquireSession("127.0.0.1")
+	require.True(t, ok)
+	_, ok = l.acquireSession("127.0.0.1")
+	require.True(t, ok)
+	_, ok = l.acquireSession("127.0.0.1")
+	require.False(t, ok)
+}
+
+func TestServer_PerIPHandshakeLimit(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	go NewServer(WithPerIPLimit(1, 0)).Serve(l) // nolint: errcheck
+
+	pending, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	defer pending.Close()
+	time.Sleep(20 * time.Millisecond)
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(time.Second)) // nolint: errcheck
+	_, err = conn.Read(make([]byte, 1))
+	require.Equal(t, io.EOF, err)
+}
@@ -0,0 +1,73 @@
+package socks5
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/thinkgos/go-socks5/statute"
+)
+
+// resolveDest resolves the FQDN of the destination in place by the NameResolver and the answer
+// filters, the failure is replied. It returns the addresses resolved, the first is the dest.IP.
+func (sf *Server) resolveDest(ctx context.Context, write io.Writer, req *Request,
+	dest *statute.AddrSpec) (context.Context, []net.IP, error) {
+	start := sf.clock.Now()
+	_, span := sf.startSpan(ctx, SpanResolve, Attribute{AttrDest, dest.FQDN})
+	ctx, ips, rep, err := sf.resolve(ctx, dest.FQDN)
+	sf.observeDuration(PhaseResolve, req.Command, start)
+	if err == nil {
+		dest.IP = ips[0]
+		span.SetAttributes(Attribute{AttrResolvedIP, dest.IP.String()})
+	}
+	endSpan(span, err)
+	if err != nil {
+		detail := statute.DetailResolveFailed
+		if rep == statute.RepRuleFailure {
+			detail = statute.DetailRuleDenied
+		}
+		sf.incError(PhaseResolve, rep)
+		if err := sf.sendFailure(write, req.RemoteAddr, rep, detail); err != nil {
+			return ctx, nil, fmt.Errorf("failed to send reply, %v", err)
+		}
+		return ctx, nil, fmt.Errorf("failed to resolve destination[%v], %v", dest.FQDN, err)
+	}
+	return ctx, ips, nil
+}
+
+// pinAddrs narrows the alternates of the addresses resolved to the ones the rules allow, the
+// destination is allowed already, so the Happy Eyeballs never dials an address not checked.
+// The alternates are dropped if the destination is rewritten to the other address.
+func (sf *Server) pinAddrs(ctx context.Context, req *Request) []net.IP {
+	if len(req.ResolvedIPs) <= 1 {
+		return req.ResolvedIPs
+	}
+	if !req.DestAddr.IP.Equal(req.ResolvedIP) {
+		return req.ResolvedIPs[:1]
+	}
+	rules := sf.ruleSet(req)
+	pinned := []net.IP{req.ResolvedIPs[0]}
+	for _, ip := range req.ResolvedIPs[1:] {
+		alt := *req
+		alt.DestAddr = &statute.AddrSpec{IP: ip, Port: req.DestAddr.Port, AddrType: statute.ATYPIPv4}
+		if ip.To4() == nil {
+			alt.DestAddr.AddrType = statute.ATYPIPv6
+		}
+		if _, ok := rules.Allow(ctx, &alt); ok && allowDestination(&alt) {
+			pinned = append(pinned, ip)
+		}
+	}
+	return pinned
+}
+
+// pinnedResolver looks up the addresses pinned of the request, never resolves again
+type pinnedResolver struct {
+	request *Request
+}
+
+// LookupAddrs implement interface AddrResolver
+func (sf pinnedResolver) LookupAddrs(context.Context, string) ([]net.IP, time.Duration, error) {
+	return sf.request.ResolvedIPs, 0, nil
+}
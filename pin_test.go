@@ -0,0 +1,101 @@
+package socks5
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/thinkgos/go-socks5/statute"
+)
+
+func TestServer_PinResolvedIP(t *testing.T) {
+	target := echoTarget(t)
+	var lookups int32
+	answers := []net.IP{net.ParseIP("2001:db8::1"), target.IP}
+	resolver := PolicyResolver{
+		Resolver: addrResolverFunc(func(context.Context, string) ([]net.IP, time.Duration, error) {
+			// the answer rebinds after the first lookup
+			if atomic.AddInt32(&lookups, 1) > 2 {
+				return []net.IP{net.IPv4(10, 0, 0, 1)}, 0, nil
+			}
+			return answers, 0, nil
+		}),
+		Policy: PreferIPv6,
+	}
+	var mu sync.Mutex
+	var dialed []string
+	dial := WithDial(func(ctx context.Context, network, addr string) (net.Conn, error) {
+		mu.Lock()
+		dialed = append(dialed, addr)
+		mu.Unlock()
+		if addr != target.String() {
+			return nil, errors.New("connection refused")
+		}
+		return new(net.Dialer).DialContext(ctx, network, addr)
+	})
+	infos := make(chan DialInfo, 1)
+
+	// the alternate is dialed without resolving again
+	proxy := serveSocks(t, WithResolver(resolver), WithHappyEyeballs(time.Hour), dial,
+		WithDialHandle(func(ctx context.Context, request *Request, info DialInfo) { infos <- info }))
+	assert.Equal(t, statute.RepSuccess, connectDomain(t, proxy, "pin.example", target.Port))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&lookups))
+	assert.Equal(t, []string{"[2001:db8::1]:" + strconv.Itoa(target.Port), target.String()}, dialed)
+	info := <-infos
+	assert.Equal(t, "pin.example", info.FQDN)
+	assert.Equal(t, answers[0], info.ResolvedIP)
+	assert.Equal(t, target.String(), info.RemoteAddr.String())
+
+	// the alternate the rules deny is never dialed
+	atomic.StoreInt32(&lookups, 0)
+	dialed = nil
+	resolvedIPs := make(chan []net.IP, 2)
+	proxy = serveSocks(t, WithResolver(resolver), WithHappyEyeballs(time.Hour), dial,
+		WithRule(ruleFunc(func(ctx context.Context, req *Request) (context.Context, bool) {
+			resolvedIPs <- req.ResolvedIPs
+			return ctx, !req.DestAddr.IP.Equal(target.IP)
+		})))
+	assert.Equal(t, statute.RepConnectionRefused, connectDomain(t, proxy, "pin.example", target.Port))
+	assert.Equal(t, answers, <-resolvedIPs)
+	assert.Equal(t, []string{"[2001:db8::1]:" + strconv.Itoa(target.Port)}, dialed)
+}
+
+func TestServer_PinRewrittenFQDN(t *testing.T) {
+	target := echoTarget(t)
+	resolver := PolicyResolver{
+		Resolver: addrResolverFunc(func(_ context.Context, name string) ([]net.IP, time.Duration, error) {
+			switch name {
+			case "old.example":
+				return []net.IP{net.IPv4(10, 0, 0, 9)}, 0, nil
+			case "new.example":
+				return []net.IP{target.IP}, 0, nil
+			}
+			return nil, 0, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+		}),
+	}
+	dialed := make(chan string, 1)
+	checked := make(chan string, 1)
+	proxy := serveSocks(t,
+		WithResolver(resolver),
+		WithRewriter(RewriteRules{{Host: "old.example", NewHost: "new.example"}}),
+		WithRule(ruleFunc(func(ctx context.Context, req *Request) (context.Context, bool) {
+			checked <- req.DestAddr.String()
+			return ctx, true
+		})),
+		WithDial(func(ctx context.Context, network, addr string) (net.Conn, error) {
+			dialed <- addr
+			return new(net.Dialer).DialContext(ctx, network, addr)
+		}),
+	)
+	// the FQDN rewritten to is resolved before the rules, the address checked is dialed
+	assert.Equal(t, statute.RepSuccess, connectDomain(t, proxy, "old.example", target.Port))
+	assert.Equal(t, target.String(), <-checked)
+	assert.Equal(t, target.String(), <-dialed)
+}
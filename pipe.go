@@ -0,0 +1,61 @@
+package socks5
+
+import (
+	"net"
+)
+
+type closeReader interface {
+	CloseRead() error
+}
+
+// halfResult is the result of a direction of the pipe
+type halfResult struct {
+	// up is from client to target
+	up bool
+	// n is the bytes copied
+	n   int64
+	err error
+}
+
+// pipe relays the data between the client and the target in both directions until both ended,
+// the upstream is copied by a new goroutine and the downstream by the caller. A direction ended
+// by EOF half-closes the peer, the write side of its destination is closed by the copy, and the
+// read side of its source here, the other direction keeps relaying. A direction ended by an error
+// interrupts the other by the deadline in the past, so no copy is left blocked once the relay
+// fails. Only the reads are interrupted if the session is migrating, so the data being written is
+// not lost, see Migrate. The client and the session may be nil, such as the request not served on
+// a connection. The results are returned in the order the directions ended.
+func (sf *Server) pipe(sess *session, client, target net.Conn, up, down func() (int64, error)) [2]halfResult {
+	done := make(chan halfResult, 2)
+	finish := func(r halfResult) {
+		src := client
+		if !r.up {
+			src = target
+		}
+		if r.err == nil {
+			if c, ok := src.(closeReader); ok {
+				c.CloseRead() // nolint: errcheck
+			}
+		} else {
+			migrating := sess.migrationOf() != nil
+			for _, c := range []net.Conn{client, target} {
+				if c == nil {
+					continue
+				}
+				if migrating {
+					c.SetReadDeadline(aLongTimeAgo) // nolint: errcheck
+				} else {
+					c.SetDeadline(aLongTimeAgo) // nolint: errcheck
+				}
+			}
+		}
+		done <- r
+	}
+	sf.goFunc(func() {
+		n, err := up()
+		finish(halfResult{true, n, err})
+	})
+	n, err := down()
+	finish(halfResult{false, n, err})
+	return [2]halfResult{<-done, <-done}
+}
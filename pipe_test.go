@@ -0,0 +1,77 @@
+package socks5
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// tcpPair returns both ends of a tcp connection
+func tcpPair(t *testing.T) (*net.TCPConn, *net.TCPConn) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	c, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	s, err := l.Accept()
+	require.NoError(t, err)
+	t.Cleanup(func() { c.Close(); s.Close() })
+	return c.(*net.TCPConn), s.(*net.TCPConn)
+}
+
+func TestServer_pipe(t *testing.T) {
+	srv := NewServer()
+
+	t.Run("half close", func(t *testing.T) {
+		clientEnd, client := tcpPair(t)
+		target, targetEnd := tcpPair(t)
+		done := make(chan [2]halfResult, 1)
+		go func() {
+			done <- srv.pipe(nil, client, target,
+				func() (int64, error) { return srv.proxy(nil, target, client) },
+				func() (int64, error) { return srv.proxy(nil, client, target) })
+		}()
+
+		_, err := clientEnd.Write([]byte("hello"))
+		require.NoError(t, err)
+		require.NoError(t, clientEnd.CloseWrite())
+		// the EOF of the client is passed to the target, the response still relayed
+		b, err := ioutil.ReadAll(targetEnd)
+		require.NoError(t, err)
+		require.Equal(t, "hello", string(b))
+		_, err = targetEnd.Write([]byte("world!"))
+		require.NoError(t, err)
+		targetEnd.Close()
+		b, err = ioutil.ReadAll(clientEnd)
+		require.NoError(t, err)
+		require.Equal(t, "world!", string(b))
+
+		results := <-done
+		require.Equal(t, [2]halfResult{{true, 5, nil}, {false, 6, nil}}, results)
+	})
+
+	t.Run("error interrupts", func(t *testing.T) {
+		_, client := tcpPair(t)
+		target, _ := tcpPair(t)
+		boom := errors.New("boom")
+		done := make(chan [2]halfResult, 1)
+		go func() {
+			done <- srv.pipe(nil, client, target,
+				func() (int64, error) { return 0, boom },
+				func() (int64, error) { return io.Copy(client, target) })
+		}()
+		select {
+		case results := <-done:
+			require.Equal(t, halfResult{true, 0, boom}, results[0])
+			var ne net.Error
+			require.True(t, errors.As(results[1].err, &ne) && ne.Timeout())
+		case <-time.After(time.Second):
+			t.Fatal("blocked direction not interrupted")
+		}
+	})
+}
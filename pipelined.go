@@ -0,0 +1,77 @@
+package socks5
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/thinkgos/go-socks5/statute"
+)
+
+// pipelinedWait is the time the check of the pipelined data waits for the data the client sent
+// after the CONNECT request, which is not buffered by the request parsing yet.
+const pipelinedWait = 5 * time.Millisecond
+
+// checkPipelined checks the data the client sent after the CONNECT request before the reply,
+// rejects the request if the pipelined data is not allowed or over the limit.
+// The data is read into the buffer up to the limit within pipelinedWait, so the check does not
+// depend on how much of it the request parsing happened to buffer.
+func (sf *Server) checkPipelined(writer io.Writer, request *Request, conn net.Conn, br *bufio.Reader) error {
+	if request.Command != statute.CommandConnect || (!sf.pipelinedDeny && sf.pipelinedMax <= 0) {
+		return nil
+	}
+	limit := 0
+	if !sf.pipelinedDeny {
+		limit = sf.pipelinedMax
+	}
+	if br.Buffered() <= limit {
+		n := limit + 1
+		if n > br.Size() {
+			n = br.Size()
+		}
+		conn.SetReadDeadline(time.Now().Add(pipelinedWait)) // nolint: errcheck
+		br.Peek(n)                                          // nolint: errcheck
+		conn.SetReadDeadline(time.Time{})                   // nolint: errcheck
+	}
+	buffered := br.Buffered()
+	if buffered <= limit {
+		return nil
+	}
+	sf.incError(PhaseRule, statute.RepRuleFailure)
+	if err := sf.sendFailure(writer, request.RemoteAddr, statute.RepRuleFailure,
+		statute.DetailPipelinedData); err != nil {
+		return fmt.Errorf("failed to send reply, %v", err)
+	}
+	return fmt.Errorf("pipelined data %d bytes before reply rejected", buffered)
+}
+
+// bufferedReader is a reader reporting the bytes buffered, which are read without blocking,
+// such as *bufio.Reader.
+type bufferedReader interface {
+	io.Reader
+	Buffered() int
+}
+
+// flushEarlyData writes the data pipelined after the CONNECT request to the target
+// as soon as connected, before the reply, which shaves an RTT for short
+// request/response protocols. The data is read through the request reader, so the
+// readers wrapping the connection, such as the trace and the GSSAPI encapsulation, see it.
+// The data not reported buffered by the reader is relayed after the reply.
+func (sf *Server) flushEarlyData(request *Request, target net.Conn) error {
+	if !sf.earlyData {
+		return nil
+	}
+	br, ok := request.Reader.(bufferedReader)
+	if !ok || br.Buffered() == 0 {
+		return nil
+	}
+	data := make([]byte, br.Buffered())
+	if _, err := io.ReadFull(br, data); err != nil {
+		return err
+	}
+	n, err := target.Write(data)
+	request.sess.countUp(n)
+	return err
+}
@@ -0,0 +1,135 @@
+package socks5
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/thinkgos/go-socks5/statute"
+)
+
+func TestServer_PipelinedData(t *testing.T) {
+	tests := []struct {
+		name string
+		opt  Option
+		rep  uint8
+	}{
+		{"deny", WithPipelinedData(false, 0), statute.RepRuleFailure},
+		{"over limit", WithPipelinedData(true, 2), statute.RepRuleFailure},
+		{"allow", WithPipelinedData(true, 4), statute.RepConnectionRefused},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, conn := net.Pipe()
+			defer client.Close()
+			go NewServer(tt.opt).ServeConn(conn) // nolint: errcheck
+
+			_, err := client.Write([]byte{statute.VersionSocks5, 1, statute.MethodNoAuth})
+			require.NoError(t, err)
+			_, err = io.ReadFull(client, make([]byte, 2))
+			require.NoError(t, err)
+
+			// request to a closed port followed by the pipelined "ping"
+			_, err = client.Write([]byte{
+				statute.VersionSocks5, statute.CommandConnect, 0,
+				statute.ATYPIPv4, 127, 0, 0, 1, 0, 1,
+				'p', 'i', 'n', 'g',
+			})
+			require.NoError(t, err)
+			reply, err := statute.ParseReply(client)
+			require.NoError(t, err)
+			require.Equal(t, tt.rep, reply.Response)
+			go io.Copy(ioutil.Discard, client) // nolint: errcheck
+		})
+	}
+}
+
+func TestServer_PipelinedData_Split(t *testing.T) {
+	client, conn := net.Pipe()
+	defer client.Close()
+	go NewServer(WithPipelinedData(false, 0)).ServeConn(conn) // nolint: errcheck
+
+	_, err := client.Write([]byte{statute.VersionSocks5, 1, statute.MethodNoAuth})
+	require.NoError(t, err)
+	_, err = io.ReadFull(client, make([]byte, 2))
+	require.NoError(t, err)
+
+	// the pipelined "ping" not buffered by the request parsing
+	_, err = client.Write([]byte{
+		statute.VersionSocks5, statute.CommandConnect, 0,
+		statute.ATYPIPv4, 127, 0, 0, 1, 0, 1,
+	})
+	require.NoError(t, err)
+	go client.Write([]byte("ping")) // nolint: errcheck
+	reply, err := statute.ParseReply(client)
+	require.NoError(t, err)
+	require.Equal(t, statute.RepRuleFailure, reply.Response)
+}
+
+type earlyDataWriter struct {
+	got     chan []byte
+	early   []byte
+	replied bool
+}
+
+func (w *earlyDataWriter) Write(b []byte) (int, error) {
+	if !w.replied {
+		w.replied = true
+		select {
+		case w.early = <-w.got:
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+	return len(b), nil
+}
+
+func TestServer_EarlyData(t *testing.T) {
+	t.Run("bufio", func(t *testing.T) {
+		testEarlyData(t, func(br *bufio.Reader) io.Reader { return br })
+	})
+	t.Run("trace", func(t *testing.T) {
+		testEarlyData(t, func(br *bufio.Reader) io.Reader {
+			return newTraceReader(br, NewLogger(log.New(ioutil.Discard, "", 0)), 1)
+		})
+	})
+}
+
+func testEarlyData(t *testing.T, wrap func(br *bufio.Reader) io.Reader) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	got := make(chan []byte, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		buf := make([]byte, 4)
+		if _, err := io.ReadFull(conn, buf); err == nil {
+			got <- buf
+		}
+	}()
+	lAddr := l.Addr().(*net.TCPAddr)
+
+	req, err := ParseRequest(bytes.NewReader([]byte{
+		statute.VersionSocks5, statute.CommandConnect, 0,
+		statute.ATYPIPv4, 127, 0, 0, 1, byte(lAddr.Port >> 8), byte(lAddr.Port),
+	}))
+	require.NoError(t, err)
+	br := bufio.NewReader(bytes.NewReader([]byte("ping")))
+	br.Peek(4) // nolint: errcheck
+	req.Reader = wrap(br)
+
+	w := &earlyDataWriter{got: got}
+	NewServer(WithEarlyData(true)).handleRequest(context.Background(), w, req) // nolint: errcheck
+	require.Equal(t, []byte("ping"), w.early)
+}
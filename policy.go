@@ -0,0 +1,40 @@
+package socks5
+
+// SetRules replaces the RuleSet of the live server without recreating it or dropping
+// the listeners, such as reloading the policy from the configuration file. The requests
+// after it are permitted by the new rule set, the sessions established are not affected,
+// and the listener's WithListenerRule still takes precedence.
+// A nil rule set restores the default, NewPermitAll.
+func (sf *Server) SetRules(rule RuleSet) {
+	if rule == nil {
+		rule = NewPermitAll()
+	}
+	sf.policyMu.Lock()
+	sf.rules = rule
+	sf.policyMu.Unlock()
+}
+
+// SetResolver replaces the NameResolver of the live server, the requests after it
+// are resolved by the new one. A nil resolver restores the default, DNSResolver.
+func (sf *Server) SetResolver(res NameResolver) {
+	if res == nil {
+		res = DNSResolver{}
+	}
+	sf.policyMu.Lock()
+	sf.resolver = res
+	sf.policyMu.Unlock()
+}
+
+// currentRules returns the RuleSet of the server in effect
+func (sf *Server) currentRules() RuleSet {
+	sf.policyMu.RLock()
+	defer sf.policyMu.RUnlock()
+	return sf.rules
+}
+
+// currentResolver returns the NameResolver of the server in effect
+func (sf *Server) currentResolver() NameResolver {
+	sf.policyMu.RLock()
+	defer sf.policyMu.RUnlock()
+	return sf.resolver
+}
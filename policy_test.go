@@ -0,0 +1,53 @@
+package socks5
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/proxy"
+)
+
+type resolverFunc func(ctx context.Context, name string) (context.Context, net.IP, error)
+
+func (f resolverFunc) Resolve(ctx context.Context, name string) (context.Context, net.IP, error) {
+	return f(ctx, name)
+}
+
+func TestServer_SetRulesAndResolver(t *testing.T) {
+	target := echoTarget(t)
+	srv := NewServer(WithRule(NewPermitNone()))
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	go srv.Serve(l) // nolint: errcheck
+
+	d, err := proxy.SOCKS5("tcp", l.Addr().String(), nil, proxy.Direct)
+	require.NoError(t, err)
+	_, err = d.Dial("tcp", target.String())
+	require.Error(t, err)
+
+	// the same listener serves by the new rules
+	srv.SetRules(NewPermitAll())
+	conn, err := d.Dial("tcp", target.String())
+	require.NoError(t, err)
+	conn.Close()
+
+	addr := net.JoinHostPort("echo.test", strconv.Itoa(target.Port))
+	_, err = d.Dial("tcp", addr)
+	require.Error(t, err)
+	srv.SetResolver(resolverFunc(func(ctx context.Context, name string) (context.Context, net.IP, error) {
+		return ctx, target.IP, nil
+	}))
+	conn, err = d.Dial("tcp", addr)
+	require.NoError(t, err)
+	conn.Close()
+
+	// nil restores the defaults
+	srv.SetRules(nil)
+	srv.SetResolver(nil)
+	require.IsType(t, NewPermitAll(), srv.currentRules())
+	require.Equal(t, DNSResolver{}, srv.currentResolver())
+}
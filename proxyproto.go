@@ -0,0 +1,220 @@
+package socks5
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// proxy protocol defaults
+const (
+	defaultProxyProtocolTimeout = 5 * time.Second
+	proxyProtocolV1MaxLen       = 107
+)
+
+// proxyProtocolV2Sig is the signature of the PROXY protocol v2 header
+var proxyProtocolV2Sig = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// ErrNoProxyHeader the connection has no PROXY protocol header while it is required
+var ErrNoProxyHeader = errors.New("no PROXY protocol header")
+
+// ProxyProtocolConfig parses the HAProxy PROXY protocol v1 and v2 header of the inbound
+// connections, such as the server behind a TCP load balancer. The source address of the
+// header replaces the remote address of the connection before the SOCKS handshake, so the
+// rules, the authentication, the sessions and the logs see the original client.
+// The header is only parsed from the trusted peers, the others are served as the clients.
+type ProxyProtocolConfig struct {
+	// Trusted is the networks or ips of the load balancers, such as "10.0.0.0/8",
+	// no peer is trusted if it is empty, so a client can not forge its source address.
+	Trusted []string
+	// Optional serves the connections of the trusted peers without the header,
+	// such as the health checks, otherwise they are rejected.
+	Optional bool
+	// Timeout is the timeout of reading the header, defaults to 5 seconds.
+	Timeout time.Duration
+}
+
+// proxyProtocol is the compiled ProxyProtocolConfig
+type proxyProtocol struct {
+	ProxyProtocolConfig
+	trusted []*net.IPNet
+}
+
+func newProxyProtocol(cfg ProxyProtocolConfig) *proxyProtocol {
+	p := &proxyProtocol{ProxyProtocolConfig: cfg}
+	for _, s := range cfg.Trusted {
+		// the invalid one is reported by Server.Validate
+		if network, err := parseCIDR(s); err == nil {
+			p.trusted = append(p.trusted, network)
+		}
+	}
+	return p
+}
+
+// isTrusted reports whether the header of the peer is parsed
+func (sf *proxyProtocol) isTrusted(addr net.Addr) bool {
+	ip := unmapIP(addrIP(addr))
+	for _, network := range sf.trusted {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// accept reads the header of the connection from a trusted peer, it returns the connection
+// with the remote address of the header. The header is read by the earlier of the timeout and
+// the handshake deadline, which is restored after, zero if no handshake deadline.
+func (sf *proxyProtocol) accept(conn net.Conn, handshakeDeadline time.Time) (net.Conn, error) {
+	if !sf.isTrusted(conn.RemoteAddr()) {
+		return conn, nil
+	}
+	timeout := sf.Timeout
+	if timeout <= 0 {
+		timeout = defaultProxyProtocolTimeout
+	}
+	deadline := time.Now().Add(timeout)
+	if !handshakeDeadline.IsZero() && handshakeDeadline.Before(deadline) {
+		deadline = handshakeDeadline
+	}
+	conn.SetReadDeadline(deadline)                // nolint: errcheck
+	defer conn.SetReadDeadline(handshakeDeadline) // nolint: errcheck
+
+	br := bufio.NewReader(conn)
+	b, err := br.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+	var src net.Addr
+	switch b[0] {
+	case 'P':
+		src, err = readProxyHeaderV1(br)
+	case proxyProtocolV2Sig[0]:
+		src, err = readProxyHeaderV2(br)
+	default:
+		if !sf.Optional {
+			return nil, ErrNoProxyHeader
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("proxy protocol from %s, %w", conn.RemoteAddr(), err)
+	}
+	if src == nil {
+		src = conn.RemoteAddr()
+	}
+	var r io.Reader = br
+	if br.Buffered() == 0 {
+		r = conn
+	}
+	return &proxiedConn{conn, r, src}, nil
+}
+
+// readProxyHeaderV1 reads the human-readable header, such as
+// "PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\n",
+// the source address is nil for "PROXY UNKNOWN".
+func readProxyHeaderV1(br *bufio.Reader) (net.Addr, error) {
+	var line []byte
+	for !bytes.HasSuffix(line, []byte("\r\n")) {
+		if len(line) >= proxyProtocolV1MaxLen {
+			return nil, errors.New("v1 header too long")
+		}
+		b, err := br.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+	}
+	fields := strings.Fields(string(line))
+	if len(fields) < 2 || fields[0] != "PROXY" {
+		return nil, fmt.Errorf("invalid v1 header %q", line)
+	}
+	switch fields[1] {
+	case "UNKNOWN":
+		return nil, nil
+	case "TCP4", "TCP6":
+	default:
+		return nil, fmt.Errorf("v1 unsupported protocol %s", fields[1])
+	}
+	if len(fields) != 6 {
+		return nil, fmt.Errorf("invalid v1 header %q", line)
+	}
+	ip := net.ParseIP(fields[2])
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if ip == nil || err != nil || (fields[1] == "TCP4") != (ip.To4() != nil) {
+		return nil, fmt.Errorf("invalid v1 source address %s:%s", fields[2], fields[4])
+	}
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+// readProxyHeaderV2 reads the binary header, the source address is nil for the LOCAL
+// command or the unspecified address family, the TLVs are skipped.
+func readProxyHeaderV2(br *bufio.Reader) (net.Addr, error) {
+	hdr := make([]byte, 16)
+	if _, err := io.ReadFull(br, hdr); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(hdr[:12], proxyProtocolV2Sig) {
+		return nil, errors.New("invalid v2 signature")
+	}
+	if hdr[12]>>4 != 2 {
+		return nil, fmt.Errorf("v2 unsupported version %d", hdr[12]>>4)
+	}
+	payload := make([]byte, binary.BigEndian.Uint16(hdr[14:]))
+	if _, err := io.ReadFull(br, payload); err != nil {
+		return nil, err
+	}
+	switch hdr[12] & 0x0f {
+	case 0x00: // LOCAL
+		return nil, nil
+	case 0x01: // PROXY
+	default:
+		return nil, fmt.Errorf("v2 unsupported command %d", hdr[12]&0x0f)
+	}
+
+	var ipLen int
+	switch hdr[13] >> 4 {
+	case 0x1: // AF_INET
+		ipLen = net.IPv4len
+	case 0x2: // AF_INET6
+		ipLen = net.IPv6len
+	default: // AF_UNSPEC or AF_UNIX
+		return nil, nil
+	}
+	if len(payload) < 2*ipLen+4 {
+		return nil, fmt.Errorf("v2 address block too short, %d bytes", len(payload))
+	}
+	ip := net.IP(payload[:ipLen])
+	port := int(binary.BigEndian.Uint16(payload[2*ipLen:]))
+	if hdr[13]&0x0f == 0x2 { // DGRAM
+		return &net.UDPAddr{IP: ip, Port: port}, nil
+	}
+	return &net.TCPAddr{IP: ip, Port: port}, nil
+}
+
+// proxiedConn is the connection with the remote address of the PROXY protocol header
+type proxiedConn struct {
+	net.Conn
+	r      io.Reader
+	remote net.Addr
+}
+
+// Read implement interface io.Reader
+func (sf *proxiedConn) Read(p []byte) (int, error) { return sf.r.Read(p) }
+
+// RemoteAddr implement interface net.Conn, the source address of the header
+func (sf *proxiedConn) RemoteAddr() net.Addr { return sf.remote }
+
+// CloseWrite implement interface closeWriter
+func (sf *proxiedConn) CloseWrite() error {
+	if c, ok := sf.Conn.(closeWriter); ok {
+		return c.CloseWrite()
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package socks5
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/thinkgos/go-socks5/statute"
+)
+
+func TestServer_ProxyProtocol(t *testing.T) {
+	sources := make(chan string, 1)
+	rule := ruleFunc(func(ctx context.Context, req *Request) (context.Context, bool) {
+		sources <- req.RemoteAddr.String()
+		return ctx, false
+	})
+	request := []byte{
+		statute.VersionSocks5, 1, statute.MethodNoAuth,
+		statute.VersionSocks5, statute.CommandConnect, 0, statute.ATYPIPv4, 127, 0, 0, 1, 0, 80,
+	}
+	v2 := append([]byte(nil), proxyProtocolV2Sig...)
+	v2 = append(v2, 0x21, 0x21, 0, 36+3)
+	v2 = append(v2, net.ParseIP("2001:db8::1")...)
+	v2 = append(v2, net.ParseIP("2001:db8::2")...)
+	v2 = append(v2, 0x1f, 0x90, 0x01, 0xbb, 0x04, 0x00, 0x00) // ports and a NOOP TLV
+
+	local := ProxyProtocolConfig{Trusted: []string{"127.0.0.1"}}
+	for _, tt := range []struct {
+		name   string
+		cfg    ProxyProtocolConfig
+		header []byte
+		source string
+	}{
+		{"v1", local, []byte("PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\n"), "192.168.0.1:56324"},
+		{"v2", ProxyProtocolConfig{Trusted: []string{"127.0.0.1"}}, v2, "[2001:db8::1]:8080"},
+		{"v1 unknown", local, []byte("PROXY UNKNOWN\r\n"), "127.0.0.1"},
+		{"optional", ProxyProtocolConfig{Trusted: []string{"127.0.0.1"}, Optional: true}, nil, "127.0.0.1"},
+		{"untrusted", ProxyProtocolConfig{Trusted: []string{"10.0.0.0/8"}}, nil, "127.0.0.1"},
+		{"none trusted", ProxyProtocolConfig{}, nil, "127.0.0.1"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			proxy := serveSocks(t, WithProxyProtocol(tt.cfg), WithRule(rule))
+			conn, err := net.Dial("tcp", proxy.String())
+			require.NoError(t, err)
+			defer conn.Close()
+			_, err = conn.Write(append(tt.header, request...))
+			require.NoError(t, err)
+			_, err = io.ReadFull(conn, make([]byte, 2))
+			require.NoError(t, err)
+			rep, err := statute.ParseReply(conn)
+			require.NoError(t, err)
+			require.Equal(t, statute.RepRuleFailure, rep.Response)
+			require.True(t, strings.HasPrefix(<-sources, tt.source))
+		})
+	}
+
+	// the header is required
+	proxy := serveSocks(t, WithProxyProtocol(local), WithRule(rule))
+	conn, err := net.Dial("tcp", proxy.String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write(request)
+	require.NoError(t, err)
+	_, err = conn.Read(make([]byte, 1))
+	require.Error(t, err)
+}
+
+func TestServer_ProxyProtocol_HandshakeTimeout(t *testing.T) {
+	proxy := serveSocks(t, WithProxyProtocol(ProxyProtocolConfig{Trusted: []string{"127.0.0.1"}}),
+		WithHandshakeTimeout(100*time.Millisecond))
+	conn, err := net.Dial("tcp", proxy.String())
+	require.NoError(t, err)
+	defer conn.Close()
+	// the header only, then silent
+	_, err = conn.Write([]byte("PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\n"))
+	require.NoError(t, err)
+	start := time.Now()
+	conn.SetReadDeadline(start.Add(2 * time.Second)) // nolint: errcheck
+	_, err = conn.Read(make([]byte, 1))
+	require.Equal(t, io.EOF, err)
+	require.True(t, time.Since(start) < time.Second)
+}
+
+func TestReadProxyHeader(t *testing.T) {
+	for _, header := range []string{
+		"PROXY TCP4 192.168.0.1 192.168.0.11 56324\r\n",
+		"PROXY TCP4 ::1 192.168.0.11 56324 443\r\n",
+		"PROXY UDP4 192.168.0.1 192.168.0.11 56324 443\r\n",
+		"PROXY TCP4 192.168.0.1 192.168.0.11 56324 443" + strings.Repeat(" ", 100) + "\r\n",
+	} {
+		_, err := readProxyHeaderV1(bufio.NewReader(strings.NewReader(header)))
+		require.Error(t, err, header)
+	}
+
+	// LOCAL command, such as the health checks of the load balancer
+	local := append(append([]byte(nil), proxyProtocolV2Sig...), 0x20, 0x00, 0, 0)
+	src, err := readProxyHeaderV2(bufio.NewReader(bytes.NewReader(local)))
+	require.NoError(t, err)
+	require.Nil(t, src)
+
+	short := append(append([]byte(nil), proxyProtocolV2Sig...), 0x21, 0x11, 0, 4, 1, 2, 3, 4)
+	_, err = readProxyHeaderV2(bufio.NewReader(bytes.NewReader(short)))
+	require.Error(t, err)
+}
@@ -0,0 +1,258 @@
+package socks5
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// tokenBucket is a token bucket rate limiter which refills rate tokens per second,
+// up to burst tokens.
+type tokenBucket struct {
+	clock  Clock
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// newTokenBucket returns a full token bucket, burst defaults to rate.
+// It returns nil if rate <= 0, which means no limit.
+func newTokenBucket(clock Clock, rate, burst int) *tokenBucket {
+	if rate <= 0 {
+		return nil
+	}
+	if burst < rate {
+		burst = rate
+	}
+	return &tokenBucket{
+		clock:  clock,
+		rate:   float64(rate),
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   clock.Now(),
+	}
+}
+
+// allow reports whether n tokens can be taken now, and takes them if so.
+// nil bucket always allows.
+func (sf *tokenBucket) allow(n int) bool {
+	if sf == nil {
+		return true
+	}
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	sf.refill(sf.clock.Now())
+	if sf.tokens < float64(n) {
+		return false
+	}
+	sf.tokens -= float64(n)
+	return true
+}
+
+// refund gives back the n tokens taken, up to the burst. nil bucket ignores.
+func (sf *tokenBucket) refund(n int) {
+	if sf == nil {
+		return
+	}
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	sf.tokens += float64(n)
+	if sf.tokens > sf.burst {
+		sf.tokens = sf.burst
+	}
+}
+
+// take takes n tokens even if not enough, and returns how long to wait for
+// the debt to be refilled. nil bucket never waits.
+func (sf *tokenBucket) take(n int) time.Duration {
+	if sf == nil {
+		return 0
+	}
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	sf.refill(sf.clock.Now())
+	sf.tokens -= float64(n)
+	if sf.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-sf.tokens / sf.rate * float64(time.Second))
+}
+
+// full reports whether the bucket is refilled to the burst, that is idle.
+// nil bucket is always full.
+func (sf *tokenBucket) full(now time.Time) bool {
+	if sf == nil {
+		return true
+	}
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	sf.refill(now)
+	return sf.tokens >= sf.burst
+}
+
+func (sf *tokenBucket) refill(now time.Time) {
+	sf.tokens += now.Sub(sf.last).Seconds() * sf.rate
+	if sf.tokens > sf.burst {
+		sf.tokens = sf.burst
+	}
+	sf.last = now
+}
+
+// RateLimiter limits the bandwidth of the TCP relay and the UDP forwarding per key,
+// the key is the username if authenticated by username, otherwise the client ip.
+// Both directions of the sessions with the same key share the bandwidth.
+type RateLimiter interface {
+	// WaitN blocks until n bytes of the key are allowed or the context is done.
+	WaitN(ctx context.Context, key string, n int) error
+	// AllowN reports whether n bytes of the key are allowed now, the datagram is dropped if not.
+	AllowN(key string, n int) bool
+}
+
+// tokenBucketSweepInterval is the interval to remove the idle buckets
+const tokenBucketSweepInterval = time.Minute
+
+// TokenBucketLimiter is a RateLimiter with a token bucket per key
+type TokenBucketLimiter struct {
+	// Clock of the buckets, set before use, nil means the system clock.
+	Clock   Clock
+	rate    int
+	burst   int
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+	swept   time.Time
+}
+
+// NewTokenBucketLimiter new a token bucket limiter refills bytesPerSecond per key,
+// up to burst bytes, burst defaults to bytesPerSecond. bytesPerSecond <= 0 means no limit.
+func NewTokenBucketLimiter(bytesPerSecond, burst int) *TokenBucketLimiter {
+	return &TokenBucketLimiter{
+		rate:    bytesPerSecond,
+		burst:   burst,
+		buckets: make(map[string]*tokenBucket),
+	}
+}
+
+func (sf *TokenBucketLimiter) bucket(key string) *tokenBucket {
+	if sf.rate <= 0 {
+		// no limit, the nil bucket always allows, nothing to store
+		return nil
+	}
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	b, ok := sf.buckets[key]
+	if !ok {
+		clock := clockOrSystem(sf.Clock)
+		b = newTokenBucket(clock, sf.rate, sf.burst)
+		sf.buckets[key] = b
+		// the full buckets are idle, remove them to bound the keys
+		now := clock.Now()
+		if sf.swept.IsZero() {
+			sf.swept = now
+		}
+		if now.Sub(sf.swept) >= tokenBucketSweepInterval {
+			sf.swept = now
+			for k, v := range sf.buckets {
+				if k != key && v.full(now) {
+					delete(sf.buckets, k)
+				}
+			}
+		}
+	}
+	return b
+}
+
+// WaitN implement interface RateLimiter
+func (sf *TokenBucketLimiter) WaitN(ctx context.Context, key string, n int) error {
+	return sleepContext(ctx, clockOrSystem(sf.Clock), sf.bucket(key).take(n))
+}
+
+// AllowN implement interface RateLimiter
+func (sf *TokenBucketLimiter) AllowN(key string, n int) bool {
+	return sf.bucket(key).allow(n)
+}
+
+// rateLimitKey is the key of the request to limit, the username or the client ip
+func rateLimitKey(request *Request) string {
+	if user := usernameOf(request); user != "" {
+		return "user:" + user
+	}
+	return "ip:" + unmapIP(addrIP(request.RemoteAddr)).String()
+}
+
+// rateLimitWriter waits for the connection bucket and the rate limiter before write
+type rateLimitWriter struct {
+	io.Writer
+	ctx     context.Context
+	clock   Clock
+	conn    *tokenBucket
+	limiter RateLimiter
+	key     string
+}
+
+// Write implement interface io.Writer
+func (sf *rateLimitWriter) Write(p []byte) (int, error) {
+	if err := sleepContext(sf.ctx, sf.clock, sf.conn.take(len(p))); err != nil {
+		return 0, err
+	}
+	if sf.limiter != nil {
+		if err := sf.limiter.WaitN(sf.ctx, sf.key, len(p)); err != nil {
+			return 0, err
+		}
+	}
+	return sf.Writer.Write(p)
+}
+
+// CloseWrite implement interface closeWriter
+func (sf *rateLimitWriter) CloseWrite() error {
+	if c, ok := sf.Writer.(closeWriter); ok {
+		return c.CloseWrite()
+	}
+	return nil
+}
+
+// rateLimit wraps the relay writers with the per connection bucket and the rate limiter,
+// the per connection bucket is shared by both directions.
+func (sf *Server) rateLimit(ctx context.Context, request *Request, clientW, targetW io.Writer) (io.Writer, io.Writer) {
+	rate := sf.sessionByteRate(request)
+	if rate <= 0 && sf.rateLimiter == nil {
+		return clientW, targetW
+	}
+	conn := newTokenBucket(sf.clock, rate, 0)
+	key := rateLimitKey(request)
+	return &rateLimitWriter{clientW, ctx, sf.clock, conn, sf.rateLimiter, key},
+		&rateLimitWriter{targetW, ctx, sf.clock, conn, sf.rateLimiter, key}
+}
+
+// allowRate reports whether the datagram of n bytes is allowed by the association bucket
+// and the rate limiter, the datagram is dropped if not, and nothing is taken.
+func (sf *natTable) allowRate(n int) bool {
+	if !sf.connRate.allow(n) {
+		return false
+	}
+	if sf.srv.rateLimiter != nil && !sf.srv.rateLimiter.AllowN(sf.rateKey, n) {
+		sf.connRate.refund(n)
+		return false
+	}
+	return true
+}
+
+// allowDatagram reports whether the datagram of n bytes from the client is allowed by the
+// packet and the byte bucket of the client and by allowRate, the tokens are taken only if all allow.
+func (sf *natTable) allowDatagram(packets, bytes *tokenBucket, n int) bool {
+	if !packets.allow(1) {
+		return false
+	}
+	if !bytes.allow(n) {
+		packets.refund(1)
+		return false
+	}
+	if !sf.allowRate(n) {
+		packets.refund(1)
+		bytes.refund(n)
+		return false
+	}
+	return true
+}
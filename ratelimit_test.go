@@ -0,0 +1,113 @@
+package socks5
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenBucket(t *testing.T) {
+	var nilBucket *tokenBucket
+	require.True(t, nilBucket.allow(1<<20))
+	require.Nil(t, newTokenBucket(SystemClock{}, 0, 10))
+
+	b := newTokenBucket(SystemClock{}, 2, 0)
+	require.True(t, b.allow(1))
+	require.True(t, b.allow(1))
+	require.False(t, b.allow(1))
+
+	b = newTokenBucket(SystemClock{}, 100, 1000)
+	require.True(t, b.allow(1000))
+	require.False(t, b.allow(500))
+}
+
+func TestTokenBucket_Take(t *testing.T) {
+	var nilBucket *tokenBucket
+	require.Zero(t, nilBucket.take(1<<20))
+
+	b := newTokenBucket(SystemClock{}, 1000, 0)
+	require.Zero(t, b.take(1000))
+	d := b.take(500)
+	require.True(t, d > 400*time.Millisecond && d <= 500*time.Millisecond, d)
+}
+
+func TestNatTable_allowDatagram(t *testing.T) {
+	clock := newManualClock()
+	table := newNatTable(NewServer())
+	packets := newTokenBucket(clock, 10, 0)
+	bytes := newTokenBucket(clock, 100, maxUDPPayload)
+	table.connRate = newTokenBucket(clock, 100, 1000)
+
+	// the datagram larger than the byte rate passes the burst
+	require.True(t, table.allowDatagram(packets, bytes, 1000))
+	// refused by the association bucket, no packet nor byte token taken
+	for i := 0; i < 5; i++ {
+		require.False(t, table.allowDatagram(packets, bytes, 1))
+	}
+	require.Equal(t, float64(9), packets.tokens)
+	require.Equal(t, float64(maxUDPPayload-1000), bytes.tokens)
+	// refused by the byte bucket, no packet token taken
+	require.False(t, table.allowDatagram(packets, bytes, maxUDPPayload))
+	require.Equal(t, float64(9), packets.tokens)
+}
+
+func TestTokenBucketLimiter(t *testing.T) {
+	l := NewTokenBucketLimiter(1000, 0)
+	require.True(t, l.AllowN("user:foo", 1000))
+	require.False(t, l.AllowN("user:foo", 1))
+	require.True(t, l.AllowN("user:bar", 1000))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	require.Equal(t, context.DeadlineExceeded, l.WaitN(ctx, "user:bar", 500))
+	require.NoError(t, l.WaitN(context.Background(), "user:baz", 1000))
+
+	// the idle buckets are removed
+	l.bucket("user:idle")
+	l.swept = time.Now().Add(-tokenBucketSweepInterval)
+	l.bucket("user:qux")
+	require.NotContains(t, l.buckets, "user:idle")
+	require.Contains(t, l.buckets, "user:foo")
+	require.Contains(t, l.buckets, "user:qux")
+}
+
+func TestRateLimitKey(t *testing.T) {
+	req := &Request{RemoteAddr: &net.TCPAddr{IP: net.ParseIP("::ffff:10.0.0.1"), Port: 1080}}
+	require.Equal(t, "ip:10.0.0.1", rateLimitKey(req))
+	req.AuthContext = &AuthContext{Payload: map[string]string{"username": "foo"}}
+	require.Equal(t, "user:foo", rateLimitKey(req))
+}
+
+func TestServer_RateLimit(t *testing.T) {
+	srv := NewServer(WithConnRateLimit(10000))
+	req := &Request{RemoteAddr: &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1080}}
+	client, target := new(bytes.Buffer), new(bytes.Buffer)
+	clientW, targetW := srv.rateLimit(context.Background(), req, client, target)
+
+	start := time.Now()
+	_, err := targetW.Write(make([]byte, 10000))
+	require.NoError(t, err)
+	// both directions share the bucket of the connection
+	_, err = clientW.Write(make([]byte, 2000))
+	require.NoError(t, err)
+	require.True(t, time.Since(start) >= 150*time.Millisecond)
+	require.Equal(t, 10000, target.Len())
+	require.Equal(t, 2000, client.Len())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	clientW, _ = srv.rateLimit(ctx, req, client, target)
+	_, err = clientW.Write(make([]byte, 10000))
+	require.NoError(t, err)
+	cancel()
+	_, err = clientW.Write(make([]byte, 5000))
+	require.Equal(t, context.Canceled, err)
+
+	srv = NewServer()
+	clientW, targetW = srv.rateLimit(context.Background(), req, client, target)
+	require.Equal(t, client, clientW)
+	require.Equal(t, target, targetW)
+}
@@ -0,0 +1,292 @@
+package socks5
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strconv"
+	"time"
+)
+
+// SessionRecord is the stable schema of a session ended, shared by the access log, the audit
+// logs built on WithSessionCloseHandle and the exporters, see RecordEncoder.
+// The fields are numbered in order from 1, the protobuf field numbers and the CSV columns,
+// the new fields are only appended.
+type SessionRecord struct {
+	ID          uint64        `json:"id"`
+	Start       time.Time     `json:"start"`
+	Duration    time.Duration `json:"duration_ns"`
+	Client      string        `json:"client"`
+	User        string        `json:"user"`
+	Tenant      string        `json:"tenant"`
+	Command     uint8         `json:"command"`
+	Destination string        `json:"destination"`
+	// Egress, Remote, Resolved and DialDuration are of the dial, empty if not dialed
+	Egress       string        `json:"egress"`
+	Remote       string        `json:"remote"`
+	Resolved     string        `json:"resolved"`
+	DialDuration time.Duration `json:"dial_duration_ns"`
+	BytesUp      uint64        `json:"bytes_up"`
+	BytesDown    uint64        `json:"bytes_down"`
+	CloseReason  string        `json:"close_reason"`
+	// Error the session ended with, empty if ended normally
+	Error string `json:"error"`
+	// Rule and RuleReason of the rule decision, empty if the rule set does not record it
+	Rule       string `json:"rule"`
+	RuleReason string `json:"rule_reason"`
+}
+
+// UsageRecord is the stable schema of a UsageEntry of the UsageReport interval,
+// numbered the same as the SessionRecord.
+type UsageRecord struct {
+	Start       time.Time `json:"start"`
+	End         time.Time `json:"end"`
+	User        string    `json:"user"`
+	Destination string    `json:"destination"`
+	Sessions    uint64    `json:"sessions"`
+	Denials     uint64    `json:"denials"`
+	BytesUp     uint64    `json:"bytes_up"`
+	BytesDown   uint64    `json:"bytes_down"`
+}
+
+// NewSessionRecord returns the record of the session ended at end with the error, end is on the
+// Clock of the server the session started on, such as the time the session close handle called,
+// or now if the session is active.
+func NewSessionRecord(s Session, end time.Time, err error) SessionRecord {
+	rec := SessionRecord{
+		ID:          s.ID,
+		Start:       s.Started,
+		Duration:    end.Sub(s.Started),
+		User:        s.User,
+		Tenant:      s.Tenant,
+		Command:     s.Command,
+		Destination: s.DestAddr,
+		BytesUp:     s.BytesUp,
+		BytesDown:   s.BytesDown,
+		CloseReason: s.CloseReason.String(),
+		Rule:        s.Rule,
+		RuleReason:  s.RuleReason,
+	}
+	if s.ClientAddr != nil {
+		rec.Client = s.ClientAddr.String()
+	}
+	if s.Dial != nil {
+		rec.Egress, rec.Remote = s.Dial.LocalAddr.String(), s.Dial.RemoteAddr.String()
+		rec.DialDuration = s.Dial.Duration
+		if s.Dial.ResolvedIP != nil {
+			rec.Resolved = s.Dial.ResolvedIP.String()
+		}
+	}
+	if err != nil {
+		rec.Error = err.Error()
+	}
+	return rec
+}
+
+// Records returns the records of the entries of the report
+func (sf UsageReport) Records() []UsageRecord {
+	records := make([]UsageRecord, 0, len(sf.Entries))
+	for _, e := range sf.Entries {
+		records = append(records, UsageRecord{
+			Start:       sf.Start,
+			End:         sf.End,
+			User:        e.User,
+			Destination: e.Destination,
+			Sessions:    e.Sessions,
+			Denials:     e.Denials,
+			BytesUp:     e.BytesUp,
+			BytesDown:   e.BytesDown,
+		})
+	}
+	return records
+}
+
+// Encode writes the records of the report by the encoder
+func (sf UsageReport) Encode(w io.Writer, enc RecordEncoder) error {
+	for _, rec := range sf.Records() {
+		if err := enc.EncodeUsage(w, &rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RecordEncoder serializes the records, each record is written by a single Write,
+// so the records written concurrently to the same writer are not interleaved,
+// such as JSONEncoder, CSVEncoder and ProtobufEncoder.
+type RecordEncoder interface {
+	EncodeSession(w io.Writer, rec *SessionRecord) error
+	EncodeUsage(w io.Writer, rec *UsageRecord) error
+}
+
+// recordField is a field of a record in the schema order
+type recordField struct {
+	name  string
+	value interface{} // uint64, int64, string or time.Time
+}
+
+func (sf *SessionRecord) fields() []recordField {
+	return []recordField{
+		{"id", sf.ID},
+		{"start", sf.Start},
+		{"duration_ns", int64(sf.Duration)},
+		{"client", sf.Client},
+		{"user", sf.User},
+		{"tenant", sf.Tenant},
+		{"command", uint64(sf.Command)},
+		{"destination", sf.Destination},
+		{"egress", sf.Egress},
+		{"remote", sf.Remote},
+		{"resolved", sf.Resolved},
+		{"dial_duration_ns", int64(sf.DialDuration)},
+		{"bytes_up", sf.BytesUp},
+		{"bytes_down", sf.BytesDown},
+		{"close_reason", sf.CloseReason},
+		{"error", sf.Error},
+		{"rule", sf.Rule},
+		{"rule_reason", sf.RuleReason},
+	}
+}
+
+func (sf *UsageRecord) fields() []recordField {
+	return []recordField{
+		{"start", sf.Start},
+		{"end", sf.End},
+		{"user", sf.User},
+		{"destination", sf.Destination},
+		{"sessions", sf.Sessions},
+		{"denials", sf.Denials},
+		{"bytes_up", sf.BytesUp},
+		{"bytes_down", sf.BytesDown},
+	}
+}
+
+// SessionRecordColumns returns the names of the SessionRecord fields in order, the JSON names
+// and the CSV header.
+func SessionRecordColumns() []string { return columns((&SessionRecord{}).fields()) }
+
+// UsageRecordColumns returns the names of the UsageRecord fields in order, the JSON names
+// and the CSV header.
+func UsageRecordColumns() []string { return columns((&UsageRecord{}).fields()) }
+
+func columns(fields []recordField) []string {
+	names := make([]string, 0, len(fields))
+	for _, f := range fields {
+		names = append(names, f.name)
+	}
+	return names
+}
+
+// JSONEncoder encodes a record per line of JSON object, the time in RFC 3339
+type JSONEncoder struct{}
+
+// EncodeSession implement interface RecordEncoder
+func (JSONEncoder) EncodeSession(w io.Writer, rec *SessionRecord) error { return encodeJSON(w, rec) }
+
+// EncodeUsage implement interface RecordEncoder
+func (JSONEncoder) EncodeUsage(w io.Writer, rec *UsageRecord) error { return encodeJSON(w, rec) }
+
+func encodeJSON(w io.Writer, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
+
+// CSVEncoder encodes a record per CSV line in the order of SessionRecordColumns and
+// UsageRecordColumns, the time in RFC 3339, no header written.
+type CSVEncoder struct{}
+
+// EncodeSession implement interface RecordEncoder
+func (CSVEncoder) EncodeSession(w io.Writer, rec *SessionRecord) error {
+	return encodeCSV(w, rec.fields())
+}
+
+// EncodeUsage implement interface RecordEncoder
+func (CSVEncoder) EncodeUsage(w io.Writer, rec *UsageRecord) error {
+	return encodeCSV(w, rec.fields())
+}
+
+func encodeCSV(w io.Writer, fields []recordField) error {
+	row := make([]string, 0, len(fields))
+	for _, f := range fields {
+		switch v := f.value.(type) {
+		case uint64:
+			row = append(row, strconv.FormatUint(v, 10))
+		case int64:
+			row = append(row, strconv.FormatInt(v, 10))
+		case string:
+			row = append(row, v)
+		case time.Time:
+			row = append(row, v.Format(time.RFC3339Nano))
+		}
+	}
+	var buf bytes.Buffer
+	cw := csv.NewWriter(&buf)
+	cw.Write(row) // nolint: errcheck
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		return err
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// ProtobufEncoder encodes a record per protobuf message prefixed by the varint length, the
+// same as the delimited messages of the protobuf libraries. The field numbers are in the order
+// of the fields from 1, the integers are varint, the durations int64 nanoseconds, the times
+// int64 unix nanoseconds and the strings length delimited, the zero values are omitted.
+type ProtobufEncoder struct{}
+
+// EncodeSession implement interface RecordEncoder
+func (ProtobufEncoder) EncodeSession(w io.Writer, rec *SessionRecord) error {
+	return encodeProtobuf(w, rec.fields())
+}
+
+// EncodeUsage implement interface RecordEncoder
+func (ProtobufEncoder) EncodeUsage(w io.Writer, rec *UsageRecord) error {
+	return encodeProtobuf(w, rec.fields())
+}
+
+// protobuf wire types
+const (
+	wireVarint = 0
+	wireBytes  = 2
+)
+
+func encodeProtobuf(w io.Writer, fields []recordField) error {
+	var msg []byte
+	for i, f := range fields {
+		num := uint64(i + 1)
+		switch v := f.value.(type) {
+		case uint64:
+			if v != 0 {
+				msg = appendVarint(appendVarint(msg, num<<3|wireVarint), v)
+			}
+		case int64:
+			if v != 0 {
+				msg = appendVarint(appendVarint(msg, num<<3|wireVarint), uint64(v))
+			}
+		case string:
+			if v != "" {
+				msg = appendVarint(appendVarint(msg, num<<3|wireBytes), uint64(len(v)))
+				msg = append(msg, v...)
+			}
+		case time.Time:
+			if !v.IsZero() {
+				msg = appendVarint(appendVarint(msg, num<<3|wireVarint), uint64(v.UnixNano()))
+			}
+		}
+	}
+	_, err := w.Write(append(appendVarint(make([]byte, 0, len(msg)+binary.MaxVarintLen64), uint64(len(msg))), msg...))
+	return err
+}
+
+func appendVarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(b, buf[:binary.PutUvarint(buf[:], v)]...)
+}
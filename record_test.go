@@ -0,0 +1,131 @@
+package socks5
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testSessionRecord() SessionRecord {
+	start := time.Date(2020, 7, 1, 12, 0, 0, 0, time.UTC)
+	return NewSessionRecord(Session{
+		ID:         7,
+		ClientAddr: &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 5000},
+		Command:    1,
+		DestAddr:   "example.com:443",
+		User:       "foo",
+		Dial: &DialInfo{
+			LocalAddr:  &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 6000},
+			RemoteAddr: &net.TCPAddr{IP: net.IPv4(93, 184, 216, 34), Port: 443},
+			ResolvedIP: net.IPv4(93, 184, 216, 34),
+			Duration:   time.Millisecond,
+		},
+		Started:     start,
+		BytesUp:     10,
+		BytesDown:   20,
+		CloseReason: CloseReasonClientEOF,
+		Rule:        "lan",
+		RuleReason:  "matched rule lan",
+	}, start.Add(time.Second), errors.New("boom"))
+}
+
+func TestSessionRecord(t *testing.T) {
+	rec := testSessionRecord()
+	require.Equal(t, "127.0.0.1:5000", rec.Client)
+	require.Equal(t, "10.0.0.1:6000", rec.Egress)
+	require.Equal(t, "93.184.216.34", rec.Resolved)
+	require.Equal(t, "client_eof", rec.CloseReason)
+	require.Equal(t, "boom", rec.Error)
+	require.Equal(t, "lan", rec.Rule)
+	require.Equal(t, "matched rule lan", rec.RuleReason)
+	require.Equal(t, time.Second, rec.Duration)
+
+	now := time.Now()
+	rec = NewSessionRecord(Session{Started: now}, now, nil)
+	require.Empty(t, rec.Client)
+	require.Empty(t, rec.Egress)
+	require.Empty(t, rec.Error)
+}
+
+func TestJSONEncoder(t *testing.T) {
+	rec := testSessionRecord()
+	rec.Duration = time.Second
+	b := new(bytes.Buffer)
+	require.NoError(t, JSONEncoder{}.EncodeSession(b, &rec))
+	require.True(t, strings.HasSuffix(b.String(), "}\n"))
+
+	// the JSON names are the columns
+	var m map[string]interface{}
+	require.NoError(t, json.Unmarshal(b.Bytes(), &m))
+	require.Len(t, m, len(SessionRecordColumns()))
+	for _, c := range SessionRecordColumns() {
+		require.Contains(t, m, c)
+	}
+	require.Equal(t, float64(time.Second), m["duration_ns"])
+	require.Equal(t, "2020-07-01T12:00:00Z", m["start"])
+}
+
+func TestCSVEncoder(t *testing.T) {
+	rec := testSessionRecord()
+	rec.Duration = time.Second
+	b := new(bytes.Buffer)
+	require.NoError(t, CSVEncoder{}.EncodeSession(b, &rec))
+	require.Equal(t, "7,2020-07-01T12:00:00Z,1000000000,127.0.0.1:5000,foo,,1,example.com:443,10.0.0.1:6000,"+
+		"93.184.216.34:443,93.184.216.34,1000000,10,20,client_eof,boom,lan,matched rule lan\n", b.String())
+
+	b.Reset()
+	report := UsageReport{
+		Start:   time.Date(2020, 7, 1, 0, 0, 0, 0, time.UTC),
+		End:     time.Date(2020, 7, 2, 0, 0, 0, 0, time.UTC),
+		Entries: []UsageEntry{{User: "foo", Destination: "a,b:80", Sessions: 1, BytesUp: 2, BytesDown: 3}},
+	}
+	require.NoError(t, report.Encode(b, CSVEncoder{}))
+	require.Equal(t, "2020-07-01T00:00:00Z,2020-07-02T00:00:00Z,foo,\"a,b:80\",1,0,2,3\n", b.String())
+	require.Equal(t, []string{"start", "end", "user", "destination", "sessions", "denials", "bytes_up", "bytes_down"},
+		UsageRecordColumns())
+}
+
+func TestProtobufEncoder(t *testing.T) {
+	rec := UsageRecord{User: "foo", Sessions: 300}
+	b := new(bytes.Buffer)
+	require.NoError(t, ProtobufEncoder{}.EncodeUsage(b, &rec))
+	// length, field 3 bytes "foo", field 5 varint 300, the zero values omitted
+	require.Equal(t, []byte{8, 3<<3 | 2, 3, 'f', 'o', 'o', 5 << 3, 0xac, 0x02}, b.Bytes())
+
+	b.Reset()
+	full := testSessionRecord()
+	require.NoError(t, ProtobufEncoder{}.EncodeSession(b, &full))
+	n, err := binary.ReadUvarint(b)
+	require.NoError(t, err)
+	require.Equal(t, int(n), b.Len())
+}
+
+func TestServer_AccessLogEncoder(t *testing.T) {
+	target := echoTarget(t)
+	accessLog := &syncBuffer{}
+	closed := make(chan struct{})
+	srv := NewServer(
+		WithAccessLog(log.New(accessLog, "", 0)),
+		WithAccessLogEncoder(JSONEncoder{}),
+		WithSessionCloseHandle(func(Session, error) { close(closed) }),
+	)
+	proxy, _ := startServer(t, srv)
+	defer srv.Close()
+
+	relaySession(t, proxy, target).Close()
+	<-closed
+	var rec SessionRecord
+	require.NoError(t, json.Unmarshal([]byte(accessLog.String()), &rec))
+	require.Equal(t, target.String(), rec.Destination)
+	require.Equal(t, target.String(), rec.Remote)
+	require.Equal(t, uint64(4), rec.BytesUp)
+	require.Equal(t, "client_eof", rec.CloseReason)
+}
@@ -0,0 +1,57 @@
+package socks5
+
+import (
+	"net"
+	"sync"
+
+	"github.com/thinkgos/go-socks5/statute"
+)
+
+// replyMaxLen is the max length of the reply with the IPv6 bound address
+const replyMaxLen = 4 + net.IPv6len + 2
+
+// failureReplies are the pre-encoded failure replies indexed by the reply code,
+// the bound address of the failure reply is always the zero IPv4 address.
+var failureReplies = func() (r [256][]byte) {
+	for i := range r {
+		r[i] = statute.Reply{
+			Version:  statute.VersionSocks5,
+			Response: uint8(i),
+			BndAddr:  statute.AddrSpec{AddrType: statute.ATYPIPv4, IP: net.IPv4zero},
+		}.Bytes()
+	}
+	return r
+}()
+
+// replyPool is the reusable buffers to write the success replies.
+var replyPool = sync.Pool{
+	New: func() interface{} { return new([replyMaxLen]byte) },
+}
+
+// AppendReply appends the reply with the bound address to b and returns the extended buffer,
+// same as SendReply writes, it does not allocate if b has enough capacity.
+func AppendReply(b []byte, rep uint8, bindAddr net.Addr) []byte {
+	if rep != statute.RepSuccess {
+		return append(b, failureReplies[rep]...)
+	}
+
+	var ip net.IP
+	var port int
+	if tcpAddr, ok := bindAddr.(*net.TCPAddr); ok && tcpAddr != nil {
+		ip, port = tcpAddr.IP, tcpAddr.Port
+	} else if udpAddr, ok := bindAddr.(*net.UDPAddr); ok && udpAddr != nil {
+		ip, port = udpAddr.IP, udpAddr.Port
+	} else {
+		return append(b, failureReplies[statute.RepAddrTypeNotSupported]...)
+	}
+
+	rsp := statute.Reply{
+		Version:  statute.VersionSocks5,
+		Response: rep,
+		BndAddr:  statute.AddrSpec{AddrType: statute.ATYPIPv4, IP: ip, Port: port},
+	}
+	if ip.To4() == nil && ip.To16() != nil {
+		rsp.BndAddr.AddrType = statute.ATYPIPv6
+	}
+	return rsp.AppendTo(b)
+}
@@ -0,0 +1,77 @@
+package socks5
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/thinkgos/go-socks5/statute"
+)
+
+func TestAppendReply(t *testing.T) {
+	tests := []struct {
+		name     string
+		rep      uint8
+		bindAddr net.Addr
+		want     []byte
+	}{
+		{
+			"success ipv4",
+			statute.RepSuccess,
+			&net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 8080},
+			[]byte{statute.VersionSocks5, statute.RepSuccess, 0, statute.ATYPIPv4, 127, 0, 0, 1, 0x1f, 0x90},
+		},
+		{
+			"success ipv6",
+			statute.RepSuccess,
+			&net.UDPAddr{IP: net.IPv6loopback, Port: 8080},
+			[]byte{
+				statute.VersionSocks5, statute.RepSuccess, 0, statute.ATYPIPv6,
+				0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1, 0x1f, 0x90,
+			},
+		},
+		{
+			"success unknown address",
+			statute.RepSuccess,
+			nil,
+			[]byte{statute.VersionSocks5, statute.RepAddrTypeNotSupported, 0, statute.ATYPIPv4, 0, 0, 0, 0, 0, 0},
+		},
+		{
+			"failure",
+			statute.RepHostUnreachable,
+			&net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 8080},
+			[]byte{statute.VersionSocks5, statute.RepHostUnreachable, 0, statute.ATYPIPv4, 0, 0, 0, 0, 0, 0},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, AppendReply(nil, tt.rep, tt.bindAddr))
+
+			buf := new(bytes.Buffer)
+			require.NoError(t, SendReply(buf, tt.rep, tt.bindAddr))
+			require.Equal(t, tt.want, buf.Bytes())
+		})
+	}
+}
+
+func TestSendReply_Allocs(t *testing.T) {
+	v4 := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 8080}
+	v6 := &net.TCPAddr{IP: net.IPv6loopback, Port: 8080}
+	allocs := testing.AllocsPerRun(100, func() {
+		SendReply(ioutil.Discard, statute.RepSuccess, v4)         // nolint: errcheck
+		SendReply(ioutil.Discard, statute.RepSuccess, v6)         // nolint: errcheck
+		SendReply(ioutil.Discard, statute.RepHostUnreachable, v4) // nolint: errcheck
+	})
+	require.Zero(t, allocs)
+}
+
+func BenchmarkSendReply(b *testing.B) {
+	bindAddr := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 8080}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		SendReply(ioutil.Discard, statute.RepSuccess, bindAddr) // nolint: errcheck
+	}
+}
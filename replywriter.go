@@ -0,0 +1,99 @@
+package socks5
+
+import (
+	"io"
+	"sync"
+)
+
+// ReplyWriter batches the replies written to the client, the embedders terminating SOCKS inside
+// another framed transport, such as the websocket messages or the grpc streams, control how the
+// replies are framed and flushed, such as a message per Flush. A bufio.Writer is a ReplyWriter.
+//
+// The server calls Flush before it waits for the client, before it waits for the peer of the BIND,
+// and when the session ends, so the replies of a step, such as the failure reply and the reply
+// detail, are flushed together. Once the relay starts, each write of the data relayed is flushed.
+// The ReplyWriter is not used concurrently.
+type ReplyWriter interface {
+	io.Writer
+	Flush() error
+}
+
+// replyBuffer writes to the ReplyWriter, flushing each write once relaying
+type replyBuffer struct {
+	ReplyWriter
+	mu       sync.Mutex // serializes both directions of the relay
+	relaying bool
+	// discard the replies before relaying, such as the transparent connections
+	discard bool
+}
+
+// Write implement interface io.Writer
+func (sf *replyBuffer) Write(p []byte) (int, error) {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	if sf.discard && !sf.relaying {
+		return len(p), nil
+	}
+	n, err := sf.ReplyWriter.Write(p)
+	if err == nil && sf.relaying {
+		err = sf.ReplyWriter.Flush()
+	}
+	return n, err
+}
+
+// Flush implement interface ReplyWriter
+func (sf *replyBuffer) Flush() error {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	return sf.ReplyWriter.Flush()
+}
+
+// CloseWrite implement interface closeWriter, the replies written are flushed first
+func (sf *replyBuffer) CloseWrite() error {
+	if err := sf.Flush(); err != nil {
+		return err
+	}
+	if c, ok := sf.ReplyWriter.(closeWriter); ok {
+		return c.CloseWrite()
+	}
+	return nil
+}
+
+// flush the replies written, nil or relaying has nothing to flush
+func (sf *replyBuffer) flush() error {
+	if sf == nil {
+		return nil
+	}
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	if sf.relaying {
+		return nil
+	}
+	return sf.ReplyWriter.Flush()
+}
+
+// startRelay flushes the replies, the writes are flushed each from now on
+func (sf *replyBuffer) startRelay() error {
+	if sf == nil {
+		return nil
+	}
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	sf.relaying = true
+	return sf.ReplyWriter.Flush()
+}
+
+// flushReader flushes the replies before reading the client, it is read by the bufio.Reader
+// of the connection only when no data buffered, that is the server waits for the client.
+type flushReader struct {
+	io.Reader
+	replies *replyBuffer
+}
+
+// Read implement interface io.Reader
+func (sf *flushReader) Read(p []byte) (int, error) {
+	if err := sf.replies.flush(); err != nil {
+		return 0, err
+	}
+	return sf.Reader.Read(p)
+}
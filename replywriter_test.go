@@ -0,0 +1,138 @@
+package socks5
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/thinkgos/go-socks5/statute"
+)
+
+// messageWriter writes the bytes written before each Flush as a message
+type messageWriter struct {
+	w        io.Writer
+	buf      bytes.Buffer
+	mu       sync.Mutex
+	messages [][]byte
+}
+
+func (m *messageWriter) Write(p []byte) (int, error) { return m.buf.Write(p) }
+
+func (m *messageWriter) Flush() error {
+	if m.buf.Len() == 0 {
+		return nil
+	}
+	msg := append([]byte{}, m.buf.Bytes()...)
+	m.buf.Reset()
+	m.mu.Lock()
+	m.messages = append(m.messages, msg)
+	m.mu.Unlock()
+	_, err := m.w.Write(msg)
+	return err
+}
+
+func (m *messageWriter) flushed() [][]byte {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.messages
+}
+
+func serveReplyWriter(t *testing.T, opts ...Option) (net.Addr, chan *messageWriter, chan struct{}) {
+	writers := make(chan *messageWriter, 1)
+	closed := make(chan struct{}, 1)
+	opts = append(opts,
+		WithReplyWriter(func(w io.Writer) ReplyWriter {
+			m := &messageWriter{w: w}
+			writers <- m
+			return m
+		}),
+		WithSessionCloseHandle(func(Session, error) { closed <- struct{}{} }),
+	)
+	srv := NewServer(opts...)
+	proxy, _ := startServer(t, srv)
+	t.Cleanup(func() { srv.Close() })
+	return proxy, writers, closed
+}
+
+func TestReplyWriter_Flush(t *testing.T) {
+	target := echoTarget(t)
+	proxy, writers, closed := serveReplyWriter(t, WithCredential(StaticCredentials{"foo": "bar"}))
+
+	conn, err := net.Dial("tcp", proxy.String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte{statute.VersionSocks5, 1, statute.MethodUserPassAuth})
+	require.NoError(t, err)
+	_, err = statute.ParseMethodReply(conn)
+	require.NoError(t, err)
+	_, err = conn.Write(statute.NewUserPassRequest(statute.UserPassAuthVersion, []byte("foo"), []byte("bar")).Bytes())
+	require.NoError(t, err)
+	_, err = statute.ParseUserPassReply(conn)
+	require.NoError(t, err)
+	_, err = conn.Write(statute.Request{
+		Version: statute.VersionSocks5,
+		Command: statute.CommandConnect,
+		DstAddr: statute.AddrSpec{AddrType: statute.ATYPIPv4, IP: target.IP, Port: target.Port},
+	}.Bytes())
+	require.NoError(t, err)
+	rep, err := statute.ParseReply(conn)
+	require.NoError(t, err)
+	require.Equal(t, statute.RepSuccess, rep.Response)
+	// the data relayed is flushed each
+	_, err = conn.Write([]byte("ping"))
+	require.NoError(t, err)
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(conn, buf)
+	require.NoError(t, err)
+	conn.Close()
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("session close not notified")
+	}
+
+	messages := (<-writers).flushed()
+	require.Len(t, messages, 4)
+	require.Equal(t, []byte{statute.VersionSocks5, statute.MethodUserPassAuth}, messages[0])
+	require.Equal(t, []byte{statute.UserPassAuthVersion, statute.AuthSuccess}, messages[1])
+	require.Len(t, messages[2], 10)
+	require.Equal(t, "ping", string(messages[3]))
+}
+
+func TestReplyWriter_Batched(t *testing.T) {
+	_, loopback, _ := net.ParseCIDR("127.0.0.0/8")
+	proxy, writers, closed := serveReplyWriter(t,
+		WithRule(NewPermitNone()), WithReplyDetail([]*net.IPNet{loopback}))
+
+	conn, err := net.Dial("tcp", proxy.String())
+	require.NoError(t, err)
+	defer conn.Close()
+	// the request pipelined, the server never waits for the client
+	req := bytes.NewBuffer([]byte{statute.VersionSocks5, 1, statute.MethodNoAuth})
+	req.Write(statute.Request{
+		Version: statute.VersionSocks5,
+		Command: statute.CommandConnect,
+		DstAddr: statute.AddrSpec{AddrType: statute.ATYPIPv4, IP: net.IPv4(127, 0, 0, 1), Port: 1},
+	}.Bytes())
+	_, err = conn.Write(req.Bytes())
+	require.NoError(t, err)
+	got, err := ioutil.ReadAll(conn)
+	require.NoError(t, err)
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("session close not notified")
+	}
+
+	// the method reply, the failure reply and the reply detail flushed together
+	want := append([]byte{statute.VersionSocks5, statute.MethodNoAuth}, failureReplies[statute.RepRuleFailure]...)
+	want = append(want, statute.NewReplyDetail(statute.DetailRuleDenied).Bytes()...)
+	require.Equal(t, want, got)
+	require.Equal(t, [][]byte{want}, (<-writers).flushed())
+}
@@ -2,22 +2,164 @@ package socks5
 
 import (
 	"context"
+	"errors"
 	"net"
+	"time"
 )
 
-// NameResolver is used to implement custom name resolution
+// NameResolver is used to implement custom name resolution, the context carries the
+// request, so the per user policies are possible, see AuthContextFromContext.
 type NameResolver interface {
 	Resolve(ctx context.Context, name string) (context.Context, net.IP, error)
 }
 
+// AddrResolver resolves all the addresses of the name with the TTL of the answers,
+// 0 means the TTL is unknown, such as by the system resolver.
+type AddrResolver interface {
+	LookupAddrs(ctx context.Context, name string) ([]net.IP, time.Duration, error)
+}
+
+// IPPolicy is the policy which picks the address from both the A and AAAA answers
+type IPPolicy uint8
+
+// ip policy defined
+const (
+	// PreferIPv4 picks the IPv4 address if any, otherwise the IPv6
+	PreferIPv4 IPPolicy = iota
+	// PreferIPv6 picks the IPv6 address if any, otherwise the IPv4
+	PreferIPv6
+	// IPv4Only picks the IPv4 address only
+	IPv4Only
+	// IPv6Only picks the IPv6 address only
+	IPv6Only
+)
+
+// String implement interface fmt.Stringer
+func (p IPPolicy) String() string {
+	switch p {
+	case PreferIPv4:
+		return "prefer_ipv4"
+	case PreferIPv6:
+		return "prefer_ipv6"
+	case IPv4Only:
+		return "ipv4_only"
+	case IPv6Only:
+		return "ipv6_only"
+	}
+	return "unknown"
+}
+
+// errNoAddress no address of the policy
+var errNoAddress = errors.New("no address of the ip policy")
+
+// pickIP picks the address by the policy, nil if none
+func pickIP(ips []net.IP, policy IPPolicy) net.IP {
+	var ip4, ip6 net.IP
+	for _, ip := range ips {
+		if ip.To4() != nil {
+			if ip4 == nil {
+				ip4 = ip
+			}
+		} else if ip6 == nil {
+			ip6 = ip
+		}
+	}
+	switch policy {
+	case PreferIPv6:
+		if ip6 != nil {
+			return ip6
+		}
+		return ip4
+	case IPv4Only:
+		return ip4
+	case IPv6Only:
+		return ip6
+	default:
+		if ip4 != nil {
+			return ip4
+		}
+		return ip6
+	}
+}
+
+// resolveBy resolves the name by the AddrResolver, picks the address by the policy
+func resolveBy(ctx context.Context, r AddrResolver, name string, policy IPPolicy) (context.Context, net.IP, error) {
+	ips, _, err := r.LookupAddrs(ctx, name)
+	if err != nil {
+		return ctx, nil, err
+	}
+	ip := pickIP(ips, policy)
+	if ip == nil {
+		return ctx, nil, &net.DNSError{Err: errNoAddress.Error(), Name: name, IsNotFound: true}
+	}
+	return ctx, ip, nil
+}
+
 // DNSResolver uses the system DNS to resolve host names
 type DNSResolver struct{}
 
-// Resolve implement interface NameResolver
+// Resolve implement interface NameResolver, IPv4 address preferred
 func (d DNSResolver) Resolve(ctx context.Context, name string) (context.Context, net.IP, error) {
-	addr, err := net.ResolveIPAddr("ip", name)
+	return resolveBy(ctx, d, name, PreferIPv4)
+}
+
+// LookupAddrs implement interface AddrResolver, the system resolver does not report the TTL
+func (d DNSResolver) LookupAddrs(ctx context.Context, name string) ([]net.IP, time.Duration, error) {
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, name)
 	if err != nil {
-		return ctx, nil, err
+		return nil, 0, err
+	}
+	ips := make([]net.IP, 0, len(addrs))
+	for _, addr := range addrs {
+		ips = append(ips, addr.IP)
+	}
+	return ips, 0, nil
+}
+
+// PolicyResolver resolves both the A and AAAA of the name, and picks the address by the policy,
+// the PolicyFunc selects the policy per request, such as per user by AuthContextFromContext.
+type PolicyResolver struct {
+	// Resolver resolves the addresses, defaults to DNSResolver
+	Resolver AddrResolver
+	// Policy picks the address, defaults to PreferIPv4
+	Policy IPPolicy
+	// PolicyFunc returns the policy of the request, it takes precedence over the Policy
+	PolicyFunc func(ctx context.Context) IPPolicy
+}
+
+// Resolve implement interface NameResolver
+func (sf PolicyResolver) Resolve(ctx context.Context, name string) (context.Context, net.IP, error) {
+	var r AddrResolver = DNSResolver{}
+	if sf.Resolver != nil {
+		r = sf.Resolver
+	}
+	policy := sf.Policy
+	if sf.PolicyFunc != nil {
+		policy = sf.PolicyFunc(ctx)
+	}
+	return resolveBy(ctx, r, name, policy)
+}
+
+// LookupAddrs implement interface AddrResolver, the addresses of the other family are
+// dropped by IPv4Only and IPv6Only
+func (sf PolicyResolver) LookupAddrs(ctx context.Context, name string) ([]net.IP, time.Duration, error) {
+	var r AddrResolver = DNSResolver{}
+	if sf.Resolver != nil {
+		r = sf.Resolver
+	}
+	ips, ttl, err := r.LookupAddrs(ctx, name)
+	if err != nil {
+		return nil, 0, err
+	}
+	policy := sf.Policy
+	if sf.PolicyFunc != nil {
+		policy = sf.PolicyFunc(ctx)
+	}
+	switch policy {
+	case IPv4Only:
+		ips = filterFamily(ips, "tcp4")
+	case IPv6Only:
+		ips = filterFamily(ips, "tcp6")
 	}
-	return ctx, addr.IP, err
+	return ips, ttl, nil
 }
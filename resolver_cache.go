@@ -0,0 +1,163 @@
+package socks5
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+// CachingResolver caches the addresses resolved by the Resolver for the TTL of the answers,
+// clamped to the MinTTL and the MaxTTL. The zero value is usable, which caches nothing
+// resolved by the system resolver, as it does not report the TTL, set the MinTTL for it.
+type CachingResolver struct {
+	// Resolver resolves the addresses on the cache miss, defaults to DNSResolver
+	Resolver AddrResolver
+	// MinTTL is the min duration cached, also the duration of the answer without the TTL
+	MinTTL time.Duration
+	// MaxTTL is the max duration cached, 0 means no limit
+	MaxTTL time.Duration
+	// NegativeTTL is the duration the failure cached, 0 means not cached
+	NegativeTTL time.Duration
+	// MaxEntries limits the names cached, 0 means no limit
+	MaxEntries int
+	// Policy picks the address of Resolve, defaults to PreferIPv4
+	Policy IPPolicy
+	// Clock of the expiration, defaults to SystemClock
+	Clock Clock
+
+	mu      sync.Mutex
+	entries map[string]*dnsCacheEntry
+}
+
+type dnsCacheEntry struct {
+	ips     []net.IP
+	err     error
+	expires time.Time
+	// ready is closed once resolved, the concurrent misses wait for it
+	ready chan struct{}
+}
+
+// Resolve implement interface NameResolver
+func (sf *CachingResolver) Resolve(ctx context.Context, name string) (context.Context, net.IP, error) {
+	return resolveBy(ctx, sf, name, sf.Policy)
+}
+
+// LookupAddrs implement interface AddrResolver, the TTL is the remaining of the cached
+func (sf *CachingResolver) LookupAddrs(ctx context.Context, name string) ([]net.IP, time.Duration, error) {
+	now := sf.now()
+	sf.mu.Lock()
+	if sf.entries == nil {
+		sf.entries = make(map[string]*dnsCacheEntry)
+	}
+	e, ok := sf.entries[name]
+	if ok {
+		select {
+		case <-e.ready:
+			if now.Before(e.expires) {
+				sf.mu.Unlock()
+				return e.ips, e.expires.Sub(now), e.err
+			}
+			ok = false
+		default:
+		}
+	}
+	if !ok {
+		sf.evict(now)
+		e = &dnsCacheEntry{ready: make(chan struct{})}
+		sf.entries[name] = e
+		sf.mu.Unlock()
+		// shared by the concurrent misses, not canceled by the caller
+		go sf.resolve(detachedContext{ctx}, name, e)
+	} else {
+		sf.mu.Unlock()
+	}
+
+	select {
+	case <-e.ready:
+	case <-ctx.Done():
+		return nil, 0, ctx.Err()
+	}
+	var ttl time.Duration
+	if e.err == nil {
+		ttl = e.expires.Sub(now)
+	}
+	return e.ips, ttl, e.err
+}
+
+// resolve resolves the name of the entry on the context detached from the caller, the entry is
+// removed if not cached
+func (sf *CachingResolver) resolve(ctx context.Context, name string, e *dnsCacheEntry) {
+	var r AddrResolver = DNSResolver{}
+	if sf.Resolver != nil {
+		r = sf.Resolver
+	}
+	ips, ttl, err := r.LookupAddrs(ctx, name)
+	if err != nil {
+		ttl = sf.NegativeTTL
+	} else {
+		if ttl < sf.MinTTL {
+			ttl = sf.MinTTL
+		}
+		if sf.MaxTTL > 0 && ttl > sf.MaxTTL {
+			ttl = sf.MaxTTL
+		}
+	}
+	e.ips, e.err, e.expires = ips, err, sf.now().Add(ttl)
+	sf.mu.Lock()
+	if ttl <= 0 && sf.entries[name] == e {
+		delete(sf.entries, name)
+	}
+	sf.mu.Unlock()
+	close(e.ready)
+}
+
+// evict removes the expired entries if the cache is full, then an arbitrary one if still full
+func (sf *CachingResolver) evict(now time.Time) {
+	if sf.MaxEntries <= 0 || len(sf.entries) < sf.MaxEntries {
+		return
+	}
+	for name, e := range sf.entries {
+		select {
+		case <-e.ready:
+			if !now.Before(e.expires) {
+				delete(sf.entries, name)
+			}
+		default:
+		}
+	}
+	for name := range sf.entries {
+		if len(sf.entries) < sf.MaxEntries {
+			break
+		}
+		delete(sf.entries, name)
+	}
+}
+
+// Flush removes all the cached entries
+func (sf *CachingResolver) Flush() {
+	sf.mu.Lock()
+	sf.entries = nil
+	sf.mu.Unlock()
+}
+
+func (sf *CachingResolver) now() time.Time {
+	if sf.Clock == nil {
+		return time.Now()
+	}
+	return sf.Clock.Now()
+}
+
+// detachedContext keeps the values of the context, but neither its cancellation nor its deadline
+type detachedContext struct {
+	context.Context
+}
+
+// Deadline implement interface context.Context
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+
+// Done implement interface context.Context
+func (detachedContext) Done() <-chan struct{} { return nil }
+
+// Err implement interface context.Context
+func (detachedContext) Err() error { return nil }
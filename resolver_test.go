@@ -2,10 +2,22 @@ package socks5
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"golang.org/x/net/dns/dnsmessage"
 )
 
 func TestDNSResolver(t *testing.T) {
@@ -16,3 +28,304 @@ func TestDNSResolver(t *testing.T) {
 	require.NoError(t, err)
 	assert.True(t, addr.IsLoopback())
 }
+
+type addrResolverFunc func(ctx context.Context, name string) ([]net.IP, time.Duration, error)
+
+func (f addrResolverFunc) LookupAddrs(ctx context.Context, name string) ([]net.IP, time.Duration, error) {
+	return f(ctx, name)
+}
+
+func TestPickIP(t *testing.T) {
+	ip4, ip6 := net.IPv4(10, 0, 0, 1), net.ParseIP("2001:db8::1")
+	both := []net.IP{ip6, ip4}
+	assert.Equal(t, ip4, pickIP(both, PreferIPv4))
+	assert.Equal(t, ip6, pickIP(both, PreferIPv6))
+	assert.Equal(t, ip4, pickIP(both, IPv4Only))
+	assert.Equal(t, ip6, pickIP(both, IPv6Only))
+	assert.Equal(t, ip4, pickIP([]net.IP{ip4}, PreferIPv6))
+	assert.Nil(t, pickIP([]net.IP{ip4}, IPv6Only))
+	assert.Equal(t, "prefer_ipv6", PreferIPv6.String())
+}
+
+func TestPolicyResolver(t *testing.T) {
+	ip4, ip6 := net.IPv4(10, 0, 0, 1), net.ParseIP("2001:db8::1")
+	r := PolicyResolver{
+		Resolver: addrResolverFunc(func(context.Context, string) ([]net.IP, time.Duration, error) {
+			return []net.IP{ip4, ip6}, 0, nil
+		}),
+		// the per user policy
+		PolicyFunc: func(ctx context.Context) IPPolicy {
+			if ac, ok := AuthContextFromContext(ctx); ok && ac.Payload["username"] == "v6" {
+				return IPv6Only
+			}
+			return PreferIPv4
+		},
+	}
+	_, ip, err := r.Resolve(context.Background(), "example.com")
+	require.NoError(t, err)
+	assert.Equal(t, ip4, ip)
+
+	req := &Request{AuthContext: &AuthContext{Payload: map[string]string{"username": "v6"}}}
+	ctx := context.WithValue(context.Background(), requestContextKey{}, req)
+	_, ip, err = r.Resolve(ctx, "example.com")
+	require.NoError(t, err)
+	assert.Equal(t, ip6, ip)
+
+	r.PolicyFunc = nil
+	r.Resolver = addrResolverFunc(func(context.Context, string) ([]net.IP, time.Duration, error) {
+		return []net.IP{ip6}, 0, nil
+	})
+	r.Policy = IPv4Only
+	_, _, err = r.Resolve(context.Background(), "example.com")
+	var de *net.DNSError
+	require.True(t, errors.As(err, &de))
+	assert.True(t, de.IsNotFound)
+}
+
+func TestCachingResolver(t *testing.T) {
+	clock := newManualClock()
+	var calls int32
+	ttl := 30 * time.Second
+	fail := false
+	r := &CachingResolver{
+		Resolver: addrResolverFunc(func(context.Context, string) ([]net.IP, time.Duration, error) {
+			atomic.AddInt32(&calls, 1)
+			if fail {
+				return nil, 0, errors.New("servfail")
+			}
+			return []net.IP{net.IPv4(10, 0, 0, 1)}, ttl, nil
+		}),
+		MinTTL:      5 * time.Second,
+		MaxTTL:      time.Minute,
+		NegativeTTL: 10 * time.Second,
+		Clock:       clock,
+	}
+	ctx := context.Background()
+
+	_, ip, err := r.Resolve(ctx, "a.example")
+	require.NoError(t, err)
+	assert.Equal(t, net.IPv4(10, 0, 0, 1), ip)
+	clock.Advance(29 * time.Second)
+	_, remain, err := r.LookupAddrs(ctx, "a.example")
+	require.NoError(t, err)
+	assert.Equal(t, time.Second, remain)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+	// the TTL respected
+	clock.Advance(time.Second)
+	_, _, err = r.LookupAddrs(ctx, "a.example")
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+
+	// the TTL clamped to the min
+	ttl = 0
+	_, remain, err = r.LookupAddrs(ctx, "b.example")
+	require.NoError(t, err)
+	assert.Equal(t, 5*time.Second, remain)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&calls))
+
+	// the failure cached for the negative TTL
+	fail = true
+	_, _, err = r.LookupAddrs(ctx, "c.example")
+	require.Error(t, err)
+	_, _, err = r.LookupAddrs(ctx, "c.example")
+	require.Error(t, err)
+	assert.Equal(t, int32(4), atomic.LoadInt32(&calls))
+	clock.Advance(10 * time.Second)
+	_, _, err = r.LookupAddrs(ctx, "c.example")
+	require.Error(t, err)
+	assert.Equal(t, int32(5), atomic.LoadInt32(&calls))
+
+	r.Flush()
+	fail = false
+	r.MaxEntries = 1
+	_, _, err = r.LookupAddrs(ctx, "a.example")
+	require.NoError(t, err)
+	_, _, err = r.LookupAddrs(ctx, "b.example")
+	require.NoError(t, err)
+	r.mu.Lock()
+	assert.Len(t, r.entries, 1)
+	r.mu.Unlock()
+}
+
+func TestCachingResolver_CanceledCaller(t *testing.T) {
+	release := make(chan struct{})
+	r := &CachingResolver{
+		Resolver: addrResolverFunc(func(ctx context.Context, _ string) ([]net.IP, time.Duration, error) {
+			select {
+			case <-release:
+				return []net.IP{net.IPv4(10, 0, 0, 1)}, time.Minute, nil
+			case <-ctx.Done():
+				return nil, 0, ctx.Err()
+			}
+		}),
+	}
+	first, cancel := context.WithCancel(context.Background())
+	firstErr := make(chan error, 1)
+	go func() {
+		_, _, err := r.LookupAddrs(first, "a.example")
+		firstErr <- err
+	}()
+	waiter := make(chan error, 1)
+	go func() {
+		_, _, err := r.LookupAddrs(context.Background(), "a.example")
+		waiter <- err
+	}()
+	require.Eventually(t, func() bool {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		return len(r.entries) == 1
+	}, time.Second, time.Millisecond)
+
+	// the first caller canceled, the waiter still gets the answer
+	cancel()
+	require.Equal(t, context.Canceled, <-firstErr)
+	close(release)
+	require.NoError(t, <-waiter)
+}
+
+// dnsAnswer answers the query with the records of the name, truncated if tc
+func dnsAnswer(t *testing.T, query []byte, records map[dnsmessage.Type]net.IP, tc bool) []byte {
+	var p dnsmessage.Parser
+	h, err := p.Start(query)
+	require.NoError(t, err)
+	q, err := p.Question()
+	require.NoError(t, err)
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: h.ID, Response: true, Truncated: tc})
+	require.NoError(t, b.StartQuestions())
+	require.NoError(t, b.Question(q))
+	require.NoError(t, b.StartAnswers())
+	rh := dnsmessage.ResourceHeader{Name: q.Name, Class: dnsmessage.ClassINET, TTL: 300}
+	if ip, ok := records[q.Type]; ok && !tc {
+		if q.Type == dnsmessage.TypeA {
+			var a [4]byte
+			copy(a[:], ip.To4())
+			require.NoError(t, b.AResource(rh, dnsmessage.AResource{A: a}))
+		} else {
+			var a [16]byte
+			copy(a[:], ip.To16())
+			rh.TTL = 60
+			require.NoError(t, b.AAAAResource(rh, dnsmessage.AAAAResource{AAAA: a}))
+		}
+	}
+	answer, err := b.Finish()
+	require.NoError(t, err)
+	return answer
+}
+
+// serveDNSStream serves the dns over the stream listener
+func serveDNSStream(t *testing.T, l net.Listener, records map[dnsmessage.Type]net.IP) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			var length [2]byte
+			if _, err := io.ReadFull(conn, length[:]); err != nil {
+				return
+			}
+			query := make([]byte, binary.BigEndian.Uint16(length[:]))
+			if _, err := io.ReadFull(conn, query); err != nil {
+				return
+			}
+			answer := dnsAnswer(t, query, records, false)
+			binary.BigEndian.PutUint16(length[:], uint16(len(answer)))
+			conn.Write(append(length[:], answer...)) // nolint: errcheck
+		}()
+	}
+}
+
+func TestUpstreamResolver(t *testing.T) {
+	records := map[dnsmessage.Type]net.IP{
+		dnsmessage.TypeA:    net.IPv4(10, 0, 0, 1),
+		dnsmessage.TypeAAAA: net.ParseIP("2001:db8::1"),
+	}
+	// the udp answer is truncated, retried over tcp on the same port
+	tl, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer tl.Close()
+	go serveDNSStream(t, tl, records)
+	pc, err := net.ListenPacket("udp", tl.Addr().String())
+	require.NoError(t, err)
+	defer pc.Close()
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			pc.WriteTo(dnsAnswer(t, buf[:n], records, true), addr) // nolint: errcheck
+		}
+	}()
+
+	r := UpstreamResolver{Addr: tl.Addr().String(), Timeout: time.Second}
+	ips, ttl, err := r.LookupAddrs(context.Background(), "example.com")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []net.IP{net.IPv4(10, 0, 0, 1).To4(), records[dnsmessage.TypeAAAA]}, ips)
+	assert.Equal(t, time.Minute, ttl)
+
+	// DNS over TLS
+	ca := newTestCA(t)
+	ll, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{ca.issue(t, "dns", x509.ExtKeyUsageServerAuth)},
+	})
+	require.NoError(t, err)
+	defer ll.Close()
+	go serveDNSStream(t, ll, map[dnsmessage.Type]net.IP{dnsmessage.TypeA: net.IPv4(10, 0, 0, 2)})
+	r = UpstreamResolver{Addr: ll.Addr().String(), Network: "tls", TLSConfig: &tls.Config{RootCAs: ca.pool}}
+	_, ip, err := r.Resolve(context.Background(), "example.com")
+	require.NoError(t, err)
+	assert.Equal(t, net.IPv4(10, 0, 0, 2).To4(), ip)
+}
+
+func TestDoHResolver(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query, err := ioutil.ReadAll(r.Body)
+		require.NoError(t, err)
+		assert.Equal(t, "application/dns-message", r.Header.Get("Content-Type"))
+		assert.Equal(t, []byte{0, 0}, query[:2])
+		w.Header().Set("Content-Type", "application/dns-message")
+		w.Write(dnsAnswer(t, query, map[dnsmessage.Type]net.IP{ // nolint: errcheck
+			dnsmessage.TypeAAAA: net.ParseIP("2001:db8::2"),
+		}, false))
+	}))
+	defer srv.Close()
+
+	r := DoHResolver{URL: srv.URL}
+	_, ip, err := r.Resolve(context.Background(), "example.com")
+	require.NoError(t, err)
+	assert.Equal(t, net.ParseIP("2001:db8::2"), ip)
+}
+
+func TestParseDNSAnswer(t *testing.T) {
+	records := map[dnsmessage.Type]net.IP{dnsmessage.TypeA: net.IPv4(10, 0, 0, 1)}
+	query, err := newDNSQuery("example.com", dnsmessage.TypeA)
+	require.NoError(t, err)
+	ips, _, err := parseDNSAnswer("example.com", query, dnsAnswer(t, query, records, false))
+	require.NoError(t, err)
+	assert.Equal(t, []net.IP{net.IPv4(10, 0, 0, 1).To4()}, ips)
+
+	// the answer of the id 0 is not accepted over udp
+	answer := dnsAnswer(t, query, records, false)
+	answer[0], answer[1] = 0, 0
+	if query[0] != 0 || query[1] != 0 {
+		_, _, err = parseDNSAnswer("example.com", query, answer)
+		require.EqualError(t, err, "lookup example.com: dns answer id mismatch")
+	}
+
+	// the answer of another question
+	other, err := newDNSQuery("evil.example", dnsmessage.TypeA)
+	require.NoError(t, err)
+	answer = dnsAnswer(t, other, records, false)
+	copy(answer, query[:2])
+	_, _, err = parseDNSAnswer("example.com", query, answer)
+	require.EqualError(t, err, "lookup example.com: dns answer question mismatch")
+	query2, err := newDNSQuery("example.com", dnsmessage.TypeAAAA)
+	require.NoError(t, err)
+	answer = dnsAnswer(t, query2, records, false)
+	copy(answer, query[:2])
+	_, _, err = parseDNSAnswer("example.com", query, answer)
+	require.EqualError(t, err, "lookup example.com: dns answer question mismatch")
+}
@@ -0,0 +1,378 @@
+package socks5
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// defaultDNSTimeout the default timeout of a query to the upstream
+const defaultDNSTimeout = 5 * time.Second
+
+// maxDNSMessage the max size of the dns message
+const maxDNSMessage = 64 * 1024
+
+// errTruncated the answer over udp is truncated, retried over tcp
+var errTruncated = errors.New("dns answer truncated")
+
+// errDNSQuestion the question of the answer is not of the query
+var errDNSQuestion = errors.New("dns answer question mismatch")
+
+// UpstreamResolver queries the specific upstream name server, over the plain udp, tcp,
+// or DNS over TLS (RFC 7858), both the A and AAAA are queried with the TTL of the answers.
+type UpstreamResolver struct {
+	// Addr of the name server, host:port, the port defaults to 53, or 853 of the DNS over TLS
+	Addr string
+	// Network is "udp", "tcp" or "tls", defaults to "udp", the truncated answer over udp is
+	// retried over tcp.
+	Network string
+	// TLSConfig of the DNS over TLS, the ServerName defaults to the host of the Addr
+	TLSConfig *tls.Config
+	// Timeout of a query, defaults to 5s
+	Timeout time.Duration
+	// Dialer dials the name server, defaults to net.Dialer
+	Dialer Dialer
+}
+
+// Resolve implement interface NameResolver, IPv4 address preferred
+func (sf UpstreamResolver) Resolve(ctx context.Context, name string) (context.Context, net.IP, error) {
+	return resolveBy(ctx, sf, name, PreferIPv4)
+}
+
+// LookupAddrs implement interface AddrResolver
+func (sf UpstreamResolver) LookupAddrs(ctx context.Context, name string) ([]net.IP, time.Duration, error) {
+	return lookupBoth(ctx, name, sf.exchange)
+}
+
+// exchange sends the query and returns the answer
+func (sf UpstreamResolver) exchange(ctx context.Context, query []byte) ([]byte, error) {
+	network := sf.Network
+	if network == "" {
+		network = "udp"
+	}
+	answer, err := sf.exchangeOver(ctx, network, query)
+	if errors.Is(err, errTruncated) {
+		answer, err = sf.exchangeOver(ctx, "tcp", query)
+	}
+	return answer, err
+}
+
+func (sf UpstreamResolver) exchangeOver(ctx context.Context, network string, query []byte) ([]byte, error) {
+	timeout := sf.Timeout
+	if timeout <= 0 {
+		timeout = defaultDNSTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	port, dialNetwork := "53", network
+	if network == "tls" {
+		port, dialNetwork = "853", "tcp"
+	}
+	addr := sf.Addr
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, port)
+	}
+	var d Dialer = new(net.Dialer)
+	if sf.Dialer != nil {
+		d = sf.Dialer
+	}
+	conn, err := d.DialContext(ctx, dialNetwork, addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline) // nolint: errcheck
+	}
+	if network == "tls" {
+		cfg := &tls.Config{}
+		if sf.TLSConfig != nil {
+			cfg = sf.TLSConfig.Clone()
+		}
+		if cfg.ServerName == "" {
+			cfg.ServerName, _, _ = net.SplitHostPort(addr)
+		}
+		tconn := tls.Client(conn, cfg)
+		if err := tconn.Handshake(); err != nil {
+			return nil, err
+		}
+		conn = tconn
+	}
+
+	if network == "udp" {
+		if _, err := conn.Write(query); err != nil {
+			return nil, err
+		}
+		buf := make([]byte, maxDNSMessage)
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				return nil, err
+			}
+			// skip the answer of other queries
+			if n < 12 || !bytes.Equal(buf[:2], query[:2]) {
+				continue
+			}
+			// the TC bit of the header
+			if buf[2]&0x02 != 0 {
+				return nil, errTruncated
+			}
+			return buf[:n], nil
+		}
+	}
+	// the message over the stream is prefixed with the length
+	msg := make([]byte, 2+len(query))
+	binary.BigEndian.PutUint16(msg, uint16(len(query)))
+	copy(msg[2:], query)
+	if _, err := conn.Write(msg); err != nil {
+		return nil, err
+	}
+	var length [2]byte
+	if _, err := io.ReadFull(conn, length[:]); err != nil {
+		return nil, err
+	}
+	answer := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(conn, answer); err != nil {
+		return nil, err
+	}
+	return answer, nil
+}
+
+// DoHResolver queries the name server by the DNS over HTTPS (RFC 8484),
+// both the A and AAAA are queried with the TTL of the answers.
+type DoHResolver struct {
+	// URL of the DNS over HTTPS endpoint, such as https://cloudflare-dns.com/dns-query
+	URL string
+	// Client sends the queries, defaults to http.DefaultClient
+	Client *http.Client
+	// Timeout of a query, defaults to 5s
+	Timeout time.Duration
+}
+
+// Resolve implement interface NameResolver, IPv4 address preferred
+func (sf DoHResolver) Resolve(ctx context.Context, name string) (context.Context, net.IP, error) {
+	return resolveBy(ctx, sf, name, PreferIPv4)
+}
+
+// LookupAddrs implement interface AddrResolver
+func (sf DoHResolver) LookupAddrs(ctx context.Context, name string) ([]net.IP, time.Duration, error) {
+	return lookupBoth(ctx, name, sf.exchange)
+}
+
+// exchange posts the query and returns the answer
+func (sf DoHResolver) exchange(ctx context.Context, query []byte) ([]byte, error) {
+	timeout := sf.Timeout
+	if timeout <= 0 {
+		timeout = defaultDNSTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	// the id should be 0 for the http cache, restored in the answer of the id 0
+	id := query[:2]
+	query = append([]byte{0, 0}, query[2:]...)
+	req, err := http.NewRequest(http.MethodPost, sf.URL, bytes.NewReader(query))
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/dns-message")
+	req.Header.Set("Accept", "application/dns-message")
+	client := http.DefaultClient
+	if sf.Client != nil {
+		client = sf.Client
+	}
+	rsp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer rsp.Body.Close()
+	if rsp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("doh status %s", rsp.Status)
+	}
+	if ct := rsp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "application/dns-message") {
+		return nil, fmt.Errorf("doh content type %q", ct)
+	}
+	answer, err := ioutil.ReadAll(io.LimitReader(rsp.Body, maxDNSMessage))
+	if err != nil {
+		return nil, err
+	}
+	if len(answer) >= 2 && answer[0] == 0 && answer[1] == 0 {
+		copy(answer, id)
+	}
+	return answer, nil
+}
+
+// lookupBoth queries both the A and AAAA of the name concurrently, the TTL is the min of the answers
+func lookupBoth(ctx context.Context, name string,
+	exchange func(ctx context.Context, query []byte) ([]byte, error)) ([]net.IP, time.Duration, error) {
+	type result struct {
+		ips []net.IP
+		ttl time.Duration
+		err error
+	}
+	types := []dnsmessage.Type{dnsmessage.TypeA, dnsmessage.TypeAAAA}
+	results := make([]chan result, len(types))
+	for i, typ := range types {
+		results[i] = make(chan result, 1)
+		go func(ch chan result, typ dnsmessage.Type) {
+			ips, ttl, err := lookupType(ctx, name, typ, exchange)
+			ch <- result{ips, ttl, err}
+		}(results[i], typ)
+	}
+	var ips []net.IP
+	var ttl time.Duration
+	var firstErr error
+	for _, ch := range results {
+		r := <-ch
+		if r.err != nil {
+			if firstErr == nil {
+				firstErr = r.err
+			}
+			continue
+		}
+		if len(r.ips) > 0 && (len(ips) == 0 || r.ttl < ttl) {
+			ttl = r.ttl
+		}
+		ips = append(ips, r.ips...)
+	}
+	if len(ips) == 0 {
+		if firstErr != nil {
+			return nil, 0, firstErr
+		}
+		return nil, 0, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	}
+	return ips, ttl, nil
+}
+
+// lookupType queries the addresses of the type
+func lookupType(ctx context.Context, name string, typ dnsmessage.Type,
+	exchange func(ctx context.Context, query []byte) ([]byte, error)) ([]net.IP, time.Duration, error) {
+	query, err := newDNSQuery(name, typ)
+	if err != nil {
+		return nil, 0, err
+	}
+	answer, err := exchange(ctx, query)
+	if err != nil {
+		return nil, 0, &net.DNSError{Err: err.Error(), Name: name, IsTemporary: true}
+	}
+	return parseDNSAnswer(name, query, answer)
+}
+
+// newDNSQuery builds the query of the name with a random id
+func newDNSQuery(name string, typ dnsmessage.Type) ([]byte, error) {
+	if !strings.HasSuffix(name, ".") {
+		name += "."
+	}
+	n, err := dnsmessage.NewName(name)
+	if err != nil {
+		return nil, err
+	}
+	var id [2]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return nil, err
+	}
+	b := dnsmessage.NewBuilder(make([]byte, 0, 512), dnsmessage.Header{
+		ID:               binary.BigEndian.Uint16(id[:]),
+		RecursionDesired: true,
+	})
+	b.EnableCompression()
+	if err := b.StartQuestions(); err != nil {
+		return nil, err
+	}
+	if err := b.Question(dnsmessage.Question{Name: n, Type: typ, Class: dnsmessage.ClassINET}); err != nil {
+		return nil, err
+	}
+	return b.Finish()
+}
+
+// parseDNSAnswer parses the addresses of the answer, the TTL is the min of the records
+func parseDNSAnswer(name string, query, answer []byte) ([]net.IP, time.Duration, error) {
+	var p dnsmessage.Parser
+	h, err := p.Start(answer)
+	if err != nil {
+		return nil, 0, &net.DNSError{Err: err.Error(), Name: name}
+	}
+	if h.ID != binary.BigEndian.Uint16(query) {
+		return nil, 0, &net.DNSError{Err: "dns answer id mismatch", Name: name}
+	}
+	if h.Truncated {
+		return nil, 0, &net.DNSError{Err: errTruncated.Error(), Name: name}
+	}
+	switch h.RCode {
+	case dnsmessage.RCodeSuccess:
+	case dnsmessage.RCodeNameError:
+		return nil, 0, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	default:
+		return nil, 0, &net.DNSError{Err: "dns server failure, " + h.RCode.String(), Name: name, IsTemporary: true}
+	}
+	if err := matchDNSQuestion(&p, query); err != nil {
+		return nil, 0, &net.DNSError{Err: err.Error(), Name: name}
+	}
+	var ips []net.IP
+	var ttl uint32
+	for seen := false; ; seen = true {
+		rh, err := p.AnswerHeader()
+		if err == dnsmessage.ErrSectionDone {
+			break
+		}
+		if err != nil {
+			return nil, 0, &net.DNSError{Err: err.Error(), Name: name}
+		}
+		switch rh.Type {
+		case dnsmessage.TypeA:
+			r, err := p.AResource()
+			if err != nil {
+				return nil, 0, &net.DNSError{Err: err.Error(), Name: name}
+			}
+			ips = append(ips, net.IP(r.A[:]))
+		case dnsmessage.TypeAAAA:
+			r, err := p.AAAAResource()
+			if err != nil {
+				return nil, 0, &net.DNSError{Err: err.Error(), Name: name}
+			}
+			ips = append(ips, net.IP(r.AAAA[:]))
+		default:
+			// such as the CNAME, the TTL of the chain counts
+			if err := p.SkipAnswer(); err != nil {
+				return nil, 0, &net.DNSError{Err: err.Error(), Name: name}
+			}
+		}
+		if !seen || rh.TTL < ttl {
+			ttl = rh.TTL
+		}
+	}
+	return ips, time.Duration(ttl) * time.Second, nil
+}
+
+// matchDNSQuestion parses the question of the answer, which must be the question of the query
+func matchDNSQuestion(p *dnsmessage.Parser, query []byte) error {
+	var qp dnsmessage.Parser
+	if _, err := qp.Start(query); err != nil {
+		return err
+	}
+	want, err := qp.Question()
+	if err != nil {
+		return err
+	}
+	got, err := p.Question()
+	if err != nil {
+		return errDNSQuestion
+	}
+	if got.Type != want.Type || got.Class != want.Class || !strings.EqualFold(got.Name.String(), want.Name.String()) {
+		return errDNSQuestion
+	}
+	return p.SkipAllQuestions()
+}
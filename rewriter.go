@@ -0,0 +1,67 @@
+package socks5
+
+import (
+	"context"
+	"net"
+	"strconv"
+
+	"github.com/thinkgos/go-socks5/statute"
+)
+
+// RewriteRule rewrites the matched destination host and port
+type RewriteRule struct {
+	// Host to match, IP or FQDN, empty matches any host
+	Host string
+	// Port to match, 0 matches any port
+	Port int
+	// NewHost of the destination, empty keeps the host
+	NewHost string
+	// NewPort of the destination, 0 keeps the port
+	NewPort int
+}
+
+// RewriteRules is an implementation of the AddressRewriter which
+// rewrites the destination with the first matched rule
+type RewriteRules []RewriteRule
+
+// Rewrite implement interface AddressRewriter
+func (sf RewriteRules) Rewrite(ctx context.Context, request *Request) (context.Context, *statute.AddrSpec) {
+	dest := request.DestAddr
+	for _, r := range sf {
+		if !r.match(dest) {
+			continue
+		}
+		host, port := r.NewHost, r.NewPort
+		if host == "" {
+			if dest.FQDN != "" {
+				host = dest.FQDN
+			} else {
+				host = dest.IP.String()
+			}
+		}
+		if port == 0 {
+			port = dest.Port
+		}
+		addr, err := statute.ParseAddrSpec(net.JoinHostPort(host, strconv.Itoa(port)))
+		if err != nil {
+			return ctx, dest
+		}
+		if r.NewHost == "" && dest.FQDN != "" {
+			// keep the resolved ip
+			addr.IP = dest.IP
+		}
+		return ctx, &addr
+	}
+	return ctx, dest
+}
+
+func (r RewriteRule) match(dest *statute.AddrSpec) bool {
+	if r.Port != 0 && r.Port != dest.Port {
+		return false
+	}
+	if r.Host == "" || r.Host == dest.FQDN {
+		return true
+	}
+	ip := net.ParseIP(r.Host)
+	return ip != nil && ip.Equal(dest.IP)
+}
@@ -0,0 +1,59 @@
+package socks5
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/thinkgos/go-socks5/statute"
+)
+
+func TestRewriteRules(t *testing.T) {
+	rules := RewriteRules{
+		{Host: "10.0.0.1", Port: 80, NewPort: 8080},
+		{Host: "example.com", NewHost: "127.0.0.1"},
+	}
+	ctx := context.Background()
+
+	dest := &statute.AddrSpec{IP: net.ParseIP("10.0.0.1"), Port: 80, AddrType: statute.ATYPIPv4}
+	_, got := rules.Rewrite(ctx, &Request{DestAddr: dest})
+	require.Equal(t, "10.0.0.1:8080", got.String())
+	require.Equal(t, 80, dest.Port)
+
+	dest = &statute.AddrSpec{IP: net.ParseIP("10.0.0.1"), Port: 443, AddrType: statute.ATYPIPv4}
+	_, got = rules.Rewrite(ctx, &Request{DestAddr: dest})
+	require.Equal(t, dest, got)
+
+	dest = &statute.AddrSpec{
+		FQDN:     "example.com",
+		IP:       net.ParseIP("93.184.216.34"),
+		Port:     443,
+		AddrType: statute.ATYPDomain,
+	}
+	_, got = rules.Rewrite(ctx, &Request{DestAddr: dest})
+	require.Equal(t, "127.0.0.1:443", got.String())
+}
+
+func TestServer_OverrideResolved(t *testing.T) {
+	target := echoTarget(t)
+	// the rules allow the requested port only, the target overridden is not checked
+	rules := ruleFunc(func(ctx context.Context, req *Request) (context.Context, bool) {
+		return ctx, req.DestAddr.Port == 1
+	})
+	resolver := resolverFunc(func(ctx context.Context, name string) (context.Context, net.IP, error) {
+		require.Equal(t, "echo.tenant.internal", name)
+		return ctx, target.IP, nil
+	})
+	override := overrideFunc(func(ctx context.Context, request *Request) (context.Context, *statute.AddrSpec) {
+		require.Equal(t, 1, request.DestAddr.Port)
+		require.NotNil(t, request.AuthContext)
+		require.NotNil(t, request.RemoteAddr)
+		return ctx, &statute.AddrSpec{FQDN: "echo.tenant.internal", Port: target.Port, AddrType: statute.ATYPDomain}
+	})
+	proxy := serveSocks(t, WithRule(rules), WithResolver(resolver), WithOverride(override))
+
+	conn := relaySession(t, proxy, &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1})
+	conn.Close()
+}
@@ -0,0 +1,79 @@
+package socks5
+
+import (
+	"context"
+	"fmt"
+)
+
+// NamedRule is a named rule which allows or blocks the matched request
+type NamedRule struct {
+	// Name of the rule, recorded into the decision
+	Name string
+	// Allow the matched request, otherwise block it
+	Allow bool
+	// Match reports whether the rule applies to the request, nil matches any request
+	Match func(ctx context.Context, req *Request) bool
+}
+
+// NamedRules is an implementation of the RuleSet which decides by the first
+// matched rule, and records the decision into the request context.
+type NamedRules struct {
+	Rules []NamedRule
+	// DefaultAllow is the decision if no rule matched
+	DefaultAllow bool
+}
+
+// RuleDecision records which rule decided the request and why
+type RuleDecision struct {
+	// Rule name, empty if no rule matched
+	Rule string
+	// Allow is the decision
+	Allow bool
+	// Reason of the decision
+	Reason string
+}
+
+// String implement interface fmt.Stringer
+func (sf RuleDecision) String() string {
+	action := "blocked"
+	if sf.Allow {
+		action = "allowed"
+	}
+	return fmt.Sprintf("%s, %s", action, sf.Reason)
+}
+
+type ruleDecisionKey struct{}
+
+// WithRuleDecision returns a copy of the context carrying the rule decision,
+// custom RuleSet use it to record the decision.
+func WithRuleDecision(ctx context.Context, d RuleDecision) context.Context {
+	return context.WithValue(ctx, ruleDecisionKey{}, d)
+}
+
+// RuleDecisionFromContext returns the rule decision carried by the context
+func RuleDecisionFromContext(ctx context.Context) (RuleDecision, bool) {
+	d, ok := ctx.Value(ruleDecisionKey{}).(RuleDecision)
+	return d, ok
+}
+
+// Allow implement interface RuleSet
+func (sf *NamedRules) Allow(ctx context.Context, req *Request) (context.Context, bool) {
+	for i, r := range sf.Rules {
+		if r.Match != nil && !r.Match(ctx, req) {
+			continue
+		}
+		name := r.Name
+		if name == "" {
+			name = fmt.Sprintf("#%d", i)
+		}
+		return WithRuleDecision(ctx, RuleDecision{
+			Rule:   name,
+			Allow:  r.Allow,
+			Reason: fmt.Sprintf("matched rule %s", name),
+		}), r.Allow
+	}
+	return WithRuleDecision(ctx, RuleDecision{
+		Allow:  sf.DefaultAllow,
+		Reason: "no rule matched, default action",
+	}), sf.DefaultAllow
+}
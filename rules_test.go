@@ -0,0 +1,65 @@
+package socks5
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/thinkgos/go-socks5/statute"
+)
+
+func TestNamedRules(t *testing.T) {
+	rules := &NamedRules{
+		Rules: []NamedRule{
+			{
+				Name: "block-bind",
+				Match: func(_ context.Context, req *Request) bool {
+					return req.Command == statute.CommandBind
+				},
+			},
+			{
+				Name:  "allow-connect",
+				Allow: true,
+				Match: func(_ context.Context, req *Request) bool {
+					return req.Command == statute.CommandConnect
+				},
+			},
+		},
+	}
+
+	ctx, ok := rules.Allow(context.Background(), &Request{Request: statute.Request{Command: statute.CommandConnect}})
+	require.True(t, ok)
+	d, has := RuleDecisionFromContext(ctx)
+	require.True(t, has)
+	require.Equal(t, "allow-connect", d.Rule)
+	require.True(t, d.Allow)
+
+	ctx, ok = rules.Allow(context.Background(), &Request{Request: statute.Request{Command: statute.CommandBind}})
+	require.False(t, ok)
+	d, _ = RuleDecisionFromContext(ctx)
+	require.Equal(t, "block-bind", d.Rule)
+
+	ctx, ok = rules.Allow(context.Background(), &Request{Request: statute.Request{Command: statute.CommandAssociate}})
+	require.False(t, ok)
+	d, _ = RuleDecisionFromContext(ctx)
+	require.Equal(t, "", d.Rule)
+	require.Equal(t, "blocked, no rule matched, default action", d.String())
+}
+
+func TestNamedRules_Request(t *testing.T) {
+	s := NewServer(WithRule(&NamedRules{Rules: []NamedRule{{Name: "deny-all"}}}))
+
+	req, err := ParseRequest(bytes.NewBuffer([]byte{
+		statute.VersionSocks5, statute.CommandConnect, 0,
+		statute.ATYPIPv4, 127, 0, 0, 1, 0, 80,
+	}))
+	require.NoError(t, err)
+
+	err = s.handleRequest(context.Background(), new(MockConn), req)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "matched rule deny-all")
+	require.NotNil(t, req.Decision)
+	require.Equal(t, "deny-all", req.Decision.Rule)
+}
@@ -45,3 +45,9 @@ func TestPermitCommand(t *testing.T) {
 	_, ok = r.Allow(ctx, &Request{Request: statute.Request{Command: 0x00}})
 	require.False(t, ok)
 }
+
+type ruleFunc func(ctx context.Context, req *Request) (context.Context, bool)
+
+func (f ruleFunc) Allow(ctx context.Context, req *Request) (context.Context, bool) {
+	return f(ctx, req)
+}
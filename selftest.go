@@ -0,0 +1,288 @@
+package socks5
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/thinkgos/go-socks5/internal/echotarget"
+	"github.com/thinkgos/go-socks5/statute"
+)
+
+// defaultSelfTestTimeout limits the self test if the context has no deadline
+const defaultSelfTestTimeout = 5 * time.Second
+
+// selfTestPayload is echoed by the echo targets of the self test
+var selfTestPayload = []byte("socks5 self test")
+
+// SelfTestResult is the result of a command of the self test
+type SelfTestResult struct {
+	// Skipped is true if the command is disabled
+	Skipped bool
+	// Err of the round trip, nil if the payload echoed
+	Err error
+	// Latency from the dial to the payload echoed
+	Latency time.Duration
+}
+
+// SelfTestReport is the report of the self test
+type SelfTestReport struct {
+	// Addr of the listener tested
+	Addr      string
+	Connect   SelfTestResult
+	Associate SelfTestResult
+}
+
+// Healthy reports whether no round trip failed
+func (sf *SelfTestReport) Healthy() bool {
+	return sf.Connect.Err == nil && sf.Associate.Err == nil
+}
+
+// selfTest hosts the tcp and udp echo targets on loopback, started by the first self test
+type selfTest struct {
+	username string
+	password string
+	mu       sync.Mutex
+	closed   bool
+	tcp      net.Listener
+	udp      net.PacketConn
+}
+
+// targets starts the echo targets once, returns the tcp and the udp echo target
+func (sf *selfTest) targets() (tcp, udp net.Addr, err error) {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	if sf.closed {
+		return nil, nil, ErrServerClosed
+	}
+	if sf.tcp == nil {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			return nil, nil, fmt.Errorf("listen tcp echo target, %v", err)
+		}
+		pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			l.Close()
+			return nil, nil, fmt.Errorf("listen udp echo target, %v", err)
+		}
+		sf.tcp, sf.udp = l, pc
+		go echotarget.ServeTCP(l)
+		go echotarget.ServeUDP(pc)
+	}
+	return sf.tcp.Addr(), sf.udp.LocalAddr(), nil
+}
+
+// close the echo targets, nil has nothing to close
+func (sf *selfTest) close() {
+	if sf == nil {
+		return
+	}
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	sf.closed = true
+	if sf.tcp != nil {
+		sf.tcp.Close()
+		sf.udp.Close()
+	}
+}
+
+// SelfTest runs a CONNECT and an ASSOCIATE round trip through a listener of the server to the
+// echo targets it hosts on loopback, usable as a deep health check, see WithSelfTest.
+// The listeners serving TLS are not tested, the rules must allow the loopback echo targets.
+// The context limits the self test, defaults to 5 seconds if no deadline.
+func (sf *Server) SelfTest(ctx context.Context) (*SelfTestReport, error) {
+	if sf.selfTest == nil {
+		return nil, errors.New("socks5: self test not enabled by WithSelfTest")
+	}
+	if sf.shuttingDown() {
+		return nil, ErrServerClosed
+	}
+	addr := sf.selfTestAddr()
+	if addr == "" {
+		return nil, errors.New("socks5: no listener to self test")
+	}
+	tcpTarget, udpTarget, err := sf.selfTest.targets()
+	if err != nil {
+		return nil, err
+	}
+	if _, has := ctx.Deadline(); !has {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, defaultSelfTestTimeout)
+		defer cancel()
+	}
+
+	report := &SelfTestReport{Addr: addr}
+	if sf.commandDisabled(statute.CommandConnect) {
+		report.Connect.Skipped = true
+	} else {
+		start := time.Now()
+		report.Connect.Err = sf.selfTestConnect(ctx, addr, tcpTarget)
+		report.Connect.Latency = time.Since(start)
+	}
+	if sf.commandDisabled(statute.CommandAssociate) {
+		report.Associate.Skipped = true
+	} else {
+		start := time.Now()
+		report.Associate.Err = sf.selfTestAssociate(ctx, addr, udpTarget)
+		report.Associate.Latency = time.Since(start)
+	}
+	return report, nil
+}
+
+// selfTestAddr returns the address to dial of a listener not serving TLS, the unspecified ip
+// is replaced by the loopback, empty if none.
+func (sf *Server) selfTestAddr() string {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	for l, lc := range sf.listeners {
+		addr, ok := (*l).Addr().(*net.TCPAddr)
+		if !ok || sf.tlsConfigOf(lc) != nil {
+			continue
+		}
+		ip := addr.IP
+		if ip == nil || ip.IsUnspecified() {
+			if ip == nil || ip.To4() != nil {
+				ip = net.IPv4(127, 0, 0, 1)
+			} else {
+				ip = net.IPv6loopback
+			}
+		}
+		return net.JoinHostPort(ip.String(), strconv.Itoa(addr.Port))
+	}
+	return ""
+}
+
+// selfTestConnect echoes the payload by CONNECT to the tcp echo target
+func (sf *Server) selfTestConnect(ctx context.Context, addr string, target net.Addr) error {
+	conn, _, err := sf.selfTestRequest(ctx, addr, statute.CommandConnect, echotarget.AddrSpec(target))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if _, err := conn.Write(selfTestPayload); err != nil {
+		return fmt.Errorf("write payload, %v", err)
+	}
+	buf := make([]byte, len(selfTestPayload))
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		return fmt.Errorf("read payload, %v", err)
+	}
+	if !bytes.Equal(buf, selfTestPayload) {
+		return errors.New("payload corrupted")
+	}
+	return nil
+}
+
+// selfTestAssociate echoes the payload by ASSOCIATE to the udp echo target
+func (sf *Server) selfTestAssociate(ctx context.Context, addr string, target net.Addr) error {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		return fmt.Errorf("listen udp, %v", err)
+	}
+	defer pc.Close()
+	defer closeOnDone(ctx, pc)()
+	conn, bnd, err := sf.selfTestRequest(ctx, addr, statute.CommandAssociate,
+		statute.AddrSpec{IP: net.IPv4zero, AddrType: statute.ATYPIPv4})
+	if err != nil {
+		return err
+	}
+	// the association lives as long as the tcp connection
+	defer conn.Close()
+
+	relayHost := bnd.IP.String()
+	if bnd.IP == nil || bnd.IP.IsUnspecified() {
+		relayHost, _, _ = net.SplitHostPort(addr)
+	}
+	relay, err := net.ResolveUDPAddr("udp", net.JoinHostPort(relayHost, strconv.Itoa(bnd.Port)))
+	if err != nil {
+		return fmt.Errorf("relay address, %v", err)
+	}
+	pk, err := statute.NewDatagram(target.String(), selfTestPayload)
+	if err != nil {
+		return err
+	}
+	if _, err := pc.WriteTo(pk.Bytes(), relay); err != nil {
+		return fmt.Errorf("write datagram, %v", err)
+	}
+	deadline, _ := ctx.Deadline()
+	pc.SetReadDeadline(deadline) // nolint: errcheck
+	buf := make([]byte, 64*1024)
+	n, _, err := pc.ReadFrom(buf)
+	if err != nil {
+		return fmt.Errorf("read datagram, %v", err)
+	}
+	echo, err := statute.ParseDatagram(buf[:n])
+	if err != nil {
+		return fmt.Errorf("parse datagram, %v", err)
+	}
+	if !bytes.Equal(echo.Data, selfTestPayload) {
+		return errors.New("datagram corrupted")
+	}
+	return nil
+}
+
+// selfTestRequest dials the listener, negotiates and sends the request, the connection is
+// returned after the success reply with the bind address of the reply.
+func (sf *Server) selfTestRequest(ctx context.Context, addr string, cmd byte,
+	dst statute.AddrSpec) (net.Conn, statute.AddrSpec, error) {
+	conn, err := new(net.Dialer).DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, statute.AddrSpec{}, fmt.Errorf("dial listener, %v", err)
+	}
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline) // nolint: errcheck
+	rep, err := sf.selfTest.handshake(conn, cmd, dst)
+	if err != nil {
+		conn.Close()
+		return nil, statute.AddrSpec{}, err
+	}
+	return conn, rep.BndAddr, nil
+}
+
+// handshake negotiates the method, authenticates and sends the request
+func (sf *selfTest) handshake(conn net.Conn, cmd byte, dst statute.AddrSpec) (statute.Reply, error) {
+	method := statute.MethodNoAuth
+	if sf.username != "" {
+		method = statute.MethodUserPassAuth
+	}
+	if _, err := conn.Write(statute.NewMethodRequest(statute.VersionSocks5, []byte{method}).Bytes()); err != nil {
+		return statute.Reply{}, err
+	}
+	mr, err := statute.ParseMethodReply(conn)
+	if err != nil {
+		return statute.Reply{}, fmt.Errorf("read method reply, %v", err)
+	}
+	if mr.Method != method {
+		return statute.Reply{}, fmt.Errorf("method %#x selected, want %#x", mr.Method, method)
+	}
+	if method == statute.MethodUserPassAuth {
+		req := statute.NewUserPassRequest(statute.UserPassAuthVersion, []byte(sf.username), []byte(sf.password))
+		if _, err := conn.Write(req.Bytes()); err != nil {
+			return statute.Reply{}, err
+		}
+		ar, err := statute.ParseUserPassReply(conn)
+		if err != nil {
+			return statute.Reply{}, fmt.Errorf("read auth reply, %v", err)
+		}
+		if ar.Status != statute.AuthSuccess {
+			return statute.Reply{}, fmt.Errorf("authentication failed, status %#x", ar.Status)
+		}
+	}
+	req := statute.Request{Version: statute.VersionSocks5, Command: cmd, DstAddr: dst}
+	if _, err := conn.Write(req.Bytes()); err != nil {
+		return statute.Reply{}, err
+	}
+	rep, err := statute.ParseReply(conn)
+	if err != nil {
+		return rep, fmt.Errorf("read reply, %v", err)
+	}
+	if rep.Response != statute.RepSuccess {
+		return rep, fmt.Errorf("reply %d, want %d", rep.Response, statute.RepSuccess)
+	}
+	return rep, nil
+}
@@ -0,0 +1,52 @@
+package socks5
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer_SelfTest(t *testing.T) {
+	srv := NewServer(WithCredential(StaticCredentials{"foo": "bar"}), WithSelfTest("foo", "bar"))
+	_, err := srv.SelfTest(context.Background())
+	require.EqualError(t, err, "socks5: no listener to self test")
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go srv.Serve(l) // nolint: errcheck
+	require.Eventually(t, func() bool { return srv.selfTestAddr() != "" }, time.Second, time.Millisecond)
+
+	report, err := srv.SelfTest(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, l.Addr().String(), report.Addr)
+	require.NoError(t, report.Connect.Err)
+	require.NoError(t, report.Associate.Err)
+	require.True(t, report.Healthy())
+	require.Greater(t, int64(report.Connect.Latency), int64(0))
+
+	require.NoError(t, srv.Close())
+	_, err = srv.SelfTest(context.Background())
+	require.Equal(t, ErrServerClosed, err)
+}
+
+func TestServer_SelfTestFailure(t *testing.T) {
+	srv := NewServer(WithCredential(StaticCredentials{"foo": "bar"}), WithSelfTest("foo", "baz"),
+		WithDisableAssociate())
+	defer srv.Close()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go srv.Serve(l) // nolint: errcheck
+	require.Eventually(t, func() bool { return srv.selfTestAddr() != "" }, time.Second, time.Millisecond)
+
+	report, err := srv.SelfTest(context.Background())
+	require.NoError(t, err)
+	require.EqualError(t, report.Connect.Err, "authentication failed, status 0x1")
+	require.True(t, report.Associate.Skipped)
+	require.False(t, report.Healthy())
+
+	_, err = NewServer().SelfTest(context.Background())
+	require.EqualError(t, err, "socks5: self test not enabled by WithSelfTest")
+}
@@ -3,12 +3,16 @@ package socks5
 import (
 	"bufio"
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
 	"net"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/thinkgos/go-socks5/bufferpool"
 	"github.com/thinkgos/go-socks5/statute"
@@ -31,6 +35,8 @@ type Server struct {
 	// by appending a UserPassAuthenticator to AuthMethods. If not provided,
 	// and authCustomMethods is nil, then "no-auth" mode is enabled.
 	credentials CredentialStore
+	// policyMu guards the resolver and the rules replaced at runtime
+	policyMu sync.RWMutex
 	// resolver can be provided to do custom name resolution.
 	// Defaults to DNSResolver if not provided.
 	resolver NameResolver
@@ -41,17 +47,208 @@ type Server struct {
 	// This is invoked before the RuleSet is invoked.
 	// Defaults to NoRewrite.
 	rewriter AddressRewriter
+	// override can be used to replace the destination entirely.
+	// This is invoked after the RuleSet is invoked.
+	override DestinationOverride
+	// forwardTarget and forwardUserTargets enable the static forwarding mode,
+	// CONNECT always connects to the target regardless of the requested destination.
+	forwardTarget      *forwardTarget
+	forwardUserTargets map[string]*forwardTarget
 	// bindIP is used for bind or udp associate
 	bindIP net.IP
+	// udpPacketRate and udpByteRate limit the datagrams and the bytes per second
+	// sent by the client of each association, 0 means no limit.
+	udpPacketRate int
+	udpByteRate   int
+	// udpMaxDatagram limits the size of the datagram relayed to the client,
+	// the oversized datagram is handled with the udpOversizePolicy. 0 means no limit.
+	udpMaxDatagram    int
+	udpOversizePolicy UDPOversizePolicy
+	// udpFragmentPolicy handles the fragmented datagrams from the client,
+	// reassembled within udpReassemblyTimeout, 0 means 5 seconds.
+	udpFragmentPolicy    UDPFragmentPolicy
+	udpReassemblyTimeout time.Duration
+	// udpMaxFlows limits the udp flows of an association, 0 means no limit.
+	udpMaxFlows int
+	// udpMaxGlobalFlows limits the udp flows of all associations, 0 means no limit.
+	udpMaxGlobalFlows int
+	// udpFlowIdleTimeout removes the udp flows idle for the duration, 0 means never.
+	udpFlowIdleTimeout time.Duration
+	// udpFlowEvictHandle optional called when an udp flow is removed from the NAT table
+	udpFlowEvictHandle func(flow UDPFlow, reason UDPFlowEvictReason)
+	// udpGlobalFlows is the current udp flows of all associations
+	udpGlobalFlows int64
+	// udpTables is the NAT tables of all associations
+	udpTables sync.Map
+	// sessions is the active tcp sessions
+	sessions sync.Map
+	// activeConns is the connections being served, updated atomically
+	activeConns int64
+	// refusing is the connections being refused over the limit, updated atomically
+	refusing int64
+	// inShutdown is set by Shutdown or Close, updated atomically
+	inShutdown int32
+	mu         sync.Mutex
+	listeners  map[*net.Listener]*listenerConfig
 	// logger can be used to provide a custom log target.
 	// Defaults to ioutil.Discard.
 	logger Logger
-	// Optional function for dialing out
-	dial func(ctx context.Context, network, addr string) (net.Conn, error)
-	// buffer pool
+	// structured writes the leveled and structured logs, nil if not configured
+	structured StructuredLogger
+	// dialer dials out the targets
+	dialer Dialer
+	// answerFilters filter the addresses resolved from the FQDN destinations in order
+	answerFilters []AnswerFilter
+	// happyEyeballs is the fallback delay of the Happy Eyeballs dialing, 0 means disabled
+	happyEyeballs time.Duration
+	// buffer pool of the tcp relay
 	bufferPool bufferpool.BufPool
+	// datagramPool is the buffer pool of the udp datagrams
+	datagramPool bufferpool.BufPool
+	// relayBufferSize and datagramBufferSize set by WithBufferSize, checked by Validate only
+	relayBufferSize    int
+	datagramBufferSize int
+	// zeroCopy splices the relay of the plain tcp connections on linux
+	zeroCopy bool
 	// goroutine pool
 	gPool GPool
+	// metrics can be used to export server metrics.
+	// Defaults to NoopMetrics.
+	metrics Metrics
+	// replyWriter creates the writer of the replies of a connection, nil writes to the connection
+	replyWriter func(w io.Writer) ReplyWriter
+	// replyDetailNets is the trusted networks which the reply detail
+	// extension is sent to after a failure reply.
+	replyDetailNets []*net.IPNet
+	// acceptBackoffMin and acceptBackoffMax is the delay range to back off
+	// on temporary accept errors, such as EMFILE and ENFILE.
+	acceptBackoffMin time.Duration
+	acceptBackoffMax time.Duration
+	// acceptErrorHandle is notified of the temporary accept errors.
+	acceptErrorHandle func(err error, delay time.Duration)
+	// associatePreferIPv6 binds the udp relay on the address family of the client connection,
+	// so the client connected over IPv6 gets an IPv6 relay address.
+	associatePreferIPv6 bool
+	// pipelinedDeny rejects the CONNECT request followed by the data sent before the reply,
+	// pipelinedMax limits the bytes buffered before the reply, 0 means no limit.
+	pipelinedDeny bool
+	pipelinedMax  int
+	// earlyData flushes the pipelined data to the target as soon as connected, before the reply
+	earlyData bool
+	// handshakeTimeout limits the method negotiation, the auth and the request parsing, 0 means no limit
+	handshakeTimeout time.Duration
+	// dialTimeout limits the dial of the targets, 0 means no limit
+	dialTimeout time.Duration
+	// idleTimeout closes the sessions relaying no byte in either direction for the duration, 0 means never
+	idleTimeout time.Duration
+	// requestHeaderTimeout limits the time the client takes to deliver the request header,
+	// requestHeaderMaxReads limits the reads it takes, 0 means no limit.
+	requestHeaderTimeout  time.Duration
+	requestHeaderMaxReads int
+	// associateStrict only relays the datagrams to the ASSOCIATE request destination
+	associateStrict bool
+	// associatePeerOnly restricts learning the client udp endpoint to the tcp peer's ip
+	associatePeerOnly bool
+	// clock is the source of time of the timeouts, rate limits, quotas and idle tracking
+	clock Clock
+	// associateSourceCheck only relays the datagrams from the client address declared in the ASSOCIATE request
+	associateSourceCheck bool
+	// associateSourceNAT relaxes the source check for the clients behind NAT
+	associateSourceNAT bool
+	// udpSocketReuse shares one udp relay socket among the associations from the same client ip
+	udpSocketReuse bool
+	udpRelays      udpRelayPool
+	// connSlots is the semaphore of the connections served, nil means no limit
+	connSlots chan struct{}
+	// overloadPolicy is what to do with the connections over the limit
+	overloadPolicy OverloadPolicy
+	// perIP caps the concurrent handshakes and active sessions per source ip
+	perIP *perIPLimiter
+	// sessionMemory limits the buffered bytes of a session, 0 means no limit
+	sessionMemory int64
+	// memory limits the buffered bytes of all the sessions, nil means no limit
+	memory *memoryBudget
+	// protocols served on the listeners, only SOCKS5 if zero
+	protocols Protocol
+	// geoIP looks up the country of the clients, nil means disabled
+	geoIP GeoIP
+	// countryPolicies is the policy of the clients per country, keyed by the country code or CountryAny
+	countryPolicies map[string]*CountryPolicy
+	// connByteRate limits the bytes per second of a session, both directions, 0 means no limit
+	connByteRate int
+	// rateLimiter limits the bandwidth per username or client ip
+	rateLimiter RateLimiter
+	// acceptFilter filters the accepted connections before served
+	acceptFilter AcceptFilter
+	// dialHandle is notified of the dial info on the connect path
+	dialHandle func(ctx context.Context, request *Request, info DialInfo)
+	// trafficMeter is notified of the start and the end of the sessions
+	trafficMeter TrafficMeter
+	// accessLogger writes the access log line of every session end
+	accessLogger AccessLogger
+	// accessLogEncoder encodes the access log line, nil writes the key=value line
+	accessLogEncoder RecordEncoder
+	// milestones fires the cumulative transfer milestones per user
+	milestones *milestoneTracker
+	// sessionCloseHandle is notified of the session end with the close reason
+	sessionCloseHandle func(s Session, err error)
+	// clientNoiseHandle is notified of the client noise, such as ECONNABORTED and WSAECONNRESET
+	clientNoiseHandle func(phase Phase, err error)
+	// bind is the default config of the BIND command
+	bind BindConfig
+	// bindAddrPool is the addresses the BIND listens on in turn
+	bindAddrPool []string
+	bindAddrNext uint32
+	// bindFilter restricts which clients may use BIND
+	bindFilter func(ctx context.Context, request *Request) bool
+	// authEventHandle is notified of every authentication attempt
+	authEventHandle func(AuthEvent)
+	// authFailureDelay and authFailureJitter delay the auth failure replies, 0 means no delay
+	authFailureDelay  time.Duration
+	authFailureJitter time.Duration
+	// virtualServers is the config profiles selected by the local address
+	virtualServers map[string]*listenerConfig
+	// symmetricTimeout mirrors the deadline to both relay legs, extended on every read
+	symmetricTimeout time.Duration
+	// read/write timeouts of the client and target leg of the relay, 0 means no timeout.
+	clientReadTimeout  time.Duration
+	clientWriteTimeout time.Duration
+	targetReadTimeout  time.Duration
+	targetWriteTimeout time.Duration
+	// stallThreshold is the duration a relay direction blocked on write is considered stalled
+	stallThreshold time.Duration
+	// stallHandle is notified of the stalled relay, returns whether to close the session
+	stallHandle func(s Session, up bool) bool
+	// diagnostic answers the diagnostic command with the version
+	diagnostic        bool
+	diagnosticVersion string
+	// disabledCommands is the bitmask of the commands rejected as not supported, 1<<command
+	disabledCommands uint8
+	// tracer starts the spans of the request phases, nil if not enabled
+	tracer Tracer
+	// compression compresses the CONNECT payload requested by the client at the level
+	compression      bool
+	compressionLevel int
+	// proxyProtocol parses the PROXY protocol header of the inbound connections
+	proxyProtocol *proxyProtocol
+	// tlsConfig serves TLS on the listeners, the listener's WithListenerTLS takes precedence
+	tlsConfig *tls.Config
+	// socketPriorityFunc returns the SO_PRIORITY of the outbound socket of the request
+	socketPriorityFunc func(ctx context.Context, request *Request) (int, bool)
+	// shadow mirrors the selected CONNECT traffic to a shadow backend
+	shadow *ShadowConfig
+	// traceLimit enables the wire-level debug tracing, dumps the negotiation
+	// messages and the first traceLimit bytes of each relay direction.
+	traceLimit int
+	// usage aggregates the usage and delivers the report periodically
+	usage *usageCollector
+	// budget limits the new sessions of the users per time window, nil means no limit
+	budget *ConnectionBudget
+	// selfTest hosts the echo targets of SelfTest, nil if not enabled
+	selfTest *selfTest
+	// middlewares wrap the handling of the request, handler is the chain built
+	middlewares []Middleware
+	handler     Handler
 	// user's handle
 	userConnectHandle   func(ctx context.Context, writer io.Writer, request *Request) error
 	userBindHandle      func(ctx context.Context, writer io.Writer, request *Request) error
@@ -64,21 +261,35 @@ func NewServer(opts ...Option) *Server {
 		authMethods:       make(map[uint8]Authenticator),
 		authCustomMethods: []Authenticator{},
 		bufferPool:        bufferpool.NewPool(32 * 1024),
+		datagramPool:      bufferpool.NewPool(32 * 1024),
 		resolver:          DNSResolver{},
 		rules:             NewPermitAll(),
 		logger:            NewLogger(log.New(ioutil.Discard, "socks5: ", log.LstdFlags)),
-		dial: func(ctx context.Context, net_, addr string) (net.Conn, error) {
-			return net.Dial(net_, addr)
-		},
+		metrics:           NoopMetrics{},
+		acceptBackoffMin:  5 * time.Millisecond,
+		acceptBackoffMax:  time.Second,
+		clock:             SystemClock{},
+		dialer:            new(net.Dialer),
 	}
 
 	for _, opt := range opts {
 		opt(srv)
 	}
+	if srv.milestones != nil {
+		srv.milestones.clock = srv.clock
+	}
+	if srv.usage != nil {
+		srv.usage.clock, srv.usage.start = srv.clock, srv.clock.Now()
+	}
+	if srv.budget != nil && srv.budget.Store == nil {
+		store := NewMemoryStore()
+		store.Clock = srv.clock
+		srv.budget.Store = store
+	}
 
 	// Ensure we have at least one authentication method enabled
 	if (len(srv.authCustomMethods) == 0) && srv.credentials != nil {
-		srv.authCustomMethods = []Authenticator{&UserPassAuthenticator{srv.credentials}}
+		srv.authCustomMethods = []Authenticator{&UserPassAuthenticator{Credentials: srv.credentials}}
 	}
 
 	if len(srv.authCustomMethods) == 0 {
@@ -88,6 +299,12 @@ func NewServer(opts ...Option) *Server {
 	for _, v := range srv.authCustomMethods {
 		srv.authMethods[v.GetCode()] = v
 	}
+	srv.handler = chainHandler(srv.handleRequest, srv.middlewares)
+	for _, c := range srv.virtualServers {
+		srv.buildHandler(c)
+		// the virtual server with the client auth but without TLS is reported by Validate
+		srv.buildTLS(c) // nolint: errcheck
+	}
 
 	return srv
 }
@@ -101,17 +318,115 @@ func (sf *Server) ListenAndServe(network, addr string) error {
 	return sf.Serve(l)
 }
 
-// Serve is used to serve connections from a listener
-func (sf *Server) Serve(l net.Listener) error {
+// Serve is used to serve connections from a listener,
+// opts optional overrides the server's option for the listener.
+func (sf *Server) Serve(l net.Listener, opts ...ListenerOption) error {
+	return sf.ServeContext(context.Background(), l, opts...)
+}
+
+// ServeListeners serves the listeners concurrently, such as IPv4, IPv6 and a unix socket, or the
+// listeners of ActivationListeners, under the shared Shutdown and Close, see ServeContext.
+// It returns once all the listeners stop, with the error of the first stopped, which closes
+// the other listeners, the sessions served keep on.
+func (sf *Server) ServeListeners(ctx context.Context, ls ...net.Listener) error {
+	errs := make(chan error, len(ls))
+	for _, l := range ls {
+		go func(l net.Listener) { errs <- sf.ServeContext(ctx, l) }(l)
+	}
+	var err error
+	for range ls {
+		if e := <-errs; err == nil {
+			err = e
+			for _, l := range ls {
+				l.Close()
+			}
+		}
+	}
+	return err
+}
+
+// ServeContext is the same as Serve, the context is threaded into the resolver,
+// rule set, dialer and relay of the requests, cancelling the context closes
+// the listener and tears down all the connections served from it,
+// then ServeContext returns the context's error.
+func (sf *Server) ServeContext(ctx context.Context, l net.Listener, opts ...ListenerOption) error {
+	lc := newListenerConfig(opts...)
+	if lc != nil {
+		lc.addr = l.Addr()
+		sf.buildHandler(lc)
+		if err := sf.buildTLS(lc); err != nil {
+			l.Close()
+			return err
+		}
+	}
 	defer l.Close()
+	defer closeOnDone(ctx, l)()
+	if !sf.trackListener(&l, lc, true) {
+		return ErrServerClosed
+	}
+	defer sf.trackListener(&l, lc, false)
+	var delay time.Duration // how long to sleep on accept failure
 	for {
+		release, acquired, err := sf.acquireConn(ctx)
+		if err != nil {
+			return err
+		}
 		conn, err := l.Accept()
 		if err != nil {
+			if acquired {
+				release()
+			}
+			if sf.shuttingDown() {
+				return ErrServerClosed
+			}
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if isClientNoise(err) {
+				sf.clientNoise(PhaseNegotiation, err) // nolint: errcheck
+				continue
+			}
+			if ne, ok := err.(net.Error); ok && ne.Temporary() && sf.acceptBackoffMax > 0 { // nolint: staticcheck
+				if delay == 0 {
+					delay = sf.acceptBackoffMin
+				} else {
+					delay *= 2
+				}
+				if delay > sf.acceptBackoffMax {
+					delay = sf.acceptBackoffMax
+				}
+				sf.logger.Errorf("accept error: %v; retrying in %v", err, delay)
+				if sf.acceptErrorHandle != nil {
+					sf.acceptErrorHandle(err, delay)
+				}
+				if err := sleepContext(ctx, sf.clock, delay); err != nil {
+					return err
+				}
+				continue
+			}
 			return err
 		}
+		delay = 0
+		tag, ok := sf.acceptConn(conn)
+		if !ok {
+			if acquired {
+				release()
+			}
+			continue
+		}
+		if !acquired {
+			sf.refuse(conn)
+			continue
+		}
+		sf.addActiveConns(1)
 		sf.goFunc(func() {
-			if err := sf.ServeConn(conn); err != nil {
-				sf.logger.Errorf("server: %v", err)
+			defer release()
+			defer sf.addActiveConns(-1)
+			if err := sf.serveConn(ctx, conn, lc, tag); err != nil {
+				var noise *clientNoiseError
+				if !errors.As(err, &noise) && !errors.Is(err, ErrServerClosed) {
+					sf.logger.Errorf("server: %v", err)
+				}
 			}
 		})
 	}
@@ -119,60 +434,390 @@ func (sf *Server) Serve(l net.Listener) error {
 
 // ServeConn is used to serve a single connection.
 func (sf *Server) ServeConn(conn net.Conn) error {
+	tag, ok := sf.acceptConn(conn)
+	if !ok {
+		return nil
+	}
+	release, acquired, err := sf.acquireConn(context.Background())
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	if !acquired {
+		sf.refuseConn(conn)
+		return nil
+	}
+	defer release()
+	sf.addActiveConns(1)
+	defer sf.addActiveConns(-1)
+	return sf.serveConn(context.Background(), conn, nil, tag)
+}
+
+func (sf *Server) serveConn(ctx context.Context, conn net.Conn, lc *listenerConfig, tag string) (err error) {
+	handshakeDeadline := sf.beginHandshake(conn, lc)
+	transparent := lc.transparentMode()
+	if sf.proxyProtocol != nil && transparent == TransparentNone {
+		pconn, err := sf.proxyProtocol.accept(conn, handshakeDeadline)
+		if err != nil {
+			conn.Close()
+			if isClientNoise(err) {
+				return sf.clientNoise(PhaseNegotiation, err)
+			}
+			sf.incError(PhaseNegotiation, NoReply)
+			return err
+		}
+		conn = pconn
+	}
+	if vc := sf.virtualServer(conn.LocalAddr()); vc != nil && transparent == TransparentNone {
+		lc = vc
+	}
+	country, countryPolicy, hasCountryPolicy := sf.clientCountry(conn.RemoteAddr())
+	if hasCountryPolicy && countryPolicy.Deny {
+		conn.Close()
+		sf.incError(PhaseNegotiation, NoReply)
+		return fmt.Errorf("client %s from country %q denied", conn.RemoteAddr(), country)
+	}
 	var authContext *AuthContext
 
+	var tlsState *tls.ConnectionState
+	if cfg := sf.tlsConfigOf(lc); cfg != nil && transparent == TransparentNone {
+		tconn := tls.Server(conn, cfg)
+		if err := tconn.Handshake(); err != nil {
+			conn.Close()
+			if isClientNoise(err) {
+				return sf.clientNoise(PhaseNegotiation, err)
+			}
+			sf.incError(PhaseNegotiation, NoReply)
+			return fmt.Errorf("tls handshake failed, %v", err)
+		}
+		state := tconn.ConnectionState()
+		tlsState, conn = &state, tconn
+	}
 	defer conn.Close()
+	// the context of the connection is canceled once the connection ends,
+	// so the work the hooks started for it, such as a slow lookup, is abandoned.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
-	bufConn := bufio.NewReader(conn)
+	sess := newSession(conn, sf.clock.Now())
+	sess.tag = tag
+	sf.sessions.Store(sess.id, sess)
+	defer sf.sessions.Delete(sess.id)
+	defer func() { err = sf.endSession(sess, err) }()
+	sf.logSessionStart(sess)
+	ctx, span := sf.startSpan(ctx, SpanSession, Attribute{AttrClient, sess.clientAddr.String()})
+	defer func() { endSpan(span, err) }()
+	defer closeOnDone(ctx, closerFunc(func() error {
+		sess.setCloseReason(CloseReasonAdminKill)
+		return conn.Close()
+	}))()
+	// the session stored after Shutdown or Close closed the sessions
+	if sf.shuttingDown() {
+		return ErrServerClosed
+	}
 
-	mr, err := statute.ParseMethodRequest(bufConn)
-	if err != nil {
-		return err
+	sourceIP := unmapIP(addrIP(conn.RemoteAddr())).String()
+	releaseHandshake, ok := sf.perIP.acquireHandshake(sourceIP)
+	if !ok {
+		sess.setCloseReason(CloseReasonQuotaExceeded)
+		sf.incError(PhaseNegotiation, NoReply)
+		return fmt.Errorf("too many handshakes from %s", sourceIP)
+	}
+	defer releaseHandshake()
+
+	counter := &readCounter{Conn: conn}
+	var writer io.Writer = conn
+	var tw *traceWriter
+	if sf.traceLimit > 0 {
+		tw = newTraceWriter(conn, sf.logger, sess.id)
+		writer = tw
 	}
-	if mr.Ver != statute.VersionSocks5 {
-		return statute.ErrNotSupportVersion
+	var src io.Reader = counter
+	var replies *replyBuffer
+	if transparent != TransparentNone {
+		replies = &replyBuffer{ReplyWriter: discardReplies{writer}, discard: true}
+		writer = replies
+	} else if sf.replyWriter != nil {
+		replies = &replyBuffer{ReplyWriter: sf.replyWriter(writer)}
+		defer replies.flush() // nolint: errcheck
+		src, writer = &flushReader{counter, replies}, replies
+	}
+	bufConn := bufio.NewReader(src)
+	var reader io.Reader = bufConn
+	var tr *traceReader
+	if sf.traceLimit > 0 {
+		tr = newTraceReader(bufConn, sf.logger, sess.id)
+		reader = tr
 	}
 
-	// Authenticate the connection
-	authContext, err = sf.authenticate(conn, bufConn, conn.RemoteAddr().String(), mr.Methods)
-	if err != nil {
-		return fmt.Errorf("failed to authenticate: %w", err)
+	authMethods := sf.authMethods
+	if lc != nil && lc.authMethods != nil {
+		authMethods = lc.authMethods
+	}
+	if hasCountryPolicy && countryPolicy.AuthMethods != nil {
+		authMethods = make(map[uint8]Authenticator, len(countryPolicy.AuthMethods))
+		for _, v := range countryPolicy.AuthMethods {
+			authMethods[v.GetCode()] = v
+		}
+	}
+	start := sf.clock.Now()
+	var version byte
+	// the transparent connections have no handshake, the server may speak first
+	if transparent == TransparentNone {
+		if version, err = sniffVersion(bufConn); err != nil {
+			if isClientNoise(err) {
+				return sf.clientNoise(PhaseNegotiation, err)
+			}
+			sf.incError(PhaseNegotiation, NoReply)
+			return err
+		}
+		if (version == statute.VersionSocks4 && !sf.serves(Socks4|Socks4a)) ||
+			(version == statute.VersionSocks5 && !sf.serves(Socks5)) {
+			sf.incError(PhaseNegotiation, NoReply)
+			return statute.ErrNotSupportVersion
+		}
 	}
 
-	// The client request detail
-	request, err := ParseRequest(bufConn)
-	if err != nil {
-		if errors.Is(err, statute.ErrUnrecognizedAddrType) {
-			if err := SendReply(conn, statute.RepAddrTypeNotSupported, nil); err != nil {
-				return fmt.Errorf("failed to send reply %w", err)
+	// the negotiation span ends with the error if the session ends before the request
+	_, negotiationSpan := sf.startSpan(ctx, SpanNegotiation)
+	defer func() {
+		if negotiationSpan != nil {
+			endSpan(negotiationSpan, err)
+		}
+	}()
+	var request *Request
+	var negotiationDuration, authDuration time.Duration
+	if transparent != TransparentNone {
+		sess.setState(SessionRequesting)
+		if request, err = transparentRequest(conn, lc.addr, transparent, reader); err != nil {
+			sf.incError(PhaseRequest, NoReply)
+			return err
+		}
+		authContext = &AuthContext{Method: statute.MethodNoAuth, Payload: make(map[string]string)}
+	} else if version == statute.VersionSocks4 {
+		sess.setState(SessionRequesting)
+		reads := counter.count()
+		sf.beginRequestHeader(conn, handshakeDeadline)
+		request, authContext, err = sf.readSocks4Request(writer, reader, authMethods)
+		tr.flush("request")
+		if err != nil {
+			if isClientNoise(err) {
+				return sf.clientNoise(PhaseRequest, err)
 			}
+			sf.incError(PhaseNegotiation, NoReply)
+			return fmt.Errorf("failed to read socks4 request, %w", err)
+		}
+		if err := sf.endRequestHeader(conn, handshakeDeadline, request, counter.count()-reads, start); err != nil {
+			return err
+		}
+		negotiationDuration = sf.since(start)
+		writer = newSocks4Writer(writer, request.Command)
+	} else {
+		mr, err := statute.ParseMethodRequest(reader)
+		tr.flush("method request")
+		if err != nil {
+			if isClientNoise(err) {
+				return sf.clientNoise(PhaseNegotiation, err)
+			}
+			sf.incError(PhaseNegotiation, NoReply)
+			return err
+		}
+		if mr.Ver != statute.VersionSocks5 {
+			sf.incError(PhaseNegotiation, NoReply)
+			return statute.ErrNotSupportVersion
+		}
+
+		negotiationDuration = sf.since(start)
+
+		// Authenticate the connection
+		sess.setState(SessionAuthenticating)
+		start = sf.clock.Now()
+		userAddr := unmapAddr(conn.RemoteAddr()).String()
+		tr.setRedact()
+		authContext, err = sf.authenticateWith(ctx, authMethods, writer, reader, userAddr, mr.Methods, tlsState)
+		tr.flush("auth")
+		if err != nil {
+			if isClientNoise(err) {
+				return sf.clientNoise(PhaseAuth, err)
+			}
+			if errors.Is(err, statute.ErrNoSupportedAuth) {
+				sf.incError(PhaseNegotiation, NoReply)
+			} else {
+				sf.incError(PhaseAuth, NoReply)
+			}
+			return fmt.Errorf("failed to authenticate: %w", err)
+		}
+		authDuration = sf.since(start)
+		if authContext != nil && authContext.encapsulate != nil {
+			reader, writer = authContext.encapsulate(reader, writer)
+		}
+		sess.setState(SessionRequesting)
+
+		// The client request detail
+		start = sf.clock.Now()
+		reads := counter.count()
+		headerDeadline := sf.beginRequestHeader(conn, handshakeDeadline)
+		request, err = ParseRequest(reader)
+		tr.flush("request")
+		if err != nil {
+			if isClientNoise(err) {
+				return sf.clientNoise(PhaseRequest, err)
+			}
+			if !headerDeadline.IsZero() && !sf.clock.Now().Before(headerDeadline) {
+				sf.incError(PhaseRequest, NoReply)
+				sf.logger.Errorf("slow client %s: request header not complete in %v",
+					conn.RemoteAddr(), sf.requestHeaderTimeout)
+			} else if errors.Is(err, statute.ErrUnrecognizedAddrType) {
+				sf.incError(PhaseNegotiation, statute.RepAddrTypeNotSupported)
+				if err := sf.sendFailure(writer, conn.RemoteAddr(), statute.RepAddrTypeNotSupported,
+					statute.DetailAddrTypeNotSupported); err != nil {
+					return fmt.Errorf("failed to send reply %w", err)
+				}
+			} else {
+				sf.incError(PhaseNegotiation, NoReply)
+			}
+			return fmt.Errorf("failed to read destination address, %w", err)
+		}
+		if err := sf.endRequestHeader(conn, handshakeDeadline, request, counter.count()-reads, start); err != nil {
+			return err
 		}
-		return fmt.Errorf("failed to read destination address, %w", err)
 	}
 
+	negotiationSpan.End()
+	negotiationSpan = nil
+	endHandshake(conn, handshakeDeadline)
+	// the request is sent, Shutdown lets it finish
+	sess.setState(SessionDispatching)
+
 	if request.Request.Command != statute.CommandConnect &&
 		request.Request.Command != statute.CommandBind &&
-		request.Request.Command != statute.CommandAssociate {
-		if err := SendReply(conn, statute.RepCommandNotSupported, nil); err != nil {
+		request.Request.Command != statute.CommandAssociate &&
+		!(request.Request.Command == statute.CommandDiagnostic && sf.diagnostic) {
+		sf.incError(PhaseNegotiation, statute.RepCommandNotSupported)
+		if err := sf.sendFailure(writer, conn.RemoteAddr(), statute.RepCommandNotSupported,
+			statute.DetailCommandNotSupported); err != nil {
 			return fmt.Errorf("failed to send reply, %v", err)
 		}
 		return fmt.Errorf("unrecognized command[%d]", request.Request.Command)
 	}
+	if sf.commandDisabled(request.Request.Command) {
+		sf.incError(PhaseNegotiation, statute.RepCommandNotSupported)
+		if err := sf.sendFailure(writer, conn.RemoteAddr(), statute.RepCommandNotSupported,
+			statute.DetailCommandNotSupported); err != nil {
+			return fmt.Errorf("failed to send reply, %v", err)
+		}
+		return fmt.Errorf("command[%d] disabled", request.Request.Command)
+	}
 
+	if m, ok := sf.metrics.(DurationMetrics); ok {
+		m.ObserveDuration(PhaseNegotiation, request.Command, negotiationDuration)
+		m.ObserveDuration(PhaseAuth, request.Command, authDuration)
+	}
+
+	request.sess = sess
+	request.conn = conn
+	request.replies = replies
+	request.Tag = tag
 	request.AuthContext = authContext
-	request.LocalAddr = conn.LocalAddr()
-	request.RemoteAddr = conn.RemoteAddr()
+	request.Accepted = sess.started
+	request.listener = lc
+	request.ClientCountry, request.countryPolicy = country, countryPolicy
+	if lc != nil {
+		request.Tenant = lc.tenant
+	}
+	sess.setRequest(request)
+	sess.setBuffered(bufConn.Buffered())
+	if sf.usage != nil {
+		sf.usage.run()
+		defer func() {
+			if SessionState(atomic.LoadUint32(&sess.state)) == SessionRelaying {
+				sf.usage.addSession(request)
+			}
+		}()
+	}
+	sess.onTransfer = sf.transferHook(request.Command, sf.milestones.counter(usernameOf(request)))
+	request.TLS = tlsState
+	request.LocalAddr = unmapAddr(conn.LocalAddr())
+	request.RemoteAddr = unmapAddr(conn.RemoteAddr())
+	if client := forwardedClient(authContext); client != nil {
+		request.ForwardedBy, request.RemoteAddr = request.RemoteAddr, client
+	}
+	if request.Command == statute.CommandDiagnostic {
+		return sf.handleDiagnostic(writer, request)
+	}
+	if err := sf.checkPipelined(writer, request, conn, bufConn); err != nil {
+		return err
+	}
+	request.mem = newMemoryBudget(sf.sessionMemory, sf.memory)
+	defer request.mem.close()
+	if err := sf.admitMemory(writer, request, bufConn.Buffered()); err != nil {
+		return err
+	}
+	releaseHandshake()
+	releaseSession, ok := sf.perIP.acquireSession(sourceIP)
+	if !ok {
+		sess.setCloseReason(CloseReasonQuotaExceeded)
+		sf.incError(PhaseRule, statute.RepRuleFailure)
+		if err := sf.sendFailure(writer, conn.RemoteAddr(), statute.RepRuleFailure,
+			statute.DetailRuleDenied); err != nil {
+			return fmt.Errorf("failed to send reply, %v", err)
+		}
+		return fmt.Errorf("too many sessions from %s", sourceIP)
+	}
+	defer releaseSession()
+	tr.startRelay(sf.traceLimit)
+	tw.startRelay(sf.traceLimit)
+	sf.meterStart(sess, request)
+	if sf.tracer != nil {
+		span.SetAttributes(requestAttributes(request)...)
+		writer = &replySpanWriter{Writer: writer, span: span}
+	}
+	defer sf.watchIdle(sess, conn, sf.idleTimeoutOf(lc))()
 	// Process the client request
-	return sf.handleRequest(conn, request)
+	return sf.handlerOf(lc)(ctx, writer, request)
+}
+
+// commandDisabled reports whether the command is disabled by the options
+func (sf *Server) commandDisabled(cmd byte) bool {
+	return sf.disabledCommands&(1<<cmd) != 0
 }
 
 // authenticate is used to handle connection authentication
 func (sf *Server) authenticate(conn io.Writer, bufConn io.Reader,
 	userAddr string, methods []byte) (*AuthContext, error) {
+	return sf.authenticateWith(context.Background(), sf.authMethods, conn, bufConn, userAddr, methods, nil)
+}
+
+func (sf *Server) authenticateWith(ctx context.Context, authMethods map[uint8]Authenticator, conn io.Writer,
+	bufConn io.Reader, userAddr string, methods []byte, tlsState *tls.ConnectionState) (*AuthContext, error) {
 	// Select a usable method
 	for _, method := range methods {
-		if cator, found := sf.authMethods[method]; found {
-			return cator.Authenticate(bufConn, conn, userAddr)
+		if cator, found := authMethods[method]; found {
+			start := sf.clock.Now()
+			var delayed *delayedAuth
+			if sf.authFailureDelay > 0 || sf.authFailureJitter > 0 {
+				delayed = newDelayedAuth(conn)
+				conn, bufConn = delayed.replies, delayed.reader(bufConn)
+			}
+			var ac *AuthContext
+			var err error
+			if tc, ok := cator.(TLSAuthenticator); ok && tlsState != nil {
+				ac, err = tc.AuthenticateTLS(bufConn, conn, userAddr, tlsState)
+			} else if cc, ok := cator.(ContextAuthenticator); ok {
+				ac, err = cc.AuthenticateContext(ctx, bufConn, conn, userAddr)
+			} else {
+				ac, err = cator.Authenticate(bufConn, conn, userAddr)
+			}
+			sf.emitAuthEvent(method, userAddr, start, ac, err)
+			if m, ok := sf.metrics.(SessionMetrics); ok && err != nil {
+				m.IncAuthFailure(method)
+			}
+			if delayed != nil {
+				if ferr := delayed.release(ctx, sf, err != nil); ferr != nil && err == nil {
+					return nil, ferr
+				}
+			}
+			return ac, err
 		}
 	}
 	// No usable method found
@@ -180,6 +825,28 @@ func (sf *Server) authenticate(conn io.Writer, bufConn io.Reader,
 	return nil, statute.ErrNoSupportedAuth
 }
 
+// closerFunc is an adapter to allow the use of ordinary functions as io.Closer
+type closerFunc func() error
+
+// Close implement interface io.Closer
+func (f closerFunc) Close() error { return f() }
+
+// closeOnDone closes c once the context is done, the returned stop releases the watch.
+func closeOnDone(ctx context.Context, c io.Closer) (stop func()) {
+	if ctx.Done() == nil {
+		return func() {}
+	}
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.Close()
+		case <-done:
+		}
+	}()
+	return func() { close(done) }
+}
+
 func (sf *Server) goFunc(f func()) {
 	if sf.gPool == nil || sf.gPool.Submit(f) != nil {
 		go f()
@@ -2,6 +2,7 @@ package socks5
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"io"
 	"log"
@@ -318,3 +319,87 @@ func TestNoSupportedAuth_Server(t *testing.T) {
 
 	assert.Equal(t, []byte{statute.VersionSocks5, statute.MethodNoAcceptable}, rsp.Bytes())
 }
+
+type temporaryError struct{}
+
+func (temporaryError) Error() string   { return "temporary error" }
+func (temporaryError) Timeout() bool   { return false }
+func (temporaryError) Temporary() bool { return true }
+
+type errListener struct {
+	net.Listener
+	errs []error
+}
+
+func (l *errListener) Accept() (net.Conn, error) {
+	if len(l.errs) == 0 {
+		return nil, errors.New("closed")
+	}
+	err := l.errs[0]
+	l.errs = l.errs[1:]
+	return nil, err
+}
+
+func (l *errListener) Close() error { return nil }
+
+func TestServer_AcceptBackoff(t *testing.T) {
+	var delays []time.Duration
+	srv := NewServer(
+		WithAcceptBackoff(time.Millisecond, 3*time.Millisecond),
+		WithAcceptErrorHandle(func(err error, delay time.Duration) {
+			delays = append(delays, delay)
+		}),
+	)
+	err := srv.Serve(&errListener{errs: []error{temporaryError{}, temporaryError{}, temporaryError{}}})
+	require.EqualError(t, err, "closed")
+	require.Equal(t, []time.Duration{time.Millisecond, 2 * time.Millisecond, 3 * time.Millisecond}, delays)
+
+	srv = NewServer(WithAcceptBackoff(0, 0))
+	err = srv.Serve(&errListener{errs: []error{temporaryError{}}})
+	require.Equal(t, temporaryError{}, err)
+}
+
+func TestServer_AcceptBackoff_Cancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	// the backoff sleeps on the clock of the server, which never advances
+	srv := NewServer(
+		WithClock(newManualClock()),
+		WithAcceptBackoff(time.Hour, time.Hour),
+		WithAcceptErrorHandle(func(error, time.Duration) { cancel() }),
+	)
+	done := make(chan error, 1)
+	go func() { done <- srv.ServeContext(ctx, &errListener{errs: []error{temporaryError{}}}) }()
+	select {
+	case err := <-done:
+		require.Equal(t, context.Canceled, err)
+	case <-time.After(time.Second):
+		t.Fatal("accept backoff not cancelled")
+	}
+}
+
+func TestServer_DisableCommand(t *testing.T) {
+	target := echoTarget(t)
+	proxyAddr := serveSocks(t, WithRule(NewPermitAll()), WithDisableBind(), WithDisableAssociate())
+
+	// CONNECT still served
+	relaySession(t, proxyAddr, target).Close()
+
+	for _, cmd := range []byte{statute.CommandBind, statute.CommandAssociate} {
+		conn, err := net.Dial("tcp", proxyAddr.String())
+		require.NoError(t, err)
+		req := bytes.NewBuffer([]byte{statute.VersionSocks5, 1, statute.MethodNoAuth})
+		req.Write(statute.Request{
+			Version: statute.VersionSocks5,
+			Command: cmd,
+			DstAddr: statute.AddrSpec{AddrType: statute.ATYPIPv4, IP: target.IP, Port: target.Port},
+		}.Bytes())
+		_, err = conn.Write(req.Bytes())
+		require.NoError(t, err)
+		_, err = statute.ParseMethodReply(conn)
+		require.NoError(t, err)
+		rep, err := statute.ParseReply(conn)
+		require.NoError(t, err)
+		require.Equal(t, statute.RepCommandNotSupported, rep.Response)
+		conn.Close()
+	}
+}
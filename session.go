@@ -0,0 +1,303 @@
+package socks5
+
+import (
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// SessionState is the state of a session
+type SessionState uint32
+
+// session state defined
+const (
+	SessionNegotiating SessionState = iota
+	SessionAuthenticating
+	SessionRequesting
+	SessionConnecting
+	SessionRelaying
+	// SessionDispatching the request is read, being resolved, checked by the rules
+	// and dispatched to the command
+	SessionDispatching
+)
+
+// String implement interface fmt.Stringer
+func (s SessionState) String() string {
+	switch s {
+	case SessionNegotiating:
+		return "negotiating"
+	case SessionAuthenticating:
+		return "authenticating"
+	case SessionRequesting:
+		return "requesting"
+	case SessionConnecting:
+		return "connecting"
+	case SessionRelaying:
+		return "relaying"
+	case SessionDispatching:
+		return "dispatching"
+	}
+	return "unknown"
+}
+
+// Session is a snapshot of a tcp session of the server
+type Session struct {
+	// ID of the session, unique in the server
+	ID uint64
+	// State of the session
+	State SessionState
+	// ClientAddr of the the network that sent the request,
+	// the original client once the identity forwarded by a front proxy
+	ClientAddr net.Addr
+	// ForwardedBy is the front proxy which forwarded the identity of the client, nil if not forwarded
+	ForwardedBy net.Addr
+	// LocalAddr of the the network server listen
+	LocalAddr net.Addr
+	// Command of the request, 0 if the request is not parsed yet
+	Command byte
+	// DestAddr of the request, empty if the request is not parsed yet
+	DestAddr string
+	// Tenant of the request, see Request.Tenant
+	Tenant string
+	// Tag of the connection attached by the AcceptFilter
+	Tag string
+	// User name of the request, empty if not authenticated by username
+	User string
+	// Dial info of the connect path, nil if not dialed yet
+	Dial *DialInfo
+	// Started time of the session
+	Started time.Time
+	// Deadline of the session, zero means no deadline
+	Deadline time.Time
+	// Buffered bytes received from client but not forwarded yet,
+	// such as the pipelined data after the request.
+	Buffered int
+	// BytesUp from client to target
+	BytesUp uint64
+	// BytesDown from target to client
+	BytesDown uint64
+	// CloseReason of the session, CloseReasonNone if the session is active
+	CloseReason CloseReason
+	// Rule name and RuleReason of the Request.Decision, empty if the rule set does not record it
+	Rule       string
+	RuleReason string
+}
+
+// session is a tcp session of the server
+type session struct {
+	id         uint64
+	conn       net.Conn
+	tag        string
+	clientAddr net.Addr
+	localAddr  net.Addr
+	started    time.Time
+	// updated atomically
+	state     uint32
+	deadline  int64 // unix nano
+	buffered  int64
+	bytesUp   uint64
+	bytesDown uint64
+	// closeReason is set once the session is ending
+	closeReason uint32
+	// onTransfer is notified of the bytes relayed in both directions, upstream is from client
+	// to target, nil if not needed, set before relay.
+	onTransfer func(upstream bool, n int)
+
+	mu       sync.Mutex
+	command  byte
+	destAddr string
+	tenant   string
+	user     string
+	dial     *DialInfo
+	decision *RuleDecision
+	// forwardedFor is the original client if the identity forwarded by a front proxy
+	forwardedFor net.Addr
+	// migratable is the target of the relay which could be migrated, nil if not migratable
+	migratable net.Conn
+	// migration the session is handed to, nil if not migrating
+	migration *migration
+	handedOff bool
+	// metered is the request notified to the traffic meter
+	metered *Request
+}
+
+var sessionID uint64
+
+func newSession(conn net.Conn, now time.Time) *session {
+	return &session{
+		id:         atomic.AddUint64(&sessionID, 1),
+		conn:       conn,
+		clientAddr: unmapAddr(conn.RemoteAddr()),
+		localAddr:  unmapAddr(conn.LocalAddr()),
+		started:    now,
+	}
+}
+
+func (sf *session) setState(state SessionState) {
+	if sf != nil {
+		atomic.StoreUint32(&sf.state, uint32(state))
+	}
+}
+
+func (sf *session) setDeadline(t time.Time) {
+	if sf != nil {
+		var v int64
+		if !t.IsZero() {
+			v = t.UnixNano()
+		}
+		atomic.StoreInt64(&sf.deadline, v)
+	}
+}
+
+func (sf *session) setBuffered(n int) {
+	if sf != nil {
+		atomic.StoreInt64(&sf.buffered, int64(n))
+	}
+}
+
+func (sf *session) setRequest(req *Request) {
+	if sf != nil {
+		sf.mu.Lock()
+		sf.command = req.Command
+		sf.destAddr = req.RawDestAddr.String()
+		sf.tenant = req.Tenant
+		sf.user = usernameOf(req)
+		sf.forwardedFor = forwardedClient(req.AuthContext)
+		sf.mu.Unlock()
+	}
+}
+
+func (sf *session) setDecision(d *RuleDecision) {
+	if sf != nil {
+		sf.mu.Lock()
+		sf.decision = d
+		sf.mu.Unlock()
+	}
+}
+
+// countUp counts the bytes from client to target
+func (sf *session) countUp(n int) {
+	if sf != nil {
+		atomic.AddUint64(&sf.bytesUp, uint64(n))
+		if sf.onTransfer != nil {
+			sf.onTransfer(true, n)
+		}
+	}
+}
+
+// countDown counts the bytes from target to client
+func (sf *session) countDown(n int) {
+	if sf != nil {
+		atomic.AddUint64(&sf.bytesDown, uint64(n))
+		if sf.onTransfer != nil {
+			sf.onTransfer(false, n)
+		}
+	}
+}
+
+// upWriter wraps w counting the bytes from client to target
+func (sf *session) upWriter(w io.Writer) io.Writer {
+	if sf == nil {
+		return w
+	}
+	return &countWriter{w, &sf.bytesUp, true, sf.onTransfer, sf}
+}
+
+// downWriter wraps w counting the bytes from target to client
+func (sf *session) downWriter(w io.Writer) io.Writer {
+	if sf == nil {
+		return w
+	}
+	return &countWriter{w, &sf.bytesDown, false, sf.onTransfer, sf}
+}
+
+func (sf *session) snapshot() Session {
+	s := Session{
+		ID:         sf.id,
+		State:      SessionState(atomic.LoadUint32(&sf.state)),
+		ClientAddr: sf.clientAddr,
+		Tag:        sf.tag,
+		LocalAddr:  sf.localAddr,
+		Started:    sf.started,
+		Buffered:   int(atomic.LoadInt64(&sf.buffered)),
+		BytesUp:    atomic.LoadUint64(&sf.bytesUp),
+		BytesDown:  atomic.LoadUint64(&sf.bytesDown),
+		// the close reason is set once the session is ending
+		CloseReason: CloseReason(atomic.LoadUint32(&sf.closeReason)),
+	}
+	if d := atomic.LoadInt64(&sf.deadline); d != 0 {
+		s.Deadline = time.Unix(0, d)
+	}
+	sf.mu.Lock()
+	s.Command, s.DestAddr, s.Tenant = sf.command, sf.destAddr, sf.tenant
+	s.User, s.Dial = sf.user, sf.dial
+	if sf.decision != nil {
+		s.Rule, s.RuleReason = sf.decision.Rule, sf.decision.Reason
+	}
+	if sf.forwardedFor != nil {
+		s.ClientAddr, s.ForwardedBy = sf.forwardedFor, sf.clientAddr
+	}
+	sf.mu.Unlock()
+	return s
+}
+
+// Sessions returns the snapshot of the active tcp sessions
+func (sf *Server) Sessions() []Session {
+	var sessions []Session
+	sf.sessions.Range(func(_, value interface{}) bool {
+		sessions = append(sessions, value.(*session).snapshot())
+		return true
+	})
+	return sessions
+}
+
+// CloseSession forcibly closes the active tcp session of the id with the close reason
+// CloseReasonAdminKill, reports whether the session is found.
+func (sf *Server) CloseSession(id uint64) bool {
+	value, ok := sf.sessions.Load(id)
+	if !ok {
+		return false
+	}
+	sess := value.(*session)
+	sess.setCloseReason(CloseReasonAdminKill)
+	sess.conn.Close()
+	return true
+}
+
+// countWriter counts the bytes written
+type countWriter struct {
+	io.Writer
+	n        *uint64
+	upstream bool
+	notify   func(upstream bool, n int)
+	sess     *session
+}
+
+// Write implement interface io.Writer
+func (sf *countWriter) Write(p []byte) (int, error) {
+	n, err := sf.Writer.Write(p)
+	sf.add(n)
+	return n, err
+}
+
+// add counts the n bytes relayed
+func (sf *countWriter) add(n int) {
+	atomic.AddUint64(sf.n, uint64(n))
+	if sf.notify != nil {
+		sf.notify(sf.upstream, n)
+	}
+}
+
+// CloseWrite implement interface closeWriter, the migrating session is not half closed,
+// as the connection is handed to the new process.
+func (sf *countWriter) CloseWrite() error {
+	if sf.sess.migrationOf() != nil {
+		return nil
+	}
+	if c, ok := sf.Writer.(closeWriter); ok {
+		return c.CloseWrite()
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package socks5
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/proxy"
+
+	"github.com/thinkgos/go-socks5/statute"
+)
+
+func TestServer_Sessions(t *testing.T) {
+	// Create a local listener
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.Copy(conn, conn) // nolint: errcheck
+	}()
+
+	srv := NewServer()
+	sl, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go srv.Serve(sl) // nolint: errcheck
+
+	dial, err := proxy.SOCKS5("tcp", sl.Addr().String(), nil, proxy.Direct)
+	require.NoError(t, err)
+	conn, err := dial.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	conn.Write([]byte("ping")) // nolint: errcheck
+	out := make([]byte, 4)
+	conn.SetDeadline(time.Now().Add(time.Second)) // nolint: errcheck
+	_, err = io.ReadFull(conn, out)
+	require.NoError(t, err)
+
+	sessions := srv.Sessions()
+	require.Len(t, sessions, 1)
+	require.Equal(t, SessionRelaying, sessions[0].State)
+	require.Equal(t, statute.CommandConnect, sessions[0].Command)
+	require.Equal(t, l.Addr().String(), sessions[0].DestAddr)
+	require.Equal(t, uint64(4), sessions[0].BytesUp)
+	require.Equal(t, uint64(4), sessions[0].BytesDown)
+	require.Equal(t, "relaying", sessions[0].State.String())
+}
+
+func TestServer_CloseSession(t *testing.T) {
+	target := echoTarget(t)
+	reasons := make(chan CloseReason, 1)
+	srv := NewServer(WithStructuredLogger(LoggerFunc(func(_ Level, msg string, kv ...interface{}) {
+		if fields := Fields(kv...); msg == "session closed" {
+			reasons <- fields["reason"].(CloseReason)
+		}
+	})))
+	proxyAddr, _ := startServer(t, srv)
+	defer srv.Close()
+
+	conn := relaySession(t, proxyAddr, target)
+	defer conn.Close()
+	require.Eventually(t, func() bool { return len(srv.Sessions()) == 1 }, time.Second, 10*time.Millisecond)
+	id := srv.Sessions()[0].ID
+
+	require.False(t, srv.CloseSession(id+1))
+	require.True(t, srv.CloseSession(id))
+	conn.SetReadDeadline(time.Now().Add(time.Second)) // nolint: errcheck
+	_, err := conn.Read(make([]byte, 1))
+	require.Error(t, err)
+	ne, ok := err.(net.Error)
+	require.False(t, ok && ne.Timeout())
+	require.Equal(t, CloseReasonAdminKill, <-reasons)
+	require.Eventually(t, func() bool { return len(srv.Sessions()) == 0 }, time.Second, 10*time.Millisecond)
+	require.False(t, srv.CloseSession(id))
+}
@@ -0,0 +1,176 @@
+package socks5
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"sync"
+	"time"
+)
+
+// shadow defaults
+const (
+	defaultShadowMaxBuffer = 256 * 1024
+	shadowQueueLen         = 256
+	shadowTimeout          = 5 * time.Second
+)
+
+// ShadowConfig mirrors the selected CONNECT traffic of the client to the target direction
+// to a shadow backend, such as testing a migration with the production traffic.
+// The shadow is isolated from the primary relay, it never blocks or fails the session:
+// the data are queued and written to the shadow in background, the responses of the
+// shadow are discarded, and the mirror of the session is abandoned once the shadow
+// fails or falls behind MaxBuffer bytes.
+type ShadowConfig struct {
+	// Select returns the address of the shadow backend for the request, false to not mirror it.
+	Select func(ctx context.Context, request *Request) (addr string, ok bool)
+	// Dialer dials the shadow backend, defaults to net.Dialer.
+	Dialer Dialer
+	// MaxBuffer is the bytes queued for the shadow, defaults to 256 KiB.
+	MaxBuffer int
+	// ErrorHandle optional notified of the failure of the shadow of the request
+	ErrorHandle func(request *Request, err error)
+}
+
+// shadowMirror writes the data mirrored of a session to the shadow backend
+type shadowMirror struct {
+	cfg     *ShadowConfig
+	request *Request
+	addr    string
+	mu      sync.Mutex
+	ch      chan []byte
+	queued  int
+	closed  bool
+	failed  bool
+}
+
+// startShadow starts the mirror of the request if selected, nil if not
+func (sf *Server) startShadow(ctx context.Context, request *Request) *shadowMirror {
+	if sf.shadow == nil || sf.shadow.Select == nil {
+		return nil
+	}
+	addr, ok := sf.shadow.Select(ctx, request)
+	if !ok {
+		return nil
+	}
+	m := &shadowMirror{
+		cfg:     sf.shadow,
+		request: request,
+		addr:    addr,
+		ch:      make(chan []byte, shadowQueueLen),
+	}
+	sf.goFunc(m.run)
+	return m
+}
+
+// run dials the shadow and writes the data queued, until the mirror closed and the queue drained
+func (sf *shadowMirror) run() {
+	var d Dialer = sf.cfg.Dialer
+	if d == nil {
+		d = new(net.Dialer)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), shadowTimeout)
+	conn, err := d.DialContext(ctx, "tcp", sf.addr)
+	cancel()
+	if err != nil {
+		sf.fail(fmt.Errorf("dial shadow %s, %v", sf.addr, err))
+	} else {
+		defer conn.Close()
+		go io.Copy(ioutil.Discard, conn) // nolint: errcheck
+	}
+
+	for b := range sf.ch {
+		sf.mu.Lock()
+		sf.queued -= len(b)
+		failed := sf.failed
+		sf.mu.Unlock()
+		if failed {
+			continue
+		}
+		conn.SetWriteDeadline(time.Now().Add(shadowTimeout)) // nolint: errcheck
+		if _, err := conn.Write(b); err != nil {
+			sf.fail(fmt.Errorf("write shadow %s, %v", sf.addr, err))
+		}
+	}
+	if c, ok := conn.(closeWriter); ok && !sf.isFailed() {
+		c.CloseWrite() // nolint: errcheck
+	}
+}
+
+// write queues a copy of the data, never blocks
+func (sf *shadowMirror) write(p []byte) {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	if sf.closed || sf.failed || len(p) == 0 {
+		return
+	}
+	maxBuffer := sf.cfg.MaxBuffer
+	if maxBuffer <= 0 {
+		maxBuffer = defaultShadowMaxBuffer
+	}
+	if sf.queued+len(p) > maxBuffer {
+		sf.failLocked(fmt.Errorf("shadow %s falls behind over %d bytes", sf.addr, maxBuffer))
+		return
+	}
+	select {
+	case sf.ch <- append([]byte(nil), p...):
+		sf.queued += len(p)
+	default:
+		sf.failLocked(fmt.Errorf("shadow %s falls behind over %d writes", sf.addr, shadowQueueLen))
+	}
+}
+
+// close stops mirroring, the data queued are still written to the shadow
+func (sf *shadowMirror) close() {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	if !sf.closed {
+		sf.closed = true
+		close(sf.ch)
+	}
+}
+
+func (sf *shadowMirror) isFailed() bool {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	return sf.failed
+}
+
+func (sf *shadowMirror) fail(err error) {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	sf.failLocked(err)
+}
+
+func (sf *shadowMirror) failLocked(err error) {
+	if sf.failed {
+		return
+	}
+	sf.failed = true
+	if sf.cfg.ErrorHandle != nil {
+		sf.cfg.ErrorHandle(sf.request, err)
+	}
+}
+
+// shadowConn is the target conn which mirrors the data written to the shadow
+type shadowConn struct {
+	net.Conn
+	mirror *shadowMirror
+}
+
+// Write implement interface io.Writer
+func (sf *shadowConn) Write(p []byte) (int, error) {
+	n, err := sf.Conn.Write(p)
+	sf.mirror.write(p[:n])
+	return n, err
+}
+
+// CloseWrite implement interface closeWriter
+func (sf *shadowConn) CloseWrite() error {
+	if c, ok := sf.Conn.(closeWriter); ok {
+		return c.CloseWrite()
+	}
+	return nil
+}
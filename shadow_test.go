@@ -0,0 +1,97 @@
+package socks5
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer_Shadow(t *testing.T) {
+	target := echoTarget(t)
+	shadow, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer shadow.Close()
+	mirrored := make(chan string, 1)
+	go func() {
+		conn, err := shadow.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.Write([]byte("shadow response")) // nolint: errcheck
+		b, _ := ioutil.ReadAll(conn)
+		mirrored <- string(b)
+	}()
+
+	proxy := serveSocks(t, WithShadow(ShadowConfig{
+		Select: func(_ context.Context, request *Request) (string, bool) {
+			return shadow.Addr().String(), request.DestAddr.Port == target.Port
+		},
+	}))
+	conn := relaySession(t, proxy, target)
+	_, err = conn.Write([]byte("pong"))
+	require.NoError(t, err)
+	buf := make([]byte, 4)
+	_, err = conn.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, "pong", string(buf))
+	conn.Close()
+
+	select {
+	case b := <-mirrored:
+		require.Equal(t, "pingpong", b)
+	case <-time.After(time.Second):
+		t.Fatal("no data mirrored")
+	}
+}
+
+func TestServer_Shadow_Failure(t *testing.T) {
+	target := echoTarget(t)
+	// a port closed
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	shadowAddr := l.Addr().String()
+	l.Close()
+
+	failed := make(chan error, 1)
+	proxy := serveSocks(t, WithShadow(ShadowConfig{
+		Select: func(context.Context, *Request) (string, bool) { return shadowAddr, true },
+		ErrorHandle: func(_ *Request, err error) {
+			failed <- err
+		},
+	}))
+	conn := relaySession(t, proxy, target)
+	defer conn.Close()
+	_, err = conn.Write([]byte("pong"))
+	require.NoError(t, err)
+	buf := make([]byte, 4)
+	_, err = conn.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, "pong", string(buf))
+
+	select {
+	case err := <-failed:
+		require.Contains(t, err.Error(), "dial shadow")
+	case <-time.After(time.Second):
+		t.Fatal("shadow failure not notified")
+	}
+}
+
+func TestShadowMirror_FallBehind(t *testing.T) {
+	var failure error
+	m := &shadowMirror{
+		cfg:  &ShadowConfig{MaxBuffer: 8, ErrorHandle: func(_ *Request, err error) { failure = err }},
+		addr: "shadow",
+		ch:   make(chan []byte, shadowQueueLen),
+	}
+	m.write([]byte("12345"))
+	m.write([]byte("6789"))
+	require.Error(t, failure)
+	m.write([]byte("0"))
+	m.close()
+	require.Len(t, m.ch, 1)
+}
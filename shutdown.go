@@ -0,0 +1,124 @@
+package socks5
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// ErrServerClosed is returned by the Server's Serve and ListenAndServe
+// after a call to Shutdown or Close.
+var ErrServerClosed = errors.New("socks5: Server closed")
+
+// shutdownPollIntervalMax is the max interval to poll for the sessions drained.
+const shutdownPollIntervalMax = 500 * time.Millisecond
+
+func (sf *Server) shuttingDown() bool {
+	return atomic.LoadInt32(&sf.inShutdown) != 0
+}
+
+// trackListener adds or removes the listener with its config, false if the server is shutting down.
+func (sf *Server) trackListener(l *net.Listener, lc *listenerConfig, add bool) bool {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	if sf.listeners == nil {
+		sf.listeners = make(map[*net.Listener]*listenerConfig)
+	}
+	if add {
+		if sf.shuttingDown() {
+			return false
+		}
+		sf.listeners[l] = lc
+	} else {
+		delete(sf.listeners, l)
+	}
+	return true
+}
+
+func (sf *Server) closeListeners() error {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	var err error
+	for l := range sf.listeners {
+		if cerr := (*l).Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+		delete(sf.listeners, l)
+	}
+	return err
+}
+
+// closeSessions closes the client connections of the sessions in the states,
+// all the sessions if no state specified, reports whether no active or refusing connection left.
+func (sf *Server) closeSessions(states ...SessionState) bool {
+	sf.sessions.Range(func(_, value interface{}) bool {
+		sess := value.(*session)
+		state := SessionState(atomic.LoadUint32(&sess.state))
+		if len(states) == 0 {
+			sess.setCloseReason(CloseReasonAdminKill)
+			sess.conn.Close()
+			return true
+		}
+		for _, s := range states {
+			if s == state {
+				sess.setCloseReason(CloseReasonAdminKill)
+				sess.conn.Close()
+				break
+			}
+		}
+		return true
+	})
+	return atomic.LoadInt64(&sf.activeConns) == 0 && atomic.LoadInt64(&sf.refusing) == 0
+}
+
+// Close immediately closes all the listeners and the client connections,
+// the in-flight CONNECT, BIND and ASSOCIATE sessions are interrupted.
+// Close does not wait for the goroutines to exit nor the final usage report, see Shutdown.
+// Close returns any error returned from closing the listeners.
+func (sf *Server) Close() error {
+	atomic.StoreInt32(&sf.inShutdown, 1)
+	err := sf.closeListeners()
+	sf.closeSessions()
+	sf.selfTest.close()
+	sf.usage.stop()
+	return err
+}
+
+// Shutdown gracefully shuts down the server without interrupting the sessions in relay,
+// modeled after the net/http Server: it first closes all the listeners, then closes the
+// connections not sent the request yet, those negotiating, authenticating or reading the request,
+// the requests read are left to be resolved, checked and dispatched, and then waits for the sessions and the connections being refused over the limit to drain.
+// If the context expires before the shutdown is complete, Shutdown returns the context's error,
+// the remaining sessions can be interrupted by Close. Otherwise it returns any error returned
+// from closing the listeners after all the connection goroutines have exited and the final usage
+// report of WithUsageReport delivered.
+func (sf *Server) Shutdown(ctx context.Context) error {
+	atomic.StoreInt32(&sf.inShutdown, 1)
+	err := sf.closeListeners()
+	sf.selfTest.close()
+
+	interval := time.Millisecond
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+	for {
+		if sf.closeSessions(SessionNegotiating, SessionAuthenticating, SessionRequesting) {
+			select {
+			case <-sf.usage.stop():
+				return err
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+			if interval *= 2; interval > shutdownPollIntervalMax {
+				interval = shutdownPollIntervalMax
+			}
+			timer.Reset(interval)
+		}
+	}
+}
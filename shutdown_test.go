@@ -0,0 +1,207 @@
+package socks5
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/thinkgos/go-socks5/statute"
+)
+
+// relaySession connects to the target through the proxy and checks the relay
+func relaySession(t testing.TB, proxy net.Addr, target *net.TCPAddr) net.Conn {
+	conn, err := net.Dial(proxy.Network(), proxy.String())
+	require.NoError(t, err)
+	req := bytes.NewBuffer([]byte{statute.VersionSocks5, 1, statute.MethodNoAuth})
+	req.Write(statute.Request{
+		Version: statute.VersionSocks5,
+		Command: statute.CommandConnect,
+		DstAddr: statute.AddrSpec{AddrType: statute.ATYPIPv4, IP: target.IP, Port: target.Port},
+	}.Bytes())
+	req.WriteString("ping")
+	_, err = conn.Write(req.Bytes())
+	require.NoError(t, err)
+	_, err = statute.ParseMethodReply(conn)
+	require.NoError(t, err)
+	rep, err := statute.ParseReply(conn)
+	require.NoError(t, err)
+	require.Equal(t, statute.RepSuccess, rep.Response)
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(conn, buf)
+	require.NoError(t, err)
+	return conn
+}
+
+func startServer(t *testing.T, srv *Server) (net.Addr, chan error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	served := make(chan error, 1)
+	go func() { served <- srv.Serve(l) }()
+	return l.Addr(), served
+}
+
+func TestServer_Shutdown(t *testing.T) {
+	target := echoTarget(t)
+	srv := NewServer()
+	proxy, served := startServer(t, srv)
+
+	relaying := relaySession(t, proxy, target)
+	defer relaying.Close()
+	negotiating, err := net.Dial("tcp", proxy.String())
+	require.NoError(t, err)
+	defer negotiating.Close()
+	time.Sleep(10 * time.Millisecond)
+
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- srv.Shutdown(context.Background()) }()
+
+	require.Equal(t, ErrServerClosed, <-served)
+	// the connection not sent the request is closed
+	negotiating.SetReadDeadline(time.Now().Add(time.Second)) // nolint: errcheck
+	_, err = negotiating.Read(make([]byte, 1))
+	require.Equal(t, io.EOF, err)
+
+	// the relaying session is not interrupted
+	_, err = relaying.Write([]byte("pong"))
+	require.NoError(t, err)
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(relaying, buf)
+	require.NoError(t, err)
+	require.Equal(t, []byte("pong"), buf)
+	select {
+	case <-shutdown:
+		t.Fatal("shutdown returned before the session drained")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	relaying.Close()
+	select {
+	case err := <-shutdown:
+		require.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("shutdown not returned after the session drained")
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	require.Equal(t, ErrServerClosed, srv.Serve(l))
+}
+
+func TestServer_Close(t *testing.T) {
+	target := echoTarget(t)
+	srv := NewServer()
+	proxy, served := startServer(t, srv)
+
+	relaying := relaySession(t, proxy, target)
+	defer relaying.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	require.Equal(t, context.DeadlineExceeded, srv.Shutdown(ctx))
+	require.Equal(t, ErrServerClosed, <-served)
+
+	require.NoError(t, srv.Close())
+	relaying.SetReadDeadline(time.Now().Add(time.Second)) // nolint: errcheck
+	_, err := relaying.Read(make([]byte, 1))
+	require.Equal(t, io.EOF, err)
+	require.NoError(t, srv.Shutdown(context.Background()))
+}
+
+func TestServer_Shutdown_Requesting(t *testing.T) {
+	srv := NewServer()
+	proxy, served := startServer(t, srv)
+
+	// authenticated but never sends the request
+	conn, method, err := greet(t, proxy, time.Second)
+	require.NoError(t, err)
+	require.Equal(t, statute.MethodNoAuth, method)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	require.NoError(t, srv.Shutdown(ctx))
+	require.Equal(t, ErrServerClosed, <-served)
+	conn.SetReadDeadline(time.Now().Add(time.Second)) // nolint: errcheck
+	_, err = conn.Read(make([]byte, 1))
+	require.Equal(t, io.EOF, err)
+}
+
+func TestServer_Shutdown_Refusing(t *testing.T) {
+	target := echoTarget(t)
+	srv := NewServer(WithMaxConnections(1, OverloadRefuse))
+	proxy, served := startServer(t, srv)
+
+	relaying := relaySession(t, proxy, target)
+	// refused, waiting for the greeting
+	refused, err := net.Dial("tcp", proxy.String())
+	require.NoError(t, err)
+	defer refused.Close()
+	require.Eventually(t, func() bool { return atomic.LoadInt64(&srv.refusing) == 1 },
+		time.Second, time.Millisecond)
+	relaying.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	require.Equal(t, context.DeadlineExceeded, srv.Shutdown(ctx))
+	require.Equal(t, ErrServerClosed, <-served)
+	// the refuser ends by the refuse timeout
+	require.NoError(t, srv.Shutdown(context.Background()))
+}
+
+func TestServer_Shutdown_Dispatching(t *testing.T) {
+	target := echoTarget(t)
+	resolving, release := make(chan struct{}), make(chan struct{})
+	srv := NewServer(WithResolver(resolverFunc(func(ctx context.Context, _ string) (context.Context, net.IP, error) {
+		close(resolving)
+		<-release
+		return ctx, target.IP, nil
+	})))
+	proxy, served := startServer(t, srv)
+
+	// the request is sent, the slow resolver holds it
+	conn, err := net.Dial("tcp", proxy.String())
+	require.NoError(t, err)
+	defer conn.Close()
+	req := bytes.NewBuffer([]byte{statute.VersionSocks5, 1, statute.MethodNoAuth})
+	req.Write(statute.Request{
+		Version: statute.VersionSocks5,
+		Command: statute.CommandConnect,
+		DstAddr: statute.AddrSpec{AddrType: statute.ATYPDomain, FQDN: "echo.test", Port: target.Port},
+	}.Bytes())
+	_, err = conn.Write(req.Bytes())
+	require.NoError(t, err)
+	<-resolving
+	require.Equal(t, SessionDispatching, srv.Sessions()[0].State)
+
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- srv.Shutdown(context.Background()) }()
+	require.Equal(t, ErrServerClosed, <-served)
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+
+	// the request is not interrupted
+	_, err = statute.ParseMethodReply(conn)
+	require.NoError(t, err)
+	rep, err := statute.ParseReply(conn)
+	require.NoError(t, err)
+	require.Equal(t, statute.RepSuccess, rep.Response)
+	_, err = conn.Write([]byte("ping"))
+	require.NoError(t, err)
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(conn, buf)
+	require.NoError(t, err)
+	require.Equal(t, []byte("ping"), buf)
+
+	conn.Close()
+	select {
+	case err := <-shutdown:
+		require.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("shutdown not returned after the session drained")
+	}
+}
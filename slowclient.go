@@ -0,0 +1,58 @@
+package socks5
+
+import (
+	"fmt"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// readCounter counts the reads from the connection
+type readCounter struct {
+	net.Conn
+	reads int64
+}
+
+// Read implement interface io.Reader
+func (sf *readCounter) Read(p []byte) (int, error) {
+	atomic.AddInt64(&sf.reads, 1)
+	return sf.Conn.Read(p)
+}
+
+func (sf *readCounter) count() int {
+	return int(atomic.LoadInt64(&sf.reads))
+}
+
+// beginRequestHeader sets the read deadline of the request header if configured,
+// it returns the deadline on the server clock, zero if not configured or the deadline
+// of the handshake comes first. The read deadline itself is on the system time.
+func (sf *Server) beginRequestHeader(conn net.Conn, handshake time.Time) time.Time {
+	if sf.requestHeaderTimeout <= 0 {
+		return time.Time{}
+	}
+	wall := time.Now().Add(sf.requestHeaderTimeout)
+	if !handshake.IsZero() && handshake.Before(wall) {
+		return time.Time{}
+	}
+	conn.SetReadDeadline(wall) // nolint: errcheck
+	return sf.clock.Now().Add(sf.requestHeaderTimeout)
+}
+
+// endRequestHeader restores the read deadline to the deadline of the handshake and records the
+// request header, it returns an error if the client trickles the bytes more than the max reads.
+func (sf *Server) endRequestHeader(conn net.Conn, handshake time.Time, request *Request, reads int,
+	start time.Time) error {
+	if sf.requestHeaderTimeout > 0 {
+		conn.SetReadDeadline(handshake) // nolint: errcheck
+	}
+	sf.observeDuration(PhaseRequest, request.Command, start)
+	if m, ok := sf.metrics.(RequestHeaderMetrics); ok {
+		m.ObserveRequestHeader(len(request.Request.Bytes()), reads)
+	}
+	if sf.requestHeaderMaxReads > 0 && reads > sf.requestHeaderMaxReads {
+		sf.incError(PhaseRequest, NoReply)
+		sf.logger.Errorf("slow client %s: request header delivered in %d reads", conn.RemoteAddr(), reads)
+		return fmt.Errorf("request header delivered in %d reads, over limit %d", reads, sf.requestHeaderMaxReads)
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package socks5
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/thinkgos/go-socks5/statute"
+)
+
+func serveSlowClient(t *testing.T, srv *Server, header []byte, chunk int) error {
+	client, conn := net.Pipe()
+	defer client.Close()
+
+	errCh := make(chan error, 1)
+	go func() { errCh <- srv.ServeConn(conn) }()
+
+	_, err := client.Write([]byte{statute.VersionSocks5, 1, statute.MethodNoAuth})
+	require.NoError(t, err)
+	reply := make([]byte, 2)
+	_, err = io.ReadFull(client, reply)
+	require.NoError(t, err)
+
+	for i := 0; i < len(header); i += chunk {
+		end := i + chunk
+		if end > len(header) {
+			end = len(header)
+		}
+		if _, err = client.Write(header[i:end]); err != nil {
+			break
+		}
+	}
+	go io.Copy(ioutil.Discard, client) // nolint: errcheck
+	select {
+	case err = <-errCh:
+		return err
+	case <-time.After(2 * time.Second):
+		t.Fatal("serve not finished")
+		return nil
+	}
+}
+
+func TestServer_RequestHeaderLimit(t *testing.T) {
+	header := []byte{
+		statute.VersionSocks5, statute.CommandConnect, 0,
+		statute.ATYPIPv4, 127, 0, 0, 1, 0, 1,
+	}
+
+	t.Run("trickle", func(t *testing.T) {
+		m := newMockMetrics()
+		srv := NewServer(WithMetrics(m), WithRequestHeaderLimit(time.Second, 3))
+		err := serveSlowClient(t, srv, header, 1)
+		require.Error(t, err)
+		require.Equal(t, 1, m.headers)
+		require.Equal(t, len(header), m.reads)
+		require.Equal(t, 1, m.errors[PhaseRequest][NoReply])
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		m := newMockMetrics()
+		srv := NewServer(WithMetrics(m), WithRequestHeaderLimit(50*time.Millisecond, 0))
+		err := serveSlowClient(t, srv, header[:4], 4)
+		require.Error(t, err)
+		require.Equal(t, 0, m.headers)
+		require.Equal(t, 1, m.errors[PhaseRequest][NoReply])
+	})
+
+	t.Run("whole", func(t *testing.T) {
+		m := newMockMetrics()
+		srv := NewServer(WithMetrics(m), WithRequestHeaderLimit(time.Second, 3))
+		serveSlowClient(t, srv, header, len(header)) // nolint: errcheck
+		require.Equal(t, 1, m.headers)
+		require.Equal(t, 1, m.reads)
+		require.Equal(t, 0, m.errors[PhaseRequest][NoReply])
+		require.Equal(t, 1, m.durations[PhaseRequest][statute.CommandConnect])
+	})
+}
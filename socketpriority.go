@@ -0,0 +1,69 @@
+package socks5
+
+import (
+	"context"
+	"net"
+	"syscall"
+)
+
+type socketPriorityKey struct{}
+
+// ContextWithSocketPriority sets the SO_PRIORITY of the outbound socket of the request,
+// such as by the RuleSet per user or destination, it overrides the WithSocketPriority option.
+func ContextWithSocketPriority(ctx context.Context, priority int) context.Context {
+	return context.WithValue(ctx, socketPriorityKey{}, priority)
+}
+
+// SocketPriorityFromContext returns the socket priority set by ContextWithSocketPriority
+func SocketPriorityFromContext(ctx context.Context) (int, bool) {
+	p, ok := ctx.Value(socketPriorityKey{}).(int)
+	return p, ok
+}
+
+// socketPriority returns the priority of the outbound socket of the request, false if not set
+func (sf *Server) socketPriority(ctx context.Context, request *Request) (int, bool) {
+	if p, ok := SocketPriorityFromContext(ctx); ok {
+		return p, true
+	}
+	if sf.socketPriorityFunc != nil {
+		return sf.socketPriorityFunc(ctx, request)
+	}
+	return 0, false
+}
+
+// prioritized returns the dialer which sets the socket priority, the control of the socket
+// is hooked for net.Dialer, so the priority applies from the first packet, the other dialers
+// get the priority set after connected. The failure to set is logged, never fails the dial.
+func (sf *Server) prioritized(d Dialer, priority int) Dialer {
+	set := func(c syscall.RawConn) {
+		if err := setSocketPriority(c, priority); err != nil {
+			sf.logger.Errorf("set socket priority %d failed, %v", priority, err)
+		}
+	}
+	if nd, ok := d.(*net.Dialer); ok {
+		pd := *nd
+		control := nd.Control
+		pd.Control = func(network, address string, c syscall.RawConn) error {
+			if control != nil {
+				if err := control(network, address, c); err != nil {
+					return err
+				}
+			}
+			set(c)
+			return nil
+		}
+		return &pd
+	}
+	return DialFunc(func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := d.DialContext(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		if sc, ok := conn.(syscall.Conn); ok {
+			if c, err := sc.SyscallConn(); err == nil {
+				set(c)
+			}
+		}
+		return conn, nil
+	})
+}
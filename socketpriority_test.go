@@ -0,0 +1,30 @@
+package socks5
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer_SocketPriority(t *testing.T) {
+	srv := NewServer()
+	_, ok := srv.socketPriority(context.Background(), &Request{})
+	require.False(t, ok)
+
+	srv = NewServer(WithSocketPriority(func(_ context.Context, request *Request) (int, bool) {
+		return 4, request.AuthContext != nil && request.AuthContext.Payload["username"] == "bulk"
+	}))
+	p, ok := srv.socketPriority(context.Background(), &Request{
+		AuthContext: &AuthContext{Payload: map[string]string{"username": "bulk"}},
+	})
+	require.True(t, ok)
+	require.Equal(t, 4, p)
+	_, ok = srv.socketPriority(context.Background(), &Request{})
+	require.False(t, ok)
+
+	// set by the rule set
+	p, ok = srv.socketPriority(ContextWithSocketPriority(context.Background(), 1), &Request{})
+	require.True(t, ok)
+	require.Equal(t, 1, p)
+}
@@ -0,0 +1,16 @@
+package socks5
+
+import (
+	"syscall"
+)
+
+// setSocketPriority sets the SO_PRIORITY of the socket, the priority over 6 requires CAP_NET_ADMIN.
+func setSocketPriority(c syscall.RawConn, priority int) error {
+	var err error
+	if e := c.Control(func(fd uintptr) {
+		err = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_PRIORITY, priority)
+	}); e != nil {
+		return e
+	}
+	return err
+}
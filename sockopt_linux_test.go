@@ -0,0 +1,35 @@
+package socks5
+
+import (
+	"context"
+	"net"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func socketPriorityOf(t *testing.T, conn net.Conn) int {
+	c, err := conn.(syscall.Conn).SyscallConn()
+	require.NoError(t, err)
+	var priority int
+	require.NoError(t, c.Control(func(fd uintptr) {
+		priority, err = syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_PRIORITY)
+	}))
+	require.NoError(t, err)
+	return priority
+}
+
+func TestServer_Prioritized(t *testing.T) {
+	target := echoTarget(t)
+	srv := NewServer()
+	for _, d := range []Dialer{
+		new(net.Dialer),
+		DialFunc(new(net.Dialer).DialContext),
+	} {
+		conn, err := srv.prioritized(d, 5).DialContext(context.Background(), "tcp", target.String())
+		require.NoError(t, err)
+		require.Equal(t, 5, socketPriorityOf(t, conn))
+		conn.Close()
+	}
+}
@@ -0,0 +1,13 @@
+// +build !linux
+
+package socks5
+
+import (
+	"errors"
+	"syscall"
+)
+
+// setSocketPriority is not supported but on linux
+func setSocketPriority(syscall.RawConn, int) error {
+	return errors.New("SO_PRIORITY not supported on this platform")
+}
@@ -0,0 +1,139 @@
+package socks5
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/thinkgos/go-socks5/statute"
+)
+
+// Protocol is the socks protocol served by the server
+type Protocol uint8
+
+// protocol defined
+const (
+	Socks4 Protocol = 1 << iota
+	Socks4a
+	Socks5
+)
+
+// serves reports whether the server serves the protocol, only SOCKS5 by default.
+func (sf *Server) serves(p Protocol) bool {
+	if sf.protocols == 0 {
+		return p == Socks5
+	}
+	return sf.protocols&p != 0
+}
+
+// sniffVersion peeks the socks version of the connection without consuming it.
+func sniffVersion(bufConn *bufio.Reader) (byte, error) {
+	b, err := bufConn.Peek(1)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get version byte, %w", err)
+	}
+	return b[0], nil
+}
+
+// readSocks4Request read the SOCKS4 or SOCKS4a request, SOCKS4 has no authentication,
+// so it is only served when the no auth method is enabled, the USERID is provided
+// with the payload "userid" of the auth context.
+func (sf *Server) readSocks4Request(writer io.Writer, reader io.Reader,
+	authMethods map[uint8]Authenticator) (*Request, *AuthContext, error) {
+	hd, err := statute.ParseSocks4Request(reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	if hd.IsSocks4a() && !sf.serves(Socks4a) {
+		sendSocks4Reply(writer, statute.Socks4Rejected) // nolint: errcheck
+		return nil, nil, fmt.Errorf("socks4a not supported, %v", statute.ErrNotSupportVersion)
+	}
+	if _, ok := authMethods[statute.MethodNoAuth]; !ok {
+		sendSocks4Reply(writer, statute.Socks4Rejected) // nolint: errcheck
+		return nil, nil, fmt.Errorf("socks4 userid %q, %v", hd.UserID, statute.ErrNoSupportedAuth)
+	}
+	if hd.Command != statute.CommandConnect && hd.Command != statute.CommandBind {
+		sendSocks4Reply(writer, statute.Socks4Rejected) // nolint: errcheck
+		return nil, nil, fmt.Errorf("unrecognized socks4 command[%d]", hd.Command)
+	}
+
+	raw := hd.Bytes()
+	hd.DstAddr.Unmap()
+	now := sf.clock.Now()
+	request := &Request{
+		Request: statute.Request{
+			Version: statute.VersionSocks4,
+			Command: hd.Command,
+			DstAddr: hd.DstAddr,
+		},
+		RawDestAddr: &hd.DstAddr,
+		Reader:      reader,
+		RawHeader:   raw,
+		Accepted:    now,
+		Received:    now,
+	}
+	authContext := &AuthContext{
+		Method:  statute.MethodNoAuth,
+		Payload: map[string]string{"userid": hd.UserID},
+	}
+	return request, authContext, nil
+}
+
+func sendSocks4Reply(w io.Writer, rep byte) error {
+	_, err := w.Write(statute.Socks4Reply{Version: statute.Socks4ReplyVersion, Response: rep}.Bytes())
+	return err
+}
+
+// socks4Writer translates the SOCKS5 replies written by the handlers to the SOCKS4 replies,
+// then writes the data as is.
+type socks4Writer struct {
+	io.Writer
+	// replies is the remaining replies to translate, two for BIND and one for CONNECT,
+	// negative after a failure reply, when the following writes such as the reply detail are discarded.
+	replies int
+}
+
+func newSocks4Writer(w io.Writer, cmd byte) *socks4Writer {
+	replies := 1
+	if cmd == statute.CommandBind {
+		replies = 2
+	}
+	return &socks4Writer{w, replies}
+}
+
+// Write implement interface io.Writer
+func (sf *socks4Writer) Write(b []byte) (int, error) {
+	if sf.replies == 0 {
+		return sf.Writer.Write(b)
+	}
+	if sf.replies < 0 {
+		return len(b), nil
+	}
+	rsp, err := statute.ParseReply(bytes.NewReader(b))
+	if err != nil {
+		return 0, err
+	}
+	rep := statute.Socks4Reply{
+		Version:  statute.Socks4ReplyVersion,
+		Response: statute.Socks4Granted,
+		BndAddr:  rsp.BndAddr,
+	}
+	sf.replies--
+	if rsp.Response != statute.RepSuccess {
+		rep.Response = statute.Socks4Rejected
+		sf.replies = -1
+	}
+	if _, err := sf.Writer.Write(rep.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// CloseWrite implement interface closeWriter
+func (sf *socks4Writer) CloseWrite() error {
+	if c, ok := sf.Writer.(closeWriter); ok {
+		return c.CloseWrite()
+	}
+	return nil
+}
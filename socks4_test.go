@@ -0,0 +1,133 @@
+package socks5
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/thinkgos/go-socks5/statute"
+)
+
+func serveSocks(t testing.TB, opts ...Option) net.Addr {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+	go NewServer(opts...).Serve(l) // nolint: errcheck
+	return l.Addr()
+}
+
+func echoTarget(t testing.TB) *net.TCPAddr {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn) // nolint: errcheck
+			}()
+		}
+	}()
+	return l.Addr().(*net.TCPAddr)
+}
+
+func socks4Connect(t *testing.T, proxy net.Addr, req statute.Socks4Request) (net.Conn, statute.Socks4Reply) {
+	conn, err := net.Dial("tcp", proxy.String())
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(time.Second)) // nolint: errcheck
+	_, err = conn.Write(req.Bytes())
+	require.NoError(t, err)
+	rep, err := statute.ParseSocks4Reply(conn)
+	require.NoError(t, err)
+	return conn, rep
+}
+
+func TestSOCKS4_Connect(t *testing.T) {
+	target := echoTarget(t)
+	proxy := serveSocks(t, WithProtocols(Socks4, Socks4a, Socks5))
+
+	for _, dst := range []statute.AddrSpec{
+		{AddrType: statute.ATYPIPv4, IP: target.IP, Port: target.Port},
+		{AddrType: statute.ATYPDomain, FQDN: "localhost", Port: target.Port},
+	} {
+		conn, rep := socks4Connect(t, proxy, statute.Socks4Request{
+			Version: statute.VersionSocks4,
+			Command: statute.CommandConnect,
+			DstAddr: dst,
+			UserID:  "foo",
+		})
+		require.Equal(t, statute.Socks4Granted, rep.Response)
+
+		_, err := conn.Write([]byte("ping"))
+		require.NoError(t, err)
+		buf := make([]byte, 4)
+		_, err = io.ReadFull(conn, buf)
+		require.NoError(t, err)
+		require.Equal(t, []byte("ping"), buf)
+	}
+
+	// SOCKS5 on the same listener
+	conn, err := net.Dial("tcp", proxy.String())
+	require.NoError(t, err)
+	defer conn.Close()
+	req := bytes.NewBuffer([]byte{statute.VersionSocks5, 1, statute.MethodNoAuth})
+	req.Write(statute.Request{
+		Version: statute.VersionSocks5,
+		Command: statute.CommandConnect,
+		DstAddr: statute.AddrSpec{AddrType: statute.ATYPIPv4, IP: target.IP, Port: target.Port},
+	}.Bytes())
+	_, err = conn.Write(req.Bytes())
+	require.NoError(t, err)
+	_, err = statute.ParseMethodReply(conn)
+	require.NoError(t, err)
+	rep, err := statute.ParseReply(conn)
+	require.NoError(t, err)
+	require.Equal(t, statute.RepSuccess, rep.Response)
+}
+
+func TestSOCKS4_Rejected(t *testing.T) {
+	target := echoTarget(t)
+	socks4a := statute.Socks4Request{
+		Version: statute.VersionSocks4,
+		Command: statute.CommandConnect,
+		DstAddr: statute.AddrSpec{AddrType: statute.ATYPDomain, FQDN: "localhost", Port: target.Port},
+	}
+
+	// socks4a not enabled
+	_, rep := socks4Connect(t, serveSocks(t, WithProtocols(Socks4)), socks4a)
+	require.Equal(t, statute.Socks4Rejected, rep.Response)
+
+	// no auth method not enabled
+	_, rep = socks4Connect(t, serveSocks(t,
+		WithProtocols(Socks4, Socks4a),
+		WithCredential(StaticCredentials{"foo": "bar"}),
+	), socks4a)
+	require.Equal(t, statute.Socks4Rejected, rep.Response)
+
+	// rule denied
+	_, rep = socks4Connect(t, serveSocks(t,
+		WithProtocols(Socks4, Socks4a),
+		WithRule(ruleFunc(func(ctx context.Context, _ *Request) (context.Context, bool) { return ctx, false })),
+	), socks4a)
+	require.Equal(t, statute.Socks4Rejected, rep.Response)
+
+	// socks4 not enabled by default
+	conn, err := net.Dial("tcp", serveSocks(t).String())
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Second)) // nolint: errcheck
+	_, err = conn.Write(socks4a.Bytes())
+	require.NoError(t, err)
+	_, err = conn.Read(make([]byte, 1))
+	require.Equal(t, io.EOF, err)
+}
@@ -36,6 +36,15 @@ func (sf AddrSpec) Address() string {
 	return fmt.Sprintf("%s:%d", sf.IP, sf.Port)
 }
 
+// Unmap converts the IPv4-mapped IPv6 address(::ffff:a.b.c.d) to IPv4 address
+func (sf *AddrSpec) Unmap() {
+	if sf.FQDN == "" && len(sf.IP) == net.IPv6len {
+		if ip4 := sf.IP.To4(); ip4 != nil {
+			sf.IP, sf.AddrType = ip4, ATYPIPv4
+		}
+	}
+}
+
 // ParseAddrSpec parse addr(host:port) to the AddrSpec address
 func ParseAddrSpec(addr string) (as AddrSpec, err error) {
 	var host, port string
@@ -94,3 +94,13 @@ func TestParseAddrSpec(t *testing.T) {
 		})
 	}
 }
+
+func TestAddrSpecUnmap(t *testing.T) {
+	addr := AddrSpec{IP: net.ParseIP("::ffff:127.0.0.1"), Port: 8080, AddrType: ATYPIPv6}
+	addr.Unmap()
+	assert.Equal(t, AddrSpec{IP: net.IPv4(127, 0, 0, 1).To4(), Port: 8080, AddrType: ATYPIPv4}, addr)
+
+	addr = AddrSpec{IP: net.IPv6loopback, Port: 8080, AddrType: ATYPIPv6}
+	addr.Unmap()
+	assert.Equal(t, AddrSpec{IP: net.IPv6loopback, Port: 8080, AddrType: ATYPIPv6}, addr)
+}
@@ -0,0 +1,70 @@
+package statute
+
+import (
+	"fmt"
+	"io"
+	"math"
+)
+
+// ReplyDetailVersion is the vendor extension version of the reply detail
+const ReplyDetailVersion = byte(0xe0)
+
+// reply detail defined
+const (
+	DetailAddrTypeNotSupported = "addr_type_not_supported"
+	DetailCommandNotSupported  = "command_not_supported"
+	DetailResolveFailed        = "resolve_failed"
+	DetailRuleDenied           = "rule_denied"
+	DetailDialFailed           = "dial_failed"
+	DetailServerFailure        = "server_failure"
+	DetailPipelinedData        = "pipelined_data"
+	DetailMemoryLimit          = "memory_limit"
+)
+
+// ReplyDetail is the vendor extension appended after a failure reply,
+// it carries a short machine-readable error detail.
+// The reply detail is formed as follows:
+// 	+-----+-----+----------+
+// 	| VER | LEN |  DETAIL  |
+// 	+-----+-----+----------+
+// 	|  1  |  1  | Variable |
+// 	+-----+-----+----------+
+type ReplyDetail struct {
+	Ver    byte
+	Detail string // 0-255 bytes
+}
+
+// NewReplyDetail new reply detail, detail longer than 255 bytes will be truncated
+func NewReplyDetail(detail string) ReplyDetail {
+	if len(detail) > math.MaxUint8 {
+		detail = detail[:math.MaxUint8]
+	}
+	return ReplyDetail{ReplyDetailVersion, detail}
+}
+
+// ParseReplyDetail parse reply detail.
+func ParseReplyDetail(r io.Reader) (rd ReplyDetail, err error) {
+	tmp := []byte{0, 0}
+	if _, err = io.ReadFull(r, tmp); err != nil {
+		return
+	}
+	rd.Ver = tmp[0]
+	if rd.Ver != ReplyDetailVersion {
+		err = fmt.Errorf("unsupported reply detail version: %v", rd.Ver)
+		return
+	}
+	detail := make([]byte, tmp[1])
+	if _, err = io.ReadFull(r, detail); err != nil {
+		return
+	}
+	rd.Detail = string(detail)
+	return rd, nil
+}
+
+// Bytes reply detail to bytes
+func (sf ReplyDetail) Bytes() []byte {
+	b := make([]byte, 0, 2+len(sf.Detail))
+	b = append(b, sf.Ver, byte(len(sf.Detail)))
+	b = append(b, sf.Detail...)
+	return b
+}
@@ -0,0 +1,27 @@
+package statute
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReplyDetail(t *testing.T) {
+	want := []byte{ReplyDetailVersion, 11, 'r', 'u', 'l', 'e', '_', 'd', 'e', 'n', 'i', 'e', 'd'}
+
+	rd := NewReplyDetail(DetailRuleDenied)
+	assert.Equal(t, want, rd.Bytes())
+
+	got, err := ParseReplyDetail(bytes.NewReader(want))
+	require.NoError(t, err)
+	assert.Equal(t, rd, got)
+
+	_, err = ParseReplyDetail(bytes.NewReader([]byte{0x01, 0}))
+	require.Error(t, err)
+
+	rd = NewReplyDetail(strings.Repeat("a", 300))
+	assert.Len(t, rd.Detail, 255)
+}
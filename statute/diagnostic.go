@@ -0,0 +1,73 @@
+package statute
+
+import (
+	"fmt"
+	"io"
+	"math"
+)
+
+// CommandDiagnostic is the vendor command of the diagnostic probe, the server replies success
+// with the observed client address as BND.ADDR and BND.PORT, followed by the Diagnostic.
+const CommandDiagnostic = byte(0xf0)
+
+// DiagnosticVersion the version of the diagnostic
+const DiagnosticVersion = byte(0x01)
+
+// Diagnostic is the vendor extension appended after the success reply of the diagnostic command,
+// it carries the version of the server, the negotiated auth method and the authenticated user.
+// The diagnostic is formed as follows:
+// 	+-----+--------+------+----------+------+----------+
+// 	| VER | METHOD | VLEN | VERSION  | ULEN |   USER   |
+// 	+-----+--------+------+----------+------+----------+
+// 	|  1  |    1   |   1  | Variable |   1  | Variable |
+// 	+-----+--------+------+----------+------+----------+
+type Diagnostic struct {
+	Ver     byte
+	Method  byte
+	Version string // 0-255 bytes
+	User    string // 0-255 bytes
+}
+
+// NewDiagnostic new diagnostic, version and user longer than 255 bytes will be truncated
+func NewDiagnostic(method byte, version, user string) Diagnostic {
+	if len(version) > math.MaxUint8 {
+		version = version[:math.MaxUint8]
+	}
+	if len(user) > math.MaxUint8 {
+		user = user[:math.MaxUint8]
+	}
+	return Diagnostic{DiagnosticVersion, method, version, user}
+}
+
+// ParseDiagnostic parse diagnostic.
+func ParseDiagnostic(r io.Reader) (d Diagnostic, err error) {
+	tmp := []byte{0, 0, 0}
+	if _, err = io.ReadFull(r, tmp); err != nil {
+		return
+	}
+	d.Ver, d.Method = tmp[0], tmp[1]
+	if d.Ver != DiagnosticVersion {
+		err = fmt.Errorf("unsupported diagnostic version: %v", d.Ver)
+		return
+	}
+	version := make([]byte, int(tmp[2])+1)
+	if _, err = io.ReadFull(r, version); err != nil {
+		return
+	}
+	d.Version = string(version[:len(version)-1])
+	user := make([]byte, version[len(version)-1])
+	if _, err = io.ReadFull(r, user); err != nil {
+		return
+	}
+	d.User = string(user)
+	return d, nil
+}
+
+// Bytes diagnostic to bytes
+func (sf Diagnostic) Bytes() []byte {
+	b := make([]byte, 0, 4+len(sf.Version)+len(sf.User))
+	b = append(b, sf.Ver, sf.Method, byte(len(sf.Version)))
+	b = append(b, sf.Version...)
+	b = append(b, byte(len(sf.User)))
+	return append(b, sf.User...)
+}
@@ -0,0 +1,30 @@
+package statute
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiagnostic(t *testing.T) {
+	want := []byte{DiagnosticVersion, MethodUserPassAuth, 3, 'v', '1', '0', 3, 'f', 'o', 'o'}
+
+	d := NewDiagnostic(MethodUserPassAuth, "v10", "foo")
+	assert.Equal(t, want, d.Bytes())
+
+	got, err := ParseDiagnostic(bytes.NewReader(want))
+	require.NoError(t, err)
+	assert.Equal(t, d, got)
+
+	_, err = ParseDiagnostic(bytes.NewReader([]byte{0x02, 0, 0, 0}))
+	require.Error(t, err)
+	_, err = ParseDiagnostic(bytes.NewReader(want[:len(want)-1]))
+	require.Error(t, err)
+
+	d = NewDiagnostic(MethodNoAuth, strings.Repeat("v", 300), strings.Repeat("u", 300))
+	assert.Len(t, d.Version, 255)
+	assert.Len(t, d.User, 255)
+}
@@ -0,0 +1,163 @@
+package statute
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// MethodForwardedIdentity is the vendor method of the private range, by which a front proxy
+// forwards the identity of the original client to the next hop.
+const MethodForwardedIdentity = byte(0x88)
+
+// ForwardedIdentityVersion the version of the forwarded identity sub-negotiation
+const ForwardedIdentityVersion = byte(0x01)
+
+// forwardedMACLen the length of the HMAC-SHA256
+const forwardedMACLen = sha256.Size
+
+// ForwardedNonceLen the length of the nonce of the forwarded identity challenge
+const ForwardedNonceLen = 16
+
+// ForwardedChallenge is the challenge the server sends after selecting the method, the NONCE is
+// random per handshake and signed by the forwarded identity, so a handshake seen is not replayed.
+// The forwarded identity challenge is formed as follows:
+// 	+-----+-------+
+// 	| VER | NONCE |
+// 	+-----+-------+
+// 	|  1  |   16  |
+// 	+-----+-------+
+type ForwardedChallenge struct {
+	Ver   byte
+	Nonce []byte
+}
+
+// NewForwardedChallenge new forwarded identity challenge of the random nonce
+func NewForwardedChallenge() (ForwardedChallenge, error) {
+	nonce := make([]byte, ForwardedNonceLen)
+	if _, err := rand.Read(nonce); err != nil {
+		return ForwardedChallenge{}, err
+	}
+	return ForwardedChallenge{ForwardedIdentityVersion, nonce}, nil
+}
+
+// Bytes to bytes
+func (sf ForwardedChallenge) Bytes() []byte {
+	return append([]byte{sf.Ver}, sf.Nonce...)
+}
+
+// ParseForwardedChallenge parse the forwarded identity challenge.
+func ParseForwardedChallenge(r io.Reader) (fc ForwardedChallenge, err error) {
+	b := make([]byte, 1+ForwardedNonceLen)
+	if _, err = io.ReadFull(r, b); err != nil {
+		return
+	}
+	if b[0] != ForwardedIdentityVersion {
+		err = fmt.Errorf("unsupported forwarded identity version: %v", b[0])
+		return
+	}
+	return ForwardedChallenge{b[0], b[1:]}, nil
+}
+
+// ForwardedIdentity is the forwarded identity request packet, the MAC is the HMAC-SHA256
+// of the preceding fields and the nonce of the challenge by the secret shared by the proxies,
+// the TIMESTAMP is the unix seconds the packet signed, the reply is the same as the UserPassReply.
+// The forwarded identity request is formed as follows:
+// 	+-----+------+----------+------+----------+-----------+-----+
+// 	| VER | ULEN |   USER   | CLEN |  CLIENT  | TIMESTAMP | MAC |
+// 	+-----+------+----------+------+----------+-----------+-----+
+// 	|  1  |   1  | Variable |   1  | Variable |     8     |  32 |
+// 	+-----+------+----------+------+----------+-----------+-----+
+type ForwardedIdentity struct {
+	Ver byte
+	// User of the original client, empty if not authenticated by a user
+	User string
+	// Client is the address of the original client, host:port
+	Client string
+	// Timestamp unix seconds
+	Timestamp int64
+	MAC       []byte
+}
+
+// NewForwardedIdentity new forwarded identity signed by the secret for the nonce of the challenge,
+// the user and client over 255 bytes are truncated.
+func NewForwardedIdentity(secret, nonce []byte, user, client string, timestamp int64) ForwardedIdentity {
+	fi := ForwardedIdentity{
+		Ver:       ForwardedIdentityVersion,
+		User:      truncateUint8(user),
+		Client:    truncateUint8(client),
+		Timestamp: timestamp,
+	}
+	fi.MAC = fi.sum(secret, nonce)
+	return fi
+}
+
+// Verify reports whether the MAC is signed by the secret for the nonce of the challenge
+func (sf ForwardedIdentity) Verify(secret, nonce []byte) bool {
+	return hmac.Equal(sf.MAC, sf.sum(secret, nonce))
+}
+
+func (sf ForwardedIdentity) sum(secret, nonce []byte) []byte {
+	h := hmac.New(sha256.New, secret)
+	h.Write(sf.header()) // nolint: errcheck
+	h.Write(nonce)       // nolint: errcheck
+	return h.Sum(nil)
+}
+
+// header returns the fields before the MAC, the user and client over 255 bytes are truncated
+func (sf ForwardedIdentity) header() []byte {
+	user, client := truncateUint8(sf.User), truncateUint8(sf.Client)
+	b := make([]byte, 0, 11+len(user)+len(client))
+	b = append(b, sf.Ver, byte(len(user)))
+	b = append(b, user...)
+	b = append(b, byte(len(client)))
+	b = append(b, client...)
+	var ts [8]byte
+	binary.BigEndian.PutUint64(ts[:], uint64(sf.Timestamp))
+	return append(b, ts[:]...)
+}
+
+// Bytes to bytes
+func (sf ForwardedIdentity) Bytes() []byte {
+	return append(sf.header(), sf.MAC...)
+}
+
+// ParseForwardedIdentity parse the forwarded identity request.
+func ParseForwardedIdentity(r io.Reader) (fi ForwardedIdentity, err error) {
+	tmp := []byte{0, 0}
+	// Get the version and username length
+	if _, err = io.ReadFull(r, tmp); err != nil {
+		return
+	}
+	fi.Ver = tmp[0]
+	if fi.Ver != ForwardedIdentityVersion {
+		err = fmt.Errorf("unsupported forwarded identity version: %v", fi.Ver)
+		return
+	}
+	user := make([]byte, int(tmp[1])+1)
+	if _, err = io.ReadFull(r, user); err != nil {
+		return
+	}
+	fi.User = string(user[:len(user)-1])
+	client := make([]byte, int(user[len(user)-1])+8+forwardedMACLen)
+	if _, err = io.ReadFull(r, client); err != nil {
+		return
+	}
+	n := len(client) - 8 - forwardedMACLen
+	fi.Client = string(client[:n])
+	fi.Timestamp = int64(binary.BigEndian.Uint64(client[n:]))
+	fi.MAC = client[n+8:]
+	return fi, nil
+}
+
+// truncateUint8 truncates s to the max length of the one byte length field
+func truncateUint8(s string) string {
+	if len(s) > math.MaxUint8 {
+		return s[:math.MaxUint8]
+	}
+	return s
+}
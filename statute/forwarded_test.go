@@ -0,0 +1,66 @@
+package statute
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestForwardedIdentity(t *testing.T) {
+	secret := []byte("secret")
+	nonce := bytes.Repeat([]byte{1}, ForwardedNonceLen)
+	fi := NewForwardedIdentity(secret, nonce, "user", "10.0.0.1:4321", 1600000000)
+	assert.True(t, fi.Verify(secret, nonce))
+	assert.False(t, fi.Verify([]byte("other"), nonce))
+	assert.False(t, fi.Verify(secret, bytes.Repeat([]byte{2}, ForwardedNonceLen)))
+
+	got, err := ParseForwardedIdentity(bytes.NewReader(fi.Bytes()))
+	require.NoError(t, err)
+	assert.Equal(t, fi, got)
+	assert.True(t, got.Verify(secret, nonce))
+
+	// tampered
+	b := fi.Bytes()
+	b[2] = 'U'
+	got, err = ParseForwardedIdentity(bytes.NewReader(b))
+	require.NoError(t, err)
+	assert.False(t, got.Verify(secret, nonce))
+
+	_, err = ParseForwardedIdentity(bytes.NewReader(b[:len(b)-1]))
+	require.Error(t, err)
+	_, err = ParseForwardedIdentity(bytes.NewReader([]byte{0x02, 0}))
+	require.Error(t, err)
+}
+
+func TestForwardedIdentity_Truncated(t *testing.T) {
+	secret := []byte("secret")
+	nonce := bytes.Repeat([]byte{1}, ForwardedNonceLen)
+	fi := NewForwardedIdentity(secret, nonce, strings.Repeat("u", 300), "10.0.0.1:4321", 1600000000)
+	assert.Len(t, fi.User, 255)
+
+	got, err := ParseForwardedIdentity(bytes.NewReader(fi.Bytes()))
+	require.NoError(t, err)
+	assert.Equal(t, fi, got)
+	assert.True(t, got.Verify(secret, nonce))
+}
+
+func TestForwardedChallenge(t *testing.T) {
+	fc, err := NewForwardedChallenge()
+	require.NoError(t, err)
+	assert.Len(t, fc.Nonce, ForwardedNonceLen)
+	got, err := ParseForwardedChallenge(bytes.NewReader(fc.Bytes()))
+	require.NoError(t, err)
+	assert.Equal(t, fc, got)
+
+	other, err := NewForwardedChallenge()
+	require.NoError(t, err)
+	assert.NotEqual(t, fc.Nonce, other.Nonce)
+
+	_, err = ParseForwardedChallenge(bytes.NewReader(fc.Bytes()[:ForwardedNonceLen]))
+	require.Error(t, err)
+	_, err = ParseForwardedChallenge(bytes.NewReader(append([]byte{0x02}, fc.Nonce...)))
+	require.Error(t, err)
+}
@@ -0,0 +1,80 @@
+package statute
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// gssapi defined, see RFC 1961
+const (
+	// GSSAPIVersion gssapi sub-negotiation version
+	GSSAPIVersion = byte(0x01)
+	// gssapi message type
+	GSSAPITypeAuth          = byte(0x01)
+	GSSAPITypeProtection    = byte(0x02)
+	GSSAPITypeEncapsulation = byte(0x03)
+	GSSAPITypeAbort         = byte(0xff)
+)
+
+// gssapi protection level defined
+const (
+	GSSAPIProtectionIntegrity       = byte(0x01)
+	GSSAPIProtectionConfidentiality = byte(0x02)
+	GSSAPIProtectionSelective       = byte(0x03)
+)
+
+// GSSAPIMessage is the gssapi sub-negotiation message
+// The gssapi message is formed as follows:
+// 	+------+------+------+.......................+
+// 	+ ver  | mtyp | len  |       token           |
+// 	+------+------+------+.......................+
+// 	+ 0x01 | 0x01 | 0x02 | up to 2^16 - 1 octets |
+// 	+------+------+------+.......................+
+// The abort message has no len and token.
+type GSSAPIMessage struct {
+	Ver   byte
+	MTyp  byte
+	Token []byte
+}
+
+// NewGSSAPIMessage new gssapi message with message type and token
+func NewGSSAPIMessage(mtyp byte, token []byte) (GSSAPIMessage, error) {
+	if len(token) > math.MaxUint16 {
+		return GSSAPIMessage{}, fmt.Errorf("gssapi token too long, %d", len(token))
+	}
+	return GSSAPIMessage{GSSAPIVersion, mtyp, token}, nil
+}
+
+// ParseGSSAPIMessage parse gssapi message.
+func ParseGSSAPIMessage(r io.Reader) (msg GSSAPIMessage, err error) {
+	tmp := []byte{0, 0}
+	if _, err = io.ReadFull(r, tmp); err != nil {
+		return
+	}
+	msg.Ver, msg.MTyp = tmp[0], tmp[1]
+	if msg.Ver != GSSAPIVersion {
+		err = fmt.Errorf("unsupported gssapi version: %v", msg.Ver)
+		return
+	}
+	if msg.MTyp == GSSAPITypeAbort {
+		return
+	}
+	if _, err = io.ReadFull(r, tmp); err != nil {
+		return
+	}
+	msg.Token = make([]byte, binary.BigEndian.Uint16(tmp))
+	_, err = io.ReadFull(r, msg.Token)
+	return
+}
+
+// Bytes to bytes
+func (sf GSSAPIMessage) Bytes() []byte {
+	if sf.MTyp == GSSAPITypeAbort {
+		return []byte{sf.Ver, sf.MTyp}
+	}
+	b := make([]byte, 0, 4+len(sf.Token))
+	b = append(b, sf.Ver, sf.MTyp, byte(len(sf.Token)>>8), byte(len(sf.Token)))
+	return append(b, sf.Token...)
+}
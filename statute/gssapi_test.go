@@ -0,0 +1,29 @@
+package statute
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGSSAPIMessage(t *testing.T) {
+	msg, err := NewGSSAPIMessage(GSSAPITypeAuth, []byte("token"))
+	require.NoError(t, err)
+	b := msg.Bytes()
+	require.Equal(t, []byte{GSSAPIVersion, GSSAPITypeAuth, 0, 5, 't', 'o', 'k', 'e', 'n'}, b)
+
+	got, err := ParseGSSAPIMessage(bytes.NewReader(b))
+	require.NoError(t, err)
+	require.Equal(t, msg, got)
+
+	abort := GSSAPIMessage{GSSAPIVersion, GSSAPITypeAbort, nil}
+	got, err = ParseGSSAPIMessage(bytes.NewReader(abort.Bytes()))
+	require.NoError(t, err)
+	require.Equal(t, GSSAPITypeAbort, got.MTyp)
+
+	_, err = ParseGSSAPIMessage(bytes.NewReader([]byte{0x02, GSSAPITypeAuth}))
+	require.Error(t, err)
+	_, err = NewGSSAPIMessage(GSSAPITypeAuth, make([]byte, 1<<16))
+	require.Error(t, err)
+}
@@ -30,7 +30,7 @@ func ParseRequest(r io.Reader) (req Request, err error) {
 	// Read the version and command
 	tmp := []byte{0, 0}
 	if _, err = io.ReadFull(r, tmp); err != nil {
-		return req, fmt.Errorf("failed to get request version and command, %v", err)
+		return req, fmt.Errorf("failed to get request version and command, %w", err)
 	}
 	req.Version, req.Command = tmp[0], tmp[1]
 	if req.Version != VersionSocks5 {
@@ -39,7 +39,7 @@ func ParseRequest(r io.Reader) (req Request, err error) {
 
 	// Read reserved and address type
 	if _, err = io.ReadFull(r, tmp); err != nil {
-		return req, fmt.Errorf("failed to get request RSV and address type, %v", err)
+		return req, fmt.Errorf("failed to get request RSV and address type, %w", err)
 	}
 	req.Reserved, req.DstAddr.AddrType = tmp[0], tmp[1]
 
@@ -47,25 +47,25 @@ func ParseRequest(r io.Reader) (req Request, err error) {
 	case ATYPIPv4:
 		addr := make([]byte, net.IPv4len+2)
 		if _, err = io.ReadFull(r, addr); err != nil {
-			return req, fmt.Errorf("failed to get request, %v", err)
+			return req, fmt.Errorf("failed to get request, %w", err)
 		}
 		req.DstAddr.IP = net.IPv4(addr[0], addr[1], addr[2], addr[3])
 		req.DstAddr.Port = int(binary.BigEndian.Uint16(addr[net.IPv4len:]))
 	case ATYPIPv6:
 		addr := make([]byte, net.IPv6len+2)
 		if _, err = io.ReadFull(r, addr); err != nil {
-			return req, fmt.Errorf("failed to get request, %v", err)
+			return req, fmt.Errorf("failed to get request, %w", err)
 		}
 		req.DstAddr.IP = addr[:net.IPv6len]
 		req.DstAddr.Port = int(binary.BigEndian.Uint16(addr[net.IPv6len:]))
 	case ATYPDomain:
 		if _, err = io.ReadFull(r, tmp[:1]); err != nil {
-			return req, fmt.Errorf("failed to get request, %v", err)
+			return req, fmt.Errorf("failed to get request, %w", err)
 		}
 		domainLen := int(tmp[0])
 		addr := make([]byte, domainLen+2)
 		if _, err = io.ReadFull(r, addr); err != nil {
-			return req, fmt.Errorf("failed to get request, %v", err)
+			return req, fmt.Errorf("failed to get request, %w", err)
 		}
 		req.DstAddr.FQDN = string(addr[:domainLen])
 		req.DstAddr.Port = int(binary.BigEndian.Uint16(addr[domainLen:]))
@@ -121,28 +121,30 @@ type Reply struct {
 
 // Bytes returns a slice of request
 func (sf Reply) Bytes() (b []byte) {
-	var addr []byte
-
 	length := 6
 	if sf.BndAddr.AddrType == ATYPIPv4 {
 		length += net.IPv4len
-		addr = sf.BndAddr.IP.To4()
 	} else if sf.BndAddr.AddrType == ATYPIPv6 {
 		length += net.IPv6len
-		addr = sf.BndAddr.IP.To16()
 	} else { // ATYPDomain
 		length += 1 + len(sf.BndAddr.FQDN)
-		addr = []byte(sf.BndAddr.FQDN)
 	}
+	return sf.AppendTo(make([]byte, 0, length))
+}
 
-	b = make([]byte, 0, length)
+// AppendTo appends the reply to b and returns the extended buffer,
+// it does not allocate if b has enough capacity.
+func (sf Reply) AppendTo(b []byte) []byte {
 	b = append(b, sf.Version, sf.Response, sf.Reserved, sf.BndAddr.AddrType)
-	if sf.BndAddr.AddrType == ATYPDomain {
+	if sf.BndAddr.AddrType == ATYPIPv4 {
+		b = append(b, sf.BndAddr.IP.To4()...)
+	} else if sf.BndAddr.AddrType == ATYPIPv6 {
+		b = append(b, sf.BndAddr.IP.To16()...)
+	} else { // ATYPDomain
 		b = append(b, byte(len(sf.BndAddr.FQDN)))
+		b = append(b, sf.BndAddr.FQDN...)
 	}
-	b = append(b, addr...)
-	b = append(b, byte(sf.BndAddr.Port>>8), byte(sf.BndAddr.Port))
-	return b
+	return append(b, byte(sf.BndAddr.Port>>8), byte(sf.BndAddr.Port))
 }
 
 // ParseReply parse to reply from io.Reader
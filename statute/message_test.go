@@ -196,6 +196,10 @@ func TestReply_Bytes(t *testing.T) {
 			if gotB := tt.reply.Bytes(); !reflect.DeepEqual(gotB, tt.wantB) {
 				t.Errorf("Bytes() = %v, want %v", gotB, tt.wantB)
 			}
+			prefix := []byte{0xff}
+			if gotB := tt.reply.AppendTo(prefix); !reflect.DeepEqual(gotB, append(prefix, tt.wantB...)) {
+				t.Errorf("AppendTo() = %v, want %v", gotB, append(prefix, tt.wantB...))
+			}
 		})
 	}
 }
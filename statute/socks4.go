@@ -0,0 +1,157 @@
+package statute
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+)
+
+// VersionSocks4 socks protocol version 4, SOCKS4a is the extension of it
+const VersionSocks4 = byte(0x04)
+
+// socks4 reply status
+const (
+	Socks4ReplyVersion   = byte(0x00)
+	Socks4Granted        = byte(0x5a)
+	Socks4Rejected       = byte(0x5b)
+	Socks4NoIdentd       = byte(0x5c)
+	Socks4IdentdMismatch = byte(0x5d)
+)
+
+// socks4MaxField is the max length of the USERID and the SOCKS4a HOST
+const socks4MaxField = 255
+
+// ErrSocks4FieldTooLong the USERID or the SOCKS4a HOST is too long
+var ErrSocks4FieldTooLong = errors.New("socks4 field too long")
+
+// Socks4Request represents the SOCKS4 and SOCKS4a request
+// The SOCKS4 request is formed as follows:
+//	+----+----+---------+--------+----------+------+
+//	| VN | CD | DSTPORT | DSTIP  |  USERID  | NULL |
+//	+----+----+---------+--------+----------+------+
+//	| 1  | 1  |    2    |   4    | Variable | X'00'|
+//	+----+----+---------+--------+----------+------+
+// The SOCKS4a request sets DSTIP to 0.0.0.x with x nonzero,
+// followed by the HOST terminated with NULL.
+type Socks4Request struct {
+	// Version of socks protocol for message
+	Version byte
+	// Socks Command "connect","bind"
+	Command byte
+	// DstAddr in socks message, ATYPDomain for SOCKS4a
+	DstAddr AddrSpec
+	// UserID in socks message
+	UserID string
+}
+
+// IsSocks4a reports whether the request is SOCKS4a with the HOST
+func (sf Socks4Request) IsSocks4a() bool {
+	return sf.DstAddr.AddrType == ATYPDomain
+}
+
+// ParseSocks4Request parse to SOCKS4 and SOCKS4a request from io.Reader
+func ParseSocks4Request(r io.Reader) (req Socks4Request, err error) {
+	tmp := make([]byte, 8)
+	if _, err = io.ReadFull(r, tmp); err != nil {
+		return req, fmt.Errorf("failed to get socks4 request, %w", err)
+	}
+	req.Version, req.Command = tmp[0], tmp[1]
+	if req.Version != VersionSocks4 {
+		return req, fmt.Errorf("unrecognized SOCKS version[%d]", req.Version)
+	}
+	req.DstAddr.Port = int(binary.BigEndian.Uint16(tmp[2:]))
+	req.DstAddr.AddrType = ATYPIPv4
+	req.DstAddr.IP = net.IPv4(tmp[4], tmp[5], tmp[6], tmp[7])
+
+	if req.UserID, err = readNullString(r); err != nil {
+		return req, fmt.Errorf("failed to get socks4 userid, %w", err)
+	}
+	if tmp[4] == 0 && tmp[5] == 0 && tmp[6] == 0 && tmp[7] != 0 {
+		if req.DstAddr.FQDN, err = readNullString(r); err != nil {
+			return req, fmt.Errorf("failed to get socks4a host, %w", err)
+		}
+		req.DstAddr.AddrType = ATYPDomain
+		req.DstAddr.IP = nil
+	}
+	return req, nil
+}
+
+// readNullString read the string terminated with NULL, byte by byte,
+// the reader should be buffered.
+func readNullString(r io.Reader) (string, error) {
+	var b []byte
+	one := []byte{0}
+	for {
+		if _, err := io.ReadFull(r, one); err != nil {
+			return "", err
+		}
+		if one[0] == 0 {
+			return string(b), nil
+		}
+		if len(b) == socks4MaxField {
+			return "", ErrSocks4FieldTooLong
+		}
+		b = append(b, one[0])
+	}
+}
+
+// Bytes returns a slice of request
+func (sf Socks4Request) Bytes() []byte {
+	b := make([]byte, 0, 8+len(sf.UserID)+1+len(sf.DstAddr.FQDN)+1)
+	b = append(b, sf.Version, sf.Command, byte(sf.DstAddr.Port>>8), byte(sf.DstAddr.Port))
+	if sf.IsSocks4a() {
+		b = append(b, 0, 0, 0, 1)
+	} else if ip := sf.DstAddr.IP.To4(); ip != nil {
+		b = append(b, ip...)
+	} else {
+		b = append(b, 0, 0, 0, 0)
+	}
+	b = append(b, sf.UserID...)
+	b = append(b, 0)
+	if sf.IsSocks4a() {
+		b = append(b, sf.DstAddr.FQDN...)
+		b = append(b, 0)
+	}
+	return b
+}
+
+// Socks4Reply represents the SOCKS4 reply
+// The SOCKS4 reply is formed as follows:
+//	+----+----+---------+-------+
+//	| VN | CD | DSTPORT | DSTIP |
+//	+----+----+---------+-------+
+//	| 1  | 1  |    2    |   4   |
+//	+----+----+---------+-------+
+type Socks4Reply struct {
+	// Version of the reply, always 0
+	Version byte
+	// Socks4 Response status
+	Response byte
+	// Bind Address in socks message, only IPv4
+	BndAddr AddrSpec
+}
+
+// ParseSocks4Reply parse to SOCKS4 reply from io.Reader
+func ParseSocks4Reply(r io.Reader) (rep Socks4Reply, err error) {
+	tmp := make([]byte, 8)
+	if _, err = io.ReadFull(r, tmp); err != nil {
+		return rep, fmt.Errorf("failed to get socks4 reply, %v", err)
+	}
+	rep.Version, rep.Response = tmp[0], tmp[1]
+	rep.BndAddr.AddrType = ATYPIPv4
+	rep.BndAddr.Port = int(binary.BigEndian.Uint16(tmp[2:]))
+	rep.BndAddr.IP = net.IPv4(tmp[4], tmp[5], tmp[6], tmp[7])
+	return rep, nil
+}
+
+// Bytes returns a slice of reply
+func (sf Socks4Reply) Bytes() []byte {
+	b := make([]byte, 0, 8)
+	b = append(b, sf.Version, sf.Response, byte(sf.BndAddr.Port>>8), byte(sf.BndAddr.Port))
+	if ip := sf.BndAddr.IP.To4(); ip != nil {
+		return append(b, ip...)
+	}
+	return append(b, 0, 0, 0, 0)
+}
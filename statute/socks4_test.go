@@ -0,0 +1,83 @@
+package statute
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSocks4Request(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     []byte
+		want    Socks4Request
+		wantErr bool
+	}{
+		{
+			"SOCKS4",
+			[]byte{VersionSocks4, CommandConnect, 0x1f, 0x90, 127, 0, 0, 1, 'f', 'o', 'o', 0},
+			Socks4Request{
+				VersionSocks4, CommandConnect,
+				AddrSpec{IP: net.IPv4(127, 0, 0, 1), Port: 8080, AddrType: ATYPIPv4}, "foo",
+			},
+			false,
+		},
+		{
+			"SOCKS4a",
+			[]byte{
+				VersionSocks4, CommandBind, 0x1f, 0x90, 0, 0, 0, 1, 0,
+				'l', 'o', 'c', 'a', 'l', 'h', 'o', 's', 't', 0,
+			},
+			Socks4Request{
+				VersionSocks4, CommandBind,
+				AddrSpec{FQDN: "localhost", Port: 8080, AddrType: ATYPDomain}, "",
+			},
+			false,
+		},
+		{
+			"invalid version",
+			[]byte{VersionSocks5, CommandConnect, 0x1f, 0x90, 127, 0, 0, 1, 0},
+			Socks4Request{Version: VersionSocks5, Command: CommandConnect},
+			true,
+		},
+		{
+			"no null",
+			[]byte{VersionSocks4, CommandConnect, 0x1f, 0x90, 127, 0, 0, 1, 'f', 'o', 'o'},
+			Socks4Request{},
+			true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseSocks4Request(bytes.NewReader(tt.raw))
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+			require.Equal(t, tt.raw, got.Bytes())
+		})
+	}
+
+	raw := []byte{VersionSocks4, CommandConnect, 0x1f, 0x90, 127, 0, 0, 1}
+	raw = append(raw, strings.Repeat("a", socks4MaxField+1)...)
+	_, err := ParseSocks4Request(bytes.NewReader(append(raw, 0)))
+	require.Error(t, err)
+}
+
+func TestSocks4Reply(t *testing.T) {
+	rep := Socks4Reply{
+		Socks4ReplyVersion, Socks4Granted,
+		AddrSpec{IP: net.IPv4(127, 0, 0, 1), Port: 8080, AddrType: ATYPIPv4},
+	}
+	raw := []byte{Socks4ReplyVersion, Socks4Granted, 0x1f, 0x90, 127, 0, 0, 1}
+	require.Equal(t, raw, rep.Bytes())
+
+	got, err := ParseSocks4Reply(bytes.NewReader(raw))
+	require.NoError(t, err)
+	require.Equal(t, rep, got)
+}
@@ -2,6 +2,7 @@ package statute
 
 import (
 	"errors"
+	"strconv"
 )
 
 // VersionSocks5 socks protocol version
@@ -17,7 +18,7 @@ const (
 // method defined
 const (
 	MethodNoAuth       = byte(0x00)
-	MethodGSSAPI       = byte(0x01) // TODO: not support now
+	MethodGSSAPI       = byte(0x01)
 	MethodUserPassAuth = byte(0x02)
 	MethodNoAcceptable = byte(0xff)
 )
@@ -43,6 +44,27 @@ const (
 	// 0x09 - 0xff unassigned
 )
 
+// repText is the text of the reply status defined by RFC 1928
+var repText = map[uint8]string{
+	RepSuccess:              "succeeded",
+	RepServerFailure:        "general SOCKS server failure",
+	RepRuleFailure:          "connection not allowed by ruleset",
+	RepNetworkUnreachable:   "network unreachable",
+	RepHostUnreachable:      "host unreachable",
+	RepConnectionRefused:    "connection refused",
+	RepTTLExpired:           "TTL expired",
+	RepCommandNotSupported:  "command not supported",
+	RepAddrTypeNotSupported: "address type not supported",
+}
+
+// RepText returns the text of the reply status, such as "connection refused"
+func RepText(rep uint8) string {
+	if text, ok := repText[rep]; ok {
+		return text
+	}
+	return "unassigned reply " + strconv.Itoa(int(rep))
+}
+
 // auth defined
 const (
 	// user password version
@@ -52,6 +74,13 @@ const (
 	AuthFailure = byte(0x01)
 )
 
+// vendor extension flags of the RSV of the request and the reply
+const (
+	// FlagCompress the client requests the DEFLATE compression of the CONNECT payload,
+	// the server sets it in the success reply if accepted.
+	FlagCompress = byte(0x01)
+)
+
 // error defined
 var (
 	ErrUnrecognizedAddrType = errors.New("unrecognized address type")
@@ -0,0 +1,13 @@
+package statute
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRepText(t *testing.T) {
+	assert.Equal(t, "connection refused", RepText(RepConnectionRefused))
+	assert.Equal(t, "connection not allowed by ruleset", RepText(RepRuleFailure))
+	assert.Equal(t, "unassigned reply 9", RepText(9))
+}
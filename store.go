@@ -0,0 +1,64 @@
+package socks5
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Store is the state shared by the servers, such as the counters of the ConnectionBudget,
+// a Store backed by Redis or a database shares the state across the instances.
+type Store interface {
+	// Incr increments the counter of the key by one and returns the count,
+	// the counter expires after the ttl since it is created.
+	Incr(ctx context.Context, key string, ttl time.Duration) (int64, error)
+}
+
+// memoryStoreSweep is the number of the increments between the sweeps of the expired counters
+const memoryStoreSweep = 1024
+
+// MemoryStore is the in-process Store, the state is not shared across the instances.
+// The zero value is ready to use.
+type MemoryStore struct {
+	// Clock of the counters, set before use, nil means the system clock.
+	Clock Clock
+
+	mu       sync.Mutex
+	counters map[string]*memoryCounter
+	incrs    int
+}
+
+type memoryCounter struct {
+	count   int64
+	expires time.Time
+}
+
+// NewMemoryStore new a in-process Store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{counters: make(map[string]*memoryCounter)}
+}
+
+// Incr implement interface Store
+func (sf *MemoryStore) Incr(_ context.Context, key string, ttl time.Duration) (int64, error) {
+	now := clockOrSystem(sf.Clock).Now()
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	if sf.counters == nil {
+		sf.counters = make(map[string]*memoryCounter)
+	}
+	if sf.incrs++; sf.incrs >= memoryStoreSweep {
+		sf.incrs = 0
+		for k, c := range sf.counters {
+			if !now.Before(c.expires) {
+				delete(sf.counters, k)
+			}
+		}
+	}
+	c, ok := sf.counters[key]
+	if !ok || !now.Before(c.expires) {
+		c = &memoryCounter{expires: now.Add(ttl)}
+		sf.counters[key] = c
+	}
+	c.count++
+	return c.count, nil
+}
@@ -2,6 +2,7 @@ package testing
 
 import (
 	"io"
+	"io/ioutil"
 	"log"
 	"net"
 	"os"
@@ -15,6 +16,7 @@ import (
 	"github.com/thinkgos/go-socks5"
 	"github.com/thinkgos/go-socks5/bufferpool"
 	"github.com/thinkgos/go-socks5/ccsocks5"
+	"github.com/thinkgos/go-socks5/statute"
 )
 
 func Test_Socks5_Connect(t *testing.T) {
@@ -124,3 +126,62 @@ func Test_socks5_Associate(t *testing.T) {
 	require.Equal(t, []byte("pong"), out)
 	time.Sleep(time.Second * 1)
 }
+
+func Test_Socks5_ReplyDetail(t *testing.T) {
+	_, trusted, err := net.ParseCIDR("127.0.0.0/8")
+	require.NoError(t, err)
+
+	srv := socks5.NewServer(
+		socks5.WithRule(socks5.NewPermitNone()),
+		socks5.WithReplyDetail([]*net.IPNet{trusted}),
+	)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go srv.Serve(l) // nolint: errcheck
+
+	client := ccsocks5.NewClient(l.Addr().String(), ccsocks5.WithReplyDetail())
+	_, err = client.Dial("tcp", "127.0.0.1:80")
+	require.Error(t, err)
+
+	rErr, ok := err.(*ccsocks5.ReplyError)
+	require.True(t, ok)
+	assert.Equal(t, statute.RepRuleFailure, rErr.Rep)
+	assert.Equal(t, statute.DetailRuleDenied, rErr.Detail)
+}
+
+func Test_Socks5_Bind(t *testing.T) {
+	cator := socks5.UserPassAuthenticator{Credentials: socks5.StaticCredentials{"user": "pass"}}
+	srv := socks5.NewServer(socks5.WithAuthMethods([]socks5.Authenticator{cator}))
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	go srv.Serve(l) // nolint: errcheck
+
+	client := ccsocks5.NewClient(l.Addr().String(),
+		ccsocks5.WithAuthMethods(ccsocks5.NoAuth{}, ccsocks5.UserPassAuth{User: "user", Password: "pass"}),
+	)
+	bind, err := client.Bind("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer bind.Close()
+
+	// the remote peer connects to the bind address
+	go func() {
+		peer, err := net.Dial("tcp", bind.BindAddr().String())
+		if err != nil {
+			return
+		}
+		defer peer.Close()
+		peer.Write([]byte("ping"))    // nolint: errcheck
+		io.Copy(ioutil.Discard, peer) // nolint: errcheck
+	}()
+
+	conn, err := bind.Accept()
+	require.NoError(t, err)
+	defer conn.Close()
+
+	out := make([]byte, 4)
+	conn.SetDeadline(time.Now().Add(time.Second)) // nolint: errcheck
+	_, err = io.ReadFull(conn, out)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("ping"), out)
+}
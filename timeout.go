@@ -0,0 +1,83 @@
+package socks5
+
+import (
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// handshakeTimeoutOf returns the handshake timeout of the listener or the server's
+func (sf *Server) handshakeTimeoutOf(lc *listenerConfig) time.Duration {
+	if lc != nil && lc.handshakeTimeout != nil {
+		return *lc.handshakeTimeout
+	}
+	return sf.handshakeTimeout
+}
+
+// idleTimeoutOf returns the idle timeout of the listener or the server's
+func (sf *Server) idleTimeoutOf(lc *listenerConfig) time.Duration {
+	if lc != nil && lc.idleTimeout != nil {
+		return *lc.idleTimeout
+	}
+	return sf.idleTimeout
+}
+
+// beginHandshake sets the deadline of the method negotiation, the auth and the request parsing
+// if configured, it returns the deadline on the system time, zero if not configured.
+func (sf *Server) beginHandshake(conn net.Conn, lc *listenerConfig) time.Time {
+	timeout := sf.handshakeTimeoutOf(lc)
+	if timeout <= 0 {
+		return time.Time{}
+	}
+	deadline := time.Now().Add(timeout)
+	conn.SetDeadline(deadline) // nolint: errcheck
+	return deadline
+}
+
+// endHandshake clears the deadline of the handshake if set
+func endHandshake(conn net.Conn, deadline time.Time) {
+	if !deadline.IsZero() {
+		conn.SetDeadline(time.Time{}) // nolint: errcheck
+	}
+}
+
+// idleCheckIntervalMin is the min interval to check the idle sessions
+const idleCheckIntervalMin = 10 * time.Millisecond
+
+// watchIdle closes the session which relays no byte in either direction for the idle timeout,
+// with CloseReasonIdleTimeout, the time before relaying is not counted. The returned func
+// stops the watch.
+func (sf *Server) watchIdle(sess *session, conn net.Conn, timeout time.Duration) (stop func()) {
+	if timeout <= 0 {
+		return func() {}
+	}
+	interval := timeout / 4
+	if interval < idleCheckIntervalMin {
+		interval = idleCheckIntervalMin
+	}
+	done := make(chan struct{})
+	ticker := sf.clock.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		active, transferred := sf.clock.Now(), uint64(0)
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C():
+			}
+			now := sf.clock.Now()
+			n := atomic.LoadUint64(&sess.bytesUp) + atomic.LoadUint64(&sess.bytesDown)
+			if SessionState(atomic.LoadUint32(&sess.state)) != SessionRelaying || n != transferred {
+				active, transferred = now, n
+				continue
+			}
+			if now.Sub(active) >= timeout {
+				sess.setCloseReason(CloseReasonIdleTimeout)
+				conn.Close()
+				return
+			}
+		}
+	}()
+	return func() { close(done) }
+}
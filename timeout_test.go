@@ -0,0 +1,156 @@
+package socks5
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/thinkgos/go-socks5/statute"
+)
+
+func TestServer_HandshakeTimeout(t *testing.T) {
+	proxy := serveSocks(t, WithHandshakeTimeout(50*time.Millisecond))
+
+	// silent after connected
+	conn, err := net.Dial("tcp", proxy.String())
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(time.Second)) // nolint: errcheck
+	_, err = conn.Read(make([]byte, 1))
+	assert.Equal(t, io.EOF, err)
+
+	// silent after the method negotiated
+	conn, err = net.Dial("tcp", proxy.String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte{statute.VersionSocks5, 1, statute.MethodNoAuth})
+	require.NoError(t, err)
+	conn.SetReadDeadline(time.Now().Add(time.Second)) // nolint: errcheck
+	_, err = statute.ParseMethodReply(conn)
+	require.NoError(t, err)
+	_, err = conn.Read(make([]byte, 1))
+	assert.Equal(t, io.EOF, err)
+
+	// the deadline cleared once the request parsed
+	target := echoTarget(t)
+	conn = relaySession(t, proxy, target)
+	defer conn.Close()
+	time.Sleep(100 * time.Millisecond)
+	_, err = conn.Write([]byte("pong"))
+	require.NoError(t, err)
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(conn, buf)
+	require.NoError(t, err)
+	assert.Equal(t, "pong", string(buf))
+}
+
+func TestServer_DialTimeout(t *testing.T) {
+	proxy := serveSocks(t, WithDialTimeout(50*time.Millisecond),
+		WithDial(func(ctx context.Context, network, addr string) (net.Conn, error) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}))
+	conn, err := net.Dial("tcp", proxy.String())
+	require.NoError(t, err)
+	defer conn.Close()
+	req := bytes.NewBuffer([]byte{statute.VersionSocks5, 1, statute.MethodNoAuth})
+	req.Write(statute.Request{
+		Version: statute.VersionSocks5,
+		Command: statute.CommandConnect,
+		DstAddr: statute.AddrSpec{AddrType: statute.ATYPIPv4, IP: net.IPv4(10, 0, 0, 1), Port: 80},
+	}.Bytes())
+	_, err = conn.Write(req.Bytes())
+	require.NoError(t, err)
+	_, err = statute.ParseMethodReply(conn)
+	require.NoError(t, err)
+	rep, err := statute.ParseReply(conn)
+	require.NoError(t, err)
+	assert.Equal(t, statute.RepTTLExpired, rep.Response)
+}
+
+func TestServer_IdleTimeout(t *testing.T) {
+	target := echoTarget(t)
+	clock := newManualClock()
+	closed := make(chan Session, 1)
+	srv := NewServer(
+		WithClock(clock),
+		WithIdleTimeout(2*time.Second),
+		WithSessionCloseHandle(func(s Session, err error) { closed <- s }),
+	)
+	proxy, _ := startServer(t, srv)
+	defer srv.Close()
+
+	conn := relaySession(t, proxy, target)
+	defer conn.Close()
+	var s Session
+	require.Eventually(t, func() bool {
+		clock.Advance(time.Second)
+		select {
+		case s = <-closed:
+			return true
+		default:
+			return false
+		}
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, CloseReasonIdleTimeout, s.CloseReason)
+	conn.SetReadDeadline(time.Now().Add(time.Second)) // nolint: errcheck
+	_, err := conn.Read(make([]byte, 1))
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestServer_IdleTimeoutShort(t *testing.T) {
+	target := echoTarget(t)
+	clock := newManualClock()
+	closed := make(chan Session, 1)
+	srv := NewServer(
+		WithClock(clock),
+		WithIdleTimeout(400*time.Millisecond),
+		WithSessionCloseHandle(func(s Session, err error) { closed <- s }),
+	)
+	proxy, _ := startServer(t, srv)
+	defer srv.Close()
+
+	conn := relaySession(t, proxy, target)
+	defer conn.Close()
+	// closed within a quarter of the timeout after it is idle for the timeout
+	start := clock.Now()
+	var s Session
+	require.Eventually(t, func() bool {
+		select {
+		case s = <-closed:
+			return true
+		default:
+			clock.Advance(100 * time.Millisecond)
+			return false
+		}
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, CloseReasonIdleTimeout, s.CloseReason)
+	assert.True(t, clock.Now().Sub(start) <= 600*time.Millisecond, clock.Now().Sub(start))
+}
+
+func TestServer_IdleTimeoutActive(t *testing.T) {
+	target := echoTarget(t)
+	clock := newManualClock()
+	srv := NewServer(WithClock(clock), WithIdleTimeout(2*time.Second))
+	proxy, _ := startServer(t, srv)
+	defer srv.Close()
+
+	conn := relaySession(t, proxy, target)
+	defer conn.Close()
+	buf := make([]byte, 4)
+	// the transfer keeps the session alive
+	for i := 0; i < 5; i++ {
+		clock.Advance(time.Second)
+		_, err := conn.Write([]byte("ping"))
+		require.NoError(t, err)
+		_, err = io.ReadFull(conn, buf)
+		require.NoError(t, err)
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,93 @@
+package socks5
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"net"
+
+	"github.com/thinkgos/go-socks5/statute"
+)
+
+// ListenAndServeTLS is the same as ListenAndServe, but serves SOCKS over TLS (socks5s)
+// with the certificate and the private key files, which could be empty if the certificates
+// are provided by the WithTLSConfig option. The client certificate authentication is
+// configured by the ClientAuth and ClientCAs of the tls config, see ClientCertAuthenticator.
+func (sf *Server) ListenAndServeTLS(network, addr, certFile, keyFile string) error {
+	cfg := &tls.Config{}
+	if sf.tlsConfig != nil {
+		cfg = sf.tlsConfig.Clone()
+	}
+	if certFile != "" || keyFile != "" || (len(cfg.Certificates) == 0 && cfg.GetCertificate == nil) {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return err
+		}
+		cfg.Certificates = append([]tls.Certificate{cert}, cfg.Certificates...)
+	}
+	l, err := net.Listen(network, addr)
+	if err != nil {
+		return err
+	}
+	return sf.Serve(l, WithListenerTLS(cfg))
+}
+
+// tlsConfigOf returns the tls config of the listener, or of the server, nil if TLS is not served
+func (sf *Server) tlsConfigOf(lc *listenerConfig) *tls.Config {
+	if lc != nil && lc.tls != nil {
+		return lc.tls
+	}
+	return sf.tlsConfig
+}
+
+// errNoClientCert is the client certificate required but not presented or verified
+var errNoClientCert = errors.New("no verified client certificate")
+
+// ClientCertAuthenticator authenticates the client by the verified TLS client certificate,
+// negotiated as the no auth method, with the identity of the certificate as the payload
+// "username" of the auth context, and the subject as the payload "cert_subject".
+// The server must verify the client certificates, such as tls.RequireAndVerifyClientCert.
+type ClientCertAuthenticator struct {
+	// Identity returns the username of the verified client certificate,
+	// defaults to the common name of the subject.
+	Identity func(cert *x509.Certificate) (string, error)
+	// Optional accepts the client without a verified certificate anonymously,
+	// such as with tls.VerifyClientCertIfGiven.
+	Optional bool
+}
+
+// GetCode implement interface Authenticator
+func (a ClientCertAuthenticator) GetCode() uint8 { return statute.MethodNoAuth }
+
+// Authenticate implement interface Authenticator, the connection not over TLS has no certificate
+func (a ClientCertAuthenticator) Authenticate(_ io.Reader, writer io.Writer, userAddr string) (*AuthContext, error) {
+	return a.AuthenticateTLS(nil, writer, userAddr, &tls.ConnectionState{})
+}
+
+// AuthenticateTLS implement interface TLSAuthenticator
+func (a ClientCertAuthenticator) AuthenticateTLS(_ io.Reader, writer io.Writer, _ string,
+	state *tls.ConnectionState) (*AuthContext, error) {
+	ac := &AuthContext{Method: statute.MethodNoAuth, Payload: make(map[string]string)}
+	if len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		if a.Optional {
+			_, err := writer.Write([]byte{statute.VersionSocks5, statute.MethodNoAuth})
+			return ac, err
+		}
+		writer.Write([]byte{statute.VersionSocks5, statute.MethodNoAcceptable}) // nolint: errcheck
+		return nil, &AuthError{Err: errNoClientCert}
+	}
+	cert := state.VerifiedChains[0][0]
+	user := cert.Subject.CommonName
+	if a.Identity != nil {
+		var err error
+		if user, err = a.Identity(cert); err != nil {
+			writer.Write([]byte{statute.VersionSocks5, statute.MethodNoAcceptable}) // nolint: errcheck
+			return nil, &AuthError{User: cert.Subject.String(), Err: err}
+		}
+	}
+	ac.Payload["username"] = user
+	ac.Payload["cert_subject"] = cert.Subject.String()
+	_, err := writer.Write([]byte{statute.VersionSocks5, statute.MethodNoAuth})
+	return ac, err
+}
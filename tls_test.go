@@ -0,0 +1,116 @@
+package socks5
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/thinkgos/go-socks5/statute"
+)
+
+// writeKeyPair writes the certificate and the key in PEM to the dir
+func writeKeyPair(t *testing.T, dir string, cert tls.Certificate) (certFile, keyFile string) {
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	der, err := x509.MarshalECPrivateKey(cert.PrivateKey.(*ecdsa.PrivateKey))
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(certFile,
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}), 0600))
+	require.NoError(t, ioutil.WriteFile(keyFile,
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600))
+	return certFile, keyFile
+}
+
+func TestServer_ListenAndServeTLS(t *testing.T) {
+	ca := newTestCA(t)
+	dir, err := ioutil.TempDir("", "socks5s")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	certFile, keyFile := writeKeyPair(t, dir, ca.issue(t, "server", x509.ExtKeyUsageServerAuth))
+
+	users := make(chan string, 1)
+	srv := NewServer(
+		WithTLSConfig(&tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: ca.pool}),
+		WithAuthMethods([]Authenticator{ClientCertAuthenticator{}}),
+		WithRule(ruleFunc(func(ctx context.Context, req *Request) (context.Context, bool) {
+			users <- req.AuthContext.Payload["username"]
+			return ctx, false
+		})),
+	)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := l.Addr().String()
+	l.Close()
+	go srv.ListenAndServeTLS("tcp", addr, certFile, keyFile) // nolint: errcheck
+	defer srv.Close()
+
+	var conn *tls.Conn
+	require.Eventually(t, func() bool {
+		conn, err = tls.Dial("tcp", addr, &tls.Config{
+			RootCAs:      ca.pool,
+			Certificates: []tls.Certificate{ca.issue(t, "alice", x509.ExtKeyUsageClientAuth)},
+		})
+		return err == nil
+	}, time.Second, 10*time.Millisecond)
+	defer conn.Close()
+	_, err = conn.Write([]byte{
+		statute.VersionSocks5, 1, statute.MethodNoAuth,
+		statute.VersionSocks5, statute.CommandConnect, 0, statute.ATYPIPv4, 127, 0, 0, 1, 0, 80,
+	})
+	require.NoError(t, err)
+	_, err = io.ReadFull(conn, make([]byte, 2))
+	require.NoError(t, err)
+	rep, err := statute.ParseReply(conn)
+	require.NoError(t, err)
+	require.Equal(t, statute.RepRuleFailure, rep.Response)
+	require.Equal(t, "alice", <-users)
+
+	err = NewServer().ListenAndServeTLS("tcp", "127.0.0.1:0", filepath.Join(dir, "none"), keyFile)
+	require.Error(t, err)
+}
+
+func TestClientCertAuthenticator(t *testing.T) {
+	ca := newTestCA(t)
+	cert, err := x509.ParseCertificate(ca.issue(t, "bob", x509.ExtKeyUsageClientAuth).Certificate[0])
+	require.NoError(t, err)
+	state := &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert, ca.cert}}}
+
+	var w bytesWriter
+	ac, err := ClientCertAuthenticator{}.AuthenticateTLS(nil, &w, "", state)
+	require.NoError(t, err)
+	require.Equal(t, "bob", ac.Payload["username"])
+	require.Equal(t, "CN=bob", ac.Payload["cert_subject"])
+	require.Equal(t, []byte{statute.VersionSocks5, statute.MethodNoAuth}, w.b)
+
+	ac, err = ClientCertAuthenticator{Identity: func(c *x509.Certificate) (string, error) {
+		return "user-" + c.Subject.CommonName, nil
+	}}.AuthenticateTLS(nil, &w, "", state)
+	require.NoError(t, err)
+	require.Equal(t, "user-bob", ac.Payload["username"])
+
+	// no certificate
+	w.b = nil
+	_, err = ClientCertAuthenticator{}.Authenticate(nil, &w, "")
+	require.Error(t, err)
+	require.Equal(t, []byte{statute.VersionSocks5, statute.MethodNoAcceptable}, w.b)
+	ac, err = ClientCertAuthenticator{Optional: true}.AuthenticateTLS(nil, &w, "", &tls.ConnectionState{})
+	require.NoError(t, err)
+	require.Empty(t, ac.Payload["username"])
+}
+
+type bytesWriter struct{ b []byte }
+
+func (w *bytesWriter) Write(p []byte) (int, error) {
+	w.b = append(w.b, p...)
+	return len(p), nil
+}
@@ -0,0 +1,173 @@
+package socks5
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha1" // nolint: gosec
+	"crypto/subtle"
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// TOTPSecrets provides the TOTP secret of the user
+type TOTPSecrets interface {
+	TOTPSecret(user string) (secret []byte, ok bool)
+}
+
+// StaticTOTPSecrets enables using a map directly as the TOTP secrets
+type StaticTOTPSecrets map[string][]byte
+
+// TOTPSecret implement interface TOTPSecrets
+func (s StaticTOTPSecrets) TOTPSecret(user string) ([]byte, bool) {
+	secret, ok := s[user]
+	return secret, ok
+}
+
+// TOTP defaults
+const (
+	defaultTOTPDigits = 6
+	defaultTOTPPeriod = 30 * time.Second
+)
+
+// TOTPCredentials is a CredentialStore which requires a TOTP second factor (RFC 6238),
+// the password field must contain the password followed by the TOTP code.
+// The user without a TOTP secret is refused. The last time step accepted is recorded per user,
+// the code of an earlier step is refused, and the code of the step is refused once its
+// ReuseWindow passed, so a code seen is not replayed in its skew window as RFC 6238 section 5.2
+// recommends. It must not be copied after first use.
+type TOTPCredentials struct {
+	// Credentials validate the password part
+	Credentials CredentialStore
+	// Secrets of the users, defaults to the Credentials if it implements TOTPSecrets
+	Secrets TOTPSecrets
+	// Digits of the code, defaults to 6
+	Digits int
+	// Period of the time step, defaults to 30s
+	Period time.Duration
+	// Skew is the number of adjacent time steps accepted for clock drift
+	Skew int
+	// ReuseWindow is how long after its first use the code authenticates more connections
+	// of the user, such as a browser or CONNECT and ASSOCIATE opened together,
+	// defaults to 5s, negative means the code is used once.
+	ReuseWindow time.Duration
+	// Clock of the time steps, nil means the system clock
+	Clock Clock
+
+	mu sync.Mutex
+	// last time step accepted per user
+	last map[string]totpAccepted
+}
+
+// totpAccepted is the time step accepted and when first accepted
+type totpAccepted struct {
+	step int64
+	at   time.Time
+}
+
+// defaultTOTPReuseWindow is the default ReuseWindow of TOTPCredentials
+const defaultTOTPReuseWindow = 5 * time.Second
+
+// Valid implement interface CredentialStore
+func (sf *TOTPCredentials) Valid(user, password, userAddr string) bool {
+	return sf.ValidContext(context.Background(), user, password, userAddr)
+}
+
+// ValidContext implement interface ContextCredentialStore, the context is passed to the Credentials
+func (sf *TOTPCredentials) ValidContext(ctx context.Context, user, password, userAddr string) bool {
+	digits, period := sf.params()
+	if len(password) < digits {
+		return false
+	}
+	secrets := sf.Secrets
+	if secrets == nil {
+		secrets, _ = sf.Credentials.(TOTPSecrets)
+	}
+	if secrets == nil {
+		return false
+	}
+	secret, ok := secrets.TOTPSecret(user)
+	if !ok {
+		return false
+	}
+	pass, code := password[:len(password)-digits], password[len(password)-digits:]
+
+	t := clockOrSystem(sf.Clock).Now()
+	matched, step := false, int64(0)
+	for i := -sf.Skew; i <= sf.Skew; i++ {
+		at := t.Add(time.Duration(i) * period)
+		expect := TOTPCode(secret, at, digits, period)
+		if subtle.ConstantTimeCompare([]byte(expect), []byte(code)) == 1 {
+			matched, step = true, totpStep(at, period)
+		}
+	}
+	if !matched || !validCredentials(ctx, sf.Credentials, user, pass, userAddr) {
+		return false
+	}
+	return sf.accept(user, step, t)
+}
+
+// accept records the time step accepted of the user, false if a later step accepted,
+// or the step accepted out of the reuse window
+func (sf *TOTPCredentials) accept(user string, step int64, now time.Time) bool {
+	window := sf.ReuseWindow
+	if window == 0 {
+		window = defaultTOTPReuseWindow
+	}
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	if last, ok := sf.last[user]; ok {
+		if step < last.step {
+			return false
+		}
+		if step == last.step {
+			return window > 0 && now.Sub(last.at) < window
+		}
+	}
+	if sf.last == nil {
+		sf.last = make(map[string]totpAccepted)
+	}
+	sf.last[user] = totpAccepted{step, now}
+	return true
+}
+
+func (sf *TOTPCredentials) params() (int, time.Duration) {
+	return totpParams(sf.Digits, sf.Period)
+}
+
+// totpParams returns the digits and the period, the defaults if not positive
+func totpParams(digits int, period time.Duration) (int, time.Duration) {
+	if digits <= 0 {
+		digits = defaultTOTPDigits
+	}
+	if period <= 0 {
+		period = defaultTOTPPeriod
+	}
+	return digits, period
+}
+
+// totpStep returns the time step of the time
+func totpStep(t time.Time, period time.Duration) int64 {
+	return t.UnixNano() / int64(period)
+}
+
+// TOTPCode returns the TOTP code of the secret at the time, with HMAC-SHA1 per RFC 6238,
+// the digits defaults to 6 and the period to 30s if not positive, the code over 10 digits is
+// padded by the leading zeros.
+func TOTPCode(secret []byte, t time.Time, digits int, period time.Duration) string {
+	digits, period = totpParams(digits, period)
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(totpStep(t, period)))
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(counter[:]) // nolint: errcheck
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:]) & 0x7fffffff
+	// the value is under 10^10
+	mod := uint64(1)
+	for i := 0; i < digits && i < 10; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", digits, uint64(value)%mod)
+}
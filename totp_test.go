@@ -0,0 +1,74 @@
+package socks5
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTOTPCode(t *testing.T) {
+	// test vectors of RFC 6238 with SHA1
+	secret := []byte("12345678901234567890")
+	require.Equal(t, "94287082", TOTPCode(secret, time.Unix(59, 0), 8, 30*time.Second))
+	require.Equal(t, "07081804", TOTPCode(secret, time.Unix(1111111109, 0), 8, 30*time.Second))
+	require.Equal(t, "287082", TOTPCode(secret, time.Unix(59, 0), 6, 30*time.Second))
+	require.Equal(t, "1094287082", TOTPCode(secret, time.Unix(59, 0), 10, 30*time.Second))
+	require.Equal(t, "001094287082", TOTPCode(secret, time.Unix(59, 0), 12, 30*time.Second))
+	require.Equal(t, "287082", TOTPCode(secret, time.Unix(59, 0), 0, 0))
+	// the sub-second period
+	require.Len(t, TOTPCode(secret, time.Unix(59, 0), 6, 500*time.Millisecond), 6)
+}
+
+func TestTOTPCredentials(t *testing.T) {
+	secret := []byte("12345678901234567890")
+	// 15s into the time step
+	clock := &manualClock{now: time.Unix(1111111095, 0)}
+	creds := &TOTPCredentials{
+		Credentials: StaticCredentials{"foo": "bar", "nosecret": "bar"},
+		Secrets:     StaticTOTPSecrets{"foo": secret},
+		Skew:        1,
+		Clock:       clock,
+	}
+	now := clock.Now()
+	code := TOTPCode(secret, now, 6, 30*time.Second)
+	prev := TOTPCode(secret, now.Add(-30*time.Second), 6, 30*time.Second)
+	old := TOTPCode(secret, now.Add(-90*time.Second), 6, 30*time.Second)
+
+	require.True(t, creds.Valid("foo", "bar"+prev, ""))
+	require.True(t, creds.Valid("foo", "bar"+code, ""))
+	// more connections of the user in the reuse window
+	clock.Advance(2 * time.Second)
+	require.True(t, creds.Valid("foo", "bar"+code, ""))
+	require.True(t, creds.Valid("foo", "bar"+code, ""))
+	// the codes of the earlier steps are not replayed
+	require.False(t, creds.Valid("foo", "bar"+prev, ""))
+	require.False(t, creds.Valid("foo", "bar"+old, ""))
+	require.False(t, creds.Valid("foo", "baz"+code, ""))
+	require.False(t, creds.Valid("foo", "bar", ""))
+	require.False(t, creds.Valid("nosecret", "bar"+code, ""))
+	// nor the code of the step out of the reuse window
+	clock.Advance(defaultTOTPReuseWindow)
+	require.False(t, creds.Valid("foo", "bar"+code, ""))
+
+	// the next step is accepted
+	clock.Advance(30 * time.Second)
+	next := TOTPCode(secret, clock.Now(), 6, 30*time.Second)
+	require.False(t, creds.Valid("foo", "baz"+next, ""))
+	require.True(t, creds.Valid("foo", "bar"+next, ""))
+	require.True(t, creds.Valid("foo", "bar"+next, ""))
+}
+
+func TestTOTPCredentials_SingleUse(t *testing.T) {
+	secret := []byte("12345678901234567890")
+	clock := newManualClock()
+	creds := &TOTPCredentials{
+		Credentials: StaticCredentials{"foo": "bar"},
+		Secrets:     StaticTOTPSecrets{"foo": secret},
+		ReuseWindow: -1,
+		Clock:       clock,
+	}
+	code := TOTPCode(secret, clock.Now(), 6, 30*time.Second)
+	require.True(t, creds.Valid("foo", "bar"+code, ""))
+	require.False(t, creds.Valid("foo", "bar"+code, ""))
+}
@@ -0,0 +1,129 @@
+package socks5
+
+import (
+	"encoding/hex"
+	"io"
+	"sync/atomic"
+)
+
+// traceReader dumps the bytes read from client for debugging,
+// the messages are dumped on flush, the relayed data are dumped
+// immediately up to the limit.
+type traceReader struct {
+	r       io.Reader
+	logger  Logger
+	id      uint64
+	pending []byte
+	redact  bool
+	relay   bool
+	remain  int
+}
+
+func newTraceReader(r io.Reader, logger Logger, id uint64) *traceReader {
+	return &traceReader{r: r, logger: logger, id: id}
+}
+
+// Read implement interface io.Reader
+func (sf *traceReader) Read(p []byte) (int, error) {
+	n, err := sf.r.Read(p)
+	if n > 0 {
+		if sf.relay {
+			sf.remain = dumpRelay(sf.logger, sf.id, "recv relay", p[:n], sf.remain)
+		} else {
+			sf.pending = append(sf.pending, p[:n]...)
+		}
+	}
+	return n, err
+}
+
+// Buffered implement interface bufferedReader, 0 if the underlying reader does not report it
+func (sf *traceReader) Buffered() int {
+	if br, ok := sf.r.(bufferedReader); ok {
+		return br.Buffered()
+	}
+	return 0
+}
+
+// setRedact redacts the bytes read until flush, such as credentials
+func (sf *traceReader) setRedact() {
+	if sf != nil {
+		sf.redact = true
+	}
+}
+
+// flush dumps the bytes read since last flush
+func (sf *traceReader) flush(msg string) {
+	if sf == nil || len(sf.pending) == 0 {
+		return
+	}
+	if sf.redact {
+		sf.logger.Errorf("trace[%d] recv %s: <redacted %d bytes>", sf.id, msg, len(sf.pending))
+	} else {
+		sf.logger.Errorf("trace[%d] recv %s:\n%s", sf.id, msg, hex.Dump(sf.pending))
+	}
+	sf.pending, sf.redact = sf.pending[:0], false
+}
+
+// startRelay dumps the first limit bytes relayed
+func (sf *traceReader) startRelay(limit int) {
+	if sf != nil {
+		sf.relay, sf.remain = true, limit
+	}
+}
+
+// traceWriter dumps the bytes written to client for debugging,
+// each message is dumped when written, the relayed data are dumped
+// up to the limit.
+type traceWriter struct {
+	w      io.Writer
+	logger Logger
+	id     uint64
+	// relay start after the number of messages written
+	relayAfter int32
+	remain     int
+}
+
+func newTraceWriter(w io.Writer, logger Logger, id uint64) *traceWriter {
+	return &traceWriter{w: w, logger: logger, id: id, relayAfter: -1}
+}
+
+// Write implement interface io.Writer
+func (sf *traceWriter) Write(p []byte) (int, error) {
+	if after := atomic.LoadInt32(&sf.relayAfter); after != 0 {
+		if after > 0 {
+			atomic.AddInt32(&sf.relayAfter, -1)
+		}
+		sf.logger.Errorf("trace[%d] send:\n%s", sf.id, hex.Dump(p))
+	} else {
+		sf.remain = dumpRelay(sf.logger, sf.id, "send relay", p, sf.remain)
+	}
+	return sf.w.Write(p)
+}
+
+// CloseWrite implement interface closeWriter
+func (sf *traceWriter) CloseWrite() error {
+	if c, ok := sf.w.(closeWriter); ok {
+		return c.CloseWrite()
+	}
+	return nil
+}
+
+// startRelay dumps the first limit bytes relayed, after the reply is written
+func (sf *traceWriter) startRelay(limit int) {
+	if sf != nil {
+		sf.remain = limit
+		atomic.StoreInt32(&sf.relayAfter, 1)
+	}
+}
+
+// dumpRelay dumps the first remain bytes of b, returns the remain bytes to dump
+func dumpRelay(logger Logger, id uint64, msg string, b []byte, remain int) int {
+	if remain <= 0 {
+		return 0
+	}
+	if len(b) > remain {
+		b = b[:remain]
+	}
+	logger.Errorf("trace[%d] %s:\n%s", id, msg, hex.Dump(b))
+	return remain - len(b)
+}
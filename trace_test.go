@@ -0,0 +1,76 @@
+package socks5
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/proxy"
+)
+
+type bufferLogger struct {
+	mu sync.Mutex
+	sb strings.Builder
+}
+
+func (l *bufferLogger) Errorf(format string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	fmt.Fprintf(&l.sb, format+"\n", args...)
+}
+
+func (l *bufferLogger) String() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.sb.String()
+}
+
+func TestServer_Trace(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.Copy(conn, conn) // nolint: errcheck
+	}()
+
+	logger := new(bufferLogger)
+	srv := NewServer(
+		WithCredential(StaticCredentials{"foo": "secret"}),
+		WithLogger(logger),
+		WithTrace(2),
+	)
+	sl, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go srv.Serve(sl) // nolint: errcheck
+
+	dial, err := proxy.SOCKS5("tcp", sl.Addr().String(), &proxy.Auth{User: "foo", Password: "secret"}, proxy.Direct)
+	require.NoError(t, err)
+	conn, err := dial.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	conn.Write([]byte("ping")) // nolint: errcheck
+	out := make([]byte, 4)
+	conn.SetDeadline(time.Now().Add(time.Second)) // nolint: errcheck
+	_, err = io.ReadFull(conn, out)
+	require.NoError(t, err)
+
+	s := logger.String()
+	require.Contains(t, s, "recv method request")
+	require.Contains(t, s, "recv auth: <redacted")
+	require.Contains(t, s, "recv request")
+	require.Contains(t, s, "recv relay")
+	require.Contains(t, s, "|pi|")
+	require.NotContains(t, s, "secret")
+	require.NotContains(t, s, "|ping|")
+}
@@ -0,0 +1,137 @@
+package socks5
+
+import (
+	"context"
+	"io"
+	"sync/atomic"
+
+	"github.com/thinkgos/go-socks5/statute"
+)
+
+// tracerName is the instrumentation name of the tracer
+const tracerName = "github.com/thinkgos/go-socks5"
+
+// span names
+const (
+	// SpanSession covers a tcp session, from the accept to the close
+	SpanSession = "socks5.session"
+	// SpanNegotiation covers the method negotiation and the authentication
+	SpanNegotiation = "socks5.negotiation"
+	// SpanResolve covers the resolution of the FQDN destination
+	SpanResolve = "socks5.resolve"
+	// SpanDial covers the outbound dial of the CONNECT
+	SpanDial = "socks5.dial"
+	// SpanRelay covers the relay of the CONNECT
+	SpanRelay = "socks5.relay"
+)
+
+// span attribute keys
+const (
+	AttrClient     = "socks5.client"
+	AttrUser       = "socks5.user"
+	AttrCommand    = "socks5.command"
+	AttrDest       = "socks5.dest"
+	AttrResolvedIP = "socks5.resolved_ip"
+	AttrRemote     = "socks5.remote"
+	AttrReply      = "socks5.reply"
+	AttrBytesUp    = "socks5.bytes_up"
+	AttrBytesDown  = "socks5.bytes_down"
+)
+
+// Attribute is a key-value of the span
+type Attribute struct {
+	Key   string
+	Value interface{}
+}
+
+// TracerProvider provides the tracer of the server, it mirrors the TracerProvider of
+// OpenTelemetry, the adapter of the OpenTelemetry SDK is a few lines, such as:
+//
+//	func (p otelProvider) Tracer(name string) socks5.Tracer { return otelTracer{p.tp.Tracer(name)} }
+//
+//	func (t otelTracer) Start(ctx context.Context, name string, attrs ...socks5.Attribute) (
+//		context.Context, socks5.Span) {
+//		ctx, span := t.Tracer.Start(ctx, name)
+//		s := otelSpan{span}
+//		s.SetAttributes(attrs...)
+//		return ctx, s
+//	}
+//
+// and the otelSpan converts the Attribute to attribute.KeyValue by attribute.String or attribute.Int64.
+type TracerProvider interface {
+	Tracer(name string) Tracer
+}
+
+// Tracer starts the spans
+type Tracer interface {
+	// Start starts a span as the child of the span in the ctx if any,
+	// and returns the ctx carrying the span.
+	Start(ctx context.Context, name string, attrs ...Attribute) (context.Context, Span)
+}
+
+// Span is a phase of the request
+type Span interface {
+	SetAttributes(attrs ...Attribute)
+	// RecordError records the error and marks the span failed
+	RecordError(err error)
+	End()
+}
+
+// noopSpan is the span if the tracing is not enabled
+type noopSpan struct{}
+
+func (noopSpan) SetAttributes(...Attribute) {}
+func (noopSpan) RecordError(error)          {}
+func (noopSpan) End()                       {}
+
+// startSpan starts the span if the tracing is enabled
+func (sf *Server) startSpan(ctx context.Context, name string, attrs ...Attribute) (context.Context, Span) {
+	if sf.tracer == nil {
+		return ctx, noopSpan{}
+	}
+	return sf.tracer.Start(ctx, name, attrs...)
+}
+
+// endSpan records the error if any and ends the span
+func endSpan(span Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+	}
+	span.End()
+}
+
+// requestAttributes returns the attributes of the request
+func requestAttributes(request *Request) []Attribute {
+	attrs := []Attribute{
+		{AttrCommand, int64(request.Command)},
+		{AttrDest, request.RawDestAddr.String()},
+	}
+	if user := usernameOf(request); user != "" {
+		attrs = append(attrs, Attribute{AttrUser, user})
+	}
+	return attrs
+}
+
+// replySpanWriter records the reply code of the request to the span by the first write,
+// which is always the reply, the later writes pass through.
+type replySpanWriter struct {
+	io.Writer
+	span     Span
+	recorded int32
+}
+
+// Write implement interface io.Writer
+func (sf *replySpanWriter) Write(p []byte) (int, error) {
+	if len(p) > 1 && p[0] == statute.VersionSocks5 && atomic.CompareAndSwapInt32(&sf.recorded, 0, 1) {
+		sf.span.SetAttributes(Attribute{AttrReply, int64(p[1])})
+	}
+	return sf.Writer.Write(p)
+}
+
+// CloseWrite implement interface closeWriter
+func (sf *replySpanWriter) CloseWrite() error {
+	if c, ok := sf.Writer.(closeWriter); ok {
+		return c.CloseWrite()
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package socks5
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/proxy"
+
+	"github.com/thinkgos/go-socks5/statute"
+)
+
+type recordSpan struct {
+	name  string
+	attrs map[string]interface{}
+	err   error
+	ended bool
+}
+
+// recordTracer records the spans ended
+type recordTracer struct {
+	mu    sync.Mutex
+	spans []*recordSpan
+}
+
+func (sf *recordTracer) Tracer(string) Tracer { return sf }
+
+func (sf *recordTracer) Start(ctx context.Context, name string, attrs ...Attribute) (context.Context, Span) {
+	s := &recordSpan{name: name, attrs: make(map[string]interface{})}
+	span := &tracedSpan{sf, s}
+	span.SetAttributes(attrs...)
+	return ctx, span
+}
+
+func (sf *recordTracer) ended(name string) *recordSpan {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	for _, s := range sf.spans {
+		if s.name == name {
+			return s
+		}
+	}
+	return nil
+}
+
+type tracedSpan struct {
+	t *recordTracer
+	s *recordSpan
+}
+
+func (sf *tracedSpan) SetAttributes(attrs ...Attribute) {
+	for _, a := range attrs {
+		sf.s.attrs[a.Key] = a.Value
+	}
+}
+func (sf *tracedSpan) RecordError(err error) { sf.s.err = err }
+func (sf *tracedSpan) End() {
+	sf.t.mu.Lock()
+	defer sf.t.mu.Unlock()
+	sf.s.ended = true
+	sf.t.spans = append(sf.t.spans, sf.s)
+}
+
+func TestWithTracerProvider_Nil(t *testing.T) {
+	require.Nil(t, NewServer(WithTracerProvider(nil)).tracer)
+	require.Nil(t, NewServer(WithTracerProvider(&recordTracer{}), WithTracerProvider(nil)).tracer)
+}
+
+func TestServer_Tracing(t *testing.T) {
+	target := echoTarget(t)
+	tracer := &recordTracer{}
+	proxyAddr := serveSocks(t, WithTracerProvider(tracer))
+	conn := relaySession(t, proxyAddr, target)
+	conn.Close()
+
+	require.Eventually(t, func() bool { return tracer.ended(SpanSession) != nil }, time.Second, 10*time.Millisecond)
+	session := tracer.ended(SpanSession)
+	require.Equal(t, int64(statute.RepSuccess), session.attrs[AttrReply])
+	require.Equal(t, int64(statute.CommandConnect), session.attrs[AttrCommand])
+	require.Equal(t, target.String(), session.attrs[AttrDest])
+	require.NotNil(t, tracer.ended(SpanNegotiation))
+	require.Equal(t, target.String(), tracer.ended(SpanDial).attrs[AttrRemote])
+	relay := tracer.ended(SpanRelay)
+	require.Equal(t, int64(4), relay.attrs[AttrBytesUp])
+	require.Equal(t, int64(4), relay.attrs[AttrBytesDown])
+
+	// the resolution and the dial failure
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+	tracer = &recordTracer{}
+	d, err := proxy.SOCKS5("tcp", serveSocks(t, WithTracerProvider(tracer)).String(), nil, proxy.Direct)
+	require.NoError(t, err)
+	_, err = d.Dial("tcp", net.JoinHostPort("localhost", strconv.Itoa(port)))
+	require.Error(t, err)
+	require.Eventually(t, func() bool { return tracer.ended(SpanSession) != nil }, time.Second, 10*time.Millisecond)
+	require.NotEmpty(t, tracer.ended(SpanResolve).attrs[AttrResolvedIP])
+	dial := tracer.ended(SpanDial)
+	require.Error(t, dial.err)
+	require.Equal(t, int64(statute.RepConnectionRefused), dial.attrs[AttrReply])
+	require.Equal(t, int64(statute.RepConnectionRefused), tracer.ended(SpanSession).attrs[AttrReply])
+	require.Error(t, tracer.ended(SpanSession).err)
+}
@@ -0,0 +1,89 @@
+package socks5
+
+import (
+	"net"
+	"time"
+)
+
+// SessionInfo is the traffic accounting of a session
+type SessionInfo struct {
+	// ID of the session, unique in the server
+	ID uint64
+	// ClientAddr of the the network that sent the request
+	ClientAddr net.Addr
+	// LocalAddr of the the network server listen
+	LocalAddr net.Addr
+	// Command of the request
+	Command byte
+	// DestAddr of the target, the requested destination on the session start,
+	// after resolve, rewrite and override on the session end
+	DestAddr string
+	// AuthContext provided during negotiation
+	AuthContext *AuthContext
+	// Started time of the session
+	Started time.Time
+	// Duration of the session, 0 on the session start
+	Duration time.Duration
+	// BytesUp from client to target
+	BytesUp uint64
+	// BytesDown from target to client
+	BytesDown uint64
+	// CloseReason of the session, CloseReasonNone on the session start
+	CloseReason CloseReason
+}
+
+// TrafficMeter is notified of the start and the end of the sessions which request accepted,
+// such as to bill the users and export the usage.
+// OnSessionEnd is called only if OnSessionStart called.
+type TrafficMeter interface {
+	OnSessionStart(info SessionInfo)
+	OnSessionEnd(info SessionInfo)
+}
+
+func newSessionInfo(sess *session, request *Request) SessionInfo {
+	s := sess.snapshot()
+	info := SessionInfo{
+		ID:          s.ID,
+		ClientAddr:  s.ClientAddr,
+		LocalAddr:   s.LocalAddr,
+		Command:     request.Command,
+		AuthContext: request.AuthContext,
+		Started:     s.Started,
+		BytesUp:     s.BytesUp,
+		BytesDown:   s.BytesDown,
+		CloseReason: s.CloseReason,
+	}
+	if request.DestAddr != nil {
+		info.DestAddr = request.DestAddr.String()
+	} else if request.RawDestAddr != nil {
+		info.DestAddr = request.RawDestAddr.String()
+	}
+	return info
+}
+
+// meterStart notifies the traffic meter of the session start
+func (sf *Server) meterStart(sess *session, request *Request) {
+	if sf.trafficMeter == nil {
+		return
+	}
+	sess.mu.Lock()
+	sess.metered = request
+	sess.mu.Unlock()
+	sf.trafficMeter.OnSessionStart(newSessionInfo(sess, request))
+}
+
+// meterEnd notifies the traffic meter of the session end, if started
+func (sf *Server) meterEnd(sess *session) {
+	if sf.trafficMeter == nil {
+		return
+	}
+	sess.mu.Lock()
+	request := sess.metered
+	sess.mu.Unlock()
+	if request == nil {
+		return
+	}
+	info := newSessionInfo(sess, request)
+	info.Duration = sf.since(info.Started)
+	sf.trafficMeter.OnSessionEnd(info)
+}
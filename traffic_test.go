@@ -0,0 +1,46 @@
+package socks5
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/thinkgos/go-socks5/statute"
+)
+
+type mockTrafficMeter struct {
+	start chan SessionInfo
+	end   chan SessionInfo
+}
+
+func (m *mockTrafficMeter) OnSessionStart(info SessionInfo) { m.start <- info }
+
+func (m *mockTrafficMeter) OnSessionEnd(info SessionInfo) { m.end <- info }
+
+func TestServer_TrafficMeter(t *testing.T) {
+	target := echoTarget(t)
+	meter := &mockTrafficMeter{make(chan SessionInfo, 1), make(chan SessionInfo, 1)}
+	srv := NewServer(WithTrafficMeter(meter))
+	proxy, _ := startServer(t, srv)
+	defer srv.Close()
+
+	conn := relaySession(t, proxy, target)
+	start := <-meter.start
+	require.Equal(t, statute.CommandConnect, start.Command)
+	require.Equal(t, target.String(), start.DestAddr)
+	require.Equal(t, statute.MethodNoAuth, start.AuthContext.Method)
+	require.Equal(t, CloseReasonNone, start.CloseReason)
+
+	conn.Close()
+	select {
+	case end := <-meter.end:
+		require.Equal(t, start.ID, end.ID)
+		require.Equal(t, uint64(4), end.BytesUp)
+		require.Equal(t, uint64(4), end.BytesDown)
+		require.Equal(t, CloseReasonClientEOF, end.CloseReason)
+		require.True(t, end.Duration > 0)
+	case <-time.After(time.Second):
+		t.Fatal("session end not notified")
+	}
+}
@@ -0,0 +1,115 @@
+package socks5
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/thinkgos/go-socks5/statute"
+)
+
+// TransparentMode is how the original destination of a connection redirected to a transparent
+// listener is recovered, see WithListenerTransparent.
+type TransparentMode uint8
+
+// transparent mode defined
+const (
+	// TransparentNone the listener serves the SOCKS handshake
+	TransparentNone TransparentMode = iota
+	// TransparentRedirect the connections redirected by the iptables REDIRECT or DNAT,
+	// the original destination is read by SO_ORIGINAL_DST, linux only.
+	TransparentRedirect
+	// TransparentTProxy the connections intercepted by the iptables TPROXY, the original
+	// destination is the local address, the listener socket must set IP_TRANSPARENT.
+	TransparentTProxy
+)
+
+// String implement interface fmt.Stringer
+func (m TransparentMode) String() string {
+	switch m {
+	case TransparentNone:
+		return "none"
+	case TransparentRedirect:
+		return "redirect"
+	case TransparentTProxy:
+		return "tproxy"
+	default:
+		return "TransparentMode(" + strconv.Itoa(int(m)) + ")"
+	}
+}
+
+// errNotRedirected is the connection to the transparent listener not redirected, relaying it
+// would connect the listener itself.
+var errNotRedirected = errors.New("connection not redirected")
+
+// transparentMode returns the transparent mode of the listener, nil is TransparentNone
+func (sf *listenerConfig) transparentMode() TransparentMode {
+	if sf == nil {
+		return TransparentNone
+	}
+	return sf.transparent
+}
+
+// transparentRequest returns the CONNECT request to the original destination of the connection
+// redirected to the listener of the address, the client is not authenticated.
+func transparentRequest(conn net.Conn, listenAddr net.Addr, mode TransparentMode, reader io.Reader) (*Request, error) {
+	var dst *net.TCPAddr
+	switch mode {
+	case TransparentRedirect:
+		tc, ok := conn.(*net.TCPConn)
+		if !ok {
+			return nil, fmt.Errorf("transparent redirect of %T not supported", conn)
+		}
+		var err error
+		if dst, err = originalDst(tc); err != nil {
+			return nil, fmt.Errorf("failed to get original destination, %v", err)
+		}
+		if local, ok := conn.LocalAddr().(*net.TCPAddr); ok && local.IP.Equal(dst.IP) && local.Port == dst.Port {
+			return nil, errNotRedirected
+		}
+	case TransparentTProxy:
+		var ok bool
+		if dst, ok = conn.LocalAddr().(*net.TCPAddr); !ok {
+			return nil, fmt.Errorf("transparent tproxy of %T not supported", conn)
+		}
+		if l, ok := listenAddr.(*net.TCPAddr); ok && l.Port == dst.Port &&
+			(l.IP == nil || l.IP.IsUnspecified() || l.IP.Equal(dst.IP)) {
+			return nil, errNotRedirected
+		}
+	default:
+		return nil, fmt.Errorf("unsupported transparent mode %v", mode)
+	}
+
+	addr := statute.AddrSpec{IP: dst.IP, Port: dst.Port, AddrType: statute.ATYPIPv6}
+	if ip4 := dst.IP.To4(); ip4 != nil {
+		addr.IP, addr.AddrType = ip4, statute.ATYPIPv4
+	}
+	now := time.Now()
+	return &Request{
+		Request:     statute.Request{Version: statute.VersionSocks5, Command: statute.CommandConnect, DstAddr: addr},
+		RawDestAddr: &addr,
+		Reader:      reader,
+		Accepted:    now,
+		Received:    now,
+	}, nil
+}
+
+// discardReplies is the ReplyWriter of the transparent connections, the client is not aware of
+// the proxy, the replies are discarded by the replyBuffer until the relay starts.
+type discardReplies struct {
+	io.Writer
+}
+
+// Flush implement interface ReplyWriter
+func (discardReplies) Flush() error { return nil }
+
+// CloseWrite implement interface closeWriter
+func (sf discardReplies) CloseWrite() error {
+	if c, ok := sf.Writer.(closeWriter); ok {
+		return c.CloseWrite()
+	}
+	return nil
+}
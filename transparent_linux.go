@@ -0,0 +1,43 @@
+package socks5
+
+import (
+	"net"
+	"syscall"
+	"unsafe"
+)
+
+// soOriginalDst is SO_ORIGINAL_DST of netfilter, the same value of IP6T_SO_ORIGINAL_DST
+const soOriginalDst = 80
+
+// originalDst returns the original destination of the connection redirected by netfilter
+func originalDst(conn *net.TCPConn) (*net.TCPAddr, error) {
+	rc, err := conn.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	local, _ := conn.LocalAddr().(*net.TCPAddr)
+	var dst *net.TCPAddr
+	if e := rc.Control(func(fd uintptr) {
+		if local != nil && local.IP.To4() == nil {
+			// the sockaddr_in6 fits the IPv6MTUInfo
+			var info *syscall.IPv6MTUInfo
+			if info, err = syscall.GetsockoptIPv6MTUInfo(int(fd), syscall.SOL_IPV6, soOriginalDst); err == nil {
+				port := (*[2]byte)(unsafe.Pointer(&info.Addr.Port))
+				dst = &net.TCPAddr{
+					IP:   append(net.IP(nil), info.Addr.Addr[:]...),
+					Port: int(port[0])<<8 | int(port[1]),
+				}
+			}
+			return
+		}
+		// the sockaddr_in fits the IPv6Mreq
+		var mreq *syscall.IPv6Mreq
+		if mreq, err = syscall.GetsockoptIPv6Mreq(int(fd), syscall.SOL_IP, soOriginalDst); err == nil {
+			sa := mreq.Multiaddr
+			dst = &net.TCPAddr{IP: net.IPv4(sa[4], sa[5], sa[6], sa[7]), Port: int(sa[2])<<8 | int(sa[3])}
+		}
+	}); e != nil {
+		return nil, e
+	}
+	return dst, err
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package socks5
+
+import (
+	"errors"
+	"net"
+)
+
+// originalDst is not supported but on linux
+func originalDst(*net.TCPConn) (*net.TCPAddr, error) {
+	return nil, errors.New("SO_ORIGINAL_DST not supported on this platform")
+}
@@ -0,0 +1,68 @@
+package socks5
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// interceptedConn is the connection intercepted by TPROXY, the local address is the original destination
+type interceptedConn struct {
+	net.Conn
+	local net.Addr
+}
+
+func (sf interceptedConn) LocalAddr() net.Addr { return sf.local }
+
+func TestServer_TransparentTProxy(t *testing.T) {
+	target := echoTarget(t)
+	srv := NewServer()
+	client, server := net.Pipe()
+	defer client.Close()
+	lc := newListenerConfig(WithListenerTransparent(TransparentTProxy))
+	lc.addr = &net.TCPAddr{IP: net.IPv4zero, Port: 1080}
+	done := make(chan error, 1)
+	go func() {
+		done <- srv.serveConn(context.Background(), interceptedConn{server, target}, lc, "")
+	}()
+
+	// no handshake and no reply, the data is relayed to the original destination
+	client.SetDeadline(time.Now().Add(time.Second)) // nolint: errcheck
+	_, err := client.Write([]byte("ping"))
+	require.NoError(t, err)
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(client, buf)
+	require.NoError(t, err)
+	require.Equal(t, "ping", string(buf))
+	client.Close()
+	require.NoError(t, <-done)
+}
+
+func TestServer_TransparentNotRedirected(t *testing.T) {
+	for _, mode := range []TransparentMode{TransparentRedirect, TransparentTProxy} {
+		t.Run(mode.String(), func(t *testing.T) {
+			srv := NewServer()
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			require.NoError(t, err)
+			go srv.Serve(l, WithListenerTransparent(mode)) // nolint: errcheck
+			defer srv.Close()
+
+			// the connection to the listener itself is closed, never relayed to itself
+			conn, err := net.Dial("tcp", l.Addr().String())
+			require.NoError(t, err)
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(time.Second)) // nolint: errcheck
+			_, err = conn.Read(make([]byte, 1))
+			require.Equal(t, io.EOF, err)
+		})
+	}
+}
+
+func TestTransparentMode_String(t *testing.T) {
+	require.Equal(t, "redirect", TransparentRedirect.String())
+	require.Equal(t, "TransparentMode(9)", TransparentMode(9).String())
+}
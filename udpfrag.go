@@ -0,0 +1,114 @@
+package socks5
+
+import (
+	"net"
+	"time"
+
+	"github.com/thinkgos/go-socks5/statute"
+)
+
+// UDPFragmentPolicy is the policy of the fragmented datagram from the client, whose FRAG is not 0
+type UDPFragmentPolicy uint8
+
+// udp fragment policy defined
+const (
+	// UDPFragmentDrop drops the fragments, as the RFC 1928 allows for the server not supporting fragmentation
+	UDPFragmentDrop UDPFragmentPolicy = iota
+	// UDPFragmentReassemble reassembles the fragments into the datagram sent to the target
+	UDPFragmentReassemble
+)
+
+// String implement interface fmt.Stringer
+func (p UDPFragmentPolicy) String() string {
+	switch p {
+	case UDPFragmentDrop:
+		return "drop"
+	case UDPFragmentReassemble:
+		return "reassemble"
+	}
+	return "unknown"
+}
+
+// reassembly defaults
+const (
+	// defaultReassemblyTimeout is the least reassembly timer of the RFC 1928
+	defaultReassemblyTimeout = 5 * time.Second
+	// maxReassembledSize is the max size of the reassembled data, which must fit an udp datagram
+	maxReassembledSize = 65507
+)
+
+// fragQueue is the reassembly queue of an association, per RFC 1928 the fragments come in
+// order of FRAG position from 1, the high-order bit marks the end fragment. The queue is
+// dropped if a fragment is out of order, for another destination, or the reassembly
+// timer expired, as a datagram missing a fragment can not be reassembled.
+type fragQueue struct {
+	srv     *Server
+	mem     *memoryBudget
+	timeout time.Duration
+	dst     statute.AddrSpec
+	data    []byte
+	// last is the position of the last fragment queued, 0 means empty
+	last    byte
+	started time.Time
+}
+
+func newFragQueue(srv *Server, mem *memoryBudget) *fragQueue {
+	timeout := srv.udpReassemblyTimeout
+	if timeout <= 0 {
+		timeout = defaultReassemblyTimeout
+	}
+	return &fragQueue{srv: srv, mem: mem, timeout: timeout}
+}
+
+// push the fragment, returns the reassembled datagram once the end fragment comes.
+// The returned data is valid until the next push.
+func (sf *fragQueue) push(pk statute.Datagram) (statute.Datagram, bool) {
+	if sf.srv.udpFragmentPolicy != UDPFragmentReassemble {
+		sf.srv.addDroppedFragments(1)
+		return pk, false
+	}
+	pos, end := pk.Frag&maxFragments, pk.Frag&^maxFragments != 0
+	if sf.last != 0 &&
+		(pos != sf.last+1 || sf.srv.since(sf.started) > sf.timeout || !sameAddr(pk.DstAddr, sf.dst)) {
+		sf.reset()
+	}
+	if pos != sf.last+1 || len(sf.data)+len(pk.Data) > maxReassembledSize ||
+		!sf.mem.acquire(int64(len(pk.Data))) {
+		// a fragment after the ones dropped, or beyond the limits
+		sf.reset()
+		sf.srv.addDroppedFragments(1)
+		return pk, false
+	}
+	if sf.last == 0 {
+		// the ip of the datagram parsed points into the read buffer, reused by the next read
+		sf.dst, sf.started = pk.DstAddr, sf.srv.clock.Now()
+		sf.dst.IP = append(net.IP(nil), pk.DstAddr.IP...)
+	}
+	sf.data = append(sf.data, pk.Data...)
+	sf.last = pos
+	if !end {
+		return pk, false
+	}
+	pk.Frag, pk.DstAddr, pk.Data = 0, sf.dst, sf.data
+	sf.release()
+	return pk, true
+}
+
+// reset drops the fragments queued
+func (sf *fragQueue) reset() {
+	if sf.last != 0 {
+		sf.srv.addDroppedFragments(int(sf.last))
+	}
+	sf.release()
+}
+
+func (sf *fragQueue) release() {
+	sf.mem.release(int64(len(sf.data)))
+	sf.data, sf.last = sf.data[:0], 0
+}
+
+func (sf *Server) addDroppedFragments(n int) {
+	if m, ok := sf.metrics.(UDPMetrics); ok {
+		m.AddDroppedFragments(n)
+	}
+}
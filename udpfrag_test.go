@@ -0,0 +1,131 @@
+package socks5
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/thinkgos/go-socks5/statute"
+)
+
+// fragments splits the data to the target into the fragments of size bytes
+func fragments(t *testing.T, target string, data []byte, size int) []statute.Datagram {
+	pk, err := statute.NewDatagram(target, data)
+	require.NoError(t, err)
+	packets, _ := encapsulate(pk, len(pk.Header())+size, UDPOversizeFragment)
+	frags := make([]statute.Datagram, 0, len(packets))
+	for _, b := range packets {
+		frag, err := statute.ParseDatagram(b)
+		require.NoError(t, err)
+		frags = append(frags, frag)
+	}
+	return frags
+}
+
+func TestFragQueue(t *testing.T) {
+	clock := newManualClock()
+	m := newMockMetrics()
+	srv := NewServer(WithClock(clock), WithMetrics(m), WithUDPFragment(UDPFragmentReassemble, 0))
+	q := newFragQueue(srv, nil)
+	data := bytes.Repeat([]byte("abcdefghij"), 3)
+
+	// in order
+	frags := fragments(t, "127.0.0.1:80", data, 8)
+	require.Len(t, frags, 4)
+	for _, f := range frags[:3] {
+		_, ok := q.push(f)
+		require.False(t, ok)
+	}
+	pk, ok := q.push(frags[3])
+	require.True(t, ok)
+	require.Equal(t, data, pk.Data)
+	require.Equal(t, byte(0), pk.Frag)
+	require.Equal(t, "127.0.0.1:80", pk.DstAddr.String())
+	require.Equal(t, 0, m.fragments)
+
+	// a missing fragment drops the queue and the fragments after it
+	q.push(frags[0])
+	q.push(frags[1])
+	_, ok = q.push(frags[3])
+	require.False(t, ok)
+	require.Equal(t, 3, m.fragments)
+
+	// a new datagram restarts the queue
+	q.push(frags[0])
+	for _, f := range frags {
+		pk, ok = q.push(f)
+	}
+	require.True(t, ok)
+	require.Equal(t, data, pk.Data)
+	require.Equal(t, 4, m.fragments)
+
+	// the reassembly timer expired
+	q.push(frags[0])
+	clock.Advance(defaultReassemblyTimeout + time.Second)
+	_, ok = q.push(frags[1])
+	require.False(t, ok)
+	require.Equal(t, 6, m.fragments)
+}
+
+func TestFragQueue_Drop(t *testing.T) {
+	m := newMockMetrics()
+	q := newFragQueue(NewServer(WithMetrics(m)), nil)
+	for _, f := range fragments(t, "127.0.0.1:80", []byte("0123456789"), 4) {
+		_, ok := q.push(f)
+		require.False(t, ok)
+	}
+	require.Equal(t, 3, m.fragments)
+}
+
+func TestSOCKS5_Associate_Reassemble(t *testing.T) {
+	target := udpEchoTarget(t)
+	proxy := serveSocks(t, WithUDPFragment(UDPFragmentReassemble, time.Second))
+	conn, relay := associate(t, proxy, 0)
+	defer conn.Close()
+	client, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	defer client.Close()
+
+	data := bytes.Repeat([]byte("0123456789"), 10)
+	for _, f := range fragments(t, target.LocalAddr().String(), data, 30) {
+		_, err = client.WriteTo(f.Bytes(), relay)
+		require.NoError(t, err)
+	}
+	buf := make([]byte, 2048)
+	client.SetReadDeadline(time.Now().Add(time.Second)) // nolint: errcheck
+	n, err := client.Read(buf)
+	require.NoError(t, err)
+	rsp, err := statute.ParseDatagram(buf[:n])
+	require.NoError(t, err)
+	require.Equal(t, data, rsp.Data)
+}
+
+func TestFragQueue_IPv6ReadBuffer(t *testing.T) {
+	m := newMockMetrics()
+	q := newFragQueue(NewServer(WithMetrics(m), WithUDPFragment(UDPFragmentReassemble, 0)), nil)
+	packets := func(target string) [][]byte {
+		pk, err := statute.NewDatagram(target, []byte("0123456789"))
+		require.NoError(t, err)
+		b, _ := encapsulate(pk, len(pk.Header())+4, UDPOversizeFragment)
+		return b
+	}
+	a, b := packets("[2001:db8::1]:80"), packets("[2001:db8::2]:80")
+
+	// the fragments parsed from the same read buffer, the second to another destination
+	buf := make([]byte, 2048)
+	read := func(p []byte) statute.Datagram {
+		pk, err := statute.ParseDatagram(buf[:copy(buf, p)])
+		require.NoError(t, err)
+		return pk
+	}
+	_, ok := q.push(read(a[0]))
+	require.False(t, ok)
+	_, ok = q.push(read(b[1]))
+	require.False(t, ok)
+	_, ok = q.push(read(a[2]))
+	require.False(t, ok)
+	require.Equal(t, 3, m.fragments)
+}
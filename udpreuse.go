@@ -0,0 +1,190 @@
+package socks5
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// udpEndpointQueue is the datagrams queued for an association on the shared relay socket,
+// the datagram is dropped once the queue is full.
+const udpEndpointQueue = 64
+
+// errUDPEndpointClosed matches the error of a closed socket, which ends the relay of the association
+var errUDPEndpointClosed = errors.New("use of closed network connection")
+
+// udpRelayPool is the relay sockets shared by the associations from the same client ip
+type udpRelayPool struct {
+	mu    sync.Mutex
+	conns map[string]*sharedUDPConn
+}
+
+// listen returns the endpoint of the association on the shared relay socket of the client ip,
+// the socket listens on the network and laddr once the first association of the client ip comes.
+// port is the client port declared in the ASSOCIATE request, the datagrams are routed by it.
+// The association not declaring its port, or declaring the port of another, gets its own socket,
+// as its datagrams could not be told apart from the others'.
+func (sf *udpRelayPool) listen(srv *Server, network string, laddr *net.UDPAddr, client net.IP,
+	port int) (net.PacketConn, error) {
+	if port == 0 {
+		return net.ListenUDP(network, laddr)
+	}
+	key := network + "|" + laddr.String() + "|" + client.String()
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	sc, ok := sf.conns[key]
+	if !ok {
+		conn, err := net.ListenUDP(network, laddr)
+		if err != nil {
+			return nil, err
+		}
+		if sf.conns == nil {
+			sf.conns = make(map[string]*sharedUDPConn)
+		}
+		sc = &sharedUDPConn{
+			UDPConn: conn,
+			pool:    sf,
+			key:     key,
+			client:  client,
+			ports:   make(map[int]*udpEndpoint),
+		}
+		sf.conns[key] = sc
+		srv.goFunc(func() { sc.serve(srv) })
+	}
+	sc.mu.Lock()
+	_, exist := sc.ports[port]
+	sc.mu.Unlock()
+	if exist {
+		return net.ListenUDP(network, laddr)
+	}
+	sc.refs++
+	ep := &udpEndpoint{
+		conn: sc,
+		port: port,
+		ch:   make(chan udpDatagram, udpEndpointQueue),
+		done: make(chan struct{}),
+	}
+	sc.mu.Lock()
+	sc.ports[port] = ep
+	sc.mu.Unlock()
+	return ep, nil
+}
+
+// release drops the endpoint, and closes the socket once it has no endpoint
+func (sf *udpRelayPool) release(ep *udpEndpoint) {
+	sc := ep.conn
+	sc.mu.Lock()
+	if sc.ports[ep.port] == ep {
+		delete(sc.ports, ep.port)
+	}
+	sc.mu.Unlock()
+
+	sf.mu.Lock()
+	sc.refs--
+	if sc.refs == 0 {
+		delete(sf.conns, sc.key)
+		sc.UDPConn.Close()
+	}
+	sf.mu.Unlock()
+}
+
+// sharedUDPConn is the relay socket of a client ip, demuxing the datagrams
+// to the associations by the client port.
+type sharedUDPConn struct {
+	*net.UDPConn
+	pool   *udpRelayPool
+	key    string
+	client net.IP
+	// refs is the associations on the socket, guarded by the pool mutex
+	refs int
+
+	mu    sync.Mutex
+	ports map[int]*udpEndpoint
+}
+
+type udpDatagram struct {
+	data []byte
+	src  *net.UDPAddr
+}
+
+// serve reads the datagrams from the socket and delivers them to the endpoint of the client port,
+// the datagram from an unknown port is dropped.
+func (sf *sharedUDPConn) serve(srv *Server) {
+	buf := srv.datagramPool.Get()
+	defer srv.datagramPool.Put(buf)
+	for {
+		n, src, err := sf.ReadFromUDP(buf[:cap(buf)])
+		if err != nil {
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Temporary() {
+				continue
+			}
+			return
+		}
+		if !sf.client.Equal(src.IP) {
+			continue
+		}
+		sf.mu.Lock()
+		ep := sf.ports[src.Port]
+		sf.mu.Unlock()
+		if ep == nil {
+			continue
+		}
+		select {
+		case ep.ch <- udpDatagram{append([]byte(nil), buf[:n]...), src}:
+		default:
+		}
+	}
+}
+
+// udpEndpoint is the association on the shared relay socket, implement interface net.PacketConn
+type udpEndpoint struct {
+	conn *sharedUDPConn
+	// port is the client port declared
+	port      int
+	ch        chan udpDatagram
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// ReadFrom implement interface net.PacketConn
+func (sf *udpEndpoint) ReadFrom(p []byte) (int, net.Addr, error) {
+	select {
+	case d := <-sf.ch:
+		return copy(p, d.data), d.src, nil
+	case <-sf.done:
+		return 0, nil, &net.OpError{Op: "read", Net: "udp", Addr: sf.LocalAddr(), Err: errUDPEndpointClosed}
+	}
+}
+
+// WriteTo implement interface net.PacketConn
+func (sf *udpEndpoint) WriteTo(p []byte, addr net.Addr) (int, error) {
+	select {
+	case <-sf.done:
+		return 0, &net.OpError{Op: "write", Net: "udp", Addr: sf.LocalAddr(), Err: errUDPEndpointClosed}
+	default:
+	}
+	return sf.conn.WriteTo(p, addr)
+}
+
+// Close implement interface net.PacketConn
+func (sf *udpEndpoint) Close() error {
+	sf.closeOnce.Do(func() {
+		close(sf.done)
+		sf.conn.pool.release(sf)
+	})
+	return nil
+}
+
+// LocalAddr implement interface net.PacketConn
+func (sf *udpEndpoint) LocalAddr() net.Addr { return sf.conn.LocalAddr() }
+
+// SetDeadline implement interface net.PacketConn, deadlines are not supported on the shared socket
+func (sf *udpEndpoint) SetDeadline(time.Time) error { return nil }
+
+// SetReadDeadline implement interface net.PacketConn
+func (sf *udpEndpoint) SetReadDeadline(time.Time) error { return nil }
+
+// SetWriteDeadline implement interface net.PacketConn
+func (sf *udpEndpoint) SetWriteDeadline(time.Time) error { return nil }
@@ -0,0 +1,124 @@
+package socks5
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/thinkgos/go-socks5/statute"
+)
+
+// associate opens an association with the client port declared, returns the tcp connection
+// and the relay address
+func associate(t *testing.T, proxy net.Addr, port int) (net.Conn, *net.UDPAddr) {
+	conn, err := net.Dial("tcp", proxy.String())
+	require.NoError(t, err)
+	req := bytes.NewBuffer([]byte{statute.VersionSocks5, 1, statute.MethodNoAuth})
+	req.Write(statute.Request{
+		Version: statute.VersionSocks5,
+		Command: statute.CommandAssociate,
+		DstAddr: statute.AddrSpec{AddrType: statute.ATYPIPv4, IP: net.IPv4zero, Port: port},
+	}.Bytes())
+	_, err = conn.Write(req.Bytes())
+	require.NoError(t, err)
+	_, err = statute.ParseMethodReply(conn)
+	require.NoError(t, err)
+	rep, err := statute.ParseReply(conn)
+	require.NoError(t, err)
+	require.Equal(t, statute.RepSuccess, rep.Response)
+	return conn, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: rep.BndAddr.Port}
+}
+
+// udpEchoTarget listens an udp echo target
+func udpEchoTarget(t *testing.T) *net.UDPConn {
+	target, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	t.Cleanup(func() { target.Close() })
+	go func() {
+		buf := make([]byte, 2048)
+		for {
+			n, remote, err := target.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			target.WriteTo(buf[:n], remote) // nolint: errcheck
+		}
+	}()
+	return target
+}
+
+// udpPing sends msg to the target through the relay, returns the echo
+func udpPing(client *net.UDPConn, relay *net.UDPAddr, target net.Addr, msg string) (string, error) {
+	pk, err := statute.NewDatagram(target.String(), []byte(msg))
+	if err != nil {
+		return "", err
+	}
+	if _, err = client.WriteTo(pk.Bytes(), relay); err != nil {
+		return "", err
+	}
+	buf := make([]byte, 2048)
+	client.SetReadDeadline(time.Now().Add(time.Second)) // nolint: errcheck
+	n, err := client.Read(buf)
+	if err != nil {
+		return "", err
+	}
+	rsp, err := statute.ParseDatagram(buf[:n])
+	if err != nil {
+		return "", err
+	}
+	return string(rsp.Data), nil
+}
+
+func TestServer_UDPSocketReuse(t *testing.T) {
+	target := udpEchoTarget(t)
+	srv := NewServer(WithUDPSocketReuse(true))
+	proxy, _ := startServer(t, srv)
+	defer srv.Close()
+
+	client1, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	defer client1.Close()
+	client2, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	defer client2.Close()
+	port1, port2 := client1.LocalAddr().(*net.UDPAddr).Port, client2.LocalAddr().(*net.UDPAddr).Port
+
+	// the associations declaring their client ports share the socket
+	conn1, relay1 := associate(t, proxy, port1)
+	defer conn1.Close()
+	conn2, relay2 := associate(t, proxy, port2)
+	defer conn2.Close()
+	require.Equal(t, relay1.Port, relay2.Port)
+
+	// the association not declaring the port, or declaring the port of another, gets its own
+	conn3, relay3 := associate(t, proxy, 0)
+	defer conn3.Close()
+	require.NotEqual(t, relay1.Port, relay3.Port)
+	conn4, relay4 := associate(t, proxy, port1)
+	defer conn4.Close()
+	require.NotEqual(t, relay1.Port, relay4.Port)
+
+	ping := func(client *net.UDPConn, relay *net.UDPAddr, msg string) {
+		rsp, err := udpPing(client, relay, target.LocalAddr(), msg)
+		require.NoError(t, err)
+		require.Equal(t, msg, rsp)
+	}
+	ping(client2, relay2, "two")
+	ping(client1, relay1, "one")
+	ping(client2, relay2, "two")
+	ping(client1, relay3, "three")
+
+	// the socket closes once all associations end
+	conn1.Close()
+	conn2.Close()
+	conn3.Close()
+	conn4.Close()
+	require.Eventually(t, func() bool {
+		srv.udpRelays.mu.Lock()
+		defer srv.udpRelays.mu.Unlock()
+		return len(srv.udpRelays.conns) == 0
+	}, time.Second, 10*time.Millisecond)
+}
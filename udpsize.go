@@ -0,0 +1,79 @@
+package socks5
+
+import (
+	"github.com/thinkgos/go-socks5/statute"
+)
+
+// UDPOversizePolicy is the policy of the relayed datagram exceeding the max size
+type UDPOversizePolicy uint8
+
+// udp oversize policy defined
+const (
+	// UDPOversizeDrop drops the datagram
+	UDPOversizeDrop UDPOversizePolicy = iota
+	// UDPOversizeTruncate truncates the data to fit the max size
+	UDPOversizeTruncate
+	// UDPOversizeFragment splits the datagram into the SOCKS5 fragments
+	UDPOversizeFragment
+)
+
+// String implement interface fmt.Stringer
+func (p UDPOversizePolicy) String() string {
+	switch p {
+	case UDPOversizeDrop:
+		return "drop"
+	case UDPOversizeTruncate:
+		return "truncate"
+	case UDPOversizeFragment:
+		return "fragment"
+	}
+	return "unknown"
+}
+
+// maxFragments is the max fragment position, the high-order bit of FRAG marks the end
+const maxFragments = 0x7f
+
+// encapsulate returns the datagrams sent to the client, applying the oversize policy
+// if the datagram exceeds max size, nil means dropped. 0 max size means no limit.
+func encapsulate(pk statute.Datagram, max int, policy UDPOversizePolicy) (packets [][]byte, oversize bool) {
+	header := pk.Header()
+	if max <= 0 || len(header)+len(pk.Data) <= max {
+		return [][]byte{append(header, pk.Data...)}, false
+	}
+	room := max - len(header)
+	if room <= 0 {
+		return nil, true
+	}
+	switch policy {
+	case UDPOversizeTruncate:
+		return [][]byte{append(header, pk.Data[:room]...)}, true
+	case UDPOversizeFragment:
+		count := (len(pk.Data) + room - 1) / room
+		if count > maxFragments {
+			return nil, true
+		}
+		packets = make([][]byte, 0, count)
+		for i := 0; i < count; i++ {
+			data := pk.Data[i*room:]
+			if len(data) > room {
+				data = data[:room]
+			}
+			frag := byte(i + 1)
+			if i == count-1 {
+				frag |= 0x80
+			}
+			b := make([]byte, 0, len(header)+len(data))
+			b = append(b, header...)
+			b[2] = frag
+			packets = append(packets, append(b, data...))
+		}
+		return packets, true
+	}
+	return nil, true
+}
+
+func (sf *Server) incUDPOversize(policy UDPOversizePolicy) {
+	if m, ok := sf.metrics.(UDPMetrics); ok {
+		m.IncUDPOversize(policy)
+	}
+}
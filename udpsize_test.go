@@ -0,0 +1,47 @@
+package socks5
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/thinkgos/go-socks5/statute"
+)
+
+func TestEncapsulate(t *testing.T) {
+	data := bytes.Repeat([]byte{'a'}, 25)
+	pk, err := statute.NewDatagram("127.0.0.1:80", data)
+	require.NoError(t, err)
+	headerLen := len(pk.Header())
+
+	packets, oversize := encapsulate(pk, 0, UDPOversizeDrop)
+	require.False(t, oversize)
+	require.Len(t, packets, 1)
+	require.Equal(t, pk.Bytes(), packets[0])
+
+	packets, oversize = encapsulate(pk, headerLen+10, UDPOversizeDrop)
+	require.True(t, oversize)
+	require.Nil(t, packets)
+
+	packets, oversize = encapsulate(pk, headerLen+10, UDPOversizeTruncate)
+	require.True(t, oversize)
+	require.Len(t, packets, 1)
+	require.Equal(t, data[:10], packets[0][headerLen:])
+
+	packets, oversize = encapsulate(pk, headerLen+10, UDPOversizeFragment)
+	require.True(t, oversize)
+	require.Len(t, packets, 3)
+	var joined []byte
+	for i, b := range packets {
+		frag, err := statute.ParseDatagram(b)
+		require.NoError(t, err)
+		want := byte(i + 1)
+		if i == len(packets)-1 {
+			want |= 0x80
+		}
+		require.Equal(t, want, frag.Frag)
+		joined = append(joined, frag.Data...)
+	}
+	require.Equal(t, data, joined)
+}
@@ -0,0 +1,170 @@
+package socks5
+
+import (
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// UsageEntry is the aggregated usage of a user to a destination
+type UsageEntry struct {
+	// User name, empty if not authenticated by username
+	User string
+	// Destination requested by the user, host:port
+	Destination string
+	// Sessions relayed
+	Sessions uint64
+	// Denials by the rules
+	Denials uint64
+	// BytesUp from client to target
+	BytesUp uint64
+	// BytesDown from target to client
+	BytesDown uint64
+}
+
+// UsageReport is the aggregated usage summaries of an interval
+type UsageReport struct {
+	Start   time.Time
+	End     time.Time
+	Entries []UsageEntry
+}
+
+type usageKey struct {
+	user        string
+	destination string
+}
+
+// usageCollector aggregates the usage and delivers the report periodically
+type usageCollector struct {
+	clock    Clock
+	interval time.Duration
+	report   func(UsageReport)
+	once     sync.Once
+	stopOnce sync.Once
+	// stopped is closed to stop, done is closed once the final report delivered
+	stopped chan struct{}
+	done    chan struct{}
+	mu      sync.Mutex
+	start   time.Time
+	entries map[usageKey]*UsageEntry
+}
+
+func newUsageCollector(interval time.Duration, report func(UsageReport)) *usageCollector {
+	return &usageCollector{
+		clock:    SystemClock{},
+		interval: interval,
+		report:   report,
+		start:    time.Now(),
+		entries:  make(map[usageKey]*UsageEntry),
+		stopped:  make(chan struct{}),
+	}
+}
+
+// run starts delivering the report periodically, only once, not after stopped.
+func (sf *usageCollector) run() {
+	sf.once.Do(func() {
+		sf.done = make(chan struct{})
+		go sf.loop()
+	})
+}
+
+func (sf *usageCollector) loop() {
+	defer close(sf.done)
+	ticker := sf.clock.NewTicker(sf.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C():
+			sf.report(sf.flush())
+		case <-sf.stopped:
+			sf.report(sf.flush())
+			return
+		}
+	}
+}
+
+// stop stops the periodic report after delivering the final report of the current interval,
+// it returns the channel closed once the final report delivered, nothing delivered if not run.
+func (sf *usageCollector) stop() <-chan struct{} {
+	started := true
+	if sf != nil {
+		sf.once.Do(func() { started = false })
+	}
+	if sf == nil || !started {
+		done := make(chan struct{})
+		close(done)
+		return done
+	}
+	sf.stopOnce.Do(func() { close(sf.stopped) })
+	return sf.done
+}
+
+// flush returns the report of the current interval and starts a new interval
+func (sf *usageCollector) flush() UsageReport {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	now := sf.clock.Now()
+	r := UsageReport{
+		Start:   sf.start,
+		End:     now,
+		Entries: make([]UsageEntry, 0, len(sf.entries)),
+	}
+	for _, e := range sf.entries {
+		r.Entries = append(r.Entries, *e)
+	}
+	sf.start = now
+	sf.entries = make(map[usageKey]*UsageEntry)
+	return r
+}
+
+func (sf *usageCollector) entry(req *Request) *UsageEntry {
+	key := usageKey{usernameOf(req), destinationOf(req)}
+	e, ok := sf.entries[key]
+	if !ok {
+		e = &UsageEntry{User: key.user, Destination: key.destination}
+		sf.entries[key] = e
+	}
+	return e
+}
+
+func (sf *usageCollector) addDenial(req *Request) {
+	if sf == nil {
+		return
+	}
+	sf.mu.Lock()
+	sf.entry(req).Denials++
+	sf.mu.Unlock()
+}
+
+func (sf *usageCollector) addSession(req *Request) {
+	if sf == nil || req.sess == nil {
+		return
+	}
+	sf.mu.Lock()
+	e := sf.entry(req)
+	e.Sessions++
+	e.BytesUp += atomic.LoadUint64(&req.sess.bytesUp)
+	e.BytesDown += atomic.LoadUint64(&req.sess.bytesDown)
+	sf.mu.Unlock()
+}
+
+// usernameOf returns the username of the request, empty if not authenticated by username
+func usernameOf(req *Request) string {
+	if req.AuthContext == nil {
+		return ""
+	}
+	return req.AuthContext.Payload["username"]
+}
+
+// destinationOf returns the requested destination, prefer FQDN
+func destinationOf(req *Request) string {
+	if req.RawDestAddr == nil {
+		return ""
+	}
+	if req.RawDestAddr.FQDN != "" {
+		return net.JoinHostPort(req.RawDestAddr.FQDN, strconv.Itoa(req.RawDestAddr.Port))
+	}
+	return req.RawDestAddr.String()
+}
@@ -0,0 +1,82 @@
+package socks5
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/thinkgos/go-socks5/statute"
+)
+
+func TestUsageCollector(t *testing.T) {
+	c := newUsageCollector(time.Minute, func(UsageReport) {})
+
+	req := &Request{
+		AuthContext: &AuthContext{Method: statute.MethodUserPassAuth, Payload: map[string]string{"username": "foo"}},
+		RawDestAddr: &statute.AddrSpec{FQDN: "localhost", Port: 80, AddrType: statute.ATYPDomain},
+		sess:        &session{bytesUp: 10, bytesDown: 20},
+	}
+	c.addSession(req)
+	c.addSession(req)
+	c.addDenial(req)
+	c.addDenial(&Request{RawDestAddr: &statute.AddrSpec{FQDN: "localhost", Port: 80}})
+
+	r := c.flush()
+	require.Len(t, r.Entries, 2)
+	for _, e := range r.Entries {
+		require.Equal(t, "localhost:80", e.Destination)
+		if e.User == "foo" {
+			require.Equal(t, UsageEntry{"foo", "localhost:80", 2, 1, 20, 40}, e)
+		} else {
+			require.Equal(t, UsageEntry{"", "localhost:80", 0, 1, 0, 0}, e)
+		}
+	}
+	require.Empty(t, c.flush().Entries)
+
+	var nilCollector *usageCollector
+	nilCollector.addDenial(req)
+}
+
+func TestUsageCollector_Stop(t *testing.T) {
+	reports := make(chan UsageReport, 4)
+	c := newUsageCollector(time.Minute, func(r UsageReport) { reports <- r })
+	clock := newManualClock()
+	c.clock = clock
+	// nothing delivered if not run
+	<-newUsageCollector(time.Minute, func(UsageReport) { t.Fatal("delivered") }).stop()
+
+	c.run()
+	c.addDenial(&Request{RawDestAddr: &statute.AddrSpec{FQDN: "localhost", Port: 80}})
+	<-c.stop()
+	r := <-reports
+	require.Len(t, r.Entries, 1)
+	<-c.stop()
+
+	// stopped, no more report
+	clock.Advance(time.Hour)
+	c.run()
+	select {
+	case <-reports:
+		t.Fatal("reported after stopped")
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+func TestServer_UsageReport_Shutdown(t *testing.T) {
+	reports := make(chan UsageReport, 1)
+	srv := NewServer(WithUsageReport(time.Hour, func(r UsageReport) { reports <- r }))
+	proxy, _ := startServer(t, srv)
+	conn := relaySession(t, proxy, echoTarget(t))
+	conn.Close()
+
+	require.NoError(t, srv.Shutdown(context.Background()))
+	select {
+	case r := <-reports:
+		require.Len(t, r.Entries, 1)
+		require.Equal(t, uint64(1), r.Entries[0].Sessions)
+	default:
+		t.Fatal("final report not delivered")
+	}
+}
@@ -0,0 +1,252 @@
+package socks5
+
+import (
+	"compress/flate"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/thinkgos/go-socks5/statute"
+)
+
+// ConfigProblem is a conflicting or nonsensical configuration of the server
+type ConfigProblem struct {
+	// Option is the option configured it, such as "WithUDPNATLimit"
+	Option string
+	// Message describes the problem
+	Message string
+}
+
+// String implement interface fmt.Stringer
+func (p ConfigProblem) String() string { return p.Option + ": " + p.Message }
+
+// ConfigError is the problems found by Server.Validate
+type ConfigError struct {
+	Problems []ConfigProblem
+}
+
+// Error implement interface error
+func (sf *ConfigError) Error() string {
+	s := make([]string, 0, len(sf.Problems))
+	for _, p := range sf.Problems {
+		s = append(s, p.String())
+	}
+	return "socks5: invalid configuration, " + strings.Join(s, "; ")
+}
+
+// validator is implemented by the rule set which could check its configuration, such as DestinationRules
+type validator interface {
+	Validate() error
+}
+
+// Validate checks the server assembled by the options for the conflicting or nonsensical
+// configuration, such as the username/password authentication without credentials,
+// or the udp options while ASSOCIATE is never permitted. It is meant to be called before
+// listening, the server serves as before regardless. It returns a *ConfigError with all
+// the problems found, nil if none.
+func (sf *Server) Validate() error {
+	var problems []ConfigProblem
+	report := func(option, message string) {
+		problems = append(problems, ConfigProblem{option, message})
+	}
+
+	// authentication
+	for _, m := range sf.authCustomMethods {
+		switch a := m.(type) {
+		case UserPassAuthenticator:
+			if a.Credentials == nil {
+				report("WithAuthMethods", "username/password authentication without credentials")
+			}
+		case *UserPassAuthenticator:
+			if a.Credentials == nil {
+				report("WithAuthMethods", "username/password authentication without credentials")
+			}
+		case CheckedUserPassAuthenticator:
+			if a.Credentials == nil {
+				report("WithAuthMethods", "username/password authentication without credentials")
+			}
+		case *CheckedUserPassAuthenticator:
+			if a.Credentials == nil {
+				report("WithAuthMethods", "username/password authentication without credentials")
+			}
+		}
+	}
+	if _, ok := sf.authMethods[statute.MethodUserPassAuth]; !ok && sf.credentials != nil {
+		report("WithCredential", "credentials ignored, no username/password authentication in the auth methods")
+	}
+	if _, ok := sf.authMethods[statute.MethodNoAuth]; !ok && sf.serves(Socks4) {
+		report("WithProtocols", "SOCKS4 has no authentication and is never served without the no auth method")
+	}
+
+	// rules and destinations
+	if v, ok := sf.currentRules().(validator); ok {
+		if err := v.Validate(); err != nil {
+			report("WithRule", err.Error())
+		}
+	}
+	if t := sf.forwardTarget; t != nil && t.err != nil {
+		report("WithForward", "invalid target "+t.raw+", "+t.err.Error())
+	}
+	users := make([]string, 0, len(sf.forwardUserTargets))
+	for user := range sf.forwardUserTargets {
+		users = append(users, user)
+	}
+	sort.Strings(users)
+	for _, user := range users {
+		if t := sf.forwardUserTargets[user]; t.err != nil {
+			report("WithForward", "invalid target "+t.raw+" of user "+user+", "+t.err.Error())
+		}
+	}
+	for _, addr := range sf.bindAddrPool {
+		if _, err := statute.ParseAddrSpec(addr); err != nil {
+			report("WithBindAddrPool", "invalid address "+addr+", "+err.Error())
+		}
+	}
+	if sf.compression && (sf.compressionLevel < flate.HuffmanOnly || sf.compressionLevel > flate.BestCompression) {
+		report("WithCompression", fmt.Sprintf("invalid level %d, compression declined", sf.compressionLevel))
+	}
+	addrs := make([]string, 0, len(sf.virtualServers))
+	for addr := range sf.virtualServers {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+	for _, addr := range addrs {
+		if c := sf.virtualServers[addr]; c.clientAuth != nil && sf.tlsConfigOf(c) == nil {
+			report("WithVirtualServer", "client auth of "+addr+" without TLS")
+		}
+	}
+	if sf.proxyProtocol != nil {
+		if len(sf.proxyProtocol.Trusted) == 0 {
+			report("WithProxyProtocol", "no trusted peers, the header is never parsed")
+		}
+		for _, s := range sf.proxyProtocol.Trusted {
+			if _, err := parseCIDR(s); err != nil {
+				report("WithProxyProtocol", err.Error())
+			}
+		}
+	}
+
+	// commands
+	if sf.commandDisabled(statute.CommandConnect) && sf.commandDisabled(statute.CommandBind) &&
+		sf.commandDisabled(statute.CommandAssociate) {
+		report("WithDisableConnect", "all the commands disabled")
+	}
+
+	// udp
+	neverAssociate := ""
+	if sf.commandDisabled(statute.CommandAssociate) {
+		neverAssociate = "udp option set but ASSOCIATE is disabled by WithDisableAssociate"
+	} else if p, ok := sf.currentRules().(*PermitCommand); ok && !p.EnableAssociate && sf.userAssociateHandle == nil {
+		neverAssociate = "udp option set but ASSOCIATE is never permitted by the rules"
+	}
+	if neverAssociate != "" {
+		for _, c := range []struct {
+			option string
+			set    bool
+		}{
+			{"WithUDPNATLimit", sf.udpMaxFlows != 0 || sf.udpMaxGlobalFlows != 0},
+			{"WithUDPRateLimit", sf.udpPacketRate != 0 || sf.udpByteRate != 0},
+			{"WithUDPMaxDatagram", sf.udpMaxDatagram != 0},
+			{"WithUDPFragment", sf.udpFragmentPolicy != UDPFragmentDrop},
+			{"WithUDPFlowTimeout", sf.udpFlowIdleTimeout != 0},
+			{"WithUDPSocketReuse", sf.udpSocketReuse},
+			{"WithAssociateSourceCheck", sf.associateSourceCheck},
+		} {
+			if c.set {
+				report(c.option, neverAssociate)
+			}
+		}
+	}
+	if sf.udpMaxFlows < 0 || sf.udpMaxGlobalFlows < 0 {
+		report("WithUDPNATLimit", "negative limit")
+	} else if sf.udpMaxGlobalFlows > 0 && sf.udpMaxFlows > sf.udpMaxGlobalFlows {
+		report("WithUDPNATLimit", "per association limit exceeds the global limit")
+	}
+	if sf.udpPacketRate < 0 || sf.udpByteRate < 0 {
+		report("WithUDPRateLimit", "negative rate")
+	}
+	if sf.udpMaxDatagram < 0 {
+		report("WithUDPMaxDatagram", "negative size")
+	} else if sf.udpMaxDatagram > 0 && sf.udpMaxDatagram <= maxDatagramHeaderLen &&
+		sf.udpOversizePolicy != UDPOversizeDrop {
+		report("WithUDPMaxDatagram", "size may not fit the datagram header")
+	}
+	if sf.relayBufferSize < 0 || sf.datagramBufferSize < 0 {
+		report("WithBufferSize", "negative size")
+	} else if sf.datagramBufferSize > 0 && sf.datagramBufferSize < minDatagramBufferSize {
+		report("WithBufferSize", fmt.Sprintf("datagram buffer under %d bytes raised to it", minDatagramBufferSize))
+	}
+	if sf.udpReassemblyTimeout < 0 {
+		report("WithUDPFragment", "negative reassembly timeout")
+	}
+	if sf.udpFlowIdleTimeout < 0 {
+		report("WithUDPFlowTimeout", "negative idle timeout")
+	}
+	if sf.associateSourceNAT && !sf.associateSourceCheck {
+		report("WithAssociateSourceCheck", "NAT relaxed without the source check enabled")
+	}
+
+	// relay and limits
+	if sf.pipelinedDeny && sf.earlyData {
+		report("WithEarlyData", "early data enabled but the pipelined data denied by WithPipelinedData")
+	}
+	if sf.pipelinedMax < 0 {
+		report("WithPipelinedData", "negative max buffered")
+	}
+	if sf.sessionMemory < 0 {
+		report("WithMemoryLimit", "negative limit")
+	} else if sf.memory != nil && sf.memory.limit > 0 && sf.sessionMemory > sf.memory.limit {
+		report("WithMemoryLimit", "per session limit exceeds the global limit")
+	}
+	if sf.connByteRate < 0 {
+		report("WithConnRateLimit", "negative rate")
+	}
+	if sf.countryPolicies != nil && sf.geoIP == nil {
+		report("WithClientCountry", "country policies without GeoIP")
+	}
+	for country, p := range sf.countryPolicies {
+		if p.ByteRate < 0 {
+			report("WithClientCountry", fmt.Sprintf("negative rate of country %q", country))
+		}
+	}
+	if sf.acceptBackoffMax > 0 && sf.acceptBackoffMin > sf.acceptBackoffMax {
+		report("WithAcceptBackoff", "min backoff exceeds the max backoff")
+	}
+	if sf.requestHeaderTimeout < 0 || sf.requestHeaderMaxReads < 0 {
+		report("WithRequestHeaderLimit", "negative limit")
+	}
+	for _, c := range []struct {
+		option   string
+		negative bool
+	}{
+		{"WithSymmetricDeadline", sf.symmetricTimeout < 0},
+		{"WithClientTimeout", sf.clientReadTimeout < 0 || sf.clientWriteTimeout < 0},
+		{"WithTargetTimeout", sf.targetReadTimeout < 0 || sf.targetWriteTimeout < 0},
+		{"WithHandshakeTimeout", sf.handshakeTimeout < 0},
+		{"WithDialTimeout", sf.dialTimeout < 0},
+		{"WithIdleTimeout", sf.idleTimeout < 0},
+	} {
+		if c.negative {
+			report(c.option, "negative timeout")
+		}
+	}
+	if sf.handshakeTimeout > 0 && sf.requestHeaderTimeout > sf.handshakeTimeout {
+		report("WithRequestHeaderLimit", "timeout exceeds the handshake timeout of WithHandshakeTimeout")
+	}
+	if sf.authFailureDelay < 0 || sf.authFailureJitter < 0 {
+		report("WithAuthFailureDelay", "negative delay")
+	} else if sf.handshakeTimeout > 0 && sf.authFailureDelay+sf.authFailureJitter >= sf.handshakeTimeout {
+		report("WithAuthFailureDelay", "delay exceeds the handshake timeout of WithHandshakeTimeout")
+	}
+	if sf.budget != nil && !sf.budget.valid() {
+		report("WithConnectionBudget", "non-positive window or negative max")
+	}
+	if sf.stallThreshold < 0 {
+		report("WithStallWatchdog", "negative threshold")
+	}
+
+	if len(problems) > 0 {
+		return &ConfigError{problems}
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package socks5
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer_Validate(t *testing.T) {
+	require.NoError(t, NewServer().Validate())
+	require.NoError(t, NewServer(
+		WithCredential(StaticCredentials{"foo": "bar"}),
+		WithUDPNATLimit(16, 1024),
+		WithUDPFragment(UDPFragmentReassemble, 0),
+		WithRule(&DestinationRules{DefaultAllow: true}),
+		WithAcceptBackoff(0, 0),
+	).Validate())
+
+	err := NewServer(
+		WithAuthMethods([]Authenticator{UserPassAuthenticator{}}),
+		WithProtocols(Socks4, Socks5),
+		WithRule(NewPermitConnAndAss()),
+		WithUDPNATLimit(32, 16),
+		WithPipelinedData(false, 0),
+		WithEarlyData(true),
+		WithClientTimeout(-time.Second, 0),
+		WithForward("10.0.0.1:80", map[string]string{"foo": "no-port"}),
+	).Validate()
+	var ce *ConfigError
+	require.True(t, errors.As(err, &ce))
+	var options []string
+	for _, p := range ce.Problems {
+		options = append(options, p.Option)
+	}
+	require.Equal(t, []string{
+		"WithAuthMethods",
+		"WithProtocols",
+		"WithForward",
+		"WithUDPNATLimit",
+		"WithEarlyData",
+		"WithClientTimeout",
+	}, options)
+	require.Contains(t, err.Error(), "WithAuthMethods: username/password authentication without credentials")
+
+	// udp options while ASSOCIATE is never permitted
+	err = NewServer(WithRule(NewPermitNone()), WithUDPFlowTimeout(time.Minute)).Validate()
+	require.EqualError(t, err, "socks5: invalid configuration, "+
+		"WithUDPFlowTimeout: udp option set but ASSOCIATE is never permitted by the rules")
+
+	err = NewServer(WithProxyProtocol(ProxyProtocolConfig{Trusted: []string{"10.0.0.0/8", "lb"}})).Validate()
+	require.EqualError(t, err,
+		"socks5: invalid configuration, WithProxyProtocol: invalid cidr lb, invalid CIDR address: lb")
+
+	err = NewServer(WithProxyProtocol(ProxyProtocolConfig{Optional: true})).Validate()
+	require.EqualError(t, err,
+		"socks5: invalid configuration, WithProxyProtocol: no trusted peers, the header is never parsed")
+
+	err = NewServer(WithCompression(10)).Validate()
+	require.EqualError(t, err, "socks5: invalid configuration, WithCompression: invalid level 10, compression declined")
+
+	err = NewServer(WithDisableAssociate(), WithUDPFlowTimeout(time.Minute)).Validate()
+	require.EqualError(t, err, "socks5: invalid configuration, "+
+		"WithUDPFlowTimeout: udp option set but ASSOCIATE is disabled by WithDisableAssociate")
+	err = NewServer(WithDisableConnect(), WithDisableBind(), WithDisableAssociate()).Validate()
+	require.EqualError(t, err, "socks5: invalid configuration, WithDisableConnect: all the commands disabled")
+	err = NewServer(WithClientCountry(nil, map[string]CountryPolicy{"CN": {ByteRate: -1}})).Validate()
+	require.True(t, errors.As(err, &ce))
+	require.Len(t, ce.Problems, 2)
+	err = NewServer(WithBufferSize(-1, 0)).Validate()
+	require.EqualError(t, err, "socks5: invalid configuration, WithBufferSize: negative size")
+	err = NewServer(WithBufferSize(0, 16)).Validate()
+	require.EqualError(t, err,
+		"socks5: invalid configuration, WithBufferSize: datagram buffer under 774 bytes raised to it")
+	err = NewServer(WithConnectionBudget(ConnectionBudget{Limits: []BudgetLimit{{Max: 1}}})).Validate()
+	require.EqualError(t, err,
+		"socks5: invalid configuration, WithConnectionBudget: non-positive window or negative max")
+	err = NewServer(WithAcceptBackoff(2*time.Second, time.Second)).Validate()
+	require.EqualError(t, err, "socks5: invalid configuration, WithAcceptBackoff: min backoff exceeds the max backoff")
+	err = NewServer(WithAuthFailureDelay(-1, 0)).Validate()
+	require.EqualError(t, err, "socks5: invalid configuration, WithAuthFailureDelay: negative delay")
+	err = NewServer(WithAuthFailureDelay(time.Second, time.Second), WithHandshakeTimeout(2*time.Second)).Validate()
+	require.EqualError(t, err, "socks5: invalid configuration, "+
+		"WithAuthFailureDelay: delay exceeds the handshake timeout of WithHandshakeTimeout")
+
+	// the rule set validates itself
+	err = NewServer(WithRule(&DestinationRules{Rules: []DestinationRule{{Action: DestinationRedirect}}})).Validate()
+	require.True(t, errors.As(err, &ce))
+	require.Equal(t, "WithRule", ce.Problems[0].Option)
+}
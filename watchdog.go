@@ -0,0 +1,98 @@
+package socks5
+
+import (
+	"io"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// stallWriter records since when the write is blocked
+type stallWriter struct {
+	io.Writer
+	clock Clock
+	since int64  // unix nano, 0 if not writing
+	seq   uint64 // the writes started
+	// notified is the seq of the write whose stall notified, only accessed by the watcher
+	notified uint64
+}
+
+// Write implement interface io.Writer
+func (sf *stallWriter) Write(p []byte) (int, error) {
+	atomic.AddUint64(&sf.seq, 1)
+	atomic.StoreInt64(&sf.since, sf.clock.Now().UnixNano())
+	n, err := sf.Writer.Write(p)
+	atomic.StoreInt64(&sf.since, 0)
+	return n, err
+}
+
+// CloseWrite implement interface closeWriter
+func (sf *stallWriter) CloseWrite() error {
+	if c, ok := sf.Writer.(closeWriter); ok {
+		return c.CloseWrite()
+	}
+	return nil
+}
+
+// stalled reports whether the write is blocked longer than the threshold and not notified yet,
+// a stall is notified once until the bytes move again.
+func (sf *stallWriter) stalled(now time.Time, threshold time.Duration) bool {
+	since, seq := atomic.LoadInt64(&sf.since), atomic.LoadUint64(&sf.seq)
+	if since == 0 || seq == sf.notified || now.Sub(time.Unix(0, since)) <= threshold {
+		return false
+	}
+	sf.notified = seq
+	return true
+}
+
+// watchStall watches the relay writers, if a direction has been blocked on write
+// longer than the threshold, such as the slow-reader attack, the stall handle is notified
+// and the session is closed unless the handle returns false, the stall kept is notified once.
+// It returns the wrapped writers and the function to stop watching.
+func (sf *Server) watchStall(request *Request, clientW, targetW io.Writer, target net.Conn) (
+	io.Writer, io.Writer, func()) {
+	if sf.stallThreshold <= 0 {
+		return clientW, targetW, func() {}
+	}
+	down, up := &stallWriter{Writer: clientW, clock: sf.clock}, &stallWriter{Writer: targetW, clock: sf.clock}
+	done := make(chan struct{})
+	interval := sf.stallThreshold / 4
+	if interval < 10*time.Millisecond {
+		interval = 10 * time.Millisecond
+	}
+	go func() {
+		ticker := sf.clock.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-ticker.C():
+				for _, w := range []*stallWriter{up, down} {
+					if !w.stalled(now, sf.stallThreshold) {
+						continue
+					}
+					shouldClose := true
+					if sf.stallHandle != nil {
+						var s Session
+						if request.sess != nil {
+							s = request.sess.snapshot()
+						}
+						shouldClose = sf.stallHandle(s, w == up)
+					}
+					if shouldClose {
+						request.sess.setCloseReason(CloseReasonIdleTimeout)
+						sf.logger.Errorf("relay stalled over %v, close session %v",
+							sf.stallThreshold, request.RemoteAddr)
+						target.Close()
+						if request.conn != nil {
+							request.conn.Close()
+						}
+						return
+					}
+				}
+			}
+		}
+	}()
+	return down, up, func() { close(done) }
+}
@@ -0,0 +1,72 @@
+package socks5
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer_watchStall(t *testing.T) {
+	client, _ := net.Pipe()
+	target, peer := net.Pipe()
+	defer peer.Close()
+
+	stalled := make(chan bool, 1)
+	srv := NewServer(WithStallWatchdog(50*time.Millisecond, func(s Session, up bool) bool {
+		stalled <- up
+		return true
+	}))
+
+	_, targetW, stop := srv.watchStall(&Request{conn: client}, client, target, target)
+	defer stop()
+
+	// nobody reads the peer, the write blocks until the watchdog closes the target
+	_, err := targetW.Write([]byte("ping"))
+	require.Error(t, err)
+	select {
+	case up := <-stalled:
+		require.True(t, up)
+	case <-time.After(time.Second):
+		t.Fatal("stall not detected")
+	}
+}
+
+func TestServer_watchStall_Once(t *testing.T) {
+	client, _ := net.Pipe()
+	target, peer := net.Pipe()
+	defer peer.Close()
+
+	stalled := make(chan bool, 16)
+	srv := NewServer(WithStallWatchdog(20*time.Millisecond, func(s Session, up bool) bool {
+		stalled <- up
+		return false
+	}))
+
+	_, targetW, stop := srv.watchStall(&Request{conn: client}, client, target, target)
+	defer stop()
+
+	buf := make([]byte, 4)
+	for i := 0; i < 2; i++ {
+		written := make(chan error, 1)
+		go func() {
+			_, err := targetW.Write([]byte("ping"))
+			written <- err
+		}()
+		select {
+		case <-stalled:
+		case <-time.After(time.Second):
+			t.Fatal("stall not detected")
+		}
+		// the stall kept is not notified again
+		time.Sleep(100 * time.Millisecond)
+		require.Len(t, stalled, 0)
+
+		// the bytes move again, the next stall is notified
+		_, err := io.ReadFull(peer, buf)
+		require.NoError(t, err)
+		require.NoError(t, <-written)
+	}
+}
@@ -0,0 +1,80 @@
+package socks5
+
+import (
+	"bufio"
+	"io"
+	"net"
+)
+
+// spliceChunk is the bytes spliced at a time, the bytes relayed are counted per chunk
+const spliceChunk = 64 * 1024
+
+// spliceLegs returns the tcp connections of both legs if the relay could be spliced, that is
+// zero copy enabled, and the legs are the plain tcp connections not wrapped by any feature
+// observing the data relayed.
+func (sf *Server) spliceLegs(request *Request, writer io.Writer, target net.Conn,
+	clientR io.Reader, clientW io.Writer, targetR io.Reader, targetW io.Writer) (*net.TCPConn, *net.TCPConn, bool) {
+	if !canSplice || !sf.zeroCopy || sf.idleTimeoutOf(request.listener) > 0 {
+		return nil, nil, false
+	}
+	client, ok1 := request.conn.(*net.TCPConn)
+	tc, ok2 := target.(*net.TCPConn)
+	br, ok3 := request.Reader.(*bufio.Reader)
+	if !ok1 || !ok2 || !ok3 {
+		return nil, nil, false
+	}
+	if w, ok := writer.(*net.TCPConn); !ok || w != client {
+		return nil, nil, false
+	}
+	if r, ok := clientR.(*bufio.Reader); !ok || r != br {
+		return nil, nil, false
+	}
+	if w, ok := clientW.(*net.TCPConn); !ok || w != client {
+		return nil, nil, false
+	}
+	if r, ok := targetR.(*net.TCPConn); !ok || r != tc {
+		return nil, nil, false
+	}
+	if w, ok := targetW.(*net.TCPConn); !ok || w != tc {
+		return nil, nil, false
+	}
+	return client, tc, true
+}
+
+// splice is the same as proxy, but the data is copied by the ReadFrom of the tcp connection,
+// which splices on linux. The data buffered by br, if any, is written first, then src is
+// read directly. counter is the counting writer of dst, the bytes are counted per chunk.
+// It returns the bytes copied.
+func (sf *Server) splice(counter io.Writer, dst, src *net.TCPConn, br *bufio.Reader) (int64, error) {
+	var written int64
+	if br != nil {
+		if n := br.Buffered(); n > 0 {
+			b, _ := br.Peek(n)
+			if _, err := counter.Write(b); err != nil {
+				return 0, err
+			}
+			br.Discard(n) // nolint: errcheck
+			written = int64(n)
+		}
+	}
+	cw, _ := counter.(*countWriter)
+	lr := &io.LimitedReader{R: src}
+	var err error
+	for {
+		lr.N = spliceChunk
+		var n int64
+		n, err = dst.ReadFrom(lr)
+		written += n
+		if cw != nil {
+			cw.add(int(n))
+		}
+		// short of the chunk is the EOF of src
+		if err != nil || n < spliceChunk {
+			break
+		}
+	}
+	if c, ok := counter.(closeWriter); ok {
+		c.CloseWrite() // nolint: errcheck
+	}
+	return written, err
+}
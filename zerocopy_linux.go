@@ -0,0 +1,4 @@
+package socks5
+
+// canSplice reports whether the ReadFrom of the tcp connection splices from the tcp connection
+const canSplice = true
@@ -0,0 +1,8 @@
+//go:build !linux
+// +build !linux
+
+package socks5
+
+// canSplice reports whether the ReadFrom of the tcp connection splices from the tcp connection,
+// the ReadFrom copies by the userspace buffer on the other platforms.
+const canSplice = false
@@ -0,0 +1,117 @@
+package socks5
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestZeroCopy_Relay(t *testing.T) {
+	srv := NewServer(WithZeroCopy())
+	proxy, _ := startServer(t, srv)
+	defer srv.Close()
+	// the pipelined data buffered before the splice is relayed too
+	conn := relaySession(t, proxy, echoTarget(t))
+	defer conn.Close()
+
+	payload := make([]byte, 1<<20)
+	_, err := rand.Read(payload)
+	require.NoError(t, err)
+	go conn.Write(payload) // nolint: errcheck
+	got := make([]byte, len(payload))
+	_, err = io.ReadFull(conn, got)
+	require.NoError(t, err)
+	require.True(t, bytes.Equal(payload, got))
+
+	require.NoError(t, conn.(*net.TCPConn).CloseWrite())
+	_, err = conn.Read(got)
+	require.Equal(t, io.EOF, err)
+	require.Eventually(t, func() bool {
+		sessions := srv.Sessions()
+		return len(sessions) == 0
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestZeroCopy_Counted(t *testing.T) {
+	srv := NewServer(WithZeroCopy())
+	proxy, _ := startServer(t, srv)
+	defer srv.Close()
+	conn := relaySession(t, proxy, echoTarget(t))
+	defer conn.Close()
+
+	payload := make([]byte, 4*spliceChunk)
+	go conn.Write(payload) // nolint: errcheck
+	_, err := io.ReadFull(conn, make([]byte, len(payload)))
+	require.NoError(t, err)
+	// the full chunks are counted once spliced, the echoed "ping" leaves a partial
+	// chunk down which is counted once completed.
+	require.Eventually(t, func() bool {
+		sessions := srv.Sessions()
+		return len(sessions) == 1 && sessions[0].BytesUp == uint64(4+len(payload)) &&
+			sessions[0].BytesDown == uint64(len(payload))
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestServer_spliceLegs(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	dial := func() (*net.TCPConn, *net.TCPConn) {
+		c, err := net.Dial("tcp", l.Addr().String())
+		require.NoError(t, err)
+		s, err := l.Accept()
+		require.NoError(t, err)
+		t.Cleanup(func() { c.Close(); s.Close() })
+		return c.(*net.TCPConn), s.(*net.TCPConn)
+	}
+	client, _ := dial()
+	target, _ := dial()
+	request := &Request{Reader: bufio.NewReader(client), conn: client}
+
+	legs := func(srv *Server, targetW io.Writer) bool {
+		_, _, ok := srv.spliceLegs(request, client, target, request.Reader, client, target, targetW)
+		return ok
+	}
+	require.Equal(t, canSplice, legs(NewServer(WithZeroCopy()), target))
+	require.False(t, legs(NewServer(), target))
+	require.False(t, legs(NewServer(WithZeroCopy(), WithIdleTimeout(time.Minute)), target))
+	require.False(t, legs(NewServer(WithZeroCopy()), &timeoutWriter{target, target, time.Second}))
+}
+
+func BenchmarkRelay(b *testing.B) {
+	for _, bc := range []struct {
+		name string
+		opts []Option
+	}{
+		{"buffered", nil},
+		{"zerocopy", []Option{WithZeroCopy()}},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			conn := relaySession(b, serveSocks(b, bc.opts...), echoTarget(b))
+			defer conn.Close()
+			payload := make([]byte, 1<<20)
+			done := make(chan error, 1)
+			go func() {
+				_, err := io.CopyN(ioutil.Discard, conn, int64(b.N)*int64(len(payload)))
+				done <- err
+			}()
+			b.SetBytes(int64(len(payload)))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := conn.Write(payload); err != nil {
+					b.Fatal(err)
+				}
+			}
+			if err := <-done; err != nil {
+				b.Fatal(err)
+			}
+		})
+	}
+}